@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/inconshreveable/log15"
+	"github.com/spf13/cobra"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/consul"
+	"github.com/stephane-martin/skewer/store"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+var backupStoreCmd = &cobra.Command{
+	Use:   "backup-store FILE",
+	Short: "Backup the Store while skewer is not running",
+	Long: `backup-store writes a snapshot of the Store to FILE, and its integrity
+metadata (size, checksum, number of queued messages) to FILE.json. The
+snapshot keeps the messages that were not delivered yet, so that they can be
+restored on another host with restore-store. When the Store is encrypted, the
+same store secret is needed to use the restored Store.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := runBackupStore(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error happened: %s\n", err)
+			os.Exit(-1)
+		}
+	},
+}
+
+var restoreStoreCmd = &cobra.Command{
+	Use:   "restore-store FILE",
+	Short: "Restore a backup of the Store while skewer is not running",
+	Long: `restore-store checks the snapshot in FILE against its metadata in
+FILE.json, and loads it into the Store. The Store must be empty.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := runRestoreStore(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error happened: %s\n", err)
+			os.Exit(-1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(backupStoreCmd, restoreStoreCmd)
+}
+
+// loadStoreConfig reads the Store configuration for the commands that open
+// the Store while skewer is not running.
+func loadStoreConfig(logger log15.Logger) (conf.StoreConfig, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	params := consul.ConnParams{
+		Address:    consulAddr,
+		Datacenter: consulDC,
+		Token:      consulToken,
+		CAFile:     consulCAFile,
+		CAPath:     consulCAPath,
+		CertFile:   consulCertFile,
+		KeyFile:    consulKeyFile,
+		Insecure:   consulInsecure,
+		Key:        consulPrefix,
+	}
+	c, _, err := conf.InitLoad(ctx, configDirName, params, nil, logger)
+	if err != nil {
+		return c.Store, eerrors.Wrap(err, "Error loading the configuration")
+	}
+	c.Store.Dirname = storeDirname
+	return c.Store, nil
+}
+
+func runBackupStore(fname string) (err error) {
+	logger := log15.New()
+	logger.SetHandler(log15.LvlFilterHandler(log15.LvlWarn, log15.StderrHandler))
+	cfg, err := loadStoreConfig(logger)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := store.Backup(cfg, f)
+	e := f.Close()
+	if err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(fname)
+		return err
+	}
+	infob, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(fname+".json", infob, 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d messages, %d bytes, sha256 %s\n", info.Messages, info.Size, info.SHA256)
+	return nil
+}
+
+func runRestoreStore(fname string) error {
+	logger := log15.New()
+	logger.SetHandler(log15.LvlFilterHandler(log15.LvlWarn, log15.StderrHandler))
+	cfg, err := loadStoreConfig(logger)
+	if err != nil {
+		return err
+	}
+	infob, err := ioutil.ReadFile(fname + ".json")
+	if err != nil {
+		return eerrors.Wrap(err, "Error reading the backup metadata")
+	}
+	var info store.BackupInfo
+	err = json.Unmarshal(infob, &info)
+	if err != nil {
+		return eerrors.Wrap(err, "Invalid backup metadata")
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = store.Verify(f, &info)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(cfg.Dirname, 0700)
+	if err != nil {
+		return err
+	}
+	err = store.Restore(cfg, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d messages restored\n", info.Messages)
+	return nil
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/inconshreveable/log15"
+	"github.com/spf13/cobra"
+	"github.com/stephane-martin/skewer/store"
+)
+
+var compactRatio float64
+
+var compactStoreCmd = &cobra.Command{
+	Use:   "compact-store",
+	Short: "Garbage collect the Store while skewer is not running",
+	Long: `compact-store deletes the messages of the Store that no destination
+references anymore, and rewrites the badger value log files to reclaim their
+disk space. skewer must not be running, as the Store can only be opened by one
+process.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log15.New()
+		logger.SetHandler(log15.LvlFilterHandler(log15.LvlInfo, log15.StderrHandler))
+		cfg, err := loadStoreConfig(logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error happened: %s\n", err)
+			os.Exit(-1)
+		}
+		ratio := cfg.GCDiscardRatio
+		if compactRatio != 0 {
+			ratio = compactRatio
+		}
+		if ratio <= 0 || ratio >= 1 {
+			fmt.Fprintln(os.Stderr, "The discard ratio must be between 0 and 1")
+			os.Exit(-1)
+		}
+
+		before, after, err := store.Compact(cfg, ratio, logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error compacting the Store: %s\n", err)
+			os.Exit(-1)
+		}
+		fmt.Printf("Store size: %d bytes before, %d bytes after\n", before, after)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(compactStoreCmd)
+	compactStoreCmd.Flags().Float64Var(&compactRatio, "ratio", 0, "rewrite the value log files with at least that ratio of garbage, instead of store.gc_discard_ratio")
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stephane-martin/skewer/store"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+var failedPort int
+var failedDest string
+var failedLimit int
+var failedJSON bool
+
+var failedCmd = &cobra.Command{
+	Use:   "failed",
+	Short: "Inspect, requeue or purge the messages that failed to be delivered",
+	Long: `failed talks to the admin API of a running skewer, served on the
+metrics port (see metrics.port in the configuration). The failed messages are
+the messages that a destination could not deliver: the messages in the failed
+queue are tried again every minute, the messages in the permerrors queue are
+kept until they are requeued or purged.`,
+}
+
+var failedListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the failed messages, with the reason of the failure",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runFailed(http.MethodGet, "", args)
+	},
+}
+
+var failedRequeueCmd = &cobra.Command{
+	Use:   "requeue [UID...]",
+	Short: "Send the failed messages to their destination again (all of them if no UID is given)",
+	Run: func(cmd *cobra.Command, args []string) {
+		runFailed(http.MethodPost, store.AdminRequeue, args)
+	},
+}
+
+var failedPurgeCmd = &cobra.Command{
+	Use:   "purge [UID...]",
+	Short: "Delete the failed messages (all of them if no UID is given)",
+	Run: func(cmd *cobra.Command, args []string) {
+		runFailed(http.MethodPost, store.AdminPurge, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(failedCmd)
+	failedCmd.AddCommand(failedListCmd, failedRequeueCmd, failedPurgeCmd)
+	failedCmd.PersistentFlags().IntVar(&failedPort, "port", 0, "metrics port of the running skewer")
+	failedCmd.PersistentFlags().StringVar(&failedDest, "dest", "", "only the messages of that destination")
+	failedListCmd.Flags().IntVar(&failedLimit, "limit", 100, "maximum number of listed messages")
+	failedListCmd.Flags().BoolVar(&failedJSON, "json", false, "print the messages as JSON")
+}
+
+func runFailed(method string, action string, uids []string) {
+	resp, err := failedRequest(method, action, uids)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error happened: %s\n", err)
+		os.Exit(-1)
+	}
+	if action != "" {
+		fmt.Printf("%d messages\n", resp.Count)
+		return
+	}
+	if failedJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(resp.Messages)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "UID\tDESTINATION\tQUEUE\tFAILED AT\tREASON\tLAST ERROR")
+	for _, m := range resp.Messages {
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			m.Uid, m.Destination, m.Queue, m.FailedAt.Format(time.RFC3339), m.Reason, m.LastError,
+		)
+	}
+	_ = w.Flush()
+}
+
+func failedRequest(method string, action string, uids []string) (resp store.AdminResponse, err error) {
+	if failedPort <= 0 {
+		return resp, eerrors.New("the metrics port of skewer must be given with --port")
+	}
+	u := fmt.Sprintf("http://127.0.0.1:%d/admin/failed", failedPort)
+	if action != "" {
+		u += "/" + action
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return resp, err
+	}
+	params := req.URL.Query()
+	if len(failedDest) > 0 {
+		params.Set("destination", failedDest)
+	}
+	if action == "" {
+		params.Set("limit", strconv.Itoa(failedLimit))
+	}
+	for _, uid := range uids {
+		params.Add("uid", uid)
+	}
+	req.URL.RawQuery = params.Encode()
+
+	return adminCall(req)
+}
+
+// adminCall sends a request to the admin API of a running skewer.
+func adminCall(req *http.Request) (resp store.AdminResponse, err error) {
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.Header.Get("Content-Type") != "application/json" {
+		body, _ := ioutil.ReadAll(io.LimitReader(httpResp.Body, 4096))
+		return resp, eerrors.Errorf("HTTP status %d: %s", httpResp.StatusCode, body)
+	}
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		return resp, err
+	}
+	if len(resp.Error) > 0 {
+		return resp, eerrors.New(resp.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/inconshreveable/log15"
+	"github.com/spf13/cobra"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/consul"
+	"github.com/stephane-martin/skewer/decoders"
+	"github.com/stephane-martin/skewer/javascript"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+var parseFormat string
+var parseCharset string
+var parseFilters []string
+var parseNoConfig bool
+
+var parseCmd = &cobra.Command{
+	Use:   "parse [FILE]",
+	Short: "Parse sample messages with a decoder and filters",
+	Long: `parse reads messages from FILE (or from stdin), one per line, runs them
+through the decoder and the filters, and prints the resulting messages as JSON.
+It helps to validate the parsers and the filters before deployment. The named
+parsers are read from the skewer configuration, unless --no-config is given.
+
+A filter may be the source of a JS FilterMessages function, @path to read the
+JS function from a file, plugin:path for a Go plugin, or wasm:path for a WASM
+module.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := runParse(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error happened: %s\n", err)
+			os.Exit(-1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(parseCmd)
+	parseCmd.Flags().StringVar(&parseFormat, "format", "rfc5424", "decoder, or chain of decoders, like rfc5424|json")
+	parseCmd.Flags().StringVar(&parseCharset, "charset", "utf8", "charset of the messages")
+	parseCmd.Flags().StringArrayVar(&parseFilters, "filter", nil, "filter applied to the parsed messages (can be repeated)")
+	parseCmd.Flags().BoolVar(&parseNoConfig, "no-config", false, "do not read the skewer configuration")
+}
+
+func runParse(args []string) error {
+	logger := log15.New()
+	logger.SetHandler(log15.LvlFilterHandler(log15.LvlWarn, log15.StderrHandler))
+
+	c := conf.NewBaseConf()
+	if !parseNoConfig {
+		params := consul.ConnParams{
+			Address:    consulAddr,
+			Datacenter: consulDC,
+			Token:      consulToken,
+			CAFile:     consulCAFile,
+			CAPath:     consulCAPath,
+			CertFile:   consulCertFile,
+			KeyFile:    consulKeyFile,
+			Insecure:   consulInsecure,
+			Key:        consulPrefix,
+		}
+		var err error
+		c, _, err = conf.InitLoad(context.Background(), configDirName, params, nil, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	var input io.Reader = os.Stdin
+	if len(args) == 1 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		input = f
+	}
+
+	filters := make([]*javascript.Environment, 0, len(parseFilters))
+	for _, filter := range parseFilters {
+		if strings.HasPrefix(filter, "@") {
+			content, err := ioutil.ReadFile(filter[1:])
+			if err != nil {
+				return err
+			}
+			filter = string(content)
+		}
+		filters = append(filters, javascript.NewFilterEnvironment(filter, "", "", "", "", "", logger))
+	}
+
+	env := decoders.NewParsersEnv(c.Parsers, logger)
+	defer env.Close()
+	decoder := conf.DecoderBaseConfig{Format: parseFormat, Charset: parseCharset}
+	gen := utils.NewGenerator()
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+
+	maxSize := c.Main.MaxInputMessageSize
+	if maxSize < bufio.MaxScanTokenSize {
+		maxSize = bufio.MaxScanTokenSize
+	}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxSize)
+	var failed bool
+	lineNumber := 0
+	output := func(msgs []*model.SyslogMessage) error {
+	Msgs:
+		for _, msg := range msgs {
+			for i, filter := range filters {
+				result, err := filter.FilterMessage(msg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "line %d: filter %d: %s\n", lineNumber, i+1, err)
+					failed = true
+					continue Msgs
+				}
+				switch result {
+				case javascript.DROPPED:
+					fmt.Fprintf(os.Stderr, "line %d: dropped by filter %d\n", lineNumber, i+1)
+					continue Msgs
+				case javascript.REJECTED:
+					fmt.Fprintf(os.Stderr, "line %d: rejected by filter %d\n", lineNumber, i+1)
+					continue Msgs
+				case javascript.FILTER_ERROR:
+					fmt.Fprintf(os.Stderr, "line %d: filter %d returned an error\n", lineNumber, i+1)
+					failed = true
+					continue Msgs
+				}
+			}
+			full := model.FullFactoryFrom(msg)
+			full.Uid = gen.Uid()
+			full.SourceType = "parse"
+			err := out.Encode(full.Regular())
+			model.FullFree(full)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		// the messages decoded before a parsing error are printed too
+		msgs, err := env.Parse(&decoder, line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", lineNumber, err)
+			failed = true
+		}
+		if err := output(msgs); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// print the incomplete auditd events
+	if err := output(env.Flush()); err != nil {
+		return err
+	}
+	if failed {
+		return eerrors.New("Some messages were not parsed or filtered successfully")
+	}
+	return nil
+}
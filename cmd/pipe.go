@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/spf13/cobra"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/consul"
+	"github.com/stephane-martin/skewer/decoders"
+	"github.com/stephane-martin/skewer/javascript"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/store/dests"
+	"github.com/stephane-martin/skewer/utils"
+	"go.uber.org/atomic"
+)
+
+var pipeFormat string
+var pipeCharset string
+var pipeDestination string
+var pipeTopicTmpl string
+var pipePartitionTmpl string
+var pipeMaxLineSize int
+var pipeAckTimeout time.Duration
+
+// pipeCmd represents the pipe command
+var pipeCmd = &cobra.Command{
+	Use:   "pipe",
+	Short: "Forward line-delimited messages read from stdin",
+	Long: `pipe reads messages from stdin, one message per line, parses them with
+the given decoder and forwards them to the configured destinations. The Store
+is not used: skewer pipe returns when stdin is closed and all the messages
+have been acknowledged by the destinations, or when --ack-timeout has elapsed
+after stdin was closed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log15.New()
+		logger.SetHandler(log15.LvlFilterHandler(log15.LvlWarn, log15.StderrHandler))
+
+		params := consul.ConnParams{
+			Address:    consulAddr,
+			Datacenter: consulDC,
+			Token:      consulToken,
+			CAFile:     consulCAFile,
+			CAPath:     consulCAPath,
+			CertFile:   consulCertFile,
+			KeyFile:    consulKeyFile,
+			Insecure:   consulInsecure,
+			Key:        consulPrefix,
+		}
+
+		c, _, err := conf.InitLoad(context.Background(), configDirName, params, nil, logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %s\n", err)
+			os.Exit(-1)
+		}
+		if len(pipeDestination) > 0 {
+			c.Main.Destination = strings.Split(pipeDestination, ",")
+		}
+		// the flags override the [stdin] section of the configuration
+		decoderConf := c.Stdin.DecoderBaseConfig
+		if cmd.Flags().Changed("format") || len(decoderConf.Format) == 0 {
+			decoderConf.Format = pipeFormat
+		}
+		if cmd.Flags().Changed("charset") || len(decoderConf.Charset) == 0 {
+			decoderConf.Charset = pipeCharset
+		}
+		if !cmd.Flags().Changed("max-line-size") && c.Stdin.MaxLineSize > 0 {
+			pipeMaxLineSize = c.Stdin.MaxLineSize
+		}
+		nErrors, err := pipe(c, decoderConf, logger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		if nErrors > 0 {
+			fmt.Fprintf(os.Stderr, "%d message(s) could not be forwarded\n", nErrors)
+			os.Exit(1)
+		}
+	},
+}
+
+func pipe(c conf.BaseConfig, decoderConf conf.DecoderBaseConfig, logger log15.Logger) (uint64, error) {
+	destTypes, err := c.Main.GetDestinations()
+	if err != nil {
+		return 0, err
+	}
+	dests.InitRegistry()
+
+	var pending sync.WaitGroup
+	var nPending atomic.Int64
+	var nErrors atomic.Uint64
+	ack := func(uid utils.MyULID, d conf.DestinationType) {
+		nPending.Dec()
+		pending.Done()
+	}
+	nack := func(uid utils.MyULID, d conf.DestinationType) {
+		nErrors.Inc()
+		nPending.Dec()
+		pending.Done()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	env := dests.BuildEnv().
+		Callbacks(ack, nack, nack).
+		Config(c).
+		Logger(logger)
+
+	destinations := make([]dests.Destination, 0)
+	closeDestinations := func() {
+		for _, dest := range destinations {
+			_ = dest.Close()
+		}
+		destinations = nil
+	}
+	defer closeDestinations()
+
+	for _, typ := range destTypes.Iterate() {
+		dest, err := dests.NewDestination(ctx, typ, env)
+		if err != nil {
+			return 0, fmt.Errorf("Error setting up destination '%s': %s", conf.DestinationNames[typ], err)
+		}
+		destinations = append(destinations, dest)
+		go func(dest dests.Destination) {
+			err := <-dest.Fatal()
+			if err != nil {
+				logger.Error("Destination fatal error", "error", err)
+				cancel()
+			}
+		}(dest)
+	}
+
+	parsers := decoders.NewParsersEnv(c.Parsers, logger)
+	jsenv := javascript.NewFilterEnvironment("", "", pipeTopicTmpl, "", pipePartitionTmpl, "", logger)
+	gen := utils.NewGenerator()
+	output := make([]model.OutputMsg, 1)
+
+	// send parses each message once, and gives a copy of it to every
+	// destination, as the destinations take ownership of the messages
+	send := func(syslogMsgs []*model.SyslogMessage) {
+		for _, syslogMsg := range syslogMsgs {
+			if syslogMsg == nil {
+				continue
+			}
+			topic, _ := jsenv.Topic(syslogMsg)
+			if len(topic) == 0 {
+				topic = "default-topic"
+			}
+			partitionKey, _ := jsenv.PartitionKey(syslogMsg)
+			partitionNumber, _ := jsenv.PartitionNumber(syslogMsg)
+			for i, dest := range destinations {
+				msg := syslogMsg
+				if i < len(destinations)-1 {
+					msg = syslogMsg.Copy()
+				}
+				full := model.FullFactoryFrom(msg)
+				full.Uid = gen.Uid()
+				full.SourceType = "pipe"
+				output[0] = model.OutputMsg{
+					Message:         full,
+					Topic:           topic,
+					PartitionKey:    partitionKey,
+					PartitionNumber: partitionNumber,
+				}
+				nPending.Inc()
+				pending.Add(1)
+				// errors are reported through the NACK callback
+				_ = dest.Send(ctx, output)
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 65536), pipeMaxLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		// the messages decoded before a parsing error are sent too
+		syslogMsgs, err := parsers.Parse(&decoderConf, line)
+		if err != nil {
+			logger.Warn("Error parsing message", "error", err)
+			nErrors.Inc()
+		}
+		send(syslogMsgs)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nErrors.Load(), fmt.Errorf("Error reading stdin: %s", err)
+	}
+	if ctx.Err() != nil {
+		return nErrors.Load(), nil
+	}
+	// send the incomplete auditd events
+	send(parsers.Flush())
+
+	// the destinations flush their buffers when they are closed. Some of
+	// them, like the Parquet files, only acknowledge the messages then.
+	closeDestinations()
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(pipeAckTimeout):
+		return nErrors.Load(), fmt.Errorf("%d message(s) have not been acknowledged after %s", nPending.Load(), pipeAckTimeout)
+	}
+	return nErrors.Load(), nil
+}
+
+func init() {
+	RootCmd.AddCommand(pipeCmd)
+	pipeCmd.Flags().StringVar(&pipeFormat, "format", "rfc5424", "decoder used to parse the lines (overrides the [stdin] section)")
+	pipeCmd.Flags().StringVar(&pipeCharset, "charset", "utf8", "charset of the input (overrides the [stdin] section)")
+	pipeCmd.Flags().StringVar(&pipeDestination, "dest", "", "destinations to forward messages to (default: configured destinations)")
+	pipeCmd.Flags().StringVar(&pipeTopicTmpl, "topic-tmpl", "topic-{{.AppName}}", "template used to compute the topic")
+	pipeCmd.Flags().StringVar(&pipePartitionTmpl, "partition-tmpl", "partition-{{.HostName}}", "template used to compute the partition key")
+	pipeCmd.Flags().IntVar(&pipeMaxLineSize, "max-line-size", 1024*1024, "maximum size of an input line")
+	pipeCmd.Flags().DurationVar(&pipeAckTimeout, "ack-timeout", 30*time.Second, "how long to wait for the acknowledgements after stdin is closed")
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/spf13/cobra"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/store"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// the replayed messages are written to the Store by batches of replayBatchSize
+const replayBatchSize = 1000
+
+var replayFrom string
+var replayTo string
+var replayDests []string
+
+var replayCmd = &cobra.Command{
+	Use:   "replay [FILE...]",
+	Short: "Re-inject archived messages into the Store for some destinations",
+	Long: `replay reads archived messages from FILEs (or from stdin), one JSON
+message per line, as written by the file destination with the fulljson format,
+or by the store dead letter file. The messages received in the [--from, --to]
+time range are written back into the Store, for the destinations given by
+--dest only. They are delivered when skewer starts again. The Store does not
+keep the messages after they have been delivered, so an archive is needed.
+
+skewer must not be running, as the Store can only be opened by one process.
+The messages that are still in the Store are not replayed twice.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		nb, err := runReplay(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error happened: %s\n", err)
+			os.Exit(-1)
+		}
+		fmt.Printf("%d messages replayed\n", nb)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().StringVar(&replayFrom, "from", "", "only the messages received after that time (RFC3339)")
+	replayCmd.Flags().StringVar(&replayTo, "to", "", "only the messages received before that time (RFC3339)")
+	replayCmd.Flags().StringArrayVar(&replayDests, "dest", nil, "destination of the replayed messages (can be repeated)")
+}
+
+func parseReplayTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, eerrors.Wrapf(err, "Invalid time: '%s'", s)
+	}
+	return t, nil
+}
+
+func runReplay(files []string) (int, error) {
+	from, err := parseReplayTime(replayFrom)
+	if err != nil {
+		return 0, err
+	}
+	to, err := parseReplayTime(replayTo)
+	if err != nil {
+		return 0, err
+	}
+	var dests conf.DestinationType
+	for _, elt := range replayDests {
+		for _, name := range strings.Split(elt, ",") {
+			name = strings.TrimSpace(strings.ToLower(name))
+			d, ok := conf.Destinations[name]
+			if !ok {
+				return 0, eerrors.Errorf("Unknown destination: '%s'", name)
+			}
+			dests |= d
+		}
+	}
+	if dests == 0 {
+		return 0, eerrors.New("At least one destination must be given with --dest")
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.LvlFilterHandler(log15.LvlWarn, log15.StderrHandler))
+	cfg, err := loadStoreConfig(logger)
+	if err != nil {
+		return 0, err
+	}
+	secret, err := cfg.GetPlainSecretB()
+	if err != nil {
+		return 0, eerrors.Wrap(err, "Error reading the Store secret")
+	}
+
+	replayer, err := store.NewReplayer(cfg, secret, dests)
+	if err != nil {
+		return 0, err
+	}
+	r := &replayReader{replayer: replayer, from: from, to: to}
+
+	if len(files) == 0 {
+		err = r.read(os.Stdin)
+	}
+	for _, fname := range files {
+		err = r.readFile(fname)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = r.flush()
+	}
+	e := replayer.Close()
+	if err == nil {
+		err = e
+	}
+	return r.nb, err
+}
+
+type replayReader struct {
+	replayer *store.Replayer
+	from     time.Time
+	to       time.Time
+	batch    []*model.FullMessage
+	nb       int
+}
+
+func (r *replayReader) readFile(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return eerrors.Wrapf(r.read(f), "Error reading '%s'", fname)
+}
+
+func (r *replayReader) read(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 65536), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var regular model.RegularFullMessage
+		err := json.Unmarshal(line, &regular)
+		if err != nil {
+			return err
+		}
+		m, err := regular.Internal()
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+		received := time.Unix(0, m.Fields.TimeGeneratedNum)
+		if m.Fields.TimeGeneratedNum == 0 {
+			received = m.Uid.Time()
+		}
+		if (!r.from.IsZero() && received.Before(r.from)) || (!r.to.IsZero() && received.After(r.to)) {
+			model.FullFree(m)
+			continue
+		}
+		r.batch = append(r.batch, m)
+		if len(r.batch) >= replayBatchSize {
+			err = r.flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (r *replayReader) flush() error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+	nb, err := r.replayer.Replay(r.batch)
+	for _, m := range r.batch {
+		model.FullFree(m)
+	}
+	r.batch = r.batch[:0]
+	r.nb += nb
+	return err
+}
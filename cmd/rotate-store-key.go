@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+var rotatePort int
+
+var rotateStoreKeyCmd = &cobra.Command{
+	Use:   "rotate-store-key",
+	Short: "Encrypt the messages in the Store again with the current store secret",
+	Long: `rotate-store-key asks a running skewer to encrypt the messages in the
+Store again with the current store secret, in the background.
+
+To rotate the store secret: move the current secret to store.old_secrets, set
+a new store.secret, and restart skewer. The new messages are encrypted with the
+new secret, and the old messages can still be read with the old secrets. Then
+run rotate-store-key. When skewer logs that the key rotation is done, the old
+secrets can be removed from the configuration.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		nb, err := runRotateStoreKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error happened: %s\n", err)
+			os.Exit(-1)
+		}
+		fmt.Printf("Key rotation started, %d messages to check\n", nb)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(rotateStoreKeyCmd)
+	rotateStoreKeyCmd.Flags().IntVar(&rotatePort, "port", 0, "metrics port of the running skewer")
+}
+
+func runRotateStoreKey() (int, error) {
+	if rotatePort <= 0 {
+		return 0, eerrors.New("the metrics port of skewer must be given with --port")
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/admin/rotate-key", rotatePort), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := adminCall(req)
+	return resp.Count, err
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -289,6 +290,7 @@ func (ch *serveChild) setupStore() (st *services.StoreController, err error) {
 		services.DumpableOpt(DumpableFlag),
 		services.StorePathOpt(storeDirname),
 		services.FileDestTmplOpt(tmpl),
+		services.DeadLetterFileOpt(ch.conf.Store.DeadLetterFile),
 		services.CertFilesOpt(certfiles),
 		services.CertPathsOpt(certpaths),
 		services.ProfileOpt(profile),
@@ -371,6 +373,16 @@ func (ch *serveChild) StartController(typ base.Types) error {
 		return ch.StartAccounting()
 	case base.MacOS:
 		return ch.StartMacOS()
+	case base.Kubernetes:
+		return ch.StartKubernetes()
+	case base.Exec:
+		return ch.StartExec()
+	case base.PubSub:
+		return ch.StartPubSub()
+	case base.JournalGateway:
+		return ch.StartJournalGateway()
+	case base.Stdin:
+		return ch.StartStdin()
 	case base.KafkaSource:
 		return ch.StartKafkaSource()
 	case base.Filesystem:
@@ -502,6 +514,141 @@ func (ch *serveChild) StartMacOS() error {
 	return nil
 }
 
+// StartKubernetes starts the Kubernetes pod logs process.
+func (ch *serveChild) StartKubernetes() error {
+	if ch.conf.Kubernetes.Enabled {
+		ch.logger.Info("kubernetes logs source is enabled")
+		certfiles := make([]string, 0, 1)
+		if len(ch.conf.Kubernetes.CAFile) > 0 {
+			certfiles = append(certfiles, ch.conf.Kubernetes.CAFile)
+		}
+		// the token is rotated by the kubelet: mount its directory so that
+		// the new token is visible to the confined process
+		certpaths := make([]string, 0, 1)
+		if len(ch.conf.Kubernetes.TokenFile) > 0 {
+			certpaths = append(certpaths, filepath.Dir(ch.conf.Kubernetes.TokenFile))
+		}
+		err := ch.controllers[base.Kubernetes].Create(
+			services.DumpableOpt(DumpableFlag),
+			services.CertFilesOpt(certfiles),
+			services.CertPathsOpt(certpaths),
+		)
+		if err != nil {
+			return eerrors.Wrap(err, "Error creating kubernetes controller")
+		}
+		ch.controllers[base.Kubernetes].SetConf(*ch.conf)
+		_, err = ch.controllers[base.Kubernetes].Start()
+		if err != nil {
+			return eerrors.Wrap(err, "Error starting kubernetes controller")
+		}
+		ch.logger.Debug("kubernetes plugin has been started")
+	}
+	return nil
+}
+
+// StartExec starts the process that runs the configured commands.
+func (ch *serveChild) StartExec() error {
+	if len(ch.conf.ExecSource) > 0 {
+		ch.logger.Info("exec sources are enabled")
+		err := ch.controllers[base.Exec].Create(
+			services.DumpableOpt(DumpableFlag),
+		)
+		if err != nil {
+			return eerrors.Wrap(err, "Error creating exec controller")
+		}
+		ch.controllers[base.Exec].SetConf(*ch.conf)
+		_, err = ch.controllers[base.Exec].Start()
+		if err != nil {
+			return eerrors.Wrap(err, "Error starting exec controller")
+		}
+		ch.logger.Debug("exec plugin has been started")
+	}
+	return nil
+}
+
+// StartPubSub starts the process that pulls messages from Pub/Sub subscriptions.
+func (ch *serveChild) StartPubSub() error {
+	if len(ch.conf.PubSubSource) > 0 {
+		ch.logger.Info("pubsub sources are enabled")
+		certfiles := make([]string, 0, len(ch.conf.PubSubSource))
+		for _, c := range ch.conf.PubSubSource {
+			if len(c.CredentialsFile) > 0 {
+				certfiles = append(certfiles, c.CredentialsFile)
+			}
+		}
+		err := ch.controllers[base.PubSub].Create(
+			services.DumpableOpt(DumpableFlag),
+			services.CertFilesOpt(certfiles),
+		)
+		if err != nil {
+			return eerrors.Wrap(err, "Error creating pubsub controller")
+		}
+		ch.controllers[base.PubSub].SetConf(*ch.conf)
+		_, err = ch.controllers[base.PubSub].Start()
+		if err != nil {
+			return eerrors.Wrap(err, "Error starting pubsub controller")
+		}
+		ch.logger.Debug("pubsub plugin has been started")
+	}
+	return nil
+}
+
+// StartStdin starts the process that reads the messages from stdin.
+func (ch *serveChild) StartStdin() error {
+	if ch.conf.Stdin.Enabled {
+		ch.logger.Info("stdin source is enabled")
+		err := ch.controllers[base.Stdin].Create(
+			services.DumpableOpt(DumpableFlag),
+		)
+		if err != nil {
+			return eerrors.Wrap(err, "Error creating stdin controller")
+		}
+		ch.controllers[base.Stdin].SetConf(*ch.conf)
+		_, err = ch.controllers[base.Stdin].Start()
+		if err != nil {
+			return eerrors.Wrap(err, "Error starting stdin controller")
+		}
+		ch.logger.Debug("stdin plugin has been started")
+	}
+	return nil
+}
+
+// StartJournalGateway starts the process that streams the remote journals.
+func (ch *serveChild) StartJournalGateway() error {
+	if len(ch.conf.JournalGatewaySource) > 0 {
+		ch.logger.Info("journal gateway sources are enabled")
+		certfiles := make([]string, 0)
+		for _, c := range ch.conf.JournalGatewaySource {
+			for _, f := range []string{c.CAFile, c.CertFile, c.KeyFile} {
+				if len(f) > 0 {
+					certfiles = append(certfiles, f)
+				}
+			}
+		}
+		certpaths := make([]string, 0)
+		for _, c := range ch.conf.JournalGatewaySource {
+			if len(c.CAPath) > 0 {
+				certpaths = append(certpaths, c.CAPath)
+			}
+		}
+		err := ch.controllers[base.JournalGateway].Create(
+			services.DumpableOpt(DumpableFlag),
+			services.CertFilesOpt(certfiles),
+			services.CertPathsOpt(certpaths),
+		)
+		if err != nil {
+			return eerrors.Wrap(err, "Error creating journal gateway controller")
+		}
+		ch.controllers[base.JournalGateway].SetConf(*ch.conf)
+		_, err = ch.controllers[base.JournalGateway].Start()
+		if err != nil {
+			return eerrors.Wrap(err, "Error starting journal gateway controller")
+		}
+		ch.logger.Debug("journal gateway plugin has been started")
+	}
+	return nil
+}
+
 // StartJournal starts the journald process.
 func (ch *serveChild) StartJournal() error {
 	if journald.Supported {
@@ -748,6 +895,12 @@ func (ch *serveChild) setupMetrics(logger log15.Logger) {
 			controllers = append(controllers, ch.controllers[typ])
 		}
 	}
+	if ch.store != nil {
+		// the admin API for the failed messages
+		ch.metricsServer.Handle("/admin/failed", ch.store.AdminHandler())
+		ch.metricsServer.Handle("/admin/failed/", ch.store.AdminHandler())
+		ch.metricsServer.Handle("/admin/rotate-key", ch.store.RotateKeyHandler())
+	}
 	ch.metricsServer.NewConf(ch.conf.Metrics, logger, controllers...)
 }
 
@@ -11,13 +11,16 @@ import (
 	"hash/fnv"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/Shopify/sarama"
-	"github.com/bsm/sarama-cluster"
 	"github.com/fatih/set"
 	"github.com/nats-io/go-nats"
 	metrics "github.com/rcrowley/go-metrics"
@@ -30,6 +33,9 @@ import (
 	"github.com/stephane-martin/skewer/sys/kring"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/templates"
+	"github.com/xdg-go/scram"
+	"github.com/yuin/gopher-lua/parse"
 )
 
 var Version string
@@ -49,6 +55,7 @@ func NewBaseConf() BaseConfig {
 		KafkaSource:      []KafkaSourceConfig{},
 		Store:            StoreConfig{},
 		Parsers:          []ParserConfig{},
+		Routes:           []RouteConfig{},
 		Journald:         JournaldConfig{},
 		Metrics:          MetricsConfig{},
 
@@ -197,6 +204,46 @@ func (l KafkaVersion) Greater(r KafkaVersion) bool {
 	return false
 }
 
+// Sources returns the configurations of all the sources.
+func (c *BaseConfig) Sources() []Source {
+	sources := make([]Source, 0)
+	for i := range c.FSSource {
+		sources = append(sources, &c.FSSource[i])
+	}
+	for i := range c.TCPSource {
+		sources = append(sources, &c.TCPSource[i])
+	}
+	for i := range c.UDPSource {
+		sources = append(sources, &c.UDPSource[i])
+	}
+	for i := range c.RELPSource {
+		sources = append(sources, &c.RELPSource[i])
+	}
+	for i := range c.DirectRELPSource {
+		sources = append(sources, &c.DirectRELPSource[i])
+	}
+	for i := range c.GraylogSource {
+		sources = append(sources, &c.GraylogSource[i])
+	}
+	for i := range c.KafkaSource {
+		sources = append(sources, &c.KafkaSource[i])
+	}
+	for i := range c.HTTPServerSource {
+		sources = append(sources, &c.HTTPServerSource[i])
+	}
+	for i := range c.ExecSource {
+		sources = append(sources, &c.ExecSource[i])
+	}
+	for i := range c.PubSubSource {
+		sources = append(sources, &c.PubSubSource[i])
+	}
+	for i := range c.JournalGatewaySource {
+		sources = append(sources, &c.JournalGatewaySource[i])
+	}
+	sources = append(sources, &c.Journald, &c.Accounting, &c.MacOS, &c.Kubernetes, &c.Stdin)
+	return sources
+}
+
 func (c *FilterSubConfig) CalculateID() utils.MyULID {
 	return utils.MyULID(string(fnv.New128a().Sum([]byte(c.Export()))))
 }
@@ -237,6 +284,26 @@ func (c *MacOSSourceConfig) SetConfID() {
 	c.ConfID = c.FilterSubConfig.CalculateID()
 }
 
+func (c *ExecSourceConfig) SetConfID() {
+	c.ConfID = c.FilterSubConfig.CalculateID()
+}
+
+func (c *PubSubSourceConfig) SetConfID() {
+	c.ConfID = c.FilterSubConfig.CalculateID()
+}
+
+func (c *JournalGatewaySourceConfig) SetConfID() {
+	c.ConfID = c.FilterSubConfig.CalculateID()
+}
+
+func (c *KubernetesSourceConfig) SetConfID() {
+	c.ConfID = c.FilterSubConfig.CalculateID()
+}
+
+func (c *StdinSourceConfig) SetConfID() {
+	c.ConfID = c.FilterSubConfig.CalculateID()
+}
+
 func (c *KafkaSourceConfig) SetConfID() {
 	c.ConfID = c.FilterSubConfig.CalculateID()
 }
@@ -306,9 +373,18 @@ func (c *BaseConfig) GetCertificateFiles() (res map[string][]string) {
 	res = make(map[string][]string)
 	s := set.New(set.ThreadSafe)
 	s.Add(c.KafkaDest.CAFile, c.KafkaDest.CertFile, c.KafkaDest.KeyFile)
+	s.Add(c.KafkaDest.kerberosFiles()...)
 	s.Add(c.RELPDest.CAFile, c.RELPDest.CertFile, c.RELPDest.KeyFile)
 	s.Add(c.TCPDest.CAFile, c.TCPDest.CertFile, c.TCPDest.KeyFile)
 	s.Add(c.HTTPServerDest.CAFile, c.HTTPServerDest.CertFile, c.HTTPServerDest.KeyFile)
+	s.Add(c.MongoDBDest.CAFile, c.MongoDBDest.CertFile, c.MongoDBDest.KeyFile)
+	s.Add(c.NSQDest.CAFile, c.NSQDest.CertFile, c.NSQDest.KeyFile)
+	s.Add(c.PulsarDest.CAFile, c.PulsarDest.CertFile, c.PulsarDest.KeyFile)
+	s.Add(c.WebsocketClientDest.CAFile, c.WebsocketClientDest.CertFile, c.WebsocketClientDest.KeyFile)
+	s.Add(c.SyslogTLSDest.CAFile, c.SyslogTLSDest.CertFile, c.SyslogTLSDest.KeyFile)
+	s.Add(c.PromRemoteWriteDest.CAFile, c.PromRemoteWriteDest.CertFile, c.PromRemoteWriteDest.KeyFile)
+	s.Add(c.AlertDest.CAFile, c.AlertDest.CertFile, c.AlertDest.KeyFile)
+	s.Add(c.GraylogDest.CAFile, c.GraylogDest.CertFile, c.GraylogDest.KeyFile)
 	res["dests"] = cleanList(s)
 
 	s = set.New(set.ThreadSafe)
@@ -332,6 +408,7 @@ func (c *BaseConfig) GetCertificateFiles() (res map[string][]string) {
 	s = set.New(set.ThreadSafe)
 	for _, src := range c.KafkaSource {
 		s.Add(src.CAFile, src.CertFile, src.KeyFile)
+		s.Add(src.kerberosFiles()...)
 	}
 	res["kafkasource"] = cleanList(s)
 
@@ -350,6 +427,14 @@ func (c *BaseConfig) GetCertificatePaths() (res map[string][]string) {
 	s.Add(c.KafkaDest.CAPath)
 	s.Add(c.RELPDest.CAPath)
 	s.Add(c.TCPDest.CAPath)
+	s.Add(c.MongoDBDest.CAPath)
+	s.Add(c.NSQDest.CAPath)
+	s.Add(c.PulsarDest.CAPath)
+	s.Add(c.WebsocketClientDest.CAPath)
+	s.Add(c.SyslogTLSDest.CAPath)
+	s.Add(c.PromRemoteWriteDest.CAPath)
+	s.Add(c.AlertDest.CAPath)
+	s.Add(c.GraylogDest.CAPath)
 	res["dests"] = cleanList(s)
 
 	s = set.New(set.ThreadSafe)
@@ -402,6 +487,45 @@ func (c *ListenersConfig) GetListenAddrs() (addrs map[int]string, err error) {
 	return
 }
 
+// ParseCIDRs parses the allowed and denied client ranges. A plain IP address
+// is accepted as a single host range.
+func (c *ListenersConfig) ParseCIDRs() (allowed []*net.IPNet, denied []*net.IPNet, err error) {
+	allowed, err = parseCIDRs(c.AllowedCIDRs)
+	if err != nil {
+		return nil, nil, err
+	}
+	denied, err = parseCIDRs(c.DeniedCIDRs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return allowed, denied, nil
+}
+
+func parseCIDRs(ranges []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ranges))
+	for _, r := range ranges {
+		r = strings.TrimSpace(r)
+		if len(r) == 0 {
+			continue
+		}
+		if !strings.Contains(r, "/") {
+			if ip := net.ParseIP(r); ip != nil {
+				if ip.To4() != nil {
+					r += "/32"
+				} else {
+					r += "/128"
+				}
+			}
+		}
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range: %s", r)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
 func (c *TCPSourceConfig) Export() string {
 	b, _ := json.Marshal(c)
 	return string(b)
@@ -441,8 +565,74 @@ func ImportSyslogConfig(data []byte) (*FilterSubConfig, error) {
 	return &c, nil
 }
 
-func (c *KafkaSourceConfig) GetSaramaConsumerConfig(confined bool) (*cluster.Config, error) {
-	s := cluster.NewConfig()
+// configureSASL sets up the SASL authentication in the sarama configuration.
+// The session secret is needed to decrypt the SASL password.
+func (c *KafkaBaseConfig) configureSASL(s *sarama.Config, confined bool, r kring.Ring) error {
+	if len(c.SASLMechanism) == 0 {
+		return nil
+	}
+	s.Net.SASL.Enable = true
+	s.Net.SASL.Handshake = true
+	if c.SASLMechanism == SASLGSSAPI {
+		c.configureKerberos(s, confined)
+		return nil
+	}
+	s.Net.SASL.User = c.SASLUsername
+	switch c.SASLMechanism {
+	case SASLScramSHA256:
+		s.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		s.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(scram.SHA256)
+	case SASLScramSHA512:
+		s.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		s.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(scram.SHA512)
+	default:
+		s.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	}
+	if len(c.SASLPassword) == 0 {
+		// the password is dropped in Complete() when no session secret is given
+		return eerrors.New("SASL is enabled but the Kafka SASL password is not available")
+	}
+	if r == nil {
+		return eerrors.New("The session secret is needed to decrypt the Kafka SASL password")
+	}
+	m, err := r.GetBoxSecret()
+	if err != nil {
+		return eerrors.Wrap(err, "Failed to retrieve the current session encryption secret")
+	}
+	defer m.Destroy()
+	locked, err := c.DecryptSASLPassword(m)
+	if err != nil {
+		return eerrors.Wrap(err, "Failed to decrypt the Kafka SASL password")
+	}
+	defer locked.Destroy()
+	// sarama only accepts the password as a string
+	s.Net.SASL.Password = string(locked.Buffer())
+	return nil
+}
+
+// configureKerberos sets up the GSSAPI authentication with the keytab. sarama
+// logs in again with the keytab for each new broker connection, so the
+// tickets never need to be renewed.
+func (c *KafkaBaseConfig) configureKerberos(s *sarama.Config, confined bool) {
+	keytab := c.SASLKerberosKeytab
+	krb5Conf := c.SASLKerberosConfig
+	if confined {
+		keytab = filepath.Join("/tmp", "certfiles", keytab)
+		krb5Conf = filepath.Join("/tmp", "certfiles", krb5Conf)
+	}
+	s.Net.SASL.Mechanism = sarama.SASLTypeGSSAPI
+	s.Net.SASL.GSSAPI = sarama.GSSAPIConfig{
+		AuthType:           sarama.KRB5_KEYTAB_AUTH,
+		KeyTabPath:         keytab,
+		KerberosConfigPath: krb5Conf,
+		ServiceName:        c.SASLKerberosServiceName,
+		Username:           c.SASLKerberosPrincipal,
+		Realm:              c.SASLKerberosRealm,
+	}
+}
+
+func (c *KafkaSourceConfig) GetSaramaConsumerConfig(confined bool, r kring.Ring) (*sarama.Config, error) {
+	s := sarama.NewConfig()
 	s.ClientID = c.ClientID
 	s.ChannelBufferSize = c.ChannelBufferSize
 
@@ -462,7 +652,7 @@ func (c *KafkaSourceConfig) GetSaramaConsumerConfig(confined bool) (*cluster.Con
 	s.Consumer.Fetch.Min = c.MinFetchBytes
 	s.Consumer.MaxWaitTime = c.MaxWaitTime
 	s.Consumer.MaxProcessingTime = c.MaxProcessingTime
-	s.Consumer.Offsets.CommitInterval = c.OffsetsCommitInterval
+	s.Consumer.Offsets.AutoCommit.Interval = c.OffsetsCommitInterval
 	s.Consumer.Offsets.Initial = c.OffsetsInitial
 	s.Consumer.Offsets.Retention = c.OffsetsRetention
 	s.Consumer.Return.Errors = true
@@ -481,15 +671,19 @@ func (c *KafkaSourceConfig) GetSaramaConsumerConfig(confined bool) (*cluster.Con
 
 	}
 
-	s.Group.Offsets.Retry.Max = c.OffsetsMaxRetry
-	s.Group.Session.Timeout = c.SessionTimeout
-	s.Group.Heartbeat.Interval = c.HeartbeatInterval
-	s.Group.Return.Notifications = false
+	err := c.configureSASL(s, confined, r)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Consumer.Offsets.Retry.Max = c.OffsetsMaxRetry
+	s.Consumer.Group.Session.Timeout = c.SessionTimeout
+	s.Consumer.Group.Heartbeat.Interval = c.HeartbeatInterval
 
 	return s, nil
 }
 
-func (c *KafkaDestConfig) GetSaramaProducerConfig(confined bool) (*sarama.Config, error) {
+func (c *KafkaDestConfig) GetSaramaProducerConfig(confined bool, r kring.Ring) (*sarama.Config, error) {
 	s := sarama.NewConfig()
 	s.ClientID = c.ClientID
 	s.ChannelBufferSize = c.ChannelBufferSize
@@ -517,6 +711,15 @@ func (c *KafkaDestConfig) GetSaramaProducerConfig(confined bool) (*sarama.Config
 	s.Producer.Retry.Backoff = c.RetrySendBackoff
 	s.Producer.Retry.Max = c.RetrySendMax
 
+	if c.ExactlyOnce {
+		s.Producer.Idempotent = true
+		s.Producer.Transaction.ID = c.TransactionalID
+		s.Producer.Transaction.Timeout = c.TransactionTimeout
+		// the idempotent producer keeps the messages in order with a
+		// single request in flight
+		s.Net.MaxOpenRequests = 1
+	}
+
 	v, _ := ParseVersion(c.Version) // the ignored error has been checked at launch
 	s.Version = v
 
@@ -542,6 +745,11 @@ func (c *KafkaDestConfig) GetSaramaProducerConfig(confined bool) (*sarama.Config
 
 	}
 
+	err := c.configureSASL(s, confined, r)
+	if err != nil {
+		return nil, err
+	}
+
 	switch c.Partitioner {
 	case "manual":
 		s.Producer.Partitioner = sarama.NewManualPartitioner
@@ -555,8 +763,8 @@ func (c *KafkaDestConfig) GetSaramaProducerConfig(confined bool) (*sarama.Config
 	return s, nil
 }
 
-func (c *KafkaDestConfig) GetAsyncProducer(confined bool) (sarama.AsyncProducer, metrics.Registry, error) {
-	conf, err := c.GetSaramaProducerConfig(confined)
+func (c *KafkaDestConfig) GetAsyncProducer(confined bool, r kring.Ring) (sarama.AsyncProducer, metrics.Registry, error) {
+	conf, err := c.GetSaramaProducerConfig(confined, r)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -567,8 +775,8 @@ func (c *KafkaDestConfig) GetAsyncProducer(confined bool) (sarama.AsyncProducer,
 	return p, conf.MetricRegistry, nil
 }
 
-func (c *KafkaDestConfig) GetClient(confined bool) (sarama.Client, error) {
-	conf, err := c.GetSaramaProducerConfig(confined)
+func (c *KafkaDestConfig) GetClient(confined bool, r kring.Ring) (sarama.Client, error) {
+	conf, err := c.GetSaramaProducerConfig(confined, r)
 	if err != nil {
 		return nil, err
 	}
@@ -579,16 +787,16 @@ func (c *KafkaDestConfig) GetClient(confined bool) (sarama.Client, error) {
 	return cl, nil
 }
 
-func (c *KafkaSourceConfig) GetClient(confined bool) (*cluster.Consumer, metrics.Registry, error) {
-	conf, err := c.GetSaramaConsumerConfig(confined)
+func (c *KafkaSourceConfig) GetClient(confined bool, r kring.Ring) (sarama.ConsumerGroup, metrics.Registry, error) {
+	conf, err := c.GetSaramaConsumerConfig(confined, r)
 	if err != nil {
 		return nil, nil, err
 	}
-	cl, err := cluster.NewConsumer(c.Brokers, c.GroupID, c.Topics, conf)
+	cl, err := sarama.NewConsumerGroup(c.Brokers, c.GroupID, conf)
 	if err != nil {
 		return nil, nil, err
 	}
-	return cl, conf.Config.MetricRegistry, nil
+	return cl, conf.MetricRegistry, nil
 }
 
 func getViper(confDir string) (v *viper.Viper, err error) {
@@ -774,9 +982,52 @@ func (c *BaseConfig) Complete(r kring.Ring) (err error) {
 			return confCheckError(eerrors.New("The same parser name is used multiple times"))
 		}
 		f := strings.TrimSpace(parserConf.Func)
-		if len(f) == 0 {
+		defined := 0
+		for _, ok := range []bool{len(f) > 0, len(parserConf.Regexps) > 0, len(parserConf.Command) > 0, len(parserConf.Plugin) > 0, len(parserConf.Wasm) > 0} {
+			if ok {
+				defined++
+			}
+		}
+		if defined == 0 {
 			return confCheckError(eerrors.New("Empty parser func"))
 		}
+		if defined > 1 {
+			return confCheckError(eerrors.Errorf("Parser '%s' must define only one of func, regexps, command, plugin or wasm", name))
+		}
+		switch parserConf.Language {
+		case "", "javascript":
+		case "lua":
+			_, err := parse.Parse(strings.NewReader(f), name)
+			if err != nil {
+				return confCheckError(eerrors.Wrapf(err, "Invalid Lua script in parser '%s'", name))
+			}
+		default:
+			return confCheckError(eerrors.Errorf("Unknown language for parser '%s': '%s'", name, parserConf.Language))
+		}
+		for _, expr := range parserConf.Regexps {
+			_, err := regexp.Compile(expr)
+			if err != nil {
+				return confCheckError(eerrors.Wrapf(err, "Invalid regexp in parser '%s'", name))
+			}
+		}
+		if len(parserConf.Command) > 0 {
+			_, err := exec.LookPath(parserConf.Command[0])
+			if err != nil {
+				return confCheckError(eerrors.Wrapf(err, "Invalid command in parser '%s'", name))
+			}
+		}
+		if len(parserConf.Plugin) > 0 {
+			_, err := os.Stat(parserConf.Plugin)
+			if err != nil {
+				return confCheckError(eerrors.Wrapf(err, "Invalid plugin in parser '%s'", name))
+			}
+		}
+		if len(parserConf.Wasm) > 0 {
+			_, err := os.Stat(parserConf.Wasm)
+			if err != nil {
+				return confCheckError(eerrors.Wrapf(err, "Invalid WASM module in parser '%s'", name))
+			}
+		}
 		parsersNames[name] = true
 	}
 
@@ -790,12 +1041,105 @@ func (c *BaseConfig) Complete(r kring.Ring) (err error) {
 		return err
 	}
 
+	err = c.CheckRoutes()
+	if err != nil {
+		return err
+	}
+
+	err = c.CheckGeoIP()
+	if err != nil {
+		return err
+	}
+
+	switch c.Store.Backend {
+	case BadgerBackend, BoltBackend, MemoryBackend:
+	case "":
+		c.Store.Backend = BadgerBackend
+	default:
+		return confCheckError(eerrors.Errorf("Unknown store backend: '%s'", c.Store.Backend))
+	}
+
+	if c.Store.MaxAge < 0 || c.Store.MaxMessages < 0 {
+		return confCheckError(eerrors.New("The store max_age and max_messages must not be negative"))
+	}
+	if c.Store.Backend == MemoryBackend && c.Store.MaxMessages == 0 {
+		c.Store.MaxMessages = DefaultMemoryMaxMessages
+	}
+
+	if len(c.Store.OldSecrets) > 0 && len(strings.TrimSpace(c.Store.Secret)) == 0 {
+		return confCheckError(eerrors.New("The store old_secrets can only be used with a store secret"))
+	}
+
+	if c.Store.MaxDiskUsage < 0 {
+		return confCheckError(eerrors.New("The store max_disk_usage must not be negative"))
+	}
+	if c.Store.Backend == BoltBackend && (c.Store.MaxAge > 0 || c.Store.MaxMessages > 0 || c.Store.MaxDiskUsage > 0) {
+		return confCheckError(eerrors.New("The store max_age, max_messages and max_disk_usage are not supported by the bolt backend"))
+	}
+	if c.Store.Backend == MemoryBackend && (len(c.Store.DeadLetterFile) > 0 || c.Store.MaxDiskUsage > 0) {
+		return confCheckError(eerrors.New("The store dead_letter_file and max_disk_usage are not supported by the memory backend"))
+	}
+	c.Store.DeadLetterFile = strings.TrimSpace(c.Store.DeadLetterFile)
+	if len(c.Store.DeadLetterFile) > 0 {
+		// the confined store finds the file under the same absolute path
+		c.Store.DeadLetterFile, err = filepath.Abs(c.Store.DeadLetterFile)
+		if err != nil {
+			return confCheckError(eerrors.Wrap(err, "Invalid store dead_letter_file"))
+		}
+	}
+	switch c.Store.DiskUsagePolicy {
+	case DiskBlock, DiskDropOldest, DiskDropNewest:
+	case "":
+		c.Store.DiskUsagePolicy = DiskBlock
+	default:
+		return confCheckError(eerrors.Errorf("Unknown store disk_usage_policy: '%s'", c.Store.DiskUsagePolicy))
+	}
+	if c.Store.GCInterval <= 0 {
+		c.Store.GCInterval = time.Minute
+	}
+	if c.Store.GCDiscardRatio <= 0 || c.Store.GCDiscardRatio >= 1 {
+		return confCheckError(eerrors.New("The store gc_discard_ratio must be between 0 and 1"))
+	}
+	if c.Store.AckBatchSize == 0 {
+		c.Store.AckBatchSize = c.Store.BatchSize * 4 / 5
+	}
+	if c.Store.AckBatchSize == 0 {
+		c.Store.AckBatchSize = 1
+	}
+	if c.Store.AckLatency < 0 {
+		return confCheckError(eerrors.New("The store ack_latency must not be negative"))
+	}
+
 	_, err = ParseVersion(c.KafkaDest.Version)
 	if err != nil {
 		return confCheckError(
 			eerrors.Wrap(err, "Kafka version can not be parsed"),
 		)
 	}
+	err = c.KafkaDest.CheckSASL()
+	if err != nil {
+		return err
+	}
+	_, err = c.KafkaDest.GetHeaders()
+	if err != nil {
+		return err
+	}
+	_, err = c.KafkaDest.GetTopicDetail()
+	if err != nil {
+		return err
+	}
+	err = c.KafkaDest.CheckSchemaRegistry()
+	if err != nil {
+		return err
+	}
+	err = c.KafkaDest.CheckExactlyOnce()
+	if err != nil {
+		return err
+	}
+	err = c.FileDest.CheckRotation()
+	if err != nil {
+		return err
+	}
 
 	if len(c.NATSDest.NServers) == 0 {
 		if c.NATSDest.TLSEnabled {
@@ -819,36 +1163,125 @@ func (c *BaseConfig) Complete(r kring.Ring) (err error) {
 		}
 	}
 
-	sources := make([]Source, 0)
-	for i := range c.FSSource {
-		sources = append(sources, &c.FSSource[i])
-	}
+	sources := c.Sources()
+
 	for i := range c.TCPSource {
-		sources = append(sources, &c.TCPSource[i])
+		if len(c.TCPSource[i].FrameDelimiter) == 0 {
+			c.TCPSource[i].FrameDelimiter = "\n"
+		}
+		framing := strings.ToLower(strings.TrimSpace(c.TCPSource[i].Framing))
+		switch framing {
+		case "":
+			// keep compatibility with the line_framing option
+			if c.TCPSource[i].LineFraming {
+				framing = TCPLineFraming
+			} else {
+				framing = TCPAutoFraming
+			}
+		case TCPAutoFraming, TCPLineFraming, TCPOctetFraming:
+		case TCPRFC5425Framing:
+			// RFC 5425: TLS from the first byte, and mutual authentication by default
+			if !c.TCPSource[i].TLSEnabled {
+				return confCheckError(eerrors.New("RFC 5425 framing requires tls_enabled"))
+			}
+			if c.TCPSource[i].StartTLS {
+				return confCheckError(eerrors.New("RFC 5425 framing is not compatible with starttls"))
+			}
+			if len(strings.TrimSpace(c.TCPSource[i].ClientAuthType)) == 0 {
+				c.TCPSource[i].ClientAuthType = "require_and_verify_client_cert"
+			}
+		default:
+			return confCheckError(eerrors.WithTags(eerrors.New("Unknown TCP framing"), "framing", framing))
+		}
+		c.TCPSource[i].Framing = framing
 	}
+
 	for i := range c.UDPSource {
-		sources = append(sources, &c.UDPSource[i])
-	}
-	for i := range c.RELPSource {
-		sources = append(sources, &c.RELPSource[i])
-	}
-	for i := range c.DirectRELPSource {
-		sources = append(sources, &c.DirectRELPSource[i])
+		if c.UDPSource[i].Sockets <= 0 {
+			c.UDPSource[i].Sockets = 1
+		}
 	}
+
+	// set default values for graylog sources
 	for i := range c.GraylogSource {
-		sources = append(sources, &c.GraylogSource[i])
+		gc := &c.GraylogSource[i]
+		if gc.MaxChunks <= 0 {
+			gc.MaxChunks = 128
+		}
+		if gc.MaxChunks > 255 {
+			// the number of chunks is coded on one byte
+			gc.MaxChunks = 255
+		}
+		if gc.ChunksTimeout <= 0 {
+			gc.ChunksTimeout = 5 * time.Second
+		}
+		if gc.MaxMessageSize <= 0 {
+			gc.MaxMessageSize = 1024 * 1024
+		}
 	}
-	for i := range c.KafkaSource {
-		sources = append(sources, &c.KafkaSource[i])
+
+	// set default values for exec sources
+	for i := range c.ExecSource {
+		ec := &c.ExecSource[i]
+		ec.Command = strings.TrimSpace(ec.Command)
+		if len(ec.Command) == 0 {
+			return confCheckError(eerrors.New("Exec source: command is empty"))
+		}
+		if len(ec.AppName) == 0 {
+			ec.AppName = filepath.Base(ec.Command)
+		}
+		if ec.Period <= 0 {
+			ec.Period = time.Minute
+		}
+		if ec.Timeout <= 0 || ec.Timeout > ec.Period {
+			ec.Timeout = ec.Period
+		}
+		if ec.MaxLines <= 0 {
+			ec.MaxLines = 10000
+		}
+		if ec.MaxLineSize <= 0 {
+			ec.MaxLineSize = 65536
+		}
 	}
-	for i := range c.HTTPServerSource {
-		sources = append(sources, &c.HTTPServerSource[i])
+
+	// set default values for pubsub sources
+	for i := range c.PubSubSource {
+		pc := &c.PubSubSource[i]
+		pc.Project = strings.TrimSpace(pc.Project)
+		pc.Subscription = strings.TrimSpace(pc.Subscription)
+		if len(pc.Project) == 0 || len(pc.Subscription) == 0 {
+			return confCheckError(eerrors.New("Pub/Sub source: project and subscription must be set"))
+		}
+		if len(pc.Endpoint) == 0 {
+			pc.Endpoint = "https://pubsub.googleapis.com"
+		}
+		if pc.MaxMessages <= 0 {
+			pc.MaxMessages = 100
+		}
+		if pc.Pullers <= 0 {
+			pc.Pullers = 1
+		}
+		if pc.PullTimeout <= 0 {
+			pc.PullTimeout = 30 * time.Second
+		}
 	}
-	sources = append(sources, &c.Journald, &c.Accounting, &c.MacOS)
 
-	for i := range c.TCPSource {
-		if len(c.TCPSource[i].FrameDelimiter) == 0 {
-			c.TCPSource[i].FrameDelimiter = "\n"
+	// set default values for journal gateway sources
+	for i := range c.JournalGatewaySource {
+		jc := &c.JournalGatewaySource[i]
+		jc.URL = strings.TrimRight(strings.TrimSpace(jc.URL), "/")
+		if len(jc.URL) == 0 {
+			return confCheckError(eerrors.New("Journal gateway source: url is empty"))
+		}
+		if !strings.Contains(jc.URL, "://") {
+			jc.URL = "http://" + jc.URL
+		}
+		_, err := url.Parse(jc.URL)
+		if err != nil {
+			return confCheckError(eerrors.Wrap(err, "Journal gateway source: invalid url"))
+		}
+		if jc.ReconnectPeriod <= 0 {
+			jc.ReconnectPeriod = 10 * time.Second
 		}
 	}
 
@@ -921,6 +1354,10 @@ func (c *BaseConfig) Complete(r kring.Ring) (err error) {
 			if err != nil {
 				return confCheckError(err)
 			}
+			_, _, err = listeners.ParseCIDRs()
+			if err != nil {
+				return confCheckError(err)
+			}
 
 		}
 		if filtering != nil {
@@ -932,7 +1369,7 @@ func (c *BaseConfig) Complete(r kring.Ring) (err error) {
 			}
 
 			if len(filtering.TopicTmpl) > 0 {
-				_, err = template.New("topic").Parse(filtering.TopicTmpl)
+				_, err = templates.New("topic").Parse(filtering.TopicTmpl)
 				if err != nil {
 					return confCheckError(
 						eerrors.Wrap(err, "Error compiling topic template"),
@@ -940,13 +1377,17 @@ func (c *BaseConfig) Complete(r kring.Ring) (err error) {
 				}
 			}
 			if len(filtering.PartitionTmpl) > 0 {
-				_, err = template.New("partition").Parse(filtering.PartitionTmpl)
+				_, err = templates.New("partition").Parse(filtering.PartitionTmpl)
 				if err != nil {
 					return confCheckError(
 						eerrors.Wrap(err, "Error compiling the partition key template"),
 					)
 				}
 			}
+			err = filtering.CheckFilters()
+			if err != nil {
+				return confCheckError(err)
+			}
 			sourceConf.SetConfID()
 		}
 
@@ -961,6 +1402,9 @@ func (c *BaseConfig) Complete(r kring.Ring) (err error) {
 		if conf.OffsetsMaxRetry <= 0 {
 			conf.OffsetsMaxRetry = 3
 		}
+		if conf.MaxInflight <= 0 {
+			conf.MaxInflight = 10000
+		}
 		if conf.HeartbeatInterval <= 0 {
 			conf.HeartbeatInterval = 3 * time.Second
 		}
@@ -971,12 +1415,19 @@ func (c *BaseConfig) Complete(r kring.Ring) (err error) {
 			conf.ClientID = "skewers"
 		}
 		if len(conf.Version) == 0 {
-			conf.Version = "0.10.1.0"
+			conf.Version = "0.10.2.0"
 		}
-		_, err = ParseVersion(conf.Version)
+		ver, err := ParseVersion(conf.Version)
 		if err != nil {
 			return confCheckError(eerrors.Wrap(err, "Kafka version can not be parsed"))
 		}
+		if !ver.IsAtLeast(sarama.V0_10_2_0) {
+			return confCheckError(eerrors.New("The Kafka source needs at least Kafka 0.10.2.0"))
+		}
+		err = conf.CheckSASL()
+		if err != nil {
+			return err
+		}
 		if conf.ChannelBufferSize == 0 {
 			conf.ChannelBufferSize = 256
 		}
@@ -1038,8 +1489,23 @@ func (c *BaseConfig) Complete(r kring.Ring) (err error) {
 		if err != nil {
 			return eerrors.Wrap(err, "Failed to encrypt the Store secret")
 		}
+		err = c.KafkaDest.EncryptSASLPassword(m)
+		if err != nil {
+			return eerrors.Wrap(err, "Failed to encrypt the Kafka SASL password")
+		}
+		for i := range c.KafkaSource {
+			err = c.KafkaSource[i].EncryptSASLPassword(m)
+			if err != nil {
+				return eerrors.Wrap(err, "Failed to encrypt the Kafka SASL password")
+			}
+		}
 	} else {
 		c.Store.Secret = ""
+		c.Store.OldSecrets = nil
+		c.KafkaDest.SASLPassword = ""
+		for i := range c.KafkaSource {
+			c.KafkaSource[i].SASLPassword = ""
+		}
 	}
 
 	c.KafkaDest.Partitioner = strings.TrimSpace(strings.ToLower(c.KafkaDest.Partitioner))
@@ -22,6 +22,14 @@ func SetDefaults(v *viper.Viper) {
 		SetMetricsDefaults,
 		SetAccountingDefaults,
 		SetMacOSDefaults,
+		SetKubernetesDefaults,
+		SetStdinDefaults,
+		SetCEFDefaults,
+		SetLEEFDefaults,
+		SetFlatJSONDefaults,
+		SetCSVDefaults,
+		SetGeoIPDefaults,
+		SetDNSCacheDefaults,
 		SetMetricsDefaults,
 		SetUdpDestDefaults,
 		SetTcpDestDefaults,
@@ -32,9 +40,19 @@ func SetDefaults(v *viper.Viper) {
 		SetHTTPDestDefaults,
 		SetHTTPServerDestDefaults,
 		SetWebsocketServerDestDefaults,
+		SetWebsocketClientDestDefaults,
+		SetSyslogTLSDestDefaults,
+		SetNullDestDefaults,
+		SetPromRemoteWriteDestDefaults,
+		SetAlertDestDefaults,
 		SetNatsDestDefaults,
 		SetElasticDestDefaults,
 		SetRedisDestDefaults,
+		SetAzureBlobDestDefaults,
+		SetMongoDBDestDefaults,
+		SetNSQDestDefaults,
+		SetPulsarDestDefaults,
+		SetSQSDestDefaults,
 		SetMainDefaults,
 	}
 	for _, f := range funcs {
@@ -53,6 +71,85 @@ func SetRedisDestDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"dial_timeout", "5s")
 	v.SetDefault(prefix+"read_timeout", "3s")
 	v.SetDefault(prefix+"write_timeout", "3s")
+	v.SetDefault(prefix+"mode", "list")
+	v.SetDefault(prefix+"stream_field", "message")
+	v.SetDefault(prefix+"pipeline_size", 100)
+}
+
+func SetAzureBlobDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "azureblob_destination."
+	}
+	v.SetDefault(prefix+"container_tmpl", "skewer")
+	v.SetDefault(prefix+"blob_tmpl", "{{.HostName}}/{{.AppName}}.log")
+	v.SetDefault(prefix+"blob_type", "append")
+	v.SetDefault(prefix+"flush_size", 1024*1024)
+	v.SetDefault(prefix+"flush_period", "10s")
+	v.SetDefault(prefix+"timeout", "30s")
+	v.SetDefault(prefix+"format", "json")
+	v.SetDefault(prefix+"parquet_compression", "snappy")
+	v.SetDefault(prefix+"parquet_row_group_size", 0)
+}
+
+func SetMongoDBDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "mongodb_destination."
+	}
+	v.SetDefault(prefix+"host", "127.0.0.1")
+	v.SetDefault(prefix+"port", 27017)
+	v.SetDefault(prefix+"database", "skewer")
+	v.SetDefault(prefix+"collection", "syslog")
+	v.SetDefault(prefix+"auth_source", "admin")
+	v.SetDefault(prefix+"capped_size", 1024*1024*1024)
+	v.SetDefault(prefix+"batch_size", 1000)
+	v.SetDefault(prefix+"flush_period", "2s")
+	v.SetDefault(prefix+"timeout", "10s")
+}
+
+func SetNSQDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "nsq_destination."
+	}
+	v.SetDefault(prefix+"host", "127.0.0.1")
+	v.SetDefault(prefix+"port", 4150)
+	v.SetDefault(prefix+"format", "json")
+	v.SetDefault(prefix+"dial_timeout", "5s")
+	v.SetDefault(prefix+"write_timeout", "5s")
+	v.SetDefault(prefix+"heartbeat_interval", "30s")
+	v.SetDefault(prefix+"max_in_flight", 1000)
+}
+
+func SetPulsarDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "pulsar_destination."
+	}
+	v.SetDefault(prefix+"url", "ws://127.0.0.1:8080")
+	v.SetDefault(prefix+"tenant", "public")
+	v.SetDefault(prefix+"namespace", "default")
+	v.SetDefault(prefix+"format", "json")
+	v.SetDefault(prefix+"batching_enabled", true)
+	v.SetDefault(prefix+"batching_max_messages", 1000)
+	v.SetDefault(prefix+"batching_max_publish_delay", "10ms")
+	v.SetDefault(prefix+"max_pending_messages", 1000)
+	v.SetDefault(prefix+"send_timeout", "30s")
+	v.SetDefault(prefix+"connection_timeout", "10s")
+}
+
+func SetSQSDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "sqs_destination."
+	}
+	v.SetDefault(prefix+"region", "us-east-1")
+	v.SetDefault(prefix+"message_group_tmpl", "{{.HostName}}")
+	v.SetDefault(prefix+"batch_size", 10)
+	v.SetDefault(prefix+"flush_period", "1s")
+	v.SetDefault(prefix+"timeout", "10s")
+	v.SetDefault(prefix+"format", "json")
 }
 
 func SetElasticDestDefaults(v *viper.Viper, prefixed bool) {
@@ -129,6 +226,17 @@ func SetWebsocketServerDestDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"web_endpoint", "/web")
 }
 
+func SetWebsocketClientDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "websocketclient_destination."
+	}
+	v.SetDefault(prefix+"format", "json")
+	v.SetDefault(prefix+"url", "ws://127.0.0.1:8080/logs")
+	v.SetDefault(prefix+"connection_timeout", "10s")
+	v.SetDefault(prefix+"write_timeout", "10s")
+}
+
 func SetHTTPDestDefaults(v *viper.Viper, prefixed bool) {
 	prefix := ""
 	if prefixed {
@@ -144,6 +252,10 @@ func SetHTTPDestDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"user_agent", "skewer/"+Version)
 	v.SetDefault(prefix+"method", "POST")
 	v.SetDefault(prefix+"content_type", "auto")
+	v.SetDefault(prefix+"max_retries", 3)
+	v.SetDefault(prefix+"retry_initial_backoff", "500ms")
+	v.SetDefault(prefix+"retry_max_backoff", "30s")
+	v.SetDefault(prefix+"retry_status_codes", []int{408, 429, 500, 502, 503, 504})
 }
 
 func SetGraylogDestDefaults(v *viper.Viper, prefixed bool) {
@@ -174,6 +286,7 @@ func SetRelpDestDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"connection_timeout", "10s")
 	v.SetDefault(prefix+"relp_timeout", "90s")
 	v.SetDefault(prefix+"flush_period", "1s")
+	v.SetDefault(prefix+"failback_period", "30s")
 }
 
 func SetFileDestDefaults(v *viper.Viper, prefixed bool) {
@@ -191,6 +304,15 @@ func SetFileDestDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"gzip", false)
 	v.SetDefault(prefix+"gzip_level", 5)
 	v.SetDefault(prefix+"format", "file")
+	v.SetDefault(prefix+"rotate_size", 0)
+	v.SetDefault(prefix+"rotate_interval", 0)
+	v.SetDefault(prefix+"rotate_pattern", "20060102-150405")
+	v.SetDefault(prefix+"rotate_gzip", false)
+	v.SetDefault(prefix+"max_files", 0)
+	v.SetDefault(prefix+"max_age", 0)
+	v.SetDefault(prefix+"parquet_compression", "snappy")
+	v.SetDefault(prefix+"parquet_row_group_size", 100000)
+	v.SetDefault(prefix+"parquet_roll_period", "5m")
 }
 
 func SetStderrDestDefaults(v *viper.Viper, prefixed bool) {
@@ -224,6 +346,55 @@ func SetTcpDestDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"keepalive_period", "75s")
 	v.SetDefault(prefix+"connection_timeout", "10s")
 	v.SetDefault(prefix+"flush_period", "1s")
+	v.SetDefault(prefix+"failback_period", "30s")
+}
+
+func SetSyslogTLSDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "syslogtls_destination."
+	}
+	v.SetDefault(prefix+"host", "127.0.0.1")
+	v.SetDefault(prefix+"port", 6514)
+	v.SetDefault(prefix+"keepalive", true)
+	v.SetDefault(prefix+"keepalive_period", "75s")
+	v.SetDefault(prefix+"connection_timeout", "10s")
+	v.SetDefault(prefix+"flush_period", "1s")
+}
+
+func SetNullDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "null_destination."
+	}
+	v.SetDefault(prefix+"format", "json")
+	v.SetDefault(prefix+"encode", false)
+}
+
+func SetPromRemoteWriteDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "promremotewrite_destination."
+	}
+	v.SetDefault(prefix+"url", "http://127.0.0.1:9090/api/v1/write")
+	v.SetDefault(prefix+"push_period", "15s")
+	v.SetDefault(prefix+"request_timeout", "10s")
+}
+
+func SetAlertDestDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "alert_destination."
+	}
+	v.SetDefault(prefix+"mode", "smtp")
+	v.SetDefault(prefix+"format", "json")
+	v.SetDefault(prefix+"severity", "err")
+	v.SetDefault(prefix+"max_alerts", 10)
+	v.SetDefault(prefix+"rate_period", "1m")
+	v.SetDefault(prefix+"request_timeout", "10s")
+	v.SetDefault(prefix+"smtp_server", "127.0.0.1:25")
+	v.SetDefault(prefix+"from", "skewer@localhost")
+	v.SetDefault(prefix+"subject", "[{{.Severity}}] {{.HostName}} {{.AppName}}")
 }
 
 func SetMainDefaults(v *viper.Viper, prefixed bool) {
@@ -234,7 +405,7 @@ func SetMainDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"direct_relp", false)
 	v.SetDefault(prefix+"max_input_message_size", 65536)
 	v.SetDefault(prefix+"input_queue_size", 1024)
-	v.SetDefault(prefix+"destination", "stderr")
+	v.SetDefault(prefix+"destination", []string{"stderr"})
 	v.SetDefault(prefix+"encrypt_ipc", true)
 }
 
@@ -256,6 +427,87 @@ func SetMacOSDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"command", "/usr/bin/log")
 }
 
+func SetKubernetesDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "kubernetes."
+	}
+	v.SetDefault(prefix+"api_server", "https://kubernetes.default.svc")
+	v.SetDefault(prefix+"token_file", "/var/run/secrets/kubernetes.io/serviceaccount/token")
+	v.SetDefault(prefix+"ca_file", "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
+	v.SetDefault(prefix+"refresh_period", "30s")
+}
+
+func SetStdinDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "stdin."
+	}
+	v.SetDefault(prefix+"max_line_size", 1024*1024)
+}
+
+func SetCEFDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "cef."
+	}
+	v.SetDefault(prefix+"device_vendor", "skewer")
+	v.SetDefault(prefix+"device_product", "skewer")
+	v.SetDefault(prefix+"device_version", "1")
+	v.SetDefault(prefix+"all_properties", false)
+}
+
+func SetLEEFDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "leef."
+	}
+	v.SetDefault(prefix+"vendor", "skewer")
+	v.SetDefault(prefix+"product", "skewer")
+	v.SetDefault(prefix+"version", "1")
+	v.SetDefault(prefix+"delimiter", "^")
+	v.SetDefault(prefix+"attributes", []string{"identHostName=hostname", "msg=message"})
+	v.SetDefault(prefix+"all_properties", false)
+}
+
+func SetFlatJSONDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "flatjson."
+	}
+	v.SetDefault(prefix+"timestamp_layout", "2006-01-02T15:04:05.999999999Z07:00")
+}
+
+func SetCSVDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "csv."
+	}
+	v.SetDefault(prefix+"columns", []string{"timereported", "hostname", "appname", "procid", "msgid", "facility", "severity", "message"})
+	v.SetDefault(prefix+"separator", ",")
+	v.SetDefault(prefix+"timestamp_layout", "2006-01-02T15:04:05.999999999Z07:00")
+}
+
+func SetGeoIPDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "geoip."
+	}
+	v.SetDefault(prefix+"databases", []string{})
+	v.SetDefault(prefix+"check_period", "30s")
+}
+
+func SetDNSCacheDefaults(v *viper.Viper, prefixed bool) {
+	prefix := ""
+	if prefixed {
+		prefix = "dns_cache."
+	}
+	v.SetDefault(prefix+"size", 10000)
+	v.SetDefault(prefix+"ttl", "10m")
+	v.SetDefault(prefix+"negative_ttl", "1m")
+	v.SetDefault(prefix+"timeout", "2s")
+}
+
 func SetMetricsDefaults(v *viper.Viper, prefixed bool) {
 	prefix := ""
 	if prefixed {
@@ -305,6 +557,15 @@ func SetKafkaDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"producer_timeout", "10s")
 	v.SetDefault(prefix+"compression", "snappy")
 	v.SetDefault(prefix+"partitioner", "hash")
+	v.SetDefault(prefix+"create_topics", false)
+	v.SetDefault(prefix+"topic_partitions", 1)
+	v.SetDefault(prefix+"topic_replication_factor", 1)
+	v.SetDefault(prefix+"topic_retention", 0)
+	v.SetDefault(prefix+"schema_registry_url", "")
+	v.SetDefault(prefix+"subject_name_strategy", "topic")
+	v.SetDefault(prefix+"exactly_once", false)
+	v.SetDefault(prefix+"transactional_id", "")
+	v.SetDefault(prefix+"transaction_timeout", "1m")
 
 	v.SetDefault(prefix+"format", "json")
 }
@@ -315,8 +576,18 @@ func SetStoreDefaults(v *viper.Viper, prefixed bool) {
 		prefix = "store."
 	}
 	v.SetDefault(prefix+"dirname", "/var/lib/skewer")
+	v.SetDefault(prefix+"backend", BadgerBackend)
 	v.SetDefault(prefix+"max_table_size", 64<<20)
 	v.SetDefault(prefix+"value_log_file_size", 64<<20)
 	v.SetDefault(prefix+"batch_size", 5000)
 	v.SetDefault(prefix+"add_missing_msgid", true)
+	v.SetDefault(prefix+"max_age", 0)
+	v.SetDefault(prefix+"max_messages", 0)
+	v.SetDefault(prefix+"dead_letter_file", "")
+	v.SetDefault(prefix+"max_disk_usage", 0)
+	v.SetDefault(prefix+"disk_usage_policy", DiskBlock)
+	v.SetDefault(prefix+"gc_interval", "1m")
+	v.SetDefault(prefix+"gc_discard_ratio", 0.25)
+	v.SetDefault(prefix+"ack_batch_size", 0)
+	v.SetDefault(prefix+"ack_latency", 0)
 }
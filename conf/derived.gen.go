@@ -155,6 +155,60 @@ func deriveDeepCopy(dst, src *BaseConfig) {
 		}
 		deriveDeepCopy_5(dst.GraylogSource, src.GraylogSource)
 	}
+	if src.ExecSource == nil {
+		dst.ExecSource = nil
+	} else {
+		if dst.ExecSource != nil {
+			if len(src.ExecSource) > len(dst.ExecSource) {
+				if cap(dst.ExecSource) >= len(src.ExecSource) {
+					dst.ExecSource = (dst.ExecSource)[:len(src.ExecSource)]
+				} else {
+					dst.ExecSource = make([]ExecSourceConfig, len(src.ExecSource))
+				}
+			} else if len(src.ExecSource) < len(dst.ExecSource) {
+				dst.ExecSource = (dst.ExecSource)[:len(src.ExecSource)]
+			}
+		} else {
+			dst.ExecSource = make([]ExecSourceConfig, len(src.ExecSource))
+		}
+		deriveDeepCopy_17(dst.ExecSource, src.ExecSource)
+	}
+	if src.PubSubSource == nil {
+		dst.PubSubSource = nil
+	} else {
+		if dst.PubSubSource != nil {
+			if len(src.PubSubSource) > len(dst.PubSubSource) {
+				if cap(dst.PubSubSource) >= len(src.PubSubSource) {
+					dst.PubSubSource = (dst.PubSubSource)[:len(src.PubSubSource)]
+				} else {
+					dst.PubSubSource = make([]PubSubSourceConfig, len(src.PubSubSource))
+				}
+			} else if len(src.PubSubSource) < len(dst.PubSubSource) {
+				dst.PubSubSource = (dst.PubSubSource)[:len(src.PubSubSource)]
+			}
+		} else {
+			dst.PubSubSource = make([]PubSubSourceConfig, len(src.PubSubSource))
+		}
+		copy(dst.PubSubSource, src.PubSubSource)
+	}
+	if src.JournalGatewaySource == nil {
+		dst.JournalGatewaySource = nil
+	} else {
+		if dst.JournalGatewaySource != nil {
+			if len(src.JournalGatewaySource) > len(dst.JournalGatewaySource) {
+				if cap(dst.JournalGatewaySource) >= len(src.JournalGatewaySource) {
+					dst.JournalGatewaySource = (dst.JournalGatewaySource)[:len(src.JournalGatewaySource)]
+				} else {
+					dst.JournalGatewaySource = make([]JournalGatewaySourceConfig, len(src.JournalGatewaySource))
+				}
+			} else if len(src.JournalGatewaySource) < len(dst.JournalGatewaySource) {
+				dst.JournalGatewaySource = (dst.JournalGatewaySource)[:len(src.JournalGatewaySource)]
+			}
+		} else {
+			dst.JournalGatewaySource = make([]JournalGatewaySourceConfig, len(src.JournalGatewaySource))
+		}
+		deriveDeepCopy_19(dst.JournalGatewaySource, src.JournalGatewaySource)
+	}
 	dst.Store = src.Store
 	if src.Parsers == nil {
 		dst.Parsers = nil
@@ -172,13 +226,61 @@ func deriveDeepCopy(dst, src *BaseConfig) {
 		} else {
 			dst.Parsers = make([]ParserConfig, len(src.Parsers))
 		}
-		copy(dst.Parsers, src.Parsers)
+		deriveDeepCopy_39(dst.Parsers, src.Parsers)
+	}
+	if src.Routes == nil {
+		dst.Routes = nil
+	} else {
+		if dst.Routes != nil {
+			if len(src.Routes) > len(dst.Routes) {
+				if cap(dst.Routes) >= len(src.Routes) {
+					dst.Routes = (dst.Routes)[:len(src.Routes)]
+				} else {
+					dst.Routes = make([]RouteConfig, len(src.Routes))
+				}
+			} else if len(src.Routes) < len(dst.Routes) {
+				dst.Routes = (dst.Routes)[:len(src.Routes)]
+			}
+		} else {
+			dst.Routes = make([]RouteConfig, len(src.Routes))
+		}
+		deriveDeepCopy_22(dst.Routes, src.Routes)
 	}
 	dst.Journald = src.Journald
 	dst.Metrics = src.Metrics
 	dst.Accounting = src.Accounting
 	dst.MacOS = src.MacOS
-	dst.Main = src.Main
+	dst.Kubernetes = src.Kubernetes
+	dst.Stdin = src.Stdin
+	func() {
+		field := new(CEFConfig)
+		deriveDeepCopy_35(field, &src.CEF)
+		dst.CEF = *field
+	}()
+	func() {
+		field := new(LEEFConfig)
+		deriveDeepCopy_36(field, &src.LEEF)
+		dst.LEEF = *field
+	}()
+	dst.FlatJSON = src.FlatJSON
+	func() {
+		field := new(CSVConfig)
+		deriveDeepCopy_38(field, &src.CSV)
+		dst.CSV = *field
+	}()
+	if src.Templates != nil {
+		dst.Templates = make(map[string]string, len(src.Templates))
+		deriveDeepCopy_37(dst.Templates, src.Templates)
+	} else {
+		dst.Templates = nil
+	}
+	func() {
+		field := new(GeoIPConfig)
+		deriveDeepCopy_41(field, &src.GeoIP)
+		dst.GeoIP = *field
+	}()
+	dst.DNSCache = src.DNSCache
+	deriveDeepCopy_21(&dst.Main, &src.Main)
 	if src.KafkaDest == nil {
 		dst.KafkaDest = nil
 	} else {
@@ -186,24 +288,62 @@ func deriveDeepCopy(dst, src *BaseConfig) {
 		deriveDeepCopy_6(dst.KafkaDest, src.KafkaDest)
 	}
 	dst.UDPDest = src.UDPDest
-	dst.TCPDest = src.TCPDest
-	dst.HTTPDest = src.HTTPDest
+	func() {
+		field := new(TCPDestConfig)
+		deriveDeepCopy_28(field, &src.TCPDest)
+		dst.TCPDest = *field
+	}()
+	func() {
+		field := new(HTTPDestConfig)
+		deriveDeepCopy_27(field, &src.HTTPDest)
+		dst.HTTPDest = *field
+	}()
 	dst.HTTPServerDest = src.HTTPServerDest
 	dst.WebsocketServerDest = src.WebsocketServerDest
+	dst.WebsocketClientDest = src.WebsocketClientDest
+	dst.SyslogTLSDest = src.SyslogTLSDest
+	dst.NullDest = src.NullDest
+	func() {
+		field := new(PromRemoteWriteDestConfig)
+		deriveDeepCopy_31(field, &src.PromRemoteWriteDest)
+		dst.PromRemoteWriteDest = *field
+	}()
+	func() {
+		field := new(AlertDestConfig)
+		deriveDeepCopy_34(field, &src.AlertDest)
+		dst.AlertDest = *field
+	}()
 	if src.NATSDest == nil {
 		dst.NATSDest = nil
 	} else {
 		dst.NATSDest = new(NATSDestConfig)
 		deriveDeepCopy_7(dst.NATSDest, src.NATSDest)
 	}
-	dst.RELPDest = src.RELPDest
-	dst.FileDest = src.FileDest
+	func() {
+		field := new(RELPDestConfig)
+		deriveDeepCopy_29(field, &src.RELPDest)
+		dst.RELPDest = *field
+	}()
+	func() {
+		field := new(FileDestConfig)
+		deriveDeepCopy_24(field, &src.FileDest)
+		dst.FileDest = *field
+	}()
 	dst.StderrDest = src.StderrDest
 	dst.GraylogDest = src.GraylogDest
 	field := new(ElasticDestConfig)
 	deriveDeepCopy_8(field, &src.ElasticDest)
 	dst.ElasticDest = *field
 	dst.RedisDest = src.RedisDest
+	func() {
+		field := new(AzureBlobDestConfig)
+		deriveDeepCopy_25(field, &src.AzureBlobDest)
+		dst.AzureBlobDest = *field
+	}()
+	dst.MongoDBDest = src.MongoDBDest
+	dst.NSQDest = src.NSQDest
+	dst.PulsarDest = src.PulsarDest
+	dst.SQSDest = src.SQSDest
 }
 
 // deriveDeepCopy_ recursively copies the contents of src into dst.
@@ -269,6 +409,54 @@ func deriveDeepCopy_6(dst, src *KafkaDestConfig) {
 	dst.TlsBaseConfig = src.TlsBaseConfig
 	dst.Insecure = src.Insecure
 	dst.Format = src.Format
+	if src.Headers == nil {
+		dst.Headers = nil
+	} else {
+		if dst.Headers != nil {
+			if len(src.Headers) > len(dst.Headers) {
+				if cap(dst.Headers) >= len(src.Headers) {
+					dst.Headers = (dst.Headers)[:len(src.Headers)]
+				} else {
+					dst.Headers = make([]string, len(src.Headers))
+				}
+			} else if len(src.Headers) < len(dst.Headers) {
+				dst.Headers = (dst.Headers)[:len(src.Headers)]
+			}
+		} else {
+			dst.Headers = make([]string, len(src.Headers))
+		}
+		copy(dst.Headers, src.Headers)
+	}
+	dst.CreateTopics = src.CreateTopics
+	dst.TopicPartitions = src.TopicPartitions
+	dst.TopicReplicationFactor = src.TopicReplicationFactor
+	dst.TopicRetention = src.TopicRetention
+	if src.TopicConfig == nil {
+		dst.TopicConfig = nil
+	} else {
+		if dst.TopicConfig != nil {
+			if len(src.TopicConfig) > len(dst.TopicConfig) {
+				if cap(dst.TopicConfig) >= len(src.TopicConfig) {
+					dst.TopicConfig = (dst.TopicConfig)[:len(src.TopicConfig)]
+				} else {
+					dst.TopicConfig = make([]string, len(src.TopicConfig))
+				}
+			} else if len(src.TopicConfig) < len(dst.TopicConfig) {
+				dst.TopicConfig = (dst.TopicConfig)[:len(src.TopicConfig)]
+			}
+		} else {
+			dst.TopicConfig = make([]string, len(src.TopicConfig))
+		}
+		copy(dst.TopicConfig, src.TopicConfig)
+	}
+	dst.SchemaRegistryURL = src.SchemaRegistryURL
+	dst.SchemaRegistryUsername = src.SchemaRegistryUsername
+	dst.SchemaRegistryPassword = src.SchemaRegistryPassword
+	dst.SchemaRegistryInsecure = src.SchemaRegistryInsecure
+	dst.SubjectNameStrategy = src.SubjectNameStrategy
+	dst.ExactlyOnce = src.ExactlyOnce
+	dst.TransactionalID = src.TransactionalID
+	dst.TransactionTimeout = src.TransactionTimeout
 }
 
 // deriveDeepCopy_7 recursively copies the contents of src into dst.
@@ -362,9 +550,13 @@ func deriveDeepCopy_9(dst, src *TCPSourceConfig) {
 	dst.ListenersConfig = *field
 	dst.FilterSubConfig = src.FilterSubConfig
 	dst.TlsBaseConfig = src.TlsBaseConfig
+	dst.RateLimitConfig = src.RateLimitConfig
 	dst.ClientAuthType = src.ClientAuthType
 	dst.LineFraming = src.LineFraming
 	dst.FrameDelimiter = src.FrameDelimiter
+	dst.Framing = src.Framing
+	dst.StartTLS = src.StartTLS
+	dst.ProxyProtocol = src.ProxyProtocol
 	dst.ConfID = src.ConfID
 }
 
@@ -375,7 +567,9 @@ func deriveDeepCopy_10(dst, src *UDPSourceConfig) {
 	deriveDeepCopy_16(field, &src.ListenersConfig)
 	dst.ListenersConfig = *field
 	dst.FilterSubConfig = src.FilterSubConfig
+	dst.RateLimitConfig = src.RateLimitConfig
 	dst.ConfID = src.ConfID
+	dst.Sockets = src.Sockets
 }
 
 // deriveDeepCopy_11 recursively copies the contents of src into dst.
@@ -386,9 +580,12 @@ func deriveDeepCopy_11(dst, src *RELPSourceConfig) {
 	dst.ListenersConfig = *field
 	dst.FilterSubConfig = src.FilterSubConfig
 	dst.TlsBaseConfig = src.TlsBaseConfig
+	dst.RateLimitConfig = src.RateLimitConfig
 	dst.ClientAuthType = src.ClientAuthType
 	dst.LineFraming = src.LineFraming
 	dst.FrameDelimiter = src.FrameDelimiter
+	dst.StartTLS = src.StartTLS
+	dst.ProxyProtocol = src.ProxyProtocol
 	dst.ConfID = src.ConfID
 }
 
@@ -400,9 +597,12 @@ func deriveDeepCopy_12(dst, src *DirectRELPSourceConfig) {
 	dst.ListenersConfig = *field
 	dst.FilterSubConfig = src.FilterSubConfig
 	dst.TlsBaseConfig = src.TlsBaseConfig
+	dst.RateLimitConfig = src.RateLimitConfig
 	dst.ClientAuthType = src.ClientAuthType
 	dst.LineFraming = src.LineFraming
 	dst.FrameDelimiter = src.FrameDelimiter
+	dst.StartTLS = src.StartTLS
+	dst.ProxyProtocol = src.ProxyProtocol
 	dst.ConfID = src.ConfID
 }
 
@@ -421,6 +621,7 @@ func deriveDeepCopy_13(dst, src *KafkaSourceConfig) {
 	dst.HeartbeatInterval = src.HeartbeatInterval
 	dst.OffsetsMaxRetry = src.OffsetsMaxRetry
 	dst.GroupID = src.GroupID
+	dst.MaxInflight = src.MaxInflight
 	if src.Topics == nil {
 		dst.Topics = nil
 	} else {
@@ -449,6 +650,9 @@ func deriveDeepCopy_14(dst, src *GraylogSourceConfig) {
 	dst.ListenersConfig = *field
 	dst.FilterSubConfig = src.FilterSubConfig
 	dst.ConfID = src.ConfID
+	dst.MaxChunks = src.MaxChunks
+	dst.ChunksTimeout = src.ChunksTimeout
+	dst.MaxMessageSize = src.MaxMessageSize
 }
 
 // deriveDeepCopy_15 recursively copies the contents of src into dst.
@@ -482,6 +686,14 @@ func deriveDeepCopy_15(dst, src *KafkaBaseConfig) {
 	dst.MetadataRetryMax = src.MetadataRetryMax
 	dst.MetadataRetryBackoff = src.MetadataRetryBackoff
 	dst.MetadataRefreshFrequency = src.MetadataRefreshFrequency
+	dst.SASLMechanism = src.SASLMechanism
+	dst.SASLUsername = src.SASLUsername
+	dst.SASLPassword = src.SASLPassword
+	dst.SASLKerberosKeytab = src.SASLKerberosKeytab
+	dst.SASLKerberosPrincipal = src.SASLKerberosPrincipal
+	dst.SASLKerberosRealm = src.SASLKerberosRealm
+	dst.SASLKerberosServiceName = src.SASLKerberosServiceName
+	dst.SASLKerberosConfig = src.SASLKerberosConfig
 }
 
 // deriveDeepCopy_16 recursively copies the contents of src into dst.
@@ -509,4 +721,660 @@ func deriveDeepCopy_16(dst, src *ListenersConfig) {
 	dst.KeepAlive = src.KeepAlive
 	dst.KeepAlivePeriod = src.KeepAlivePeriod
 	dst.Timeout = src.Timeout
+	if src.AllowedCIDRs == nil {
+		dst.AllowedCIDRs = nil
+	} else {
+		dst.AllowedCIDRs = make([]string, len(src.AllowedCIDRs))
+		copy(dst.AllowedCIDRs, src.AllowedCIDRs)
+	}
+	if src.DeniedCIDRs == nil {
+		dst.DeniedCIDRs = nil
+	} else {
+		dst.DeniedCIDRs = make([]string, len(src.DeniedCIDRs))
+		copy(dst.DeniedCIDRs, src.DeniedCIDRs)
+	}
+}
+
+// deriveDeepCopy_17 recursively copies the contents of src into dst.
+func deriveDeepCopy_17(dst, src []ExecSourceConfig) {
+	for src_i, src_value := range src {
+		field := new(ExecSourceConfig)
+		deriveDeepCopy_18(field, &src_value)
+		dst[src_i] = *field
+	}
+}
+
+// deriveDeepCopy_18 recursively copies the contents of src into dst.
+func deriveDeepCopy_18(dst, src *ExecSourceConfig) {
+	dst.FilterSubConfig = src.FilterSubConfig
+	dst.ConfID = src.ConfID
+	dst.Command = src.Command
+	if src.Args == nil {
+		dst.Args = nil
+	} else {
+		if dst.Args != nil {
+			if len(src.Args) > len(dst.Args) {
+				if cap(dst.Args) >= len(src.Args) {
+					dst.Args = (dst.Args)[:len(src.Args)]
+				} else {
+					dst.Args = make([]string, len(src.Args))
+				}
+			} else if len(src.Args) < len(dst.Args) {
+				dst.Args = (dst.Args)[:len(src.Args)]
+			}
+		} else {
+			dst.Args = make([]string, len(src.Args))
+		}
+		copy(dst.Args, src.Args)
+	}
+	dst.AppName = src.AppName
+	dst.Period = src.Period
+	dst.Timeout = src.Timeout
+	dst.MaxLines = src.MaxLines
+	dst.MaxLineSize = src.MaxLineSize
+}
+
+// deriveDeepCopy_19 recursively copies the contents of src into dst.
+func deriveDeepCopy_19(dst, src []JournalGatewaySourceConfig) {
+	for src_i, src_value := range src {
+		field := new(JournalGatewaySourceConfig)
+		deriveDeepCopy_20(field, &src_value)
+		dst[src_i] = *field
+	}
+}
+
+// deriveDeepCopy_20 recursively copies the contents of src into dst.
+func deriveDeepCopy_20(dst, src *JournalGatewaySourceConfig) {
+	dst.FilterSubConfig = src.FilterSubConfig
+	dst.TlsBaseConfig = src.TlsBaseConfig
+	dst.ConfID = src.ConfID
+	dst.URL = src.URL
+	dst.Insecure = src.Insecure
+	if src.Matches == nil {
+		dst.Matches = nil
+	} else {
+		if dst.Matches != nil {
+			if len(src.Matches) > len(dst.Matches) {
+				if cap(dst.Matches) >= len(src.Matches) {
+					dst.Matches = (dst.Matches)[:len(src.Matches)]
+				} else {
+					dst.Matches = make([]string, len(src.Matches))
+				}
+			} else if len(src.Matches) < len(dst.Matches) {
+				dst.Matches = (dst.Matches)[:len(src.Matches)]
+			}
+		} else {
+			dst.Matches = make([]string, len(src.Matches))
+		}
+		copy(dst.Matches, src.Matches)
+	}
+	dst.FromStart = src.FromStart
+	dst.ReconnectPeriod = src.ReconnectPeriod
+}
+
+// deriveDeepCopy_21 recursively copies the contents of src into dst.
+func deriveDeepCopy_21(dst, src *MainConfig) {
+	dst.InputQueueSize = src.InputQueueSize
+	dst.MaxInputMessageSize = src.MaxInputMessageSize
+	if src.Destination == nil {
+		dst.Destination = nil
+	} else {
+		if dst.Destination != nil {
+			if len(src.Destination) > len(dst.Destination) {
+				if cap(dst.Destination) >= len(src.Destination) {
+					dst.Destination = (dst.Destination)[:len(src.Destination)]
+				} else {
+					dst.Destination = make([]string, len(src.Destination))
+				}
+			} else if len(src.Destination) < len(dst.Destination) {
+				dst.Destination = (dst.Destination)[:len(src.Destination)]
+			}
+		} else {
+			dst.Destination = make([]string, len(src.Destination))
+		}
+		copy(dst.Destination, src.Destination)
+	}
+	dst.EncryptIPC = src.EncryptIPC
+}
+
+// deriveDeepCopy_22 recursively copies the contents of src into dst.
+func deriveDeepCopy_22(dst, src []RouteConfig) {
+	for src_i, src_value := range src {
+		field := new(RouteConfig)
+		deriveDeepCopy_23(field, &src_value)
+		dst[src_i] = *field
+	}
+}
+
+// deriveDeepCopy_23 recursively copies the contents of src into dst.
+func deriveDeepCopy_23(dst, src *RouteConfig) {
+	dst.Client = src.Client
+	if src.Facility == nil {
+		dst.Facility = nil
+	} else {
+		if dst.Facility != nil {
+			if len(src.Facility) > len(dst.Facility) {
+				if cap(dst.Facility) >= len(src.Facility) {
+					dst.Facility = (dst.Facility)[:len(src.Facility)]
+				} else {
+					dst.Facility = make([]string, len(src.Facility))
+				}
+			} else if len(src.Facility) < len(dst.Facility) {
+				dst.Facility = (dst.Facility)[:len(src.Facility)]
+			}
+		} else {
+			dst.Facility = make([]string, len(src.Facility))
+		}
+		copy(dst.Facility, src.Facility)
+	}
+	if src.Severity == nil {
+		dst.Severity = nil
+	} else {
+		if dst.Severity != nil {
+			if len(src.Severity) > len(dst.Severity) {
+				if cap(dst.Severity) >= len(src.Severity) {
+					dst.Severity = (dst.Severity)[:len(src.Severity)]
+				} else {
+					dst.Severity = make([]string, len(src.Severity))
+				}
+			} else if len(src.Severity) < len(dst.Severity) {
+				dst.Severity = (dst.Severity)[:len(src.Severity)]
+			}
+		} else {
+			dst.Severity = make([]string, len(src.Severity))
+		}
+		copy(dst.Severity, src.Severity)
+	}
+	dst.MinSeverity = src.MinSeverity
+	dst.MaxSeverity = src.MaxSeverity
+	dst.AppName = src.AppName
+	if src.Properties == nil {
+		dst.Properties = nil
+	} else {
+		if dst.Properties != nil {
+			if len(src.Properties) > len(dst.Properties) {
+				if cap(dst.Properties) >= len(src.Properties) {
+					dst.Properties = (dst.Properties)[:len(src.Properties)]
+				} else {
+					dst.Properties = make([]string, len(src.Properties))
+				}
+			} else if len(src.Properties) < len(dst.Properties) {
+				dst.Properties = (dst.Properties)[:len(src.Properties)]
+			}
+		} else {
+			dst.Properties = make([]string, len(src.Properties))
+		}
+		copy(dst.Properties, src.Properties)
+	}
+	if src.Destination == nil {
+		dst.Destination = nil
+	} else {
+		if dst.Destination != nil {
+			if len(src.Destination) > len(dst.Destination) {
+				if cap(dst.Destination) >= len(src.Destination) {
+					dst.Destination = (dst.Destination)[:len(src.Destination)]
+				} else {
+					dst.Destination = make([]string, len(src.Destination))
+				}
+			} else if len(src.Destination) < len(dst.Destination) {
+				dst.Destination = (dst.Destination)[:len(src.Destination)]
+			}
+		} else {
+			dst.Destination = make([]string, len(src.Destination))
+		}
+		copy(dst.Destination, src.Destination)
+	}
+}
+
+// deriveDeepCopy_24 recursively copies the contents of src into dst.
+func deriveDeepCopy_24(dst, src *FileDestConfig) {
+	field := new(ParquetBaseConfig)
+	deriveDeepCopy_26(field, &src.ParquetBaseConfig)
+	dst.ParquetBaseConfig = *field
+	dst.Filename = src.Filename
+	dst.Sync = src.Sync
+	dst.SyncPeriod = src.SyncPeriod
+	dst.FlushPeriod = src.FlushPeriod
+	dst.BufferSize = src.BufferSize
+	dst.OpenFileTimeout = src.OpenFileTimeout
+	dst.OpenFilesCache = src.OpenFilesCache
+	dst.Gzip = src.Gzip
+	dst.GzipLevel = src.GzipLevel
+	dst.Format = src.Format
+	dst.RotateSize = src.RotateSize
+	dst.RotateInterval = src.RotateInterval
+	dst.RotatePattern = src.RotatePattern
+	dst.RotateGzip = src.RotateGzip
+	dst.MaxFiles = src.MaxFiles
+	dst.MaxAge = src.MaxAge
+	dst.ParquetRollPeriod = src.ParquetRollPeriod
+}
+
+// deriveDeepCopy_25 recursively copies the contents of src into dst.
+func deriveDeepCopy_25(dst, src *AzureBlobDestConfig) {
+	field := new(ParquetBaseConfig)
+	deriveDeepCopy_26(field, &src.ParquetBaseConfig)
+	dst.ParquetBaseConfig = *field
+	dst.Account = src.Account
+	dst.Endpoint = src.Endpoint
+	dst.SASToken = src.SASToken
+	dst.ManagedIdentity = src.ManagedIdentity
+	dst.ClientID = src.ClientID
+	dst.ContainerTmpl = src.ContainerTmpl
+	dst.BlobTmpl = src.BlobTmpl
+	dst.BlobType = src.BlobType
+	dst.FlushSize = src.FlushSize
+	dst.FlushPeriod = src.FlushPeriod
+	dst.Timeout = src.Timeout
+	dst.Format = src.Format
+}
+
+// deriveDeepCopy_26 recursively copies the contents of src into dst.
+func deriveDeepCopy_26(dst, src *ParquetBaseConfig) {
+	if src.ParquetColumns == nil {
+		dst.ParquetColumns = nil
+	} else {
+		if dst.ParquetColumns != nil {
+			if len(src.ParquetColumns) > len(dst.ParquetColumns) {
+				if cap(dst.ParquetColumns) >= len(src.ParquetColumns) {
+					dst.ParquetColumns = (dst.ParquetColumns)[:len(src.ParquetColumns)]
+				} else {
+					dst.ParquetColumns = make([]string, len(src.ParquetColumns))
+				}
+			} else if len(src.ParquetColumns) < len(dst.ParquetColumns) {
+				dst.ParquetColumns = (dst.ParquetColumns)[:len(src.ParquetColumns)]
+			}
+		} else {
+			dst.ParquetColumns = make([]string, len(src.ParquetColumns))
+		}
+		copy(dst.ParquetColumns, src.ParquetColumns)
+	}
+	dst.ParquetCompression = src.ParquetCompression
+	dst.ParquetRowGroupSize = src.ParquetRowGroupSize
+}
+
+// deriveDeepCopy_27 recursively copies the contents of src into dst.
+func deriveDeepCopy_27(dst, src *HTTPDestConfig) {
+	dst.TlsBaseConfig = src.TlsBaseConfig
+	dst.Insecure = src.Insecure
+	dst.URL = src.URL
+	dst.Method = src.Method
+	dst.ProxyURL = src.ProxyURL
+	dst.Rebind = src.Rebind
+	dst.Format = src.Format
+	dst.MaxIdleConnsPerHost = src.MaxIdleConnsPerHost
+	dst.IdleConnTimeout = src.IdleConnTimeout
+	dst.ConnTimeout = src.ConnTimeout
+	dst.RequestTimeout = src.RequestTimeout
+	dst.ConnKeepAlive = src.ConnKeepAlive
+	dst.ConnKeepAlivePeriod = src.ConnKeepAlivePeriod
+	dst.BasicAuth = src.BasicAuth
+	dst.Username = src.Username
+	dst.Password = src.Password
+	dst.UserAgent = src.UserAgent
+	dst.ContentType = src.ContentType
+	dst.MaxRetries = src.MaxRetries
+	dst.RetryInitialBackoff = src.RetryInitialBackoff
+	dst.RetryMaxBackoff = src.RetryMaxBackoff
+	if src.RetryStatusCodes == nil {
+		dst.RetryStatusCodes = nil
+	} else {
+		if dst.RetryStatusCodes != nil {
+			if len(src.RetryStatusCodes) > len(dst.RetryStatusCodes) {
+				if cap(dst.RetryStatusCodes) >= len(src.RetryStatusCodes) {
+					dst.RetryStatusCodes = (dst.RetryStatusCodes)[:len(src.RetryStatusCodes)]
+				} else {
+					dst.RetryStatusCodes = make([]int, len(src.RetryStatusCodes))
+				}
+			} else if len(src.RetryStatusCodes) < len(dst.RetryStatusCodes) {
+				dst.RetryStatusCodes = (dst.RetryStatusCodes)[:len(src.RetryStatusCodes)]
+			}
+		} else {
+			dst.RetryStatusCodes = make([]int, len(src.RetryStatusCodes))
+		}
+		copy(dst.RetryStatusCodes, src.RetryStatusCodes)
+	}
+	dst.ResponseAckRegexp = src.ResponseAckRegexp
+	dst.ResponseAckPath = src.ResponseAckPath
+	dst.ResponseAckValue = src.ResponseAckValue
+}
+
+// deriveDeepCopy_28 recursively copies the contents of src into dst.
+func deriveDeepCopy_28(dst, src *TCPDestConfig) {
+	dst.TcpUdpRelpDestBaseConfig = src.TcpUdpRelpDestBaseConfig
+	dst.TlsBaseConfig = src.TlsBaseConfig
+	field := new(FailoverBaseConfig)
+	deriveDeepCopy_30(field, &src.FailoverBaseConfig)
+	dst.FailoverBaseConfig = *field
+	dst.Insecure = src.Insecure
+	dst.KeepAlive = src.KeepAlive
+	dst.KeepAlivePeriod = src.KeepAlivePeriod
+	dst.ConnTimeout = src.ConnTimeout
+	dst.FlushPeriod = src.FlushPeriod
+	dst.LineFraming = src.LineFraming
+	dst.FrameDelimiter = src.FrameDelimiter
+}
+
+// deriveDeepCopy_29 recursively copies the contents of src into dst.
+func deriveDeepCopy_29(dst, src *RELPDestConfig) {
+	dst.TcpUdpRelpDestBaseConfig = src.TcpUdpRelpDestBaseConfig
+	dst.TlsBaseConfig = src.TlsBaseConfig
+	field := new(FailoverBaseConfig)
+	deriveDeepCopy_30(field, &src.FailoverBaseConfig)
+	dst.FailoverBaseConfig = *field
+	dst.Insecure = src.Insecure
+	dst.KeepAlive = src.KeepAlive
+	dst.KeepAlivePeriod = src.KeepAlivePeriod
+	dst.ConnTimeout = src.ConnTimeout
+	dst.FlushPeriod = src.FlushPeriod
+	dst.WindowSize = src.WindowSize
+	dst.RelpTimeout = src.RelpTimeout
+}
+
+// deriveDeepCopy_30 recursively copies the contents of src into dst.
+func deriveDeepCopy_30(dst, src *FailoverBaseConfig) {
+	if src.Failover == nil {
+		dst.Failover = nil
+	} else {
+		if dst.Failover != nil {
+			if len(src.Failover) > len(dst.Failover) {
+				if cap(dst.Failover) >= len(src.Failover) {
+					dst.Failover = (dst.Failover)[:len(src.Failover)]
+				} else {
+					dst.Failover = make([]string, len(src.Failover))
+				}
+			} else if len(src.Failover) < len(dst.Failover) {
+				dst.Failover = (dst.Failover)[:len(src.Failover)]
+			}
+		} else {
+			dst.Failover = make([]string, len(src.Failover))
+		}
+		copy(dst.Failover, src.Failover)
+	}
+	dst.FailbackPeriod = src.FailbackPeriod
+}
+
+// deriveDeepCopy_31 recursively copies the contents of src into dst.
+func deriveDeepCopy_31(dst, src *PromRemoteWriteDestConfig) {
+	dst.TlsBaseConfig = src.TlsBaseConfig
+	dst.Insecure = src.Insecure
+	dst.URL = src.URL
+	dst.PushPeriod = src.PushPeriod
+	dst.RequestTimeout = src.RequestTimeout
+	dst.BasicAuth = src.BasicAuth
+	dst.Username = src.Username
+	dst.Password = src.Password
+	dst.BearerToken = src.BearerToken
+	if src.ExternalLabels == nil {
+		dst.ExternalLabels = nil
+	} else {
+		if dst.ExternalLabels != nil {
+			if len(src.ExternalLabels) > len(dst.ExternalLabels) {
+				if cap(dst.ExternalLabels) >= len(src.ExternalLabels) {
+					dst.ExternalLabels = (dst.ExternalLabels)[:len(src.ExternalLabels)]
+				} else {
+					dst.ExternalLabels = make([]string, len(src.ExternalLabels))
+				}
+			} else if len(src.ExternalLabels) < len(dst.ExternalLabels) {
+				dst.ExternalLabels = (dst.ExternalLabels)[:len(src.ExternalLabels)]
+			}
+		} else {
+			dst.ExternalLabels = make([]string, len(src.ExternalLabels))
+		}
+		copy(dst.ExternalLabels, src.ExternalLabels)
+	}
+	if src.Metrics == nil {
+		dst.Metrics = nil
+	} else {
+		if dst.Metrics != nil {
+			if len(src.Metrics) > len(dst.Metrics) {
+				if cap(dst.Metrics) >= len(src.Metrics) {
+					dst.Metrics = (dst.Metrics)[:len(src.Metrics)]
+				} else {
+					dst.Metrics = make([]MetricRuleConfig, len(src.Metrics))
+				}
+			} else if len(src.Metrics) < len(dst.Metrics) {
+				dst.Metrics = (dst.Metrics)[:len(src.Metrics)]
+			}
+		} else {
+			dst.Metrics = make([]MetricRuleConfig, len(src.Metrics))
+		}
+		deriveDeepCopy_32(dst.Metrics, src.Metrics)
+	}
+}
+
+// deriveDeepCopy_32 recursively copies the contents of src into dst.
+func deriveDeepCopy_32(dst, src []MetricRuleConfig) {
+	for src_i, src_value := range src {
+		field := new(MetricRuleConfig)
+		deriveDeepCopy_33(field, &src_value)
+		dst[src_i] = *field
+	}
+}
+
+// deriveDeepCopy_33 recursively copies the contents of src into dst.
+func deriveDeepCopy_33(dst, src *MetricRuleConfig) {
+	dst.Name = src.Name
+	dst.Type = src.Type
+	dst.Match = src.Match
+	dst.AppName = src.AppName
+	dst.Value = src.Value
+	if src.Labels == nil {
+		dst.Labels = nil
+	} else {
+		if dst.Labels != nil {
+			if len(src.Labels) > len(dst.Labels) {
+				if cap(dst.Labels) >= len(src.Labels) {
+					dst.Labels = (dst.Labels)[:len(src.Labels)]
+				} else {
+					dst.Labels = make([]string, len(src.Labels))
+				}
+			} else if len(src.Labels) < len(dst.Labels) {
+				dst.Labels = (dst.Labels)[:len(src.Labels)]
+			}
+		} else {
+			dst.Labels = make([]string, len(src.Labels))
+		}
+		copy(dst.Labels, src.Labels)
+	}
+}
+
+// deriveDeepCopy_34 recursively copies the contents of src into dst.
+func deriveDeepCopy_34(dst, src *AlertDestConfig) {
+	dst.TlsBaseConfig = src.TlsBaseConfig
+	dst.Insecure = src.Insecure
+	dst.Mode = src.Mode
+	dst.Format = src.Format
+	dst.Severity = src.Severity
+	dst.Match = src.Match
+	dst.AppName = src.AppName
+	dst.MaxAlerts = src.MaxAlerts
+	dst.RatePeriod = src.RatePeriod
+	dst.WebhookURL = src.WebhookURL
+	dst.BearerToken = src.BearerToken
+	dst.RequestTimeout = src.RequestTimeout
+	dst.SMTPServer = src.SMTPServer
+	dst.SMTPUsername = src.SMTPUsername
+	dst.SMTPPassword = src.SMTPPassword
+	dst.From = src.From
+	if src.To == nil {
+		dst.To = nil
+	} else {
+		if dst.To != nil {
+			if len(src.To) > len(dst.To) {
+				if cap(dst.To) >= len(src.To) {
+					dst.To = (dst.To)[:len(src.To)]
+				} else {
+					dst.To = make([]string, len(src.To))
+				}
+			} else if len(src.To) < len(dst.To) {
+				dst.To = (dst.To)[:len(src.To)]
+			}
+		} else {
+			dst.To = make([]string, len(src.To))
+		}
+		copy(dst.To, src.To)
+	}
+	dst.Subject = src.Subject
+}
+
+// deriveDeepCopy_35 recursively copies the contents of src into dst.
+func deriveDeepCopy_35(dst, src *CEFConfig) {
+	dst.DeviceVendor = src.DeviceVendor
+	dst.DeviceProduct = src.DeviceProduct
+	dst.DeviceVersion = src.DeviceVersion
+	if src.Extensions == nil {
+		dst.Extensions = nil
+	} else {
+		if dst.Extensions != nil {
+			if len(src.Extensions) > len(dst.Extensions) {
+				if cap(dst.Extensions) >= len(src.Extensions) {
+					dst.Extensions = (dst.Extensions)[:len(src.Extensions)]
+				} else {
+					dst.Extensions = make([]string, len(src.Extensions))
+				}
+			} else if len(src.Extensions) < len(dst.Extensions) {
+				dst.Extensions = (dst.Extensions)[:len(src.Extensions)]
+			}
+		} else {
+			dst.Extensions = make([]string, len(src.Extensions))
+		}
+		copy(dst.Extensions, src.Extensions)
+	}
+	dst.AllProperties = src.AllProperties
+}
+
+// deriveDeepCopy_36 recursively copies the contents of src into dst.
+func deriveDeepCopy_36(dst, src *LEEFConfig) {
+	dst.Vendor = src.Vendor
+	dst.Product = src.Product
+	dst.Version = src.Version
+	dst.Delimiter = src.Delimiter
+	if src.Attributes == nil {
+		dst.Attributes = nil
+	} else {
+		if dst.Attributes != nil {
+			if len(src.Attributes) > len(dst.Attributes) {
+				if cap(dst.Attributes) >= len(src.Attributes) {
+					dst.Attributes = (dst.Attributes)[:len(src.Attributes)]
+				} else {
+					dst.Attributes = make([]string, len(src.Attributes))
+				}
+			} else if len(src.Attributes) < len(dst.Attributes) {
+				dst.Attributes = (dst.Attributes)[:len(src.Attributes)]
+			}
+		} else {
+			dst.Attributes = make([]string, len(src.Attributes))
+		}
+		copy(dst.Attributes, src.Attributes)
+	}
+	dst.AllProperties = src.AllProperties
+}
+
+// deriveDeepCopy_37 recursively copies the contents of src into dst.
+func deriveDeepCopy_37(dst, src map[string]string) {
+	for src_key, src_value := range src {
+		dst[src_key] = src_value
+	}
+}
+
+// deriveDeepCopy_38 recursively copies the contents of src into dst.
+func deriveDeepCopy_38(dst, src *CSVConfig) {
+	if src.Columns == nil {
+		dst.Columns = nil
+	} else {
+		if dst.Columns != nil {
+			if len(src.Columns) > len(dst.Columns) {
+				if cap(dst.Columns) >= len(src.Columns) {
+					dst.Columns = (dst.Columns)[:len(src.Columns)]
+				} else {
+					dst.Columns = make([]string, len(src.Columns))
+				}
+			} else if len(src.Columns) < len(dst.Columns) {
+				dst.Columns = (dst.Columns)[:len(src.Columns)]
+			}
+		} else {
+			dst.Columns = make([]string, len(src.Columns))
+		}
+		copy(dst.Columns, src.Columns)
+	}
+	dst.Separator = src.Separator
+	dst.TimestampLayout = src.TimestampLayout
+}
+
+// deriveDeepCopy_39 recursively copies the contents of src into dst.
+func deriveDeepCopy_39(dst, src []ParserConfig) {
+	for src_i, src_value := range src {
+		field := new(ParserConfig)
+		deriveDeepCopy_40(field, &src_value)
+		dst[src_i] = *field
+	}
+}
+
+// deriveDeepCopy_40 recursively copies the contents of src into dst.
+func deriveDeepCopy_40(dst, src *ParserConfig) {
+	dst.Name = src.Name
+	dst.Func = src.Func
+	dst.Language = src.Language
+	if src.Regexps == nil {
+		dst.Regexps = nil
+	} else {
+		if dst.Regexps != nil {
+			if len(src.Regexps) > len(dst.Regexps) {
+				if cap(dst.Regexps) >= len(src.Regexps) {
+					dst.Regexps = (dst.Regexps)[:len(src.Regexps)]
+				} else {
+					dst.Regexps = make([]string, len(src.Regexps))
+				}
+			} else if len(src.Regexps) < len(dst.Regexps) {
+				dst.Regexps = (dst.Regexps)[:len(src.Regexps)]
+			}
+		} else {
+			dst.Regexps = make([]string, len(src.Regexps))
+		}
+		copy(dst.Regexps, src.Regexps)
+	}
+	if src.Command == nil {
+		dst.Command = nil
+	} else {
+		if dst.Command != nil {
+			if len(src.Command) > len(dst.Command) {
+				if cap(dst.Command) >= len(src.Command) {
+					dst.Command = (dst.Command)[:len(src.Command)]
+				} else {
+					dst.Command = make([]string, len(src.Command))
+				}
+			} else if len(src.Command) < len(dst.Command) {
+				dst.Command = (dst.Command)[:len(src.Command)]
+			}
+		} else {
+			dst.Command = make([]string, len(src.Command))
+		}
+		copy(dst.Command, src.Command)
+	}
+	dst.Plugin = src.Plugin
+	dst.Wasm = src.Wasm
+}
+
+// deriveDeepCopy_41 recursively copies the contents of src into dst.
+func deriveDeepCopy_41(dst, src *GeoIPConfig) {
+	if src.Databases == nil {
+		dst.Databases = nil
+	} else {
+		if dst.Databases != nil {
+			if len(src.Databases) > len(dst.Databases) {
+				if cap(dst.Databases) >= len(src.Databases) {
+					dst.Databases = (dst.Databases)[:len(src.Databases)]
+				} else {
+					dst.Databases = make([]string, len(src.Databases))
+				}
+			} else if len(src.Databases) < len(dst.Databases) {
+				dst.Databases = (dst.Databases)[:len(src.Databases)]
+			}
+		} else {
+			dst.Databases = make([]string, len(src.Databases))
+		}
+		copy(dst.Databases, src.Databases)
+	}
+	dst.CheckPeriod = src.CheckPeriod
 }
@@ -1,6 +1,7 @@
 package conf
 
 import (
+	"regexp"
 	"strings"
 
 	"github.com/stephane-martin/skewer/encoders/baseenc"
@@ -41,6 +42,16 @@ const (
 	WebsocketServer DestinationType = 1024
 	Elasticsearch   DestinationType = 2048
 	Redis           DestinationType = 4096
+	AzureBlob       DestinationType = 8192
+	MongoDB         DestinationType = 16384
+	NSQ             DestinationType = 32768
+	Pulsar          DestinationType = 65536
+	SQS             DestinationType = 131072
+	WebsocketClient DestinationType = 262144
+	SyslogTLS       DestinationType = 524288
+	Null            DestinationType = 1048576
+	PromRemoteWrite DestinationType = 2097152
+	Alert           DestinationType = 4194304
 )
 
 var Destinations = map[string]DestinationType{
@@ -57,6 +68,16 @@ var Destinations = map[string]DestinationType{
 	"websocketserver": WebsocketServer,
 	"elasticsearch":   Elasticsearch,
 	"redis":           Redis,
+	"azureblob":       AzureBlob,
+	"mongodb":         MongoDB,
+	"nsq":             NSQ,
+	"pulsar":          Pulsar,
+	"sqs":             SQS,
+	"websocketclient": WebsocketClient,
+	"syslogtls":       SyslogTLS,
+	"null":            Null,
+	"promremotewrite": PromRemoteWrite,
+	"alert":           Alert,
 }
 
 var DestinationNames = map[DestinationType]string{
@@ -73,6 +94,16 @@ var DestinationNames = map[DestinationType]string{
 	WebsocketServer: "websocketserver",
 	Elasticsearch:   "elasticsearch",
 	Redis:           "redis",
+	AzureBlob:       "azureblob",
+	MongoDB:         "mongodb",
+	NSQ:             "nsq",
+	Pulsar:          "pulsar",
+	SQS:             "sqs",
+	WebsocketClient: "websocketclient",
+	SyslogTLS:       "syslogtls",
+	Null:            "null",
+	PromRemoteWrite: "promremotewrite",
+	Alert:           "alert",
 }
 
 var RDestinations = map[DestinationType]string{
@@ -89,12 +120,39 @@ var RDestinations = map[DestinationType]string{
 	WebsocketServer: "w",
 	Elasticsearch:   "l",
 	Redis:           "d",
+	AzureBlob:       "a",
+	MongoDB:         "m",
+	NSQ:             "q",
+	Pulsar:          "p",
+	SQS:             "x",
+	WebsocketClient: "c",
+	SyslogTLS:       "y",
+	Null:            "z",
+	PromRemoteWrite: "o",
+	Alert:           "b",
 }
 
+// destinationNames returns the names of the configured destinations. The
+// elements of the list can themselves be comma-separated.
+func (m *MainConfig) destinationNames() []string {
+	names := make([]string, 0, len(m.Destination))
+	for _, elt := range m.Destination {
+		for _, name := range strings.Split(elt, ",") {
+			name = strings.TrimSpace(strings.ToLower(name))
+			if len(name) > 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// GetDestinations returns the set of configured destinations. Each message is
+// forwarded to every destination, and it is removed from the store when all
+// of them have acknowledged it.
 func (m *MainConfig) GetDestinations() (dests DestinationType, err error) {
-	destr := strings.TrimSpace(strings.ToLower(m.Destination))
-	for _, dest := range strings.Split(destr, ",") {
-		d, ok := Destinations[strings.TrimSpace(dest)]
+	for _, dest := range m.destinationNames() {
+		d, ok := Destinations[dest]
 		if !ok {
 			return 0, confCheckError(
 				eerrors.WithTags(
@@ -124,6 +182,13 @@ func (c *BaseConfig) CheckDestinations() error {
 	c.StderrDest.Format = strings.TrimSpace(strings.ToLower(c.StderrDest.Format))
 	c.ElasticDest.Format = strings.TrimSpace(strings.ToLower(c.ElasticDest.Format))
 	c.RedisDest.Format = strings.TrimSpace(strings.ToLower(c.RedisDest.Format))
+	c.AzureBlobDest.Format = strings.TrimSpace(strings.ToLower(c.AzureBlobDest.Format))
+	c.NSQDest.Format = strings.TrimSpace(strings.ToLower(c.NSQDest.Format))
+	c.PulsarDest.Format = strings.TrimSpace(strings.ToLower(c.PulsarDest.Format))
+	c.SQSDest.Format = strings.TrimSpace(strings.ToLower(c.SQSDest.Format))
+	c.WebsocketClientDest.Format = strings.TrimSpace(strings.ToLower(c.WebsocketClientDest.Format))
+	c.NullDest.Format = strings.TrimSpace(strings.ToLower(c.NullDest.Format))
+	c.AlertDest.Format = strings.TrimSpace(strings.ToLower(c.AlertDest.Format))
 
 	for _, frmt := range []string{
 		c.UDPDest.Format,
@@ -137,7 +202,26 @@ func (c *BaseConfig) CheckDestinations() error {
 		c.StderrDest.Format,
 		c.ElasticDest.Format,
 		c.RedisDest.Format,
+		c.AzureBlobDest.Format,
+		c.NSQDest.Format,
+		c.PulsarDest.Format,
+		c.SQSDest.Format,
+		c.WebsocketClientDest.Format,
+		c.NullDest.Format,
+		c.AlertDest.Format,
 	} {
+		if strings.HasPrefix(frmt, "template:") {
+			// the formats can reference a template of the configuration
+			if _, ok := c.Templates[strings.TrimPrefix(frmt, "template:")]; !ok {
+				return confCheckError(
+					eerrors.WithTags(
+						eerrors.New("Unknown template in destination format"),
+						"format", frmt,
+					),
+				)
+			}
+			continue
+		}
 		if baseenc.ParseFormat(frmt) == -1 {
 			return confCheckError(
 				eerrors.WithTags(
@@ -147,5 +231,140 @@ func (c *BaseConfig) CheckDestinations() error {
 			)
 		}
 	}
+
+	c.AzureBlobDest.BlobType = strings.TrimSpace(strings.ToLower(c.AzureBlobDest.BlobType))
+	switch c.AzureBlobDest.BlobType {
+	case "":
+		c.AzureBlobDest.BlobType = AzureAppendBlob
+	case AzureAppendBlob, AzureBlockBlob:
+	default:
+		return confCheckError(
+			eerrors.WithTags(
+				eerrors.New("Unknown Azure blob type"),
+				"blob_type", c.AzureBlobDest.BlobType,
+			),
+		)
+	}
+
+	// Parquet files can only be written as a whole by the archival destinations
+	for _, frmt := range []string{
+		c.UDPDest.Format,
+		c.TCPDest.Format,
+		c.HTTPDest.Format,
+		c.HTTPServerDest.Format,
+		c.WebsocketServerDest.Format,
+		c.RELPDest.Format,
+		c.KafkaDest.Format,
+		c.StderrDest.Format,
+		c.ElasticDest.Format,
+		c.RedisDest.Format,
+		c.NSQDest.Format,
+		c.PulsarDest.Format,
+		c.SQSDest.Format,
+		c.WebsocketClientDest.Format,
+		c.NullDest.Format,
+		c.AlertDest.Format,
+	} {
+		if baseenc.ParseFormat(frmt) == baseenc.Parquet {
+			return confCheckError(eerrors.New("The parquet format is only supported by the file and azureblob destinations"))
+		}
+	}
+	if baseenc.ParseFormat(c.FileDest.Format) == baseenc.Parquet && c.FileDest.Gzip {
+		return confCheckError(eerrors.New("The file destination can not gzip parquet files"))
+	}
+	if baseenc.ParseFormat(c.FileDest.Format) == baseenc.Parquet && c.FileDest.ParquetRollPeriod <= 0 {
+		return confCheckError(eerrors.New("The file destination parquet_roll_period must be positive"))
+	}
+	if baseenc.ParseFormat(c.AzureBlobDest.Format) == baseenc.Parquet && c.AzureBlobDest.BlobType != AzureBlockBlob {
+		return confCheckError(eerrors.New("The parquet format needs Azure block blobs"))
+	}
+	for _, parquetConf := range []*ParquetBaseConfig{&c.FileDest.ParquetBaseConfig, &c.AzureBlobDest.ParquetBaseConfig} {
+		parquetConf.ParquetCompression = strings.TrimSpace(strings.ToLower(parquetConf.ParquetCompression))
+		switch parquetConf.ParquetCompression {
+		case "", "none", "uncompressed", "snappy", "gzip":
+		default:
+			return confCheckError(
+				eerrors.WithTags(
+					eerrors.New("Unknown parquet compression"),
+					"parquet_compression", parquetConf.ParquetCompression,
+				),
+			)
+		}
+	}
+
+	c.RedisDest.Mode = strings.TrimSpace(strings.ToLower(c.RedisDest.Mode))
+	switch c.RedisDest.Mode {
+	case "":
+		c.RedisDest.Mode = RedisList
+	case RedisList, RedisChannel, RedisStream:
+	default:
+		return confCheckError(
+			eerrors.WithTags(
+				eerrors.New("Unknown Redis destination mode"),
+				"mode", c.RedisDest.Mode,
+			),
+		)
+	}
+	if c.RedisDest.Mode == RedisStream && len(c.RedisDest.StreamField) == 0 {
+		c.RedisDest.StreamField = "message"
+	}
+
+	c.PulsarDest.CompressionType = strings.TrimSpace(strings.ToUpper(c.PulsarDest.CompressionType))
+	switch c.PulsarDest.CompressionType {
+	case "", "NONE", "LZ4", "ZLIB", "ZSTD", "SNAPPY":
+	default:
+		return confCheckError(
+			eerrors.WithTags(
+				eerrors.New("Unknown Pulsar compression type"),
+				"compression_type", c.PulsarDest.CompressionType,
+			),
+		)
+	}
+
+	c.SQSDest.QueueURL = strings.TrimSpace(c.SQSDest.QueueURL)
+	c.SQSDest.TopicARN = strings.TrimSpace(c.SQSDest.TopicARN)
+	if len(c.SQSDest.QueueURL) > 0 && len(c.SQSDest.TopicARN) > 0 {
+		return confCheckError(eerrors.New("The SQS destination accepts a queue_url or a topic_arn, not both"))
+	}
+	if c.SQSDest.BatchSize <= 0 || c.SQSDest.BatchSize > 10 {
+		// SQS and SNS batches are limited to 10 messages
+		c.SQSDest.BatchSize = 10
+	}
+	if c.SQSDest.FlushPeriod <= 0 {
+		return confCheckError(eerrors.New("The SQS destination flush_period must be positive"))
+	}
+
+	err := c.PromRemoteWriteDest.CheckMetrics()
+	if err != nil {
+		return err
+	}
+
+	c.AlertDest.Mode = strings.TrimSpace(strings.ToLower(c.AlertDest.Mode))
+	switch c.AlertDest.Mode {
+	case "":
+		c.AlertDest.Mode = AlertSMTP
+	case AlertSMTP, AlertWebhook:
+	default:
+		return confCheckError(
+			eerrors.WithTags(
+				eerrors.New("Unknown alert destination mode"),
+				"mode", c.AlertDest.Mode,
+			),
+		)
+	}
+	if c.AlertDest.RequestTimeout <= 0 {
+		return confCheckError(eerrors.New("The alert destination request_timeout must be positive"))
+	}
+	c.AlertDest.Severity = strings.TrimSpace(strings.ToLower(c.AlertDest.Severity))
+	for _, expr := range []string{c.AlertDest.Match, c.AlertDest.AppName} {
+		_, err = regexp.Compile(expr)
+		if err != nil {
+			return confCheckError(eerrors.Wrap(err, "Invalid regular expression in the alert destination"))
+		}
+	}
+
+	if c.MongoDBDest.Capped && c.MongoDBDest.CappedSize <= 0 {
+		return confCheckError(eerrors.New("A capped MongoDB collection needs a positive capped_size"))
+	}
 	return nil
 }
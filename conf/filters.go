@@ -0,0 +1,630 @@
+package conf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/expr"
+	"github.com/stephane-martin/skewer/utils/templates"
+)
+
+const (
+	// FilterDrop drops the matching messages.
+	FilterDrop = "drop"
+	// FilterTag keeps the matching messages, but adds a property to them.
+	FilterTag = "tag"
+	// FilterRoute tags the matching messages, and sends them only to some
+	// destinations.
+	FilterRoute = "route"
+)
+
+const (
+	// RedactFixed replaces the sensitive data with a fixed string.
+	RedactFixed = "fixed"
+	// RedactHash replaces the sensitive data with its SHA256 hash.
+	RedactHash = "hash"
+	// RedactPartial masks the sensitive data, except its last characters.
+	RedactPartial = "partial"
+	// RedactHMAC replaces the sensitive data with its HMAC-SHA256, keyed by a
+	// secret, so that the same values still give the same pseudonyms.
+	RedactHMAC = "hmac"
+)
+
+const (
+	// TruncateCut cuts the messages at the maximum length.
+	TruncateCut = "cut"
+	// TruncateMarker cuts the messages, and ends them with a marker.
+	TruncateMarker = "marker"
+	// TruncateDrop drops the messages that are too long.
+	TruncateDrop = "drop"
+)
+
+const (
+	// SequenceTxnr checks the RELP transaction numbers of each connection.
+	SequenceTxnr = "txnr"
+	// SequenceKafka checks the offsets of each Kafka topic and partition.
+	SequenceKafka = "kafka"
+)
+
+// RedactionPatterns are the builtin patterns for the redactions.
+var RedactionPatterns = map[string]string{
+	"creditcard": `\b(?:\d[ -]?){12,18}\d\b`,
+	"email":      `[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`,
+	"ipv4":       `\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`,
+	"ipv6":       `\b(?:[0-9a-fA-F]{1,4}:){2,7}(?::|[0-9a-fA-F]{1,4})\b`,
+}
+
+// RateLimitRuleConfig limits the rate of the messages that share the same key.
+// The key is a template evaluated on each message, like "{{.Client}}" or
+// "{{.AppName}}-{{.Facility}}". The messages over the limit are dropped, or
+// tagged with the skewer:ratelimited property.
+type RateLimitRuleConfig struct {
+	Key            string  `mapstructure:"key" toml:"key" json:"key"`
+	MessagesPerSec float64 `mapstructure:"max_messages_per_sec" toml:"max_messages_per_sec" json:"max_messages_per_sec"`
+	// Burst is the number of messages that can exceed the rate at once. It
+	// defaults to one second worth of messages.
+	Burst  float64 `mapstructure:"burst" toml:"burst" json:"burst"`
+	Action string  `mapstructure:"action" toml:"action" json:"action"`
+}
+
+// SampleConfig keeps only a part of the messages: one in OneIn messages, or
+// Percent percents of the messages. When Severity is set, only the messages
+// with those severities are sampled. Without Key, the messages are kept at
+// random. With Key, a template like "{{.AppName}}-{{.Severity}}", the
+// messages are counted by key, and each key keeps its share of messages.
+type SampleConfig struct {
+	OneIn    uint64   `mapstructure:"one_in" toml:"one_in" json:"one_in"`
+	Percent  float64  `mapstructure:"percent" toml:"percent" json:"percent"`
+	Key      string   `mapstructure:"key" toml:"key" json:"key"`
+	Severity []string `mapstructure:"severity" toml:"severity" json:"severity"`
+}
+
+// Ratio returns the part of the messages that are kept.
+func (c *SampleConfig) Ratio() float64 {
+	if c.OneIn > 0 {
+		return 1 / float64(c.OneIn)
+	}
+	return c.Percent / 100
+}
+
+// RedactionConfig masks the sensitive data in the messages. Pattern is a
+// regular expression, or the name of a builtin pattern (creditcard, email,
+// ipv4, ipv6). Without pattern, the whole fields are masked. Fields are
+// message (the default), hostname, appname, procid, msgid, structured, or
+// domain:key for a property.
+type RedactionConfig struct {
+	Pattern  string   `mapstructure:"pattern" toml:"pattern" json:"pattern"`
+	Fields   []string `mapstructure:"fields" toml:"fields" json:"fields"`
+	Strategy string   `mapstructure:"strategy" toml:"strategy" json:"strategy"`
+	// Replacement is the string used by the fixed strategy.
+	Replacement string `mapstructure:"replacement" toml:"replacement" json:"replacement"`
+	// Keep is the number of characters left by the partial strategy.
+	Keep int `mapstructure:"keep" toml:"keep" json:"keep"`
+	// KeyFile contains the secret key of the hmac strategy.
+	KeyFile string `mapstructure:"key_file" toml:"key_file" json:"key_file,omitempty"`
+}
+
+// Regexp returns the regular expression of the redaction.
+func (c *RedactionConfig) Regexp() string {
+	if expr, ok := RedactionPatterns[strings.ToLower(c.Pattern)]; ok {
+		return expr
+	}
+	return c.Pattern
+}
+
+// GeoIPConfig gives the MaxMind databases used to enrich the messages with
+// the location of the IP addresses, like GeoLite2-City and GeoLite2-ASN. The
+// databases are reopened when the files change.
+type GeoIPConfig struct {
+	Databases   []string      `mapstructure:"databases" toml:"databases" json:"databases"`
+	CheckPeriod time.Duration `mapstructure:"check_period" toml:"check_period" json:"check_period"`
+}
+
+// TransformConfig reduces the messages before they are stored. Rename maps
+// domain:key properties to new names. Remove drops the hostname, appname,
+// procid, msgid or structured fields, domain:key properties, or whole property
+// domains. When Keep is set, only the listed properties (domain:key) or
+// property domains are kept.
+type TransformConfig struct {
+	Rename map[string]string `mapstructure:"rename" toml:"rename" json:"rename"`
+	Remove []string          `mapstructure:"remove" toml:"remove" json:"remove"`
+	Keep   []string          `mapstructure:"keep" toml:"keep" json:"keep"`
+}
+
+// TransformFields are the message fields that a transform can remove.
+var TransformFields = map[string]bool{
+	"hostname":   true,
+	"appname":    true,
+	"procid":     true,
+	"msgid":      true,
+	"structured": true,
+}
+
+// SplitProperty splits a property name like domain:key. The key is empty when
+// the name is a whole domain.
+func SplitProperty(name string) (domain, key string) {
+	colon := strings.Index(name, ":")
+	if colon == -1 {
+		return name, ""
+	}
+	return name[:colon], name[colon+1:]
+}
+
+func checkPropertyName(name string, wholeDomain bool) error {
+	domain, key := SplitProperty(name)
+	if len(domain) == 0 || (len(key) == 0 && (!wholeDomain || strings.Contains(name, ":"))) {
+		return eerrors.Errorf("Invalid property name: '%s'", name)
+	}
+	return nil
+}
+
+// JSONSchemaConfig validates a field of the messages, the message by default,
+// against the JSON Schema in the Schema file. The invalid messages are tagged
+// with the skewer:schema_invalid property, dropped, or routed to the
+// destinations in Destination, like a dead-letter destination.
+type JSONSchemaConfig struct {
+	Schema      string   `mapstructure:"schema" toml:"schema" json:"schema"`
+	Field       string   `mapstructure:"field" toml:"field" json:"field"`
+	Action      string   `mapstructure:"action" toml:"action" json:"action"`
+	Destination []string `mapstructure:"destination" toml:"destination" json:"destination"`
+}
+
+// GetDestinations returns the destinations of the invalid messages.
+func (c *JSONSchemaConfig) GetDestinations() (DestinationType, error) {
+	return parseDestinations(c.Destination)
+}
+
+// Compile reads the Schema file and resolves its references. Only the
+// draft-07 and 2020-12 dialects are supported, and the $ref to other schemas
+// must point to local files.
+func (c *JSONSchemaConfig) Compile() (*jsonschema.Resolved, error) {
+	path, err := filepath.Abs(c.Schema)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Invalid JSON Schema path '%s'", c.Schema)
+	}
+	base := &url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	schema, err := loadSchemaFile(base)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Invalid JSON Schema '%s'", c.Schema)
+	}
+	switch strings.TrimSuffix(schema.Schema, "#") {
+	case "", "http://json-schema.org/draft-07/schema", "https://json-schema.org/draft-07/schema", "https://json-schema.org/draft/2020-12/schema":
+	default:
+		return nil, eerrors.Errorf("Unsupported JSON Schema dialect '%s' in '%s'", schema.Schema, c.Schema)
+	}
+	resolved, err := schema.Resolve(&jsonschema.ResolveOptions{BaseURI: base.String(), Loader: loadSchemaFile})
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Invalid JSON Schema '%s'", c.Schema)
+	}
+	return resolved, nil
+}
+
+func loadSchemaFile(uri *url.URL) (*jsonschema.Schema, error) {
+	if uri.Scheme != "file" {
+		return nil, eerrors.Errorf("Only local JSON Schema references are supported: '%s'", uri.String())
+	}
+	content, err := ioutil.ReadFile(filepath.FromSlash(uri.Path))
+	if err != nil {
+		return nil, err
+	}
+	schema := new(jsonschema.Schema)
+	err = json.Unmarshal(content, schema)
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// TopicRuleConfig sends the messages that match the When expression to the
+// Topic, a template like topic_tmpl. The first matching rule wins.
+type TopicRuleConfig struct {
+	When  string `mapstructure:"when" toml:"when" json:"when"`
+	Topic string `mapstructure:"topic" toml:"topic" json:"topic"`
+}
+
+// DestinationRuleConfig sends the messages that match the When expression
+// only to the listed destinations. The first matching rule wins.
+type DestinationRuleConfig struct {
+	When        string   `mapstructure:"when" toml:"when" json:"when"`
+	Destination []string `mapstructure:"destination" toml:"destination" json:"destination"`
+}
+
+// GetDestinations returns the destinations where the matching messages are sent.
+func (r *DestinationRuleConfig) GetDestinations() (DestinationType, error) {
+	return parseDestinations(r.Destination)
+}
+
+// ReverseDNSConfig resolves an IP address to a host name, that is set as the
+// Key property in the reversedns domain. IP is a template that gives the
+// address, like "{{.Client}}" (the default) or `{{.GetProperty "app" "ip"}}`.
+type ReverseDNSConfig struct {
+	Key string `mapstructure:"key" toml:"key" json:"key"`
+	IP  string `mapstructure:"ip" toml:"ip" json:"ip"`
+}
+
+// LookupConfig enriches the messages with the result of a lookup in an
+// external service, by a Key template like "{{.HostName}}". The key replaces
+// {key} in the URL of an HTTP service, or is the name of a Redis key, after
+// KeyPrefix. The fields of a JSON object (or of a Redis hash) are set as
+// properties in the Domain, and the other results as the Name property.
+//
+// The lookups are done when the messages are received, so the results are
+// cached, and the lookups that take longer than Timeout are abandoned.
+type LookupConfig struct {
+	Key           string        `mapstructure:"key" toml:"key" json:"key"`
+	Domain        string        `mapstructure:"domain" toml:"domain" json:"domain"`
+	Name          string        `mapstructure:"name" toml:"name" json:"name"`
+	URL           string        `mapstructure:"url" toml:"url" json:"url"`
+	Redis         string        `mapstructure:"redis" toml:"redis" json:"redis"`
+	RedisPassword string        `mapstructure:"redis_password" toml:"redis_password" json:"redis_password"`
+	RedisDatabase int           `mapstructure:"redis_database" toml:"redis_database" json:"redis_database"`
+	KeyPrefix     string        `mapstructure:"key_prefix" toml:"key_prefix" json:"key_prefix"`
+	Timeout       time.Duration `mapstructure:"timeout" toml:"timeout" json:"timeout"`
+	CacheSize     int           `mapstructure:"cache_size" toml:"cache_size" json:"cache_size"`
+	CacheTTL      time.Duration `mapstructure:"cache_ttl" toml:"cache_ttl" json:"cache_ttl"`
+	NegativeTTL   time.Duration `mapstructure:"negative_ttl" toml:"negative_ttl" json:"negative_ttl"`
+}
+
+func (c *LookupConfig) check() error {
+	c.Key = strings.TrimSpace(c.Key)
+	c.URL = strings.TrimSpace(c.URL)
+	c.Redis = strings.TrimSpace(c.Redis)
+	if len(c.Key) == 0 {
+		return eerrors.New("key is empty")
+	}
+	_, err := templates.New("lookup").Parse(c.Key)
+	if err != nil {
+		return eerrors.Wrap(err, "Error compiling the key template")
+	}
+	if (len(c.URL) == 0) == (len(c.Redis) == 0) {
+		return eerrors.New("exactly one of url and redis must be set")
+	}
+	if len(c.URL) > 0 {
+		u, err := url.Parse(strings.Replace(c.URL, "{key}", "key", -1))
+		if err != nil {
+			return eerrors.Wrapf(err, "Invalid URL '%s'", c.URL)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return eerrors.Errorf("Invalid URL scheme '%s'", u.Scheme)
+		}
+	}
+	c.Domain = strings.TrimSpace(c.Domain)
+	if len(c.Domain) == 0 {
+		c.Domain = "lookup"
+	}
+	c.Name = strings.TrimSpace(c.Name)
+	if len(c.Name) == 0 {
+		c.Name = "value"
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.CacheSize == 0 {
+		c.CacheSize = 10000
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = 5 * time.Minute
+	}
+	if c.NegativeTTL == 0 {
+		c.NegativeTTL = time.Minute
+	}
+	return nil
+}
+
+// DNSCacheConfig configures the cache of the reverse DNS resolutions.
+type DNSCacheConfig struct {
+	Size        int           `mapstructure:"size" toml:"size" json:"size"`
+	TTL         time.Duration `mapstructure:"ttl" toml:"ttl" json:"ttl"`
+	NegativeTTL time.Duration `mapstructure:"negative_ttl" toml:"negative_ttl" json:"negative_ttl"`
+	Timeout     time.Duration `mapstructure:"timeout" toml:"timeout" json:"timeout"`
+}
+
+// CheckGeoIP checks the GeoIP databases.
+func (c *BaseConfig) CheckGeoIP() error {
+	for _, path := range c.GeoIP.Databases {
+		_, err := os.Stat(path)
+		if err != nil {
+			return confCheckError(eerrors.Wrapf(err, "GeoIP database '%s' is not accessible", path))
+		}
+	}
+	if c.GeoIP.CheckPeriod <= 0 {
+		c.GeoIP.CheckPeriod = 30 * time.Second
+	}
+	if len(c.GeoIP.Databases) > 0 {
+		return nil
+	}
+	for _, source := range c.Sources() {
+		filterConf := source.FilterConf()
+		if filterConf != nil && len(filterConf.GeoIPFields) > 0 {
+			return confCheckError(eerrors.New("geoip_fields is set for a source, but there is no GeoIP database"))
+		}
+	}
+	return nil
+}
+
+// CheckFilters checks the filter stages and sets their default values.
+func (c *FilterSubConfig) CheckFilters() error {
+	c.MinSeverity = strings.TrimSpace(strings.ToLower(c.MinSeverity))
+	c.MaxSeverity = strings.TrimSpace(strings.ToLower(c.MaxSeverity))
+	for i := range c.Facility {
+		c.Facility[i] = strings.TrimSpace(strings.ToLower(c.Facility[i]))
+	}
+	for k := range c.Tags {
+		if len(strings.TrimSpace(k)) == 0 {
+			return eerrors.New("Empty tag name")
+		}
+	}
+	for i := range c.Samples {
+		sc := &c.Samples[i]
+		if sc.OneIn > 0 && sc.Percent > 0 {
+			return eerrors.Errorf("Sample %d: one_in and percent are mutually exclusive", i+1)
+		}
+		if sc.OneIn == 0 && (sc.Percent <= 0 || sc.Percent > 100) {
+			return eerrors.Errorf("Sample %d: one_in must be positive, or percent must be between 0 and 100", i+1)
+		}
+		sc.Key = strings.TrimSpace(sc.Key)
+		if len(sc.Key) > 0 {
+			_, err := templates.New("sample").Parse(sc.Key)
+			if err != nil {
+				return eerrors.Wrapf(err, "Error compiling the key template of sample %d", i+1)
+			}
+		}
+		for j := range sc.Severity {
+			sc.Severity[j] = strings.TrimSpace(strings.ToLower(sc.Severity[j]))
+		}
+	}
+	for i := range c.Redactions {
+		rc := &c.Redactions[i]
+		rc.Pattern = strings.TrimSpace(rc.Pattern)
+		_, err := regexp.Compile(rc.Regexp())
+		if err != nil {
+			return eerrors.Wrapf(err, "Invalid regular expression in redaction %d", i+1)
+		}
+		if len(rc.Fields) == 0 {
+			rc.Fields = []string{"message"}
+		}
+		for j := range rc.Fields {
+			rc.Fields[j] = strings.TrimSpace(rc.Fields[j])
+			switch strings.ToLower(rc.Fields[j]) {
+			case "message", "hostname", "appname", "procid", "msgid", "structured":
+				rc.Fields[j] = strings.ToLower(rc.Fields[j])
+			default:
+				if !strings.Contains(rc.Fields[j], ":") {
+					return eerrors.Errorf("Redaction %d: unknown field '%s'", i+1, rc.Fields[j])
+				}
+			}
+		}
+		rc.Strategy = strings.TrimSpace(strings.ToLower(rc.Strategy))
+		switch rc.Strategy {
+		case "":
+			rc.Strategy = RedactFixed
+		case RedactFixed, RedactHash, RedactPartial:
+		case RedactHMAC:
+			rc.KeyFile = strings.TrimSpace(rc.KeyFile)
+			if len(rc.KeyFile) == 0 {
+				return eerrors.Errorf("Redaction %d: the hmac strategy needs a key_file", i+1)
+			}
+			_, err := os.Stat(rc.KeyFile)
+			if err != nil {
+				return eerrors.Wrapf(err, "Redaction %d: failed to stat the key file '%s'", i+1, rc.KeyFile)
+			}
+		default:
+			return eerrors.Errorf("Redaction %d: unknown strategy '%s'", i+1, rc.Strategy)
+		}
+		if len(rc.Replacement) == 0 {
+			rc.Replacement = "[REDACTED]"
+		}
+		if rc.Keep < 0 {
+			rc.Keep = 0
+		}
+	}
+	for i := range c.GeoIPFields {
+		c.GeoIPFields[i] = strings.TrimSpace(c.GeoIPFields[i])
+		if c.GeoIPFields[i] != "client" && !strings.Contains(c.GeoIPFields[i], ":") {
+			return eerrors.Errorf("Unknown GeoIP field: '%s'", c.GeoIPFields[i])
+		}
+	}
+	if c.MaxMessageLength < 0 {
+		return eerrors.New("max_message_length is negative")
+	}
+	c.TruncateStrategy = strings.TrimSpace(strings.ToLower(c.TruncateStrategy))
+	switch c.TruncateStrategy {
+	case "":
+		if c.MaxMessageLength > 0 {
+			c.TruncateStrategy = TruncateMarker
+		}
+	case TruncateCut, TruncateMarker, TruncateDrop:
+	default:
+		return eerrors.Errorf("Unknown truncate strategy: '%s'", c.TruncateStrategy)
+	}
+	if c.TruncateStrategy == TruncateMarker {
+		if len(c.TruncateMarker) == 0 {
+			c.TruncateMarker = "..."
+		}
+		if len(c.TruncateMarker) >= c.MaxMessageLength {
+			return eerrors.New("truncate_marker is longer than max_message_length")
+		}
+	}
+	c.HostnameMap = strings.TrimSpace(c.HostnameMap)
+	if len(c.HostnameMap) > 0 {
+		_, err := os.Stat(c.HostnameMap)
+		if err != nil {
+			return eerrors.Wrapf(err, "Failed to stat the hostname map '%s'", c.HostnameMap)
+		}
+	}
+	if c.MaxFuture < 0 {
+		return eerrors.New("max_future is negative")
+	}
+	c.SequenceCheck = strings.TrimSpace(c.SequenceCheck)
+	switch strings.ToLower(c.SequenceCheck) {
+	case "":
+	case SequenceTxnr, SequenceKafka:
+		c.SequenceCheck = strings.ToLower(c.SequenceCheck)
+	default:
+		err := checkPropertyName(c.SequenceCheck, false)
+		if err != nil {
+			return eerrors.Wrap(err, "Invalid sequence_check")
+		}
+	}
+	if c.SequenceDelay < 0 {
+		return eerrors.New("sequence_delay is negative")
+	}
+	if len(c.SequenceCheck) > 0 && c.SequenceDelay == 0 {
+		c.SequenceDelay = 5 * time.Second
+	}
+	if c.JSONSchema != nil {
+		err := c.JSONSchema.check()
+		if err != nil {
+			return err
+		}
+	}
+	c.FilterExpr = strings.TrimSpace(c.FilterExpr)
+	if len(c.FilterExpr) > 0 {
+		_, err := expr.Compile(c.FilterExpr)
+		if err != nil {
+			return eerrors.Wrap(err, "Invalid filter_expr")
+		}
+	}
+	for i := range c.TopicRules {
+		rule := &c.TopicRules[i]
+		_, err := expr.Compile(rule.When)
+		if err != nil {
+			return eerrors.Wrapf(err, "Invalid expression in topic rule %d", i+1)
+		}
+		_, err = templates.New("topic").Parse(rule.Topic)
+		if err != nil {
+			return eerrors.Wrapf(err, "Error compiling the topic template of topic rule %d", i+1)
+		}
+	}
+	for i := range c.DestinationRules {
+		rule := &c.DestinationRules[i]
+		_, err := expr.Compile(rule.When)
+		if err != nil {
+			return eerrors.Wrapf(err, "Invalid expression in destination rule %d", i+1)
+		}
+		dests, err := rule.GetDestinations()
+		if err != nil {
+			return err
+		}
+		if dests == 0 {
+			return eerrors.Errorf("Destination rule %d has no destination", i+1)
+		}
+	}
+	for i := range c.Transforms {
+		tc := &c.Transforms[i]
+		for from, to := range tc.Rename {
+			if checkPropertyName(from, false) != nil || checkPropertyName(to, false) != nil {
+				return eerrors.Errorf("Transform %d: invalid rename from '%s' to '%s'", i+1, from, to)
+			}
+		}
+		for j, name := range tc.Remove {
+			name = strings.TrimSpace(name)
+			tc.Remove[j] = name
+			if TransformFields[strings.ToLower(name)] {
+				continue
+			}
+			err := checkPropertyName(name, true)
+			if err != nil {
+				return eerrors.Wrapf(err, "Transform %d", i+1)
+			}
+		}
+		for j, name := range tc.Keep {
+			name = strings.TrimSpace(name)
+			tc.Keep[j] = name
+			err := checkPropertyName(name, true)
+			if err != nil {
+				return eerrors.Wrapf(err, "Transform %d", i+1)
+			}
+		}
+	}
+	for i := range c.ReverseDNS {
+		rc := &c.ReverseDNS[i]
+		rc.Key = strings.TrimSpace(rc.Key)
+		rc.IP = strings.TrimSpace(rc.IP)
+		if len(rc.IP) == 0 {
+			rc.IP = "{{.Client}}"
+		}
+		if len(rc.Key) == 0 {
+			if rc.IP != "{{.Client}}" {
+				return eerrors.Errorf("Reverse DNS %d: key is empty", i+1)
+			}
+			rc.Key = "client"
+		}
+		_, err := templates.New("reversedns").Parse(rc.IP)
+		if err != nil {
+			return eerrors.Wrapf(err, "Error compiling the IP template of reverse DNS %d", i+1)
+		}
+	}
+	for i := range c.Lookups {
+		err := c.Lookups[i].check()
+		if err != nil {
+			return eerrors.Wrapf(err, "Lookup %d", i+1)
+		}
+	}
+	for i := range c.RateLimits {
+		rl := &c.RateLimits[i]
+		rl.Key = strings.TrimSpace(rl.Key)
+		if len(rl.Key) == 0 {
+			rl.Key = "{{.Client}}"
+		}
+		_, err := templates.New("ratelimit").Parse(rl.Key)
+		if err != nil {
+			return eerrors.Wrapf(err, "Error compiling the key template of rate limit %d", i+1)
+		}
+		if rl.MessagesPerSec <= 0 {
+			return eerrors.Errorf("Rate limit %d: max_messages_per_sec must be positive", i+1)
+		}
+		if rl.Burst <= 0 {
+			rl.Burst = rl.MessagesPerSec
+		}
+		rl.Action = strings.TrimSpace(strings.ToLower(rl.Action))
+		switch rl.Action {
+		case "":
+			rl.Action = FilterDrop
+		case FilterDrop, FilterTag:
+		default:
+			return eerrors.Errorf("Rate limit %d: unknown action '%s'", i+1, rl.Action)
+		}
+	}
+	return nil
+}
+
+func (c *JSONSchemaConfig) check() error {
+	c.Schema = strings.TrimSpace(c.Schema)
+	if len(c.Schema) == 0 {
+		return eerrors.New("The JSON Schema file is not set")
+	}
+	_, err := c.Compile()
+	if err != nil {
+		return err
+	}
+	c.Field = strings.TrimSpace(c.Field)
+	if len(c.Field) == 0 {
+		c.Field = "message"
+	}
+	c.Action = strings.TrimSpace(strings.ToLower(c.Action))
+	switch c.Action {
+	case "":
+		c.Action = FilterTag
+	case FilterDrop, FilterTag:
+	case FilterRoute:
+		dests, err := c.GetDestinations()
+		if err != nil {
+			return err
+		}
+		if dests == 0 {
+			return eerrors.New("The route action of the JSON Schema needs a destination")
+		}
+	default:
+		return eerrors.Errorf("Unknown JSON Schema action: '%s'", c.Action)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package conf
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// RouteConfig sends the messages that match all of its conditions only to the
+// listed destinations. An empty condition matches every message. The routes
+// are evaluated in order and the first matching route wins. The messages that
+// do not match any route are sent to every configured destination.
+type RouteConfig struct {
+	// Client is a regular expression matched against the client address.
+	Client   string   `mapstructure:"client" toml:"client" json:"client"`
+	Facility []string `mapstructure:"facility" toml:"facility" json:"facility"`
+	Severity []string `mapstructure:"severity" toml:"severity" json:"severity"`
+	// MinSeverity is the least severe level that matches, and MaxSeverity the
+	// most severe level.
+	MinSeverity string `mapstructure:"min_severity" toml:"min_severity" json:"min_severity"`
+	MaxSeverity string `mapstructure:"max_severity" toml:"max_severity" json:"max_severity"`
+	// AppName is a regular expression matched against the appname.
+	AppName string `mapstructure:"appname" toml:"appname" json:"appname"`
+	// Properties are conditions like "domain:key=regexp" on the message properties.
+	Properties  []string `mapstructure:"properties" toml:"properties" json:"properties"`
+	Destination []string `mapstructure:"destination" toml:"destination" json:"destination"`
+}
+
+// ParseProperty splits a property condition into domain, key and regular expression.
+func ParseProperty(cond string) (domain, key, expr string, err error) {
+	eq := strings.Index(cond, "=")
+	colon := strings.Index(cond, ":")
+	if eq == -1 || colon == -1 || colon > eq {
+		return "", "", "", eerrors.Errorf("Invalid property condition, expected domain:key=regexp: '%s'", cond)
+	}
+	domain = strings.TrimSpace(cond[:colon])
+	key = strings.TrimSpace(cond[colon+1 : eq])
+	expr = strings.TrimSpace(cond[eq+1:])
+	if len(domain) == 0 || len(key) == 0 {
+		return "", "", "", eerrors.Errorf("Invalid property condition, expected domain:key=regexp: '%s'", cond)
+	}
+	return domain, key, expr, nil
+}
+
+// GetDestinations returns the destinations where the matching messages are sent.
+func (r *RouteConfig) GetDestinations() (dests DestinationType, err error) {
+	return parseDestinations(r.Destination)
+}
+
+func parseDestinations(names []string) (dests DestinationType, err error) {
+	for _, elt := range names {
+		for _, name := range strings.Split(elt, ",") {
+			name = strings.TrimSpace(strings.ToLower(name))
+			if len(name) == 0 {
+				continue
+			}
+			d, ok := Destinations[name]
+			if !ok {
+				return 0, eerrors.WithTags(eerrors.New("Unknown destination type in route"), "destination", name)
+			}
+			dests = dests | d
+		}
+	}
+	return dests, nil
+}
+
+func (c *BaseConfig) CheckRoutes() error {
+	configured, err := c.Main.GetDestinations()
+	if err != nil {
+		return err
+	}
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		dests, err := route.GetDestinations()
+		if err != nil {
+			return confCheckError(err)
+		}
+		if dests == 0 {
+			return confCheckError(eerrors.Errorf("Route %d has no destination", i+1))
+		}
+		if dests&configured != dests {
+			return confCheckError(
+				eerrors.Errorf("Route %d uses a destination that is not configured in main.destination", i+1),
+			)
+		}
+		route.Client = strings.TrimSpace(route.Client)
+		route.AppName = strings.TrimSpace(route.AppName)
+		for _, expr := range []string{route.Client, route.AppName} {
+			_, err = regexp.Compile(expr)
+			if err != nil {
+				return confCheckError(eerrors.Wrapf(err, "Invalid regular expression in route %d", i+1))
+			}
+		}
+		for _, cond := range route.Properties {
+			_, _, expr, err := ParseProperty(cond)
+			if err != nil {
+				return confCheckError(err)
+			}
+			_, err = regexp.Compile(expr)
+			if err != nil {
+				return confCheckError(eerrors.Wrapf(err, "Invalid regular expression in route %d", i+1))
+			}
+		}
+		for j := range route.Facility {
+			route.Facility[j] = strings.TrimSpace(strings.ToLower(route.Facility[j]))
+		}
+		for j := range route.Severity {
+			route.Severity[j] = strings.TrimSpace(strings.ToLower(route.Severity[j]))
+		}
+		route.MinSeverity = strings.TrimSpace(strings.ToLower(route.MinSeverity))
+		route.MaxSeverity = strings.TrimSpace(strings.ToLower(route.MaxSeverity))
+	}
+	return nil
+}
@@ -2,55 +2,86 @@ package conf
 
 import (
 	"encoding/base64"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
+	sarama "github.com/Shopify/sarama"
 	"github.com/awnumar/memguard"
 	"github.com/spaolacci/murmur3"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
 	"github.com/stephane-martin/skewer/utils/sbox"
+	"github.com/stephane-martin/skewer/utils/templates"
 	"github.com/zond/gotomic"
 )
 
 // BaseConfig is the root of all configuration parameters.
 type BaseConfig struct {
-	FSSource            []FilesystemSourceConfig  `mapstructure:"fs_source" toml:"fs_source" json:"fs_source"`
-	TCPSource           []TCPSourceConfig         `mapstructure:"tcp_source" toml:"tcp_source" json:"tcp_source"`
-	UDPSource           []UDPSourceConfig         `mapstructure:"udp_source" toml:"udp_source" json:"udp_source"`
-	RELPSource          []RELPSourceConfig        `mapstructure:"relp_source" toml:"relp_source" json:"relp_source"`
-	HTTPServerSource    []HTTPServerSourceConfig  `mapstructure:"httpserver_source" toml:"httpserver_source" json:"httpserver_source"`
-	DirectRELPSource    []DirectRELPSourceConfig  `mapstructure:"directrelp_source" toml:"directrelp_source" json:"directrelp_source"`
-	KafkaSource         []KafkaSourceConfig       `mapstructure:"kafka_source" toml:"kafka_source" json:"kafka_source"`
-	GraylogSource       []GraylogSourceConfig     `mapstructure:"graylog_source" toml:"graylog_source" json:"graylog_source"`
-	Store               StoreConfig               `mapstructure:"store" toml:"store" json:"store"`
-	Parsers             []ParserConfig            `mapstructure:"parser" toml:"parser" json:"parser"`
-	Journald            JournaldConfig            `mapstructure:"journald" toml:"journald" json:"journald"`
-	Metrics             MetricsConfig             `mapstructure:"metrics" toml:"metrics" json:"metrics"`
-	Accounting          AccountingSourceConfig    `mapstructure:"accounting" toml:"accounting" json:"accounting"`
-	MacOS               MacOSSourceConfig         `mapstructure:"macos" toml:"macos" json:"macos"`
-	Main                MainConfig                `mapstructure:"main" toml:"main" json:"main"`
-	KafkaDest           *KafkaDestConfig          `mapstructure:"kafka_destination" toml:"kafka_destination" json:"kafka_destination"`
-	UDPDest             UDPDestConfig             `mapstructure:"udp_destination" toml:"udp_destination" json:"udp_destination"`
-	TCPDest             TCPDestConfig             `mapstructure:"tcp_destination" toml:"tcp_destination" json:"tcp_destination"`
-	HTTPDest            HTTPDestConfig            `mapstructure:"http_destination" toml:"http_destination" json:"http_destination"`
-	HTTPServerDest      HTTPServerDestConfig      `mapstructure:"httpserver_destination" toml:"httpserver_destination" json:"httpserver_destination"`
-	WebsocketServerDest WebsocketServerDestConfig `mapstructure:"websocketserver_destination" toml:"websocketserver_destination" json:"websocketserver_destination"`
-	NATSDest            *NATSDestConfig           `mapstructure:"nats_destination" toml:"nats_destination" json:"nats_destination"`
-	RELPDest            RELPDestConfig            `mapstructure:"relp_destination" toml:"relp_destination" json:"relp_destination"`
-	FileDest            FileDestConfig            `mapstructure:"file_destination" toml:"file_destination" json:"file_destination"`
-	StderrDest          StderrDestConfig          `mapstructure:"stderr_destination" toml:"stderr_destination" json:"stderr_destination"`
-	GraylogDest         GraylogDestConfig         `mapstructure:"graylog_destination" toml:"graylog_destination" json:"graylog_destination"`
-	ElasticDest         ElasticDestConfig         `mapstructure:"elasticsearch_destination" toml:"elasticsearch_destination" json:"elasticsearch_destination"`
-	RedisDest           RedisDestConfig           `mapstructure:"redis_destination" toml:"redis_destination" json:"redis_destination"`
+	FSSource             []FilesystemSourceConfig     `mapstructure:"fs_source" toml:"fs_source" json:"fs_source"`
+	TCPSource            []TCPSourceConfig            `mapstructure:"tcp_source" toml:"tcp_source" json:"tcp_source"`
+	UDPSource            []UDPSourceConfig            `mapstructure:"udp_source" toml:"udp_source" json:"udp_source"`
+	RELPSource           []RELPSourceConfig           `mapstructure:"relp_source" toml:"relp_source" json:"relp_source"`
+	HTTPServerSource     []HTTPServerSourceConfig     `mapstructure:"httpserver_source" toml:"httpserver_source" json:"httpserver_source"`
+	DirectRELPSource     []DirectRELPSourceConfig     `mapstructure:"directrelp_source" toml:"directrelp_source" json:"directrelp_source"`
+	KafkaSource          []KafkaSourceConfig          `mapstructure:"kafka_source" toml:"kafka_source" json:"kafka_source"`
+	GraylogSource        []GraylogSourceConfig        `mapstructure:"graylog_source" toml:"graylog_source" json:"graylog_source"`
+	ExecSource           []ExecSourceConfig           `mapstructure:"exec_source" toml:"exec_source" json:"exec_source"`
+	PubSubSource         []PubSubSourceConfig         `mapstructure:"pubsub_source" toml:"pubsub_source" json:"pubsub_source"`
+	JournalGatewaySource []JournalGatewaySourceConfig `mapstructure:"journal_gateway_source" toml:"journal_gateway_source" json:"journal_gateway_source"`
+	Store                StoreConfig                  `mapstructure:"store" toml:"store" json:"store"`
+	Parsers              []ParserConfig               `mapstructure:"parser" toml:"parser" json:"parser"`
+	Routes               []RouteConfig                `mapstructure:"route" toml:"route" json:"route"`
+	Journald             JournaldConfig               `mapstructure:"journald" toml:"journald" json:"journald"`
+	Metrics              MetricsConfig                `mapstructure:"metrics" toml:"metrics" json:"metrics"`
+	Accounting           AccountingSourceConfig       `mapstructure:"accounting" toml:"accounting" json:"accounting"`
+	MacOS                MacOSSourceConfig            `mapstructure:"macos" toml:"macos" json:"macos"`
+	Kubernetes           KubernetesSourceConfig       `mapstructure:"kubernetes" toml:"kubernetes" json:"kubernetes"`
+	Stdin                StdinSourceConfig            `mapstructure:"stdin" toml:"stdin" json:"stdin"`
+	CEF                  CEFConfig                    `mapstructure:"cef" toml:"cef" json:"cef"`
+	LEEF                 LEEFConfig                   `mapstructure:"leef" toml:"leef" json:"leef"`
+	FlatJSON             FlatJSONConfig               `mapstructure:"flatjson" toml:"flatjson" json:"flatjson"`
+	CSV                  CSVConfig                    `mapstructure:"csv" toml:"csv" json:"csv"`
+	Templates            map[string]string            `mapstructure:"templates" toml:"templates" json:"templates"`
+	GeoIP                GeoIPConfig                  `mapstructure:"geoip" toml:"geoip" json:"geoip"`
+	DNSCache             DNSCacheConfig               `mapstructure:"dns_cache" toml:"dns_cache" json:"dns_cache"`
+	Main                 MainConfig                   `mapstructure:"main" toml:"main" json:"main"`
+	KafkaDest            *KafkaDestConfig             `mapstructure:"kafka_destination" toml:"kafka_destination" json:"kafka_destination"`
+	UDPDest              UDPDestConfig                `mapstructure:"udp_destination" toml:"udp_destination" json:"udp_destination"`
+	TCPDest              TCPDestConfig                `mapstructure:"tcp_destination" toml:"tcp_destination" json:"tcp_destination"`
+	HTTPDest             HTTPDestConfig               `mapstructure:"http_destination" toml:"http_destination" json:"http_destination"`
+	HTTPServerDest       HTTPServerDestConfig         `mapstructure:"httpserver_destination" toml:"httpserver_destination" json:"httpserver_destination"`
+	WebsocketServerDest  WebsocketServerDestConfig    `mapstructure:"websocketserver_destination" toml:"websocketserver_destination" json:"websocketserver_destination"`
+	WebsocketClientDest  WebsocketClientDestConfig    `mapstructure:"websocketclient_destination" toml:"websocketclient_destination" json:"websocketclient_destination"`
+	SyslogTLSDest        SyslogTLSDestConfig          `mapstructure:"syslogtls_destination" toml:"syslogtls_destination" json:"syslogtls_destination"`
+	NullDest             NullDestConfig               `mapstructure:"null_destination" toml:"null_destination" json:"null_destination"`
+	PromRemoteWriteDest  PromRemoteWriteDestConfig    `mapstructure:"promremotewrite_destination" toml:"promremotewrite_destination" json:"promremotewrite_destination"`
+	AlertDest            AlertDestConfig              `mapstructure:"alert_destination" toml:"alert_destination" json:"alert_destination"`
+	NATSDest             *NATSDestConfig              `mapstructure:"nats_destination" toml:"nats_destination" json:"nats_destination"`
+	RELPDest             RELPDestConfig               `mapstructure:"relp_destination" toml:"relp_destination" json:"relp_destination"`
+	FileDest             FileDestConfig               `mapstructure:"file_destination" toml:"file_destination" json:"file_destination"`
+	StderrDest           StderrDestConfig             `mapstructure:"stderr_destination" toml:"stderr_destination" json:"stderr_destination"`
+	GraylogDest          GraylogDestConfig            `mapstructure:"graylog_destination" toml:"graylog_destination" json:"graylog_destination"`
+	ElasticDest          ElasticDestConfig            `mapstructure:"elasticsearch_destination" toml:"elasticsearch_destination" json:"elasticsearch_destination"`
+	RedisDest            RedisDestConfig              `mapstructure:"redis_destination" toml:"redis_destination" json:"redis_destination"`
+	AzureBlobDest        AzureBlobDestConfig          `mapstructure:"azureblob_destination" toml:"azureblob_destination" json:"azureblob_destination"`
+	MongoDBDest          MongoDBDestConfig            `mapstructure:"mongodb_destination" toml:"mongodb_destination" json:"mongodb_destination"`
+	NSQDest              NSQDestConfig                `mapstructure:"nsq_destination" toml:"nsq_destination" json:"nsq_destination"`
+	PulsarDest           PulsarDestConfig             `mapstructure:"pulsar_destination" toml:"pulsar_destination" json:"pulsar_destination"`
+	SQSDest              SQSDestConfig                `mapstructure:"sqs_destination" toml:"sqs_destination" json:"sqs_destination"`
 }
 
 // MainConfig lists general/global parameters.
 type MainConfig struct {
 	InputQueueSize      uint64 `mapstructure:"input_queue_size" toml:"input_queue_size" json:"input_queue_size"`
 	MaxInputMessageSize int    `mapstructure:"max_input_message_size" toml:"max_input_message_size" json:"max_input_message_size"`
-	Destination         string `mapstructure:"destination" toml:"destination" json:"destination"`
-	EncryptIPC          bool   `mapstructure:"encrypt_ipc" toml:"encrypt_ipc" json:"encrypt_ipc"`
+	// Destination lists the destinations where every message is sent. The
+	// legacy comma-separated string is accepted too.
+	Destination []string `mapstructure:"destination" toml:"destination" json:"destination"`
+	EncryptIPC  bool     `mapstructure:"encrypt_ipc" toml:"encrypt_ipc" json:"encrypt_ipc"`
 }
 
 type MetricsConfig struct {
@@ -58,25 +89,131 @@ type MetricsConfig struct {
 	Port int    `mapstructure:"port" toml:"port" json:"port"`
 }
 
+// CEFConfig configures the cef format. Extensions map CEF extension keys to
+// message properties, as "key=domain:key". When AllProperties is set, the
+// properties that are not mapped are added as "domain_key" extensions.
+type CEFConfig struct {
+	DeviceVendor  string   `mapstructure:"device_vendor" toml:"device_vendor" json:"device_vendor"`
+	DeviceProduct string   `mapstructure:"device_product" toml:"device_product" json:"device_product"`
+	DeviceVersion string   `mapstructure:"device_version" toml:"device_version" json:"device_version"`
+	Extensions    []string `mapstructure:"extensions" toml:"extensions" json:"extensions"`
+	AllProperties bool     `mapstructure:"all_properties" toml:"all_properties" json:"all_properties"`
+}
+
+// LEEFConfig configures the leef format. Attributes map LEEF attribute keys
+// to message fields (hostname, appname, procid, msgid, facility, severity,
+// message) or to message properties, as "key=domain:key". When AllProperties
+// is set, the properties that are not mapped are added as "domain_key"
+// attributes.
+type LEEFConfig struct {
+	Vendor        string   `mapstructure:"vendor" toml:"vendor" json:"vendor"`
+	Product       string   `mapstructure:"product" toml:"product" json:"product"`
+	Version       string   `mapstructure:"version" toml:"version" json:"version"`
+	Delimiter     string   `mapstructure:"delimiter" toml:"delimiter" json:"delimiter"`
+	Attributes    []string `mapstructure:"attributes" toml:"attributes" json:"attributes"`
+	AllProperties bool     `mapstructure:"all_properties" toml:"all_properties" json:"all_properties"`
+}
+
+// FlatJSONConfig configures the flatjson format. TimestampLayout is a Go time
+// layout, or one of "unix", "unixms" and "unixnano" for numeric timestamps.
+type FlatJSONConfig struct {
+	TimestampLayout string `mapstructure:"timestamp_layout" toml:"timestamp_layout" json:"timestamp_layout"`
+}
+
+// CSVConfig configures the csv format. Columns is the ordered list of the
+// message fields (timereported, timegenerated, hostname, appname, procid,
+// msgid, facility, severity, message, client, source_type, source_path, uid)
+// or message properties, as "domain:key", that make each record.
+type CSVConfig struct {
+	Columns         []string `mapstructure:"columns" toml:"columns" json:"columns"`
+	Separator       string   `mapstructure:"separator" toml:"separator" json:"separator"`
+	TimestampLayout string   `mapstructure:"timestamp_layout" toml:"timestamp_layout" json:"timestamp_layout"`
+}
+
 type WatcherConfig struct {
 	Filename string `mapstructure:"filename" toml:"filename" json:"filename"`
 	Whence   int    `mapstructure:"whence" toml:"whence" json:"whence"`
 }
 
+// ParserConfig defines a named parser, either as a JS function or a Lua
+// script (depending on Language, "javascript" by default, or "lua"), or as a
+// list of regular expressions that are tried in order. The named groups of the
+// first matching expression become the message fields (hostname, appname,
+// procid, msgid, facility, severity, timereported, message) or properties.
+// A parser may also be an external command: the raw messages are written to
+// its stdin and the parsed messages are read from its stdout, as
+// length-prefixed frames. Or it may be the Parse function of a Go plugin, or
+// the decode function of a WASM module.
 type ParserConfig struct {
-	Name string `mapstructure:"name" toml:"name" json:"name"`
-	Func string `mapstructure:"func" toml:"func" json:"func"`
+	Name     string   `mapstructure:"name" toml:"name" json:"name"`
+	Func     string   `mapstructure:"func" toml:"func" json:"func"`
+	Language string   `mapstructure:"language" toml:"language" json:"language"`
+	Regexps  []string `mapstructure:"regexps" toml:"regexps" json:"regexps"`
+	Command  []string `mapstructure:"command" toml:"command" json:"command"`
+	Plugin   string   `mapstructure:"plugin" toml:"plugin" json:"plugin"`
+	Wasm     string   `mapstructure:"wasm" toml:"wasm" json:"wasm"`
 }
 
 type StoreConfig struct {
-	Dirname          string `mapstructure:"-" toml:"-" json:"dirname"`
+	Dirname string `mapstructure:"-" toml:"-" json:"dirname"`
+	// Backend is the database that holds the store: BadgerBackend,
+	// BoltBackend or MemoryBackend.
+	Backend          string `mapstructure:"backend" toml:"backend" json:"backend"`
 	MaxTableSize     int64  `mapstructure:"max_table_size" toml:"max_table_size" json:"max_table_size"`
 	ValueLogFileSize int64  `mapstructure:"value_log_file_size" toml:"value_log_file_size" json:"value_log_file_size"`
 	FSync            bool   `mapstructure:"fsync" toml:"fsync" json:"fsync"`
 	Secret           string `mapstructure:"secret" toml:"-" json:"secret"`
-	BatchSize        uint32 `mapstructure:"batch_size" toml:"batch_size" json:"batch_size"`
-	AddMissingMsgID  bool   `mapstructure:"add_missing_msgid" toml:"add_missing_msgid" json:"add_missing_msgid"`
-}
+	// OldSecrets are the previous values of Secret. They are only used to
+	// read the messages that were stored before the secret was rotated.
+	OldSecrets      []string `mapstructure:"old_secrets" toml:"-" json:"old_secrets"`
+	BatchSize       uint32   `mapstructure:"batch_size" toml:"batch_size" json:"batch_size"`
+	AddMissingMsgID bool     `mapstructure:"add_missing_msgid" toml:"add_missing_msgid" json:"add_missing_msgid"`
+	// MaxAge and MaxMessages limit the messages waiting in the store. The
+	// messages older than MaxAge, and the oldest messages beyond
+	// MaxMessages, are deleted. They are written to DeadLetterFile first,
+	// when it is set.
+	MaxAge         time.Duration `mapstructure:"max_age" toml:"max_age" json:"max_age"`
+	MaxMessages    int           `mapstructure:"max_messages" toml:"max_messages" json:"max_messages"`
+	DeadLetterFile string        `mapstructure:"dead_letter_file" toml:"dead_letter_file" json:"dead_letter_file"`
+	// MaxDiskUsage limits the size of the store files on disk, in bytes.
+	// DiskUsagePolicy says what happens when the limit is exceeded.
+	MaxDiskUsage    int64  `mapstructure:"max_disk_usage" toml:"max_disk_usage" json:"max_disk_usage"`
+	DiskUsagePolicy string `mapstructure:"disk_usage_policy" toml:"disk_usage_policy" json:"disk_usage_policy"`
+	// the value log of badger is garbage collected every GCInterval. A value
+	// log file is rewritten when at least GCDiscardRatio of it is garbage.
+	GCInterval     time.Duration `mapstructure:"gc_interval" toml:"gc_interval" json:"gc_interval"`
+	GCDiscardRatio float64       `mapstructure:"gc_discard_ratio" toml:"gc_discard_ratio" json:"gc_discard_ratio"`
+	// the ACKs of a destination are written by batches of at most
+	// AckBatchSize, in a single transaction. The store waits up to
+	// AckLatency for a batch to fill up.
+	AckBatchSize uint32        `mapstructure:"ack_batch_size" toml:"ack_batch_size" json:"ack_batch_size"`
+	AckLatency   time.Duration `mapstructure:"ack_latency" toml:"ack_latency" json:"ack_latency"`
+}
+
+const (
+	// BadgerBackend is the default store backend.
+	BadgerBackend = "badger"
+	// BoltBackend stores the messages in a single BoltDB file. It needs
+	// much less memory than badger, and suits small hosts.
+	BoltBackend = "bolt"
+	// MemoryBackend keeps the messages in memory only. They are lost when
+	// skewer stops.
+	MemoryBackend = "memory"
+)
+
+// the memory store keeps DefaultMemoryMaxMessages messages at most when
+// max_messages is not set
+const DefaultMemoryMaxMessages = 100000
+
+const (
+	// DiskBlock stops the ingestion of new messages, so that the sources
+	// are blocked, until the store size is under the limit again.
+	DiskBlock = "block"
+	// DiskDropOldest evicts the oldest messages from the store.
+	DiskDropOldest = "drop_oldest"
+	// DiskDropNewest discards the new messages.
+	DiskDropNewest = "drop_newest"
+)
 
 // the Secret in StoreConfig will be encrypted with the session secret in Complete()
 // so we do not transport an unencrypted secret between the multiple skewer processes
@@ -90,7 +227,45 @@ func (s *StoreConfig) GetSecretB(m *memguard.LockedBuffer) (secretb *memguard.Lo
 		return nil, nil
 	}
 	defer locked.Destroy()
+	return decodeStoreSecret(locked)
+}
+
+// GetOldSecretsB returns the previous store secrets.
+func (s *StoreConfig) GetOldSecretsB(m *memguard.LockedBuffer) (secrets []*memguard.LockedBuffer, err error) {
+	for _, old := range s.OldSecrets {
+		locked, err := decryptSecret(old, m)
+		if err != nil {
+			return nil, err
+		}
+		if locked == nil {
+			continue
+		}
+		secretb, err := decodeStoreSecret(locked)
+		locked.Destroy()
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secretb)
+	}
+	return secrets, nil
+}
+
+// GetPlainSecretB returns the store secret when it has not been encrypted
+// with a session secret, as in the commands that open the store offline.
+func (s *StoreConfig) GetPlainSecretB() (*memguard.LockedBuffer, error) {
+	secret := strings.TrimSpace(s.Secret)
+	if len(secret) == 0 {
+		return nil, nil
+	}
+	locked, err := memguard.NewImmutableFromBytes([]byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	defer locked.Destroy()
+	return decodeStoreSecret(locked)
+}
 
+func decodeStoreSecret(locked *memguard.LockedBuffer) (secretb *memguard.LockedBuffer, err error) {
 	n := base64.URLEncoding.DecodedLen(len(locked.Buffer()))
 	if n < 32 {
 		return nil, confCheckError(eerrors.New("Store secret is too short"))
@@ -111,26 +286,41 @@ func (s *StoreConfig) GetSecretB(m *memguard.LockedBuffer) (secretb *memguard.Lo
 	return secretb, nil
 }
 
-func (s *StoreConfig) EncryptSecret(m *memguard.LockedBuffer) error {
-	secret := strings.TrimSpace(s.Secret)
-	if len(secret) == 0 {
-		s.Secret = ""
-		return nil
-	}
-	enc, err := sbox.Encrypt([]byte(secret), m)
+func (s *StoreConfig) EncryptSecret(m *memguard.LockedBuffer) (err error) {
+	s.Secret, err = encryptSecret(s.Secret, m)
 	if err != nil {
-		s.Secret = ""
 		return err
 	}
-	s.Secret = base64.StdEncoding.EncodeToString(enc)
+	for i := range s.OldSecrets {
+		s.OldSecrets[i], err = encryptSecret(s.OldSecrets[i], m)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (s *StoreConfig) DecryptSecret(m *memguard.LockedBuffer) (locked *memguard.LockedBuffer, err error) {
-	if len(s.Secret) == 0 {
+	return decryptSecret(s.Secret, m)
+}
+
+func encryptSecret(secret string, m *memguard.LockedBuffer) (string, error) {
+	secret = strings.TrimSpace(secret)
+	if len(secret) == 0 {
+		return "", nil
+	}
+	enc, err := sbox.Encrypt([]byte(secret), m)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(enc), nil
+}
+
+func decryptSecret(secret string, m *memguard.LockedBuffer) (locked *memguard.LockedBuffer, err error) {
+	if len(secret) == 0 {
 		return nil, nil
 	}
-	enc, err := base64.StdEncoding.DecodeString(s.Secret)
+	enc, err := base64.StdEncoding.DecodeString(secret)
 	if err != nil {
 		return nil, err
 	}
@@ -151,6 +341,168 @@ type KafkaDestConfig struct {
 	TlsBaseConfig           `mapstructure:",squash"`
 	Insecure                bool   `mapstructure:"insecure" toml:"insecure" json:"insecure"`
 	Format                  string `mapstructure:"format" toml:"format" json:"format"`
+	// Headers are "name=value" record headers attached to every message. The
+	// value is a text/template executed on the full message, for example
+	// "conf_id={{.ConfId}}" or "client={{.ClientAddr}}".
+	Headers []string `mapstructure:"headers" toml:"headers" json:"headers"`
+	// CreateTopics creates the missing topics with the following settings.
+	// TopicConfig are additional "key=value" topic configuration entries.
+	CreateTopics           bool          `mapstructure:"create_topics" toml:"create_topics" json:"create_topics"`
+	TopicPartitions        int32         `mapstructure:"topic_partitions" toml:"topic_partitions" json:"topic_partitions"`
+	TopicReplicationFactor int16         `mapstructure:"topic_replication_factor" toml:"topic_replication_factor" json:"topic_replication_factor"`
+	TopicRetention         time.Duration `mapstructure:"topic_retention" toml:"topic_retention" json:"topic_retention"`
+	TopicConfig            []string      `mapstructure:"topic_config" toml:"topic_config" json:"topic_config"`
+	// SchemaRegistryURL enables the Confluent wire format with the avro and
+	// fullavro formats: the schema is registered, and the messages are
+	// prefixed with its ID.
+	SchemaRegistryURL      string `mapstructure:"schema_registry_url" toml:"schema_registry_url" json:"schema_registry_url"`
+	SchemaRegistryUsername string `mapstructure:"schema_registry_username" toml:"schema_registry_username" json:"schema_registry_username"`
+	SchemaRegistryPassword string `mapstructure:"schema_registry_password" toml:"-" json:"schema_registry_password"`
+	SchemaRegistryInsecure bool   `mapstructure:"schema_registry_insecure" toml:"schema_registry_insecure" json:"schema_registry_insecure"`
+	// SubjectNameStrategy is topic, record or topic_record.
+	SubjectNameStrategy string `mapstructure:"subject_name_strategy" toml:"subject_name_strategy" json:"subject_name_strategy"`
+	// ExactlyOnce enables the idempotent producer, and sends each batch of
+	// messages from the store in a Kafka transaction. TransactionalID must be
+	// stable across restarts, so that the transaction left open by a crash
+	// is aborted: it defaults to the client ID and the hostname.
+	ExactlyOnce        bool          `mapstructure:"exactly_once" toml:"exactly_once" json:"exactly_once"`
+	TransactionalID    string        `mapstructure:"transactional_id" toml:"transactional_id" json:"transactional_id"`
+	TransactionTimeout time.Duration `mapstructure:"transaction_timeout" toml:"transaction_timeout" json:"transaction_timeout"`
+}
+
+const (
+	TopicNameStrategy       = "topic"
+	RecordNameStrategy      = "record"
+	TopicRecordNameStrategy = "topic_record"
+)
+
+// CheckSchemaRegistry checks the schema registry parameters of the Kafka destination.
+func (c *KafkaDestConfig) CheckSchemaRegistry() error {
+	c.SchemaRegistryURL = strings.TrimSpace(c.SchemaRegistryURL)
+	if len(c.SchemaRegistryURL) == 0 {
+		return nil
+	}
+	if !strings.HasPrefix(c.SchemaRegistryURL, "http://") && !strings.HasPrefix(c.SchemaRegistryURL, "https://") {
+		return confCheckError(eerrors.New("schema_registry_url must be a http:// or https:// URL"))
+	}
+	if c.Format != "avro" && c.Format != "fullavro" {
+		return confCheckError(eerrors.New("The schema registry needs the avro or fullavro format"))
+	}
+	c.SubjectNameStrategy = strings.TrimSpace(strings.ToLower(c.SubjectNameStrategy))
+	switch c.SubjectNameStrategy {
+	case TopicNameStrategy, RecordNameStrategy, TopicRecordNameStrategy:
+	default:
+		return confCheckError(eerrors.Errorf("Unknown subject name strategy: '%s'", c.SubjectNameStrategy))
+	}
+	return nil
+}
+
+// CheckExactlyOnce checks the requirements of the transactional producer.
+func (c *KafkaDestConfig) CheckExactlyOnce() error {
+	if !c.ExactlyOnce {
+		return nil
+	}
+	ver, err := ParseVersion(c.Version)
+	if err != nil {
+		return err
+	}
+	if !ver.IsAtLeast(sarama.V0_11_0_0) {
+		return confCheckError(eerrors.New("Kafka transactions need at least Kafka 0.11.0.0"))
+	}
+	if sarama.RequiredAcks(c.RequiredAcks) != sarama.WaitForAll {
+		return confCheckError(eerrors.New("The exactly_once mode needs required_acks = -1"))
+	}
+	if c.RetrySendMax < 1 {
+		return confCheckError(eerrors.New("The exactly_once mode needs retry_send_max >= 1"))
+	}
+	if c.TransactionTimeout <= 0 {
+		return confCheckError(eerrors.New("transaction_timeout must be positive"))
+	}
+	c.TransactionalID = strings.TrimSpace(c.TransactionalID)
+	if len(c.TransactionalID) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return confCheckError(eerrors.Wrap(err, "Failed to build the default transactional_id"))
+		}
+		c.TransactionalID = c.ClientID + "-" + hostname
+	}
+	return nil
+}
+
+// KafkaHeader is a parsed record header of the Kafka destination.
+type KafkaHeader struct {
+	Name  string
+	Value *template.Template
+}
+
+// GetTopicDetail returns the settings of the topics created by the Kafka
+// destination.
+func (c *KafkaDestConfig) GetTopicDetail() (*sarama.TopicDetail, error) {
+	if !c.CreateTopics {
+		return nil, nil
+	}
+	ver, err := ParseVersion(c.Version)
+	if err != nil {
+		return nil, err
+	}
+	if !ver.IsAtLeast(sarama.V0_10_1_0) {
+		return nil, confCheckError(eerrors.New("Creating topics needs at least Kafka 0.10.1.0"))
+	}
+	if c.TopicPartitions <= 0 {
+		return nil, confCheckError(eerrors.New("topic_partitions must be positive"))
+	}
+	if c.TopicReplicationFactor <= 0 {
+		return nil, confCheckError(eerrors.New("topic_replication_factor must be positive"))
+	}
+	detail := &sarama.TopicDetail{
+		NumPartitions:     c.TopicPartitions,
+		ReplicationFactor: c.TopicReplicationFactor,
+		ConfigEntries:     make(map[string]*string),
+	}
+	if c.TopicRetention > 0 {
+		retention := strconv.FormatInt(int64(c.TopicRetention/time.Millisecond), 10)
+		detail.ConfigEntries["retention.ms"] = &retention
+	}
+	for _, entry := range c.TopicConfig {
+		idx := strings.Index(entry, "=")
+		if idx <= 0 {
+			return nil, confCheckError(eerrors.Errorf("Invalid topic configuration entry, expected key=value: '%s'", entry))
+		}
+		value := strings.TrimSpace(entry[idx+1:])
+		detail.ConfigEntries[strings.TrimSpace(entry[:idx])] = &value
+	}
+	return detail, nil
+}
+
+// GetHeaders parses the record headers of the Kafka destination.
+func (c *KafkaDestConfig) GetHeaders() ([]KafkaHeader, error) {
+	if len(c.Headers) == 0 {
+		return nil, nil
+	}
+	ver, err := ParseVersion(c.Version)
+	if err != nil {
+		return nil, err
+	}
+	if !ver.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, confCheckError(eerrors.New("Kafka record headers need at least Kafka 0.11.0.0"))
+	}
+	headers := make([]KafkaHeader, 0, len(c.Headers))
+	for _, h := range c.Headers {
+		idx := strings.Index(h, "=")
+		if idx <= 0 {
+			return nil, confCheckError(eerrors.Errorf("Invalid Kafka header, expected name=value: '%s'", h))
+		}
+		name := strings.TrimSpace(h[:idx])
+		if len(name) == 0 {
+			return nil, confCheckError(eerrors.Errorf("Empty Kafka header name: '%s'", h))
+		}
+		tmpl, err := templates.New(name).Parse(h[idx+1:])
+		if err != nil {
+			return nil, confCheckError(eerrors.Wrapf(err, "Invalid template for Kafka header '%s'", name))
+		}
+		headers = append(headers, KafkaHeader{Name: name, Value: tmpl})
+	}
+	return headers, nil
 }
 
 type KafkaBaseConfig struct {
@@ -166,6 +518,90 @@ type KafkaBaseConfig struct {
 	MetadataRetryMax         int           `mapstructure:"metadata_retry_max" toml:"metadata_retry_max" json:"metadata_retry_max"`
 	MetadataRetryBackoff     time.Duration `mapstructure:"metadata_retry_backoff" toml:"metadata_retry_backoff" json:"metadata_retry_backoff"`
 	MetadataRefreshFrequency time.Duration `mapstructure:"metadata_refresh_frequency" toml:"metadata_refresh_frequency" json:"metadata_refresh_frequency"`
+	SASLMechanism            string        `mapstructure:"sasl_mechanism" toml:"sasl_mechanism" json:"sasl_mechanism"`
+	SASLUsername             string        `mapstructure:"sasl_username" toml:"sasl_username" json:"sasl_username"`
+	SASLPassword             string        `mapstructure:"sasl_password" toml:"-" json:"sasl_password"`
+	// Kerberos parameters for the GSSAPI mechanism
+	SASLKerberosKeytab      string `mapstructure:"sasl_kerberos_keytab" toml:"sasl_kerberos_keytab" json:"sasl_kerberos_keytab"`
+	SASLKerberosPrincipal   string `mapstructure:"sasl_kerberos_principal" toml:"sasl_kerberos_principal" json:"sasl_kerberos_principal"`
+	SASLKerberosRealm       string `mapstructure:"sasl_kerberos_realm" toml:"sasl_kerberos_realm" json:"sasl_kerberos_realm"`
+	SASLKerberosServiceName string `mapstructure:"sasl_kerberos_service_name" toml:"sasl_kerberos_service_name" json:"sasl_kerberos_service_name"`
+	SASLKerberosConfig      string `mapstructure:"sasl_kerberos_config" toml:"sasl_kerberos_config" json:"sasl_kerberos_config"`
+}
+
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+	SASLGSSAPI      = "GSSAPI"
+)
+
+// CheckSASL normalizes and validates the SASL parameters.
+func (c *KafkaBaseConfig) CheckSASL() error {
+	c.SASLMechanism = strings.ToUpper(strings.TrimSpace(c.SASLMechanism))
+	switch c.SASLMechanism {
+	case "":
+		return nil
+	case SASLPlain, SASLScramSHA256, SASLScramSHA512:
+	case SASLGSSAPI:
+		return c.checkKerberos()
+	default:
+		return confCheckError(eerrors.Errorf("Unknown SASL mechanism: '%s'", c.SASLMechanism))
+	}
+	if len(c.SASLUsername) == 0 {
+		return confCheckError(eerrors.New("SASL username is empty"))
+	}
+	if len(c.SASLPassword) == 0 {
+		return confCheckError(eerrors.New("SASL password is empty"))
+	}
+	return nil
+}
+
+// kerberosFiles returns the files that the confined Kafka clients need for
+// the GSSAPI authentication.
+func (c *KafkaBaseConfig) kerberosFiles() []interface{} {
+	if c.SASLMechanism != SASLGSSAPI {
+		return nil
+	}
+	return []interface{}{c.SASLKerberosKeytab, c.SASLKerberosConfig}
+}
+
+func (c *KafkaBaseConfig) checkKerberos() error {
+	if len(c.SASLKerberosKeytab) == 0 {
+		return confCheckError(eerrors.New("GSSAPI needs a Kerberos keytab"))
+	}
+	if len(c.SASLKerberosPrincipal) == 0 {
+		return confCheckError(eerrors.New("GSSAPI needs a Kerberos principal"))
+	}
+	// the realm may be given in the principal: name@REALM
+	if idx := strings.LastIndex(c.SASLKerberosPrincipal, "@"); idx >= 0 {
+		if len(c.SASLKerberosRealm) == 0 {
+			c.SASLKerberosRealm = c.SASLKerberosPrincipal[idx+1:]
+		}
+		c.SASLKerberosPrincipal = c.SASLKerberosPrincipal[:idx]
+	}
+	if len(c.SASLKerberosRealm) == 0 {
+		return confCheckError(eerrors.New("GSSAPI needs a Kerberos realm"))
+	}
+	if len(c.SASLKerberosServiceName) == 0 {
+		c.SASLKerberosServiceName = "kafka"
+	}
+	if len(c.SASLKerberosConfig) == 0 {
+		c.SASLKerberosConfig = "/etc/krb5.conf"
+	}
+	return nil
+}
+
+// the SASLPassword in KafkaBaseConfig is encrypted with the session secret in Complete(),
+// just like the Store secret
+
+func (c *KafkaBaseConfig) EncryptSASLPassword(m *memguard.LockedBuffer) (err error) {
+	c.SASLPassword, err = encryptSecret(c.SASLPassword, m)
+	return err
+}
+
+func (c *KafkaBaseConfig) DecryptSASLPassword(m *memguard.LockedBuffer) (locked *memguard.LockedBuffer, err error) {
+	return decryptSecret(c.SASLPassword, m)
 }
 
 type KafkaConsumerBaseConfig struct {
@@ -195,6 +631,9 @@ type KafkaProducerBaseConfig struct {
 }
 
 type GraylogDestConfig struct {
+	// TLS is only available in tcp mode
+	TlsBaseConfig    `mapstructure:",squash"`
+	Insecure         bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
 	Host             string        `mapstructure:"host" toml:"host" json:"host"`
 	Port             int           `mapstructure:"port" toml:"port" json:"port"`
 	Mode             string        `mapstructure:"mode" toml:"mode" json:"mode"`
@@ -212,6 +651,16 @@ type TcpUdpRelpDestBaseConfig struct {
 	Format         string        `mapstructure:"format" toml:"format" json:"format"`
 }
 
+// FailoverBaseConfig lists the servers that a TCP or RELP destination uses
+// when the configured server is not available.
+type FailoverBaseConfig struct {
+	// Failover lists "host:port" servers, in order of preference.
+	Failover []string `mapstructure:"failover" toml:"failover" json:"failover"`
+	// FailbackPeriod is the interval between the checks of the preferred
+	// servers while a failover server is used.
+	FailbackPeriod time.Duration `mapstructure:"failback_period" toml:"failback_period" json:"failback_period"`
+}
+
 type UDPDestConfig struct {
 	TcpUdpRelpDestBaseConfig `mapstructure:",squash"`
 }
@@ -219,6 +668,7 @@ type UDPDestConfig struct {
 type RELPDestConfig struct {
 	TcpUdpRelpDestBaseConfig `mapstructure:",squash"`
 	TlsBaseConfig            `mapstructure:",squash"`
+	FailoverBaseConfig       `mapstructure:",squash"`
 	Insecure                 bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
 	KeepAlive                bool          `mapstructure:"keepalive" toml:"keepalive" json:"keepalive"`
 	KeepAlivePeriod          time.Duration `mapstructure:"keepalive_period" toml:"keepalive_period" json:"keepalive_period"`
@@ -232,6 +682,7 @@ type RELPDestConfig struct {
 type TCPDestConfig struct {
 	TcpUdpRelpDestBaseConfig `mapstructure:",squash"`
 	TlsBaseConfig            `mapstructure:",squash"`
+	FailoverBaseConfig       `mapstructure:",squash"`
 	Insecure                 bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
 	KeepAlive                bool          `mapstructure:"keepalive" toml:"keepalive" json:"keepalive"`
 	KeepAlivePeriod          time.Duration `mapstructure:"keepalive_period" toml:"keepalive_period" json:"keepalive_period"`
@@ -242,6 +693,137 @@ type TCPDestConfig struct {
 	FrameDelimiter uint8 `mapstructure:"delimiter" toml:"delimiter" json:"delimiter"`
 }
 
+// SyslogTLSDestConfig configures the RFC 5425 destination. The messages are
+// always RFC 5424 encoded and octet-counted, and the client must present a
+// certificate to the server.
+type SyslogTLSDestConfig struct {
+	Host            string        `mapstructure:"host" toml:"host" json:"host"`
+	Port            int           `mapstructure:"port" toml:"port" json:"port"`
+	Rebind          time.Duration `mapstructure:"rebind" toml:"rebind" json:"rebind"`
+	CAFile          string        `mapstructure:"ca_file" toml:"ca_file" json:"ca_file"`
+	CAPath          string        `mapstructure:"ca_path" toml:"ca_path" json:"ca_path"`
+	KeyFile         string        `mapstructure:"key_file" toml:"key_file" json:"key_file"`
+	CertFile        string        `mapstructure:"cert_file" toml:"cert_file" json:"cert_file"`
+	Insecure        bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	KeepAlive       bool          `mapstructure:"keepalive" toml:"keepalive" json:"keepalive"`
+	KeepAlivePeriod time.Duration `mapstructure:"keepalive_period" toml:"keepalive_period" json:"keepalive_period"`
+	ConnTimeout     time.Duration `mapstructure:"connection_timeout" toml:"connection_timeout" json:"connection_timeout"`
+	FlushPeriod     time.Duration `mapstructure:"flush_period" toml:"flush_period" json:"flush_period"`
+}
+
+// NullDestConfig configures the destination that discards the messages. When
+// Encode is set, the messages are encoded before being discarded, so that the
+// cost of the encoding is part of the benchmark.
+type NullDestConfig struct {
+	Format string `mapstructure:"format" toml:"format" json:"format"`
+	Encode bool   `mapstructure:"encode" toml:"encode" json:"encode"`
+}
+
+// PromRemoteWriteDestConfig configures the destination that extracts metrics
+// from the messages, and pushes them periodically to a Prometheus remote
+// write endpoint.
+type PromRemoteWriteDestConfig struct {
+	TlsBaseConfig  `mapstructure:",squash"`
+	Insecure       bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	URL            string        `mapstructure:"url" toml:"url" json:"url"`
+	PushPeriod     time.Duration `mapstructure:"push_period" toml:"push_period" json:"push_period"`
+	RequestTimeout time.Duration `mapstructure:"request_timeout" toml:"request_timeout" json:"request_timeout"`
+	BasicAuth      bool          `mapstructure:"basic_auth" toml:"basic_auth" json:"basic_auth"`
+	Username       string        `mapstructure:"username" toml:"username" json:"username"`
+	Password       string        `mapstructure:"password" toml:"password" json:"password"`
+	BearerToken    string        `mapstructure:"bearer_token" toml:"bearer_token" json:"bearer_token"`
+	// ExternalLabels are "name=value" labels added to every time series.
+	ExternalLabels []string           `mapstructure:"external_labels" toml:"external_labels" json:"external_labels"`
+	Metrics        []MetricRuleConfig `mapstructure:"metrics" toml:"metrics" json:"metrics"`
+}
+
+// MetricRuleConfig describes how a metric is extracted from the messages.
+//
+// A counter is incremented by one for each matching message, or by Value
+// when it is set. A gauge is set to Value. The labels are given as "source"
+// or "label=source". A source, like Value, is a field of the message
+// (appname, hostname, procid, msgid, facility, severity, client,
+// source_type), a property as "domain:key", or a named group of the Match
+// regular expression.
+type MetricRuleConfig struct {
+	Name string `mapstructure:"name" toml:"name" json:"name"`
+	Type string `mapstructure:"type" toml:"type" json:"type"`
+	// Match is a regular expression matched against the message.
+	Match string `mapstructure:"match" toml:"match" json:"match"`
+	// AppName is a regular expression matched against the appname.
+	AppName string   `mapstructure:"appname" toml:"appname" json:"appname"`
+	Value   string   `mapstructure:"value" toml:"value" json:"value"`
+	Labels  []string `mapstructure:"labels" toml:"labels" json:"labels"`
+}
+
+var metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// CheckMetrics checks the metric extraction rules.
+func (c *PromRemoteWriteDestConfig) CheckMetrics() error {
+	for _, label := range c.ExternalLabels {
+		if !strings.Contains(label, "=") {
+			return confCheckError(eerrors.Errorf("External labels must be given as name=value: '%s'", label))
+		}
+	}
+	for i := range c.Metrics {
+		rule := &c.Metrics[i]
+		rule.Name = strings.TrimSpace(rule.Name)
+		if !metricNameRe.MatchString(rule.Name) {
+			return confCheckError(eerrors.Errorf("Invalid metric name: '%s'", rule.Name))
+		}
+		rule.Type = strings.TrimSpace(strings.ToLower(rule.Type))
+		switch rule.Type {
+		case "":
+			rule.Type = "counter"
+		case "counter", "gauge":
+		default:
+			return confCheckError(eerrors.Errorf("Unknown metric type for '%s': '%s'", rule.Name, rule.Type))
+		}
+		if rule.Type == "gauge" && len(rule.Value) == 0 {
+			return confCheckError(eerrors.Errorf("The gauge '%s' needs a value", rule.Name))
+		}
+		for _, expr := range []string{rule.Match, rule.AppName} {
+			_, err := regexp.Compile(expr)
+			if err != nil {
+				return confCheckError(eerrors.Wrapf(err, "Invalid regular expression for metric '%s'", rule.Name))
+			}
+		}
+	}
+	return nil
+}
+
+// AlertDestConfig configures the destination that sends the messages above a
+// severity threshold as alerts. The messages can be further selected with a
+// route. At most MaxAlerts alerts are sent per RatePeriod, the other
+// matching messages are dropped.
+type AlertDestConfig struct {
+	TlsBaseConfig `mapstructure:",squash"`
+	Insecure      bool   `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	Mode          string `mapstructure:"mode" toml:"mode" json:"mode"`
+	Format        string `mapstructure:"format" toml:"format" json:"format"`
+	// Severity is the least severe level that triggers an alert.
+	Severity string `mapstructure:"severity" toml:"severity" json:"severity"`
+	// Match is a regular expression matched against the message.
+	Match string `mapstructure:"match" toml:"match" json:"match"`
+	// AppName is a regular expression matched against the appname.
+	AppName    string        `mapstructure:"appname" toml:"appname" json:"appname"`
+	MaxAlerts  int           `mapstructure:"max_alerts" toml:"max_alerts" json:"max_alerts"`
+	RatePeriod time.Duration `mapstructure:"rate_period" toml:"rate_period" json:"rate_period"`
+
+	WebhookURL  string `mapstructure:"webhook_url" toml:"webhook_url" json:"webhook_url"`
+	BearerToken string `mapstructure:"bearer_token" toml:"bearer_token" json:"bearer_token"`
+	// RequestTimeout bounds the webhook requests and the SMTP sessions.
+	RequestTimeout time.Duration `mapstructure:"request_timeout" toml:"request_timeout" json:"request_timeout"`
+
+	SMTPServer   string   `mapstructure:"smtp_server" toml:"smtp_server" json:"smtp_server"`
+	SMTPUsername string   `mapstructure:"smtp_username" toml:"smtp_username" json:"smtp_username"`
+	SMTPPassword string   `mapstructure:"smtp_password" toml:"smtp_password" json:"smtp_password"`
+	From         string   `mapstructure:"from" toml:"from" json:"from"`
+	To           []string `mapstructure:"to" toml:"to" json:"to"`
+	// Subject is a template executed with the message fields.
+	Subject string `mapstructure:"subject" toml:"subject" json:"subject"`
+}
+
 type HTTPServerDestConfig struct {
 	HTTPServerBaseConfig `mapstructure:",squash"`
 
@@ -265,6 +847,17 @@ type WebsocketServerDestConfig struct {
 	WebEndPoint string `mapstructure:"web_endpoint" toml:"web_endpoint" json:"web_endpoint"`
 }
 
+type WebsocketClientDestConfig struct {
+	TlsBaseConfig `mapstructure:",squash"`
+	Insecure      bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	URL           string        `mapstructure:"url" toml:"url" json:"url"`
+	Format        string        `mapstructure:"format" toml:"format" json:"format"`
+	BearerToken   string        `mapstructure:"bearer_token" toml:"bearer_token" json:"bearer_token"`
+	ConnTimeout   time.Duration `mapstructure:"connection_timeout" toml:"connection_timeout" json:"connection_timeout"`
+	WriteTimeout  time.Duration `mapstructure:"write_timeout" toml:"write_timeout" json:"write_timeout"`
+	Rebind        time.Duration `mapstructure:"rebind" toml:"rebind" json:"rebind"`
+}
+
 type ElasticDestConfig struct {
 	TlsBaseConfig       `mapstructure:",squash"`
 	Insecure            bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
@@ -307,6 +900,120 @@ type RedisDestConfig struct {
 	DialTimeout   time.Duration `mapstructure:"dial_timeout" toml:"dial_timeout" json:"dial_timeout"`
 	ReadTimeout   time.Duration `mapstructure:"read_timeout" toml:"read_timeout" json:"read_timeout"`
 	WriteTimeout  time.Duration `mapstructure:"write_timeout" toml:"write_timeout" json:"write_timeout"`
+	Mode          string        `mapstructure:"mode" toml:"mode" json:"mode"`
+	StreamMaxLen  int64         `mapstructure:"stream_maxlen" toml:"stream_maxlen" json:"stream_maxlen"`
+	StreamField   string        `mapstructure:"stream_field" toml:"stream_field" json:"stream_field"`
+	PipelineSize  int           `mapstructure:"pipeline_size" toml:"pipeline_size" json:"pipeline_size"`
+}
+
+// Modes of the Redis destination: the messages are pushed to a list, published
+// to a channel, or added to a stream.
+const (
+	RedisList    = "list"
+	RedisChannel = "channel"
+	RedisStream  = "stream"
+)
+
+// Modes of the alert destination: the alerts are sent by email, or posted to
+// a webhook.
+const (
+	AlertSMTP    = "smtp"
+	AlertWebhook = "webhook"
+)
+
+// Blob types for the Azure Blob Storage destination.
+const (
+	AzureAppendBlob = "append"
+	AzureBlockBlob  = "block"
+)
+
+// AzureBlobDestConfig describes the Azure Blob Storage destination. The
+// requests are authenticated with a SAS token, or with the managed identity of
+// the host when no SAS token is given.
+type AzureBlobDestConfig struct {
+	ParquetBaseConfig `mapstructure:",squash"`
+	Account           string        `mapstructure:"account" toml:"account" json:"account"`
+	Endpoint          string        `mapstructure:"endpoint" toml:"endpoint" json:"endpoint"`
+	SASToken          string        `mapstructure:"sas_token" toml:"sas_token" json:"sas_token"`
+	ManagedIdentity   bool          `mapstructure:"managed_identity" toml:"managed_identity" json:"managed_identity"`
+	ClientID          string        `mapstructure:"client_id" toml:"client_id" json:"client_id"`
+	ContainerTmpl     string        `mapstructure:"container_tmpl" toml:"container_tmpl" json:"container_tmpl"`
+	BlobTmpl          string        `mapstructure:"blob_tmpl" toml:"blob_tmpl" json:"blob_tmpl"`
+	BlobType          string        `mapstructure:"blob_type" toml:"blob_type" json:"blob_type"`
+	FlushSize         int           `mapstructure:"flush_size" toml:"flush_size" json:"flush_size"`
+	FlushPeriod       time.Duration `mapstructure:"flush_period" toml:"flush_period" json:"flush_period"`
+	Timeout           time.Duration `mapstructure:"timeout" toml:"timeout" json:"timeout"`
+	Format            string        `mapstructure:"format" toml:"format" json:"format"`
+}
+
+type MongoDBDestConfig struct {
+	TlsBaseConfig `mapstructure:",squash"`
+	Insecure      bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	Host          string        `mapstructure:"host" toml:"host" json:"host"`
+	Port          int           `mapstructure:"port" toml:"port" json:"port"`
+	Database      string        `mapstructure:"database" toml:"database" json:"database"`
+	Collection    string        `mapstructure:"collection" toml:"collection" json:"collection"`
+	Username      string        `mapstructure:"username" toml:"username" json:"username"`
+	Password      string        `mapstructure:"password" toml:"password" json:"password"`
+	AuthSource    string        `mapstructure:"auth_source" toml:"auth_source" json:"auth_source"`
+	Capped        bool          `mapstructure:"capped" toml:"capped" json:"capped"`
+	CappedSize    int64         `mapstructure:"capped_size" toml:"capped_size" json:"capped_size"`
+	CappedMaxDocs int64         `mapstructure:"capped_max_docs" toml:"capped_max_docs" json:"capped_max_docs"`
+	BatchSize     int           `mapstructure:"batch_size" toml:"batch_size" json:"batch_size"`
+	FlushPeriod   time.Duration `mapstructure:"flush_period" toml:"flush_period" json:"flush_period"`
+	Timeout       time.Duration `mapstructure:"timeout" toml:"timeout" json:"timeout"`
+	Rebind        time.Duration `mapstructure:"rebind" toml:"rebind" json:"rebind"`
+}
+
+type NSQDestConfig struct {
+	TlsBaseConfig     `mapstructure:",squash"`
+	Insecure          bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	Host              string        `mapstructure:"host" toml:"host" json:"host"`
+	Port              int           `mapstructure:"port" toml:"port" json:"port"`
+	Format            string        `mapstructure:"format" toml:"format" json:"format"`
+	AuthSecret        string        `mapstructure:"auth_secret" toml:"auth_secret" json:"auth_secret"`
+	DialTimeout       time.Duration `mapstructure:"dial_timeout" toml:"dial_timeout" json:"dial_timeout"`
+	WriteTimeout      time.Duration `mapstructure:"write_timeout" toml:"write_timeout" json:"write_timeout"`
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval" toml:"heartbeat_interval" json:"heartbeat_interval"`
+	MaxInFlight       int           `mapstructure:"max_in_flight" toml:"max_in_flight" json:"max_in_flight"`
+	Rebind            time.Duration `mapstructure:"rebind" toml:"rebind" json:"rebind"`
+}
+
+type PulsarDestConfig struct {
+	TlsBaseConfig           `mapstructure:",squash"`
+	Insecure                bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	URL                     string        `mapstructure:"url" toml:"url" json:"url"`
+	Tenant                  string        `mapstructure:"tenant" toml:"tenant" json:"tenant"`
+	Namespace               string        `mapstructure:"namespace" toml:"namespace" json:"namespace"`
+	NonPersistent           bool          `mapstructure:"non_persistent" toml:"non_persistent" json:"non_persistent"`
+	Token                   string        `mapstructure:"token" toml:"token" json:"token"`
+	ProducerName            string        `mapstructure:"producer_name" toml:"producer_name" json:"producer_name"`
+	Format                  string        `mapstructure:"format" toml:"format" json:"format"`
+	BatchingEnabled         bool          `mapstructure:"batching_enabled" toml:"batching_enabled" json:"batching_enabled"`
+	BatchingMaxMessages     int           `mapstructure:"batching_max_messages" toml:"batching_max_messages" json:"batching_max_messages"`
+	BatchingMaxPublishDelay time.Duration `mapstructure:"batching_max_publish_delay" toml:"batching_max_publish_delay" json:"batching_max_publish_delay"`
+	MaxPendingMessages      int           `mapstructure:"max_pending_messages" toml:"max_pending_messages" json:"max_pending_messages"`
+	SendTimeout             time.Duration `mapstructure:"send_timeout" toml:"send_timeout" json:"send_timeout"`
+	CompressionType         string        `mapstructure:"compression_type" toml:"compression_type" json:"compression_type"`
+	ConnTimeout             time.Duration `mapstructure:"connection_timeout" toml:"connection_timeout" json:"connection_timeout"`
+	Rebind                  time.Duration `mapstructure:"rebind" toml:"rebind" json:"rebind"`
+}
+
+// SQSDestConfig configures the destination that sends messages to an SQS
+// queue, or publishes them to an SNS topic when TopicARN is set.
+type SQSDestConfig struct {
+	Region           string        `mapstructure:"region" toml:"region" json:"region"`
+	Endpoint         string        `mapstructure:"endpoint" toml:"endpoint" json:"endpoint"`
+	AccessKeyID      string        `mapstructure:"access_key_id" toml:"access_key_id" json:"access_key_id"`
+	SecretAccessKey  string        `mapstructure:"secret_access_key" toml:"secret_access_key" json:"secret_access_key"`
+	SessionToken     string        `mapstructure:"session_token" toml:"session_token" json:"session_token"`
+	QueueURL         string        `mapstructure:"queue_url" toml:"queue_url" json:"queue_url"`
+	TopicARN         string        `mapstructure:"topic_arn" toml:"topic_arn" json:"topic_arn"`
+	MessageGroupTmpl string        `mapstructure:"message_group_tmpl" toml:"message_group_tmpl" json:"message_group_tmpl"`
+	BatchSize        int           `mapstructure:"batch_size" toml:"batch_size" json:"batch_size"`
+	FlushPeriod      time.Duration `mapstructure:"flush_period" toml:"flush_period" json:"flush_period"`
+	Timeout          time.Duration `mapstructure:"timeout" toml:"timeout" json:"timeout"`
+	Format           string        `mapstructure:"format" toml:"format" json:"format"`
 }
 
 type HTTPDestConfig struct {
@@ -328,6 +1035,22 @@ type HTTPDestConfig struct {
 	Password            string        `mapstructure:"password" toml:"password" json:"password"`
 	UserAgent           string        `mapstructure:"user_agent" toml:"user_agent" json:"user_agent"`
 	ContentType         string        `mapstructure:"content_type" toml:"content_type" json:"content_type"`
+	// MaxRetries is the number of times a failed request is retried before
+	// the message is NACKed.
+	MaxRetries          int           `mapstructure:"max_retries" toml:"max_retries" json:"max_retries"`
+	RetryInitialBackoff time.Duration `mapstructure:"retry_initial_backoff" toml:"retry_initial_backoff" json:"retry_initial_backoff"`
+	RetryMaxBackoff     time.Duration `mapstructure:"retry_max_backoff" toml:"retry_max_backoff" json:"retry_max_backoff"`
+	// RetryStatusCodes lists the HTTP status codes that are retried. The other
+	// 4xx status codes are permanent errors.
+	RetryStatusCodes []int `mapstructure:"retry_status_codes" toml:"retry_status_codes" json:"retry_status_codes"`
+	// When ResponseAckRegexp is set, a message is ACKed only if the response
+	// body matches.
+	ResponseAckRegexp string `mapstructure:"response_ack_regexp" toml:"response_ack_regexp" json:"response_ack_regexp"`
+	// When ResponseAckPath is set, like "$.errors" or "$.items.0.status", a
+	// message is ACKed only if the value at that path of the JSON response
+	// body equals ResponseAckValue.
+	ResponseAckPath  string `mapstructure:"response_ack_path" toml:"response_ack_path" json:"response_ack_path"`
+	ResponseAckValue string `mapstructure:"response_ack_value" toml:"response_ack_value" json:"response_ack_value"`
 }
 
 type NATSDestConfig struct {
@@ -349,16 +1072,60 @@ type NATSDestConfig struct {
 	AllowReconnect   bool          `mapstructure:"allow_reconnect" toml:"allow_reconnect" json:"allow_reconnect"`
 }
 
+// ParquetBaseConfig describes the Parquet files written by the archival
+// destinations.
+type ParquetBaseConfig struct {
+	// ParquetColumns lists the columns as "field" or "name=field". A field is a
+	// message field, or a property as "domain:key".
+	ParquetColumns      []string `mapstructure:"parquet_columns" toml:"parquet_columns" json:"parquet_columns"`
+	ParquetCompression  string   `mapstructure:"parquet_compression" toml:"parquet_compression" json:"parquet_compression"`
+	ParquetRowGroupSize int      `mapstructure:"parquet_row_group_size" toml:"parquet_row_group_size" json:"parquet_row_group_size"`
+}
+
 type FileDestConfig struct {
-	Filename        string        `mapstructure:"filename" toml:"filename" json:"filename"`
-	Sync            bool          `mapstructure:"sync" toml:"sync" json:"sync"`
-	SyncPeriod      time.Duration `mapstructure:"sync_period" toml:"sync_period" json:"sync_period"`
-	FlushPeriod     time.Duration `mapstructure:"flush_period" toml:"flush_period" json:"flush_period"`
-	BufferSize      int           `mapstructure:"buffer_size" toml:"buffer_size" json:"buffer_size"`
-	OpenFileTimeout time.Duration `mapstructure:"open_file_timeout" toml:"open_file_timeout" json:"open_file_timeout"`
-	Gzip            bool          `mapstructure:"gzip" toml:"gzip" json:"gzip"`
-	GzipLevel       int           `mapstructure:"gzip_level" toml:"gzip_level" json:"gzip_level"`
-	Format          string        `mapstructure:"format" toml:"format" json:"format"`
+	ParquetBaseConfig `mapstructure:",squash"`
+	Filename          string        `mapstructure:"filename" toml:"filename" json:"filename"`
+	Sync              bool          `mapstructure:"sync" toml:"sync" json:"sync"`
+	SyncPeriod        time.Duration `mapstructure:"sync_period" toml:"sync_period" json:"sync_period"`
+	FlushPeriod       time.Duration `mapstructure:"flush_period" toml:"flush_period" json:"flush_period"`
+	BufferSize        int           `mapstructure:"buffer_size" toml:"buffer_size" json:"buffer_size"`
+	OpenFileTimeout   time.Duration `mapstructure:"open_file_timeout" toml:"open_file_timeout" json:"open_file_timeout"`
+	OpenFilesCache    int           `mapstructure:"open_files_cache" toml:"open_files_cache" json:"open_files_cache"`
+	Gzip              bool          `mapstructure:"gzip" toml:"gzip" json:"gzip"`
+	GzipLevel         int           `mapstructure:"gzip_level" toml:"gzip_level" json:"gzip_level"`
+	Format            string        `mapstructure:"format" toml:"format" json:"format"`
+	// RotateSize is the size in bytes after which a file is rotated.
+	RotateSize int64 `mapstructure:"rotate_size" toml:"rotate_size" json:"rotate_size"`
+	// RotateInterval rotates the files at each interval (aligned on UTC).
+	RotateInterval time.Duration `mapstructure:"rotate_interval" toml:"rotate_interval" json:"rotate_interval"`
+	// RotatePattern is the time layout appended to the name of the rotated files.
+	RotatePattern string `mapstructure:"rotate_pattern" toml:"rotate_pattern" json:"rotate_pattern"`
+	RotateGzip    bool   `mapstructure:"rotate_gzip" toml:"rotate_gzip" json:"rotate_gzip"`
+	// MaxFiles is the number of rotated files to keep for each file.
+	MaxFiles int           `mapstructure:"max_files" toml:"max_files" json:"max_files"`
+	MaxAge   time.Duration `mapstructure:"max_age" toml:"max_age" json:"max_age"`
+	// ParquetRollPeriod is how long a Parquet file stays opened. The messages
+	// are acknowledged when the file is closed, as a Parquet file is only
+	// readable when its footer has been written.
+	ParquetRollPeriod time.Duration `mapstructure:"parquet_roll_period" toml:"parquet_roll_period" json:"parquet_roll_period"`
+}
+
+// CheckRotation checks the rotation parameters of the file destination.
+func (c *FileDestConfig) CheckRotation() error {
+	if c.RotateSize < 0 || c.RotateInterval < 0 || c.MaxFiles < 0 || c.MaxAge < 0 {
+		return confCheckError(eerrors.New("The file destination rotation parameters must not be negative"))
+	}
+	if c.RotateSize == 0 && c.RotateInterval == 0 {
+		return nil
+	}
+	c.RotatePattern = strings.TrimSpace(c.RotatePattern)
+	if len(c.RotatePattern) == 0 {
+		c.RotatePattern = "20060102-150405"
+	}
+	if strings.ContainsAny(c.RotatePattern, "/\\") {
+		return confCheckError(eerrors.Errorf("rotate_pattern must not contain a path separator: '%s'", c.RotatePattern))
+	}
+	return nil
 }
 
 type StderrDestConfig struct {
@@ -372,6 +1139,59 @@ type FilterSubConfig struct {
 	PartitionFunc       string `mapstructure:"partition_key_func" toml:"partition_key_func" json:"partition_key_func"`
 	PartitionNumberFunc string `mapstructure:"partition_number_func" toml:"partition_number_func" json:"partition_number_func"`
 	FilterFunc          string `mapstructure:"filter_func" toml:"filter_func" json:"filter_func"`
+	// FilterExpr is an expression that the messages must match, like
+	// `severity <= warning && appname =~ "^nginx"`. The TopicRules and the
+	// DestinationRules select the topic and the destinations of the messages
+	// with such expressions.
+	FilterExpr       string                  `mapstructure:"filter_expr" toml:"filter_expr" json:"filter_expr,omitempty"`
+	TopicRules       []TopicRuleConfig       `mapstructure:"topic_rule" toml:"topic_rule" json:"topic_rule,omitempty"`
+	DestinationRules []DestinationRuleConfig `mapstructure:"destination_rule" toml:"destination_rule" json:"destination_rule,omitempty"`
+	// MinSeverity is the least severe level of the messages that are kept, and
+	// MaxSeverity the most severe level. When Facility is set, only the
+	// messages with those facilities are kept.
+	MinSeverity string   `mapstructure:"min_severity" toml:"min_severity" json:"min_severity,omitempty"`
+	MaxSeverity string   `mapstructure:"max_severity" toml:"max_severity" json:"max_severity,omitempty"`
+	Facility    []string `mapstructure:"facility" toml:"facility" json:"facility,omitempty"`
+	// MaxMessageLength is the maximum length in bytes of the message field.
+	// The longer messages are cut (TruncateStrategy "cut"), cut and ended by
+	// TruncateMarker ("marker", the default), or dropped ("drop").
+	MaxMessageLength int    `mapstructure:"max_message_length" toml:"max_message_length" json:"max_message_length,omitempty"`
+	TruncateStrategy string `mapstructure:"truncate_strategy" toml:"truncate_strategy" json:"truncate_strategy,omitempty"`
+	TruncateMarker   string `mapstructure:"truncate_marker" toml:"truncate_marker" json:"truncate_marker,omitempty"`
+	// MaxFuture is the tolerance for the timestamps in the future. The later
+	// timestamps are replaced by the reception time.
+	MaxFuture time.Duration `mapstructure:"max_future" toml:"max_future" json:"max_future,omitempty"`
+	// JSONSchema validates the messages against a JSON Schema.
+	JSONSchema *JSONSchemaConfig `mapstructure:"json_schema" toml:"json_schema" json:"json_schema,omitempty"`
+	// The hostnames are lowercased, and then replaced by the canonical names
+	// in the HostnameMap CSV file (with alias,canonical lines). When
+	// HostnameStripDomain is set, the domain is removed from the hostnames
+	// that are not in the file.
+	HostnameLowercase   bool   `mapstructure:"hostname_lowercase" toml:"hostname_lowercase" json:"hostname_lowercase,omitempty"`
+	HostnameStripDomain bool   `mapstructure:"hostname_strip_domain" toml:"hostname_strip_domain" json:"hostname_strip_domain,omitempty"`
+	HostnameMap         string `mapstructure:"hostname_map" toml:"hostname_map" json:"hostname_map,omitempty"`
+	// SequenceCheck detects the gaps and the regressions in the sequence
+	// numbers of the messages: the RELP transaction numbers of each
+	// connection (txnr), the offsets of each Kafka partition (kafka), or a
+	// domain:key property of each connection or client. The numbers that are
+	// still missing after SequenceDelay are reported as gaps, and the messages
+	// that go back in the sequence get the skewer:sequence_regression property.
+	SequenceCheck string        `mapstructure:"sequence_check" toml:"sequence_check" json:"sequence_check,omitempty"`
+	SequenceDelay time.Duration `mapstructure:"sequence_delay" toml:"sequence_delay" json:"sequence_delay,omitempty"`
+	// Tags are static properties added to the messages of the source, in the
+	// tags domain, like datacenter, environment or tenant.
+	Tags map[string]string `mapstructure:"tags" toml:"tags" json:"tags,omitempty"`
+	// the filter stages are applied when the messages are received, before
+	// they are stored
+	Samples    []SampleConfig        `mapstructure:"sample" toml:"sample" json:"sample,omitempty"`
+	RateLimits []RateLimitRuleConfig `mapstructure:"rate_limit" toml:"rate_limit" json:"rate_limit,omitempty"`
+	Redactions []RedactionConfig     `mapstructure:"redact" toml:"redact" json:"redact,omitempty"`
+	// GeoIPFields are the fields that contain the IP addresses to look up in
+	// the GeoIP databases: client, or domain:key for a property.
+	GeoIPFields []string           `mapstructure:"geoip_fields" toml:"geoip_fields" json:"geoip_fields,omitempty"`
+	ReverseDNS  []ReverseDNSConfig `mapstructure:"reverse_dns" toml:"reverse_dns" json:"reverse_dns,omitempty"`
+	Lookups     []LookupConfig     `mapstructure:"lookup" toml:"lookup" json:"lookup,omitempty"`
+	Transforms  []TransformConfig  `mapstructure:"transform" toml:"transform" json:"transform,omitempty"`
 }
 
 type JournaldConfig struct {
@@ -446,10 +1266,93 @@ func (c *MacOSSourceConfig) DefaultPort() int {
 	return 0
 }
 
+type KubernetesSourceConfig struct {
+	FilterSubConfig `mapstructure:",squash"`
+	ConfID          utils.MyULID  `mapstructure:"-" toml:"-" json:"conf_id"`
+	Enabled         bool          `mapstructure:"enabled" toml:"enabled" json:"enabled"`
+	APIServer       string        `mapstructure:"api_server" toml:"api_server" json:"api_server"`
+	Namespace       string        `mapstructure:"namespace" toml:"namespace" json:"namespace"`
+	LabelSelector   string        `mapstructure:"label_selector" toml:"label_selector" json:"label_selector"`
+	NodeName        string        `mapstructure:"node_name" toml:"node_name" json:"node_name"`
+	TokenFile       string        `mapstructure:"token_file" toml:"token_file" json:"token_file"`
+	CAFile          string        `mapstructure:"ca_file" toml:"ca_file" json:"ca_file"`
+	Insecure        bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	RefreshPeriod   time.Duration `mapstructure:"refresh_period" toml:"refresh_period" json:"refresh_period"`
+}
+
+func (c *KubernetesSourceConfig) FilterConf() *FilterSubConfig {
+	return &c.FilterSubConfig
+}
+
+func (c *KubernetesSourceConfig) ListenersConf() *ListenersConfig {
+	return nil
+}
+
+func (c *KubernetesSourceConfig) DecoderConf() *DecoderBaseConfig {
+	return nil
+}
+
+func (c *KubernetesSourceConfig) DefaultPort() int {
+	return 0
+}
+
+// StdinSourceConfig configures the source that reads line-delimited messages
+// from the standard input of skewer.
+type StdinSourceConfig struct {
+	FilterSubConfig   `mapstructure:",squash"`
+	DecoderBaseConfig `mapstructure:",squash"`
+	ConfID            utils.MyULID `mapstructure:"-" toml:"-" json:"conf_id"`
+	Enabled           bool         `mapstructure:"enabled" toml:"enabled" json:"enabled"`
+	MaxLineSize       int          `mapstructure:"max_line_size" toml:"max_line_size" json:"max_line_size"`
+}
+
+func (c *StdinSourceConfig) FilterConf() *FilterSubConfig {
+	return &c.FilterSubConfig
+}
+
+func (c *StdinSourceConfig) ListenersConf() *ListenersConfig {
+	return nil
+}
+
+func (c *StdinSourceConfig) DecoderConf() *DecoderBaseConfig {
+	return &c.DecoderBaseConfig
+}
+
+func (c *StdinSourceConfig) DefaultPort() int {
+	return 0
+}
+
 type DecoderBaseConfig struct {
-	Format    string `mapstructure:"format" toml:"format" json:"format"`
-	Charset   string `mapstructure:"charset" toml:"charset" json:"charset"`
-	W3CFields string `mapstructure:"w3c_fields" toml:"w3c_fields" json:"fields"`
+	// Format may be a chain of decoders, like "rfc5424|json|kv": the next
+	// decoders parse the message field, and add their properties.
+	Format       string `mapstructure:"format" toml:"format" json:"format"`
+	Charset      string `mapstructure:"charset" toml:"charset" json:"charset"`
+	W3CFields    string `mapstructure:"w3c_fields" toml:"w3c_fields" json:"fields"`
+	CSVFields    string `mapstructure:"csv_fields" toml:"csv_fields" json:"csv_fields"`
+	CSVDelimiter string `mapstructure:"csv_delimiter" toml:"csv_delimiter" json:"csv_delimiter"`
+	CSVQuote     string `mapstructure:"csv_quote" toml:"csv_quote" json:"csv_quote"`
+	// JSON paths, like "log.level", mapped onto the message fields by the
+	// json decoder. When none is set, the json decoder expects the fields
+	// of the json format.
+	JSONTimestampPath   string `mapstructure:"json_timestamp_path" toml:"json_timestamp_path" json:"json_timestamp_path"`
+	JSONTimestampLayout string `mapstructure:"json_timestamp_layout" toml:"json_timestamp_layout" json:"json_timestamp_layout"`
+	JSONSeverityPath    string `mapstructure:"json_severity_path" toml:"json_severity_path" json:"json_severity_path"`
+	JSONMessagePath     string `mapstructure:"json_message_path" toml:"json_message_path" json:"json_message_path"`
+	JSONHostnamePath    string `mapstructure:"json_hostname_path" toml:"json_hostname_path" json:"json_hostname_path"`
+	JSONAppnamePath     string `mapstructure:"json_appname_path" toml:"json_appname_path" json:"json_appname_path"`
+	AvroRegistryURL     string `mapstructure:"avro_registry_url" toml:"avro_registry_url" json:"avro_registry_url"`
+	KVPairSeparator     string `mapstructure:"kv_pair_separator" toml:"kv_pair_separator" json:"kv_pair_separator"`
+	KVSeparator         string `mapstructure:"kv_separator" toml:"kv_separator" json:"kv_separator"`
+	KVQuotes            string `mapstructure:"kv_quotes" toml:"kv_quotes" json:"kv_quotes"`
+	// Timezone is the IANA timezone, like "Europe/Paris", of the RFC3164
+	// timestamps that have no timezone. They are read as UTC by default.
+	Timezone string `mapstructure:"timezone" toml:"timezone" json:"timezone,omitempty"`
+}
+
+// JSONMapped returns true when the json decoder should use the JSON paths.
+func (c *DecoderBaseConfig) JSONMapped() bool {
+	return len(c.JSONTimestampPath) > 0 || len(c.JSONSeverityPath) > 0 || len(c.JSONMessagePath) > 0 ||
+		len(c.JSONHostnamePath) > 0 || len(c.JSONAppnamePath) > 0
 }
 
 func (c *DecoderBaseConfig) Equals(other gotomic.Thing) bool {
@@ -464,6 +1367,20 @@ func (c *DecoderBaseConfig) HashCode() uint32 {
 	h.Write([]byte(c.Format))
 	h.Write([]byte(c.Charset))
 	h.Write([]byte(c.W3CFields))
+	h.Write([]byte(c.CSVFields))
+	h.Write([]byte(c.CSVDelimiter))
+	h.Write([]byte(c.CSVQuote))
+	h.Write([]byte(c.JSONTimestampPath))
+	h.Write([]byte(c.JSONTimestampLayout))
+	h.Write([]byte(c.JSONSeverityPath))
+	h.Write([]byte(c.JSONMessagePath))
+	h.Write([]byte(c.JSONHostnamePath))
+	h.Write([]byte(c.JSONAppnamePath))
+	h.Write([]byte(c.AvroRegistryURL))
+	h.Write([]byte(c.KVPairSeparator))
+	h.Write([]byte(c.KVSeparator))
+	h.Write([]byte(c.KVQuotes))
+	h.Write([]byte(c.Timezone))
 	return h.Sum32()
 }
 
@@ -491,6 +1408,95 @@ func (c *FilesystemSourceConfig) DefaultPort() int {
 	return 0
 }
 
+type ExecSourceConfig struct {
+	FilterSubConfig `mapstructure:",squash"`
+	ConfID          utils.MyULID  `mapstructure:"-" toml:"-" json:"conf_id"`
+	Command         string        `mapstructure:"command" toml:"command" json:"command"`
+	Args            []string      `mapstructure:"args" toml:"args" json:"args"`
+	AppName         string        `mapstructure:"appname" toml:"appname" json:"appname"`
+	Period          time.Duration `mapstructure:"period" toml:"period" json:"period"`
+	Timeout         time.Duration `mapstructure:"timeout" toml:"timeout" json:"timeout"`
+	MaxLines        int           `mapstructure:"max_lines" toml:"max_lines" json:"max_lines"`
+	MaxLineSize     int           `mapstructure:"max_line_size" toml:"max_line_size" json:"max_line_size"`
+}
+
+func (c *ExecSourceConfig) FilterConf() *FilterSubConfig {
+	return &c.FilterSubConfig
+}
+
+func (c *ExecSourceConfig) ListenersConf() *ListenersConfig {
+	return nil
+}
+
+func (c *ExecSourceConfig) DecoderConf() *DecoderBaseConfig {
+	return nil
+}
+
+func (c *ExecSourceConfig) DefaultPort() int {
+	return 0
+}
+
+// PubSubSourceConfig describes a Google Cloud Pub/Sub subscription to pull
+// messages from. When CredentialsFile is empty, the access tokens are asked to
+// the GCE metadata server.
+type PubSubSourceConfig struct {
+	DecoderBaseConfig `mapstructure:",squash"`
+	FilterSubConfig   `mapstructure:",squash"`
+	ConfID            utils.MyULID  `mapstructure:"-" toml:"-" json:"conf_id"`
+	Project           string        `mapstructure:"project" toml:"project" json:"project"`
+	Subscription      string        `mapstructure:"subscription" toml:"subscription" json:"subscription"`
+	CredentialsFile   string        `mapstructure:"credentials_file" toml:"credentials_file" json:"credentials_file"`
+	Endpoint          string        `mapstructure:"endpoint" toml:"endpoint" json:"endpoint"`
+	MaxMessages       int           `mapstructure:"max_messages" toml:"max_messages" json:"max_messages"`
+	Pullers           int           `mapstructure:"pullers" toml:"pullers" json:"pullers"`
+	PullTimeout       time.Duration `mapstructure:"pull_timeout" toml:"pull_timeout" json:"pull_timeout"`
+}
+
+func (c *PubSubSourceConfig) FilterConf() *FilterSubConfig {
+	return &c.FilterSubConfig
+}
+
+func (c *PubSubSourceConfig) ListenersConf() *ListenersConfig {
+	return nil
+}
+
+func (c *PubSubSourceConfig) DecoderConf() *DecoderBaseConfig {
+	return &c.DecoderBaseConfig
+}
+
+func (c *PubSubSourceConfig) DefaultPort() int {
+	return 0
+}
+
+// JournalGatewaySourceConfig describes a remote systemd-journal-gatewayd to
+// stream journal entries from.
+type JournalGatewaySourceConfig struct {
+	FilterSubConfig `mapstructure:",squash"`
+	TlsBaseConfig   `mapstructure:",squash"`
+	ConfID          utils.MyULID  `mapstructure:"-" toml:"-" json:"conf_id"`
+	URL             string        `mapstructure:"url" toml:"url" json:"url"`
+	Insecure        bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	Matches         []string      `mapstructure:"matches" toml:"matches" json:"matches"`
+	FromStart       bool          `mapstructure:"from_start" toml:"from_start" json:"from_start"`
+	ReconnectPeriod time.Duration `mapstructure:"reconnect_period" toml:"reconnect_period" json:"reconnect_period"`
+}
+
+func (c *JournalGatewaySourceConfig) FilterConf() *FilterSubConfig {
+	return &c.FilterSubConfig
+}
+
+func (c *JournalGatewaySourceConfig) ListenersConf() *ListenersConfig {
+	return nil
+}
+
+func (c *JournalGatewaySourceConfig) DecoderConf() *DecoderBaseConfig {
+	return nil
+}
+
+func (c *JournalGatewaySourceConfig) DefaultPort() int {
+	return 0
+}
+
 type HTTPServerSourceConfig struct {
 	HTTPServerBaseConfig `mapstructure:",squash"`
 	DecoderBaseConfig    `mapstructure:",squash"`
@@ -525,14 +1531,27 @@ func (c *HTTPServerSourceConfig) DefaultPort() int {
 	return 8081
 }
 
+// Framing methods for TCP sources, as described in RFC 6587. TCPRFC5425Framing
+// turns the source into a strict RFC 5425 syslog-over-TLS receiver.
+const (
+	TCPAutoFraming    = "auto"
+	TCPLineFraming    = "line"
+	TCPOctetFraming   = "octet-counted"
+	TCPRFC5425Framing = "rfc5425"
+)
+
 type TCPSourceConfig struct {
 	DecoderBaseConfig `mapstructure:",squash"`
 	ListenersConfig   `mapstructure:",squash"`
 	FilterSubConfig   `mapstructure:",squash"`
 	TlsBaseConfig     `mapstructure:",squash"`
+	RateLimitConfig   `mapstructure:",squash"`
 	ClientAuthType    string       `mapstructure:"client_auth_type" toml:"client_auth_type" json:"client_auth_type"`
 	LineFraming       bool         `mapstructure:"line_framing" toml:"line_framing" json:"line_framing"`
 	FrameDelimiter    string       `mapstructure:"delimiter" toml:"delimiter" json:"delimiter"`
+	Framing           string       `mapstructure:"framing" toml:"framing" json:"framing"`
+	StartTLS          bool         `mapstructure:"starttls" toml:"starttls" json:"starttls"`
+	ProxyProtocol     bool         `mapstructure:"proxy_protocol" toml:"proxy_protocol" json:"proxy_protocol"`
 	ConfID            utils.MyULID `mapstructure:"-" toml:"-" json:"conf_id"`
 }
 
@@ -549,6 +1568,9 @@ func (c *TCPSourceConfig) DecoderConf() *DecoderBaseConfig {
 }
 
 func (c *TCPSourceConfig) DefaultPort() int {
+	if c.Framing == TCPRFC5425Framing {
+		return 6514
+	}
 	return 1514
 }
 
@@ -556,7 +1578,10 @@ type UDPSourceConfig struct {
 	DecoderBaseConfig `mapstructure:",squash"`
 	ListenersConfig   `mapstructure:",squash"`
 	FilterSubConfig   `mapstructure:",squash"`
+	RateLimitConfig   `mapstructure:",squash"`
 	ConfID            utils.MyULID `mapstructure:"-" toml:"-" json:"conf_id"`
+	// Sockets is the number of SO_REUSEPORT sockets opened for each port
+	Sockets int `mapstructure:"sockets" toml:"sockets" json:"sockets"`
 }
 
 func (c *UDPSourceConfig) FilterConf() *FilterSubConfig {
@@ -579,7 +1604,10 @@ type GraylogSourceConfig struct {
 	DecoderBaseConfig `mapstructure:",squash"`
 	ListenersConfig   `mapstructure:",squash"`
 	FilterSubConfig   `mapstructure:",squash"`
-	ConfID            utils.MyULID `mapstructure:"-" toml:"-" json:"conf_id"`
+	ConfID            utils.MyULID  `mapstructure:"-" toml:"-" json:"conf_id"`
+	MaxChunks         int           `mapstructure:"max_chunks" toml:"max_chunks" json:"max_chunks"`
+	ChunksTimeout     time.Duration `mapstructure:"chunks_timeout" toml:"chunks_timeout" json:"chunks_timeout"`
+	MaxMessageSize    int           `mapstructure:"max_message_size" toml:"max_message_size" json:"max_message_size"`
 }
 
 func (c *GraylogSourceConfig) FilterConf() *FilterSubConfig {
@@ -603,9 +1631,12 @@ type RELPSourceConfig struct {
 	ListenersConfig   `mapstructure:",squash"`
 	FilterSubConfig   `mapstructure:",squash"`
 	TlsBaseConfig     `mapstructure:",squash"`
+	RateLimitConfig   `mapstructure:",squash"`
 	ClientAuthType    string       `mapstructure:"client_auth_type" toml:"client_auth_type" json:"client_auth_type"`
 	LineFraming       bool         `mapstructure:"line_framing" toml:"line_framing" json:"line_framing"`
 	FrameDelimiter    string       `mapstructure:"delimiter" toml:"delimiter" json:"delimiter"`
+	StartTLS          bool         `mapstructure:"starttls" toml:"starttls" json:"starttls"`
+	ProxyProtocol     bool         `mapstructure:"proxy_protocol" toml:"proxy_protocol" json:"proxy_protocol"`
 	ConfID            utils.MyULID `mapstructure:"-" toml:"-" json:"conf_id"`
 }
 
@@ -625,14 +1656,52 @@ func (c *RELPSourceConfig) DefaultPort() int {
 	return 2514
 }
 
+// TCPConfig returns the listening parameters of the RELP source. The framing
+// of RELP is fixed, so that the framing of the TCP source is left empty.
+func (c *RELPSourceConfig) TCPConfig() TCPSourceConfig {
+	return TCPSourceConfig{
+		DecoderBaseConfig: c.DecoderBaseConfig,
+		ListenersConfig:   c.ListenersConfig,
+		FilterSubConfig:   c.FilterSubConfig,
+		TlsBaseConfig:     c.TlsBaseConfig,
+		RateLimitConfig:   c.RateLimitConfig,
+		ClientAuthType:    c.ClientAuthType,
+		LineFraming:       c.LineFraming,
+		FrameDelimiter:    c.FrameDelimiter,
+		StartTLS:          c.StartTLS,
+		ProxyProtocol:     c.ProxyProtocol,
+		ConfID:            c.ConfID,
+	}
+}
+
+// RELPConfig is the reverse of RELPSourceConfig.TCPConfig.
+func (c *TCPSourceConfig) RELPConfig() RELPSourceConfig {
+	return RELPSourceConfig{
+		DecoderBaseConfig: c.DecoderBaseConfig,
+		ListenersConfig:   c.ListenersConfig,
+		FilterSubConfig:   c.FilterSubConfig,
+		TlsBaseConfig:     c.TlsBaseConfig,
+		RateLimitConfig:   c.RateLimitConfig,
+		ClientAuthType:    c.ClientAuthType,
+		LineFraming:       c.LineFraming,
+		FrameDelimiter:    c.FrameDelimiter,
+		StartTLS:          c.StartTLS,
+		ProxyProtocol:     c.ProxyProtocol,
+		ConfID:            c.ConfID,
+	}
+}
+
 type DirectRELPSourceConfig struct {
 	DecoderBaseConfig `mapstructure:",squash"`
 	ListenersConfig   `mapstructure:",squash"`
 	FilterSubConfig   `mapstructure:",squash"`
 	TlsBaseConfig     `mapstructure:",squash"`
+	RateLimitConfig   `mapstructure:",squash"`
 	ClientAuthType    string       `mapstructure:"client_auth_type" toml:"client_auth_type" json:"client_auth_type"`
 	LineFraming       bool         `mapstructure:"line_framing" toml:"line_framing" json:"line_framing"`
 	FrameDelimiter    string       `mapstructure:"delimiter" toml:"delimiter" json:"delimiter"`
+	StartTLS          bool         `mapstructure:"starttls" toml:"starttls" json:"starttls"`
+	ProxyProtocol     bool         `mapstructure:"proxy_protocol" toml:"proxy_protocol" json:"proxy_protocol"`
 	ConfID            utils.MyULID `mapstructure:"-" toml:"-" json:"conf_id"`
 }
 
@@ -652,6 +1721,40 @@ func (c *DirectRELPSourceConfig) DefaultPort() int {
 	return 3514
 }
 
+// TCPConfig returns the listening parameters of the direct RELP source.
+func (c *DirectRELPSourceConfig) TCPConfig() TCPSourceConfig {
+	return TCPSourceConfig{
+		DecoderBaseConfig: c.DecoderBaseConfig,
+		ListenersConfig:   c.ListenersConfig,
+		FilterSubConfig:   c.FilterSubConfig,
+		TlsBaseConfig:     c.TlsBaseConfig,
+		RateLimitConfig:   c.RateLimitConfig,
+		ClientAuthType:    c.ClientAuthType,
+		LineFraming:       c.LineFraming,
+		FrameDelimiter:    c.FrameDelimiter,
+		StartTLS:          c.StartTLS,
+		ProxyProtocol:     c.ProxyProtocol,
+		ConfID:            c.ConfID,
+	}
+}
+
+// DirectRELPConfig is the reverse of DirectRELPSourceConfig.TCPConfig.
+func (c *TCPSourceConfig) DirectRELPConfig() DirectRELPSourceConfig {
+	return DirectRELPSourceConfig{
+		DecoderBaseConfig: c.DecoderBaseConfig,
+		ListenersConfig:   c.ListenersConfig,
+		FilterSubConfig:   c.FilterSubConfig,
+		TlsBaseConfig:     c.TlsBaseConfig,
+		RateLimitConfig:   c.RateLimitConfig,
+		ClientAuthType:    c.ClientAuthType,
+		LineFraming:       c.LineFraming,
+		FrameDelimiter:    c.FrameDelimiter,
+		StartTLS:          c.StartTLS,
+		ProxyProtocol:     c.ProxyProtocol,
+		ConfID:            c.ConfID,
+	}
+}
+
 type Source interface {
 	FilterConf() *FilterSubConfig
 	ListenersConf() *ListenersConfig
@@ -660,6 +1763,13 @@ type Source interface {
 	SetConfID()
 }
 
+// RateLimitConfig limits the rate of messages accepted from each client.
+// Zero means no limit.
+type RateLimitConfig struct {
+	MessagesPerSec float64 `mapstructure:"max_messages_per_sec" toml:"max_messages_per_sec" json:"max_messages_per_sec"`
+	BytesPerSec    float64 `mapstructure:"max_bytes_per_sec" toml:"max_bytes_per_sec" json:"max_bytes_per_sec"`
+}
+
 type ListenersConfig struct {
 	Ports           []int         `mapstructure:"ports" toml:"ports" json:"ports"`
 	BindAddr        string        `mapstructure:"bind_addr" toml:"bind_addr" json:"bind_addr"`
@@ -667,6 +1777,8 @@ type ListenersConfig struct {
 	KeepAlive       bool          `mapstructure:"keepalive" toml:"keepalive" json:"keepalive"`
 	KeepAlivePeriod time.Duration `mapstructure:"keepalive_period" toml:"keepalive_period" json:"keepalive_period"`
 	Timeout         time.Duration `mapstructure:"timeout" toml:"timeout" json:"timeout"`
+	AllowedCIDRs    []string      `mapstructure:"allowed_cidrs" toml:"allowed_cidrs" json:"allowed_cidrs"`
+	DeniedCIDRs     []string      `mapstructure:"denied_cidrs" toml:"denied_cidrs" json:"denied_cidrs"`
 }
 
 type KafkaSourceConfig struct {
@@ -682,6 +1794,7 @@ type KafkaSourceConfig struct {
 	OffsetsMaxRetry         int           `mapstructure:"offsets_max_retry" toml:"offsets_max_retry" json:"offsets_max_retry"`
 	GroupID                 string        `mapstructure:"group_ip" toml:"group_id" json:"group_id"`
 	Topics                  []string      `mapstructure:"topics" toml:"topics" json:"topics"`
+	MaxInflight             int           `mapstructure:"max_inflight" toml:"max_inflight" json:"max_inflight"`
 }
 
 func (c *KafkaSourceConfig) FilterConf() *FilterSubConfig {
@@ -0,0 +1,98 @@
+package decoders
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// %h %l %u %t "%r" %>s %b
+const clfExpr = `^(?P<client>\S+) (?P<ident>\S+) (?P<user>\S+) \[(?P<time>[^\]]+)\] "(?P<request>(?:[^"\\]|\\.)*)" (?P<status>\d{3}|-) (?P<bytes>\d+|-)`
+
+// combined adds "%{Referer}i" "%{User-agent}i", and an optional %D (microseconds)
+var combinedRe = regexp.MustCompile(clfExpr + ` "(?P<referer>(?:[^"\\]|\\.)*)" "(?P<user_agent>(?:[^"\\]|\\.)*)"(?: (?P<duration>\d+))?\s*$`)
+
+var clfRe = regexp.MustCompile(clfExpr + `\s*$`)
+
+// nginx combined, optionally followed by "$http_x_forwarded_for" and
+// $request_time (seconds, with a milliseconds resolution)
+var nginxRe = regexp.MustCompile(clfExpr + ` "(?P<referer>(?:[^"\\]|\\.)*)" "(?P<user_agent>(?:[^"\\]|\\.)*)"(?: "(?P<forwarded_for>(?:[^"\\]|\\.)*)")?(?: (?P<duration>\d+\.\d+))?\s*$`)
+
+const accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+func pCLF(m []byte) ([]*model.SyslogMessage, error) {
+	return parseAccessLog(m, clfRe, "httpd", 0)
+}
+
+func pCombined(m []byte) ([]*model.SyslogMessage, error) {
+	return parseAccessLog(m, combinedRe, "httpd", time.Microsecond)
+}
+
+func pNginx(m []byte) ([]*model.SyslogMessage, error) {
+	return parseAccessLog(m, nginxRe, "nginx", time.Second)
+}
+
+// parseAccessLog parses an access log line. The request fields are stored
+// in the "access" properties domain. The duration, when present, is
+// converted to milliseconds with durationUnit.
+func parseAccessLog(m []byte, re *regexp.Regexp, appname string, durationUnit time.Duration) ([]*model.SyslogMessage, error) {
+	line := strings.TrimSpace(string(m))
+	match := re.FindStringSubmatch(line)
+	if match == nil {
+		return nil, AccessLogDecodingError(eerrors.New("The message does not match the access log format"))
+	}
+	msg := model.Factory()
+	msg.TimeGeneratedNum = time.Now().UnixNano()
+	msg.TimeReportedNum = msg.TimeGeneratedNum
+	msg.Facility = 1
+	msg.Severity = 6
+	msg.Version = 1
+	msg.AppName = appname
+	msg.ClearDomain("access")
+
+	for i, name := range re.SubexpNames() {
+		value := match[i]
+		if i == 0 || len(name) == 0 || len(value) == 0 || value == "-" {
+			continue
+		}
+		switch name {
+		case "time":
+			t, err := time.Parse(accessLogTimeLayout, value)
+			if err != nil {
+				return nil, AccessLogDecodingError(eerrors.Wrap(err, "Invalid access log timestamp"))
+			}
+			msg.TimeReportedNum = t.UnixNano()
+		case "request":
+			msg.Message = value
+			parts := strings.Fields(value)
+			if len(parts) == 3 {
+				msg.SetProperty("access", "method", parts[0])
+				msg.SetProperty("access", "path", parts[1])
+				msg.SetProperty("access", "protocol", parts[2])
+			}
+		case "status":
+			msg.SetProperty("access", name, value)
+			status, _ := strconv.Atoi(value)
+			switch {
+			case status >= 500:
+				msg.Severity = model.Serr
+			case status >= 400:
+				msg.Severity = model.SWarning
+			}
+		case "duration":
+			d, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				ms := d * float64(durationUnit) / float64(time.Millisecond)
+				msg.SetProperty("access", "duration_ms", strconv.FormatFloat(ms, 'f', -1, 64))
+			}
+		default:
+			msg.SetProperty("access", name, value)
+		}
+	}
+	msg.SetPriority()
+	return []*model.SyslogMessage{msg}, nil
+}
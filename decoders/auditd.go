@@ -0,0 +1,236 @@
+package decoders
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// type=SYSCALL msg=audit(1364481363.243:24287): key=value...
+var auditRecordRe = regexp.MustCompile(`(?:node=(\S+) )?type=(\S+) msg=audit\((\d+)\.(\d+):(\d+)\):\s*`)
+
+// maxPendingAuditEvents bounds the number of events that wait for their
+// remaining records.
+const maxPendingAuditEvents = 256
+
+// auditFlushTimeout is how long an event waits for its remaining records.
+const auditFlushTimeout = 2 * time.Second
+
+type auditRecord struct {
+	node   string
+	typ    string
+	fields [][2]string
+	raw    string
+}
+
+// auditKey identifies an event. The serials are only unique for a given
+// host.
+type auditKey struct {
+	host   string
+	serial string
+}
+
+type auditEvent struct {
+	key      auditKey
+	time     time.Time
+	received time.Time
+	header   *model.SyslogMessage
+	records  []auditRecord
+}
+
+// auditDecoder reassembles the audit records of an event. The kernel writes
+// the records of an event consecutively, and the multi-record events end
+// with an EOE record. So an event is complete when its EOE record arrives,
+// when a record of another event of the same host arrives, or when it has
+// waited for auditFlushTimeout.
+type auditDecoder struct {
+	mu      sync.Mutex
+	pending map[auditKey]*auditEvent
+	order   []auditKey
+	now     func() time.Time
+}
+
+func newAuditDecoder() *auditDecoder {
+	return &auditDecoder{pending: make(map[auditKey]*auditEvent), now: time.Now}
+}
+
+// parse returns the events that have been completed. When some lines are
+// invalid, the completed events are returned with the first error.
+func (d *auditDecoder) parse(m []byte) ([]*model.SyslogMessage, error) {
+	lines := strings.Split(strings.TrimSpace(string(m)), "\n")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	msgs := d.expire(now)
+	var firstErr error
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var err error
+		msgs, err = d.add(line, now, msgs)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return msgs, firstErr
+}
+
+// add appends the record to its event, and appends the completed events to
+// msgs.
+func (d *auditDecoder) add(line string, now time.Time, msgs []*model.SyslogMessage) ([]*model.SyslogMessage, error) {
+	loc := auditRecordRe.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return msgs, AuditDecodingError(eerrors.New("Audit record header not found"))
+	}
+	header, err := syslogHeader([]byte(line[:loc[0]]))
+	if err != nil {
+		return msgs, AuditDecodingError(err)
+	}
+	sec, _ := strconv.ParseInt(line[loc[6]:loc[7]], 10, 64)
+	ms, _ := strconv.ParseInt(line[loc[8]:loc[9]], 10, 64)
+	record := auditRecord{
+		typ:    line[loc[4]:loc[5]],
+		fields: parseAuditFields(line[loc[1]:]),
+		raw:    line[loc[0]:],
+	}
+	key := auditKey{host: header.HostName, serial: line[loc[10]:loc[11]]}
+	if loc[2] != -1 {
+		record.node = line[loc[2]:loc[3]]
+		key.host = record.node
+	}
+
+	// a record of another event completes the pending events of the host
+	for _, k := range append([]auditKey(nil), d.order...) {
+		if k.host == key.host && k.serial != key.serial {
+			msgs = append(msgs, d.flush(k))
+		}
+	}
+	if record.typ == "EOE" {
+		if _, ok := d.pending[key]; ok {
+			msgs = append(msgs, d.flush(key))
+		}
+		model.Free(header)
+		return msgs, nil
+	}
+	event, ok := d.pending[key]
+	if !ok {
+		event = &auditEvent{
+			key:      key,
+			time:     time.Unix(sec, ms*int64(time.Millisecond)),
+			received: now,
+			header:   header,
+		}
+		d.pending[key] = event
+		d.order = append(d.order, key)
+	} else {
+		model.Free(header)
+	}
+	event.records = append(event.records, record)
+	if len(d.order) > maxPendingAuditEvents {
+		msgs = append(msgs, d.flush(d.order[0]))
+	}
+	return msgs, nil
+}
+
+// expire returns the events that have waited for too long.
+func (d *auditDecoder) expire(now time.Time) (msgs []*model.SyslogMessage) {
+	for len(d.order) > 0 && now.Sub(d.pending[d.order[0]].received) >= auditFlushTimeout {
+		msgs = append(msgs, d.flush(d.order[0]))
+	}
+	return msgs
+}
+
+// flushAll returns all the pending events.
+func (d *auditDecoder) flushAll() (msgs []*model.SyslogMessage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.order) > 0 {
+		msgs = append(msgs, d.flush(d.order[0]))
+	}
+	return msgs
+}
+
+// flush builds the message of a pending event.
+func (d *auditDecoder) flush(key auditKey) *model.SyslogMessage {
+	event := d.pending[key]
+	delete(d.pending, key)
+	for i, k := range d.order {
+		if k == key {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+	msg := event.header
+	msg.TimeReportedNum = event.time.UnixNano()
+	msg.AppName = "auditd"
+	msg.MsgId = event.records[0].typ
+	msg.Version = 1
+	msg.ClearDomain("audit")
+	msg.SetProperty("audit", "serial", key.serial)
+	if node := event.records[0].node; len(node) > 0 {
+		msg.HostName = node
+	}
+	raws := make([]string, 0, len(event.records))
+	types := make([]string, 0, len(event.records))
+	counts := make(map[string]int)
+	for _, record := range event.records {
+		raws = append(raws, record.raw)
+		types = append(types, record.typ)
+		// the records of the same type are numbered: path.name, path.1.name...
+		prefix := strings.ToLower(record.typ)
+		if n := counts[prefix]; n > 0 {
+			prefix = prefix + "." + strconv.Itoa(n)
+		}
+		counts[strings.ToLower(record.typ)]++
+		for _, kv := range record.fields {
+			msg.SetProperty("audit", prefix+"."+kv[0], kv[1])
+		}
+	}
+	msg.SetProperty("audit", "types", strings.Join(types, ","))
+	msg.Message = strings.Join(raws, "\n")
+	msg.SetPriority()
+	return msg
+}
+
+// parseAuditFields parses the key=value fields of a record. The values may be
+// quoted. The user space records nest their fields in msg='...'.
+func parseAuditFields(s string) (fields [][2]string) {
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ")
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			break
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+		var value string
+		if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+			end := strings.IndexByte(s[1:], s[0])
+			if end == -1 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+			if key == "msg" && strings.Contains(value, "=") {
+				fields = append(fields, parseAuditFields(value)...)
+				continue
+			}
+		} else {
+			end := strings.IndexByte(s, ' ')
+			if end == -1 {
+				value, s = s, ""
+			} else {
+				value, s = s[:end], s[end:]
+			}
+		}
+		fields = append(fields, [2]string{key, value})
+	}
+	return fields
+}
@@ -0,0 +1,135 @@
+package decoders
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	auditSyscall = `type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=2 success=no exit=-13 comm="cat" exe="/usr/bin/cat"`
+	auditCwd     = `type=CWD msg=audit(1364481363.243:24287):  cwd="/home/shadowman"`
+	auditPath    = `type=PATH msg=audit(1364481363.243:24287): item=0 name="/etc/ssh/sshd_config" inode=409248`
+	auditPath1   = `type=PATH msg=audit(1364481363.243:24287): item=1 name="/etc/ssh" inode=409200`
+	auditEOE     = `type=EOE msg=audit(1364481363.243:24287): `
+	auditLogin   = `type=USER_LOGIN msg=audit(1364481364.000:24288): pid=1 uid=0 msg='op=login acct="root" res=success'`
+)
+
+// parseAll feeds the lines one by one to the decoder.
+func parseAll(t *testing.T, d *auditDecoder, lines ...string) (msgs []*model.SyslogMessage) {
+	for _, line := range lines {
+		m, err := d.parse([]byte(line))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		msgs = append(msgs, m...)
+	}
+	return msgs
+}
+
+func TestAuditMultiRecords(t *testing.T) {
+	d := newAuditDecoder()
+	msgs := parseAll(t, d, auditSyscall, auditCwd, auditPath, auditPath1)
+	assert.Empty(t, msgs)
+	msgs = parseAll(t, d, auditEOE)
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+	msg := msgs[0]
+	assert.Equal(t, "auditd", msg.AppName)
+	assert.Equal(t, "SYSCALL", msg.MsgId)
+	assert.Equal(t, int64(1364481363243)*int64(time.Millisecond), msg.TimeReportedNum)
+	assert.Equal(t, "24287", msg.GetProperty("audit", "serial"))
+	assert.Equal(t, "SYSCALL,CWD,PATH,PATH", msg.GetProperty("audit", "types"))
+	assert.Equal(t, "cat", msg.GetProperty("audit", "syscall.comm"))
+	assert.Equal(t, "/home/shadowman", msg.GetProperty("audit", "cwd.cwd"))
+	assert.Equal(t, "/etc/ssh/sshd_config", msg.GetProperty("audit", "path.name"))
+	assert.Equal(t, "/etc/ssh", msg.GetProperty("audit", "path.1.name"))
+	assert.Equal(t, 4, len(strings.Split(msg.Message, "\n")))
+	assert.Empty(t, d.flushAll())
+}
+
+func TestAuditNextEvent(t *testing.T) {
+	d := newAuditDecoder()
+	// the records of an event may be given in one message
+	msgs := parseAll(t, d, strings.Join([]string{auditSyscall, auditCwd, auditLogin}, "\n"))
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "SYSCALL,CWD", msgs[0].GetProperty("audit", "types"))
+	}
+	msgs = d.flushAll()
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "USER_LOGIN", msgs[0].MsgId)
+		assert.Equal(t, "login", msgs[0].GetProperty("audit", "user_login.op"))
+		assert.Equal(t, "root", msgs[0].GetProperty("audit", "user_login.acct"))
+	}
+}
+
+func TestAuditHosts(t *testing.T) {
+	d := newAuditDecoder()
+	// the events of different hosts are interleaved, and may share serials
+	msgs := parseAll(t, d,
+		"node=a "+auditSyscall,
+		"node=b "+auditSyscall,
+		"node=a "+auditCwd,
+		"node=b "+auditPath,
+		"node=b "+auditEOE,
+	)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "b", msgs[0].HostName)
+		assert.Equal(t, "SYSCALL,PATH", msgs[0].GetProperty("audit", "types"))
+	}
+	msgs = parseAll(t, d, "node=a "+auditEOE)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "a", msgs[0].HostName)
+		assert.Equal(t, "SYSCALL,CWD", msgs[0].GetProperty("audit", "types"))
+	}
+}
+
+func TestAuditFlushTimeout(t *testing.T) {
+	d := newAuditDecoder()
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	msgs := parseAll(t, d, "node=a "+auditSyscall)
+	assert.Empty(t, msgs)
+	now = now.Add(auditFlushTimeout)
+	msgs = parseAll(t, d, "node=b "+auditLogin)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "a", msgs[0].HostName)
+		assert.Equal(t, "SYSCALL", msgs[0].MsgId)
+	}
+}
+
+func TestAuditError(t *testing.T) {
+	d := newAuditDecoder()
+	parseAll(t, d, auditSyscall)
+	// the completed event is returned with the error
+	msgs, err := d.parse([]byte(auditLogin + "\nnot an audit record"))
+	assert.Error(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "SYSCALL", msgs[0].MsgId)
+	}
+	msgs = d.flushAll()
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "USER_LOGIN", msgs[0].MsgId)
+	}
+}
+
+func TestParsersEnvAudit(t *testing.T) {
+	env := NewParsersEnv(nil, nil)
+	c := &conf.DecoderBaseConfig{Format: "auditd", Charset: "utf8"}
+	msgs, err := env.Parse(c, []byte(auditSyscall))
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+	msgs, err = env.Parse(c, []byte(auditCwd+"\ninvalid"))
+	assert.Error(t, err)
+	assert.Empty(t, msgs)
+	msgs = env.Flush()
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "SYSCALL,CWD", msgs[0].GetProperty("audit", "types"))
+	}
+	assert.Empty(t, env.Flush())
+}
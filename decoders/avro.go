@@ -0,0 +1,149 @@
+package decoders
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// schemaRegistry fetches the Avro schemas from a Confluent schema registry,
+// and caches the codecs by schema ID.
+type schemaRegistry struct {
+	url    string
+	clt    *http.Client
+	mu     sync.Mutex
+	codecs map[uint32]*goavro.Codec
+}
+
+var registriesMu sync.Mutex
+var registries = make(map[string]*schemaRegistry)
+
+func getSchemaRegistry(url string) *schemaRegistry {
+	url = strings.TrimRight(url, "/")
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	if r, ok := registries[url]; ok {
+		return r
+	}
+	r := &schemaRegistry{
+		url:    url,
+		clt:    &http.Client{Timeout: 10 * time.Second},
+		codecs: make(map[uint32]*goavro.Codec),
+	}
+	registries[url] = r
+	return r
+}
+
+func (r *schemaRegistry) codec(id uint32) (*goavro.Codec, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.codecs[id]; ok {
+		return c, nil
+	}
+	resp, err := r.clt.Get(fmt.Sprintf("%s/schemas/ids/%d", r.url, id))
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error querying the schema registry")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, eerrors.Errorf("The schema registry returned %s for schema %d", resp.Status, id)
+	}
+	var body struct {
+		Schema string `json:"schema"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error decoding the schema registry response")
+	}
+	c, err := goavro.NewCodec(body.Schema)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Invalid schema %d", id)
+	}
+	r.codecs[id] = c
+	return c, nil
+}
+
+// AvroDecoder makes a decoder for the Confluent wire format: a zero magic
+// byte, the schema ID on 4 bytes, and the Avro binary encoded record. The
+// schemas are fetched from the registry. The record fields are flattened into
+// the "avro" properties domain, and the message is the record as JSON.
+func AvroDecoder(registryURL string) (func([]byte) ([]*model.SyslogMessage, error), error) {
+	if len(registryURL) == 0 {
+		return nil, eerrors.New("No schema registry specified for the Avro decoder")
+	}
+	registry := getSchemaRegistry(registryURL)
+
+	return func(m []byte) ([]*model.SyslogMessage, error) {
+		if len(m) < 5 || m[0] != 0 {
+			return nil, AvroDecodingError(eerrors.New("The message is not in the Confluent wire format"))
+		}
+		codec, err := registry.codec(binary.BigEndian.Uint32(m[1:5]))
+		if err != nil {
+			return nil, AvroDecodingError(err)
+		}
+		native, _, err := codec.NativeFromBinary(m[5:])
+		if err != nil {
+			return nil, AvroDecodingError(err)
+		}
+		textual, err := codec.TextualFromNative(nil, native)
+		if err != nil {
+			return nil, AvroDecodingError(err)
+		}
+		msg := model.Factory()
+		msg.TimeGeneratedNum = time.Now().UnixNano()
+		msg.TimeReportedNum = msg.TimeGeneratedNum
+		msg.Facility = 1
+		msg.Severity = 6
+		msg.Version = 1
+		msg.Message = string(textual)
+		msg.ClearDomain("avro")
+		flattenAvro(msg, "", native)
+		msg.SetPriority()
+		return []*model.SyslogMessage{msg}, nil
+	}, nil
+}
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+func flattenAvro(msg *model.SyslogMessage, key string, v interface{}) {
+	switch val := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		if len(val) == 1 {
+			// goavro wraps the union values as {"type": value}
+			for typ, inner := range val {
+				if avroPrimitives[typ] {
+					flattenAvro(msg, key, inner)
+					return
+				}
+			}
+		}
+		for k, inner := range val {
+			if len(key) > 0 {
+				k = key + "." + k
+			}
+			flattenAvro(msg, k, inner)
+		}
+	case []byte:
+		msg.SetProperty("avro", key, string(val))
+	case []interface{}:
+		b, _ := json.Marshal(val)
+		msg.SetProperty("avro", key, string(b))
+	default:
+		msg.SetProperty("avro", key, fmt.Sprintf("%v", val))
+	}
+}
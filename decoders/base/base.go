@@ -17,6 +17,17 @@ const (
 	Collectd
 	W3C
 	LTSV
+	CEF
+	CSV
+	CLF
+	Combined
+	Nginx
+	Avro
+	Cisco
+	Auditd
+	WinXML
+	Snare
+	KV
 )
 
 var Formats = map[string]Format{
@@ -30,6 +41,17 @@ var Formats = map[string]Format{
 	"collectd":    Collectd,
 	"w3c":         W3C,
 	"ltsv":        LTSV,
+	"cef":         CEF,
+	"csv":         CSV,
+	"clf":         CLF,
+	"combined":    Combined,
+	"nginx":       Nginx,
+	"avro":        Avro,
+	"cisco":       Cisco,
+	"auditd":      Auditd,
+	"winxml":      WinXML,
+	"snare":       Snare,
+	"kv":          KV,
 }
 
 func ParseFormat(format string) Format {
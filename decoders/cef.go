@@ -0,0 +1,196 @@
+package decoders
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+var cefPrefix = []byte("CEF:")
+
+var cefHeaderNames = []string{
+	"version", "device_vendor", "device_product", "device_version",
+	"signature_id", "name", "severity",
+}
+
+// cefTimeLayouts are the formats allowed for the rt extension, besides the
+// milliseconds since epoch.
+var cefTimeLayouts = []string{
+	"Jan 02 2006 15:04:05.000 MST",
+	"Jan 02 2006 15:04:05 MST",
+	"Jan 02 2006 15:04:05.000",
+	"Jan 02 2006 15:04:05",
+	"Jan 02 15:04:05.000 MST",
+	"Jan 02 15:04:05 MST",
+	"Jan 02 15:04:05.000",
+	"Jan 02 15:04:05",
+}
+
+// pCEF parses ArcSight CEF messages. The CEF payload may be preceded by a
+// RFC3164 header. The CEF header fields and the extensions are stored in the
+// "cef" properties domain.
+func pCEF(m []byte) ([]*model.SyslogMessage, error) {
+	m = bytes.TrimSpace(m)
+	idx := bytes.Index(m, cefPrefix)
+	if idx == -1 {
+		return nil, CEFDecodingError(eerrors.New("CEF prefix not found"))
+	}
+	msg, err := syslogHeader(m[:idx])
+	if err != nil {
+		return nil, CEFDecodingError(err)
+	}
+
+	fields := splitCEFHeader(string(m[idx+len(cefPrefix):]))
+	if len(fields) < len(cefHeaderNames) {
+		return nil, CEFDecodingError(eerrors.Errorf("The CEF header has %d fields, expected %d", len(fields), len(cefHeaderNames)))
+	}
+	msg.ClearDomain("cef")
+	for i, name := range cefHeaderNames {
+		msg.SetProperty("cef", name, fields[i])
+	}
+	var extensions map[string]string
+	if len(fields) > len(cefHeaderNames) {
+		extensions = parseCEFExtensions(fields[len(cefHeaderNames)])
+		for k, v := range extensions {
+			msg.SetProperty("cef", k, v)
+		}
+	}
+
+	msg.Version = 1
+	msg.MsgId = fields[4]
+	msg.Message = fields[5]
+	if s, ok := cefSeverity(fields[6]); ok {
+		msg.Severity = s
+	}
+	if len(msg.AppName) == 0 {
+		msg.AppName = fields[2]
+	}
+	if v := extensions["msg"]; len(v) > 0 {
+		msg.Message = v
+	}
+	if v := extensions["dvchost"]; len(v) > 0 {
+		msg.HostName = v
+	}
+	if v := extensions["dvcpid"]; len(v) > 0 {
+		msg.ProcId = v
+	}
+	if t, ok := cefTime(extensions["rt"]); ok {
+		msg.TimeReportedNum = t.UnixNano()
+	}
+	msg.SetPriority()
+	return []*model.SyslogMessage{msg}, nil
+}
+
+// splitCEFHeader splits the seven header fields, unescaping "\|" and "\\".
+// The last element is the raw extension string.
+func splitCEFHeader(s string) []string {
+	fields := make([]string, 0, len(cefHeaderNames)+1)
+	var field strings.Builder
+	for i := 0; i < len(s); i++ {
+		if len(fields) == len(cefHeaderNames) {
+			return append(fields, s[i:])
+		}
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && (s[i+1] == '|' || s[i+1] == '\\'):
+			i++
+			field.WriteByte(s[i])
+		case s[i] == '|':
+			fields = append(fields, strings.TrimSpace(field.String()))
+			field.Reset()
+		default:
+			field.WriteByte(s[i])
+		}
+	}
+	if len(fields) < len(cefHeaderNames) {
+		fields = append(fields, strings.TrimSpace(field.String()))
+	}
+	return fields
+}
+
+var cefValueUnescaper = strings.NewReplacer(`\\`, `\`, `\=`, `=`, `\n`, "\n", `\r`, "\r")
+
+// parseCEFExtensions parses the "key=value" extensions. The values may
+// contain spaces: a value ends at the space that precedes the next key.
+func parseCEFExtensions(s string) map[string]string {
+	// positions of the unescaped '='
+	var eqs []int
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == '=' {
+			eqs = append(eqs, i)
+		}
+	}
+	exts := make(map[string]string, len(eqs))
+	keyStart := 0
+	for j := 0; j < len(eqs); j++ {
+		key := strings.TrimSpace(s[keyStart:eqs[j]])
+		valueEnd := len(s)
+		next := j + 1
+		for ; next < len(eqs); next++ {
+			// the next key is the word before the next '='
+			if sp := strings.LastIndexByte(s[eqs[j]+1:eqs[next]], ' '); sp != -1 {
+				valueEnd = eqs[j] + 1 + sp
+				break
+			}
+		}
+		if len(key) > 0 {
+			exts[key] = cefValueUnescaper.Replace(strings.TrimSpace(s[eqs[j]+1 : valueEnd]))
+		}
+		keyStart = valueEnd
+		j = next - 1
+	}
+	return exts
+}
+
+// cefSeverity maps the CEF severity, 0-10 or Low/Medium/High/Very-High, to a
+// syslog severity.
+func cefSeverity(s string) (model.Severity, bool) {
+	switch strings.ToLower(s) {
+	case "low":
+		return model.Snotice, true
+	case "medium":
+		return model.SWarning, true
+	case "high":
+		return model.Serr, true
+	case "very-high":
+		return model.Scrit, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 10 {
+		return 0, false
+	}
+	switch {
+	case n <= 3:
+		return model.Snotice, true
+	case n <= 6:
+		return model.SWarning, true
+	case n <= 8:
+		return model.Serr, true
+	default:
+		return model.Scrit, true
+	}
+}
+
+func cefTime(s string) (time.Time, bool) {
+	if len(s) == 0 {
+		return time.Time{}, false
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), true
+	}
+	for _, layout := range cefTimeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			if t.Year() == 0 {
+				t = t.AddDate(time.Now().Year(), 0, 0)
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
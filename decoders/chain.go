@@ -0,0 +1,104 @@
+package decoders
+
+import (
+	"strings"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/decoders/base"
+	"github.com/stephane-martin/skewer/model"
+)
+
+// chainSeparator separates the decoders of a chain, like "rfc5424|json|kv".
+const chainSeparator = "|"
+
+// chainStages returns the decoders of a chain, or nil if the format is not a
+// chain.
+func chainStages(format string) []string {
+	if !strings.Contains(format, chainSeparator) {
+		return nil
+	}
+	stages := strings.Split(format, chainSeparator)
+	for i := range stages {
+		stages[i] = strings.TrimSpace(stages[i])
+	}
+	return stages
+}
+
+// chainParser runs a chain of decoders. The first decoder parses the raw
+// message. The next decoders parse the Message field of the resulting
+// messages, and their properties are added to the messages. When a decoder
+// can't parse a message, the message is kept as refined by the previous
+// decoders.
+type chainParser struct {
+	env    *ParsersEnv
+	stages []conf.DecoderBaseConfig
+	// the json decoders in the chain extract all the JSON values into the
+	// json properties domain
+	jsonParsers map[int]func([]byte) ([]*model.SyslogMessage, error)
+}
+
+func (e *ParsersEnv) newChainParser(c *conf.DecoderBaseConfig, formats []string) *chainParser {
+	p := &chainParser{
+		env:         e,
+		stages:      make([]conf.DecoderBaseConfig, 0, len(formats)),
+		jsonParsers: make(map[int]func([]byte) ([]*model.SyslogMessage, error)),
+	}
+	for i, format := range formats {
+		stage := *c
+		stage.Format = format
+		p.stages = append(p.stages, stage)
+		if i > 0 && base.ParseFormat(format) == base.JSON {
+			p.jsonParsers[i] = JSONMappingDecoder(&stage)
+		}
+	}
+	return p
+}
+
+func (e *ParsersEnv) getChainParser(c *conf.DecoderBaseConfig, formats []string) *chainParser {
+	if thing, have := e.parserCache.Get(c); have {
+		return thing.(*chainParser)
+	}
+	e.Lock()
+	defer e.Unlock()
+	p := e.newChainParser(c, formats)
+	e.parserCache.Put(c, p)
+	return p
+}
+
+func (p *chainParser) Release() {}
+
+func (p *chainParser) Parse(m []byte) ([]*model.SyslogMessage, error) {
+	msgs, parseErr := p.env.Parse(&p.stages[0], m)
+	if len(msgs) == 0 {
+		return nil, parseErr
+	}
+	for i := 1; i < len(p.stages); i++ {
+		for _, msg := range msgs {
+			if len(msg.Message) == 0 {
+				continue
+			}
+			var refined []*model.SyslogMessage
+			var err error
+			if jsonParser, ok := p.jsonParsers[i]; ok {
+				refined, err = jsonParser([]byte(msg.Message))
+			} else {
+				refined, err = p.env.Parse(&p.stages[i], []byte(msg.Message))
+			}
+			if err != nil {
+				continue
+			}
+			for _, r := range refined {
+				for domain, props := range r.Properties.Map {
+					if props == nil {
+						continue
+					}
+					for k, v := range props.Map {
+						msg.SetProperty(domain, k, v)
+					}
+				}
+				model.Free(r)
+			}
+		}
+	}
+	return msgs, parseErr
+}
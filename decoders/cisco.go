@@ -0,0 +1,104 @@
+package decoders
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// %FACILITY-SEVERITY-MNEMONIC: text
+var ciscoTagRe = regexp.MustCompile(`%([A-Z][A-Z0-9_]*(?:-[A-Z0-9_]+)*)-([0-7])-([A-Z0-9_]+):?\s*`)
+
+// ciscoFieldsRes extract the connection and ACL fields from the message text.
+// The named groups are stored in the "cisco" properties domain.
+var ciscoFieldsRes = []*regexp.Regexp{
+	// ASA: Deny tcp src outside:1.2.3.4/1234 dst inside:10.0.0.1/22 by access-group "OUTSIDE_IN"
+	regexp.MustCompile(`(?P<action>Deny) (?P<protocol>\w+) src (?P<src_interface>[^:\s]+):(?P<src_ip>[^/\s]+)/(?P<src_port>\d+) dst (?P<dst_interface>[^:\s]+):(?P<dst_ip>[^/\s]+)/(?P<dst_port>\d+)(?: by access-group "(?P<acl>[^"]+)")?`),
+	// ASA: access-list ACL permitted tcp inside/10.0.0.1(1234) -> outside/8.8.8.8(53)
+	regexp.MustCompile(`access-list (?P<acl>\S+) (?P<action>permitted|denied|est-allowed) (?P<protocol>\w+) (?P<src_interface>[^/\s]+)/(?P<src_ip>[^(\s]+)\((?P<src_port>\d+)\)(?:\([^)]*\))? -> (?P<dst_interface>[^/\s]+)/(?P<dst_ip>[^(\s]+)\((?P<dst_port>\d+)\)`),
+	// IOS: list ACL permitted tcp 10.0.0.1(1234) -> 10.0.0.2(80), 1 packet
+	regexp.MustCompile(`list (?P<acl>\S+) (?P<action>permitted|denied) (?P<protocol>\w+) (?P<src_ip>[^(\s]+)\((?P<src_port>\d+)\)(?: \([^)]*\))? -> (?P<dst_ip>[^(\s,]+)\((?P<dst_port>\d+)\)`),
+	// ASA: Built outbound TCP connection 123 for outside:1.2.3.4/443 (1.2.3.4/443) to inside:10.0.0.1/5000 (2.2.2.2/5000)
+	regexp.MustCompile(`(?P<action>Built|Teardown) (?:(?P<direction>inbound|outbound) )?(?P<protocol>\w+) connection (?P<connection_id>\d+) for (?P<for_interface>[^:\s]+):(?P<for_ip>[^/\s]+)/(?P<for_port>\d+)(?: \([^)]*\))? to (?P<to_interface>[^:\s]+):(?P<to_ip>[^/\s]+)/(?P<to_port>\d+)`),
+	regexp.MustCompile(`duration (?P<duration>\d+:\d{2}:\d{2})`),
+	regexp.MustCompile(`bytes (?P<bytes>\d+)`),
+}
+
+var ciscoForTo = []string{"interface", "ip", "port"}
+
+// pCisco parses the Cisco ASA and IOS messages, like
+// "<PRI>TIMESTAMP HOSTNAME : %ASA-6-302013: text". The syslog header is
+// optional.
+func pCisco(m []byte) ([]*model.SyslogMessage, error) {
+	line := strings.TrimSpace(string(m))
+	loc := ciscoTagRe.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return nil, CiscoDecodingError(eerrors.New("Cisco message tag not found"))
+	}
+	msg, err := syslogHeader([]byte(line[:loc[0]]))
+	if err != nil {
+		return nil, CiscoDecodingError(err)
+	}
+	if len(msg.HostName) == 0 && len(msg.Message) > 0 {
+		// ASA timestamps have the year: "Jan 05 2020 10:00:00 fw01"
+		parts := strings.Fields(msg.Message)
+		if len(parts) >= 4 {
+			t, err := time.Parse("Jan 2 2006 15:04:05", strings.Join(parts[:4], " "))
+			if err == nil {
+				msg.TimeReportedNum = t.UnixNano()
+				if len(parts) == 5 {
+					msg.HostName = parts[4]
+				}
+			}
+		}
+	}
+
+	facility := line[loc[2]:loc[3]]
+	mnemonic := line[loc[6]:loc[7]]
+	msg.Severity = model.Severity(line[loc[4]] - '0')
+	msg.AppName = facility
+	msg.MsgId = line[loc[2]:loc[7]]
+	msg.Message = line[loc[1]:]
+	msg.Version = 1
+	msg.ClearDomain("cisco")
+	msg.SetProperty("cisco", "facility", facility)
+	msg.SetProperty("cisco", "severity", line[loc[4]:loc[5]])
+	msg.SetProperty("cisco", "mnemonic", mnemonic)
+
+	fields := make(map[string]string)
+	for _, re := range ciscoFieldsRes {
+		match := re.FindStringSubmatch(msg.Message)
+		if match == nil {
+			continue
+		}
+		for i, name := range re.SubexpNames() {
+			if i > 0 && len(name) > 0 && len(match[i]) > 0 {
+				if _, ok := fields[name]; !ok {
+					fields[name] = match[i]
+				}
+			}
+		}
+	}
+	if _, ok := fields["for_ip"]; ok {
+		// "for" is the foreign side: the source of inbound connections, and
+		// the destination of outbound connections
+		src, dst := "for_", "to_"
+		if fields["direction"] == "outbound" {
+			src, dst = dst, src
+		}
+		for _, f := range ciscoForTo {
+			fields["src_"+f] = fields[src+f]
+			fields["dst_"+f] = fields[dst+f]
+			delete(fields, "for_"+f)
+			delete(fields, "to_"+f)
+		}
+	}
+	for k, v := range fields {
+		msg.SetProperty("cisco", k, v)
+	}
+	msg.SetPriority()
+	return []*model.SyslogMessage{msg}, nil
+}
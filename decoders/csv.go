@@ -0,0 +1,114 @@
+package decoders
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// CSVDecoder makes a CSV/TSV decoder. fieldNames lists the columns, separated
+// by spaces or commas. The empty delimiter means ",", and "tab" means a
+// tabulation. The empty quote means '"'. Each record becomes a message, and
+// the columns are stored in the "csv" properties domain.
+func CSVDecoder(fieldNames, delimiter, quote string) (func([]byte) ([]*model.SyslogMessage, error), error) {
+	fields := strings.FieldsFunc(fieldNames, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(fields) == 0 {
+		return nil, eerrors.New("No fields specified for CSV decoder")
+	}
+	delim, err := csvRune(delimiter, ',')
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Invalid CSV delimiter")
+	}
+	quoteChar, err := csvRune(quote, '"')
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Invalid CSV quote")
+	}
+	if delim == quoteChar {
+		return nil, eerrors.New("The CSV delimiter and quote must be different")
+	}
+
+	return func(m []byte) (msgs []*model.SyslogMessage, err error) {
+		records, err := splitCSV(string(m), delim, quoteChar)
+		if err != nil {
+			return nil, CSVDecodingError(err)
+		}
+		msgs = make([]*model.SyslogMessage, 0, len(records))
+		for _, record := range records {
+			msg := model.Factory()
+			msg.ClearDomain("csv")
+			for i, value := range record {
+				if i >= len(fields) {
+					break
+				}
+				if fields[i] != "-" {
+					msg.SetProperty("csv", fields[i], value)
+				}
+			}
+			msgs = append(msgs, msg)
+		}
+		return msgs, nil
+	}, nil
+}
+
+func csvRune(s string, def rune) (rune, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return def, nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == utf8.RuneError || r == '\r' || r == '\n' {
+		return 0, eerrors.Errorf("Expected a single character: '%s'", s)
+	}
+	return r, nil
+}
+
+// splitCSV splits the records and their fields. A quote in a quoted field is
+// escaped by doubling it. The empty lines are skipped.
+func splitCSV(s string, delim, quote rune) (records [][]string, err error) {
+	var record []string
+	var field strings.Builder
+	quoted := false
+	endRecord := func() {
+		record = append(record, field.String())
+		field.Reset()
+		if len(record) > 1 || len(record[0]) > 0 {
+			records = append(records, record)
+		}
+		record = nil
+	}
+	for i, r := range s {
+		switch {
+		case quoted && r == quote:
+			if strings.HasPrefix(s[i+utf8.RuneLen(r):], string(quote)) {
+				// doubled quote: the next one is skipped below
+				field.WriteRune(quote)
+				quoted = false
+				continue
+			}
+			quoted = false
+		case quoted:
+			field.WriteRune(r)
+		case r == quote:
+			quoted = true
+		case r == delim:
+			record = append(record, field.String())
+			field.Reset()
+		case r == '\n':
+			endRecord()
+		case r == '\r':
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, eerrors.New("Unterminated quoted field")
+	}
+	endRecord()
+	return records, nil
+}
@@ -2,6 +2,7 @@ package decoders
 
 import (
 	"sync"
+	"time"
 
 	"github.com/inconshreveable/log15"
 	"github.com/stephane-martin/skewer/conf"
@@ -10,6 +11,7 @@ import (
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/goplugin"
 	"github.com/zond/gotomic"
 	"golang.org/x/text/encoding/unicode"
 )
@@ -26,7 +28,33 @@ var parsers = map[base.Format](func([]byte) ([]*model.SyslogMessage, error)){
 	base.Protobuf:    pProtobuf,
 	base.Collectd:    pCollectd,
 	base.LTSV:        pLTSV,
+	base.CEF:         pCEF,
 	base.W3C:         nil,
+	base.CSV:         nil,
+	base.CLF:         pCLF,
+	base.Combined:    pCombined,
+	base.Nginx:       pNginx,
+	base.Avro:        nil,
+	base.Cisco:       pCisco,
+	base.Auditd:      nil,
+	base.WinXML:      pWinXML,
+	base.Snare:       pSnare,
+	base.KV:          nil,
+}
+
+// IsBinary returns true when the format decodes binary payloads, that must
+// not be trimmed.
+func IsBinary(format string) bool {
+	if stages := chainStages(format); stages != nil {
+		// only the first decoder of a chain parses the payload
+		format = stages[0]
+	}
+	switch base.ParseFormat(format) {
+	case base.Avro, base.GELF:
+		return true
+	default:
+		return false
+	}
 }
 
 type Parser interface {
@@ -65,23 +93,66 @@ type ParsersEnv struct {
 	sync.Mutex
 	parserCache *gotomic.Hash
 	jsFuncs     map[string]string
+	regexps     map[string][]string
+	processes   map[string]*processDecoder
+	plugins     map[string]string
+	wasms       map[string]string
+	luas        map[string]string
 	jsEnvsPool  *sync.Pool
 	logger      log15.Logger
+	// the auditd decoders keep the records of the incomplete events
+	audits []*auditDecoder
 }
 
 func NewParsersEnv(config []conf.ParserConfig, logger log15.Logger) *ParsersEnv {
 	env := ParsersEnv{
 		jsFuncs:     make(map[string]string, len(config)),
+		regexps:     make(map[string][]string, len(config)),
+		processes:   make(map[string]*processDecoder, len(config)),
+		plugins:     make(map[string]string, len(config)),
+		wasms:       make(map[string]string, len(config)),
+		luas:        make(map[string]string, len(config)),
 		logger:      logger,
 		parserCache: gotomic.NewHash(),
 	}
 	for _, c := range config {
-		env.jsFuncs[c.Name] = c.Func
+		if len(c.Regexps) > 0 {
+			env.regexps[c.Name] = c.Regexps
+		} else if len(c.Command) > 0 {
+			// the external commands are started on the first message
+			env.processes[c.Name] = newProcessDecoder(c.Name, c.Command, logger)
+		} else if len(c.Plugin) > 0 {
+			env.plugins[c.Name] = c.Plugin
+		} else if len(c.Wasm) > 0 {
+			env.wasms[c.Name] = c.Wasm
+		} else if c.Language == "lua" {
+			env.luas[c.Name] = c.Func
+		} else {
+			env.jsFuncs[c.Name] = c.Func
+		}
 	}
 	env.jsEnvsPool = &sync.Pool{New: env.newJSEnv}
 	return &env
 }
 
+// Close stops the external parsers.
+func (e *ParsersEnv) Close() {
+	for _, p := range e.processes {
+		p.Close()
+	}
+}
+
+// Flush returns the incomplete events kept by the auditd decoders. It is
+// called when no more messages will be parsed.
+func (e *ParsersEnv) Flush() (msgs []*model.SyslogMessage) {
+	e.Lock()
+	defer e.Unlock()
+	for _, d := range e.audits {
+		msgs = append(msgs, d.flushAll()...)
+	}
+	return msgs
+}
+
 func (e *ParsersEnv) newJSEnv() interface{} {
 	jsEnv := javascript.NewParsersEnvironment(e.logger)
 	var jsFuncName, jsFuncBody string
@@ -99,6 +170,8 @@ func (e *ParsersEnv) getJSEnv() *javascript.Environment {
 	return e.jsEnvsPool.Get().(*javascript.Environment)
 }
 
+// Parse decodes a raw message. When an error is returned, the messages may
+// not be empty, and must be handled too.
 func (e *ParsersEnv) Parse(c *conf.DecoderBaseConfig, m []byte) ([]*model.SyslogMessage, error) {
 	if len(m) == 0 {
 		return nil, nil
@@ -113,14 +186,44 @@ func (e *ParsersEnv) Parse(c *conf.DecoderBaseConfig, m []byte) ([]*model.Syslog
 	syslogMsgs, err := parser.Parse(m)
 	parser.Release()
 	if err != nil {
-		return nil, DecodingError(eerrors.Wrap(err, "Parsing error"))
+		// the auditd decoder returns the events that were completed before
+		// the error
+		return syslogMsgs, DecodingError(eerrors.Wrap(err, "Parsing error"))
 	}
 	return syslogMsgs, nil
 }
 
 func (e *ParsersEnv) getParser(c *conf.DecoderBaseConfig) (p Parser, err error) {
+	if stages := chainStages(c.Format); stages != nil {
+		return e.getChainParser(c, stages), nil
+	}
 	frmt := base.ParseFormat(c.Format)
 	if frmt == -1 {
+		if _, ok := e.regexps[c.Format]; ok {
+			return e.getRegexpParser(c)
+		}
+		if p, ok := e.processes[c.Format]; ok {
+			// the raw message is given as is to the external command
+			return &nativeParser{baseParser: p.parse}, nil
+		}
+		if path, ok := e.plugins[c.Format]; ok {
+			// the plugins are loaded only once, so we don't need to cache them
+			p, err := goplugin.Parser(path)
+			if err != nil {
+				return nil, err
+			}
+			return &nativeParser{baseParser: p}, nil
+		}
+		if path, ok := e.wasms[c.Format]; ok {
+			p, err := WasmDecoder(path)
+			if err != nil {
+				return nil, err
+			}
+			return &nativeParser{baseParser: p}, nil
+		}
+		if _, ok := e.luas[c.Format]; ok {
+			return e.getLuaParser(c)
+		}
 		// look for a JS function
 		return e.getJSParser(c.Format)
 	}
@@ -160,6 +263,34 @@ func (e *ParsersEnv) getNonJSParser(frmt base.Format, c *conf.DecoderBaseConfig)
 			return nil, DecodingError(eerrors.New("No fields specified for W3C Extended Log Format decoder"))
 		}
 		p = W3CDecoder(c.W3CFields)
+	} else if frmt == base.CSV {
+		// CSV parser is parametrized too
+		var err error
+		p, err = CSVDecoder(c.CSVFields, c.CSVDelimiter, c.CSVQuote)
+		if err != nil {
+			return nil, DecodingError(err)
+		}
+	} else if frmt == base.Avro {
+		var err error
+		p, err = AvroDecoder(c.AvroRegistryURL)
+		if err != nil {
+			return nil, DecodingError(err)
+		}
+	} else if frmt == base.Auditd {
+		// the auditd parser keeps the records of the incomplete events
+		d := newAuditDecoder()
+		e.audits = append(e.audits, d)
+		p = d.parse
+	} else if frmt == base.RFC3164 && len(c.Timezone) > 0 {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return nil, DecodingError(eerrors.Wrapf(err, "Unknown timezone '%s'", c.Timezone))
+		}
+		p = RFC3164Decoder(loc)
+	} else if frmt == base.KV {
+		p = KVDecoder(c.KVPairSeparator, c.KVSeparator, c.KVQuotes)
+	} else if frmt == base.JSON && c.JSONMapped() {
+		p = JSONMappingDecoder(c)
 	} else {
 		p = parsers[frmt]
 	}
@@ -171,9 +302,40 @@ func (e *ParsersEnv) getNonJSParser(frmt base.Format, c *conf.DecoderBaseConfig)
 	return &nativeParser{baseParser: p}, nil
 }
 
+func (e *ParsersEnv) getRegexpParser(c *conf.DecoderBaseConfig) (*nativeParser, error) {
+	if thing, have := e.parserCache.Get(c); have {
+		return &nativeParser{baseParser: thing.(func([]byte) ([]*model.SyslogMessage, error))}, nil
+	}
+	e.Lock()
+	defer e.Unlock()
+	p, err := RegexpDecoder(c.Format, e.regexps[c.Format])
+	if err != nil {
+		return nil, DecodingError(err)
+	}
+	// the regexps apply to text, so the charset is decoded like for RFC3164
+	p = parserWithEncoding(base.RFC3164, c.Charset, p)
+	e.parserCache.Put(c, p)
+	return &nativeParser{baseParser: p}, nil
+}
+
+func (e *ParsersEnv) getLuaParser(c *conf.DecoderBaseConfig) (*nativeParser, error) {
+	if thing, have := e.parserCache.Get(c); have {
+		return &nativeParser{baseParser: thing.(func([]byte) ([]*model.SyslogMessage, error))}, nil
+	}
+	e.Lock()
+	defer e.Unlock()
+	p, err := LuaDecoder(c.Format, e.luas[c.Format])
+	if err != nil {
+		return nil, err
+	}
+	p = parserWithEncoding(base.RFC3164, c.Charset, p)
+	e.parserCache.Put(c, p)
+	return &nativeParser{baseParser: p}, nil
+}
+
 func parserWithEncoding(frmt base.Format, charset string, p func([]byte) ([]*model.SyslogMessage, error)) func([]byte) ([]*model.SyslogMessage, error) {
 	switch frmt {
-	case base.RFC3164, base.RFC5424, base.W3C:
+	case base.RFC3164, base.RFC5424, base.W3C, base.CEF, base.CSV, base.CLF, base.Combined, base.Nginx, base.Cisco, base.Auditd, base.Snare, base.KV:
 		return func(m []byte) ([]*model.SyslogMessage, error) {
 			var err error
 			m, err = utils.SelectDecoder(charset).Bytes(m)
@@ -182,7 +344,7 @@ func parserWithEncoding(frmt base.Format, charset string, p func([]byte) ([]*mod
 			}
 			return p(m)
 		}
-	case base.JSON, base.RsyslogJSON, base.GELF, base.InfluxDB, -1:
+	case base.JSON, base.RsyslogJSON, base.InfluxDB, base.WinXML, -1:
 		return func(m []byte) ([]*model.SyslogMessage, error) {
 			var err error
 			m, err = unicode.UTF8.NewDecoder().Bytes(m)
@@ -191,7 +353,8 @@ func parserWithEncoding(frmt base.Format, charset string, p func([]byte) ([]*mod
 			}
 			return p(m)
 		}
-	case base.Protobuf, base.Collectd:
+	case base.Protobuf, base.Collectd, base.Avro, base.GELF:
+		// GELF may be compressed: the charset is checked after decompression
 		return p
 	default:
 		return p
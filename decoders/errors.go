@@ -53,6 +53,72 @@ func W3CDecodingError(err error) error {
 	)
 }
 
+func CEFDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding CEF message"),
+	)
+}
+
+func CSVDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding CSV message"),
+	)
+}
+
+func RegexpDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding message with regexps"),
+	)
+}
+
+func AccessLogDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding access log message"),
+	)
+}
+
+func AvroDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding Avro message"),
+	)
+}
+
+func CiscoDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding Cisco message"),
+	)
+}
+
+func AuditDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding audit record"),
+	)
+}
+
+func WindowsDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding Windows event"),
+	)
+}
+
+func LuaDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding message with Lua script"),
+	)
+}
+
+func WasmDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding message with WASM module"),
+	)
+}
+
+func ProcessDecodingError(err error) error {
+	return DecodingError(
+		eerrors.Wrap(err, "Error decoding message with external process"),
+	)
+}
+
 var ErrInvalidSD = DecodingError(eerrors.New("Invalid structured data"))
 
 var ErrInvalidPriority = DecodingError(eerrors.New("Invalid priority field"))
@@ -1,16 +1,77 @@
 package decoders
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 	"time"
 
 	"github.com/Graylog2/go-gelf/gelf"
 	"github.com/stephane-martin/skewer/model"
+	"golang.org/x/text/encoding/unicode"
 )
 
+var (
+	magicZlib = []byte{0x78}
+	magicGzip = []byte{0x1f, 0x8b}
+)
+
+// gelfMaxSize limits the size of the decompressed GELF messages.
+const gelfMaxSize = 16 * 1024 * 1024
+
+// DecompressGELF decompresses the gzip or zlib compressed GELF messages. The
+// other messages are returned as is.
+func DecompressGELF(buf []byte, maxSize int) (decompressed []byte, err error) {
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("GELF message was too short")
+	}
+	head := buf[:2]
+	var reader io.Reader
+	// the data we get from the wire is compressed
+	if bytes.Equal(head, magicGzip) {
+		reader, err = gzip.NewReader(bytes.NewReader(buf))
+	} else if head[0] == magicZlib[0] && (int(head[0])*256+int(head[1]))%31 == 0 {
+		reader, err = zlib.NewReader(bytes.NewReader(buf))
+	} else {
+		// compliance with https://github.com/Graylog2/graylog2-server
+		// treating all messages as uncompressed if  they are not gzip, zlib or
+		// chunked
+		return buf, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("NewReader: %s", err)
+	}
+
+	// protect against decompression bombs
+	decompressed, err = ioutil.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("Decompression error: %s", err)
+	}
+	if len(decompressed) > maxSize {
+		return nil, fmt.Errorf("GELF message is larger than %d bytes", maxSize)
+	}
+	return decompressed, nil
+}
+
+// pGELF decodes the GELF messages, possibly compressed, that are found
+// outside of the Graylog source, for example in Kafka topics.
 func pGELF(m []byte) ([]*model.SyslogMessage, error) {
+	m, err := DecompressGELF(m, gelfMaxSize)
+	if err != nil {
+		return nil, DecodingError(err)
+	}
+	// we assume GELF is always UTF-8
+	m, err = unicode.UTF8.NewDecoder().Bytes(m)
+	if err != nil {
+		return nil, InvalidCharsetError(err)
+	}
 	gelfMsg := &gelf.Message{}
-	err := gelfMsg.UnmarshalJSON(m)
+	err = gelfMsg.UnmarshalJSON(m)
 	if err != nil {
 		return nil, UnmarshalJsonError(err)
 	}
@@ -47,9 +108,15 @@ func fromGelfMessage(m *model.SyslogMessage, gelfm *gelf.Message) {
 	m.Version = 1
 	m.Severity = model.Severity(gelfm.Level)
 
+	// GELF additional fields are prefixed with an underscore
+	extra := make(map[string]interface{}, len(gelfm.Extra))
+	for k, v := range gelfm.Extra {
+		extra[strings.TrimPrefix(k, "_")] = v
+	}
+
 	if len(gelfm.Facility) > 0 {
 		m.Facility = model.FacilityFromString(gelfm.Facility)
-	} else if fs, ok := gelfm.Extra["facility"]; ok {
+	} else if fs, ok := extra["facility"]; ok {
 		m.Facility = model.FacilityFromString(fmt.Sprintf("%s", fs))
 	} else {
 		m.Facility = 1
@@ -57,22 +124,22 @@ func fromGelfMessage(m *model.SyslogMessage, gelfm *gelf.Message) {
 	m.SetPriority()
 
 	m.AppName = ""
-	if appname, ok := gelfm.Extra["appname"]; ok {
+	if appname, ok := extra["appname"]; ok {
 		m.AppName = fmt.Sprintf("%s", appname)
 	}
 	m.ProcId = ""
-	if procid, ok := gelfm.Extra["procid"]; ok {
+	if procid, ok := extra["procid"]; ok {
 		m.ProcId = fmt.Sprintf("%s", procid)
 	}
 	m.MsgId = ""
-	if msgid, ok := gelfm.Extra["msgid"]; ok {
+	if msgid, ok := extra["msgid"]; ok {
 		m.MsgId = fmt.Sprintf("%s", msgid)
 	}
 	m.ClearProperties()
 	if len(gelfm.Full) > 0 {
 		m.SetProperty("gelf", "full", gelfm.Full)
 	}
-	for k, v := range gelfm.Extra {
+	for k, v := range extra {
 		switch k {
 		case "facility", "appname", "procid", "msgid":
 		default:
@@ -0,0 +1,197 @@
+package decoders
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// severityAliases are the usual level names of the logging libraries.
+var severityAliases = map[string]model.Severity{
+	"emergency":   model.Semerg,
+	"fatal":       model.Scrit,
+	"critical":    model.Scrit,
+	"error":       model.Serr,
+	"warn":        model.SWarning,
+	"information": model.Sinfo,
+	"trace":       model.Sdebug,
+}
+
+type jsonMapping struct {
+	timestamp []string
+	layout    string
+	severity  []string
+	message   []string
+	hostname  []string
+	appname   []string
+}
+
+func splitJSONPath(path string) []string {
+	if len(path) == 0 {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// JSONMappingDecoder makes a JSON decoder that maps the configured JSON paths
+// onto the message fields. The other values are flattened into the "json"
+// properties domain, with dotted keys.
+func JSONMappingDecoder(c *conf.DecoderBaseConfig) func([]byte) ([]*model.SyslogMessage, error) {
+	mapping := jsonMapping{
+		timestamp: splitJSONPath(c.JSONTimestampPath),
+		layout:    c.JSONTimestampLayout,
+		severity:  splitJSONPath(c.JSONSeverityPath),
+		message:   splitJSONPath(c.JSONMessagePath),
+		hostname:  splitJSONPath(c.JSONHostnamePath),
+		appname:   splitJSONPath(c.JSONAppnamePath),
+	}
+	if len(mapping.layout) == 0 {
+		mapping.layout = time.RFC3339Nano
+	}
+	mapped := map[string]bool{
+		c.JSONTimestampPath: true,
+		c.JSONSeverityPath:  true,
+		c.JSONMessagePath:   true,
+		c.JSONHostnamePath:  true,
+		c.JSONAppnamePath:   true,
+	}
+
+	return func(m []byte) ([]*model.SyslogMessage, error) {
+		var doc map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(m))
+		dec.UseNumber()
+		err := dec.Decode(&doc)
+		if err != nil {
+			return nil, UnmarshalJsonError(err)
+		}
+		msg := model.Factory()
+		msg.TimeGeneratedNum = time.Now().UnixNano()
+		msg.TimeReportedNum = msg.TimeGeneratedNum
+		msg.Facility = 1
+		msg.Severity = 6
+		msg.Version = 1
+
+		if v, ok := jsonPath(doc, mapping.timestamp); ok {
+			t, err := jsonTimestamp(v, mapping.layout)
+			if err != nil {
+				return nil, DecodingError(eerrors.Wrap(err, "Invalid timestamp"))
+			}
+			msg.TimeReportedNum = t.UnixNano()
+		}
+		if v, ok := jsonPath(doc, mapping.severity); ok {
+			if s, ok := jsonSeverity(v); ok {
+				msg.Severity = s
+			}
+		}
+		if v, ok := jsonPath(doc, mapping.message); ok {
+			msg.Message = jsonString(v)
+		}
+		if v, ok := jsonPath(doc, mapping.hostname); ok {
+			msg.HostName = jsonString(v)
+		}
+		if v, ok := jsonPath(doc, mapping.appname); ok {
+			msg.AppName = jsonString(v)
+		}
+		msg.ClearDomain("json")
+		flattenJSON(msg, "", doc, mapped)
+		msg.SetPriority()
+		return []*model.SyslogMessage{msg}, nil
+	}
+}
+
+func jsonPath(doc map[string]interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	var cur interface{} = doc
+	for _, key := range path {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = obj[key]
+		if !ok || cur == nil {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func jsonString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(val)
+		return string(b)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// jsonTimestamp parses the timestamp with the layout, or as a number of
+// seconds ("unix"), milliseconds ("unixms") or nanoseconds ("unixnano").
+func jsonTimestamp(v interface{}, layout string) (time.Time, error) {
+	s := jsonString(v)
+	var unit float64
+	switch layout {
+	case "unix":
+		unit = float64(time.Second)
+	case "unixms":
+		unit = float64(time.Millisecond)
+	case "unixnano":
+		unit = 1
+	default:
+		return time.Parse(layout, s)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ns := f * unit
+	sec := math.Floor(ns / float64(time.Second))
+	return time.Unix(int64(sec), int64(ns-sec*float64(time.Second))), nil
+}
+
+// jsonSeverity accepts the syslog severity names and numbers, and the usual
+// level names.
+func jsonSeverity(v interface{}) (model.Severity, bool) {
+	s := strings.ToLower(strings.TrimSpace(jsonString(v)))
+	if sev, ok := model.RSeverities[s]; ok {
+		return sev, true
+	}
+	if sev, ok := severityAliases[s]; ok {
+		return sev, true
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n <= 7 {
+		return model.Severity(n), true
+	}
+	return 0, false
+}
+
+func flattenJSON(msg *model.SyslogMessage, prefix string, obj map[string]interface{}, mapped map[string]bool) {
+	for k, v := range obj {
+		key := k
+		if len(prefix) > 0 {
+			key = prefix + "." + k
+		}
+		if mapped[key] || v == nil {
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			flattenJSON(msg, key, sub, mapped)
+			continue
+		}
+		msg.SetProperty("json", key, jsonString(v))
+	}
+}
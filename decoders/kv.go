@@ -0,0 +1,94 @@
+package decoders
+
+import (
+	"strings"
+
+	"github.com/stephane-martin/skewer/model"
+)
+
+type kvParser struct {
+	pairSep string
+	kvSep   string
+	quotes  string
+}
+
+// KVDecoder makes a decoder for the key=value messages. The pairs may be
+// wrapped in a RFC3164 header. pairSep separates the pairs (default " "),
+// kvSep separates the keys and the values (default "="), and the values may
+// be quoted with any of the quotes characters (default `"'`), with backslash
+// escapes. The pairs are stored in the "kv" properties domain.
+func KVDecoder(pairSep, kvSep, quotes string) func([]byte) ([]*model.SyslogMessage, error) {
+	p := kvParser{pairSep: pairSep, kvSep: kvSep, quotes: quotes}
+	if len(p.pairSep) == 0 {
+		p.pairSep = " "
+	}
+	if len(p.kvSep) == 0 {
+		p.kvSep = "="
+	}
+	if len(p.quotes) == 0 {
+		p.quotes = `"'`
+	}
+	return func(m []byte) ([]*model.SyslogMessage, error) {
+		msgs, err := p3164(m)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			msg.ClearDomain("kv")
+			for _, kv := range p.parse(msg.Message) {
+				msg.SetProperty("kv", kv[0], kv[1])
+			}
+		}
+		return msgs, nil
+	}
+}
+
+func (p kvParser) parse(s string) (pairs [][2]string) {
+	for {
+		s = strings.TrimLeft(s, " "+p.pairSep)
+		idx := strings.Index(s, p.kvSep)
+		if idx == -1 {
+			return pairs
+		}
+		key := s[:idx]
+		if sep := strings.LastIndex(key, p.pairSep); sep != -1 {
+			// skip the words that are not part of a pair
+			key = key[sep+len(p.pairSep):]
+		}
+		key = strings.TrimSpace(key)
+		s = s[idx+len(p.kvSep):]
+		if p.pairSep != " " {
+			s = strings.TrimLeft(s, " ")
+		}
+		var value string
+		if len(s) > 0 && strings.IndexByte(p.quotes, s[0]) != -1 {
+			value, s = unquoteKV(s)
+		} else if end := strings.Index(s, p.pairSep); end != -1 {
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		} else {
+			value, s = strings.TrimSpace(s), ""
+		}
+		if len(key) > 0 {
+			pairs = append(pairs, [2]string{key, value})
+		}
+	}
+}
+
+// unquoteKV reads the quoted value at the beginning of s, and returns the rest.
+func unquoteKV(s string) (value string, rest string) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && (s[i+1] == quote || s[i+1] == '\\'):
+			i++
+			b.WriteByte(s[i])
+		case s[i] == quote:
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	// unterminated quote
+	return b.String(), ""
+}
@@ -0,0 +1,227 @@
+package decoders
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// luaTimeout is the delay given to the Lua script to parse a message.
+const luaTimeout = time.Second
+
+// luaLibs are the Lua libraries available to the scripts. The io, os,
+// package and debug libraries are not loaded.
+var luaLibs = map[string]lua.LGFunction{
+	lua.BaseLibName:   lua.OpenBase,
+	lua.TabLibName:    lua.OpenTable,
+	lua.StringLibName: lua.OpenString,
+	lua.MathLibName:   lua.OpenMath,
+}
+
+// luaUnsafe are the functions of the base library that are removed from the
+// sandbox.
+var luaUnsafe = []string{
+	"dofile", "loadfile", "load", "loadstring", "require", "module", "print",
+	"collectgarbage", "getfenv", "setfenv", "newproxy", "_printregs",
+}
+
+// luaParser runs a Lua script for each message. The script gets the raw
+// message in the raw global, and returns a message, a list of messages, or
+// nil to drop the raw message. The messages are tables with the fields
+// facility, severity (names or numbers), hostname, appname, procid, msgid,
+// message, timereported (seconds since epoch) and properties (a table of
+// tables of strings).
+//
+// The script can use the functions new_message() to create a message,
+// set_property(msg, domain, key, value), and parse_time(s, layout) to parse a
+// timestamp with a Go layout (default RFC3339) into seconds since epoch.
+//
+// Each message is parsed with fresh globals, and the Lua states are pooled.
+type luaParser struct {
+	name   string
+	proto  *lua.FunctionProto
+	states sync.Pool
+}
+
+// LuaDecoder makes a decoder from a Lua script.
+func LuaDecoder(name, script string) (func([]byte) ([]*model.SyslogMessage, error), error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	fn, err := L.Load(strings.NewReader(script), name)
+	L.Close()
+	if err != nil {
+		return nil, LuaDecodingError(eerrors.Wrapf(err, "Invalid Lua script for parser '%s'", name))
+	}
+	p := &luaParser{name: name, proto: fn.Proto}
+	p.states.New = p.newState
+	return p.parse, nil
+}
+
+func (p *luaParser) newState() interface{} {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for libName, open := range luaLibs {
+		L.Push(L.NewFunction(open))
+		L.Push(lua.LString(libName))
+		L.Call(1, 0)
+	}
+	for _, name := range luaUnsafe {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetGlobal("new_message", L.NewFunction(luaNewMessage))
+	L.SetGlobal("set_property", L.NewFunction(luaSetProperty))
+	L.SetGlobal("parse_time", L.NewFunction(luaParseTime))
+	return L
+}
+
+func (p *luaParser) parse(m []byte) ([]*model.SyslogMessage, error) {
+	L := p.states.Get().(*lua.LState)
+	// the globals of the script are set in a fresh environment, that falls
+	// back to the shared globals
+	env := L.NewTable()
+	meta := L.NewTable()
+	meta.RawSetString("__index", L.G.Global)
+	L.SetMetatable(env, meta)
+	env.RawSetString("raw", lua.LString(m))
+	fn := L.NewFunctionFromProto(p.proto)
+	fn.Env = env
+
+	ctx, cancel := context.WithTimeout(context.Background(), luaTimeout)
+	L.SetContext(ctx)
+	err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true})
+	cancel()
+	L.RemoveContext()
+	if err != nil {
+		// the state may be in an inconsistent state: don't reuse it
+		L.Close()
+		return nil, LuaDecodingError(err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	msgs, err := luaToMessages(ret)
+	p.states.Put(L)
+	if err != nil {
+		return nil, LuaDecodingError(err)
+	}
+	return msgs, nil
+}
+
+func luaToMessages(ret lua.LValue) ([]*model.SyslogMessage, error) {
+	if ret == lua.LNil {
+		return nil, nil
+	}
+	tbl, ok := ret.(*lua.LTable)
+	if !ok {
+		return nil, eerrors.Errorf("The Lua script returned a %s, expected a table", ret.Type().String())
+	}
+	var tables []*lua.LTable
+	if tbl.MaxN() == 0 {
+		tables = append(tables, tbl)
+	} else {
+		for i := 1; i <= tbl.MaxN(); i++ {
+			t, ok := tbl.RawGetInt(i).(*lua.LTable)
+			if !ok {
+				return nil, eerrors.Errorf("The Lua script returned a list with a %s, expected tables", tbl.RawGetInt(i).Type().String())
+			}
+			tables = append(tables, t)
+		}
+	}
+	now := time.Now().UnixNano()
+	msgs := make([]*model.SyslogMessage, 0, len(tables))
+	for _, t := range tables {
+		msgs = append(msgs, luaToMessage(t, now))
+	}
+	return msgs, nil
+}
+
+func luaToMessage(t *lua.LTable, now int64) *model.SyslogMessage {
+	msg := model.Factory()
+	msg.Version = 1
+	msg.Facility = model.Fuser
+	msg.Severity = model.Sinfo
+	msg.TimeGeneratedNum = now
+	msg.TimeReportedNum = now
+	msg.HostName = luaString(t, "hostname")
+	msg.AppName = luaString(t, "appname")
+	msg.ProcId = luaString(t, "procid")
+	msg.MsgId = luaString(t, "msgid")
+	msg.Message = luaString(t, "message")
+	switch v := t.RawGetString("facility").(type) {
+	case lua.LNumber:
+		msg.Facility = model.Facility(int(v))
+	case lua.LString:
+		msg.Facility = model.FacilityFromString(string(v))
+	}
+	switch v := t.RawGetString("severity").(type) {
+	case lua.LNumber:
+		msg.Severity = model.Severity(int(v))
+	case lua.LString:
+		msg.Severity = model.SeverityFromString(string(v))
+	}
+	if v, ok := t.RawGetString("timereported").(lua.LNumber); ok {
+		msg.TimeReportedNum = int64(float64(v) * float64(time.Second))
+	}
+	if props, ok := t.RawGetString("properties").(*lua.LTable); ok {
+		props.ForEach(func(domain, kvs lua.LValue) {
+			if kvs, ok := kvs.(*lua.LTable); ok {
+				kvs.ForEach(func(k, v lua.LValue) {
+					msg.SetProperty(domain.String(), k.String(), v.String())
+				})
+			}
+		})
+	}
+	msg.SetPriority()
+	return msg
+}
+
+func luaString(t *lua.LTable, field string) string {
+	v := t.RawGetString(field)
+	if v == lua.LNil {
+		return ""
+	}
+	return v.String()
+}
+
+func luaNewMessage(L *lua.LState) int {
+	msg := L.NewTable()
+	msg.RawSetString("facility", lua.LString("user"))
+	msg.RawSetString("severity", lua.LString("info"))
+	msg.RawSetString("properties", L.NewTable())
+	L.Push(msg)
+	return 1
+}
+
+func luaSetProperty(L *lua.LState) int {
+	msg := L.CheckTable(1)
+	domain := L.CheckString(2)
+	key := L.CheckString(3)
+	value := L.CheckAny(4)
+	props, ok := msg.RawGetString("properties").(*lua.LTable)
+	if !ok {
+		props = L.NewTable()
+		msg.RawSetString("properties", props)
+	}
+	kvs, ok := props.RawGetString(domain).(*lua.LTable)
+	if !ok {
+		kvs = L.NewTable()
+		props.RawSetString(domain, kvs)
+	}
+	kvs.RawSetString(key, lua.LString(value.String()))
+	return 0
+}
+
+func luaParseTime(L *lua.LState) int {
+	s := L.CheckString(1)
+	layout := L.OptString(2, time.RFC3339Nano)
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LNumber(float64(t.UnixNano()) / float64(time.Second)))
+	return 1
+}
@@ -0,0 +1,203 @@
+package decoders
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// processFrameMaxSize bounds the size of the responses of the external
+// parsers.
+const processFrameMaxSize = 16 * 1024 * 1024
+
+// processTimeout is the delay given to the external parser to answer. After
+// that, the process is killed, and restarted for the next message.
+const processTimeout = 10 * time.Second
+
+// processDecoder sends the raw messages to an external command.
+//
+// Each raw message is written to the stdin of the command as a frame: the
+// length of the message as a 4 bytes big endian integer, then the message.
+// The command answers each frame with a frame on its stdout, that contains
+// JSON: either a message, or an array of messages, in the same format as the
+// json decoder, or an object like {"error": "reason"} when the message can't
+// be parsed. The command should exit when its stdin is closed. Its stderr is
+// logged.
+type processDecoder struct {
+	mu     sync.Mutex
+	name   string
+	argv   []string
+	logger log15.Logger
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func newProcessDecoder(name string, argv []string, logger log15.Logger) *processDecoder {
+	return &processDecoder{name: name, argv: argv, logger: logger}
+}
+
+func (d *processDecoder) start() error {
+	cmd := exec.Command(d.argv[0], d.argv[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			d.logger.Info("External parser output", "parser", d.name, "line", scanner.Text())
+		}
+	}()
+	d.cmd = cmd
+	d.stdin = stdin
+	d.stdout = bufio.NewReader(stdout)
+	d.logger.Debug("External parser started", "parser", d.name, "pid", cmd.Process.Pid)
+	return nil
+}
+
+func (d *processDecoder) stop() {
+	if d.cmd == nil {
+		return
+	}
+	_ = d.stdin.Close()
+	done := make(chan struct{})
+	go func(cmd *exec.Cmd) {
+		_ = cmd.Wait()
+		close(done)
+	}(d.cmd)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		_ = d.cmd.Process.Kill()
+	}
+	d.cmd = nil
+	d.stdin = nil
+	d.stdout = nil
+}
+
+// Close stops the external command.
+func (d *processDecoder) Close() {
+	d.mu.Lock()
+	d.stop()
+	d.mu.Unlock()
+}
+
+func (d *processDecoder) parse(m []byte) ([]*model.SyslogMessage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cmd == nil {
+		err := d.start()
+		if err != nil {
+			return nil, ProcessDecodingError(eerrors.Wrap(err, "Failed to start the external parser"))
+		}
+	}
+	resp, err := d.exchange(m)
+	if err != nil {
+		// the stream is out of sync: restart the process for the next message
+		d.logger.Warn("External parser failed, restarting", "parser", d.name, "error", err)
+		d.stop()
+		return nil, ProcessDecodingError(err)
+	}
+	return decodeParserResponse(resp)
+}
+
+func (d *processDecoder) exchange(m []byte) ([]byte, error) {
+	cmd := d.cmd
+	timer := time.AfterFunc(processTimeout, func() { _ = cmd.Process.Kill() })
+	defer timer.Stop()
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(m)))
+	_, err := d.stdin.Write(header[:])
+	if err == nil {
+		_, err = d.stdin.Write(m)
+	}
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Failed to write to the external parser")
+	}
+	_, err = io.ReadFull(d.stdout, header[:])
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Failed to read from the external parser")
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > processFrameMaxSize {
+		return nil, eerrors.Errorf("The external parser response is too large: %d bytes", size)
+	}
+	resp := make([]byte, size)
+	_, err = io.ReadFull(d.stdout, resp)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Failed to read from the external parser")
+	}
+	return resp, nil
+}
+
+// decodeParserResponse decodes the JSON responses of the external parsers:
+// a message, an array of messages, or {"error": "reason"}.
+func decodeParserResponse(resp []byte) ([]*model.SyslogMessage, error) {
+	resp = bytes.TrimSpace(resp)
+	if len(resp) == 0 {
+		return nil, DecodingError(eerrors.New("Empty response from the parser"))
+	}
+	var regulars []*model.RegularSyslog
+	if resp[0] == '[' {
+		err := ffjson.Unmarshal(resp, &regulars)
+		if err != nil {
+			return nil, UnmarshalJsonError(err)
+		}
+	} else {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		err := ffjson.Unmarshal(resp, &failure)
+		if err != nil {
+			return nil, UnmarshalJsonError(err)
+		}
+		if len(failure.Error) > 0 {
+			return nil, DecodingError(eerrors.New(failure.Error))
+		}
+		regular := new(model.RegularSyslog)
+		err = ffjson.Unmarshal(resp, regular)
+		if err != nil {
+			return nil, UnmarshalJsonError(err)
+		}
+		regulars = append(regulars, regular)
+	}
+	now := time.Now().UnixNano()
+	msgs := make([]*model.SyslogMessage, 0, len(regulars))
+	for _, regular := range regulars {
+		if regular == nil {
+			continue
+		}
+		msg := regular.Internal()
+		if regular.TimeGenerated.IsZero() {
+			msg.TimeGeneratedNum = now
+		}
+		if regular.TimeReported.IsZero() {
+			msg.TimeReportedNum = msg.TimeGeneratedNum
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
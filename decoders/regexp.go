@@ -0,0 +1,94 @@
+package decoders
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+var regexpTimeLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	time.Stamp,
+}
+
+// RegexpDecoder makes a decoder from the given regular expressions, that are
+// tried in order. The named groups of the first matching expression become
+// the message fields when they are named like one, or are stored in the
+// properties domain named after the parser.
+func RegexpDecoder(name string, exprs []string) (func([]byte) ([]*model.SyslogMessage, error), error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, eerrors.Wrapf(err, "Invalid regexp in parser '%s'", name)
+		}
+		res = append(res, re)
+	}
+	return func(m []byte) ([]*model.SyslogMessage, error) {
+		line := strings.TrimSpace(string(m))
+		for _, re := range res {
+			match := re.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			msg := model.Factory()
+			msg.TimeGeneratedNum = time.Now().UnixNano()
+			msg.TimeReportedNum = msg.TimeGeneratedNum
+			msg.Facility = 1
+			msg.Severity = 6
+			msg.Version = 1
+			msg.Message = line
+			msg.ClearDomain(name)
+			for i, group := range re.SubexpNames() {
+				if i == 0 || len(group) == 0 || len(match[i]) == 0 {
+					continue
+				}
+				setRegexpField(msg, name, group, match[i])
+			}
+			msg.SetPriority()
+			return []*model.SyslogMessage{msg}, nil
+		}
+		return nil, RegexpDecodingError(eerrors.Errorf("No regexp of parser '%s' matches the message", name))
+	}, nil
+}
+
+func setRegexpField(msg *model.SyslogMessage, domain, group, value string) {
+	switch strings.ToLower(group) {
+	case "hostname":
+		msg.HostName = value
+	case "appname":
+		msg.AppName = value
+	case "procid":
+		msg.ProcId = value
+	case "msgid":
+		msg.MsgId = value
+	case "message":
+		msg.Message = value
+	case "facility":
+		if f, ok := model.RFacilities[strings.ToLower(value)]; ok {
+			msg.Facility = f
+		}
+	case "severity":
+		if s, ok := model.RSeverities[strings.ToLower(value)]; ok {
+			msg.Severity = s
+		}
+	case "timereported":
+		for _, layout := range regexpTimeLayouts {
+			t, err := time.Parse(layout, value)
+			if err == nil {
+				if t.Year() == 0 {
+					t = t.AddDate(time.Now().Year(), 0, 0)
+				}
+				msg.TimeReportedNum = t.UnixNano()
+				break
+			}
+		}
+	default:
+		msg.SetProperty(domain, group, value)
+	}
+}
@@ -3,10 +3,12 @@ package decoders
 import (
 	"bytes"
 	"strconv"
+	"strings"
 	"time"
 	uni "unicode"
 
 	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
 )
 
 var space = []byte(" ")
@@ -26,6 +28,30 @@ func pair2str(s1 []byte, s2 []byte) (string, string) {
 }
 
 func p3164(m []byte) ([]*model.SyslogMessage, error) {
+	return p3164In(m, time.UTC)
+}
+
+// RFC3164Decoder makes a RFC3164 decoder that reads the timestamps without
+// timezone in the loc timezone.
+func RFC3164Decoder(loc *time.Location) func([]byte) ([]*model.SyslogMessage, error) {
+	return func(m []byte) ([]*model.SyslogMessage, error) {
+		return p3164In(m, loc)
+	}
+}
+
+// stampTime sets the year of a timestamp without year, and its timezone. The
+// timestamps that would be more than a month in the future are from the last
+// year, like a December message received in January.
+func stampTime(t time.Time, loc *time.Location) time.Time {
+	now := time.Now().In(loc)
+	t = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+	if t.After(now.AddDate(0, 1, 0)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+func p3164In(m []byte, loc *time.Location) ([]*model.SyslogMessage, error) {
 	m = bytes.TrimSpace(m)
 
 	defaultMsg := []*model.SyslogMessage{model.Factory()}
@@ -104,7 +130,7 @@ func p3164(m []byte) ([]*model.SyslogMessage, error) {
 			model.Free(defaultMsg[0])
 			return []*model.SyslogMessage{smsg}, nil
 		}
-		t = t.AddDate(time.Now().Year(), 0, 0)
+		t = stampTime(t, loc)
 		smsg.TimeReportedNum = t.UnixNano()
 		if len(s) == 3 {
 			model.Free(defaultMsg[0])
@@ -165,6 +191,35 @@ func p3164(m []byte) ([]*model.SyslogMessage, error) {
 	return []*model.SyslogMessage{smsg}, nil
 }
 
+// syslogHeader parses the RFC3164 header that may precede a structured
+// payload, like "<PRI>TIMESTAMP HOSTNAME". The rest of the header, if any, is
+// left in the message field.
+func syslogHeader(h []byte) (*model.SyslogMessage, error) {
+	h = bytes.TrimRight(bytes.TrimSpace(h), ": ")
+	if len(h) == 0 {
+		msg := model.Factory()
+		msg.TimeGeneratedNum = time.Now().UnixNano()
+		msg.TimeReportedNum = msg.TimeGeneratedNum
+		msg.Facility = 1
+		msg.Severity = 6
+		return msg, nil
+	}
+	msgs, err := p3164(h)
+	if err != nil || len(msgs) == 0 {
+		return nil, eerrors.Wrap(err, "Invalid syslog header")
+	}
+	msg := msgs[0]
+	if msg.TimeReportedNum == 0 {
+		msg.TimeReportedNum = msg.TimeGeneratedNum
+	}
+	if len(msg.HostName) == 0 && len(msg.AppName) == 0 && !strings.Contains(msg.Message, " ") {
+		// "<PRI>TIMESTAMP HOSTNAME"
+		msg.HostName = msg.Message
+		msg.Message = ""
+	}
+	return msg, nil
+}
+
 func parseTag(tag []byte) (appname []byte, procid []byte) {
 	tag = bytes.Trim(tag, ":")
 	i := bytes.Index(tag, []byte("["))
@@ -0,0 +1,28 @@
+package decoders
+
+import (
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/wasmplugin"
+)
+
+// WasmDecoder makes a decoder from the decode function of a WASM module. The
+// function receives the raw message, and returns the same JSON as the
+// external parsers: a message, an array of messages, or {"error": "reason"}.
+func WasmDecoder(path string) (func([]byte) ([]*model.SyslogMessage, error), error) {
+	// the modules are loaded only once, so we don't need to cache the decoder
+	m, err := wasmplugin.Open(path)
+	if err != nil {
+		return nil, WasmDecodingError(err)
+	}
+	if !m.Exports("decode") {
+		return nil, WasmDecodingError(eerrors.Errorf("WASM module '%s' does not export decode", path))
+	}
+	return func(raw []byte) ([]*model.SyslogMessage, error) {
+		resp, err := m.Call("decode", raw)
+		if err != nil {
+			return nil, WasmDecodingError(err)
+		}
+		return decodeParserResponse(resp)
+	}, nil
+}
@@ -0,0 +1,181 @@
+package decoders
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+type winEvent struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID     string `xml:"EventID"`
+		Level       string `xml:"Level"`
+		Task        string `xml:"Task"`
+		Keywords    string `xml:"Keywords"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		EventRecordID string `xml:"EventRecordID"`
+		Execution     struct {
+			ProcessID string `xml:"ProcessID,attr"`
+			ThreadID  string `xml:"ThreadID,attr"`
+		} `xml:"Execution"`
+		Channel  string `xml:"Channel"`
+		Computer string `xml:"Computer"`
+		Security struct {
+			UserID string `xml:"UserID,attr"`
+		} `xml:"Security"`
+	} `xml:"System"`
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	} `xml:"EventData"`
+	RenderingInfo struct {
+		Message string `xml:"Message"`
+	} `xml:"RenderingInfo"`
+}
+
+// winLevels maps the Windows event levels to the syslog severities.
+var winLevels = map[string]model.Severity{
+	"0": model.Sinfo,
+	"1": model.Scrit,
+	"2": model.Serr,
+	"3": model.SWarning,
+	"4": model.Sinfo,
+	"5": model.Sdebug,
+}
+
+// snareLevels maps the Snare event log types to the syslog severities.
+var snareLevels = map[string]model.Severity{
+	"error":         model.Serr,
+	"warning":       model.SWarning,
+	"information":   model.Sinfo,
+	"success audit": model.Snotice,
+	"failure audit": model.SWarning,
+	"critical":      model.Scrit,
+	"verbose":       model.Sdebug,
+}
+
+// pWinXML parses the Windows events forwarded as XML. The XML may be preceded
+// by a RFC3164 header. The System fields and the EventData are stored in the
+// "windows" properties domain.
+func pWinXML(m []byte) ([]*model.SyslogMessage, error) {
+	s := strings.TrimSpace(string(m))
+	idx := strings.Index(s, "<Event")
+	if idx == -1 {
+		return nil, WindowsDecodingError(eerrors.New("Event element not found"))
+	}
+	msg, err := syslogHeader([]byte(s[:idx]))
+	if err != nil {
+		return nil, WindowsDecodingError(err)
+	}
+	var event winEvent
+	err = xml.Unmarshal([]byte(s[idx:]), &event)
+	if err != nil {
+		return nil, WindowsDecodingError(err)
+	}
+	sys := event.System
+	msg.Version = 1
+	msg.AppName = sys.Provider.Name
+	msg.MsgId = sys.EventID
+	msg.ProcId = sys.Execution.ProcessID
+	if len(sys.Computer) > 0 {
+		msg.HostName = sys.Computer
+	}
+	if sev, ok := winLevels[sys.Level]; ok {
+		msg.Severity = sev
+	}
+	if t, err := time.Parse(time.RFC3339Nano, sys.TimeCreated.SystemTime); err == nil {
+		msg.TimeReportedNum = t.UnixNano()
+	}
+	msg.ClearDomain("windows")
+	for key, value := range map[string]string{
+		"event_id":  sys.EventID,
+		"channel":   sys.Channel,
+		"provider":  sys.Provider.Name,
+		"computer":  sys.Computer,
+		"level":     sys.Level,
+		"task":      sys.Task,
+		"keywords":  sys.Keywords,
+		"record_id": sys.EventRecordID,
+		"thread_id": sys.Execution.ThreadID,
+		"user_id":   sys.Security.UserID,
+	} {
+		if len(value) > 0 {
+			msg.SetProperty("windows", key, value)
+		}
+	}
+	for i, data := range event.EventData.Data {
+		name := data.Name
+		if len(name) == 0 {
+			name = strconv.Itoa(i)
+		}
+		msg.SetProperty("windows", "data."+name, strings.TrimSpace(data.Value))
+	}
+	msg.Message = strings.TrimSpace(event.RenderingInfo.Message)
+	if len(msg.Message) == 0 {
+		msg.Message = s[idx:]
+	}
+	msg.SetPriority()
+	return []*model.SyslogMessage{msg}, nil
+}
+
+var snareFields = []string{
+	"criticality", "channel", "counter", "datetime", "event_id", "provider",
+	"user", "sid_type", "event_log_type", "computer", "category", "data",
+	"message",
+}
+
+// pSnare parses the Snare for Windows format: a RFC3164 header, then
+// MSWinEventLog and the tab separated fields. The fields are stored in the
+// "windows" properties domain, with the same names as the XML events when
+// they exist.
+func pSnare(m []byte) ([]*model.SyslogMessage, error) {
+	s := strings.TrimSpace(string(m))
+	idx := strings.Index(s, "MSWinEventLog")
+	if idx == -1 {
+		return nil, WindowsDecodingError(eerrors.New("MSWinEventLog tag not found"))
+	}
+	msg, err := syslogHeader([]byte(s[:idx]))
+	if err != nil {
+		return nil, WindowsDecodingError(err)
+	}
+	// the separator may have been replaced by spaces or escaped by the
+	// syslog agent
+	body := strings.Replace(s[idx:], "#011", "\t", -1)
+	fields := strings.Split(body, "\t")[1:]
+	if len(fields) < len(snareFields) {
+		return nil, WindowsDecodingError(eerrors.Errorf("The Snare message has %d fields, expected %d", len(fields), len(snareFields)))
+	}
+	msg.ClearDomain("windows")
+	for i, name := range snareFields {
+		value := strings.TrimSpace(fields[i])
+		if len(value) > 0 && value != "N/A" {
+			msg.SetProperty("windows", name, value)
+		}
+	}
+	msg.Version = 1
+	msg.AppName = strings.TrimSpace(fields[5])
+	msg.MsgId = strings.TrimSpace(fields[4])
+	msg.Message = strings.TrimSpace(fields[12])
+	if computer := strings.TrimSpace(fields[9]); len(computer) > 0 {
+		msg.HostName = computer
+	}
+	if sev, ok := snareLevels[strings.ToLower(strings.TrimSpace(fields[8]))]; ok {
+		msg.Severity = sev
+	}
+	if t, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", strings.TrimSpace(fields[3]), time.Local); err == nil {
+		msg.TimeReportedNum = t.UnixNano()
+	}
+	msg.SetPriority()
+	return []*model.SyslogMessage{msg}, nil
+}
@@ -1,15 +1,38 @@
 package baseenc
 
-import "strings"
+import (
+	"strings"
+	"sync"
+)
+
+var formatsMu sync.RWMutex
 
 func ParseFormat(format string) Format {
 	format = strings.ToLower(strings.TrimSpace(format))
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
 	if f, ok := Formats[format]; ok {
 		return f
 	}
 	return -1
 }
 
+// RegisterFormat adds a format that is defined by the configuration, like a
+// user template, and returns its identifier. Registering the same name again
+// returns the same identifier.
+func RegisterFormat(name string) Format {
+	name = strings.ToLower(strings.TrimSpace(name))
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if f, ok := Formats[name]; ok {
+		return f
+	}
+	f := nextFormat
+	nextFormat++
+	Formats[name] = f
+	return f
+}
+
 type Format int
 
 const (
@@ -24,8 +47,19 @@ const (
 	File
 	GELF
 	Protobuf
+	Parquet
+	CEF
+	LEEF
+	Msgpack
+	ECS
+	FlatJSON
+	CSV
+	userFormats
 )
 
+// nextFormat is the identifier of the next registered format.
+var nextFormat = userFormats
+
 var Formats = map[string]Format{
 	"rfc5424":      RFC5424,
 	"rfc3164":      RFC3164,
@@ -38,5 +72,12 @@ var Formats = map[string]Format{
 	"file":         File,
 	"gelf":         GELF,
 	"protobuf":     Protobuf,
+	"parquet":      Parquet,
+	"cef":          CEF,
+	"leef":         LEEF,
+	"msgpack":      Msgpack,
+	"ecs":          ECS,
+	"flatjson":     FlatJSON,
+	"csv":          CSV,
 	"":             JSON,
 }
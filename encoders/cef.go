@@ -0,0 +1,177 @@
+package encoders
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+type cefExtension struct {
+	key    string
+	domain string
+	prop   string
+}
+
+type cefParams struct {
+	vendor     string
+	product    string
+	version    string
+	extensions []cefExtension
+	all        bool
+}
+
+var cefMu sync.RWMutex
+var cef = cefParams{vendor: "skewer", product: "skewer", version: "1"}
+
+var cefKeyRe = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+var invalidCEFKeyChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// ConfigureCEF sets the device fields and the extension mapping of the cef
+// format.
+func ConfigureCEF(c conf.CEFConfig) error {
+	params := cefParams{
+		vendor:  c.DeviceVendor,
+		product: c.DeviceProduct,
+		version: c.DeviceVersion,
+		all:     c.AllProperties,
+	}
+	for _, spec := range c.Extensions {
+		eq := strings.Index(spec, "=")
+		colon := strings.LastIndex(spec, ":")
+		if eq == -1 || colon < eq {
+			return eerrors.Errorf("Invalid CEF extension, expected key=domain:key: '%s'", spec)
+		}
+		ext := cefExtension{
+			key:    strings.TrimSpace(spec[:eq]),
+			domain: strings.TrimSpace(spec[eq+1 : colon]),
+			prop:   strings.TrimSpace(spec[colon+1:]),
+		}
+		if !cefKeyRe.MatchString(ext.key) || len(ext.domain) == 0 || len(ext.prop) == 0 {
+			return eerrors.Errorf("Invalid CEF extension, expected key=domain:key: '%s'", spec)
+		}
+		params.extensions = append(params.extensions, ext)
+	}
+	cefMu.Lock()
+	cef = params
+	cefMu.Unlock()
+	return nil
+}
+
+// cefSeverities maps the syslog severities to the CEF 0-10 scale.
+var cefSeverities = map[model.Severity]string{
+	model.Semerg:   "10",
+	model.Salert:   "9",
+	model.Scrit:    "8",
+	model.Serr:     "7",
+	model.SWarning: "5",
+	model.Snotice:  "3",
+	model.Sinfo:    "1",
+	model.Sdebug:   "0",
+}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+func encodeCEF(v interface{}, w io.Writer) error {
+	if v == nil {
+		return nil
+	}
+	switch val := v.(type) {
+	case *model.FullMessage:
+		return encodeMsgCEF(val.Fields, w)
+	case *model.SyslogMessage:
+		return encodeMsgCEF(val, w)
+	default:
+		return defaultEncode(v, w)
+	}
+}
+
+func encodeMsgCEF(m *model.SyslogMessage, w io.Writer) (err error) {
+	cefMu.RLock()
+	params := cef
+	cefMu.RUnlock()
+
+	classID := m.MsgId
+	if len(classID) == 0 {
+		classID = m.AppName
+	}
+	if len(classID) == 0 {
+		classID = "syslog"
+	}
+	name := m.Message
+	if idx := strings.IndexByte(name, '\n'); idx != -1 {
+		name = name[:idx]
+	}
+	if len(name) > 512 {
+		name = name[:512]
+	}
+
+	var b strings.Builder
+	b.WriteString("CEF:0|")
+	for _, field := range []string{params.vendor, params.product, params.version, classID, name} {
+		b.WriteString(cefHeaderEscaper.Replace(field))
+		b.WriteByte('|')
+	}
+	b.WriteString(cefSeverities[m.Severity])
+	b.WriteByte('|')
+
+	first := true
+	ext := func(key, value string) {
+		if len(value) == 0 {
+			return
+		}
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(cefValueEscaper.Replace(value))
+	}
+	if m.TimeReportedNum > 0 {
+		ext("rt", strconv.FormatInt(m.TimeReportedNum/1000000, 10))
+	}
+	ext("dvchost", m.HostName)
+	ext("deviceFacility", m.Facility.String())
+	ext("deviceProcessName", m.AppName)
+	if _, err := strconv.ParseUint(m.ProcId, 10, 32); err == nil {
+		ext("dvcpid", m.ProcId)
+	}
+	ext("msg", m.Message)
+
+	mapped := make(map[string]bool, len(params.extensions))
+	for _, e := range params.extensions {
+		ext(e.key, m.GetProperty(e.domain, e.prop))
+		mapped[e.domain+":"+e.prop] = true
+	}
+	if params.all {
+		props := m.GetAllProperties()
+		domains := make([]string, 0, len(props))
+		for domain := range props {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+		for _, domain := range domains {
+			keys := make([]string, 0, len(props[domain]))
+			for key := range props[domain] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if mapped[domain+":"+key] {
+					continue
+				}
+				ext(invalidCEFKeyChars.ReplaceAllString(domain+"_"+key, "_"), props[domain][key])
+			}
+		}
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
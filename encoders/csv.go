@@ -0,0 +1,147 @@
+package encoders
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+var CSVMimetype = "text/csv"
+
+type csvColumn struct {
+	field  func(m *model.FullMessage, layout string) string
+	domain string
+	prop   string
+}
+
+type csvParams struct {
+	columns   []csvColumn
+	separator rune
+	layout    string
+}
+
+var csvMu sync.RWMutex
+var csvConf csvParams
+
+var csvFields = map[string]func(*model.FullMessage, string) string{
+	"timereported": func(m *model.FullMessage, layout string) string {
+		return fmt.Sprint(flatTimestamp(m.Fields.TimeReportedNum, layout))
+	},
+	"timegenerated": func(m *model.FullMessage, layout string) string {
+		return fmt.Sprint(flatTimestamp(m.Fields.TimeGeneratedNum, layout))
+	},
+	"hostname":    func(m *model.FullMessage, _ string) string { return m.Fields.HostName },
+	"appname":     func(m *model.FullMessage, _ string) string { return m.Fields.AppName },
+	"procid":      func(m *model.FullMessage, _ string) string { return m.Fields.ProcId },
+	"msgid":       func(m *model.FullMessage, _ string) string { return m.Fields.MsgId },
+	"facility":    func(m *model.FullMessage, _ string) string { return m.Fields.Facility.String() },
+	"severity":    func(m *model.FullMessage, _ string) string { return m.Fields.Severity.String() },
+	"message":     func(m *model.FullMessage, _ string) string { return m.Fields.Message },
+	"client":      func(m *model.FullMessage, _ string) string { return m.ClientAddr },
+	"source_type": func(m *model.FullMessage, _ string) string { return m.SourceType },
+	"source_path": func(m *model.FullMessage, _ string) string { return m.SourcePath },
+	"uid": func(m *model.FullMessage, _ string) string {
+		if len(m.Uid) == 0 || m.Uid == utils.ZeroULID {
+			return ""
+		}
+		return m.Uid.String()
+	},
+}
+
+func init() {
+	_ = ConfigureCSV(conf.CSVConfig{})
+}
+
+// ConfigureCSV sets the ordered columns, the separator and the timestamp
+// layout of the csv format.
+func ConfigureCSV(c conf.CSVConfig) error {
+	params := csvParams{separator: ',', layout: c.TimestampLayout}
+	if len(params.layout) == 0 {
+		params.layout = time.RFC3339Nano
+	}
+	if len(c.Separator) > 0 {
+		if c.Separator == "\\t" || strings.ToLower(c.Separator) == "tab" {
+			c.Separator = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(c.Separator)
+		if size != len(c.Separator) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return eerrors.Errorf("Invalid CSV separator: '%s'", c.Separator)
+		}
+		params.separator = r
+	}
+	columns := c.Columns
+	if len(columns) == 0 {
+		columns = []string{"timereported", "hostname", "appname", "procid", "msgid", "facility", "severity", "message"}
+	}
+	for _, name := range columns {
+		name = strings.TrimSpace(name)
+		var col csvColumn
+		if colon := strings.LastIndex(name, ":"); colon != -1 {
+			col.domain, col.prop = name[:colon], name[colon+1:]
+		} else {
+			col.field = csvFields[strings.ToLower(name)]
+		}
+		if col.field == nil && (len(col.domain) == 0 || len(col.prop) == 0) {
+			return eerrors.Errorf("Invalid CSV column, expected a field name or domain:key: '%s'", name)
+		}
+		params.columns = append(params.columns, col)
+	}
+	csvMu.Lock()
+	csvConf = params
+	csvMu.Unlock()
+	return nil
+}
+
+func encodeCSV(v interface{}, w io.Writer) error {
+	if v == nil {
+		return nil
+	}
+	switch val := v.(type) {
+	case *model.FullMessage:
+		return encodeMsgCSV(val, w)
+	case *model.SyslogMessage:
+		return encodeMsgCSV(&model.FullMessage{Fields: val}, w)
+	default:
+		return defaultEncode(v, w)
+	}
+}
+
+// encodeMsgCSV writes one record, without the line terminator: the
+// destinations add their own framing.
+func encodeMsgCSV(m *model.FullMessage, w io.Writer) error {
+	csvMu.RLock()
+	params := csvConf
+	csvMu.RUnlock()
+
+	record := make([]string, 0, len(params.columns))
+	for _, col := range params.columns {
+		if col.field != nil {
+			record = append(record, col.field(m, params.layout))
+		} else {
+			record = append(record, m.Fields.GetProperty(col.domain, col.prop))
+		}
+	}
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Comma = params.separator
+	err := cw.Write(record)
+	if err == nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+	if err != nil {
+		return EncodingError(err)
+	}
+	_, err = w.Write(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}))
+	return err
+}
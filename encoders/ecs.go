@@ -0,0 +1,159 @@
+package encoders
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+)
+
+// ECSVersion is the version of the Elastic Common Schema that the ecs format
+// follows.
+const ECSVersion = "8.11.0"
+
+type ecsDocument struct {
+	Timestamp string      `json:"@timestamp"`
+	Message   string      `json:"message,omitempty"`
+	ECS       ecsVersion  `json:"ecs"`
+	Event     ecsEvent    `json:"event"`
+	Host      *ecsHost    `json:"host,omitempty"`
+	Process   *ecsProcess `json:"process,omitempty"`
+	Log       ecsLog      `json:"log"`
+	Source    *ecsSource  `json:"source,omitempty"`
+}
+
+type ecsVersion struct {
+	Version string `json:"version"`
+}
+
+type ecsEvent struct {
+	ID       string `json:"id,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Created  string `json:"created,omitempty"`
+	Severity int32  `json:"severity"`
+	Module   string `json:"module,omitempty"`
+}
+
+type ecsHost struct {
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+}
+
+type ecsProcess struct {
+	Name string `json:"name,omitempty"`
+	PID  *int64 `json:"pid,omitempty"`
+}
+
+type ecsLog struct {
+	Level  string      `json:"level"`
+	Syslog ecsSyslog   `json:"syslog"`
+	File   *ecsLogFile `json:"file,omitempty"`
+}
+
+type ecsSyslog struct {
+	Priority       int32                        `json:"priority"`
+	Severity       ecsCode                      `json:"severity"`
+	Facility       ecsCode                      `json:"facility"`
+	AppName        string                       `json:"appname,omitempty"`
+	Hostname       string                       `json:"hostname,omitempty"`
+	ProcID         string                       `json:"procid,omitempty"`
+	MsgID          string                       `json:"msgid,omitempty"`
+	Version        string                       `json:"version,omitempty"`
+	StructuredData map[string]map[string]string `json:"structured_data,omitempty"`
+}
+
+type ecsCode struct {
+	Code int32  `json:"code"`
+	Name string `json:"name"`
+}
+
+type ecsLogFile struct {
+	Path string `json:"path"`
+}
+
+type ecsSource struct {
+	Address string `json:"address"`
+}
+
+func encodeECS(v interface{}, w io.Writer) error {
+	if v == nil {
+		return nil
+	}
+	switch val := v.(type) {
+	case *model.FullMessage:
+		doc := syslogToECS(val.Fields)
+		if len(val.Uid) > 0 && val.Uid != utils.ZeroULID {
+			doc.Event.ID = val.Uid.String()
+		}
+		doc.Event.Module = val.SourceType
+		if len(val.ClientAddr) > 0 {
+			doc.Source = &ecsSource{Address: val.ClientAddr}
+		}
+		if len(val.SourcePath) > 0 {
+			doc.Log.File = &ecsLogFile{Path: val.SourcePath}
+		}
+		return writeECS(doc, w)
+	case *model.SyslogMessage:
+		return writeECS(syslogToECS(val), w)
+	default:
+		return defaultEncode(v, w)
+	}
+}
+
+// syslogToECS maps the syslog fields to the ECS field names. The properties
+// are kept in log.syslog.structured_data.
+func syslogToECS(m *model.SyslogMessage) *ecsDocument {
+	severity := ecsCode{Code: int32(m.Severity), Name: m.Severity.String()}
+	facility := ecsCode{Code: int32(m.Facility), Name: m.Facility.String()}
+	doc := &ecsDocument{
+		Timestamp: time.Unix(0, m.TimeReportedNum).UTC().Format(time.RFC3339Nano),
+		Message:   m.Message,
+		ECS:       ecsVersion{Version: ECSVersion},
+		Event: ecsEvent{
+			Code:     m.MsgId,
+			Severity: severity.Code,
+		},
+		Log: ecsLog{
+			Level: severity.Name,
+			Syslog: ecsSyslog{
+				Priority:       int32(m.Facility)*8 + int32(m.Severity),
+				Severity:       severity,
+				Facility:       facility,
+				AppName:        m.AppName,
+				Hostname:       m.HostName,
+				ProcID:         m.ProcId,
+				MsgID:          m.MsgId,
+				StructuredData: m.GetAllProperties(),
+			},
+		},
+	}
+	if m.TimeGeneratedNum > 0 {
+		doc.Event.Created = time.Unix(0, m.TimeGeneratedNum).UTC().Format(time.RFC3339Nano)
+	}
+	if m.Version > 0 {
+		doc.Log.Syslog.Version = strconv.FormatInt(int64(m.Version), 10)
+	}
+	if len(m.HostName) > 0 {
+		doc.Host = &ecsHost{Name: m.HostName, Hostname: m.HostName}
+	}
+	if len(m.AppName) > 0 || len(m.ProcId) > 0 {
+		doc.Process = &ecsProcess{Name: m.AppName}
+		// process.pid is numeric in ECS
+		if pid, err := strconv.ParseInt(m.ProcId, 10, 64); err == nil {
+			doc.Process.PID = &pid
+		}
+	}
+	return doc
+}
+
+func writeECS(doc *ecsDocument, w io.Writer) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return EncodingError(err)
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"mime"
 	"strconv"
+	"sync"
 
 	"github.com/stephane-martin/skewer/encoders/baseenc"
 	"github.com/stephane-martin/skewer/utils/eerrors"
@@ -26,6 +27,7 @@ var AcceptedMimeTypes = []string{
 	AvroMimetype,
 	NDJsonMimetype,
 	ProtobufMimetype,
+	MsgpackMimetype,
 	OctetStreamMimetype,
 	"text/plain",
 }
@@ -35,6 +37,7 @@ var RMimeTypes = map[string]Encoder{
 	NDJsonMimetype:      encodeJSON,
 	AvroMimetype:        encodeFullAVRO,
 	ProtobufMimetype:    encodePB,
+	MsgpackMimetype:     encodeMsgpack,
 	OctetStreamMimetype: encodePB,
 	PlainMimetype:       encode5424,
 	"text/plain":        encode5424,
@@ -52,8 +55,16 @@ var MimeTypes = map[baseenc.Format]string{
 	baseenc.File:         PlainMimetype,
 	baseenc.GELF:         JsonMimetype,
 	baseenc.Protobuf:     ProtobufMimetype,
+	baseenc.CEF:          PlainMimetype,
+	baseenc.LEEF:         PlainMimetype,
+	baseenc.Msgpack:      MsgpackMimetype,
+	baseenc.ECS:          JsonMimetype,
+	baseenc.FlatJSON:     JsonMimetype,
+	baseenc.CSV:          CSVMimetype,
 }
 
+var encodersMu sync.RWMutex
+
 var encoders = map[baseenc.Format]Encoder{
 	baseenc.RFC5424:      encode5424,
 	baseenc.RFC3164:      encode3164,
@@ -66,6 +77,12 @@ var encoders = map[baseenc.Format]Encoder{
 	baseenc.File:         encodeFile,
 	baseenc.GELF:         encodeGELF,
 	baseenc.Protobuf:     encodePB,
+	baseenc.CEF:          encodeCEF,
+	baseenc.LEEF:         encodeLEEF,
+	baseenc.Msgpack:      encodeMsgpack,
+	baseenc.ECS:          encodeECS,
+	baseenc.FlatJSON:     encodeFlatJSON,
+	baseenc.CSV:          encodeCSV,
 }
 
 // Encoder is the function type that represents encoders
@@ -76,6 +93,8 @@ func EncodingError(err error) error {
 }
 
 func GetEncoder(frmt baseenc.Format) (Encoder, error) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
 	if e, ok := encoders[frmt]; ok {
 		return e, nil
 	}
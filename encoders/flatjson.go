@@ -0,0 +1,91 @@
+package encoders
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+)
+
+var flatJSONMu sync.RWMutex
+var flatJSONLayout = time.RFC3339Nano
+
+// ConfigureFlatJSON sets the timestamp layout of the flatjson format.
+func ConfigureFlatJSON(c conf.FlatJSONConfig) {
+	layout := c.TimestampLayout
+	if len(layout) == 0 {
+		layout = time.RFC3339Nano
+	}
+	flatJSONMu.Lock()
+	flatJSONLayout = layout
+	flatJSONMu.Unlock()
+}
+
+// flatTimestamp renders a timestamp with the configured layout. The "unix",
+// "unixms" and "unixnano" layouts produce numbers.
+func flatTimestamp(ns int64, layout string) interface{} {
+	switch layout {
+	case "unix":
+		return ns / int64(time.Second)
+	case "unixms":
+		return ns / int64(time.Millisecond)
+	case "unixnano":
+		return ns
+	default:
+		return time.Unix(0, ns).UTC().Format(layout)
+	}
+}
+
+func encodeFlatJSON(v interface{}, w io.Writer) error {
+	if v == nil {
+		return nil
+	}
+	switch val := v.(type) {
+	case *model.FullMessage:
+		return encodeMsgFlatJSON(val.Fields, w)
+	case *model.SyslogMessage:
+		return encodeMsgFlatJSON(val, w)
+	default:
+		return defaultEncode(v, w)
+	}
+}
+
+// encodeMsgFlatJSON encodes the message as a JSON object without nesting: the
+// properties become "domain.key" top-level keys.
+func encodeMsgFlatJSON(m *model.SyslogMessage, w io.Writer) error {
+	flatJSONMu.RLock()
+	layout := flatJSONLayout
+	flatJSONMu.RUnlock()
+
+	flat := map[string]interface{}{
+		"facility":      m.Facility.String(),
+		"severity":      m.Severity.String(),
+		"timereported":  flatTimestamp(m.TimeReportedNum, layout),
+		"timegenerated": flatTimestamp(m.TimeGeneratedNum, layout),
+	}
+	for key, value := range map[string]string{
+		"hostname": m.HostName,
+		"appname":  m.AppName,
+		"procid":   m.ProcId,
+		"msgid":    m.MsgId,
+		"message":  m.Message,
+	} {
+		if len(value) > 0 {
+			flat[key] = value
+		}
+	}
+	for domain, props := range m.GetAllProperties() {
+		for key, value := range props {
+			flat[domain+"."+key] = value
+		}
+	}
+	b, err := json.Marshal(flat)
+	if err != nil {
+		return EncodingError(err)
+	}
+	_, err = w.Write(b)
+	return err
+}
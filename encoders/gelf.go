@@ -3,13 +3,24 @@ package encoders
 import (
 	"bytes"
 	"io"
+	"regexp"
+	"strings"
 
 	"github.com/Graylog2/go-gelf/gelf"
 	"github.com/stephane-martin/skewer/model"
 )
 
+// FullToGelfMessage converts a message to GELF 1.1. The fields that GELF does
+// not define, and the properties, are added as additional fields.
 func FullToGelfMessage(m *model.FullMessage) *gelf.Message {
-	return SyslogToGelfMessage(m.Fields)
+	gelfm := SyslogToGelfMessage(m.Fields)
+	if len(m.ClientAddr) > 0 {
+		gelfm.Extra["_client"] = m.ClientAddr
+	}
+	if len(m.SourceType) > 0 {
+		gelfm.Extra["_source_type"] = m.SourceType
+	}
+	return gelfm
 }
 
 func SyslogToGelfMessage(m *model.SyslogMessage) *gelf.Message {
@@ -23,27 +34,51 @@ func SyslogToGelfMessage(m *model.SyslogMessage) *gelf.Message {
 		Facility: m.Facility.String(),
 		RawExtra: nil,
 	}
+	if len(gelfm.Host) == 0 {
+		// host is mandatory
+		gelfm.Host = "-"
+	}
+	// short_message is mandatory, and should not span multiple lines
+	if idx := strings.IndexByte(m.Message, '\n'); idx != -1 {
+		gelfm.Short = strings.TrimSpace(m.Message[:idx])
+		gelfm.Full = m.Message
+	}
+	if len(gelfm.Short) == 0 {
+		gelfm.Short = "-"
+	}
+
 	gelfm.Extra = map[string]interface{}{}
 	for domain, props := range m.Properties.GetMap() {
-		gelfm.Extra[domain] = map[string]string{}
 		for k, v := range props.GetMap() {
-			(gelfm.Extra[domain]).(map[string]string)[k] = v
+			gelfm.Extra[gelfFieldName(domain, k)] = v
 		}
 	}
-	gelfm.Extra["facility"] = gelfm.Facility
+	gelfm.Extra["_facility"] = gelfm.Facility
 	if len(m.AppName) > 0 {
-		gelfm.Extra["appname"] = m.AppName
+		gelfm.Extra["_appname"] = m.AppName
 	}
 	if len(m.ProcId) > 0 {
-		gelfm.Extra["procid"] = m.ProcId
+		gelfm.Extra["_procid"] = m.ProcId
 	}
 	if len(m.MsgId) > 0 {
-		gelfm.Extra["msgid"] = m.MsgId
+		gelfm.Extra["_msgid"] = m.MsgId
 	}
 
 	return &gelfm
 }
 
+var invalidGelfChars = regexp.MustCompile(`[^\w\.\-]`)
+
+// gelfFieldName builds the additional field name for a property. The GELF
+// field names are restricted, and "_id" is reserved.
+func gelfFieldName(domain, key string) string {
+	name := invalidGelfChars.ReplaceAllString(domain+"_"+key, "_")
+	if name == "id" {
+		name = "id_"
+	}
+	return "_" + name
+}
+
 func encodeGELF(v interface{}, w io.Writer) (err error) {
 	if v == nil {
 		return nil
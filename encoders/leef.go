@@ -0,0 +1,207 @@
+package encoders
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+type leefAttribute struct {
+	key    string
+	field  func(*model.SyslogMessage) string
+	domain string
+	prop   string
+}
+
+type leefParams struct {
+	vendor     string
+	product    string
+	version    string
+	delimiter  string
+	delimField string
+	attributes []leefAttribute
+	all        bool
+}
+
+var leefMu sync.RWMutex
+var leef = leefParams{vendor: "skewer", product: "skewer", version: "1", delimiter: "^", delimField: "^"}
+
+var leefFields = map[string]func(*model.SyslogMessage) string{
+	"hostname": func(m *model.SyslogMessage) string { return m.HostName },
+	"appname":  func(m *model.SyslogMessage) string { return m.AppName },
+	"procid":   func(m *model.SyslogMessage) string { return m.ProcId },
+	"msgid":    func(m *model.SyslogMessage) string { return m.MsgId },
+	"facility": func(m *model.SyslogMessage) string { return m.Facility.String() },
+	"severity": func(m *model.SyslogMessage) string { return m.Severity.String() },
+	"message":  func(m *model.SyslogMessage) string { return m.Message },
+}
+
+// parseLEEFDelimiter returns the delimiter, and how it is written in the
+// header: a single character, or its hexadecimal code like "x09".
+func parseLEEFDelimiter(d string) (delim string, field string, err error) {
+	switch {
+	case len(d) == 0:
+		return "^", "^", nil
+	case d == "\t" || strings.ToLower(d) == "tab":
+		return "\t", "x09", nil
+	case len(d) == 1:
+		return d, d, nil
+	}
+	hex := strings.TrimPrefix(strings.ToLower(d), "0")
+	if strings.HasPrefix(hex, "x") {
+		code, err := strconv.ParseUint(hex[1:], 16, 16)
+		if err == nil && code > 0 {
+			return string(rune(code)), hex, nil
+		}
+	}
+	return "", "", eerrors.Errorf("Invalid LEEF delimiter: '%s'", d)
+}
+
+// ConfigureLEEF sets the header fields, the delimiter and the attribute
+// mapping of the leef format.
+func ConfigureLEEF(c conf.LEEFConfig) (err error) {
+	params := leefParams{
+		vendor:  c.Vendor,
+		product: c.Product,
+		version: c.Version,
+		all:     c.AllProperties,
+	}
+	params.delimiter, params.delimField, err = parseLEEFDelimiter(c.Delimiter)
+	if err != nil {
+		return err
+	}
+	for _, spec := range c.Attributes {
+		eq := strings.Index(spec, "=")
+		if eq == -1 {
+			return eerrors.Errorf("Invalid LEEF attribute, expected key=source: '%s'", spec)
+		}
+		attr := leefAttribute{key: strings.TrimSpace(spec[:eq])}
+		source := strings.TrimSpace(spec[eq+1:])
+		if colon := strings.LastIndex(source, ":"); colon != -1 {
+			attr.domain, attr.prop = source[:colon], source[colon+1:]
+		} else {
+			attr.field = leefFields[strings.ToLower(source)]
+		}
+		if !cefKeyRe.MatchString(attr.key) || (attr.field == nil && (len(attr.domain) == 0 || len(attr.prop) == 0)) {
+			return eerrors.Errorf("Invalid LEEF attribute, expected key=source: '%s'", spec)
+		}
+		params.attributes = append(params.attributes, attr)
+	}
+	leefMu.Lock()
+	leef = params
+	leefMu.Unlock()
+	return nil
+}
+
+// leefSeverities maps the syslog severities to the LEEF 1-10 scale.
+var leefSeverities = map[model.Severity]string{
+	model.Semerg:   "10",
+	model.Salert:   "9",
+	model.Scrit:    "8",
+	model.Serr:     "7",
+	model.SWarning: "5",
+	model.Snotice:  "3",
+	model.Sinfo:    "2",
+	model.Sdebug:   "1",
+}
+
+var leefHeaderCleaner = strings.NewReplacer("|", " ", "\n", " ", "\r", " ")
+
+func encodeLEEF(v interface{}, w io.Writer) error {
+	if v == nil {
+		return nil
+	}
+	switch val := v.(type) {
+	case *model.FullMessage:
+		return encodeMsgLEEF(val.Fields, w)
+	case *model.SyslogMessage:
+		return encodeMsgLEEF(val, w)
+	default:
+		return defaultEncode(v, w)
+	}
+}
+
+func encodeMsgLEEF(m *model.SyslogMessage, w io.Writer) (err error) {
+	leefMu.RLock()
+	params := leef
+	leefMu.RUnlock()
+
+	eventID := m.MsgId
+	if len(eventID) == 0 {
+		eventID = m.AppName
+	}
+	if len(eventID) == 0 {
+		eventID = "syslog"
+	}
+	// the attribute values can not contain the delimiter
+	valueCleaner := strings.NewReplacer(params.delimiter, " ", "\n", " ", "\r", " ")
+
+	var b strings.Builder
+	b.WriteString("LEEF:2.0|")
+	for _, field := range []string{params.vendor, params.product, params.version, eventID} {
+		b.WriteString(leefHeaderCleaner.Replace(field))
+		b.WriteByte('|')
+	}
+	b.WriteString(params.delimField)
+	b.WriteByte('|')
+
+	first := true
+	attr := func(key, value string) {
+		if len(value) == 0 {
+			return
+		}
+		if !first {
+			b.WriteString(params.delimiter)
+		}
+		first = false
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(valueCleaner.Replace(value))
+	}
+	if m.TimeReportedNum > 0 {
+		attr("devTime", time.Unix(0, m.TimeReportedNum).UTC().Format("Jan 02 2006 15:04:05.000 MST"))
+		attr("devTimeFormat", "MMM dd yyyy HH:mm:ss.SSS z")
+	}
+	attr("sev", leefSeverities[m.Severity])
+	attr("cat", m.Facility.String())
+
+	mapped := make(map[string]bool, len(params.attributes))
+	for _, a := range params.attributes {
+		if a.field != nil {
+			attr(a.key, a.field(m))
+		} else {
+			attr(a.key, m.GetProperty(a.domain, a.prop))
+			mapped[a.domain+":"+a.prop] = true
+		}
+	}
+	if params.all {
+		props := m.GetAllProperties()
+		domains := make([]string, 0, len(props))
+		for domain := range props {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+		for _, domain := range domains {
+			keys := make([]string, 0, len(props[domain]))
+			for key := range props[domain] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if mapped[domain+":"+key] {
+					continue
+				}
+				attr(invalidCEFKeyChars.ReplaceAllString(domain+"_"+key, "_"), props[domain][key])
+			}
+		}
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,157 @@
+package encoders
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/valyala/bytebufferpool"
+)
+
+var MsgpackMimetype = "application/x-msgpack"
+
+// msgpackWriter encodes the few MessagePack types that the messages need.
+type msgpackWriter struct {
+	buf *bytebufferpool.ByteBuffer
+}
+
+func (w msgpackWriter) mapHeader(n int) {
+	switch {
+	case n < 16:
+		w.buf.B = append(w.buf.B, 0x80|byte(n))
+	case n < 1<<16:
+		w.buf.B = append(w.buf.B, 0xde, byte(n>>8), byte(n))
+	default:
+		w.buf.B = append(w.buf.B, 0xdf)
+		w.buf.B = appendUint32(w.buf.B, uint32(n))
+	}
+}
+
+func (w msgpackWriter) str(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		w.buf.B = append(w.buf.B, 0xa0|byte(n))
+	case n < 1<<8:
+		w.buf.B = append(w.buf.B, 0xd9, byte(n))
+	case n < 1<<16:
+		w.buf.B = append(w.buf.B, 0xda, byte(n>>8), byte(n))
+	default:
+		w.buf.B = append(w.buf.B, 0xdb)
+		w.buf.B = appendUint32(w.buf.B, uint32(n))
+	}
+	w.buf.B = append(w.buf.B, s...)
+}
+
+// timestamp writes t with the MessagePack timestamp extension type.
+func (w msgpackWriter) timestamp(t time.Time) {
+	sec := t.Unix()
+	nsec := int64(t.Nanosecond())
+	if sec >= 0 && sec < 1<<34 {
+		// timestamp 64
+		w.buf.B = append(w.buf.B, 0xd7, 0xff)
+		w.buf.B = appendUint64(w.buf.B, uint64(nsec)<<34|uint64(sec))
+		return
+	}
+	// timestamp 96
+	w.buf.B = append(w.buf.B, 0xc7, 12, 0xff)
+	w.buf.B = appendUint32(w.buf.B, uint32(nsec))
+	w.buf.B = appendUint64(w.buf.B, uint64(sec))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func encodeMsgpack(v interface{}, w io.Writer) error {
+	if v == nil {
+		return nil
+	}
+	switch val := v.(type) {
+	case *model.FullMessage:
+		return encodeMsgMsgpack(val.Fields, w)
+	case *model.SyslogMessage:
+		return encodeMsgMsgpack(val, w)
+	default:
+		return defaultEncode(v, w)
+	}
+}
+
+// encodeMsgMsgpack encodes the message with the same fields as the json
+// format.
+func encodeMsgMsgpack(m *model.SyslogMessage, w io.Writer) (err error) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	mw := msgpackWriter{buf: buf}
+
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{"hostname", m.HostName},
+		{"appname", m.AppName},
+		{"procid", m.ProcId},
+		{"msgid", m.MsgId},
+		{"message", m.Message},
+	}
+	props := m.GetAllProperties()
+	n := 4
+	for _, f := range fields {
+		if len(f.value) > 0 {
+			n++
+		}
+	}
+	if len(props) > 0 {
+		n++
+	}
+
+	mw.mapHeader(n)
+	mw.str("facility")
+	mw.str(m.Facility.String())
+	mw.str("severity")
+	mw.str(m.Severity.String())
+	mw.str("timereported")
+	mw.timestamp(time.Unix(0, m.TimeReportedNum))
+	mw.str("timegenerated")
+	mw.timestamp(time.Unix(0, m.TimeGeneratedNum))
+	for _, f := range fields {
+		if len(f.value) > 0 {
+			mw.str(f.key)
+			mw.str(f.value)
+		}
+	}
+	if len(props) > 0 {
+		mw.str("properties")
+		domains := make([]string, 0, len(props))
+		for domain := range props {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+		mw.mapHeader(len(domains))
+		for _, domain := range domains {
+			mw.str(domain)
+			keys := make([]string, 0, len(props[domain]))
+			for key := range props[domain] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			mw.mapHeader(len(keys))
+			for _, key := range keys {
+				mw.str(key)
+				mw.str(props[domain][key])
+			}
+		}
+	}
+	_, err = w.Write(buf.B)
+	return err
+}
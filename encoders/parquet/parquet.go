@@ -0,0 +1,437 @@
+// Package parquet writes syslog messages as Parquet files, so that the
+// archived messages can be queried directly by Athena, Spark, etc.
+//
+// The files have a flat schema of required columns, with PLAIN encoding and
+// one data page per column chunk.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+var magic = []byte("PAR1")
+
+// Parquet physical types
+const (
+	typeInt32     int32 = 1
+	typeInt64     int32 = 2
+	typeByteArray int32 = 6
+)
+
+// Parquet converted types
+const (
+	convertedUTF8            int32 = 0
+	convertedTimestampMillis int32 = 9
+)
+
+const (
+	encodingPlain int32 = 0
+	encodingRLE   int32 = 3
+)
+
+// Codec is the compression codec of the column chunks.
+type Codec int32
+
+const (
+	Uncompressed Codec = 0
+	Snappy       Codec = 1
+	Gzip         Codec = 2
+)
+
+// ParseCodec parses the name of a compression codec.
+func ParseCodec(name string) (Codec, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "snappy":
+		return Snappy, nil
+	case "gzip":
+		return Gzip, nil
+	case "none", "uncompressed":
+		return Uncompressed, nil
+	default:
+		return 0, eerrors.Errorf("Unknown parquet compression: '%s'", name)
+	}
+}
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt32
+	kindTimestamp
+)
+
+// Column maps a message field to a column of the Parquet file.
+type Column struct {
+	Name  string
+	Field string
+	kind  kind
+	str   func(*model.FullMessage) string
+	num   func(*model.FullMessage) int64
+}
+
+func stringColumn(f func(*model.FullMessage) string) Column {
+	return Column{kind: kindString, str: f}
+}
+
+func numColumn(k kind, f func(*model.FullMessage) int64) Column {
+	return Column{kind: k, num: f}
+}
+
+var fields = map[string]Column{
+	"uid":         stringColumn(func(m *model.FullMessage) string { return m.Uid.String() }),
+	"client":      stringColumn(func(m *model.FullMessage) string { return m.ClientAddr }),
+	"source_type": stringColumn(func(m *model.FullMessage) string { return m.SourceType }),
+	"source_path": stringColumn(func(m *model.FullMessage) string { return m.SourcePath }),
+	"facility":    stringColumn(func(m *model.FullMessage) string { return m.Fields.Facility.String() }),
+	"severity":    stringColumn(func(m *model.FullMessage) string { return m.Fields.Severity.String() }),
+	"priority":    numColumn(kindInt32, func(m *model.FullMessage) int64 { return int64(m.Fields.Priority) }),
+	"version":     numColumn(kindInt32, func(m *model.FullMessage) int64 { return int64(m.Fields.Version) }),
+	"time_reported": numColumn(kindTimestamp, func(m *model.FullMessage) int64 {
+		return m.Fields.TimeReportedNum / 1000000
+	}),
+	"time_generated": numColumn(kindTimestamp, func(m *model.FullMessage) int64 {
+		return m.Fields.TimeGeneratedNum / 1000000
+	}),
+	"hostname":   stringColumn(func(m *model.FullMessage) string { return m.Fields.HostName }),
+	"appname":    stringColumn(func(m *model.FullMessage) string { return m.Fields.AppName }),
+	"procid":     stringColumn(func(m *model.FullMessage) string { return m.Fields.ProcId }),
+	"msgid":      stringColumn(func(m *model.FullMessage) string { return m.Fields.MsgId }),
+	"structured": stringColumn(func(m *model.FullMessage) string { return m.Fields.Structured }),
+	"message":    stringColumn(func(m *model.FullMessage) string { return m.Fields.Message }),
+	"properties": stringColumn(func(m *model.FullMessage) string {
+		b, err := json.Marshal(m.Fields.GetAllProperties())
+		if err != nil {
+			return "{}"
+		}
+		return string(b)
+	}),
+}
+
+// DefaultColumns is the schema used when no column is configured.
+var DefaultColumns = []string{
+	"uid", "client", "time_reported", "time_generated", "facility", "severity",
+	"hostname", "appname", "procid", "msgid", "structured", "message", "properties",
+}
+
+// ParseColumns builds the schema from the column specifications. A column is
+// specified as "field" or "name=field". The field is one of the fields
+// of the message, or a property as "domain:key".
+func ParseColumns(specs []string) ([]Column, error) {
+	if len(specs) == 0 {
+		specs = DefaultColumns
+	}
+	columns := make([]Column, 0, len(specs))
+	names := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		name, field := "", strings.TrimSpace(spec)
+		if idx := strings.Index(field, "="); idx != -1 {
+			name, field = strings.TrimSpace(field[:idx]), strings.TrimSpace(field[idx+1:])
+		}
+		var col Column
+		if idx := strings.Index(field, ":"); idx != -1 {
+			domain, key := field[:idx], field[idx+1:]
+			if len(domain) == 0 || len(key) == 0 {
+				return nil, eerrors.Errorf("Invalid parquet column property: '%s'", spec)
+			}
+			col = stringColumn(func(m *model.FullMessage) string { return m.Fields.GetProperty(domain, key) })
+			if len(name) == 0 {
+				name = domain + "_" + key
+			}
+		} else {
+			var ok bool
+			col, ok = fields[strings.ToLower(field)]
+			if !ok {
+				return nil, eerrors.Errorf("Unknown parquet column field: '%s'", spec)
+			}
+			if len(name) == 0 {
+				name = strings.ToLower(field)
+			}
+		}
+		if names[name] {
+			return nil, eerrors.Errorf("Duplicate parquet column: '%s'", name)
+		}
+		names[name] = true
+		col.Name = name
+		col.Field = field
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+type columnChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type rowGroup struct {
+	chunks  []columnChunk
+	numRows int64
+}
+
+// Writer encodes the messages as a Parquet file. The messages are buffered
+// in memory, and written by row groups. The file is only valid when the
+// writer has been closed.
+type Writer struct {
+	w            io.Writer
+	columns      []Column
+	codec        Codec
+	rowGroupSize int
+	buffers      []bytes.Buffer
+	rows         int
+	offset       int64
+	rowGroups    []rowGroup
+	mu           sync.Mutex
+}
+
+// NewWriter returns a Parquet writer. A row group is written every
+// rowGroupSize messages.
+func NewWriter(w io.Writer, columns []Column, codec Codec, rowGroupSize int) *Writer {
+	return &Writer{
+		w:            w,
+		columns:      columns,
+		codec:        codec,
+		rowGroupSize: rowGroupSize,
+		buffers:      make([]bytes.Buffer, len(columns)),
+	}
+}
+
+// Write appends a message to the current row group.
+func (pw *Writer) Write(m *model.FullMessage) error {
+	if m == nil || m.Fields == nil {
+		return nil
+	}
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	var b [8]byte
+	for i, col := range pw.columns {
+		buf := &pw.buffers[i]
+		switch col.kind {
+		case kindString:
+			s := col.str(m)
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+			buf.Write(b[:4])
+			buf.WriteString(s)
+		case kindInt32:
+			binary.LittleEndian.PutUint32(b[:4], uint32(col.num(m)))
+			buf.Write(b[:4])
+		case kindTimestamp:
+			binary.LittleEndian.PutUint64(b[:], uint64(col.num(m)))
+			buf.Write(b[:])
+		}
+	}
+	pw.rows++
+	if pw.rowGroupSize > 0 && pw.rows >= pw.rowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// Buffered returns the size of the messages that have not been written yet.
+func (pw *Writer) Buffered() int {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	size := 0
+	for i := range pw.buffers {
+		size += pw.buffers[i].Len()
+	}
+	return size
+}
+
+func (pw *Writer) write(p []byte) error {
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	return err
+}
+
+func (pw *Writer) compress(data []byte) ([]byte, error) {
+	switch pw.codec {
+	case Snappy:
+		return snappy.Encode(nil, data), nil
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(data)
+		if err == nil {
+			err = w.Close()
+		}
+		return buf.Bytes(), err
+	default:
+		return data, nil
+	}
+}
+
+// flush writes the buffered messages as a row group.
+func (pw *Writer) flush() error {
+	if pw.rows == 0 {
+		return nil
+	}
+	if pw.offset == 0 {
+		err := pw.write(magic)
+		if err != nil {
+			return err
+		}
+	}
+	group := rowGroup{chunks: make([]columnChunk, 0, len(pw.columns)), numRows: int64(pw.rows)}
+	for i := range pw.columns {
+		data := pw.buffers[i].Bytes()
+		compressed, err := pw.compress(data)
+		if err != nil {
+			return err
+		}
+		header := pageHeader(len(data), len(compressed), pw.rows)
+		chunk := columnChunk{
+			offset:           pw.offset,
+			uncompressedSize: int64(len(header) + len(data)),
+			compressedSize:   int64(len(header) + len(compressed)),
+		}
+		err = pw.write(header)
+		if err == nil {
+			err = pw.write(compressed)
+		}
+		if err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		pw.buffers[i].Reset()
+	}
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.rows = 0
+	return nil
+}
+
+// Flush writes the buffered messages as a row group.
+func (pw *Writer) Flush() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.flush()
+}
+
+// Close writes the buffered messages and the file footer. It does not close
+// the underlying writer.
+func (pw *Writer) Close() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	err := pw.flush()
+	if err != nil {
+		return err
+	}
+	if pw.offset == 0 {
+		err = pw.write(magic)
+		if err != nil {
+			return err
+		}
+	}
+	footer := pw.footer()
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	for _, p := range [][]byte{footer, length[:], magic} {
+		err = pw.write(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pageHeader(uncompressed, compressed, rows int) []byte {
+	w := &compactWriter{}
+	w.structBegin()
+	w.i32(1, 0) // DATA_PAGE
+	w.i32(2, int32(uncompressed))
+	w.i32(3, int32(compressed))
+	w.structField(5)
+	w.i32(1, int32(rows))
+	w.i32(2, encodingPlain)
+	w.i32(3, encodingRLE)
+	w.i32(4, encodingRLE)
+	w.structEnd()
+	w.structEnd()
+	return w.buf.Bytes()
+}
+
+func (c Column) physicalType() int32 {
+	switch c.kind {
+	case kindInt32:
+		return typeInt32
+	case kindTimestamp:
+		return typeInt64
+	default:
+		return typeByteArray
+	}
+}
+
+// footer encodes the FileMetaData structure.
+func (pw *Writer) footer() []byte {
+	var numRows int64
+	for _, group := range pw.rowGroups {
+		numRows += group.numRows
+	}
+	w := &compactWriter{}
+	w.structBegin()
+	w.i32(1, 1)
+
+	w.listHeader(2, tStruct, len(pw.columns)+1)
+	w.structBegin()
+	w.str(4, "schema")
+	w.i32(5, int32(len(pw.columns)))
+	w.structEnd()
+	for _, col := range pw.columns {
+		w.structBegin()
+		w.i32(1, col.physicalType())
+		w.i32(3, 0) // REQUIRED
+		w.str(4, col.Name)
+		switch col.kind {
+		case kindString:
+			w.i32(6, convertedUTF8)
+		case kindTimestamp:
+			w.i32(6, convertedTimestampMillis)
+		}
+		w.structEnd()
+	}
+
+	w.i64(3, numRows)
+
+	w.listHeader(4, tStruct, len(pw.rowGroups))
+	for _, group := range pw.rowGroups {
+		w.structBegin()
+		w.listHeader(1, tStruct, len(group.chunks))
+		var total int64
+		for i, chunk := range group.chunks {
+			total += chunk.uncompressedSize
+			col := pw.columns[i]
+			w.structBegin()
+			w.i64(2, chunk.offset)
+			w.structField(3)
+			w.i32(1, col.physicalType())
+			w.listHeader(2, tI32, 1)
+			w.zigzag(int64(encodingPlain))
+			w.listHeader(3, tBinary, 1)
+			w.binary(col.Name)
+			w.i32(4, int32(pw.codec))
+			w.i64(5, group.numRows)
+			w.i64(6, chunk.uncompressedSize)
+			w.i64(7, chunk.compressedSize)
+			w.i64(9, chunk.offset)
+			w.structEnd()
+			w.structEnd()
+		}
+		w.i64(2, total)
+		w.i64(3, group.numRows)
+		w.structEnd()
+	}
+
+	w.str(6, "skewer")
+	w.structEnd()
+	return w.buf.Bytes()
+}
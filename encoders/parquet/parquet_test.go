@@ -0,0 +1,268 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// compactReader decodes the Thrift compact structures written by
+// compactWriter. The structs are decoded as maps from field ids to values.
+type compactReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *compactReader) byte() byte {
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *compactReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		panic("invalid varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *compactReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *compactReader) value(typ byte) interface{} {
+	switch typ {
+	case tI32, tI64:
+		return r.zigzag()
+	case tBinary:
+		n := int(r.varint())
+		b := r.buf[r.pos : r.pos+n]
+		r.pos += n
+		return string(b)
+	case tList:
+		h := r.byte()
+		size, elemType := int(h>>4), h&0x0f
+		if size == 15 {
+			size = int(r.varint())
+		}
+		l := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			l = append(l, r.value(elemType))
+		}
+		return l
+	case tStruct:
+		return r.structure()
+	default:
+		panic(fmt.Sprintf("unexpected thrift type %d", typ))
+	}
+}
+
+func (r *compactReader) structure() map[int16]interface{} {
+	s := make(map[int16]interface{})
+	var id int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return s
+		}
+		typ, delta := h&0x0f, int16(h>>4)
+		if delta == 0 {
+			id = int16(r.zigzag())
+		} else {
+			id += delta
+		}
+		s[id] = r.value(typ)
+	}
+}
+
+func TestCompactWriter(t *testing.T) {
+	w := &compactWriter{}
+	w.structBegin()
+	w.i32(1, 1)
+	w.i64(20, -3)
+	w.structField(21)
+	w.str(1, "a")
+	w.structEnd()
+	w.listHeader(22, tI32, 20)
+	for i := 0; i < 20; i++ {
+		w.zigzag(int64(i))
+	}
+	w.structEnd()
+
+	assert.Equal(t, []byte{0x15, 0x02, 0x06, 0x28, 0x05}, w.buf.Bytes()[:5])
+
+	r := &compactReader{buf: w.buf.Bytes()}
+	s := r.structure()
+	assert.Equal(t, len(w.buf.Bytes()), r.pos)
+	assert.Equal(t, int64(1), s[1])
+	assert.Equal(t, int64(-3), s[20])
+	assert.Equal(t, map[int16]interface{}{1: "a"}, s[21])
+	l := s[22].([]interface{})
+	if assert.Len(t, l, 20) {
+		assert.Equal(t, int64(19), l[19])
+	}
+}
+
+func testMessages(n int) []*model.FullMessage {
+	msgs := make([]*model.FullMessage, 0, n)
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, &model.FullMessage{
+			Fields: &model.SyslogMessage{
+				AppName:         fmt.Sprintf("app%d", i),
+				Priority:        model.Priority(i + 10),
+				TimeReportedNum: int64(i+1) * 1000000000,
+				Message:         fmt.Sprintf("message number %d", i),
+			},
+		})
+	}
+	return msgs
+}
+
+// readColumns decodes the file, and returns the values of each column.
+func readColumns(t *testing.T, file []byte, codec Codec) ([]string, [][]interface{}, int64) {
+	if len(file) < 12 || !bytes.Equal(file[:4], magic) || !bytes.Equal(file[len(file)-4:], magic) {
+		t.Fatal("the file does not begin and end with the parquet magic")
+	}
+	length := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := (&compactReader{buf: file[len(file)-8-length : len(file)-8]}).structure()
+
+	schema := meta[2].([]interface{})
+	var names []string
+	for _, elem := range schema[1:] {
+		names = append(names, elem.(map[int16]interface{})[4].(string))
+	}
+	types := make([]int64, 0, len(names))
+	for _, elem := range schema[1:] {
+		types = append(types, elem.(map[int16]interface{})[1].(int64))
+	}
+
+	values := make([][]interface{}, len(names))
+	for _, g := range meta[4].([]interface{}) {
+		group := g.(map[int16]interface{})
+		rows := group[3].(int64)
+		for i, c := range group[1].([]interface{}) {
+			chunk := c.(map[int16]interface{})[3].(map[int16]interface{})
+			assert.Equal(t, int64(codec), chunk[4])
+			assert.Equal(t, rows, chunk[5])
+			r := &compactReader{buf: file, pos: int(chunk[9].(int64))}
+			header := r.structure()
+			assert.Equal(t, rows, header[5].(map[int16]interface{})[1])
+			page := file[r.pos : r.pos+int(header[3].(int64))]
+			switch codec {
+			case Snappy:
+				var err error
+				page, err = snappy.Decode(nil, page)
+				if err != nil {
+					t.Fatal(err)
+				}
+			case Gzip:
+				gr, err := gzip.NewReader(bytes.NewReader(page))
+				if err != nil {
+					t.Fatal(err)
+				}
+				page, err = ioutil.ReadAll(gr)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !assert.Equal(t, int(header[2].(int64)), len(page)) {
+				t.FailNow()
+			}
+			for j := int64(0); j < rows; j++ {
+				switch int32(types[i]) {
+				case typeByteArray:
+					n := int(binary.LittleEndian.Uint32(page))
+					values[i] = append(values[i], string(page[4:4+n]))
+					page = page[4+n:]
+				case typeInt32:
+					values[i] = append(values[i], int64(int32(binary.LittleEndian.Uint32(page))))
+					page = page[4:]
+				case typeInt64:
+					values[i] = append(values[i], int64(binary.LittleEndian.Uint64(page)))
+					page = page[8:]
+				}
+			}
+			assert.Empty(t, page)
+		}
+	}
+	return names, values, meta[3].(int64)
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	columns, err := ParseColumns([]string{"appname", "priority", "time_reported", "text=message"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, codec := range []Codec{Uncompressed, Snappy, Gzip} {
+		t.Run(fmt.Sprintf("codec %d", codec), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, columns, codec, 2)
+			msgs := testMessages(5)
+			for _, m := range msgs {
+				if err := w.Write(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			names, values, rows := readColumns(t, buf.Bytes(), codec)
+			assert.Equal(t, []string{"appname", "priority", "time_reported", "text"}, names)
+			assert.Equal(t, int64(5), rows)
+			for i, m := range msgs {
+				assert.Equal(t, m.Fields.AppName, values[0][i])
+				assert.Equal(t, int64(m.Fields.Priority), values[1][i])
+				assert.Equal(t, m.Fields.TimeReportedNum/1000000, values[2][i])
+				assert.Equal(t, m.Fields.Message, values[3][i])
+			}
+		})
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	columns, err := ParseColumns(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, columns, Snappy, 10)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, values, rows := readColumns(t, buf.Bytes(), Snappy)
+	assert.Equal(t, DefaultColumns, names)
+	assert.Equal(t, int64(0), rows)
+	for _, v := range values {
+		assert.Empty(t, v)
+	}
+}
+
+func TestWriterBuffered(t *testing.T) {
+	columns, err := ParseColumns([]string{"appname"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, columns, Uncompressed, 2)
+	if err := w.Write(testMessages(1)[0]); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, buf.Len())
+	assert.Equal(t, 8, w.Buffered())
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, w.Buffered())
+	assert.True(t, buf.Len() > 0)
+}
@@ -0,0 +1,91 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// thrift compact protocol types
+const (
+	tI32    byte = 5
+	tI64    byte = 6
+	tBinary byte = 8
+	tList   byte = 9
+	tStruct byte = 12
+)
+
+// compactWriter encodes the Parquet metadata with the Thrift compact protocol.
+// Only the parts of the protocol that the metadata needs are implemented.
+type compactWriter struct {
+	buf     bytes.Buffer
+	lastIDs []int16
+	lastID  int16
+}
+
+func (w *compactWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buf.Write(b[:n])
+}
+
+func (w *compactWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *compactWriter) fieldHeader(id int16, typ byte) {
+	delta := id - w.lastID
+	if delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+	w.lastID = id
+}
+
+func (w *compactWriter) structBegin() {
+	w.lastIDs = append(w.lastIDs, w.lastID)
+	w.lastID = 0
+}
+
+func (w *compactWriter) structEnd() {
+	w.buf.WriteByte(0)
+	w.lastID = w.lastIDs[len(w.lastIDs)-1]
+	w.lastIDs = w.lastIDs[:len(w.lastIDs)-1]
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.fieldHeader(id, tI32)
+	w.zigzag(int64(v))
+}
+
+func (w *compactWriter) i64(id int16, v int64) {
+	w.fieldHeader(id, tI64)
+	w.zigzag(v)
+}
+
+func (w *compactWriter) binary(v string) {
+	w.varint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+func (w *compactWriter) str(id int16, v string) {
+	w.fieldHeader(id, tBinary)
+	w.binary(v)
+}
+
+func (w *compactWriter) listHeader(id int16, elemType byte, size int) {
+	w.fieldHeader(id, tList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		w.varint(uint64(size))
+	}
+}
+
+// structField begins a nested struct field. It must be closed by structEnd.
+func (w *compactWriter) structField(id int16) {
+	w.fieldHeader(id, tStruct)
+	w.structBegin()
+}
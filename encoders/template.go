@@ -0,0 +1,55 @@
+package encoders
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/stephane-martin/skewer/encoders/baseenc"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/templates"
+)
+
+// TemplatePrefix prefixes the formats that reference a template of the
+// configuration, like "template:short".
+const TemplatePrefix = "template:"
+
+// ConfigureTemplates registers each template as a format named
+// "template:name". The templates are executed with the *model.FullMessage.
+func ConfigureTemplates(texts map[string]string) error {
+	for name, text := range texts {
+		tmpl, err := templates.New(name).Parse(text)
+		if err != nil {
+			return eerrors.Wrapf(err, "Invalid template '%s'", name)
+		}
+		frmt := baseenc.RegisterFormat(TemplatePrefix + name)
+		encodersMu.Lock()
+		encoders[frmt] = templateEncoder(tmpl)
+		encodersMu.Unlock()
+	}
+	return nil
+}
+
+func templateEncoder(tmpl *template.Template) Encoder {
+	return func(v interface{}, w io.Writer) error {
+		if v == nil {
+			return nil
+		}
+		switch val := v.(type) {
+		case *model.FullMessage:
+			return executeTemplate(tmpl, val, w)
+		case *model.SyslogMessage:
+			return executeTemplate(tmpl, &model.FullMessage{Fields: val}, w)
+		default:
+			return defaultEncode(v, w)
+		}
+	}
+}
+
+func executeTemplate(tmpl *template.Template, m *model.FullMessage, w io.Writer) error {
+	err := tmpl.Execute(w, m)
+	if err != nil {
+		return EncodingError(err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package filters
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	key     string
+	value   string
+	found   bool
+	expires time.Time
+}
+
+// ttlCache is a LRU cache of bounded size, whose entries expire. It also
+// remembers the failed lookups.
+type ttlCache struct {
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	sync.Mutex
+}
+
+func newTTLCache(size int) *ttlCache {
+	return &ttlCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached value for key. ok is false when the key is not in
+// the cache or has expired, found is false when the lookup had failed.
+func (c *ttlCache) get(key string) (value string, found bool, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	elt, ok := c.entries[key]
+	if !ok {
+		return "", false, false
+	}
+	entry := elt.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(elt)
+		delete(c.entries, key)
+		return "", false, false
+	}
+	c.order.MoveToFront(elt)
+	return entry.value, entry.found, true
+}
+
+func (c *ttlCache) put(key, value string, found bool, ttl time.Duration) {
+	if c.size <= 0 || ttl <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	entry := &cacheEntry{key: key, value: value, found: found, expires: time.Now().Add(ttl)}
+	if elt, ok := c.entries[key]; ok {
+		elt.Value = entry
+		c.order.MoveToFront(elt)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,22 @@
+package filters
+
+import (
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+)
+
+// timeClamper replaces the timestamps that are too far in the future by the
+// reception time.
+type timeClamper time.Duration
+
+func (c timeClamper) Apply(m *model.FullMessage) Result {
+	f := m.Fields
+	if f.TimeGeneratedNum == 0 || f.TimeReportedNum <= f.TimeGeneratedNum+int64(c) {
+		return PASS
+	}
+	f.TimeReportedNum = f.TimeGeneratedNum
+	f.SetProperty("skewer", "time_clamped", "true")
+	clampedCounter.WithLabelValues(client(m)).Inc()
+	return PASS
+}
@@ -0,0 +1,353 @@
+// Package filters implements the stages that filter and transform the
+// messages of each source, before they are stored.
+package filters
+
+import (
+	"bytes"
+	"sync"
+	"text/template"
+
+	"github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/templates"
+)
+
+var Registry *prometheus.Registry
+var rateLimitedCounter *prometheus.CounterVec
+var sampledCounter *prometheus.CounterVec
+var truncatedCounter *prometheus.CounterVec
+var schemaInvalidCounter *prometheus.CounterVec
+var clampedCounter *prometheus.CounterVec
+var sequenceGapsCounter *prometheus.CounterVec
+var sequenceMissingCounter *prometheus.CounterVec
+var sequenceRegressionsCounter *prometheus.CounterVec
+var lookupCounter *prometheus.CounterVec
+
+var once sync.Once
+
+func InitRegistry() {
+	once.Do(func() {
+		rateLimitedCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_ratelimited_total",
+				Help: "number of messages over a rate limit",
+			},
+			[]string{"action", "client"},
+		)
+
+		sampledCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_sampled_out_total",
+				Help: "number of messages dropped by sampling",
+			},
+			[]string{"client"},
+		)
+
+		truncatedCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_truncated_total",
+				Help: "number of messages longer than the maximum length",
+			},
+			[]string{"strategy", "client"},
+		)
+
+		schemaInvalidCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_schema_invalid_total",
+				Help: "number of messages that are not valid against the JSON Schema",
+			},
+			[]string{"action", "client"},
+		)
+
+		clampedCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_time_clamped_total",
+				Help: "number of messages with a timestamp in the future",
+			},
+			[]string{"client"},
+		)
+
+		sequenceGapsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_sequence_gaps_total",
+				Help: "number of gaps in the sequence numbers of the messages",
+			},
+			[]string{"client"},
+		)
+
+		sequenceMissingCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_sequence_missing_total",
+				Help: "number of sequence numbers that never arrived",
+			},
+			[]string{"client"},
+		)
+
+		sequenceRegressionsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_sequence_regressions_total",
+				Help: "number of messages with a sequence number already seen or lower than expected",
+			},
+			[]string{"client"},
+		)
+
+		lookupCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_lookups_total",
+				Help: "number of lookups in external services, by result (found, notfound, error, cached)",
+			},
+			[]string{"domain", "result"},
+		)
+
+		Registry = prometheus.NewRegistry()
+		Registry.MustRegister(
+			rateLimitedCounter, sampledCounter, truncatedCounter, schemaInvalidCounter, clampedCounter,
+			sequenceGapsCounter, sequenceMissingCounter, sequenceRegressionsCounter, lookupCounter,
+		)
+	})
+}
+
+// Result tells what to do with a message after a stage.
+type Result int
+
+const (
+	PASS    Result = 0
+	DROPPED Result = 1
+)
+
+// Stage is a step of the filtering pipeline of a source. A stage may modify
+// the message.
+type Stage interface {
+	Apply(m *model.FullMessage) Result
+}
+
+// Pipeline applies the stages configured for a source, in order.
+type Pipeline []Stage
+
+// shared holds the resources that the pipelines of the sources share.
+type shared struct {
+	geoDBs    []*geoDB
+	resolver  *dnsResolver
+	lookups   []*lookupEnricher
+	redactors []*redactor
+}
+
+func (sh *shared) close() {
+	for _, db := range sh.geoDBs {
+		db.close()
+	}
+	for _, l := range sh.lookups {
+		l.close()
+	}
+	for _, r := range sh.redactors {
+		r.close()
+	}
+}
+
+// newPipeline builds the stages from the configuration of a source.
+func newPipeline(c *conf.FilterSubConfig, sh *shared, logger log15.Logger) (Pipeline, error) {
+	p := make(Pipeline, 0)
+	// filter the levels first, it is the cheapest way to drop messages
+	if len(c.MinSeverity) > 0 || len(c.MaxSeverity) > 0 || len(c.Facility) > 0 {
+		stage, err := newLevelFilter(c)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, stage)
+	}
+	if c.MaxFuture > 0 {
+		p = append(p, timeClamper(c.MaxFuture))
+	}
+	if c.HostnameLowercase || c.HostnameStripDomain || len(c.HostnameMap) > 0 {
+		stage, err := newHostnameCanonicalizer(c)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, stage)
+	}
+	// check the sequences before any message is dropped
+	if len(c.SequenceCheck) > 0 {
+		p = append(p, newSequenceChecker(c, logger))
+	}
+	// then tag, so that the next stages can use the tags
+	if len(c.Tags) > 0 {
+		p = append(p, newTagger(c.Tags))
+	}
+	// then sample, so that the dropped messages don't count in the rate
+	// limits
+	for _, sc := range c.Samples {
+		stage, err := newSampler(sc, logger)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, stage)
+	}
+	for _, rl := range c.RateLimits {
+		stage, err := newRateLimiter(rl, logger)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, stage)
+	}
+	// validate before the redactions and the truncation, that modify the
+	// messages
+	if c.JSONSchema != nil {
+		stage, err := newSchemaValidator(c.JSONSchema)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, stage)
+	}
+	for _, rc := range c.Redactions {
+		stage, err := newRedactor(rc)
+		if err != nil {
+			return nil, err
+		}
+		sh.redactors = append(sh.redactors, stage)
+		p = append(p, stage)
+	}
+	// truncate after the redactions, so that no partial sensitive data is
+	// left at the end of the messages
+	if c.MaxMessageLength > 0 {
+		p = append(p, newTruncater(c))
+	}
+	if len(c.GeoIPFields) > 0 {
+		stage, err := newGeoIPEnricher(c.GeoIPFields, sh.geoDBs)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, stage)
+	}
+	for _, rc := range c.ReverseDNS {
+		stage, err := newReverseDNS(rc, sh.resolver, logger)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, stage)
+	}
+	// the lookup keys may use the previous enrichments
+	for _, lc := range c.Lookups {
+		stage, err := newLookupEnricher(lc, logger)
+		if err != nil {
+			return nil, err
+		}
+		sh.lookups = append(sh.lookups, stage)
+		p = append(p, stage)
+	}
+	// transform last, so that the enrichments can be renamed or removed too
+	for _, tc := range c.Transforms {
+		stage, err := newTransformer(tc)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, stage)
+	}
+	return p, nil
+}
+
+// Apply runs the message through the stages, and stops at the first stage
+// that drops the message.
+func (p Pipeline) Apply(m *model.FullMessage) Result {
+	for _, stage := range p {
+		if stage.Apply(m) == DROPPED {
+			return DROPPED
+		}
+	}
+	return PASS
+}
+
+// Pipelines gives the pipeline of each source, by configuration ID.
+type Pipelines struct {
+	bySource map[utils.MyULID]Pipeline
+	shared   *shared
+}
+
+// New builds the pipelines of the sources that have filter stages.
+func New(c *conf.BaseConfig, logger log15.Logger) (*Pipelines, error) {
+	InitRegistry()
+	var err error
+	sh := &shared{resolver: newDNSResolver(c.DNSCache, logger)}
+	sh.geoDBs, err = openGeoDBs(c.GeoIP, logger)
+	if err != nil {
+		return nil, err
+	}
+	ps := &Pipelines{bySource: make(map[utils.MyULID]Pipeline), shared: sh}
+	for _, source := range c.Sources() {
+		filterConf := source.FilterConf()
+		if filterConf == nil {
+			continue
+		}
+		confID := filterConf.CalculateID()
+		if _, ok := ps.bySource[confID]; ok {
+			continue
+		}
+		p, err := newPipeline(filterConf, sh, logger)
+		if err != nil {
+			ps.Close()
+			return nil, err
+		}
+		if len(p) > 0 {
+			ps.bySource[confID] = p
+		}
+	}
+	return ps, nil
+}
+
+// Apply runs the message through the pipeline of its source.
+func (ps *Pipelines) Apply(m *model.FullMessage) Result {
+	if ps == nil || len(ps.bySource) == 0 || m == nil || m.Fields == nil {
+		return PASS
+	}
+	p, ok := ps.bySource[m.ConfId]
+	if !ok {
+		return PASS
+	}
+	return p.Apply(m)
+}
+
+// Close releases the resources of the pipelines.
+func (ps *Pipelines) Close() {
+	if ps == nil {
+		return
+	}
+	ps.shared.close()
+}
+
+// templateData is given to the templates of the stages: the fields of the
+// message, plus the client address.
+type templateData struct {
+	*model.SyslogMessage
+	Client string
+}
+
+func newTemplateData(m *model.FullMessage) templateData {
+	return templateData{SyslogMessage: m.Fields, Client: client(m)}
+}
+
+func client(m *model.FullMessage) string {
+	if len(m.ClientAddr) > 0 {
+		return m.ClientAddr
+	}
+	return m.Fields.GetProperty("skewer", "client")
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := templates.New(name).Parse(text)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Error compiling the %s template", name)
+	}
+	return tmpl, nil
+}
+
+func execTemplate(tmpl *template.Template, m *model.FullMessage) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, newTemplateData(m))
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,215 @@
+package filters
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	maxminddb "github.com/oschwald/maxminddb-golang"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// geoRecord holds the fields that are read from the City, Country and ASN
+// databases.
+type geoRecord struct {
+	Country struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+func (r *geoRecord) properties(props map[string]string) {
+	if len(r.Country.IsoCode) > 0 {
+		props["country_code"] = r.Country.IsoCode
+	}
+	if name := r.Country.Names["en"]; len(name) > 0 {
+		props["country"] = name
+	}
+	if name := r.City.Names["en"]; len(name) > 0 {
+		props["city"] = name
+	}
+	if r.Location.Latitude != 0 || r.Location.Longitude != 0 {
+		props["latitude"] = strconv.FormatFloat(r.Location.Latitude, 'f', -1, 64)
+		props["longitude"] = strconv.FormatFloat(r.Location.Longitude, 'f', -1, 64)
+	}
+	if r.ASN != 0 {
+		props["asn"] = strconv.FormatUint(uint64(r.ASN), 10)
+	}
+	if len(r.ASOrg) > 0 {
+		props["as_org"] = r.ASOrg
+	}
+}
+
+// geoDB is a MaxMind database, that is reopened when the file changes.
+type geoDB struct {
+	path        string
+	checkPeriod time.Duration
+	logger      log15.Logger
+	reader      *maxminddb.Reader
+	modTime     time.Time
+	lastCheck   time.Time
+	sync.RWMutex
+}
+
+func openGeoDB(path string, checkPeriod time.Duration, logger log15.Logger) (*geoDB, error) {
+	db := &geoDB{path: path, checkPeriod: checkPeriod, logger: logger}
+	err := db.open()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *geoDB) open() error {
+	infos, err := os.Stat(db.path)
+	if err != nil {
+		return eerrors.Wrapf(err, "Failed to stat GeoIP database '%s'", db.path)
+	}
+	reader, err := maxminddb.Open(db.path)
+	if err != nil {
+		return eerrors.Wrapf(err, "Failed to open GeoIP database '%s'", db.path)
+	}
+	if db.reader != nil {
+		_ = db.reader.Close()
+	}
+	db.reader = reader
+	db.modTime = infos.ModTime()
+	db.lastCheck = time.Now()
+	return nil
+}
+
+// reload reopens the database when the file has been modified.
+func (db *geoDB) reload() {
+	db.Lock()
+	defer db.Unlock()
+	if db.reader == nil || time.Since(db.lastCheck) < db.checkPeriod {
+		return
+	}
+	db.lastCheck = time.Now()
+	infos, err := os.Stat(db.path)
+	if err != nil || infos.ModTime().Equal(db.modTime) {
+		return
+	}
+	err = db.open()
+	if err != nil {
+		db.logger.Warn("Failed to reload the GeoIP database", "error", err)
+		return
+	}
+	db.logger.Info("GeoIP database reloaded", "path", db.path)
+}
+
+func (db *geoDB) close() {
+	db.Lock()
+	if db.reader != nil {
+		_ = db.reader.Close()
+		db.reader = nil
+	}
+	db.Unlock()
+}
+
+func (db *geoDB) lookup(ip net.IP, props map[string]string) {
+	db.RLock()
+	check := time.Since(db.lastCheck) >= db.checkPeriod
+	db.RUnlock()
+	if check {
+		db.reload()
+	}
+	var record geoRecord
+	db.RLock()
+	if db.reader == nil {
+		db.RUnlock()
+		return
+	}
+	err := db.reader.Lookup(ip, &record)
+	db.RUnlock()
+	if err != nil {
+		db.logger.Debug("GeoIP lookup failed", "ip", ip.String(), "error", err)
+		return
+	}
+	record.properties(props)
+}
+
+func openGeoDBs(c conf.GeoIPConfig, logger log15.Logger) ([]*geoDB, error) {
+	dbs := make([]*geoDB, 0, len(c.Databases))
+	for _, path := range c.Databases {
+		db, err := openGeoDB(path, c.CheckPeriod, logger)
+		if err != nil {
+			return nil, err
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
+// geoipEnricher adds the location of some IP addresses to the geoip
+// properties of the messages. When several fields are looked up, the
+// properties are prefixed by the field name.
+type geoipEnricher struct {
+	names   []string
+	getters []func(m *model.FullMessage) string
+	dbs     []*geoDB
+}
+
+func newGeoIPEnricher(fields []string, dbs []*geoDB) (*geoipEnricher, error) {
+	if len(dbs) == 0 {
+		return nil, eerrors.New("No GeoIP database is configured")
+	}
+	e := &geoipEnricher{names: fields, dbs: dbs}
+	for _, name := range fields {
+		getter, err := fullGetter(name)
+		if err != nil {
+			return nil, err
+		}
+		e.getters = append(e.getters, getter)
+	}
+	return e, nil
+}
+
+func (e *geoipEnricher) Apply(m *model.FullMessage) Result {
+	for i, get := range e.getters {
+		ip := parseIP(get(m))
+		if ip == nil {
+			continue
+		}
+		props := make(map[string]string)
+		for _, db := range e.dbs {
+			db.lookup(ip, props)
+		}
+		for k, v := range props {
+			if len(e.names) > 1 {
+				k = e.names[i] + "." + k
+			}
+			m.Fields.SetProperty("geoip", k, v)
+		}
+	}
+	return PASS
+}
+
+// parseIP parses an IP address, that may be followed by a port.
+func parseIP(s string) net.IP {
+	if len(s) == 0 {
+		return nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
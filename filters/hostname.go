@@ -0,0 +1,85 @@
+package filters
+
+import (
+	"encoding/csv"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// hostnameCanonicalizer normalizes the hostnames, so that a machine always
+// has the same name.
+type hostnameCanonicalizer struct {
+	lowercase   bool
+	stripDomain bool
+	names       map[string]string
+}
+
+func newHostnameCanonicalizer(c *conf.FilterSubConfig) (*hostnameCanonicalizer, error) {
+	h := &hostnameCanonicalizer{lowercase: c.HostnameLowercase, stripDomain: c.HostnameStripDomain}
+	if len(c.HostnameMap) > 0 {
+		names, err := readHostnameMap(c.HostnameMap, c.HostnameLowercase)
+		if err != nil {
+			return nil, err
+		}
+		h.names = names
+	}
+	return h, nil
+}
+
+// readHostnameMap reads the alias,canonical lines of a CSV file.
+func readHostnameMap(path string, lowercase bool) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Failed to open the hostname map '%s'", path)
+	}
+	defer func() { _ = f.Close() }()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	names := make(map[string]string)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, eerrors.Wrapf(err, "Invalid hostname map '%s'", path)
+		}
+		alias := strings.TrimSpace(record[0])
+		if lowercase {
+			alias = strings.ToLower(alias)
+		}
+		names[alias] = strings.TrimSpace(record[1])
+	}
+}
+
+func (h *hostnameCanonicalizer) Apply(m *model.FullMessage) Result {
+	name := m.Fields.HostName
+	if len(name) == 0 {
+		return PASS
+	}
+	if h.lowercase {
+		name = strings.ToLower(name)
+	}
+	if canonical, ok := h.names[name]; ok {
+		m.Fields.HostName = canonical
+		return PASS
+	}
+	if h.stripDomain && net.ParseIP(name) == nil {
+		if dot := strings.IndexByte(name, '.'); dot > 0 {
+			name = name[:dot]
+			if canonical, ok := h.names[name]; ok {
+				name = canonical
+			}
+		}
+	}
+	m.Fields.HostName = name
+	return PASS
+}
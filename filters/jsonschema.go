@@ -0,0 +1,68 @@
+package filters
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+)
+
+// schemaValidator checks that a field of the messages is valid against a JSON
+// Schema.
+type schemaValidator struct {
+	schema *jsonschema.Resolved
+	get    func(m *model.FullMessage) string
+	action string
+	dests  string
+}
+
+func newSchemaValidator(c *conf.JSONSchemaConfig) (*schemaValidator, error) {
+	schema, err := c.Compile()
+	if err != nil {
+		return nil, err
+	}
+	get, err := fullGetter(c.Field)
+	if err != nil {
+		return nil, err
+	}
+	v := &schemaValidator{schema: schema, get: get, action: c.Action}
+	if c.Action == conf.FilterRoute {
+		names := make([]string, 0, len(c.Destination))
+		for _, name := range c.Destination {
+			names = append(names, strings.TrimSpace(strings.ToLower(name)))
+		}
+		v.dests = strings.Join(names, ",")
+	}
+	return v, nil
+}
+
+func (v *schemaValidator) Apply(m *model.FullMessage) Result {
+	var instance interface{}
+	err := json.Unmarshal([]byte(v.get(m)), &instance)
+	if err == nil {
+		err = v.schema.Validate(instance)
+	}
+	if err == nil {
+		return PASS
+	}
+	schemaInvalidCounter.WithLabelValues(v.action, client(m)).Inc()
+	if v.action == conf.FilterDrop {
+		return DROPPED
+	}
+	m.Fields.SetProperty("skewer", "schema_invalid", "true")
+	m.Fields.SetProperty("skewer", "schema_error", oneLine(err.Error()))
+	if v.action == conf.FilterRoute {
+		m.Fields.SetProperty("skewer", "destinations", v.dests)
+	}
+	return PASS
+}
+
+func oneLine(s string) string {
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.Join(lines, "; ")
+}
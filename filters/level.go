@@ -0,0 +1,55 @@
+package filters
+
+import (
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// levelFilter drops the messages outside of a range of severities, or
+// without an allowed facility.
+type levelFilter struct {
+	// the most severe levels have the lowest values
+	min        model.Severity
+	max        model.Severity
+	facilities map[model.Facility]bool
+}
+
+func newLevelFilter(c *conf.FilterSubConfig) (*levelFilter, error) {
+	f := &levelFilter{min: model.Sdebug, max: model.Semerg}
+	if len(c.MinSeverity) > 0 {
+		sev, ok := model.RSeverities[c.MinSeverity]
+		if !ok {
+			return nil, eerrors.Errorf("Unknown min_severity: '%s'", c.MinSeverity)
+		}
+		f.min = sev
+	}
+	if len(c.MaxSeverity) > 0 {
+		sev, ok := model.RSeverities[c.MaxSeverity]
+		if !ok {
+			return nil, eerrors.Errorf("Unknown max_severity: '%s'", c.MaxSeverity)
+		}
+		f.max = sev
+	}
+	if len(c.Facility) > 0 {
+		f.facilities = make(map[model.Facility]bool, len(c.Facility))
+		for _, name := range c.Facility {
+			fac, ok := model.RFacilities[name]
+			if !ok {
+				return nil, eerrors.Errorf("Unknown facility: '%s'", name)
+			}
+			f.facilities[fac] = true
+		}
+	}
+	return f, nil
+}
+
+func (f *levelFilter) Apply(m *model.FullMessage) Result {
+	if m.Fields.Severity > f.min || m.Fields.Severity < f.max {
+		return DROPPED
+	}
+	if f.facilities != nil && !f.facilities[m.Fields.Facility] {
+		return DROPPED
+	}
+	return PASS
+}
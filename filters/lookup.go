@@ -0,0 +1,229 @@
+package filters
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// the bodies of the HTTP responses are read up to maxLookupBody bytes
+const maxLookupBody = 64 * 1024
+
+// lookupBackend returns the result of a lookup, as properties. found is false
+// when the service has no result for the key.
+type lookupBackend interface {
+	lookup(ctx context.Context, key string) (result map[string]string, found bool, err error)
+	close()
+}
+
+// lookupEnricher sets the result of the lookup of a key in an external
+// service as properties of the messages.
+type lookupEnricher struct {
+	key         *template.Template
+	domain      string
+	name        string
+	timeout     time.Duration
+	ttl         time.Duration
+	negativeTTL time.Duration
+	cache       *ttlCache
+	backend     lookupBackend
+	logger      log15.Logger
+}
+
+func newLookupEnricher(c conf.LookupConfig, logger log15.Logger) (*lookupEnricher, error) {
+	key, err := parseTemplate("lookup key", c.Key)
+	if err != nil {
+		return nil, err
+	}
+	l := &lookupEnricher{
+		key:         key,
+		domain:      c.Domain,
+		name:        c.Name,
+		timeout:     c.Timeout,
+		ttl:         c.CacheTTL,
+		negativeTTL: c.NegativeTTL,
+		cache:       newTTLCache(c.CacheSize),
+		logger:      logger,
+	}
+	if len(c.URL) > 0 {
+		l.backend = &httpLookup{
+			url:    c.URL,
+			name:   c.Name,
+			client: &http.Client{Timeout: c.Timeout},
+		}
+	} else {
+		l.backend = &redisLookup{
+			prefix: c.KeyPrefix,
+			name:   c.Name,
+			client: redis.NewClient(&redis.Options{
+				Addr:         c.Redis,
+				Password:     c.RedisPassword,
+				DB:           c.RedisDatabase,
+				DialTimeout:  c.Timeout,
+				ReadTimeout:  c.Timeout,
+				WriteTimeout: c.Timeout,
+			}),
+		}
+	}
+	return l, nil
+}
+
+func (l *lookupEnricher) Apply(m *model.FullMessage) Result {
+	key, err := execTemplate(l.key, m)
+	if err != nil {
+		l.logger.Info("Error calculating the lookup key", "error", err, "uid", m.Uid)
+		return PASS
+	}
+	key = strings.TrimSpace(key)
+	if len(key) == 0 {
+		return PASS
+	}
+	result, found := l.get(key)
+	if !found {
+		return PASS
+	}
+	for k, v := range result {
+		m.Fields.SetProperty(l.domain, k, v)
+	}
+	return PASS
+}
+
+// get returns the result of the lookup of key, from the cache if possible.
+// The failed lookups are cached too, so that a service down does not slow
+// down every message.
+func (l *lookupEnricher) get(key string) (map[string]string, bool) {
+	if value, found, ok := l.cache.get(key); ok {
+		lookupCounter.WithLabelValues(l.domain, "cached").Inc()
+		if !found {
+			return nil, false
+		}
+		var result map[string]string
+		_ = json.Unmarshal([]byte(value), &result)
+		return result, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	result, found, err := l.backend.lookup(ctx, key)
+	cancel()
+	if err != nil {
+		lookupCounter.WithLabelValues(l.domain, "error").Inc()
+		l.logger.Info("Lookup failed", "domain", l.domain, "key", key, "error", err)
+		l.cache.put(key, "", false, l.negativeTTL)
+		return nil, false
+	}
+	if !found {
+		lookupCounter.WithLabelValues(l.domain, "notfound").Inc()
+		l.cache.put(key, "", false, l.negativeTTL)
+		return nil, false
+	}
+	lookupCounter.WithLabelValues(l.domain, "found").Inc()
+	value, _ := json.Marshal(result)
+	l.cache.put(key, string(value), true, l.ttl)
+	return result, true
+}
+
+func (l *lookupEnricher) close() {
+	l.backend.close()
+}
+
+// toProperties converts a lookup result to properties: the fields of a JSON
+// object, or a single property.
+func toProperties(name string, body []byte) map[string]string {
+	var obj map[string]interface{}
+	if json.Unmarshal(body, &obj) != nil {
+		return map[string]string{name: strings.TrimSpace(string(body))}
+	}
+	result := make(map[string]string, len(obj))
+	for k, v := range obj {
+		switch val := v.(type) {
+		case nil:
+		case string:
+			result[k] = val
+		case float64:
+			result[k] = strconv.FormatFloat(val, 'f', -1, 64)
+		case bool:
+			result[k] = strconv.FormatBool(val)
+		default:
+			b, _ := json.Marshal(val)
+			result[k] = string(b)
+		}
+	}
+	return result
+}
+
+type httpLookup struct {
+	url    string
+	name   string
+	client *http.Client
+}
+
+func (h *httpLookup) lookup(ctx context.Context, key string) (map[string]string, bool, error) {
+	u := strings.Replace(h.url, "{key}", url.PathEscape(key), -1)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxLookupBody))
+	if err != nil {
+		return nil, false, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, false, eerrors.Errorf("HTTP status %d", resp.StatusCode)
+	case len(body) == 0:
+		return nil, false, nil
+	}
+	return toProperties(h.name, body), true, nil
+}
+
+func (h *httpLookup) close() {}
+
+type redisLookup struct {
+	prefix string
+	name   string
+	client *redis.Client
+}
+
+func (r *redisLookup) lookup(ctx context.Context, key string) (map[string]string, bool, error) {
+	client := r.client.WithContext(ctx)
+	key = r.prefix + key
+	value, err := client.Get(key).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		fields, err := client.HGetAll(key).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		return fields, len(fields) > 0, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return toProperties(r.name, []byte(value)), true, nil
+}
+
+func (r *redisLookup) close() {
+	_ = r.client.Close()
+}
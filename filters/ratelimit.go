@@ -0,0 +1,96 @@
+package filters
+
+import (
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+)
+
+// idle keys are forgotten after keyIdleTimeout
+const keyIdleTimeout = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket for each key.
+type rateLimiter struct {
+	key       *template.Template
+	rate      float64
+	burst     float64
+	tag       bool
+	logger    log15.Logger
+	buckets   map[string]*bucket
+	lastPurge time.Time
+	sync.Mutex
+}
+
+func newRateLimiter(c conf.RateLimitRuleConfig, logger log15.Logger) (*rateLimiter, error) {
+	key, err := parseTemplate("rate limit key", c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimiter{
+		key:       key,
+		rate:      c.MessagesPerSec,
+		burst:     c.Burst,
+		tag:       c.Action == conf.FilterTag,
+		logger:    logger,
+		buckets:   make(map[string]*bucket),
+		lastPurge: time.Now(),
+	}, nil
+}
+
+func (l *rateLimiter) Apply(m *model.FullMessage) Result {
+	key, err := execTemplate(l.key, m)
+	if err != nil {
+		l.logger.Info("Error calculating the rate limit key", "error", err, "uid", m.Uid)
+		return PASS
+	}
+	if l.allow(key) {
+		return PASS
+	}
+	if l.tag {
+		m.Fields.SetProperty("skewer", "ratelimited", "true")
+		rateLimitedCounter.WithLabelValues(conf.FilterTag, client(m)).Inc()
+		return PASS
+	}
+	rateLimitedCounter.WithLabelValues(conf.FilterDrop, client(m)).Inc()
+	return DROPPED
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastPurge) > keyIdleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > keyIdleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPurge = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,189 @@
+package filters
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/awnumar/memguard"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// field gets and sets a field of the messages.
+type field struct {
+	get func(m *model.SyslogMessage) string
+	set func(m *model.SyslogMessage, v string)
+}
+
+var messageFields = map[string]field{
+	"message": {
+		get: func(m *model.SyslogMessage) string { return m.Message },
+		set: func(m *model.SyslogMessage, v string) { m.Message = v },
+	},
+	"hostname": {
+		get: func(m *model.SyslogMessage) string { return m.HostName },
+		set: func(m *model.SyslogMessage, v string) { m.HostName = v },
+	},
+	"appname": {
+		get: func(m *model.SyslogMessage) string { return m.AppName },
+		set: func(m *model.SyslogMessage, v string) { m.AppName = v },
+	},
+	"procid": {
+		get: func(m *model.SyslogMessage) string { return m.ProcId },
+		set: func(m *model.SyslogMessage, v string) { m.ProcId = v },
+	},
+	"msgid": {
+		get: func(m *model.SyslogMessage) string { return m.MsgId },
+		set: func(m *model.SyslogMessage, v string) { m.MsgId = v },
+	},
+	"structured": {
+		get: func(m *model.SyslogMessage) string { return m.Structured },
+		set: func(m *model.SyslogMessage, v string) { m.Structured = v },
+	},
+}
+
+// getField returns the accessors of a message field, or of a property when
+// the name is like domain:key.
+func getField(name string) (field, error) {
+	if f, ok := messageFields[strings.ToLower(name)]; ok {
+		return f, nil
+	}
+	colon := strings.Index(name, ":")
+	if colon <= 0 || colon == len(name)-1 {
+		return field{}, eerrors.Errorf("Unknown field: '%s'", name)
+	}
+	domain, key := name[:colon], name[colon+1:]
+	return field{
+		get: func(m *model.SyslogMessage) string { return m.GetProperty(domain, key) },
+		set: func(m *model.SyslogMessage, v string) {
+			if len(m.GetProperty(domain, key)) > 0 {
+				m.SetProperty(domain, key, v)
+			}
+		},
+	}, nil
+}
+
+// fullGetter returns a function that reads a field of the messages, or the
+// client address.
+func fullGetter(name string) (func(m *model.FullMessage) string, error) {
+	if name == "client" {
+		return client, nil
+	}
+	f, err := getField(name)
+	if err != nil {
+		return nil, err
+	}
+	return func(m *model.FullMessage) string { return f.get(m.Fields) }, nil
+}
+
+// redactor masks the sensitive data in some fields of the messages.
+type redactor struct {
+	re          *regexp.Regexp
+	fields      []field
+	strategy    string
+	replacement string
+	keep        int
+	key         *memguard.LockedBuffer
+}
+
+// the HMAC keys must have at least minHMACKeyLength bytes
+const minHMACKeyLength = 16
+
+// readKey reads a secret key into protected memory.
+func readKey(path string) (*memguard.LockedBuffer, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Failed to read the key file '%s'", path)
+	}
+	key := bytes.TrimSpace(content)
+	if len(key) < minHMACKeyLength {
+		memguard.WipeBytes(content)
+		return nil, eerrors.Errorf("The key in '%s' is shorter than %d bytes", path, minHMACKeyLength)
+	}
+	// NewImmutableFromBytes wipes key
+	buf, err := memguard.NewImmutableFromBytes(key)
+	memguard.WipeBytes(content)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Failed to protect the key")
+	}
+	return buf, nil
+}
+
+func newRedactor(c conf.RedactionConfig) (*redactor, error) {
+	r := &redactor{
+		strategy:    c.Strategy,
+		replacement: c.Replacement,
+		keep:        c.Keep,
+	}
+	if len(c.Pattern) > 0 {
+		re, err := regexp.Compile(c.Regexp())
+		if err != nil {
+			return nil, eerrors.Wrap(err, "Invalid regular expression in redaction")
+		}
+		r.re = re
+	}
+	for _, name := range c.Fields {
+		f, err := getField(name)
+		if err != nil {
+			return nil, err
+		}
+		r.fields = append(r.fields, f)
+	}
+	if r.strategy == conf.RedactHMAC {
+		key, err := readKey(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		r.key = key
+	}
+	return r, nil
+}
+
+func (r *redactor) close() {
+	if r.key != nil {
+		r.key.Destroy()
+	}
+}
+
+func (r *redactor) Apply(m *model.FullMessage) Result {
+	for _, f := range r.fields {
+		v := f.get(m.Fields)
+		if len(v) == 0 {
+			continue
+		}
+		if r.re == nil {
+			f.set(m.Fields, r.mask(v))
+		} else {
+			f.set(m.Fields, r.re.ReplaceAllStringFunc(v, r.mask))
+		}
+	}
+	return PASS
+}
+
+func (r *redactor) mask(s string) string {
+	switch r.strategy {
+	case conf.RedactHash:
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	case conf.RedactHMAC:
+		mac := hmac.New(sha256.New, r.key.Buffer())
+		_, _ = mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	case conf.RedactPartial:
+		n := utf8.RuneCountInString(s) - r.keep
+		if n <= 0 {
+			return s
+		}
+		runes := []rune(s)
+		return strings.Repeat("*", n) + string(runes[n:])
+	default:
+		return r.replacement
+	}
+}
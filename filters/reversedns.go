@@ -0,0 +1,93 @@
+package filters
+
+import (
+	"context"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+)
+
+// dnsResolver resolves IP addresses to host names, through a cache shared by
+// the sources.
+type dnsResolver struct {
+	cache       *ttlCache
+	ttl         time.Duration
+	negativeTTL time.Duration
+	timeout     time.Duration
+	logger      log15.Logger
+}
+
+func newDNSResolver(c conf.DNSCacheConfig, logger log15.Logger) *dnsResolver {
+	r := &dnsResolver{
+		ttl:         c.TTL,
+		negativeTTL: c.NegativeTTL,
+		timeout:     c.Timeout,
+		logger:      logger,
+	}
+	if r.timeout <= 0 {
+		r.timeout = 2 * time.Second
+	}
+	r.cache = newTTLCache(c.Size)
+	return r
+}
+
+// resolve returns the host name of the IP address, or an empty string.
+func (r *dnsResolver) resolve(ip string) string {
+	if name, found, ok := r.cache.get(ip); ok {
+		if found {
+			return name
+		}
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	cancel()
+	if err != nil || len(names) == 0 {
+		if err != nil {
+			r.logger.Debug("Reverse DNS lookup failed", "ip", ip, "error", err)
+		}
+		r.cache.put(ip, "", false, r.negativeTTL)
+		return ""
+	}
+	name := strings.TrimSuffix(names[0], ".")
+	r.cache.put(ip, name, true, r.ttl)
+	return name
+}
+
+// reverseDNS sets the host name of an IP address of the messages as a
+// property in the reversedns domain.
+type reverseDNS struct {
+	key      string
+	ip       *template.Template
+	resolver *dnsResolver
+	logger   log15.Logger
+}
+
+func newReverseDNS(c conf.ReverseDNSConfig, resolver *dnsResolver, logger log15.Logger) (*reverseDNS, error) {
+	ip, err := parseTemplate("reverse DNS IP", c.IP)
+	if err != nil {
+		return nil, err
+	}
+	return &reverseDNS{key: c.Key, ip: ip, resolver: resolver, logger: logger}, nil
+}
+
+func (r *reverseDNS) Apply(m *model.FullMessage) Result {
+	value, err := execTemplate(r.ip, m)
+	if err != nil {
+		r.logger.Info("Error calculating the IP to resolve", "error", err, "uid", m.Uid)
+		return PASS
+	}
+	ip := parseIP(strings.TrimSpace(value))
+	if ip == nil {
+		return PASS
+	}
+	if name := r.resolver.resolve(ip.String()); len(name) > 0 {
+		m.Fields.SetProperty("reversedns", r.key, name)
+	}
+	return PASS
+}
@@ -0,0 +1,115 @@
+package filters
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// creditEpsilon absorbs the rounding errors of the accumulated credits
+const creditEpsilon = 1e-9
+
+type credit struct {
+	value float64
+	last  time.Time
+}
+
+// sampler keeps a part of the messages, at random, or by key.
+type sampler struct {
+	ratio      float64
+	key        *template.Template
+	severities map[model.Severity]bool
+	logger     log15.Logger
+	credits    map[uint64]*credit
+	lastPurge  time.Time
+	sync.Mutex
+}
+
+func newSampler(c conf.SampleConfig, logger log15.Logger) (*sampler, error) {
+	s := &sampler{
+		ratio:     c.Ratio(),
+		logger:    logger,
+		credits:   make(map[uint64]*credit),
+		lastPurge: time.Now(),
+	}
+	if len(c.Key) > 0 {
+		key, err := parseTemplate("sample key", c.Key)
+		if err != nil {
+			return nil, err
+		}
+		s.key = key
+	}
+	if len(c.Severity) > 0 {
+		s.severities = make(map[model.Severity]bool, len(c.Severity))
+		for _, name := range c.Severity {
+			sev, ok := model.RSeverities[name]
+			if !ok {
+				return nil, eerrors.Errorf("Unknown severity in sample: '%s'", name)
+			}
+			s.severities[sev] = true
+		}
+	}
+	return s, nil
+}
+
+func (s *sampler) Apply(m *model.FullMessage) Result {
+	if s.severities != nil && !s.severities[m.Fields.Severity] {
+		return PASS
+	}
+	var keep bool
+	if s.key == nil {
+		keep = rand.Float64() < s.ratio
+	} else {
+		key, err := execTemplate(s.key, m)
+		if err != nil {
+			s.logger.Info("Error calculating the sample key", "error", err, "uid", m.Uid)
+			return PASS
+		}
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		keep = s.keep(h.Sum64())
+	}
+	if keep {
+		return PASS
+	}
+	sampledCounter.WithLabelValues(client(m)).Inc()
+	return DROPPED
+}
+
+// keep accumulates the ratio for each message of the key, and keeps a message
+// each time the accumulated credit reaches one.
+func (s *sampler) keep(key uint64) bool {
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+
+	if now.Sub(s.lastPurge) > keyIdleTimeout {
+		for k, c := range s.credits {
+			if now.Sub(c.last) > keyIdleTimeout {
+				delete(s.credits, k)
+			}
+		}
+		s.lastPurge = now
+	}
+
+	c, ok := s.credits[key]
+	if !ok {
+		// the first message of a key is always kept
+		c = &credit{value: 1}
+		s.credits[key] = c
+	}
+	c.last = now
+	keep := c.value >= 1-creditEpsilon
+	if keep {
+		c.value--
+	}
+	c.value += s.ratio
+	return keep
+}
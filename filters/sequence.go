@@ -0,0 +1,193 @@
+package filters
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+)
+
+const (
+	// the RELP transaction numbers wrap after relpMaxTxnr
+	relpMaxTxnr = 999999999
+	// at most maxPending missing numbers are remembered for each sequence,
+	// the others are reported as gaps immediately
+	maxPending = 1024
+	// idle sequences are forgotten after sequenceIdleTimeout
+	sequenceIdleTimeout = 10 * time.Minute
+)
+
+type sequence struct {
+	client  string
+	max     int64
+	pending map[int64]time.Time
+	last    time.Time
+}
+
+// sequenceChecker follows the sequence numbers of the messages by connection,
+// Kafka partition or client, and reports the gaps and the regressions.
+//
+// The messages of a connection may be slightly reordered, because they are
+// parsed concurrently: a missing number is only reported as a gap when it
+// has not arrived after some delay.
+type sequenceChecker struct {
+	kind      string
+	domain    string
+	key       string
+	delay     time.Duration
+	logger    log15.Logger
+	sequences map[string]*sequence
+	lastPurge time.Time
+	sync.Mutex
+}
+
+func newSequenceChecker(c *conf.FilterSubConfig, logger log15.Logger) *sequenceChecker {
+	s := &sequenceChecker{
+		kind:      c.SequenceCheck,
+		delay:     c.SequenceDelay,
+		logger:    logger,
+		sequences: make(map[string]*sequence),
+		lastPurge: time.Now(),
+	}
+	if s.kind != conf.SequenceTxnr && s.kind != conf.SequenceKafka {
+		s.domain, s.key = conf.SplitProperty(s.kind)
+	}
+	return s
+}
+
+// number returns the sequence that the message belongs to, and its number in
+// the sequence.
+func (s *sequenceChecker) number(m *model.FullMessage) (string, int64, bool) {
+	switch s.kind {
+	case conf.SequenceTxnr:
+		if m.Txnr <= 0 {
+			return "", 0, false
+		}
+		return m.ConnId.String(), int64(m.Txnr), true
+	case conf.SequenceKafka:
+		topic := m.Fields.GetProperty("kafka", "topic")
+		offset, err := strconv.ParseInt(m.Fields.GetProperty("kafka", "offset"), 10, 64)
+		if len(topic) == 0 || err != nil {
+			return "", 0, false
+		}
+		return topic + "/" + m.Fields.GetProperty("kafka", "partition"), offset, true
+	default:
+		value := m.Fields.GetProperty(s.domain, s.key)
+		if len(value) == 0 {
+			return "", 0, false
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			s.logger.Debug("Invalid sequence number", "value", value, "uid", m.Uid)
+			return "", 0, false
+		}
+		if len(m.ConnId) > 0 {
+			return m.ConnId.String(), n, true
+		}
+		return client(m), n, true
+	}
+}
+
+func (s *sequenceChecker) Apply(m *model.FullMessage) Result {
+	name, n, ok := s.number(m)
+	if !ok {
+		return PASS
+	}
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+
+	if now.Sub(s.lastPurge) > s.delay {
+		for k, seq := range s.sequences {
+			s.expire(k, seq, now)
+			if now.Sub(seq.last) > sequenceIdleTimeout {
+				delete(s.sequences, k)
+			}
+		}
+		s.lastPurge = now
+	}
+
+	seq, ok := s.sequences[name]
+	if !ok {
+		s.sequences[name] = &sequence{
+			client:  client(m),
+			max:     n,
+			pending: make(map[int64]time.Time),
+			last:    now,
+		}
+		return PASS
+	}
+	seq.last = now
+
+	switch {
+	case n == seq.max+1:
+		seq.max = n
+	case n > seq.max:
+		from := seq.max + 1
+		if n-from > maxPending-int64(len(seq.pending)) {
+			// too many missing numbers to wait for them
+			s.gap(name, seq, from, n-1, n-from)
+		} else {
+			for i := from; i < n; i++ {
+				seq.pending[i] = now
+			}
+		}
+		seq.max = n
+	case s.kind == conf.SequenceTxnr && seq.max-n > relpMaxTxnr/2:
+		// the transaction numbers have wrapped
+		s.expire(name, seq, time.Time{})
+		seq.max = n
+	default:
+		if _, late := seq.pending[n]; late {
+			delete(seq.pending, n)
+			return PASS
+		}
+		sequenceRegressionsCounter.WithLabelValues(seq.client).Inc()
+		s.logger.Warn(
+			"Sequence regression",
+			"sequence", name, "client", seq.client,
+			"number", n, "max", seq.max, "uid", m.Uid,
+		)
+		m.Fields.SetProperty("skewer", "sequence_regression", "true")
+	}
+	return PASS
+}
+
+// expire reports the missing numbers that have been waited for longer than
+// the delay, or all of them when now is zero.
+func (s *sequenceChecker) expire(name string, seq *sequence, now time.Time) {
+	if len(seq.pending) == 0 {
+		return
+	}
+	var from, to, missing int64 = -1, -1, 0
+	for n, since := range seq.pending {
+		if !now.IsZero() && now.Sub(since) < s.delay {
+			continue
+		}
+		delete(seq.pending, n)
+		missing++
+		if from == -1 || n < from {
+			from = n
+		}
+		if n > to {
+			to = n
+		}
+	}
+	if from != -1 {
+		s.gap(name, seq, from, to, missing)
+	}
+}
+
+// gap reports that missing numbers between from and to never arrived.
+func (s *sequenceChecker) gap(name string, seq *sequence, from, to, missing int64) {
+	sequenceGapsCounter.WithLabelValues(seq.client).Inc()
+	sequenceMissingCounter.WithLabelValues(seq.client).Add(float64(missing))
+	s.logger.Warn(
+		"Sequence gap",
+		"sequence", name, "client", seq.client,
+		"from", from, "to", to, "missing", missing,
+	)
+}
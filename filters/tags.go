@@ -0,0 +1,25 @@
+package filters
+
+import (
+	"github.com/stephane-martin/skewer/model"
+)
+
+// tagger adds static properties to the messages, in the tags domain.
+type tagger struct {
+	tags map[string]string
+}
+
+func newTagger(tags map[string]string) *tagger {
+	t := &tagger{tags: make(map[string]string, len(tags))}
+	for k, v := range tags {
+		t.tags[k] = v
+	}
+	return t
+}
+
+func (t *tagger) Apply(m *model.FullMessage) Result {
+	for k, v := range t.tags {
+		m.Fields.SetProperty("tags", k, v)
+	}
+	return PASS
+}
@@ -0,0 +1,96 @@
+package filters
+
+import (
+	"strings"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+)
+
+type property struct {
+	domain string
+	key    string
+}
+
+// transformer renames and removes some properties and fields of the
+// messages, or keeps only an allowlist of properties.
+type transformer struct {
+	rename  map[property]property
+	fields  []field
+	remove  []property
+	keep    map[property]bool
+	domains map[string]bool
+}
+
+func newTransformer(c conf.TransformConfig) (*transformer, error) {
+	t := &transformer{rename: make(map[property]property, len(c.Rename))}
+	for from, to := range c.Rename {
+		fromDomain, fromKey := conf.SplitProperty(from)
+		toDomain, toKey := conf.SplitProperty(to)
+		t.rename[property{fromDomain, fromKey}] = property{toDomain, toKey}
+	}
+	for _, name := range c.Remove {
+		if conf.TransformFields[strings.ToLower(name)] {
+			f, err := getField(name)
+			if err != nil {
+				return nil, err
+			}
+			t.fields = append(t.fields, f)
+			continue
+		}
+		domain, key := conf.SplitProperty(name)
+		t.remove = append(t.remove, property{domain, key})
+	}
+	if len(c.Keep) > 0 {
+		t.keep = make(map[property]bool, len(c.Keep))
+		t.domains = make(map[string]bool)
+		for _, name := range c.Keep {
+			domain, key := conf.SplitProperty(name)
+			if len(key) == 0 {
+				t.domains[domain] = true
+			} else {
+				t.keep[property{domain, key}] = true
+			}
+		}
+	}
+	return t, nil
+}
+
+func (t *transformer) Apply(m *model.FullMessage) Result {
+	fields := m.Fields
+	for from, to := range t.rename {
+		v := fields.GetProperty(from.domain, from.key)
+		if len(v) == 0 {
+			continue
+		}
+		fields.DeleteProperty(from.domain, from.key)
+		fields.SetProperty(to.domain, to.key, v)
+	}
+	for _, f := range t.fields {
+		f.set(fields, "")
+	}
+	for _, p := range t.remove {
+		if len(p.key) == 0 {
+			fields.DeleteDomain(p.domain)
+		} else {
+			fields.DeleteProperty(p.domain, p.key)
+		}
+	}
+	if t.keep != nil {
+		for domain, inner := range fields.Properties.Map {
+			if t.domains[domain] {
+				continue
+			}
+			if inner == nil {
+				fields.DeleteDomain(domain)
+				continue
+			}
+			for key := range inner.Map {
+				if !t.keep[property{domain, key}] {
+					fields.DeleteProperty(domain, key)
+				}
+			}
+		}
+	}
+	return PASS
+}
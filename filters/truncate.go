@@ -0,0 +1,50 @@
+package filters
+
+import (
+	"unicode/utf8"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+)
+
+// truncater limits the length of the message field.
+type truncater struct {
+	max      int
+	strategy string
+	marker   string
+}
+
+func newTruncater(c *conf.FilterSubConfig) *truncater {
+	return &truncater{
+		max:      c.MaxMessageLength,
+		strategy: c.TruncateStrategy,
+		marker:   c.TruncateMarker,
+	}
+}
+
+func (t *truncater) Apply(m *model.FullMessage) Result {
+	if len(m.Fields.Message) <= t.max {
+		return PASS
+	}
+	truncatedCounter.WithLabelValues(t.strategy, client(m)).Inc()
+	switch t.strategy {
+	case conf.TruncateDrop:
+		return DROPPED
+	case conf.TruncateMarker:
+		m.Fields.Message = cut(m.Fields.Message, t.max-len(t.marker)) + t.marker
+	default:
+		m.Fields.Message = cut(m.Fields.Message, t.max)
+	}
+	return PASS
+}
+
+// cut returns the first n bytes of s, without splitting a UTF-8 character.
+func cut(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
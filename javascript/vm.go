@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"sync"
 	"text/template"
 	"unicode/utf8"
 
 	"github.com/dop251/goja"
 	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/expr"
+	"github.com/stephane-martin/skewer/utils/goplugin"
+	"github.com/stephane-martin/skewer/utils/templates"
+	"github.com/stephane-martin/skewer/utils/wasmplugin"
 )
 
 var jsSyslogMessage string = `function SyslogMessage(p, f, s, v, timer, timeg, host, app, proc, msgid, structured, msg, props) {
@@ -52,11 +58,50 @@ var FILTER = {
 
 type FilterResult int64
 
+// programs caches the compiled JS functions, so that the environments of the
+// sources that share the same functions don't compile them again.
+var programs = make(map[string]*goja.Program)
+var programsLock sync.Mutex
+
+func compile(src string) (*goja.Program, error) {
+	programsLock.Lock()
+	defer programsLock.Unlock()
+	if p, ok := programs[src]; ok {
+		return p, nil
+	}
+	p, err := goja.Compile("", src, false)
+	if err != nil {
+		return nil, err
+	}
+	programs[src] = p
+	return p, nil
+}
+
+// run runs the JS source in the environment runtime.
+func (e *Environment) run(src string) error {
+	p, err := compile(src)
+	if err != nil {
+		return err
+	}
+	_, err = e.runtime.RunProgram(p)
+	return err
+}
+
+// pluginPrefix marks the filter functions that are loaded from a Go plugin,
+// like "plugin:/path/to/filter.so".
+const pluginPrefix = "plugin:"
+
+// wasmPrefix marks the filter functions that are loaded from a WASM module,
+// like "wasm:/path/to/filter.wasm".
+const wasmPrefix = "wasm:"
+
 const (
 	PASS         FilterResult = 0
 	DROPPED      FilterResult = 1
 	REJECTED     FilterResult = 2
 	FILTER_ERROR FilterResult = 3
+	// ROUTED means that the filter sent the message to other destinations.
+	ROUTED FilterResult = 4
 )
 
 type iSyslogMessage struct {
@@ -106,8 +151,22 @@ type Environment struct {
 	jsPartitionKey      goja.Callable
 	jsPartitionNumber   goja.Callable
 	jsParsers           map[string]goja.Callable
+	pluginFilter        goplugin.FilterFunc
 	topicTmpl           *template.Template
 	partitionKeyTmpl    *template.Template
+	filterExpr          *expr.Expr
+	topicRules          []topicRule
+	destinationRules    []destinationRule
+}
+
+type topicRule struct {
+	when  *expr.Expr
+	topic *template.Template
+}
+
+type destinationRule struct {
+	when  *expr.Expr
+	dests conf.DestinationType
 }
 
 type ConcreteParser struct {
@@ -153,13 +212,13 @@ func newEnv(filterFunc, topicFunc, topicTmpl, partitionKeyFunc, partitionKeyTmpl
 	e.logger = logger.New("class", "Environment")
 
 	if len(topicTmpl) > 0 {
-		t, err := template.New("topic").Parse(topicTmpl)
+		t, err := templates.New("topic").Parse(topicTmpl)
 		if err == nil {
 			e.topicTmpl = t
 		}
 	}
 	if len(partitionKeyTmpl) > 0 {
-		t, err := template.New("pkey").Parse(partitionKeyTmpl)
+		t, err := templates.New("pkey").Parse(partitionKeyTmpl)
 		if err == nil {
 			e.partitionKeyTmpl = t
 		}
@@ -191,7 +250,23 @@ func newEnv(filterFunc, topicFunc, topicTmpl, partitionKeyFunc, partitionKeyTmpl
 			e.logger.Warn("Error setting the JS PartitionKey() func", "error", err)
 		}
 	}
-	if len(filterFunc) > 0 {
+	if strings.HasPrefix(filterFunc, pluginPrefix) {
+		// the filter is the Filter function of a Go plugin
+		f, err := goplugin.Filter(strings.TrimPrefix(filterFunc, pluginPrefix))
+		if err != nil {
+			e.logger.Warn("Error loading the Filter() func from Go plugin", "error", err)
+		} else {
+			e.pluginFilter = f
+		}
+	} else if strings.HasPrefix(filterFunc, wasmPrefix) {
+		// the filter is the filter function of a WASM module
+		f, err := wasmplugin.Filter(strings.TrimPrefix(filterFunc, wasmPrefix))
+		if err != nil {
+			e.logger.Warn("Error loading the filter func from WASM module", "error", err)
+		} else {
+			e.pluginFilter = f
+		}
+	} else if len(filterFunc) > 0 {
 		err := e.setFilterMessagesFunc(filterFunc)
 		if err != nil {
 			e.logger.Warn("Error setting the JS Filter() func", "error", err)
@@ -242,7 +317,7 @@ func (e *Environment) AddParser(name string, parserFunc string) error {
 }
 
 func (e *Environment) setTopicFunc(f string) error {
-	_, err := e.runtime.RunString(f)
+	err := e.run(f)
 	if err != nil {
 		return err
 	}
@@ -259,7 +334,7 @@ func (e *Environment) setTopicFunc(f string) error {
 }
 
 func (e *Environment) setPartitionKeyFunc(f string) error {
-	_, err := e.runtime.RunString(f)
+	err := e.run(f)
 	if err != nil {
 		return err
 	}
@@ -276,7 +351,7 @@ func (e *Environment) setPartitionKeyFunc(f string) error {
 }
 
 func (e *Environment) setPartitionNumberFunc(f string) error {
-	_, err := e.runtime.RunString(f)
+	err := e.run(f)
 	if err != nil {
 		return err
 	}
@@ -293,7 +368,7 @@ func (e *Environment) setPartitionNumberFunc(f string) error {
 }
 
 func (e *Environment) setFilterMessagesFunc(f string) error {
-	_, err := e.runtime.RunString(f)
+	err := e.run(f)
 	if err != nil {
 		return err
 	}
@@ -309,10 +384,73 @@ func (e *Environment) setFilterMessagesFunc(f string) error {
 	return nil
 }
 
+// SetExpressions sets the filter expression, the topic rules and the
+// destination rules of the environment.
+func (e *Environment) SetExpressions(c *conf.FilterSubConfig) (err error) {
+	if len(c.FilterExpr) > 0 {
+		e.filterExpr, err = compileExpr(c.FilterExpr)
+		if err != nil {
+			return err
+		}
+	}
+	e.topicRules = make([]topicRule, 0, len(c.TopicRules))
+	for _, rule := range c.TopicRules {
+		var r topicRule
+		r.when, err = compileExpr(rule.When)
+		if err != nil {
+			return err
+		}
+		r.topic, err = templates.New("topic").Parse(rule.Topic)
+		if err != nil {
+			return err
+		}
+		e.topicRules = append(e.topicRules, r)
+	}
+	e.destinationRules = make([]destinationRule, 0, len(c.DestinationRules))
+	for _, rule := range c.DestinationRules {
+		var r destinationRule
+		r.when, err = compileExpr(rule.When)
+		if err != nil {
+			return err
+		}
+		r.dests, err = rule.GetDestinations()
+		if err != nil {
+			return err
+		}
+		e.destinationRules = append(e.destinationRules, r)
+	}
+	return nil
+}
+
+func compileExpr(src string) (*expr.Expr, error) {
+	x, err := expr.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range x.Idents() {
+		if !model.ValidLookup(name) {
+			return nil, eerrors.Errorf("Unknown identifier '%s' in expression: %s", name, src)
+		}
+	}
+	return x, nil
+}
+
 func (e *Environment) Topic(m *model.SyslogMessage) (topic string, err error) {
 	errs := make([]error, 0)
 
-	if e.jsTopic != nil {
+	for _, rule := range e.topicRules {
+		if rule.when.Match(m.Lookup) {
+			topicBuf := bytes.Buffer{}
+			err = rule.topic.Execute(&topicBuf, m)
+			if err == nil {
+				topic = topicBuf.String()
+			} else {
+				errs = append(errs, err)
+			}
+			break
+		}
+	}
+	if len(topic) == 0 && e.jsTopic != nil {
 		var jsMessage goja.Value
 		var jsTopic goja.Value
 		jsMessage, err = e.toJsMessage(m)
@@ -396,49 +534,137 @@ func (e *Environment) PartitionNumber(m *model.SyslogMessage) (partitionNumber i
 	return partitionNumber, eerrors.Combine(errs...)
 }
 
+// FilterMessage runs the filter function on the message. The filter may
+// modify the message.
 func (e *Environment) FilterMessage(m *model.SyslogMessage) (filterResult FilterResult, err error) {
+	filterResult, _, err = e.filterMessage(m)
+	return filterResult, err
+}
+
+// FilterMessageTo runs the filter function on the message that is about to be
+// sent to dest. The JS filter function can route the message by returning the
+// name of a destination, or an array of names: the result is ROUTED when dest
+// is not one of them. Otherwise the first matching destination rule selects
+// the destinations.
+func (e *Environment) FilterMessageTo(m *model.SyslogMessage, dest conf.DestinationType) (filterResult FilterResult, err error) {
+	filterResult, dests, err := e.filterMessage(m)
+	if filterResult == PASS && dests == 0 {
+		for _, rule := range e.destinationRules {
+			if rule.when.Match(m.Lookup) {
+				dests = rule.dests
+				break
+			}
+		}
+	}
+	if filterResult == PASS && dests != 0 && !dests.Has(dest) {
+		return ROUTED, nil
+	}
+	return filterResult, err
+}
+
+func (e *Environment) filterMessage(m *model.SyslogMessage) (filterResult FilterResult, dests conf.DestinationType, err error) {
 	var jsMessage goja.Value
 	var resJsMessage goja.Value
 	var result *model.SyslogMessage
 
+	if m != nil && e.filterExpr != nil && !e.filterExpr.Match(m.Lookup) {
+		return DROPPED, 0, nil
+	}
+	if e.pluginFilter != nil {
+		if m == nil {
+			return DROPPED, 0, nil
+		}
+		filterResult, err = e.filterWithPlugin(m)
+		return filterResult, 0, err
+	}
 	if e.jsFilterMessages == nil {
-		return PASS, nil
+		return PASS, 0, nil
 	}
 	if m == nil {
-		return DROPPED, nil
+		return DROPPED, 0, nil
 	}
 	jsMessage, err = e.toJsMessage(m)
 	if err != nil {
-		return FILTER_ERROR, go2jsError(executingJSErrorFactory(err, "NewSyslogMessage"))
+		return FILTER_ERROR, 0, go2jsError(executingJSErrorFactory(err, "NewSyslogMessage"))
 	}
 	resJsMessage, err = e.jsFilterMessages(nil, jsMessage)
 	if err != nil {
-		return FILTER_ERROR, executingJSErrorFactory(err, "FilterMessages")
+		return FILTER_ERROR, 0, executingJSErrorFactory(err, "FilterMessages")
 	}
 
-	filterResult = FilterResult(resJsMessage.ToInteger())
+	switch exported := resJsMessage.Export().(type) {
+	case string, []interface{}:
+		// the filter returned destinations: the message passes
+		dests, err = jsDestinations(exported)
+		if err != nil {
+			return FILTER_ERROR, 0, jsvmError(err)
+		}
+		filterResult = PASS
+	default:
+		filterResult = FilterResult(resJsMessage.ToInteger())
+	}
 	switch filterResult {
 	case DROPPED:
-		return DROPPED, nil
+		return DROPPED, 0, nil
 	case REJECTED:
-		return REJECTED, nil
+		return REJECTED, 0, nil
 	case FILTER_ERROR:
-		return FILTER_ERROR, nil
+		return FILTER_ERROR, 0, nil
 	case PASS:
 		result, err = e.fromJsMessage(jsMessage)
 		if err != nil {
-			return FILTER_ERROR, js2goError(err)
+			return FILTER_ERROR, 0, js2goError(err)
 		}
 		if result != nil {
 			*m = *result
 			model.Free(result)
 		}
-		return PASS, nil
+		return PASS, dests, nil
 
 	default:
-		return FILTER_ERROR, jsvmError(eerrors.Errorf("JS filter function returned an invalid result: %d", int64(filterResult)))
+		return FILTER_ERROR, 0, jsvmError(eerrors.Errorf("JS filter function returned an invalid result: %d", int64(filterResult)))
+	}
+
+}
+
+// jsDestinations converts the destinations returned by a JS filter function.
+func jsDestinations(exported interface{}) (dests conf.DestinationType, err error) {
+	var names []interface{}
+	switch v := exported.(type) {
+	case string:
+		names = append(names, v)
+	case []interface{}:
+		names = v
+	}
+	for _, name := range names {
+		n, ok := name.(string)
+		if !ok {
+			return 0, eerrors.Errorf("JS filter function returned an invalid destination: %v", name)
+		}
+		d, ok := conf.Destinations[strings.TrimSpace(strings.ToLower(n))]
+		if !ok {
+			return 0, eerrors.Errorf("JS filter function returned an unknown destination: '%s'", n)
+		}
+		dests |= d
 	}
+	if dests == 0 {
+		return 0, eerrors.New("JS filter function returned an empty list of destinations")
+	}
+	return dests, nil
+}
 
+func (e *Environment) filterWithPlugin(m *model.SyslogMessage) (FilterResult, error) {
+	res, err := e.pluginFilter(m)
+	if err != nil {
+		return FILTER_ERROR, eerrors.Wrap(err, "Error executing the plugin filter")
+	}
+	filterResult := FilterResult(res)
+	switch filterResult {
+	case PASS, DROPPED, REJECTED, FILTER_ERROR:
+		return filterResult, nil
+	default:
+		return FILTER_ERROR, eerrors.Errorf("Plugin filter returned an invalid result: %d", res)
+	}
 }
 
 func (e *Environment) toJsMessage(m *model.SyslogMessage) (sm goja.Value, err error) {
@@ -0,0 +1,67 @@
+package journald
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+)
+
+// EntryToSyslog converts a journal entry to a syslog message. It does not
+// depend on libsystemd, so that entries read from other sources (eg.
+// systemd-journal-gatewayd) can be converted too.
+func EntryToSyslog(entry map[string]string) *model.SyslogMessage {
+	m := model.Factory()
+	properties := map[string]string{}
+	for k, v := range entry {
+		k = strings.ToLower(k)
+		switch k {
+		case "syslog_identifier":
+		case "_comm":
+			m.AppName = v
+		case "message":
+			m.Message = v
+		case "syslog_pid":
+		case "_pid":
+			m.ProcId = v
+		case "priority":
+			p, err := strconv.Atoi(v)
+			if err == nil {
+				m.Severity = model.Severity(p)
+			}
+		case "syslog_facility":
+			f, err := strconv.Atoi(v)
+			if err == nil {
+				m.Facility = model.Facility(f)
+			}
+		case "_hostname":
+			m.HostName = v
+		case "_source_realtime_timestamp": // microseconds
+			t, err := strconv.ParseInt(v, 10, 64)
+			if err == nil {
+				m.TimeReportedNum = t * 1000
+			}
+		default:
+			if strings.HasPrefix(k, "_") {
+				properties[k] = v
+			}
+
+		}
+	}
+	if len(m.AppName) == 0 {
+		m.AppName = entry["SYSLOG_IDENTIFIER"]
+	}
+	if len(m.ProcId) == 0 {
+		m.ProcId = entry["SYSLOG_PID"]
+	}
+	m.TimeGeneratedNum = time.Now().UnixNano()
+	if m.TimeReportedNum == 0 {
+		m.TimeReportedNum = m.TimeGeneratedNum
+	}
+	m.Priority = model.Priority(int(m.Facility)*8 + int(m.Severity))
+	m.ClearDomain("journald")
+	m.Properties.Map["journald"].Map = properties
+	m.SetProperty("skewer", "client", m.HostName)
+	return m
+}
@@ -5,8 +5,6 @@ package journald
 import (
 	"context"
 	"os"
-	"strconv"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -33,61 +31,6 @@ type Reader struct {
 
 type Converter func(*sdjournal.JournalEntry) *model.FullMessage
 
-func EntryToSyslog(entry map[string]string) *model.SyslogMessage {
-	m := model.Factory()
-	properties := map[string]string{}
-	for k, v := range entry {
-		k = strings.ToLower(k)
-		switch k {
-		case "syslog_identifier":
-		case "_comm":
-			m.AppName = v
-		case "message":
-			m.Message = v
-		case "syslog_pid":
-		case "_pid":
-			m.ProcId = v
-		case "priority":
-			p, err := strconv.Atoi(v)
-			if err == nil {
-				m.Severity = model.Severity(p)
-			}
-		case "syslog_facility":
-			f, err := strconv.Atoi(v)
-			if err == nil {
-				m.Facility = model.Facility(f)
-			}
-		case "_hostname":
-			m.HostName = v
-		case "_source_realtime_timestamp": // microseconds
-			t, err := strconv.ParseInt(v, 10, 64)
-			if err == nil {
-				m.TimeReportedNum = t * 1000
-			}
-		default:
-			if strings.HasPrefix(k, "_") {
-				properties[k] = v
-			}
-
-		}
-	}
-	if len(m.AppName) == 0 {
-		m.AppName = entry["SYSLOG_IDENTIFIER"]
-	}
-	if len(m.ProcId) == 0 {
-		m.ProcId = entry["SYSLOG_PID"]
-	}
-	m.TimeGeneratedNum = time.Now().UnixNano()
-	if m.TimeReportedNum == 0 {
-		m.TimeReportedNum = m.TimeGeneratedNum
-	}
-	m.Priority = model.Priority(int(m.Facility)*8 + int(m.Severity))
-	m.ClearDomain("journald")
-	m.Properties.Map["journald"].Map = properties
-	m.SetProperty("skewer", "client", m.HostName)
-	return m
-}
-
 func makeMapConverter(coding string, confID utils.MyULID) Converter {
 	decoder := utils.SelectDecoder(coding)
 	generator := utils.NewGenerator()
@@ -297,10 +297,11 @@ func execServeParent() error {
 	}
 	extraFiles = append(extraFiles, rDeadManPipe)
 
+	// the stdin source, in a plugin of the child, reads our standard input
 	childProcess := exec.Cmd{
 		Args:       append([]string{"skewer-child"}, os.Args[1:]...),
 		Path:       exe,
-		Stdin:      nil,
+		Stdin:      os.Stdin,
 		Stdout:     os.Stdout,
 		Stderr:     os.Stderr,
 		ExtraFiles: extraFiles,
@@ -514,7 +515,12 @@ func runUnconfined(t base.Types) error {
 		base.MacOS,
 		base.KafkaSource,
 		base.Filesystem,
-		base.HTTPServer:
+		base.HTTPServer,
+		base.Kubernetes,
+		base.Exec,
+		base.PubSub,
+		base.JournalGateway,
+		base.Stdin:
 
 		if t == base.Store {
 			runtime.GOMAXPROCS(128)
@@ -525,11 +531,13 @@ func runUnconfined(t base.Types) error {
 		var binderClient binder.Client
 		logger := log15.New()
 		var pipe *os.File
+		var input *os.File
 		var err error
 		var handle uintptr = 3
 		var binderHdl uintptr
 		var loggerHdl uintptr
 		var pipeHdl uintptr
+		var inputHdl uintptr
 		var ringSecretHdl uintptr
 		var ringSecret *memguard.LockedBuffer
 
@@ -548,6 +556,11 @@ func runUnconfined(t base.Types) error {
 			handle++
 		}
 
+		if os.Getenv("SKEWER_HAS_INPUT") == "TRUE" {
+			inputHdl = handle
+			handle++
+		}
+
 		ringSecretHdl = handle
 		rPipe := os.NewFile(ringSecretHdl, "ringsecretpipe")
 		buf := make([]byte, 32)
@@ -583,6 +596,9 @@ func runUnconfined(t base.Types) error {
 		if pipeHdl > 0 {
 			pipe = os.NewFile(pipeHdl, "pipe")
 		}
+		if inputHdl > 0 {
+			input = os.NewFile(inputHdl, "input")
+		}
 
 		err = scomp.SetupSeccomp(t)
 		if err != nil {
@@ -601,6 +617,7 @@ func runUnconfined(t base.Types) error {
 			services.SetBinder(binderClient),
 			services.SetLogger(logger),
 			services.SetPipe(pipe),
+			services.SetInput(input),
 		)
 		if err != nil {
 			return fatalError("Plugin encountered a fatal error", err)
@@ -655,7 +672,12 @@ func runConfined(t base.Types) error {
 		base.Configuration,
 		base.KafkaSource,
 		base.Filesystem,
-		base.HTTPServer:
+		base.HTTPServer,
+		base.Kubernetes,
+		base.Exec,
+		base.PubSub,
+		base.JournalGateway,
+		base.Stdin:
 
 		path, err := osext.Executable()
 		if err != nil {
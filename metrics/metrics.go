@@ -15,7 +15,17 @@ import (
 )
 
 type MetricsServer struct {
-	server *http.Server
+	server   *http.Server
+	handlers map[string]http.Handler
+}
+
+// Handle registers an additional handler, served on the metrics port from
+// the next call to NewConf.
+func (m *MetricsServer) Handle(pattern string, handler http.Handler) {
+	if m.handlers == nil {
+		m.handlers = make(map[string]http.Handler)
+	}
+	m.handlers[pattern] = handler
 }
 
 func (m *MetricsServer) Stop() {
@@ -55,6 +65,9 @@ func (m *MetricsServer) NewConf(c conf.MetricsConfig, logger log15.Logger, gathe
 				},
 			),
 		)
+		for pattern, handler := range m.handlers {
+			mux.Handle(pattern, handler)
+		}
 		m.server = &http.Server{
 			Addr:    fmt.Sprintf("127.0.0.1:%d", c.Port),
 			Handler: mux,
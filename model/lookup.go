@@ -0,0 +1,50 @@
+package model
+
+import "strings"
+
+// lookupFields are the names of the message fields that the expressions can
+// use.
+var lookupFields = map[string]func(m *SyslogMessage) interface{}{
+	"severity":   func(m *SyslogMessage) interface{} { return int(m.Severity) },
+	"facility":   func(m *SyslogMessage) interface{} { return int(m.Facility) },
+	"priority":   func(m *SyslogMessage) interface{} { return int(m.Priority) },
+	"version":    func(m *SyslogMessage) interface{} { return int(m.Version) },
+	"hostname":   func(m *SyslogMessage) interface{} { return m.HostName },
+	"appname":    func(m *SyslogMessage) interface{} { return m.AppName },
+	"procid":     func(m *SyslogMessage) interface{} { return m.ProcId },
+	"msgid":      func(m *SyslogMessage) interface{} { return m.MsgId },
+	"structured": func(m *SyslogMessage) interface{} { return m.Structured },
+	"message":    func(m *SyslogMessage) interface{} { return m.Message },
+	"client":     func(m *SyslogMessage) interface{} { return m.GetProperty("skewer", "client") },
+}
+
+// Lookup returns the value of a message field, of a property when the name
+// is like domain:key, or the number of a severity or facility name.
+func (m *SyslogMessage) Lookup(name string) interface{} {
+	if f, ok := lookupFields[name]; ok {
+		return f(m)
+	}
+	if colon := strings.Index(name, ":"); colon > 0 {
+		return m.GetProperty(name[:colon], name[colon+1:])
+	}
+	if s, ok := RSeverities[name]; ok {
+		return int(s)
+	}
+	if f, ok := RFacilities[name]; ok {
+		return int(f)
+	}
+	return nil
+}
+
+// ValidLookup returns true if the name can be resolved by Lookup.
+func ValidLookup(name string) bool {
+	if _, ok := lookupFields[name]; ok {
+		return true
+	}
+	if colon := strings.Index(name, ":"); colon > 0 && colon < len(name)-1 {
+		return true
+	}
+	_, sok := RSeverities[name]
+	_, fok := RFacilities[name]
+	return sok || fok
+}
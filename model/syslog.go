@@ -51,6 +51,7 @@ type RawKafkaMessage struct {
 	Topic      string
 	Partition  int32
 	Offset     int64
+	Headers    map[string]string
 }
 
 type RawTCPMessage struct {
@@ -1,6 +1,7 @@
 package model
 
 //go:generate ffjson $GOFILE
+//go:generate protoc --proto_path=.. --proto_path=$GOPATH/src --proto_path=$GOPATH/src/github.com/stephane-martin/skewer/vendor/github.com/gogo/protobuf/protobuf --gogoslick_out=.. model/types.proto
 
 import (
 	"fmt"
@@ -511,6 +512,24 @@ func (m *SyslogMessage) SetProperty(domain, key, value string) {
 	kv.Map[key] = value
 }
 
+func (m *SyslogMessage) DeleteProperty(domain, key string) {
+	if len(m.Properties.Map) == 0 {
+		return
+	}
+	kv := m.Properties.Map[domain]
+	if kv == nil {
+		return
+	}
+	delete(kv.Map, key)
+	if len(kv.Map) == 0 {
+		delete(m.Properties.Map, domain)
+	}
+}
+
+func (m *SyslogMessage) DeleteDomain(domain string) {
+	delete(m.Properties.Map, domain)
+}
+
 func (m *SyslogMessage) SetAllProperties(all map[string](map[string]string)) {
 	m.ClearProperties()
 	for domain, kv := range all {
@@ -520,6 +539,23 @@ func (m *SyslogMessage) SetAllProperties(all map[string](map[string]string)) {
 	}
 }
 
+// Copy returns a copy of the message that does not share its properties.
+func (m *SyslogMessage) Copy() *SyslogMessage {
+	msg := Factory()
+	props := msg.Properties.Map
+	*msg = *m
+	msg.Properties.Map = props
+	for domain, inner := range m.Properties.Map {
+		if inner == nil {
+			continue
+		}
+		for k, v := range inner.Map {
+			msg.SetProperty(domain, k, v)
+		}
+	}
+	return msg
+}
+
 func (m *SyslogMessage) GetAllProperties() (res map[string](map[string]string)) {
 	res = map[string](map[string]string){}
 	if len(m.Properties.Map) == 0 {
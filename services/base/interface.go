@@ -26,6 +26,14 @@ func CountClientConnection(t Types, client string, port int, path string) {
 	ClientConnectionCounter.WithLabelValues(Types2Names[t], client, strconv.FormatInt(int64(port), 10), path).Inc()
 }
 
+func CountThrottledMessage(t Types, client string) {
+	ThrottledMsgsCounter.WithLabelValues(Types2Names[t], client).Inc()
+}
+
+func CountRejectedClient(t Types, client string) {
+	RejectedClientsCounter.WithLabelValues(Types2Names[t], client).Inc()
+}
+
 func CountParsingError(t Types, client string, parserName string) {
 	ParsingErrorCounter.WithLabelValues(Types2Names[t], client, parserName).Inc()
 }
@@ -12,6 +12,8 @@ var Once sync.Once
 var IncomingMsgsCounter *prometheus.CounterVec
 var ClientConnectionCounter *prometheus.CounterVec
 var ParsingErrorCounter *prometheus.CounterVec
+var ThrottledMsgsCounter *prometheus.CounterVec
+var RejectedClientsCounter *prometheus.CounterVec
 
 func InitRegistry() {
 	IncomingMsgsCounter = prometheus.NewCounterVec(
@@ -38,10 +40,28 @@ func InitRegistry() {
 		[]string{"provider", "client", "parsername"},
 	)
 
+	ThrottledMsgsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "skw_throttled_messages_total",
+			Help: "total number of messages that were rejected because the client exceeded its rate limit",
+		},
+		[]string{"provider", "client"},
+	)
+
+	RejectedClientsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "skw_rejected_clients_total",
+			Help: "total number of connections or datagrams rejected by the CIDR allow/deny lists",
+		},
+		[]string{"provider", "client"},
+	)
+
 	Registry = prometheus.NewRegistry()
 	Registry.MustRegister(
 		ClientConnectionCounter,
 		IncomingMsgsCounter,
 		ParsingErrorCounter,
+		ThrottledMsgsCounter,
+		RejectedClientsCounter,
 	)
 }
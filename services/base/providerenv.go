@@ -16,4 +16,6 @@ type ProviderEnv struct {
 	Binder   binder.Client
 	Logger   log15.Logger
 	Pipe     *os.File
+	// Input is the standard input of skewer, for the stdin source
+	Input *os.File
 }
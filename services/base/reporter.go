@@ -32,6 +32,8 @@ type Reporter struct {
 	reserv       *reservoir.Reservoir
 	secret       *memguard.LockedBuffer
 	pipeWriter   *utils.EncryptWriter
+	// pipeMu serializes the writes of pushqueue and StashSync to the pipe
+	pipeMu sync.Mutex
 }
 
 // NewReporter creates a reporter.
@@ -76,14 +78,17 @@ func (s *Reporter) pushqueue() {
 		}
 		w.Reset()
 
+		s.pipeMu.Lock()
 		for _, v := range m {
 			_, err := io.WriteString(s.pipeWriter, v)
 			if err != nil {
+				s.pipeMu.Unlock()
 				s.logger.Crit("Unexpected error when writing messages to the plugin pipe", "error", err)
 				return
 			}
 		}
 		err = s.bufferedPipe.Flush()
+		s.pipeMu.Unlock()
 
 		for k := range m {
 			delete(m, k)
@@ -110,6 +115,30 @@ func (s *Reporter) Stash(m *model.FullMessage) error {
 	return nil
 }
 
+// StashSync reports syslog messages to the controller, and returns only when
+// they have been written to the pipe. Unlike Stash, the messages are not
+// buffered in the reservoir, so that the caller can acknowledge them to the
+// remote side afterwards.
+func (s *Reporter) StashSync(msgs []*model.FullMessage) error {
+	s.pipeMu.Lock()
+	defer s.pipeMu.Unlock()
+	for _, m := range msgs {
+		b, err := m.Marshal()
+		if err != nil {
+			return eerrors.Wrapf(err, "Failed to marshal a message to be sent by plugin: %s", s.name)
+		}
+		_, err = s.pipeWriter.Write(b)
+		if err != nil {
+			return eerrors.Fatal(eerrors.Wrap(err, "Failed to write messages to the plugin pipe"))
+		}
+	}
+	err := s.bufferedPipe.Flush()
+	if err != nil {
+		return eerrors.Fatal(eerrors.Wrap(err, "Failed to flush the plugin pipe"))
+	}
+	return nil
+}
+
 // Report reports information about the actual listening ports to the controller.
 func (s *Reporter) Report(infos []model.ListenerInfo) error {
 	b, err := json.Marshal(infos)
@@ -22,6 +22,11 @@ const (
 	Filesystem
 	HTTPServer
 	MacOS
+	Kubernetes
+	Exec
+	PubSub
+	JournalGateway
+	Stdin
 )
 
 var Names2Types = map[string]Types{
@@ -38,6 +43,11 @@ var Names2Types = map[string]Types{
 	"skewer-files":       Filesystem,
 	"skewer-httpserver":  HTTPServer,
 	"skewer-macos":       MacOS,
+	"skewer-kubernetes":  Kubernetes,
+	"skewer-exec":        Exec,
+	"skewer-pubsub":      PubSub,
+	"skewer-jgateway":    JournalGateway,
+	"skewer-stdin":       Stdin,
 }
 
 var ErrNotFound = eerrors.New("not found")
@@ -116,6 +126,11 @@ func init() {
 		{Types2Names[Filesystem], Logger},
 		{Types2Names[HTTPServer], Logger},
 		{Types2Names[MacOS], Logger},
+		{Types2Names[Kubernetes], Logger},
+		{Types2Names[Exec], Logger},
+		{Types2Names[PubSub], Logger},
+		{Types2Names[JournalGateway], Logger},
+		{Types2Names[Stdin], Logger},
 	}
 
 	HandlesMap = map[ServiceHandle]uintptr{}
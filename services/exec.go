@@ -0,0 +1,242 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/services/base"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+func initExecRegistry() {
+	base.Once.Do(func() {
+		base.InitRegistry()
+	})
+}
+
+// ExecService periodically runs some commands and reports their output.
+type ExecService struct {
+	stasher        *base.Reporter
+	logger         log15.Logger
+	wgroup         sync.WaitGroup
+	Confs          []conf.ExecSourceConfig
+	cancel         context.CancelFunc
+	fatalErrorChan chan struct{}
+	fatalOnce      *sync.Once
+	confined       bool
+	hostname       string
+	sync.Mutex
+}
+
+func NewExecService(env *base.ProviderEnv) (base.Provider, error) {
+	initExecRegistry()
+	s := ExecService{
+		stasher:  env.Reporter,
+		logger:   env.Logger.New("class", "exec"),
+		confined: env.Confined,
+	}
+	s.hostname, _ = os.Hostname()
+	return &s, nil
+}
+
+func (s *ExecService) Type() base.Types {
+	return base.Exec
+}
+
+func (s *ExecService) Gather() ([]*dto.MetricFamily, error) {
+	return base.Registry.Gather()
+}
+
+func (s *ExecService) FatalError() chan struct{} {
+	return s.fatalErrorChan
+}
+
+func (s *ExecService) dofatal() {
+	s.fatalOnce.Do(func() { close(s.fatalErrorChan) })
+}
+
+func (s *ExecService) Start() (infos []model.ListenerInfo, err error) {
+	infos = []model.ListenerInfo{}
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel != nil {
+		return infos, eerrors.New("already started")
+	}
+	s.fatalErrorChan = make(chan struct{})
+	s.fatalOnce = &sync.Once{}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, config := range s.Confs {
+		s.wgroup.Add(1)
+		go s.loop(ctx, config)
+	}
+	return infos, nil
+}
+
+func (s *ExecService) loop(ctx context.Context, config conf.ExecSourceConfig) {
+	defer s.wgroup.Done()
+	logger := s.logger.New("command", config.Command)
+	gen := utils.NewGenerator()
+	for {
+		err := s.run(ctx, config, gen, logger)
+		if eerrors.Is("Fatal", err) {
+			logger.Error("Fatal error stashing message", "error", err)
+			s.dofatal()
+			return
+		}
+		if err != nil {
+			logger.Warn("Error running command", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.Period):
+		}
+	}
+}
+
+func (s *ExecService) run(ctx context.Context, config conf.ExecSourceConfig, gen *utils.Generator, logger log15.Logger) error {
+	cctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cctx, config.Command, config.Args...)
+	cmd.Stdin = nil
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		logStderr(stderr, config.MaxLineSize, logger)
+	}()
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 4096), config.MaxLineSize)
+	for scanner.Scan() {
+		if len(lines) < config.MaxLines {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Warn("Error reading command output", "error", err)
+	}
+	// the command must not block on a full pipe, if we stopped reading early
+	_, _ = io.Copy(ioutil.Discard, stdout)
+	wg.Wait()
+	err = cmd.Wait()
+	duration := time.Since(start)
+
+	exitCode := 0
+	if err != nil {
+		if cctx.Err() == context.DeadlineExceeded {
+			logger.Warn("Command timed out", "timeout", config.Timeout)
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				exitCode = status.ExitStatus()
+			}
+		} else {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		// we are stopping
+		return nil
+	}
+
+	if len(lines) == 0 {
+		// always report the execution, even when the command is silent
+		lines = append(lines, "")
+	}
+	severity := model.Sinfo
+	if exitCode != 0 {
+		severity = model.Serr
+	}
+	for _, line := range lines {
+		full := model.FullFactory()
+		full.Fields.Message = line
+		full.Fields.AppName = config.AppName
+		full.Fields.HostName = s.hostname
+		full.Fields.Facility = model.Fuser
+		full.Fields.Severity = severity
+		full.Fields.SetPriority()
+		full.Fields.TimeGeneratedNum = time.Now().UnixNano()
+		full.Fields.TimeReportedNum = start.UnixNano()
+		full.Fields.Version = 1
+		full.Fields.SetProperty("exec", "command", config.Command)
+		full.Fields.SetProperty("exec", "exit_code", strconv.FormatInt(int64(exitCode), 10))
+		full.Fields.SetProperty("exec", "duration", strconv.FormatFloat(duration.Seconds(), 'f', -1, 64))
+		full.ConfId = config.ConfID
+		full.Uid = gen.Uid()
+		full.SourceType = "exec"
+		full.SourcePath = config.Command
+		err = s.stasher.Stash(full)
+		if eerrors.Is("Fatal", err) {
+			return err
+		}
+		if err != nil {
+			logger.Error("Error stashing message", "error", err)
+			continue
+		}
+		base.CountIncomingMessage(base.Exec, s.hostname, 0, config.Command)
+	}
+	return nil
+}
+
+func logStderr(stderr io.Reader, maxLineSize int, logger log15.Logger) {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for scanner.Scan() {
+		logger.Info(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Warn("Error reading command stderr", "error", err)
+	}
+	_, _ = io.Copy(ioutil.Discard, stderr)
+}
+
+func (s *ExecService) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wgroup.Wait()
+	s.cancel = nil
+	s.logger.Info("exec source has been stopped")
+}
+
+func (s *ExecService) Shutdown() {
+	s.Stop()
+}
+
+func (s *ExecService) SetConf(c conf.BaseConfig) {
+	s.Lock()
+	s.Confs = c.ExecSource
+	s.Unlock()
+}
@@ -59,6 +59,18 @@ func Configure(t base.Types, c conf.BaseConfig) (res conf.BaseConfig) {
 		res.Main.MaxInputMessageSize = c.Main.MaxInputMessageSize
 	case base.MacOS:
 		res.MacOS = c.MacOS
+	case base.Kubernetes:
+		res.Kubernetes = c.Kubernetes
+	case base.Exec:
+		res.ExecSource = c.ExecSource
+	case base.PubSub:
+		res.PubSubSource = c.PubSubSource
+		res.Parsers = c.Parsers
+	case base.JournalGateway:
+		res.JournalGatewaySource = c.JournalGatewaySource
+	case base.Stdin:
+		res.Stdin = c.Stdin
+		res.Parsers = c.Parsers
 	}
 	return res
 }
@@ -124,6 +136,12 @@ func SetPipe(pipe *os.File) func(e *base.ProviderEnv) {
 	}
 }
 
+func SetInput(input *os.File) func(e *base.ProviderEnv) {
+	return func(e *base.ProviderEnv) {
+		e.Input = input
+	}
+}
+
 type ProviderOpt func(e *base.ProviderEnv)
 
 func ProviderFactory(t base.Types, env *base.ProviderEnv) (base.Provider, error) {
@@ -154,6 +172,16 @@ func ProviderFactory(t base.Types, env *base.ProviderEnv) (base.Provider, error)
 		provider, err = network.NewHTTPService(env)
 	case base.MacOS:
 		provider, err = macos.NewMacOSLogsService(env)
+	case base.Kubernetes:
+		provider, err = NewKubernetesService(env)
+	case base.Exec:
+		provider, err = NewExecService(env)
+	case base.PubSub:
+		provider, err = NewPubSubService(env)
+	case base.JournalGateway:
+		provider, err = NewJournalGatewayService(env)
+	case base.Stdin:
+		provider, err = NewStdinService(env)
 	default:
 		return nil, eerrors.Errorf("Unknown provider type: %d", t)
 	}
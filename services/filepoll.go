@@ -194,10 +194,8 @@ func flogg(logger log15.Logger, raw *model.RawFileMessage) log15.Logger {
 }
 
 func (s *FilePollingService) parseOne(raw *model.RawFileMessage, gen *utils.Generator) error {
-	syslogMsgs, err := s.parserEnv.Parse(&raw.Decoder, raw.Line)
-	if err != nil {
-		return err
-	}
+	// the messages decoded before a parsing error are stashed too
+	syslogMsgs, parseErr := s.parserEnv.Parse(&raw.Decoder, raw.Line)
 
 	for _, syslogMsg := range syslogMsgs {
 		if syslogMsg == nil {
@@ -221,7 +219,7 @@ func (s *FilePollingService) parseOne(raw *model.RawFileMessage, gen *utils.Gene
 			}
 		}
 	}
-	return nil
+	return parseErr
 }
 
 func (s *FilePollingService) parse(rawq chan *model.RawFileMessage) error {
@@ -301,6 +299,10 @@ func (s *FilePollingService) SetConf(c conf.BaseConfig) {
 		s.confs[c.FSSource[i].ConfID] = &(c.FSSource[i])
 	}
 	s.confsMap = make(map[ulid.ULID]utils.MyULID)
+	if s.parserEnv != nil {
+		// stop the external parsers of the previous configuration
+		s.parserEnv.Close()
+	}
 	s.parserEnv = decoders.NewParsersEnv(c.Parsers, s.logger)
 }
 
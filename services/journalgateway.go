@@ -0,0 +1,269 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/journald"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/services/base"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+func initJournalGatewayRegistry() {
+	base.Once.Do(func() {
+		base.InitRegistry()
+	})
+}
+
+// JournalGatewayService streams the journal entries of remote hosts from
+// systemd-journal-gatewayd.
+type JournalGatewayService struct {
+	stasher        *base.Reporter
+	logger         log15.Logger
+	wgroup         sync.WaitGroup
+	Confs          []conf.JournalGatewaySourceConfig
+	cancel         context.CancelFunc
+	fatalErrorChan chan struct{}
+	fatalOnce      *sync.Once
+	confined       bool
+	sync.Mutex
+}
+
+func NewJournalGatewayService(env *base.ProviderEnv) (base.Provider, error) {
+	initJournalGatewayRegistry()
+	s := JournalGatewayService{
+		stasher:  env.Reporter,
+		logger:   env.Logger.New("class", "journalgateway"),
+		confined: env.Confined,
+	}
+	return &s, nil
+}
+
+func (s *JournalGatewayService) Type() base.Types {
+	return base.JournalGateway
+}
+
+func (s *JournalGatewayService) Gather() ([]*dto.MetricFamily, error) {
+	return base.Registry.Gather()
+}
+
+func (s *JournalGatewayService) FatalError() chan struct{} {
+	return s.fatalErrorChan
+}
+
+func (s *JournalGatewayService) dofatal() {
+	s.fatalOnce.Do(func() { close(s.fatalErrorChan) })
+}
+
+func (s *JournalGatewayService) Start() (infos []model.ListenerInfo, err error) {
+	infos = []model.ListenerInfo{}
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel != nil {
+		return infos, eerrors.New("already started")
+	}
+	s.fatalErrorChan = make(chan struct{})
+	s.fatalOnce = &sync.Once{}
+
+	clients := make([]*http.Client, 0, len(s.Confs))
+	for _, config := range s.Confs {
+		transport := &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSHandshakeTimeout: 10 * time.Second,
+		}
+		if config.TLSEnabled || strings.HasPrefix(config.URL, "https://") {
+			u, _ := url.Parse(config.URL)
+			tlsConf, err := utils.NewTLSConfig(
+				u.Host, config.CAFile, config.CAPath, config.CertFile, config.KeyFile, config.Insecure, s.confined,
+			)
+			if err != nil {
+				return infos, eerrors.Wrap(err, "Error building the TLS configuration for the journal gateway")
+			}
+			transport.TLSClientConfig = tlsConf
+		}
+		clients = append(clients, &http.Client{Transport: transport})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for i, config := range s.Confs {
+		s.wgroup.Add(1)
+		go s.follow(ctx, config, clients[i])
+	}
+	return infos, nil
+}
+
+func (s *JournalGatewayService) follow(ctx context.Context, config conf.JournalGatewaySourceConfig, client *http.Client) {
+	defer s.wgroup.Done()
+	logger := s.logger.New("url", config.URL)
+	gen := utils.NewGenerator()
+	// cursor of the last received entry, to resume after a disconnection
+	var cursor string
+	for {
+		err := s.stream(ctx, config, client, &cursor, gen)
+		if ctx.Err() != nil {
+			return
+		}
+		if eerrors.Is("Fatal", err) {
+			logger.Error("Fatal error stashing message", "error", err)
+			s.dofatal()
+			return
+		}
+		if err != nil {
+			logger.Warn("Journal gateway stream was interrupted", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.ReconnectPeriod):
+		}
+	}
+}
+
+func (s *JournalGatewayService) stream(ctx context.Context, config conf.JournalGatewaySourceConfig, client *http.Client, cursor *string, gen *utils.Generator) error {
+	u := config.URL + "/entries?follow"
+	for _, match := range config.Matches {
+		// journal matches look like FIELD=value
+		parts := strings.SplitN(strings.TrimSpace(match), "=", 2)
+		if len(parts) == 2 && len(parts[0]) > 0 {
+			u += "&" + url.QueryEscape(parts[0]) + "=" + url.QueryEscape(parts[1])
+		}
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(*cursor) > 0 {
+		// skip the entry that we have already received
+		req.Header.Set("Range", "entries="+*cursor+":1:")
+	} else if !config.FromStart {
+		req.Header.Set("Range", "entries=:-1:")
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return eerrors.Errorf("journal gateway returned '%s': %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	host := req.URL.Hostname()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 65536), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var raw map[string]interface{}
+		err := json.Unmarshal(line, &raw)
+		if err != nil {
+			base.CountParsingError(base.JournalGateway, host, "journal")
+			s.logger.Warn("Error decoding journal entry", "error", err, "url", config.URL)
+			continue
+		}
+		entry := gatewayEntry(raw)
+		if c, ok := entry["__CURSOR"]; ok {
+			*cursor = c
+		}
+		full := model.FullFactoryFrom(journald.EntryToSyslog(entry))
+		full.Uid = gen.Uid()
+		full.ConfId = config.ConfID
+		full.SourceType = "journalgateway"
+		full.SourcePath = config.URL
+		full.ClientAddr = host
+		err = s.stasher.Stash(full)
+		model.FullFree(full)
+		if eerrors.Is("Fatal", err) {
+			return err
+		}
+		if err != nil {
+			s.logger.Error("Error stashing message", "error", err)
+			continue
+		}
+		base.CountIncomingMessage(base.JournalGateway, host, 0, config.URL)
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	return err
+}
+
+// gatewayEntry converts a JSON journal entry to a map of strings. The gateway
+// encodes non-printable values as arrays of bytes, and the fields that appear
+// several times as arrays of values.
+func gatewayEntry(raw map[string]interface{}) map[string]string {
+	entry := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if s, ok := gatewayValue(v); ok {
+			entry[k] = s
+		}
+	}
+	return entry
+}
+
+func gatewayValue(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case []interface{}:
+		if len(val) == 0 {
+			return "", false
+		}
+		if _, ok := val[0].(float64); ok {
+			b := make([]byte, 0, len(val))
+			for _, c := range val {
+				n, ok := c.(float64)
+				if !ok {
+					return "", false
+				}
+				b = append(b, byte(n))
+			}
+			return string(b), true
+		}
+		// keep the first value of a repeated field
+		return gatewayValue(val[0])
+	default:
+		return "", false
+	}
+}
+
+func (s *JournalGatewayService) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wgroup.Wait()
+	s.cancel = nil
+	s.logger.Info("journal gateway source has been stopped")
+}
+
+func (s *JournalGatewayService) Shutdown() {
+	s.Stop()
+}
+
+func (s *JournalGatewayService) SetConf(c conf.BaseConfig) {
+	s.Lock()
+	s.Confs = c.JournalGatewaySource
+	s.Unlock()
+}
@@ -0,0 +1,550 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenk/backoff"
+	"github.com/inconshreveable/log15"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/services/base"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+func initKubernetesRegistry() {
+	base.Once.Do(func() {
+		base.InitRegistry()
+	})
+}
+
+type k8sPodList struct {
+	Items []k8sPod `json:"items"`
+}
+
+type k8sPod struct {
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		UID       string            `json:"uid"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName   string `json:"nodeName"`
+		Containers []struct {
+			Name string `json:"name"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+type k8sNode struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+}
+
+// k8sMaxLineSize is the maximum size of a log line. The longer lines are truncated.
+const k8sMaxLineSize = 1024 * 1024
+
+// KubernetesService watches the pods through the Kubernetes API and streams their logs.
+type KubernetesService struct {
+	stasher        *base.Reporter
+	logger         log15.Logger
+	wgroup         sync.WaitGroup
+	Conf           conf.KubernetesSourceConfig
+	client         *http.Client
+	token          string
+	tokenMu        sync.Mutex
+	cancel         context.CancelFunc
+	fatalErrorChan chan struct{}
+	fatalOnce      *sync.Once
+	confined       bool
+	startTime      time.Time
+	// followed maps the streamed containers to the timestamp of the last received line
+	followed   map[string]time.Time
+	followedMu sync.Mutex
+	// nodeLabels caches the labels of the nodes where the followed pods run
+	nodeLabels   map[string]map[string]string
+	nodeLabelsMu sync.Mutex
+	sync.Mutex
+}
+
+func NewKubernetesService(env *base.ProviderEnv) (base.Provider, error) {
+	initKubernetesRegistry()
+	s := KubernetesService{
+		stasher:  env.Reporter,
+		logger:   env.Logger.New("class", "kubernetes"),
+		confined: env.Confined,
+	}
+	return &s, nil
+}
+
+func (s *KubernetesService) Type() base.Types {
+	return base.Kubernetes
+}
+
+func (s *KubernetesService) Gather() ([]*dto.MetricFamily, error) {
+	return base.Registry.Gather()
+}
+
+func (s *KubernetesService) FatalError() chan struct{} {
+	return s.fatalErrorChan
+}
+
+func (s *KubernetesService) dofatal() {
+	s.fatalOnce.Do(func() { close(s.fatalErrorChan) })
+}
+
+func (s *KubernetesService) path(p string) string {
+	if s.confined {
+		return filepath.Join("/tmp", "certfiles", p)
+	}
+	return p
+}
+
+// tokenPath returns the path of the token file. When confined, the directory
+// of the token is bind-mounted instead of the file itself: the kubelet rotates
+// the token by swapping a symlink in that directory, and a file bind mount
+// would keep pointing to the old token.
+func (s *KubernetesService) tokenPath() string {
+	if s.confined {
+		return filepath.Join("/tmp", "certpaths", s.Conf.TokenFile)
+	}
+	return s.Conf.TokenFile
+}
+
+func (s *KubernetesService) Start() (infos []model.ListenerInfo, err error) {
+	infos = []model.ListenerInfo{}
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel != nil {
+		return infos, eerrors.New("already started")
+	}
+	s.fatalErrorChan = make(chan struct{})
+	s.fatalOnce = &sync.Once{}
+
+	if len(s.Conf.TokenFile) > 0 {
+		err = s.readToken()
+		if err != nil {
+			return infos, err
+		}
+	}
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: s.Conf.Insecure,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if len(s.Conf.CAFile) > 0 && !s.Conf.Insecure {
+		ca, err := ioutil.ReadFile(s.path(s.Conf.CAFile))
+		if err != nil {
+			return infos, eerrors.Wrap(err, "Error reading the kubernetes CA file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return infos, eerrors.Errorf("No certificate found in '%s'", s.Conf.CAFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+	s.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConf,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+	s.startTime = time.Now()
+	s.followed = make(map[string]time.Time)
+	s.nodeLabels = make(map[string]map[string]string)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wgroup.Add(1)
+	go s.watchPods(ctx)
+	return infos, nil
+}
+
+// readToken reads the service account token. The projected tokens are
+// rotated by the kubelet, so the file is read again when the API server
+// rejects the current token.
+func (s *KubernetesService) readToken() error {
+	token, err := ioutil.ReadFile(s.tokenPath())
+	if err != nil {
+		return eerrors.Wrap(err, "Error reading the kubernetes token file")
+	}
+	s.tokenMu.Lock()
+	s.token = strings.TrimSpace(string(token))
+	s.tokenMu.Unlock()
+	return nil
+}
+
+func (s *KubernetesService) do(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	s.tokenMu.Lock()
+	token := s.token
+	s.tokenMu.Unlock()
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return s.client.Do(req)
+}
+
+func (s *KubernetesService) get(ctx context.Context, path string, params url.Values) (*http.Response, error) {
+	u := strings.TrimRight(s.Conf.APIServer, "/") + path
+	if len(params) > 0 {
+		u = u + "?" + params.Encode()
+	}
+	resp, err := s.do(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && len(s.Conf.TokenFile) > 0 {
+		// the token has probably been rotated
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		err = s.readToken()
+		if err != nil {
+			return nil, err
+		}
+		resp, err = s.do(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, eerrors.Errorf("kubernetes API returned '%s': %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (s *KubernetesService) listPods(ctx context.Context) ([]k8sPod, error) {
+	path := "/api/v1/pods"
+	if len(s.Conf.Namespace) > 0 {
+		path = fmt.Sprintf("/api/v1/namespaces/%s/pods", url.PathEscape(s.Conf.Namespace))
+	}
+	params := url.Values{}
+	if len(s.Conf.LabelSelector) > 0 {
+		params.Set("labelSelector", s.Conf.LabelSelector)
+	}
+	if len(s.Conf.NodeName) > 0 {
+		params.Set("fieldSelector", "spec.nodeName="+s.Conf.NodeName)
+	}
+	resp, err := s.get(ctx, path, params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var pods k8sPodList
+	err = json.NewDecoder(resp.Body).Decode(&pods)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error decoding the list of pods")
+	}
+	return pods.Items, nil
+}
+
+func (s *KubernetesService) getNode(ctx context.Context, name string) (*k8sNode, error) {
+	resp, err := s.get(ctx, "/api/v1/nodes/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var node k8sNode
+	err = json.NewDecoder(resp.Body).Decode(&node)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error decoding the node")
+	}
+	return &node, nil
+}
+
+// refreshNodes fetches the labels of the nodes that are not known yet, and
+// forgets about the nodes that do not run any followed pod anymore.
+func (s *KubernetesService) refreshNodes(ctx context.Context, nodes map[string]bool) {
+	for name := range nodes {
+		s.nodeLabelsMu.Lock()
+		_, have := s.nodeLabels[name]
+		s.nodeLabelsMu.Unlock()
+		if have {
+			continue
+		}
+		node, err := s.getNode(ctx, name)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Warn("Error getting kubernetes node", "node", name, "error", err)
+			}
+			continue
+		}
+		s.nodeLabelsMu.Lock()
+		s.nodeLabels[name] = node.Metadata.Labels
+		s.nodeLabelsMu.Unlock()
+	}
+	s.nodeLabelsMu.Lock()
+	for name := range s.nodeLabels {
+		if !nodes[name] {
+			delete(s.nodeLabels, name)
+		}
+	}
+	s.nodeLabelsMu.Unlock()
+}
+
+func (s *KubernetesService) getNodeLabels(name string) map[string]string {
+	s.nodeLabelsMu.Lock()
+	defer s.nodeLabelsMu.Unlock()
+	return s.nodeLabels[name]
+}
+
+func (s *KubernetesService) watchPods(ctx context.Context) {
+	defer s.wgroup.Done()
+	period := s.Conf.RefreshPeriod
+	if period <= 0 {
+		period = 30 * time.Second
+	}
+	for {
+		s.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(period):
+		}
+	}
+}
+
+func (s *KubernetesService) refresh(ctx context.Context) {
+	pods, err := s.listPods(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Warn("Error listing kubernetes pods", "error", err)
+		}
+		return
+	}
+	current := make(map[string]bool)
+	nodes := make(map[string]bool)
+	for i := range pods {
+		pod := pods[i]
+		if pod.Status.Phase != "Running" {
+			continue
+		}
+		if len(pod.Spec.NodeName) > 0 {
+			nodes[pod.Spec.NodeName] = true
+		}
+	}
+	s.refreshNodes(ctx, nodes)
+	for i := range pods {
+		pod := pods[i]
+		if pod.Status.Phase != "Running" {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			key := pod.Metadata.Namespace + "/" + pod.Metadata.Name + "/" + container.Name
+			current[key] = true
+			s.followedMu.Lock()
+			_, have := s.followed[key]
+			if !have {
+				s.followed[key] = s.startTime
+			}
+			s.followedMu.Unlock()
+			if !have {
+				s.wgroup.Add(1)
+				go s.follow(ctx, key, pod, container.Name)
+			}
+		}
+	}
+	// forget about the containers that have disappeared
+	s.followedMu.Lock()
+	for key := range s.followed {
+		if !current[key] {
+			delete(s.followed, key)
+		}
+	}
+	s.followedMu.Unlock()
+}
+
+func (s *KubernetesService) follow(ctx context.Context, key string, pod k8sPod, container string) {
+	defer s.wgroup.Done()
+	logger := s.logger.New("namespace", pod.Metadata.Namespace, "pod", pod.Metadata.Name, "container", container)
+
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = time.Second
+	b.MaxInterval = time.Minute
+	b.MaxElapsedTime = 0
+	for {
+		s.followedMu.Lock()
+		since, ok := s.followed[key]
+		s.followedMu.Unlock()
+		if !ok {
+			return
+		}
+		err := s.stream(ctx, key, since, pod, container)
+		if ctx.Err() != nil {
+			return
+		}
+		if eerrors.Is("Fatal", err) {
+			logger.Error("Fatal error stashing message", "error", err)
+			s.dofatal()
+			return
+		}
+		// the stream was closed: reconnect while refresh() says the container
+		// still exists. The timestamp of the last received line is kept, so
+		// that the lines are not read again.
+		wait := time.Second
+		if err != nil {
+			wait = b.NextBackOff()
+			logger.Info("Error streaming container logs", "error", err, "retry", wait)
+		} else {
+			b.Reset()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *KubernetesService) stream(ctx context.Context, key string, since time.Time, pod k8sPod, container string) error {
+	params := url.Values{}
+	params.Set("container", container)
+	params.Set("follow", "true")
+	params.Set("timestamps", "true")
+	params.Set("sinceTime", since.UTC().Format(time.RFC3339))
+	path := fmt.Sprintf(
+		"/api/v1/namespaces/%s/pods/%s/log",
+		url.PathEscape(pod.Metadata.Namespace),
+		url.PathEscape(pod.Metadata.Name),
+	)
+	resp, err := s.get(ctx, path, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	gen := utils.NewGenerator()
+	reader := bufio.NewReaderSize(resp.Body, 65536)
+	for {
+		line, err := readLine(reader, k8sMaxLineSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reported := time.Now()
+		if idx := strings.IndexByte(line, ' '); idx > 0 {
+			t, err := time.Parse(time.RFC3339Nano, line[:idx])
+			if err == nil {
+				reported = t
+				line = line[idx+1:]
+			}
+		}
+		// sinceTime has a one-second resolution: skip the lines we have already seen
+		if !reported.After(since) {
+			continue
+		}
+		since = reported
+		s.followedMu.Lock()
+		if _, ok := s.followed[key]; ok {
+			s.followed[key] = since
+		}
+		s.followedMu.Unlock()
+
+		full := model.FullFactory()
+		full.Fields.Message = line
+		full.Fields.AppName = container
+		full.Fields.HostName = pod.Spec.NodeName
+		full.Fields.ProcId = pod.Metadata.Name
+		full.Fields.Facility = model.Fuser
+		full.Fields.Severity = model.Sinfo
+		full.Fields.SetPriority()
+		full.Fields.TimeGeneratedNum = time.Now().UnixNano()
+		full.Fields.TimeReportedNum = reported.UnixNano()
+		full.Fields.Version = 1
+		full.Fields.SetProperty("kubernetes", "namespace", pod.Metadata.Namespace)
+		full.Fields.SetProperty("kubernetes", "pod", pod.Metadata.Name)
+		full.Fields.SetProperty("kubernetes", "pod_uid", pod.Metadata.UID)
+		full.Fields.SetProperty("kubernetes", "container", container)
+		full.Fields.SetProperty("kubernetes", "node", pod.Spec.NodeName)
+		for k, v := range pod.Metadata.Labels {
+			full.Fields.SetProperty("kubernetes_labels", k, v)
+		}
+		for k, v := range s.getNodeLabels(pod.Spec.NodeName) {
+			full.Fields.SetProperty("kubernetes_node_labels", k, v)
+		}
+		full.ConfId = s.Conf.ConfID
+		full.Uid = gen.Uid()
+		full.SourceType = "kubernetes"
+		full.SourcePath = key
+		err = s.stasher.Stash(full)
+		if eerrors.Is("Fatal", err) {
+			return err
+		}
+		if err != nil {
+			s.logger.Error("Error stashing message", "error", err)
+			continue
+		}
+		base.CountIncomingMessage(base.Kubernetes, pod.Spec.NodeName, 0, key)
+	}
+}
+
+// readLine returns the next line of r, without the end of line. The lines
+// longer than max are truncated.
+func readLine(r *bufio.Reader, max int) (string, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		if room := max - len(line); room > 0 {
+			if len(part) > room {
+				part = part[:room]
+			}
+			line = append(line, part...)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+func (s *KubernetesService) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wgroup.Wait()
+	s.cancel = nil
+	s.logger.Info("kubernetes source has been stopped")
+}
+
+func (s *KubernetesService) Shutdown() {
+	s.Stop()
+}
+
+func (s *KubernetesService) SetConf(c conf.BaseConfig) {
+	s.Lock()
+	s.Conf = c.Kubernetes
+	s.Unlock()
+}
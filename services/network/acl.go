@@ -0,0 +1,60 @@
+package network
+
+import (
+	"net"
+
+	"github.com/stephane-martin/skewer/conf"
+)
+
+// clientFilter rejects the clients that are not in the allowed CIDR ranges,
+// or that are in the denied ranges.
+type clientFilter struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+}
+
+func newClientFilter(c conf.ListenersConfig) *clientFilter {
+	// the ranges have been checked in conf.Complete()
+	allowed, denied, _ := c.ParseCIDRs()
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil
+	}
+	return &clientFilter{allowed: allowed, denied: denied}
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	default:
+		return nil
+	}
+}
+
+// Allow reports whether the remote address is allowed. Non-IP clients (unix
+// sockets) are always allowed.
+func (f *clientFilter) Allow(addr net.Addr) bool {
+	if f == nil || addr == nil {
+		return true
+	}
+	ip := remoteIP(addr)
+	if ip == nil {
+		return true
+	}
+	for _, n := range f.denied {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allowed) == 0 {
+		return true
+	}
+	for _, n := range f.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/services/base"
 	"github.com/stephane-martin/skewer/sys/binder"
+	"github.com/stephane-martin/skewer/sys/kring"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
 	"github.com/stephane-martin/skewer/utils/queue/message"
@@ -91,6 +92,7 @@ type DirectRelpService struct {
 	kc             conf.KafkaDestConfig
 	wg             sync.WaitGroup
 	confined       bool
+	ring           kring.Ring
 }
 
 func NewDirectRelpService(env *base.ProviderEnv) (base.Provider, error) {
@@ -100,8 +102,9 @@ func NewDirectRelpService(env *base.ProviderEnv) (base.Provider, error) {
 		logger:   env.Logger,
 		reporter: env.Reporter,
 		confined: env.Confined,
+		ring:     env.Ring,
 	}
-	s.impl = NewDirectRelpServiceImpl(env.Confined, env.Reporter, env.Binder, env.Logger)
+	s.impl = NewDirectRelpServiceImpl(env.Confined, env.Ring, env.Reporter, env.Binder, env.Logger)
 	return &s, nil
 }
 
@@ -123,7 +126,7 @@ func (s *DirectRelpService) Gather() ([]*dto.MetricFamily, error) {
 
 func (s *DirectRelpService) Start() (infos []model.ListenerInfo, err error) {
 	infos = []model.ListenerInfo{}
-	s.impl = NewDirectRelpServiceImpl(s.confined, s.reporter, s.b, s.logger)
+	s.impl = NewDirectRelpServiceImpl(s.confined, s.ring, s.reporter, s.b, s.logger)
 	s.fatalErrorChan = make(chan struct{})
 	s.fatalOnce = &sync.Once{}
 
@@ -203,11 +206,13 @@ type DirectRelpServiceImpl struct {
 	forwarder           *ackForwarder
 	parserEnv           *decoders.ParsersEnv
 	collectors          []prometheus.Collector
+	ring                kring.Ring
 }
 
-func NewDirectRelpServiceImpl(confined bool, reporter *base.Reporter, b binder.Client, logger log15.Logger) *DirectRelpServiceImpl {
+func NewDirectRelpServiceImpl(confined bool, ring kring.Ring, reporter *base.Reporter, b binder.Client, logger log15.Logger) *DirectRelpServiceImpl {
 	s := DirectRelpServiceImpl{
 		status:    Stopped,
+		ring:      ring,
 		reporter:  reporter,
 		configs:   map[utils.MyULID]conf.DirectRELPSourceConfig{},
 		forwarder: newAckForwarder(),
@@ -216,6 +221,7 @@ func NewDirectRelpServiceImpl(confined bool, reporter *base.Reporter, b binder.C
 	s.StreamingService.BaseService.Logger = logger.New("class", "DirectRELPService")
 	s.StreamingService.BaseService.Binder = b
 	s.StreamingService.handler = DirectRelpHandler{Server: &s}
+	s.StreamingService.provider = base.DirectRELP
 	s.StreamingService.confined = confined
 	s.StatusChan = make(chan RelpServerStatus, 10)
 	return &s
@@ -238,7 +244,7 @@ func (s *DirectRelpServiceImpl) Start() ([]model.ListenerInfo, error) {
 	}
 
 	var err error
-	producer, registry, err := s.kafkaConf.GetAsyncProducer(s.confined)
+	producer, registry, err := s.kafkaConf.GetAsyncProducer(s.confined, s.ring)
 	if err != nil {
 		connCounter.WithLabelValues("directkafka", "fail").Inc()
 		s.resetTCPListeners()
@@ -257,10 +263,10 @@ func (s *DirectRelpServiceImpl) Start() ([]model.ListenerInfo, error) {
 	s.configs = map[utils.MyULID]conf.DirectRELPSourceConfig{}
 
 	for _, l := range s.UnixListeners {
-		s.configs[l.Conf.ConfID] = conf.DirectRELPSourceConfig(l.Conf)
+		s.configs[l.Conf.ConfID] = l.Conf.DirectRELPConfig()
 	}
 	for _, l := range s.TCPListeners {
-		s.configs[l.Conf.ConfID] = conf.DirectRELPSourceConfig(l.Conf)
+		s.configs[l.Conf.ConfID] = l.Conf.DirectRELPConfig()
 	}
 
 	s.wgroup.Add(1)
@@ -378,10 +384,14 @@ func (s *DirectRelpServiceImpl) doStop(final bool, wait bool) {
 func (s *DirectRelpServiceImpl) SetConf(sc []conf.DirectRELPSourceConfig, pc []conf.ParserConfig, kc conf.KafkaDestConfig, queueSize uint64) {
 	tcpConfigs := []conf.TCPSourceConfig{}
 	for _, c := range sc {
-		tcpConfigs = append(tcpConfigs, conf.TCPSourceConfig(c))
+		tcpConfigs = append(tcpConfigs, c.TCPConfig())
 	}
 	s.StreamingService.SetConf(tcpConfigs, pc, queueSize, 132000)
 	s.kafkaConf = kc
+	if s.parserEnv != nil {
+		// stop the external parsers of the previous configuration
+		s.parserEnv.Close()
+	}
 	s.parserEnv = decoders.NewParsersEnv(s.ParserConfigs, s.Logger)
 }
 
@@ -397,18 +407,8 @@ func makeDRELPLogger(logger log15.Logger, raw *model.RawTCPMessage) log15.Logger
 }
 
 func (s *DirectRelpServiceImpl) parseOne(raw *model.RawTCPMessage) error {
-	syslogMsgs, err := s.parserEnv.Parse(&raw.Decoder, raw.Message)
-	if err != nil {
-		return err
-	}
-
-	if err != nil {
-		makeDRELPLogger(s.Logger, raw).Warn("Parsing error", "error", err)
-		s.forwarder.ForwardFail(raw.ConnID, raw.Txnr)
-		base.CountParsingError(base.DirectRELP, raw.Client, raw.Decoder.Format)
-		// TODO
-		return nil
-	}
+	// the messages decoded before a parsing error are forwarded too
+	syslogMsgs, parseErr := s.parserEnv.Parse(&raw.Decoder, raw.Message)
 
 	for _, syslogMsg := range syslogMsgs {
 		if syslogMsg == nil {
@@ -423,12 +423,12 @@ func (s *DirectRelpServiceImpl) parseOne(raw *model.RawTCPMessage) error {
 		full.Txnr = raw.Txnr
 		full.ConfId = raw.ConfID
 		full.ConnId = raw.ConnID
-		err = s.parsedMessagesQueue.Put(full)
+		err := s.parsedMessagesQueue.Put(full)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return parseErr
 }
 
 func (s *DirectRelpServiceImpl) parse() {
@@ -475,7 +475,7 @@ func (s *DirectRelpServiceImpl) handleKafkaResponses() {
 	}
 }
 
-func (s *DirectRelpServiceImpl) handleResponses(conn net.Conn, connID utils.MyULID, client string, logger log15.Logger) error {
+func (s *DirectRelpServiceImpl) handleResponses(conn io.Writer, connID utils.MyULID, client string, logger log15.Logger) error {
 	successes := map[int32]bool{}
 	failures := map[int32]bool{}
 	var err error
@@ -537,6 +537,7 @@ func (s *DirectRelpServiceImpl) handleResponses(conn net.Conn, connID utils.MyUL
 
 			if err == nil {
 				next = -1
+				s.forwarder.Committed(connID)
 			} else if err == io.EOF {
 				return io.EOF
 			} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
@@ -582,6 +583,10 @@ func (s *DirectRelpServiceImpl) pushOne(message *model.FullMessage, envs *map[ut
 			s.Logger,
 		)
 		e = (*envs)[message.ConfId]
+		err = e.SetExpressions(&config.FilterSubConfig)
+		if err != nil {
+			s.Logger.Warn("Error setting the filter expressions", "error", err)
+		}
 	}
 
 	topic, joinedErr := e.Topic(message.Fields)
@@ -651,7 +656,7 @@ type DirectRelpHandler struct {
 }
 
 func (h DirectRelpHandler) HandleConnection(conn net.Conn, c conf.TCPSourceConfig) (rerr error) {
-	config := conf.DirectRELPSourceConfig(c)
+	config := c.DirectRELPConfig()
 	s := h.Server
 	s.AddConnection(conn)
 	connID := s.forwarder.AddConn(s.QueueSize)
@@ -660,13 +665,18 @@ func (h DirectRelpHandler) HandleConnection(conn net.Conn, c conf.TCPSourceConfi
 	l.Info("New client")
 	defer l.Debug("Client gone away")
 	clientCounter(base.DirectRELP, props)
+	tlsConf, err := startTLSConfig(c, s.confined)
+	if err != nil {
+		l.Warn("Error creating STARTTLS configuration", "error", err)
+	}
+	w := newRelpWriter(conn)
 
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		err := s.handleResponses(conn, connID, props.Client, l)
+		err := s.handleResponses(w, connID, props.Client, l)
 		if err != nil && !eerrors.HasFileClosed(err) {
 			s.Logger.Warn("Unexpected error in Direct RELP handleResponses", "error", err, "connID", connID.String())
 		}
@@ -679,7 +689,7 @@ func (h DirectRelpHandler) HandleConnection(conn net.Conn, c conf.TCPSourceConfi
 			s.RemoveConnection(conn)
 			wg.Done()
 		}()
-		err := scan(l, s.forwarder, s.rawQ, conn, config.Timeout, config.ConfID, connID, s.MaxMessageSize, config.DecoderBaseConfig, props)
+		err := scan(l, s.forwarder, s.rawQ, conn, w, tlsConf, relpMaxWindow(s.QueueSize), s.limiters.get(config.ConfID), config.Timeout, config.ConfID, connID, s.MaxMessageSize, config.DecoderBaseConfig, props)
 		if err != nil && !eerrors.HasFileClosed(err) {
 			rerr = eerrors.Wrapf(err, "Error scanning Direct RELP stream: %s", connID.String())
 		}
@@ -2,11 +2,8 @@ package network
 
 import (
 	"bytes"
-	"compress/gzip"
-	"compress/zlib"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"strconv"
 	"strings"
@@ -20,6 +17,7 @@ import (
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/services/base"
 	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
 )
 
 type GraylogStatus int
@@ -31,8 +29,6 @@ const (
 
 var (
 	magicChunked = []byte{0x1e, 0x0f}
-	magicZlib    = []byte{0x78}
-	magicGzip    = []byte{0x1f, 0x8b}
 )
 
 const (
@@ -193,9 +189,10 @@ func (s *GraylogSvcImpl) handleConnection(conn net.PacketConn, config conf.Grayl
 	var addr net.Addr
 	var client string
 
-	chunks := map[[8]byte](map[uint8]([]byte)){}
-	chunkStartTime := map[[8]byte]time.Time{}
+	chunks := map[[8]byte]*gelfChunks{}
+	lastPurge := time.Now()
 	gen := utils.NewGenerator()
+	filter := newClientFilter(config.ListenersConfig)
 
 	local := conn.LocalAddr()
 	if local != nil {
@@ -211,13 +208,27 @@ func (s *GraylogSvcImpl) handleConnection(conn net.PacketConn, config conf.Grayl
 
 	logger := s.Logger.New("protocol", "graylog", "local_port", localPortS, "unix_socket_path", path)
 
-	cBuf := make([]byte, gelf.ChunkSize)
+	// GELF clients may send datagrams up to 8192 bytes, chunked or not
+	cBuf := make([]byte, 65536)
 	for {
 		n, addr, err = conn.ReadFrom(cBuf)
 		if err != nil {
 			logger.Info("Error reading UDP Graylog", "error", err)
 			return
 		}
+		if time.Since(lastPurge) > config.ChunksTimeout {
+			// forget about the incomplete messages
+			for msgid, c := range chunks {
+				if time.Since(c.start) > config.ChunksTimeout {
+					delete(chunks, msgid)
+				}
+			}
+			lastPurge = time.Now()
+		}
+		if !filter.Allow(addr) {
+			base.CountRejectedClient(base.Graylog, strings.Split(addr.String(), ":")[0])
+			continue
+		}
 		if n < 2 {
 			logger.Warn("GELF message was too short", "size", n)
 			continue
@@ -234,8 +245,8 @@ func (s *GraylogSvcImpl) handleConnection(conn net.PacketConn, config conf.Grayl
 			var msgid [8]byte
 			copy(msgid[:], cBuf[2:10])
 			seq, total := cBuf[10], cBuf[11]
-			if total > 128 {
-				logger.Warn("Too many GELF chunks", "total", total)
+			if int(total) > config.MaxChunks {
+				logger.Warn("Too many GELF chunks", "total", total, "max", config.MaxChunks)
 				delete(chunks, msgid)
 				continue
 			}
@@ -245,26 +256,43 @@ func (s *GraylogSvcImpl) handleConnection(conn net.PacketConn, config conf.Grayl
 				continue
 			}
 
-			if _, ok := chunks[msgid]; !ok {
-				chunks[msgid] = map[uint8]([]byte){}
-				chunkStartTime[msgid] = time.Now()
-
+			c, ok := chunks[msgid]
+			if !ok {
+				c = &gelfChunks{
+					start:  time.Now(),
+					total:  total,
+					chunks: map[uint8]([]byte){},
+				}
+				chunks[msgid] = c
+			}
+			if time.Since(c.start) > config.ChunksTimeout {
+				logger.Warn("GELF chunk arrived too late", "timeout", config.ChunksTimeout)
+				delete(chunks, msgid)
+				continue
 			}
-			if (time.Since(chunkStartTime[msgid])) > (5 * time.Second) {
-				logger.Warn("GELF chunk arrived after 5 seconds")
+			if c.total != total {
+				logger.Warn("Inconsistent total number of GELF chunks", "total", total, "previous", c.total)
 				delete(chunks, msgid)
 				continue
 			}
-			chunks[msgid][seq] = make([]byte, n-chunkedHeaderLen)
-			copy(chunks[msgid][seq], cBuf[chunkedHeaderLen:n])
-			if len(chunks[msgid]) < int(total) {
+			if _, ok := c.chunks[seq]; !ok {
+				c.size += n - chunkedHeaderLen
+				if c.size > config.MaxMessageSize {
+					logger.Warn("Chunked GELF message is too large", "max", config.MaxMessageSize)
+					delete(chunks, msgid)
+					continue
+				}
+				c.chunks[seq] = make([]byte, n-chunkedHeaderLen)
+				copy(c.chunks[seq], cBuf[chunkedHeaderLen:n])
+			}
+			if len(c.chunks) < int(total) {
 				continue
 			}
 			// rebuild message
-			full, err = fromChunks(chunks[msgid], total)
+			full, err = c.message(config.MaxMessageSize)
 			delete(chunks, msgid)
 		} else {
-			full, err = fullMsg(cBuf[:n])
+			full, err = fullMsg(cBuf[:n], config.MaxMessageSize)
 		}
 
 		client = "localhost"
@@ -284,49 +312,49 @@ func (s *GraylogSvcImpl) handleConnection(conn net.PacketConn, config conf.Grayl
 		full.SourcePath = path
 		full.SourcePort = int32(localPort)
 		full.ClientAddr = client
-		s.stasher.Stash(full)
-		base.CountIncomingMessage(base.Graylog, client, localPort, path)
+		err = s.stasher.Stash(full)
 		model.FullFree(full)
+		if err != nil {
+			logger.Warn("Error stashing GELF message", "error", err)
+			if eerrors.IsFatal(err) {
+				s.dofatal()
+				return
+			}
+			continue
+		}
+		base.CountIncomingMessage(base.Graylog, client, localPort, path)
 	}
 }
 
-func fromChunks(chunks map[uint8]([]byte), total uint8) (*model.FullMessage, error) {
+// gelfChunks gathers the chunks of a GELF message until they are all received.
+type gelfChunks struct {
+	start  time.Time
+	total  uint8
+	size   int
+	chunks map[uint8]([]byte)
+}
+
+func (c *gelfChunks) message(maxSize int) (*model.FullMessage, error) {
 	var i uint8
-	full := make([]byte, 0, int(total)*gelf.ChunkSize)
-	for i = 0; i < total; i++ {
-		chunk, ok := chunks[i]
+	full := make([]byte, 0, c.size)
+	for i = 0; i < c.total; i++ {
+		chunk, ok := c.chunks[i]
 		if !ok {
 			return nil, fmt.Errorf("Missing chunk")
 		}
 		full = append(full, chunk...)
 	}
-	return fullMsg(full)
+	return fullMsg(full, maxSize)
 }
 
-func fullMsg(buf []byte) (full *model.FullMessage, err error) {
-	if len(buf) < 2 {
-		return nil, fmt.Errorf("GELF message was too short")
-	}
-	head := buf[:2]
-	var reader io.Reader
-	// the data we get from the wire is compressed
-	if bytes.Equal(head, magicGzip) {
-		reader, err = gzip.NewReader(bytes.NewReader(buf))
-	} else if head[0] == magicZlib[0] && (int(head[0])*256+int(head[1]))%31 == 0 {
-		reader, err = zlib.NewReader(bytes.NewReader(buf))
-	} else {
-		// compliance with https://github.com/Graylog2/graylog2-server
-		// treating all messages as uncompressed if  they are not gzip, zlib or
-		// chunked
-		reader = bytes.NewReader(buf)
-	}
-
+func fullMsg(buf []byte, maxSize int) (full *model.FullMessage, err error) {
+	decompressed, err := decoders.DecompressGELF(buf, maxSize)
 	if err != nil {
-		return nil, fmt.Errorf("NewReader: %s", err)
+		return nil, err
 	}
 
 	gelfmsg := &gelf.Message{}
-	if err := json.NewDecoder(reader).Decode(gelfmsg); err != nil {
+	if err := json.Unmarshal(decompressed, gelfmsg); err != nil {
 		return nil, fmt.Errorf("json.Unmarshal: %s", err)
 	}
 	return decoders.FullFromGelfMessage(gelfmsg), nil
@@ -162,6 +162,10 @@ func (s *HTTPServiceImpl) SetConf(c conf.BaseConfig) {
 	s.maxMessageSize = c.Main.MaxInputMessageSize
 	s.configs = c.HTTPServerSource
 	s.parserConfigs = c.Parsers
+	if s.parserEnv != nil {
+		// stop the external parsers of the previous configuration
+		s.parserEnv.Close()
+	}
 	s.parserEnv = decoders.NewParsersEnv(s.parserConfigs, s.logger)
 	s.rawMessagesQueue = tcp.NewRing(c.Main.InputQueueSize)
 	s.trackers = &sync.Map{}
@@ -448,10 +452,8 @@ func (s *HTTPServiceImpl) parseAndEnqueue(gen *utils.Generator, raw *model.RawTC
 		"protocol", "httpserver",
 		"format", raw.Decoder.Format,
 	)
-	fulls, err := s.parseOne(raw)
-	if err != nil {
-		return eerrors.Wrap(err, "Error parsing HTTP server message")
-	}
+	// the messages decoded before a parsing error are stashed too
+	fulls, parseErr := s.parseOne(raw)
 	for _, full := range fulls {
 		defer model.FullFree(full)
 		full.Uid = gen.Uid()
@@ -465,16 +467,16 @@ func (s *HTTPServiceImpl) parseAndEnqueue(gen *utils.Generator, raw *model.RawTC
 			logger.Warn("Non-fatal error stashing HTTP message", "error", err)
 		}
 	}
+	if parseErr != nil {
+		return eerrors.Wrap(parseErr, "Error parsing HTTP server message")
+	}
 	return nil
 }
 
 func (s *HTTPServiceImpl) parseOne(raw *model.RawTCPMessage) (fulls []*model.FullMessage, err error) {
 	syslogMsgs, err := s.parserEnv.Parse(&raw.Decoder, raw.Message)
-	if err != nil {
-		return nil, err
-	}
 	if len(syslogMsgs) == 0 {
-		return nil, nil
+		return nil, err
 	}
 	fulls = make([]*model.FullMessage, 0, len(syslogMsgs))
 
@@ -490,7 +492,7 @@ func (s *HTTPServiceImpl) parseOne(raw *model.RawTCPMessage) (fulls []*model.Ful
 		full.ConnId = raw.ConnID
 		fulls = append(fulls, full)
 	}
-	return fulls, nil
+	return fulls, err
 }
 
 func (s *HTTPServiceImpl) Shutdown() {
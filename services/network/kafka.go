@@ -5,11 +5,12 @@ import (
 	"context"
 	"fmt"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	cluster "github.com/bsm/sarama-cluster"
+	"github.com/Shopify/sarama"
 	"github.com/inconshreveable/log15"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
@@ -19,6 +20,7 @@ import (
 	"github.com/stephane-martin/skewer/decoders"
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/services/base"
+	"github.com/stephane-martin/skewer/sys/kring"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
 	"github.com/stephane-martin/skewer/utils/queue"
@@ -26,13 +28,89 @@ import (
 )
 
 var incomingByteRate *prometheus.GaugeVec
+var kafkaLagGauge *prometheus.GaugeVec
+var kafkaPausedGauge *prometheus.GaugeVec
+
+// kafkaLagPeriod is the period between two updates of the consumer lag gauges.
+const kafkaLagPeriod = 10 * time.Second
 
 func initKafkaRegistry() {
 	base.Once.Do(func() {
 		base.InitRegistry()
+
+		kafkaLagGauge = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "skw_kafka_source_lag",
+				Help: "number of messages not yet consumed and committed by the Kafka source, by partition",
+			},
+			[]string{"group", "topic", "partition"},
+		)
+
+		kafkaPausedGauge = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "skw_kafka_source_paused",
+				Help: "1 when the Kafka source has stopped consuming because too many messages are in flight",
+			},
+			[]string{"group"},
+		)
+
+		base.Registry.MustRegister(kafkaLagGauge, kafkaPausedGauge)
 	})
 }
 
+// kafkaLags tracks the committed offsets of a Kafka consumer, to compute the
+// lag of each partition.
+type kafkaLags struct {
+	group     string
+	committed map[queue.TopicPartition]int64
+	seen      map[queue.TopicPartition]bool
+	sync.Mutex
+}
+
+func newKafkaLags(group string) *kafkaLags {
+	return &kafkaLags{
+		group:     group,
+		committed: make(map[queue.TopicPartition]int64),
+		seen:      make(map[queue.TopicPartition]bool),
+	}
+}
+
+func (l *kafkaLags) commit(tp queue.TopicPartition, next int64) {
+	l.Lock()
+	l.committed[tp] = next
+	l.Unlock()
+}
+
+func (l *kafkaLags) update(hwms map[string]map[int32]int64) {
+	l.Lock()
+	defer l.Unlock()
+	for topic, partitions := range hwms {
+		for partition, hwm := range partitions {
+			tp := queue.TopicPartition{Topic: topic, Partition: partition}
+			next, ok := l.committed[tp]
+			if !ok {
+				// nothing committed yet for that partition
+				continue
+			}
+			lag := hwm - next
+			if lag < 0 {
+				lag = 0
+			}
+			l.seen[tp] = true
+			kafkaLagGauge.WithLabelValues(l.group, topic, strconv.FormatInt(int64(partition), 10)).Set(float64(lag))
+		}
+	}
+}
+
+func (l *kafkaLags) clear() {
+	l.Lock()
+	for tp := range l.seen {
+		kafkaLagGauge.DeleteLabelValues(l.group, tp.Topic, strconv.FormatInt(int64(tp.Partition), 10))
+	}
+	l.seen = make(map[queue.TopicPartition]bool)
+	l.Unlock()
+}
+
 var rawkafkapool = &sync.Pool{New: func() interface{} {
 	return &model.RawKafkaMessage{
 		Message: make([]byte, 0, 4096),
@@ -68,6 +146,7 @@ type KafkaServiceImpl struct {
 	fatalErrorChan   chan struct{}
 	fatalOnce        *sync.Once
 	confined         bool
+	ring             kring.Ring
 }
 
 func NewKafkaService(env *base.ProviderEnv) (base.Provider, error) {
@@ -76,6 +155,7 @@ func NewKafkaService(env *base.ProviderEnv) (base.Provider, error) {
 		reporter: env.Reporter,
 		logger:   env.Logger.New("class", "KafkaService"),
 		confined: env.Confined,
+		ring:     env.Ring,
 	}
 	return &s, nil
 }
@@ -87,6 +167,10 @@ func (s *KafkaServiceImpl) Type() base.Types {
 func (s *KafkaServiceImpl) SetConf(c conf.BaseConfig) {
 	s.configs = c.KafkaSource
 	s.parserConfigs = c.Parsers
+	if s.parserEnv != nil {
+		// stop the external parsers of the previous configuration
+		s.parserEnv.Close()
+	}
 	s.parserEnv = decoders.NewParsersEnv(s.parserConfigs, s.logger)
 	s.rawMessagesQueue = kafka.NewRing(c.Main.InputQueueSize)
 }
@@ -146,14 +230,18 @@ func (s *KafkaServiceImpl) dofatal() {
 	s.fatalOnce.Do(func() { close(s.fatalErrorChan) })
 }
 
-func getConsumer(ctx context.Context, logger log15.Logger, breaker *circuit.Breaker, config conf.KafkaSourceConfig, confined bool) (*cluster.Consumer, metrics.Registry) {
-	var consumer *cluster.Consumer
+func getConsumer(ctx context.Context, logger log15.Logger, breaker *circuit.Breaker, config conf.KafkaSourceConfig, confined bool, ring kring.Ring) (*kafkaGroup, metrics.Registry) {
+	var consumer *kafkaGroup
 	var mregistry metrics.Registry
 
 	getClient := func() (err error) {
 		ret := make(chan struct{})
 		go func() {
-			consumer, mregistry, err = config.GetClient(confined)
+			var group sarama.ConsumerGroup
+			group, mregistry, err = config.GetClient(confined, ring)
+			if err == nil {
+				consumer = newKafkaGroup(group, config.Topics)
+			}
 			close(ret)
 		}()
 		select {
@@ -197,7 +285,7 @@ func (s *KafkaServiceImpl) startWorker(ctx context.Context, config conf.KafkaSou
 			return
 		default:
 		}
-		consumer, mregistry := getConsumer(ctx, s.logger, breaker, config, s.confined)
+		consumer, mregistry := getConsumer(ctx, s.logger, breaker, config, s.confined, s.ring)
 		if consumer == nil {
 			return
 		}
@@ -231,10 +319,8 @@ func (s *KafkaServiceImpl) parse() (err error) {
 }
 
 func (s *KafkaServiceImpl) parseOne(raw *model.RawKafkaMessage) (err error) {
-	syslogMsgs, err := s.parserEnv.Parse(&raw.Decoder, raw.Message)
-	if err != nil {
-		return err
-	}
+	// the messages decoded before a parsing error are stashed too
+	syslogMsgs, parseErr := s.parserEnv.Parse(&raw.Decoder, raw.Message)
 
 	for _, syslogMsg := range syslogMsgs {
 		if syslogMsg == nil {
@@ -245,6 +331,12 @@ func (s *KafkaServiceImpl) parseOne(raw *model.RawKafkaMessage) (err error) {
 		full.ConfId = raw.ConfID
 		full.SourceType = "kafka"
 		full.ClientAddr = raw.Client
+		full.Fields.SetProperty("kafka", "topic", raw.Topic)
+		full.Fields.SetProperty("kafka", "partition", strconv.FormatInt(int64(raw.Partition), 10))
+		full.Fields.SetProperty("kafka", "offset", strconv.FormatInt(raw.Offset, 10))
+		for k, v := range raw.Headers {
+			full.Fields.SetProperty("kafka", "header."+k, v)
+		}
 		err := s.reporter.Stash(full)
 		model.FullFree(full)
 
@@ -255,7 +347,7 @@ func (s *KafkaServiceImpl) parseOne(raw *model.RawKafkaMessage) (err error) {
 			}
 		}
 	}
-	return nil
+	return parseErr
 }
 
 func (s *KafkaServiceImpl) Shutdown() {
@@ -270,7 +362,7 @@ func (s *KafkaServiceImpl) Stop() {
 	s.wg.Wait()
 }
 
-func (s *KafkaServiceImpl) handleConsumer(ctx context.Context, config conf.KafkaSourceConfig, consumer *cluster.Consumer, mregistry metrics.Registry) {
+func (s *KafkaServiceImpl) handleConsumer(ctx context.Context, config conf.KafkaSourceConfig, consumer *kafkaGroup, mregistry metrics.Registry) {
 	if consumer == nil {
 		s.logger.Error("BUG: the consumer passed to handleConsumer is NIL")
 		return
@@ -279,6 +371,11 @@ func (s *KafkaServiceImpl) handleConsumer(ctx context.Context, config conf.Kafka
 	lctx, lcancel := context.WithCancel(ctx)
 	defer lcancel()
 	ackQueue := s.queues.New()
+	lags := newKafkaLags(config.GroupID)
+	defer lags.clear()
+	// inflight bounds the number of messages that have been read from Kafka
+	// but not yet processed, so that we stop consuming when the Store is slow
+	inflight := make(chan struct{}, config.MaxInflight)
 
 	collectors := utils.KafkaConsumerMetrics(mregistry, fmt.Sprintf("skw_kafka_source_%d", ackQueue.ID()))
 	base.Registry.MustRegister(collectors...)
@@ -309,6 +406,10 @@ func (s *KafkaServiceImpl) handleConsumer(ctx context.Context, config conf.Kafka
 			if len(ack.Topic) == 0 {
 				continue
 			}
+			select {
+			case <-inflight:
+			default:
+			}
 			// a little dance to ACK kafka messages in growing order for each partition
 			if _, ok := processedMsgs[ack.TopicPartition]; !ok {
 				processedMsgs[ack.TopicPartition] = map[int64]bool{}
@@ -320,10 +421,25 @@ func (s *KafkaServiceImpl) handleConsumer(ctx context.Context, config conf.Kafka
 			}
 			for processedMsgs[ack.TopicPartition][next] {
 				delete(processedMsgs[ack.TopicPartition], next)
-				consumer.MarkPartitionOffset(ack.Topic, ack.Partition, next, "")
+				consumer.MarkPartitionOffset(ack.Topic, ack.Partition, next)
 				next++
 				nextToACK[ack.TopicPartition] = next
 			}
+			lags.commit(ack.TopicPartition, next)
+		}
+	}()
+
+	wg.Add(1)
+	// update the consumer lag gauges
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-lctx.Done():
+				return
+			case <-time.After(kafkaLagPeriod):
+				lags.update(consumer.HighWaterMarks())
+			}
 		}
 	}()
 
@@ -352,8 +468,25 @@ func (s *KafkaServiceImpl) handleConsumer(ctx context.Context, config conf.Kafka
 
 	Loop:
 		for msg := range consumer.Messages() {
+			select {
+			case inflight <- struct{}{}:
+			default:
+				kafkaPausedGauge.WithLabelValues(config.GroupID).Set(1)
+				s.logger.Debug("Too many Kafka messages in flight, pausing consumption")
+				select {
+				case inflight <- struct{}{}:
+					kafkaPausedGauge.WithLabelValues(config.GroupID).Set(0)
+				case <-lctx.Done():
+					// the consumer is being closed, drain the messages
+					kafkaPausedGauge.WithLabelValues(config.GroupID).Set(0)
+					continue Loop
+				}
+			}
 			ok := true
-			value := bytes.TrimSpace(msg.Value)
+			value := msg.Value
+			if !decoders.IsBinary(config.Format) {
+				value = bytes.TrimSpace(value)
+			}
 			if len(value) == 0 {
 				s.logger.Warn("Empty message")
 				ok = false
@@ -376,6 +509,16 @@ func (s *KafkaServiceImpl) handleConsumer(ctx context.Context, config conf.Kafka
 			raw.Topic = msg.Topic
 			raw.Partition = msg.Partition
 			raw.Offset = msg.Offset
+			raw.Headers = nil
+			if len(msg.Headers) > 0 {
+				// record headers are only available with kafka >= 0.11
+				raw.Headers = make(map[string]string, len(msg.Headers))
+				for _, h := range msg.Headers {
+					if h != nil {
+						raw.Headers[string(h.Key)] = string(h.Value)
+					}
+				}
+			}
 			s.rawMessagesQueue.Put(raw)
 			base.CountIncomingMessage(base.KafkaSource, raw.Client, 0, "")
 		}
@@ -385,6 +528,8 @@ func (s *KafkaServiceImpl) handleConsumer(ctx context.Context, config conf.Kafka
 		// so here we now that the current kafka consumer is gone
 		// hence, there is no need to process ACK any further
 		s.queues.Delete(ackQueue)
+		// make sure the lag goroutine returns too
+		lcancel()
 
 	}()
 
@@ -0,0 +1,178 @@
+package network
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// kafkaGroup turns the sessions of a sarama consumer group into a single
+// stream of messages, for the whole life of the consumer.
+type kafkaGroup struct {
+	group    sarama.ConsumerGroup
+	topics   []string
+	messages chan *sarama.ConsumerMessage
+	errors   chan error
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	once     sync.Once
+
+	mu      sync.Mutex
+	session sarama.ConsumerGroupSession
+	claims  map[string]map[int32]sarama.ConsumerGroupClaim
+}
+
+func newKafkaGroup(group sarama.ConsumerGroup, topics []string) *kafkaGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &kafkaGroup{
+		group:    group,
+		topics:   topics,
+		messages: make(chan *sarama.ConsumerMessage),
+		errors:   make(chan error, 16),
+		cancel:   cancel,
+		claims:   make(map[string]map[int32]sarama.ConsumerGroupClaim),
+	}
+
+	var consumeWg sync.WaitGroup
+	consumeWg.Add(1)
+	go func() {
+		defer consumeWg.Done()
+		defer close(g.messages)
+		g.consume(ctx)
+	}()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		// the errors channel of the group is closed by group.Close()
+		for err := range group.Errors() {
+			g.sendError(err)
+		}
+		consumeWg.Wait()
+		close(g.errors)
+	}()
+	return g
+}
+
+// consume joins the group again after each rebalance, until the context is canceled.
+func (g *kafkaGroup) consume(ctx context.Context) {
+	for {
+		err := g.group.Consume(ctx, g.topics, g)
+		if err == sarama.ErrClosedConsumerGroup || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			g.sendError(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func (g *kafkaGroup) sendError(err error) {
+	select {
+	case g.errors <- err:
+	default:
+		// nobody reads the errors anymore
+	}
+}
+
+// Setup implements sarama.ConsumerGroupHandler.
+func (g *kafkaGroup) Setup(session sarama.ConsumerGroupSession) error {
+	g.mu.Lock()
+	g.session = session
+	g.mu.Unlock()
+	return nil
+}
+
+// Cleanup implements sarama.ConsumerGroupHandler.
+func (g *kafkaGroup) Cleanup(session sarama.ConsumerGroupSession) error {
+	g.mu.Lock()
+	g.session = nil
+	g.mu.Unlock()
+	return nil
+}
+
+// ConsumeClaim implements sarama.ConsumerGroupHandler.
+func (g *kafkaGroup) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	g.mu.Lock()
+	if g.claims[claim.Topic()] == nil {
+		g.claims[claim.Topic()] = make(map[int32]sarama.ConsumerGroupClaim)
+	}
+	g.claims[claim.Topic()][claim.Partition()] = claim
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.claims[claim.Topic()], claim.Partition())
+		g.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			select {
+			case g.messages <- msg:
+			case <-session.Context().Done():
+				return nil
+			}
+		}
+	}
+}
+
+// Messages returns the messages of every claimed partition. The channel is
+// closed after Close has been called.
+func (g *kafkaGroup) Messages() <-chan *sarama.ConsumerMessage {
+	return g.messages
+}
+
+// Errors returns the errors of the consumer. The channel is closed after
+// Close has been called.
+func (g *kafkaGroup) Errors() <-chan error {
+	return g.errors
+}
+
+// MarkPartitionOffset marks the message at offset as processed. The offsets
+// of the partitions that have been revoked by a rebalance are ignored.
+func (g *kafkaGroup) MarkPartitionOffset(topic string, partition int32, offset int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.session != nil {
+		// the committed offset is the offset of the next message to consume
+		g.session.MarkOffset(topic, partition, offset+1, "")
+	}
+}
+
+// HighWaterMarks returns the high water marks of the claimed partitions.
+func (g *kafkaGroup) HighWaterMarks() map[string]map[int32]int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	hwms := make(map[string]map[int32]int64, len(g.claims))
+	for topic, claims := range g.claims {
+		hwms[topic] = make(map[int32]int64, len(claims))
+		for partition, claim := range claims {
+			hwms[topic][partition] = claim.HighWaterMarkOffset()
+		}
+	}
+	return hwms
+}
+
+// Close leaves the group and commits the marked offsets.
+func (g *kafkaGroup) Close() (err error) {
+	g.once.Do(func() {
+		g.cancel()
+		err = g.group.Close()
+		g.wg.Wait()
+	})
+	return err
+}
@@ -0,0 +1,157 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// http://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+var proxyV1Prefix = []byte("PROXY ")
+
+const proxyV1MaxLength = 107
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyConn is a connection whose remote address has been conveyed by a
+// PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
+
+// readProxyHeader reads the PROXY protocol header (v1 or v2) at the start of
+// the connection, and returns a connection that reports the original client
+// address.
+func readProxyHeader(c net.Conn) (net.Conn, error) {
+	_ = c.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer func() { _ = c.SetReadDeadline(time.Time{}) }()
+
+	pc := &proxyConn{Conn: c, reader: bufio.NewReaderSize(c, 512)}
+	start, err := pc.reader.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error reading PROXY protocol header")
+	}
+	if bytes.Equal(start, proxyV1Prefix) {
+		pc.remote, err = readProxyV1(pc.reader)
+	} else if bytes.Equal(start, proxyV2Signature[:len(start)]) {
+		pc.remote, err = readProxyV2(pc.reader)
+	} else {
+		err = eerrors.New("No PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, eerrors.Wrap(err, "Error reading PROXY v1 header")
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, eerrors.New("PROXY v1 header is too long")
+		}
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return nil, eerrors.New("Malformed PROXY v1 header")
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		// the connection was not proxied (eg. health check)
+		return nil, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, eerrors.New("Malformed PROXY v1 header")
+		}
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			return nil, eerrors.Errorf("Invalid source address in PROXY v1 header: '%s'", fields[2])
+		}
+		port, err := strconv.ParseUint(fields[4], 10, 16)
+		if err != nil {
+			return nil, eerrors.Errorf("Invalid source port in PROXY v1 header: '%s'", fields[4])
+		}
+		return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+	default:
+		return nil, eerrors.Errorf("Unknown protocol in PROXY v1 header: '%s'", fields[1])
+	}
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error reading PROXY v2 header")
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, eerrors.New("Invalid PROXY v2 signature")
+	}
+	if header[12]>>4 != 2 {
+		return nil, eerrors.Errorf("Unsupported PROXY protocol version: %d", header[12]>>4)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error reading PROXY v2 addresses")
+	}
+	switch command {
+	case 0x00:
+		// LOCAL: the connection was established by the proxy itself
+		return nil, nil
+	case 0x01:
+	default:
+		return nil, eerrors.Errorf("Unknown PROXY v2 command: %d", command)
+	}
+	switch family >> 4 {
+	case 0x01:
+		if len(payload) < 12 {
+			return nil, eerrors.New("PROXY v2 header is too short")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 0x02:
+		if len(payload) < 36 {
+			return nil, eerrors.New("PROXY v2 header is too short")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	default:
+		// AF_UNSPEC or AF_UNIX: keep the address of the proxy
+		return nil, nil
+	}
+}
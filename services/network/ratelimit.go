@@ -0,0 +1,122 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/utils"
+)
+
+// idle clients are forgotten after clientIdleTimeout
+const clientIdleTimeout = time.Minute
+
+// tokenBucket is a token bucket that can hold one second worth of tokens.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+}
+
+func (b *tokenBucket) refill(elapsed time.Duration) {
+	b.tokens += elapsed.Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+}
+
+func (b *tokenBucket) has(n float64) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	if n > b.rate {
+		// a message larger than the burst can only pass when the bucket is full
+		n = b.rate
+	}
+	return b.tokens >= n
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens -= n
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+}
+
+type clientBuckets struct {
+	messages tokenBucket
+	bytes    tokenBucket
+	last     time.Time
+}
+
+// clientLimiter limits the number of messages and bytes per second that each
+// client is allowed to send.
+type clientLimiter struct {
+	messagesRate float64
+	bytesRate    float64
+	clients      map[string]*clientBuckets
+	lastPurge    time.Time
+	sync.Mutex
+}
+
+func newClientLimiter(c conf.RateLimitConfig) *clientLimiter {
+	if c.MessagesPerSec <= 0 && c.BytesPerSec <= 0 {
+		return nil
+	}
+	return &clientLimiter{
+		messagesRate: c.MessagesPerSec,
+		bytesRate:    c.BytesPerSec,
+		clients:      make(map[string]*clientBuckets),
+		lastPurge:    time.Now(),
+	}
+}
+
+// Allow reports whether the client can send a message of the given size.
+func (l *clientLimiter) Allow(client string, size int) bool {
+	if l == nil {
+		return true
+	}
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastPurge) > clientIdleTimeout {
+		for c, b := range l.clients {
+			if now.Sub(b.last) > clientIdleTimeout {
+				delete(l.clients, c)
+			}
+		}
+		l.lastPurge = now
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &clientBuckets{
+			messages: tokenBucket{rate: l.messagesRate, tokens: l.messagesRate},
+			bytes:    tokenBucket{rate: l.bytesRate, tokens: l.bytesRate},
+			last:     now,
+		}
+		l.clients[client] = b
+	}
+	elapsed := now.Sub(b.last)
+	b.last = now
+	b.messages.refill(elapsed)
+	b.bytes.refill(elapsed)
+	if !b.messages.has(1) || !b.bytes.has(float64(size)) {
+		return false
+	}
+	b.messages.take(1)
+	b.bytes.take(float64(size))
+	return true
+}
+
+type clientLimiters map[utils.MyULID]*clientLimiter
+
+func (ls clientLimiters) get(confID utils.MyULID) *clientLimiter {
+	if ls == nil {
+		return nil
+	}
+	return ls[confID]
+}
@@ -3,13 +3,17 @@ package network
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/looplab/fsm"
@@ -82,9 +86,17 @@ type ackForwarder struct {
 	succ sync.Map
 	fail sync.Map
 	comm sync.Map
+	wins sync.Map
 	next uint32
 }
 
+// relpWindow tracks the number of syslog frames that have not been answered
+// yet for a RELP connection.
+type relpWindow struct {
+	size    int32
+	pending int32
+}
+
 func newAckForwarder() *ackForwarder {
 	return &ackForwarder{}
 }
@@ -108,10 +120,31 @@ func bytes2txnr(b []byte) int32 {
 	return int32(x)
 }
 
-func (f *ackForwarder) Received(connID utils.MyULID, txnr int32) {
+// Received registers a syslog frame from the client. It returns an error if
+// the client does not respect the negotiated window.
+func (f *ackForwarder) Received(connID utils.MyULID, txnr int32) error {
+	if w, ok := f.wins.Load(connID); ok {
+		win := w.(*relpWindow)
+		if atomic.AddInt32(&win.pending, 1) > win.size {
+			return eerrors.Errorf("RELP window exceeded (window = %d)", win.size)
+		}
+	}
 	if c, ok := f.comm.Load(connID); ok {
 		_ = c.(*intq.Ring).Put(txnr)
 	}
+	return nil
+}
+
+// Committed must be called when a response has been sent to the client.
+func (f *ackForwarder) Committed(connID utils.MyULID) {
+	if w, ok := f.wins.Load(connID); ok {
+		atomic.AddInt32(&w.(*relpWindow).pending, -1)
+	}
+}
+
+// SetWindow sets the window negotiated with the client.
+func (f *ackForwarder) SetWindow(connID utils.MyULID, size int32) {
+	f.wins.Store(connID, &relpWindow{size: size})
 }
 
 func (f *ackForwarder) NextToCommit(connID utils.MyULID) int32 {
@@ -202,12 +235,110 @@ func (f *ackForwarder) RemoveConn(connID utils.MyULID) {
 		f.fail.Delete(connID)
 	}
 	f.comm.Delete(connID)
+	f.wins.Delete(connID)
 }
 
 func (f *ackForwarder) RemoveAll() {
 	f.succ = sync.Map{}
 	f.fail = sync.Map{}
 	f.comm = sync.Map{}
+	f.wins = sync.Map{}
+}
+
+// relpOffers are the offers exchanged in the RELP 'open' command.
+type relpOffers struct {
+	version  string
+	software string
+	commands []string
+	window   int32
+}
+
+func parseRelpOffers(data []byte) (offers relpOffers, err error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var name, value string
+		if idx := strings.IndexByte(line, '='); idx >= 0 {
+			name, value = line[:idx], line[idx+1:]
+		} else {
+			name = line
+		}
+		switch name {
+		case "relp_version":
+			offers.version = value
+		case "relp_software":
+			offers.software = value
+		case "commands":
+			for _, command := range strings.Split(value, ",") {
+				command = strings.TrimSpace(command)
+				if len(command) > 0 {
+					offers.commands = append(offers.commands, command)
+				}
+			}
+		case "window":
+			offers.window, err = utils.Atoi32(value)
+			if err != nil || offers.window <= 0 {
+				return offers, eerrors.Errorf("Invalid RELP window offer: '%s'", value)
+			}
+		default:
+			// unknown offers must be ignored
+		}
+	}
+	return offers, nil
+}
+
+func (o relpOffers) has(command string) bool {
+	for _, c := range o.commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+func (o relpOffers) String() string {
+	s := fmt.Sprintf(
+		"relp_version=%s\nrelp_software=%s\ncommands=%s",
+		o.version, o.software, strings.Join(o.commands, ","),
+	)
+	if o.window > 0 {
+		s += fmt.Sprintf("\nwindow=%d", o.window)
+	}
+	return s
+}
+
+// negotiateRelpOffers computes our answer to the client offers. The
+// commands are restricted to those that both sides support, and the window
+// can not be larger than maxWindow.
+func negotiateRelpOffers(client relpOffers, startTLS bool, maxWindow int32) (relpOffers, error) {
+	if client.version != "0" {
+		return relpOffers{}, eerrors.Errorf("Unsupported RELP version: '%s'", client.version)
+	}
+	if !client.has("syslog") {
+		return relpOffers{}, eerrors.New("The client did not offer the 'syslog' command")
+	}
+	offers := relpOffers{
+		version:  "0",
+		software: "skewer",
+		commands: []string{"syslog"},
+		window:   maxWindow,
+	}
+	if startTLS && client.has("starttls") {
+		offers.commands = append(offers.commands, "starttls")
+	}
+	if client.window > 0 && client.window < maxWindow {
+		offers.window = client.window
+	}
+	return offers, nil
+}
+
+func relpMaxWindow(qsize uint64) int32 {
+	if qsize == 0 || qsize > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(qsize)
 }
 
 type meta struct {
@@ -243,6 +374,7 @@ func NewRelpService(env *base.ProviderEnv) (base.Provider, error) {
 	s.StreamingService.BaseService.Logger = env.Logger.New("class", "RelpServer")
 	s.StreamingService.BaseService.Binder = env.Binder
 	s.StreamingService.handler = RelpHandler{Server: &s}
+	s.StreamingService.provider = base.RELP
 	s.StreamingService.confined = env.Confined
 	return &s, nil
 }
@@ -277,10 +409,10 @@ func (s *RelpService) Start() ([]model.ListenerInfo, error) {
 
 	s.configs = make(map[utils.MyULID]conf.RELPSourceConfig, len(s.UnixListeners)+len(s.TCPListeners))
 	for _, l := range s.UnixListeners {
-		s.configs[l.Conf.ConfID] = conf.RELPSourceConfig(l.Conf)
+		s.configs[l.Conf.ConfID] = l.Conf.RELPConfig()
 	}
 	for _, l := range s.TCPListeners {
-		s.configs[l.Conf.ConfID] = conf.RELPSourceConfig(l.Conf)
+		s.configs[l.Conf.ConfID] = l.Conf.RELPConfig()
 	}
 
 	cpus := runtime.NumCPU()
@@ -323,19 +455,21 @@ func (s *RelpService) Stop() {
 func (s *RelpService) SetConf(c conf.BaseConfig) {
 	tcpConfigs := make([]conf.TCPSourceConfig, 0, len(c.RELPSource))
 	for _, c := range c.RELPSource {
-		tcpConfigs = append(tcpConfigs, conf.TCPSourceConfig(c))
+		tcpConfigs = append(tcpConfigs, c.TCPConfig())
 	}
 	s.StreamingService.SetConf(tcpConfigs, c.Parsers, c.Main.InputQueueSize, 132000)
+	if s.parserEnv != nil {
+		// stop the external parsers of the previous configuration
+		s.parserEnv.Close()
+	}
 	s.parserEnv = decoders.NewParsersEnv(c.Parsers, s.Logger)
 	s.rawQ = tcp.NewRing(c.Main.InputQueueSize)
 	s.ACKQueueSize = c.Main.InputQueueSize
 }
 
 func (s *RelpService) parseOne(raw *model.RawTCPMessage, gen *utils.Generator) error {
-	syslogMsgs, err := s.parserEnv.Parse(&raw.Decoder, raw.Message)
-	if err != nil {
-		return err
-	}
+	// the messages decoded before a parsing error are stashed too
+	syslogMsgs, parseErr := s.parserEnv.Parse(&raw.Decoder, raw.Message)
 
 	for _, syslogMsg := range syslogMsgs {
 		if syslogMsg == nil {
@@ -344,6 +478,7 @@ func (s *RelpService) parseOne(raw *model.RawTCPMessage, gen *utils.Generator) e
 
 		full := model.FullFactoryFrom(syslogMsg)
 		full.Txnr = raw.Txnr
+		full.ConnId = raw.ConnID
 		full.ConfId = raw.ConfID
 		full.Uid = gen.Uid()
 		full.SourceType = "relp"
@@ -362,7 +497,7 @@ func (s *RelpService) parseOne(raw *model.RawTCPMessage, gen *utils.Generator) e
 			}
 		}
 	}
-	return nil
+	return parseErr
 }
 
 func (s *RelpService) Parse() error {
@@ -392,17 +527,54 @@ func (s *RelpService) Parse() error {
 	}
 }
 
-func writeSuccess(conn net.Conn, txnr int32) (err error) {
+func writeSuccess(conn io.Writer, txnr int32) (err error) {
 	_, err = fmt.Fprintf(conn, "%d rsp 6 200 OK\n", txnr)
 	return err
 }
 
-func writeFailure(conn net.Conn, txnr int32) (err error) {
+func writeFailure(conn io.Writer, txnr int32) (err error) {
 	_, err = fmt.Fprintf(conn, "%d rsp 6 500 KO\n", txnr)
 	return err
 }
 
-func (s *RelpService) handleResponses(conn net.Conn, connID utils.MyULID, client string, logger log15.Logger) error {
+// relpWriter serializes the writes to the RELP client, and lets us switch
+// the underlying connection when the client asks for STARTTLS.
+type relpWriter struct {
+	sync.Mutex
+	conn net.Conn
+}
+
+func newRelpWriter(conn net.Conn) *relpWriter {
+	return &relpWriter{conn: conn}
+}
+
+func (w *relpWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	return w.conn.Write(p)
+}
+
+func (w *relpWriter) upgrade(conn net.Conn) {
+	w.Lock()
+	w.conn = conn
+	w.Unlock()
+}
+
+// startTLSConfig returns the TLS configuration used to upgrade RELP
+// connections, or nil if STARTTLS is not enabled.
+func startTLSConfig(config conf.TCPSourceConfig, confined bool) (*tls.Config, error) {
+	if !config.StartTLS || config.TLSEnabled {
+		return nil, nil
+	}
+	tlsConf, err := utils.NewTLSConfig("", config.CAFile, config.CAPath, config.CertFile, config.KeyFile, false, confined)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf.ClientAuth = config.GetClientAuthType()
+	return tlsConf, nil
+}
+
+func (s *RelpService) handleResponses(conn io.Writer, connID utils.MyULID, client string, logger log15.Logger) error {
 	successes := map[int32]bool{}
 	failures := map[int32]bool{}
 	var err error
@@ -464,6 +636,7 @@ func (s *RelpService) handleResponses(conn net.Conn, connID utils.MyULID, client
 
 			if err == nil {
 				next = -1
+				s.forwarder.Committed(connID)
 			} else if eerrors.HasFileClosed(err) {
 				return io.EOF // client is gone
 			} else if eerrors.IsTimeout(err) {
@@ -481,7 +654,7 @@ type RelpHandler struct {
 
 func (h RelpHandler) HandleConnection(conn net.Conn, c conf.TCPSourceConfig) (err error) {
 	// http://www.rsyslog.com/doc/relp.html
-	config := conf.RELPSourceConfig(c)
+	config := c.RELPConfig()
 	s := h.Server
 	s.AddConnection(conn)
 	connID := s.forwarder.AddConn(s.ACKQueueSize)
@@ -490,13 +663,18 @@ func (h RelpHandler) HandleConnection(conn net.Conn, c conf.TCPSourceConfig) (er
 	l.Info("New client")
 	defer l.Debug("Client gone away")
 	clientCounter(base.RELP, props)
+	tlsConf, err := startTLSConfig(c, s.confined)
+	if err != nil {
+		l.Warn("Error creating STARTTLS configuration", "error", err)
+	}
+	w := newRelpWriter(conn)
 
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		e := s.handleResponses(conn, connID, props.Client, l)
+		e := s.handleResponses(w, connID, props.Client, l)
 		if e != nil && !eerrors.HasFileClosed(e) {
 			s.Logger.Warn("Unexpected error in RELP handleResponses", "error", e, "connID", connID.String())
 		}
@@ -509,7 +687,7 @@ func (h RelpHandler) HandleConnection(conn net.Conn, c conf.TCPSourceConfig) (er
 			s.RemoveConnection(conn)
 			wg.Done()
 		}()
-		e := scan(l, s.forwarder, s.rawQ, conn, config.Timeout, config.ConfID, connID, s.MaxMessageSize, config.DecoderBaseConfig, props)
+		e := scan(l, s.forwarder, s.rawQ, conn, w, tlsConf, relpMaxWindow(s.ACKQueueSize), s.limiters.get(config.ConfID), config.Timeout, config.ConfID, connID, s.MaxMessageSize, config.DecoderBaseConfig, props)
 		if e != nil && !eerrors.HasFileClosed(e) {
 			err = eerrors.Wrap(e, "RELP scanning error")
 		}
@@ -519,21 +697,28 @@ func (h RelpHandler) HandleConnection(conn net.Conn, c conf.TCPSourceConfig) (er
 	return err
 }
 
-func scan(l log15.Logger, f *ackForwarder, rawq *tcp.Ring, c net.Conn, tout time.Duration, cfid, cnid utils.MyULID, msiz int, dc conf.DecoderBaseConfig, props tcpProps) (err error) {
+func newRelpScanner(c net.Conn) *utils.RecoverScanner {
+	scanner := utils.WithRecover(bufio.NewScanner(c))
+	scanner.Split(utils.RelpSplit)
+	scanner.Buffer(make([]byte, 0, 132000), 132000)
+	return scanner
+}
+
+func scan(l log15.Logger, f *ackForwarder, rawq *tcp.Ring, c net.Conn, w *relpWriter, tlsConf *tls.Config, win int32, lim *clientLimiter, tout time.Duration, cfid, cnid utils.MyULID, msiz int, dc conf.DecoderBaseConfig, props tcpProps) (err error) {
 	var previous = int32(-1)
 	var command string
 	var txnr int32
 	var splits [][]byte
 	var data []byte
+	var received bool
+	var offers relpOffers
 
-	machine := newMachine(l, f, rawq, c, cfid, cnid, msiz, dc, props)
+	machine := newMachine(l, f, rawq, w, tlsConf != nil, win, &offers, cfid, cnid, msiz, dc, props)
 
 	if tout > 0 {
 		_ = c.SetReadDeadline(time.Now().Add(tout))
 	}
-	scanner := utils.WithRecover(bufio.NewScanner(c))
-	scanner.Split(utils.RelpSplit)
-	scanner.Buffer(make([]byte, 0, 132000), 132000)
+	scanner := newRelpScanner(c)
 
 	for scanner.Scan() {
 		splits = bytes.SplitN(scanner.Bytes(), sp, 3)
@@ -553,6 +738,54 @@ func scan(l log15.Logger, f *ackForwarder, rawq *tcp.Ring, c net.Conn, tout time
 			data = bytes.TrimSpace(splits[2])
 		}
 
+		if command == "starttls" {
+			// the upgrade must happen before any syslog message is sent, so
+			// that there is no pending response on the plaintext connection
+			if tlsConf == nil || !offers.has("starttls") || received || machine.Current() != "opened" {
+				countRelpProtocolError(props.Client)
+				err = writeFailure(w, txnr)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err = writeSuccess(w, txnr)
+			if err != nil {
+				return err
+			}
+			tlsConn := tls.Server(c, tlsConf)
+			err = tlsConn.Handshake()
+			if err != nil {
+				countRelpProtocolError(props.Client)
+				return eerrors.Wrap(err, "STARTTLS handshake error")
+			}
+			c = tlsConn
+			w.upgrade(tlsConn)
+			scanner = newRelpScanner(c)
+			l.Debug("RELP connection upgraded to TLS")
+			if tout > 0 {
+				_ = c.SetReadDeadline(time.Now().Add(tout))
+			}
+			continue
+		}
+		if command == "syslog" {
+			received = true
+			if machine.Current() == "opened" && !lim.Allow(props.Client, len(data)) {
+				// the client is over its rate limit: answer with a failure
+				err = f.Received(cnid, txnr)
+				if err != nil {
+					countRelpProtocolError(props.Client)
+					return err
+				}
+				f.ForwardFail(cnid, txnr)
+				base.CountThrottledMessage(base.RELP, props.Client)
+				if tout > 0 {
+					_ = c.SetReadDeadline(time.Now().Add(tout))
+				}
+				continue
+			}
+		}
+
 		err = machine.Event(command, txnr, data)
 		if err != nil {
 			switch err.(type) {
@@ -566,8 +799,11 @@ func scan(l log15.Logger, f *ackForwarder, rawq *tcp.Ring, c net.Conn, tout time
 				countRelpProtocolError(props.Client)
 				return eerrors.Wrap(err, "Internal RELP state machine error")
 			case fsm.NoTransitionError:
-				// syslog does not change opened/closed state
-				// nothing to do
+				// syslog does not change opened/closed state, but the
+				// callback may have reported an error
+				if e := err.(fsm.NoTransitionError).Err; e != nil {
+					return e
+				}
 			default:
 				if eerrors.HasFileClosed(err) {
 					return io.EOF
@@ -586,7 +822,7 @@ func scan(l log15.Logger, f *ackForwarder, rawq *tcp.Ring, c net.Conn, tout time
 	return err
 }
 
-func newMachine(l log15.Logger, fwder *ackForwarder, rawq *tcp.Ring, conn io.Writer, confID, connID utils.MyULID, msiz int, dc conf.DecoderBaseConfig, props tcpProps) *fsm.FSM {
+func newMachine(l log15.Logger, fwder *ackForwarder, rawq *tcp.Ring, conn io.Writer, startTLS bool, win int32, offers *relpOffers, confID, connID utils.MyULID, msiz int, dc conf.DecoderBaseConfig, props tcpProps) *fsm.FSM {
 	factory := makeRawTCPFactory(props, confID, dc)
 	// TODO: PERF: fsm protects internal variables (states, events) with mutexes. We don't really need the mutexes here.
 	return fsm.NewFSM(
@@ -600,7 +836,12 @@ func newMachine(l log15.Logger, fwder *ackForwarder, rawq *tcp.Ring, conn io.Wri
 			"after_syslog": func(e *fsm.Event) {
 				txnr := e.Args[0].(int32)
 				data := e.Args[1].([]byte)
-				fwder.Received(connID, txnr)
+				err := fwder.Received(connID, txnr)
+				if err != nil {
+					countRelpProtocolError(props.Client)
+					e.Err = err
+					return
+				}
 				if len(data) == 0 {
 					fwder.ForwardSucc(connID, txnr)
 					return
@@ -613,7 +854,7 @@ func newMachine(l log15.Logger, fwder *ackForwarder, rawq *tcp.Ring, conn io.Wri
 				rawmsg := factory(data)
 				rawmsg.Txnr = txnr
 				rawmsg.ConnID = connID
-				err := rawq.Put(rawmsg)
+				err = rawq.Put(rawmsg)
 				if err != nil {
 					e.Err = eerrors.Fatal(eerrors.Wrap(err, "Failed to enqueue new raw RELP message"))
 					return
@@ -629,8 +870,22 @@ func newMachine(l log15.Logger, fwder *ackForwarder, rawq *tcp.Ring, conn io.Wri
 			"enter_opened": func(e *fsm.Event) {
 				txnr := e.Args[0].(int32)
 				data := e.Args[1].([]byte)
-				fmt.Fprintf(conn, "%d rsp %d 200 OK\n%s\n", txnr, len(data)+7, string(data))
 				l.Debug("Received 'open' command")
+				client, err := parseRelpOffers(data)
+				if err == nil {
+					*offers, err = negotiateRelpOffers(client, startTLS, win)
+				}
+				if err != nil {
+					countRelpProtocolError(props.Client)
+					msg := "500 " + err.Error()
+					fmt.Fprintf(conn, "%d rsp %d %s\n", txnr, len(msg), msg)
+					e.Err = err
+					return
+				}
+				fwder.SetWindow(connID, offers.window)
+				resp := offers.String()
+				fmt.Fprintf(conn, "%d rsp %d 200 OK\n%s\n", txnr, len(resp)+7, resp)
+				l.Debug("RELP session opened", "software", client.software, "commands", strings.Join(offers.commands, ","), "window", offers.window)
 			},
 		},
 	)
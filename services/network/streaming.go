@@ -37,6 +37,8 @@ type StreamingService struct {
 	wgroup         sync.WaitGroup
 	MaxMessageSize int
 	confined       bool
+	limiters       clientLimiters
+	provider       base.Types
 }
 
 func (s *StreamingService) init() {
@@ -143,25 +145,49 @@ func (s *StreamingService) AcceptUnix(lc UnixListenerConf) error {
 func (s *StreamingService) AcceptTCP(lc TCPListenerConf) error {
 	var wg sync.WaitGroup
 	defer wg.Wait()
+	filter := newClientFilter(lc.Conf.ListenersConfig)
 
 	for {
 		c, err := lc.Listener.Accept()
 		if err != nil {
 			return eerrors.Wrap(err, "Accept() error")
 		}
+		var tlsConf *tls.Config
 		if lc.Conf.TLSEnabled {
-			// upgrade connection to TLS
-			tlsConf, err := utils.NewTLSConfig("", lc.Conf.CAFile, lc.Conf.CAPath, lc.Conf.CertFile, lc.Conf.KeyFile, false, s.confined)
+			tlsConf, err = utils.NewTLSConfig("", lc.Conf.CAFile, lc.Conf.CAPath, lc.Conf.CertFile, lc.Conf.KeyFile, false, s.confined)
 			if err != nil {
 				s.Logger.Warn("Error creating TLS configuration", "error", err)
+				_ = c.Close()
 				continue
 			}
 			tlsConf.ClientAuth = lc.Conf.GetClientAuthType()
-			c = tls.Server(c, tlsConf)
+			if lc.Conf.Framing == conf.TCPRFC5425Framing {
+				// RFC 5425 mandates TLS 1.2
+				tlsConf.MinVersion = tls.VersionTLS12
+			}
 		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			if lc.Conf.ProxyProtocol {
+				// the PROXY header comes before the TLS handshake
+				pc, err := readProxyHeader(c)
+				if err != nil {
+					s.Logger.Warn("PROXY protocol error", "error", err, "proxy", c.RemoteAddr().String())
+					_ = c.Close()
+					return
+				}
+				c = pc
+			}
+			if !filter.Allow(c.RemoteAddr()) {
+				base.CountRejectedClient(s.provider, eprops(c).Client)
+				_ = c.Close()
+				return
+			}
+			if tlsConf != nil {
+				// upgrade connection to TLS
+				c = tls.Server(c, tlsConf)
+			}
 			err := s.handleConnection(c, lc.Conf)
 			if err != nil && !eerrors.HasFileClosed(err) {
 				s.Logger.Warn("TCP connection error", "error", err)
@@ -200,4 +226,8 @@ func (s *StreamingService) SetConf(sc []conf.TCPSourceConfig, pc []conf.ParserCo
 	s.MaxMessageSize = messageSize
 	s.BaseService.SetConf(pc, queueSize)
 	s.SourceConfigs = sc
+	s.limiters = make(clientLimiters, len(sc))
+	for _, c := range sc {
+		s.limiters[c.ConfID] = newClientLimiter(c.RateLimitConfig)
+	}
 }
@@ -47,6 +47,7 @@ func NewTcpService(env *base.ProviderEnv) (*TcpServiceImpl, error) {
 	s.StreamingService.BaseService.Logger = env.Logger.New("class", "TcpServer")
 	s.StreamingService.BaseService.Binder = env.Binder
 	s.StreamingService.handler = tcpHandler{Server: &s}
+	s.StreamingService.provider = base.TCP
 	s.StreamingService.confined = env.Confined
 	return &s, nil
 }
@@ -127,6 +128,10 @@ func (s *TcpServiceImpl) Stop() {
 func (s *TcpServiceImpl) SetConf(c conf.BaseConfig) {
 	s.StreamingService.SetConf(c.TCPSource, c.Parsers, c.Main.InputQueueSize, c.Main.MaxInputMessageSize)
 	s.rawMessagesQueue = tcp.NewRing(c.Main.InputQueueSize)
+	if s.parserEnv != nil {
+		// stop the external parsers of the previous configuration
+		s.parserEnv.Close()
+	}
 	s.parserEnv = decoders.NewParsersEnv(s.ParserConfigs, s.Logger)
 }
 
@@ -143,10 +148,8 @@ func logg(logger log15.Logger, raw *model.RawMessage) log15.Logger {
 }
 
 func (s *TcpServiceImpl) parseOne(raw *model.RawTCPMessage, gen *utils.Generator) error {
-	syslogMsgs, err := s.parserEnv.Parse(&raw.Decoder, raw.Message)
-	if err != nil {
-		return err
-	}
+	// the messages decoded before a parsing error are stashed too
+	syslogMsgs, parseErr := s.parserEnv.Parse(&raw.Decoder, raw.Message)
 
 	for _, syslogMsg := range syslogMsgs {
 		if syslogMsg == nil {
@@ -170,7 +173,7 @@ func (s *TcpServiceImpl) parseOne(raw *model.RawTCPMessage, gen *utils.Generator
 			}
 		}
 	}
-	return nil
+	return parseErr
 }
 
 // parse fetch messages from the raw queue, parse them, and push them to be sent.
@@ -232,6 +235,7 @@ func (h tcpHandler) HandleConnection(conn net.Conn, config conf.TCPSourceConfig)
 	logger := makeLogger(s.Logger, props, "tcp")
 	logger.Info("New client")
 	factory := makeRawTCPFactory(props, config.ConfID, config.DecoderBaseConfig)
+	limiter := s.limiters.get(config.ConfID)
 	clientCounter(base.TCP, props)
 
 	timeout := config.Timeout
@@ -240,11 +244,7 @@ func (h tcpHandler) HandleConnection(conn net.Conn, config conf.TCPSourceConfig)
 	}
 	scanner := utils.WithRecover(bufio.NewScanner(conn))
 	scanner.Buffer(make([]byte, 0, s.MaxMessageSize), s.MaxMessageSize)
-	if config.LineFraming {
-		scanner.Split(makeLFTCPSplit(config.FrameDelimiter))
-	} else {
-		scanner.Split(TcpSplit)
-	}
+	scanner.Split(makeTCPSplit(config.Framing, config.FrameDelimiter))
 
 	for scanner.Scan() {
 		if timeout > 0 {
@@ -257,6 +257,10 @@ func (h tcpHandler) HandleConnection(conn net.Conn, config conf.TCPSourceConfig)
 		if s.MaxMessageSize > 0 && len(buf) > s.MaxMessageSize {
 			return eerrors.Fatal(eerrors.Errorf("Raw TCP message too large: %d > %d", len(buf), s.MaxMessageSize))
 		}
+		if !limiter.Allow(props.Client, len(buf)) {
+			base.CountThrottledMessage(base.TCP, props.Client)
+			continue
+		}
 		err = s.rawMessagesQueue.Put(factory(buf))
 		if err != nil {
 			return eerrors.Fatal(eerrors.Wrap(err, "Failed to enqueue new raw TCP message"))
@@ -292,16 +296,45 @@ func makeLFTCPSplit(delimiter string) func(d []byte, a bool) (int, []byte, error
 	return f
 }
 
-func getline(data []byte, trimmed int, eoferr error) (int, []byte, error) {
-	lf := bytes.IndexByte(data, '\n')
-	if lf <= 0 {
-		return 0, nil, eoferr
+func makeTCPSplit(framing string, delimiter string) bufio.SplitFunc {
+	switch framing {
+	case conf.TCPLineFraming:
+		return makeLFTCPSplit(delimiter)
+	case conf.TCPOctetFraming:
+		return octetCountingSplit
+	case conf.TCPRFC5425Framing:
+		return rfc5425Split
+	default:
+		return makeAutoTCPSplit(delimiter)
 	}
-	token := bytes.Trim(data[0:lf], " \r\n")
-	return lf + trimmed + 1, token, nil
 }
 
-func TcpSplit(data []byte, atEOF bool) (advance int, token []byte, eoferr error) {
+// makeAutoTCPSplit detects the framing method from the first bytes that a
+// client sends, and then sticks to it for the rest of the connection.
+func makeAutoTCPSplit(delimiter string) bufio.SplitFunc {
+	var split bufio.SplitFunc
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if split == nil {
+			trimmedData := bytes.TrimLeft(data, " \r\n")
+			if len(trimmedData) == 0 {
+				if atEOF {
+					return 0, nil, io.EOF
+				}
+				return 0, nil, nil
+			}
+			if trimmedData[0] >= '1' && trimmedData[0] <= '9' {
+				// octet-counting: MSG-LEN SP SYSLOG-MSG
+				split = octetCountingSplit
+			} else {
+				// non-transparent framing
+				split = makeLFTCPSplit(delimiter)
+			}
+		}
+		return split(data, atEOF)
+	}
+}
+
+func octetCountingSplit(data []byte, atEOF bool) (advance int, token []byte, eoferr error) {
 	if atEOF {
 		eoferr = io.EOF
 	}
@@ -310,19 +343,16 @@ func TcpSplit(data []byte, atEOF bool) (advance int, token []byte, eoferr error)
 		return 0, nil, eoferr
 	}
 	trimmed := len(data) - len(trimmedData)
-	if trimmedData[0] == byte('<') {
-		return getline(trimmedData, trimmed, eoferr)
-	}
-	// octet counting framing?
-	sp := bytes.IndexAny(trimmedData, " \n")
+	sp := bytes.IndexByte(trimmedData, ' ')
 	if sp <= 0 {
+		if len(trimmedData) > 10 {
+			return 0, nil, eerrors.New("Invalid octet-counting frame: missing length")
+		}
 		return 0, nil, eoferr
 	}
-	datalenStr := bytes.Trim(trimmedData[0:sp], " \r\n")
-	datalen, err := strconv.Atoi(string(datalenStr))
-	if err != nil {
-		// the first part is not a number, so back to LF
-		return getline(trimmedData, trimmed, eoferr)
+	datalen, err := strconv.Atoi(string(trimmedData[0:sp]))
+	if err != nil || datalen < 0 {
+		return 0, nil, eerrors.Errorf("Invalid octet-counting frame: bad length '%s'", string(trimmedData[0:sp]))
 	}
 	advance = trimmed + sp + 1 + datalen
 	if len(data) < advance {
@@ -330,7 +360,36 @@ func TcpSplit(data []byte, atEOF bool) (advance int, token []byte, eoferr error)
 	}
 	token = bytes.Trim(trimmedData[sp+1:sp+1+datalen], " \r\n")
 	return advance, token, nil
+}
 
+// rfc5425Split is the strict version of octetCountingSplit: SYSLOG-FRAME =
+// MSG-LEN SP SYSLOG-MSG, with nothing between the frames.
+func rfc5425Split(data []byte, atEOF bool) (advance int, token []byte, eoferr error) {
+	if atEOF {
+		eoferr = io.EOF
+	}
+	if len(data) == 0 {
+		return 0, nil, eoferr
+	}
+	if data[0] < '1' || data[0] > '9' {
+		return 0, nil, eerrors.New("Invalid RFC 5425 frame: MSG-LEN must start with a non-zero digit")
+	}
+	sp := bytes.IndexByte(data, ' ')
+	if sp < 0 {
+		if len(data) > 10 {
+			return 0, nil, eerrors.New("Invalid RFC 5425 frame: missing length")
+		}
+		return 0, nil, eoferr
+	}
+	datalen, err := strconv.Atoi(string(data[0:sp]))
+	if err != nil {
+		return 0, nil, eerrors.Errorf("Invalid RFC 5425 frame: bad length '%s'", string(data[0:sp]))
+	}
+	advance = sp + 1 + datalen
+	if len(data) < advance {
+		return 0, nil, eoferr
+	}
+	return advance, data[sp+1 : advance], nil
 }
 
 type tcpProps struct {
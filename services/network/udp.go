@@ -33,6 +33,7 @@ type UdpServiceImpl struct {
 	fatalOnce        *sync.Once
 	parserEnv        *decoders.ParsersEnv
 	rawMessagesQueue *udp.Ring
+	limiters         clientLimiters
 }
 
 func NewUdpService(env *base.ProviderEnv) (*UdpServiceImpl, error) {
@@ -55,7 +56,15 @@ func (s *UdpServiceImpl) Type() base.Types {
 func (s *UdpServiceImpl) SetConf(c conf.BaseConfig) {
 	s.BaseService.SetConf(c.Parsers, c.Main.InputQueueSize)
 	s.UdpConfigs = c.UDPSource
+	s.limiters = make(clientLimiters, len(c.UDPSource))
+	for _, config := range c.UDPSource {
+		s.limiters[config.ConfID] = newClientLimiter(config.RateLimitConfig)
+	}
 	s.rawMessagesQueue = udp.NewRing(c.Main.InputQueueSize)
+	if s.parserEnv != nil {
+		// stop the external parsers of the previous configuration
+		s.parserEnv.Close()
+	}
 	s.parserEnv = decoders.NewParsersEnv(s.ParserConfigs, s.Logger)
 }
 
@@ -83,10 +92,8 @@ func (s *UdpServiceImpl) Parse() error {
 }
 
 func (s *UdpServiceImpl) ParseOne(raw *model.RawUDPMessage, gen *utils.Generator) error {
-	syslogMsgs, err := s.parserEnv.Parse(&raw.Decoder, raw.Message[:raw.Size])
-	if err != nil {
-		return err
-	}
+	// the messages decoded before a parsing error are stashed too
+	syslogMsgs, parseErr := s.parserEnv.Parse(&raw.Decoder, raw.Message[:raw.Size])
 
 	for _, syslogMsg := range syslogMsgs {
 		if syslogMsg == nil {
@@ -109,7 +116,7 @@ func (s *UdpServiceImpl) ParseOne(raw *model.RawUDPMessage, gen *utils.Generator
 			}
 		}
 	}
-	return nil
+	return parseErr
 }
 
 func (s *UdpServiceImpl) Gather() ([]*dto.MetricFamily, error) {
@@ -212,10 +219,27 @@ func (s *UdpServiceImpl) ListenPacket(c chan model.ListenerInfo) {
 			}
 		L:
 			for port, listenAddr := range listenAddrs {
-				conn, err := s.Binder.ListenPacket("udp", listenAddr, 65536)
-				if err != nil {
-					s.Logger.Warn("Listen UDP error", "error", err)
-					continue L
+				conns := make([]net.PacketConn, 0, syslogConf.Sockets)
+				if syslogConf.Sockets > 1 {
+					// the kernel balances the packets between the sockets
+					for i := 0; i < syslogConf.Sockets; i++ {
+						conn, err := s.Binder.ListenPacketReusePort("udp", listenAddr, 65536)
+						if err != nil {
+							s.Logger.Warn("Listen UDP error", "error", err)
+							for _, conn := range conns {
+								_ = conn.Close()
+							}
+							continue L
+						}
+						conns = append(conns, conn)
+					}
+				} else {
+					conn, err := s.Binder.ListenPacket("udp", listenAddr, 65536)
+					if err != nil {
+						s.Logger.Warn("Listen UDP error", "error", err)
+						continue L
+					}
+					conns = append(conns, conn)
 				}
 				s.Logger.Debug(
 					"UDP listener",
@@ -223,20 +247,23 @@ func (s *UdpServiceImpl) ListenPacket(c chan model.ListenerInfo) {
 					"bind_addr", syslogConf.BindAddr,
 					"port", port,
 					"format", syslogConf.Format,
+					"sockets", len(conns),
 				)
 				c <- model.ListenerInfo{
 					BindAddr: syslogConf.BindAddr,
 					Port:     port,
 					Protocol: "udp",
 				}
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					err := s.handleConnection(conn, syslogConf)
-					if err != nil && !eerrors.HasFileClosed(err) {
-						s.Logger.Warn("UDP connection error", "error", err)
-					}
-				}()
+				for _, conn := range conns {
+					wg.Add(1)
+					go func(conn net.PacketConn) {
+						defer wg.Done()
+						err := s.handleConnection(conn, syslogConf)
+						if err != nil && !eerrors.HasFileClosed(err) {
+							s.Logger.Warn("UDP connection error", "error", err)
+						}
+					}(conn)
+				}
 			}
 		}
 	}
@@ -251,6 +278,8 @@ func (s *UdpServiceImpl) handleConnection(conn net.PacketConn, config conf.UDPSo
 	s.AddConnection(conn)
 	defer s.RemoveConnection(conn)
 	defer s.Logger.Debug("End of UDP connection")
+	limiter := s.limiters.get(config.ConfID)
+	filter := newClientFilter(config.ListenersConfig)
 
 	local := conn.LocalAddr()
 	if local != nil {
@@ -284,6 +313,16 @@ func (s *UdpServiceImpl) handleConnection(conn net.PacketConn, config conf.UDPSo
 		} else {
 			rawmsg.Client = strings.Split(remote.String(), ":")[0]
 		}
+		if !filter.Allow(remote) {
+			base.CountRejectedClient(base.UDP, rawmsg.Client)
+			model.RawUDPFree(rawmsg)
+			continue
+		}
+		if !limiter.Allow(rawmsg.Client, rawmsg.Size) {
+			base.CountThrottledMessage(base.UDP, rawmsg.Client)
+			model.RawUDPFree(rawmsg)
+			continue
+		}
 		err = s.rawMessagesQueue.Put(rawmsg)
 		if err != nil {
 			return eerrors.WithTypes(eerrors.Wrap(err, "Failed to enqueue new raw UDP message"))
@@ -15,11 +15,14 @@ import (
 	"github.com/awnumar/memguard"
 	"github.com/gogo/protobuf/proto"
 	"github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stephane-martin/skewer/conf"
 	"github.com/stephane-martin/skewer/consul"
+	"github.com/stephane-martin/skewer/filters"
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/services/base"
+	"github.com/stephane-martin/skewer/store"
 	"github.com/stephane-martin/skewer/sys/capabilities"
 	"github.com/stephane-martin/skewer/sys/kring"
 	"github.com/stephane-martin/skewer/sys/namespaces"
@@ -40,6 +43,8 @@ var SHUTDOWN = []byte("shutdown")
 var STARTERROR = []byte("starterror")
 var GATHER = []byte("gathermetrics")
 var METRICS = []byte("metrics")
+var STOREADMIN = []byte("storeadmin")
+var ADMINRESULT = []byte("adminresult")
 var NOLISTENER = eerrors.New("no listener")
 
 // Controller launches and controls the various services by distinct processes.
@@ -55,6 +60,7 @@ type Controller struct {
 	registry *consul.Registry
 
 	metricsChan chan []*dto.MetricFamily
+	adminChan   chan store.AdminResponse
 	stdinMu     sync.Mutex
 	stdinWriter *utils.SigWriter
 	signKey     *memguard.LockedBuffer
@@ -104,6 +110,7 @@ func (f *CFactory) New(typ base.Types) (*Controller, error) {
 		signKey:      f.signKey,
 		ring:         f.ring,
 		metricsChan:  make(chan []*dto.MetricFamily),
+		adminChan:    make(chan store.AdminResponse),
 		ShutdownChan: make(chan struct{}),
 	}
 	return &s, nil
@@ -461,6 +468,20 @@ func (s *Controller) listen(secret *memguard.LockedBuffer) chan infosAndError {
 					kill = true
 					return
 				}
+			case "adminresult":
+				if len(parts) == 2 {
+					var resp store.AdminResponse
+					err := json.Unmarshal(parts[1], &resp)
+					if err != nil {
+						s.logger.Warn("Plugin returned an invalid admin result", "error", err)
+						continue
+					}
+					select {
+					case s.adminChan <- resp:
+					default:
+						// nobody waits for that result anymore
+					}
+				}
 			default:
 				err := eerrors.New("unexpected message from plugin")
 				s.logger.Error(err.Error(), "command", command)
@@ -570,6 +591,7 @@ type PluginCreateOpts struct {
 	confDir         string
 	acctPath        string
 	fileDestTmpl    string
+	deadLetterFile  string
 	certFiles       []string
 	certPaths       []string
 	polldirectories []string
@@ -611,6 +633,12 @@ func FileDestTmplOpt(path string) func(*PluginCreateOpts) {
 	}
 }
 
+func DeadLetterFileOpt(path string) func(*PluginCreateOpts) {
+	return func(opts *PluginCreateOpts) {
+		opts.deadLetterFile = path
+	}
+}
+
 func CertFilesOpt(list []string) func(*PluginCreateOpts) {
 	return func(opts *PluginCreateOpts) {
 		opts.certFiles = list
@@ -654,7 +682,8 @@ func (s *Controller) Create(optsfuncs ...func(*PluginCreateOpts)) error {
 		base.DirectRELP,
 		base.Graylog, base.KafkaSource, base.HTTPServer,
 		base.Accounting, base.MacOS, base.Journal,
-		base.Filesystem:
+		base.Filesystem, base.Kubernetes, base.Exec, base.PubSub,
+		base.JournalGateway, base.Stdin:
 
 		cname, _ := base.Name(s.typ, true)
 		// the plugin will use this pipe to report syslog messages
@@ -665,19 +694,22 @@ func (s *Controller) Create(optsfuncs ...func(*PluginCreateOpts)) error {
 			return eerrors.Wrap(err, "Error creating plugin pipe")
 		}
 		s.pipe = piper
+		cmdOpts := []func(*namespaces.CmdOpts){
+			namespaces.BinderHandle(base.BinderHdl(s.typ)),
+			namespaces.LoggerHandle(base.LoggerHdl(s.typ)),
+			namespaces.Pipe(pipew),
+		}
+		if s.typ == base.Stdin {
+			// the stdin plugin reads the standard input of skewer
+			cmdOpts = append(cmdOpts, namespaces.Input(os.Stdin))
+		}
 
 		// if creating the namespaces fails, fallback to classical start
 		// this way we can support environments where user namespaces are not
 		// available
 		//noinspection GoBoolExpressions
 		if capabilities.CapabilitiesSupported {
-			s.cmd, err = namespaces.SetupCmd(
-				cname,
-				s.ring,
-				namespaces.BinderHandle(base.BinderHdl(s.typ)),
-				namespaces.LoggerHandle(base.LoggerHdl(s.typ)),
-				namespaces.Pipe(pipew),
-			)
+			s.cmd, err = namespaces.SetupCmd(cname, s.ring, cmdOpts...)
 			if err != nil {
 				_ = piper.Close()
 				_ = pipew.Close()
@@ -699,13 +731,7 @@ func (s *Controller) Create(optsfuncs ...func(*PluginCreateOpts)) error {
 		}
 		//noinspection GoBoolExpressions
 		if err != nil || !capabilities.CapabilitiesSupported {
-			s.cmd, err = namespaces.SetupCmd(
-				s.name,
-				s.ring,
-				namespaces.BinderHandle(base.BinderHdl(s.typ)),
-				namespaces.LoggerHandle(base.LoggerHdl(s.typ)),
-				namespaces.Pipe(pipew),
-			)
+			s.cmd, err = namespaces.SetupCmd(s.name, s.ring, cmdOpts...)
 			if err != nil {
 				_ = piper.Close()
 				_ = pipew.Close()
@@ -750,6 +776,7 @@ func (s *Controller) Create(optsfuncs ...func(*PluginCreateOpts)) error {
 				Dumpable(opts.dumpable).
 				StorePath(opts.storePath).
 				FileDestTemplate(opts.fileDestTmpl).
+				DeadLetterFile(opts.deadLetterFile).
 				CertFiles(opts.certFiles).
 				CertPaths(opts.certPaths).
 				Start()
@@ -837,6 +864,11 @@ type StoreController struct {
 	msgsBatch []string
 	gen       *utils.Generator
 	pushwg    sync.WaitGroup
+
+	pipelinesMu sync.RWMutex
+	pipelines   *filters.Pipelines
+
+	adminMu sync.Mutex
 }
 
 func (s *StoreController) push(secret *memguard.LockedBuffer) {
@@ -885,6 +917,12 @@ func (s *StoreController) Stash(m *model.FullMessage) error {
 	if s.conf.Store.AddMissingMsgID && len(m.Fields.MsgId) == 0 {
 		m.Fields.MsgId = m.Uid.String()
 	}
+	s.pipelinesMu.RLock()
+	result := s.pipelines.Apply(m)
+	s.pipelinesMu.RUnlock()
+	if result == filters.DROPPED {
+		return nil
+	}
 	err := s.reserv.AddMessage(m)
 	if err != nil {
 		return eerrors.Wrap(err, "Failed to protobuf-marshal message to be sent to the Store")
@@ -901,6 +939,16 @@ func (s *StoreController) Start() (infos []model.ListenerInfo, err error) {
 		}
 	}
 
+	pipelines, err := filters.New(&s.conf, s.logger)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error setting up the filter stages")
+	}
+	s.pipelinesMu.Lock()
+	previous := s.pipelines
+	s.pipelines = pipelines
+	s.pipelinesMu.Unlock()
+	previous.Close()
+
 	infos, err = s.Controller.Start()
 	if err != nil {
 		return nil, err
@@ -912,3 +960,11 @@ func (s *StoreController) Start() (infos []model.ListenerInfo, err error) {
 	}()
 	return infos, nil
 }
+
+// Gather returns the metrics of the Store, and the metrics of the filter
+// stages.
+func (s *StoreController) Gather() ([]*dto.MetricFamily, error) {
+	filters.InitRegistry()
+	var gatherers prometheus.Gatherers = []prometheus.Gatherer{s.Controller, filters.Registry}
+	return gatherers.Gather()
+}
@@ -12,6 +12,7 @@ import (
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stephane-martin/skewer/conf"
 	"github.com/stephane-martin/skewer/services/base"
+	"github.com/stephane-martin/skewer/store"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
 )
@@ -20,6 +21,11 @@ var stdoutMu sync.Mutex
 var stdoutWriter *utils.EncryptWriter
 var emptyMetrics = make([]*dto.MetricFamily, 0)
 
+// adminProvider is implemented by the plugins that handle admin requests.
+type adminProvider interface {
+	Admin(req store.AdminRequest) store.AdminResponse
+}
+
 func init() {
 	stdoutWriter = utils.NewEncryptWriter(os.Stdout, nil)
 }
@@ -174,6 +180,27 @@ func Launch(ctx context.Context, typ base.Types, opts ...ProviderOpt) (err error
 			if err != nil {
 				return eerrors.Wrapf(err, "Provider '%s' can not write metrics to the controller", name)
 			}
+		case "storeadmin":
+			var resp store.AdminResponse
+			admin, ok := svc.(adminProvider)
+			if !ok {
+				resp.Error = "the plugin does not support admin requests"
+			} else if len(parts) != 2 {
+				resp.Error = "badly formatted admin request"
+			} else {
+				var req store.AdminRequest
+				err := json.Unmarshal(parts[1], &req)
+				if err != nil {
+					resp.Error = err.Error()
+				} else {
+					resp = admin.Admin(req)
+				}
+			}
+			respb, _ := json.Marshal(resp)
+			err = Wout(ADMINRESULT, respb)
+			if err != nil {
+				return eerrors.Wrapf(err, "Provider '%s' can not write the admin result to the controller", name)
+			}
 		default:
 			env.Logger.Crit("Unknown command", "type", name, "command", command)
 			return eerrors.Errorf("Unknown command '%s' received by plugin '%s'", command, name)
@@ -0,0 +1,436 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/decoders"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/services/base"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+const pubsubScope = "https://www.googleapis.com/auth/pubsub"
+const gceTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+func initPubSubRegistry() {
+	base.Once.Do(func() {
+		base.InitRegistry()
+	})
+}
+
+type pubsubMessage struct {
+	Data        string            `json:"data"`
+	Attributes  map[string]string `json:"attributes"`
+	MessageID   string            `json:"messageId"`
+	PublishTime time.Time         `json:"publishTime"`
+}
+
+type pubsubPullResponse struct {
+	ReceivedMessages []struct {
+		AckID   string        `json:"ackId"`
+		Message pubsubMessage `json:"message"`
+	} `json:"receivedMessages"`
+}
+
+type gcpTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type gcpServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// gcpToken caches an OAuth2 access token. The token is obtained either from a
+// service account key (JWT bearer flow), or from the GCE metadata server.
+type gcpToken struct {
+	client  *http.Client
+	account *gcpServiceAccount
+	key     *rsa.PrivateKey
+	value   string
+	expiry  time.Time
+	sync.Mutex
+}
+
+func newGCPToken(client *http.Client, credentialsFile string) (*gcpToken, error) {
+	t := &gcpToken{client: client}
+	if len(credentialsFile) == 0 {
+		return t, nil
+	}
+	content, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error reading the GCP credentials file")
+	}
+	var account gcpServiceAccount
+	err = json.Unmarshal(content, &account)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error decoding the GCP credentials file")
+	}
+	if len(account.TokenURI) == 0 {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, eerrors.New("No private key found in the GCP credentials file")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error parsing the GCP service account private key")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, eerrors.New("The GCP service account private key is not a RSA key")
+	}
+	t.account = &account
+	t.key = rsaKey
+	return t, nil
+}
+
+func (t *gcpToken) Get(ctx context.Context) (string, error) {
+	t.Lock()
+	defer t.Unlock()
+	if len(t.value) > 0 && time.Now().Add(time.Minute).Before(t.expiry) {
+		return t.value, nil
+	}
+	var req *http.Request
+	var err error
+	if t.account == nil {
+		req, err = http.NewRequest("GET", gceTokenURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	} else {
+		assertion, err := t.assertion()
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+		req, err = http.NewRequest("POST", t.account.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", eerrors.Wrap(err, "Error requesting a GCP access token")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", eerrors.Errorf("GCP token endpoint returned '%s': %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var token gcpTokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", eerrors.Wrap(err, "Error decoding the GCP access token")
+	}
+	t.value = token.AccessToken
+	t.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return t.value, nil
+}
+
+// assertion builds the signed JWT that is exchanged for an access token.
+func (t *gcpToken) assertion() (string, error) {
+	now := time.Now().Unix()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   t.account.ClientEmail,
+		"scope": pubsubScope,
+		"aud":   t.account.TokenURI,
+		"iat":   now,
+		"exp":   now + 3600,
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	h := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", eerrors.Wrap(err, "Error signing the GCP JWT assertion")
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// PubSubService pulls messages from Google Cloud Pub/Sub subscriptions. The
+// messages are acknowledged only after they have been written synchronously to
+// the pipe towards the Store.
+type PubSubService struct {
+	stasher        *base.Reporter
+	logger         log15.Logger
+	wgroup         sync.WaitGroup
+	Confs          []conf.PubSubSourceConfig
+	parserConfigs  []conf.ParserConfig
+	parserEnv      *decoders.ParsersEnv
+	client         *http.Client
+	cancel         context.CancelFunc
+	fatalErrorChan chan struct{}
+	fatalOnce      *sync.Once
+	confined       bool
+	sync.Mutex
+}
+
+func NewPubSubService(env *base.ProviderEnv) (base.Provider, error) {
+	initPubSubRegistry()
+	s := PubSubService{
+		stasher:  env.Reporter,
+		logger:   env.Logger.New("class", "pubsub"),
+		confined: env.Confined,
+	}
+	return &s, nil
+}
+
+func (s *PubSubService) Type() base.Types {
+	return base.PubSub
+}
+
+func (s *PubSubService) Gather() ([]*dto.MetricFamily, error) {
+	return base.Registry.Gather()
+}
+
+func (s *PubSubService) FatalError() chan struct{} {
+	return s.fatalErrorChan
+}
+
+func (s *PubSubService) dofatal() {
+	s.fatalOnce.Do(func() { close(s.fatalErrorChan) })
+}
+
+func (s *PubSubService) path(p string) string {
+	if s.confined && len(p) > 0 {
+		return filepath.Join("/tmp", "certfiles", p)
+	}
+	return p
+}
+
+func (s *PubSubService) Start() (infos []model.ListenerInfo, err error) {
+	infos = []model.ListenerInfo{}
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel != nil {
+		return infos, eerrors.New("already started")
+	}
+	s.fatalErrorChan = make(chan struct{})
+	s.fatalOnce = &sync.Once{}
+	s.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+	tokens := make([]*gcpToken, 0, len(s.Confs))
+	for _, config := range s.Confs {
+		token, err := newGCPToken(s.client, s.path(config.CredentialsFile))
+		if err != nil {
+			return infos, err
+		}
+		tokens = append(tokens, token)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for i, config := range s.Confs {
+		// the pullers bound the number of messages that are not acknowledged yet
+		for j := 0; j < config.Pullers; j++ {
+			s.wgroup.Add(1)
+			go s.loop(ctx, config, tokens[i])
+		}
+	}
+	return infos, nil
+}
+
+func (s *PubSubService) loop(ctx context.Context, config conf.PubSubSourceConfig, token *gcpToken) {
+	defer s.wgroup.Done()
+	logger := s.logger.New("project", config.Project, "subscription", config.Subscription)
+	gen := utils.NewGenerator()
+	for {
+		err := s.pull(ctx, config, token, gen, logger)
+		if ctx.Err() != nil {
+			return
+		}
+		if eerrors.Is("Fatal", err) {
+			logger.Error("Fatal error stashing message", "error", err)
+			s.dofatal()
+			return
+		}
+		if err != nil {
+			logger.Warn("Error pulling messages from Pub/Sub", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+func (s *PubSubService) call(ctx context.Context, config conf.PubSubSourceConfig, token *gcpToken, method string, body interface{}) (*http.Response, error) {
+	u := fmt.Sprintf(
+		"%s/v1/projects/%s/subscriptions/%s:%s",
+		strings.TrimRight(config.Endpoint, "/"),
+		url.PathEscape(config.Project),
+		url.PathEscape(config.Subscription),
+		method,
+	)
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	accessToken, err := token.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, eerrors.Errorf("Pub/Sub API returned '%s': %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (s *PubSubService) pull(ctx context.Context, config conf.PubSubSourceConfig, token *gcpToken, gen *utils.Generator, logger log15.Logger) error {
+	pctx, cancel := context.WithTimeout(ctx, config.PullTimeout)
+	resp, err := s.call(pctx, config, token, "pull", map[string]interface{}{"maxMessages": config.MaxMessages})
+	if err != nil {
+		cancel()
+		if pctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			// no message during the pull timeout
+			return nil
+		}
+		return err
+	}
+	var pulled pubsubPullResponse
+	err = json.NewDecoder(resp.Body).Decode(&pulled)
+	resp.Body.Close()
+	cancel()
+	if err != nil {
+		return eerrors.Wrap(err, "Error decoding the Pub/Sub pull response")
+	}
+	if len(pulled.ReceivedMessages) == 0 {
+		return nil
+	}
+
+	ackIDs := make([]string, 0, len(pulled.ReceivedMessages))
+	fulls := make([]*model.FullMessage, 0, len(pulled.ReceivedMessages))
+	for _, received := range pulled.ReceivedMessages {
+		fulls = s.handle(config, received.Message, gen, fulls)
+		ackIDs = append(ackIDs, received.AckID)
+	}
+	// the messages are acknowledged only when they have left the plugin
+	err = s.stasher.StashSync(fulls)
+	for _, full := range fulls {
+		model.FullFree(full)
+	}
+	if err != nil {
+		// not acknowledged: Pub/Sub will deliver the messages again
+		return err
+	}
+	for range fulls {
+		base.CountIncomingMessage(base.PubSub, config.Project, 0, config.Subscription)
+	}
+	resp, err = s.call(ctx, config, token, "acknowledge", map[string]interface{}{"ackIds": ackIDs})
+	if err != nil {
+		logger.Warn("Error acknowledging Pub/Sub messages", "error", err)
+	} else {
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// handle parses a Pub/Sub message, and appends the resulting messages to
+// fulls. The invalid messages are dropped.
+func (s *PubSubService) handle(config conf.PubSubSourceConfig, msg pubsubMessage, gen *utils.Generator, fulls []*model.FullMessage) []*model.FullMessage {
+	data, err := base64.StdEncoding.DecodeString(msg.Data)
+	if err != nil {
+		base.CountParsingError(base.PubSub, config.Project, config.Format)
+		s.logger.Warn("Invalid Pub/Sub message data", "error", err, "message_id", msg.MessageID)
+		return fulls
+	}
+	syslogMsgs, err := s.parserEnv.Parse(&config.DecoderBaseConfig, data)
+	if err != nil {
+		// the message would never be parsed successfully: just drop it, but
+		// keep the messages decoded before the error
+		base.CountParsingError(base.PubSub, config.Project, config.Format)
+		s.logger.Warn("Error parsing Pub/Sub message", "error", err, "message_id", msg.MessageID)
+	}
+	for _, syslogMsg := range syslogMsgs {
+		if syslogMsg == nil {
+			continue
+		}
+		full := model.FullFactoryFrom(syslogMsg)
+		full.Fields.SetProperty("pubsub", "subscription", config.Subscription)
+		full.Fields.SetProperty("pubsub", "message_id", msg.MessageID)
+		full.Fields.SetProperty("pubsub", "publish_time", msg.PublishTime.Format(time.RFC3339Nano))
+		for k, v := range msg.Attributes {
+			full.Fields.SetProperty("pubsub", "attr."+k, v)
+		}
+		full.ConfId = config.ConfID
+		full.Uid = gen.Uid()
+		full.SourceType = "pubsub"
+		full.SourcePath = config.Project + "/" + config.Subscription
+		fulls = append(fulls, full)
+	}
+	return fulls
+}
+
+func (s *PubSubService) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wgroup.Wait()
+	s.cancel = nil
+	s.logger.Info("pubsub source has been stopped")
+}
+
+func (s *PubSubService) Shutdown() {
+	s.Stop()
+}
+
+func (s *PubSubService) SetConf(c conf.BaseConfig) {
+	s.Lock()
+	s.Confs = c.PubSubSource
+	s.parserConfigs = c.Parsers
+	if s.parserEnv != nil {
+		// stop the external parsers of the previous configuration
+		s.parserEnv.Close()
+	}
+	s.parserEnv = decoders.NewParsersEnv(s.parserConfigs, s.logger)
+	s.Unlock()
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/inconshreveable/log15"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/decoders"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/services/base"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+func initStdinRegistry() {
+	base.Once.Do(func() {
+		base.InitRegistry()
+	})
+}
+
+// StdinService reads line-delimited messages from the standard input of skewer.
+type StdinService struct {
+	stasher        *base.Reporter
+	logger         log15.Logger
+	wgroup         sync.WaitGroup
+	Conf           conf.StdinSourceConfig
+	parserEnv      *decoders.ParsersEnv
+	input          *os.File
+	hostname       string
+	cancel         context.CancelFunc
+	fatalErrorChan chan struct{}
+	fatalOnce      *sync.Once
+	// the input can not be read again after a reload: lines is fed by a
+	// single goroutine for the life of the plugin
+	lines    chan []byte
+	readOnce sync.Once
+	sync.Mutex
+}
+
+func NewStdinService(env *base.ProviderEnv) (base.Provider, error) {
+	initStdinRegistry()
+	s := StdinService{
+		stasher: env.Reporter,
+		logger:  env.Logger.New("class", "stdin"),
+		input:   env.Input,
+		lines:   make(chan []byte),
+	}
+	s.hostname, _ = os.Hostname()
+	return &s, nil
+}
+
+func (s *StdinService) Type() base.Types {
+	return base.Stdin
+}
+
+func (s *StdinService) Gather() ([]*dto.MetricFamily, error) {
+	return base.Registry.Gather()
+}
+
+func (s *StdinService) FatalError() chan struct{} {
+	return s.fatalErrorChan
+}
+
+func (s *StdinService) dofatal() {
+	s.fatalOnce.Do(func() { close(s.fatalErrorChan) })
+}
+
+func (s *StdinService) Start() (infos []model.ListenerInfo, err error) {
+	infos = []model.ListenerInfo{}
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel != nil {
+		return infos, eerrors.New("already started")
+	}
+	if s.input == nil {
+		return infos, eerrors.New("the stdin plugin was not given the standard input")
+	}
+	s.fatalErrorChan = make(chan struct{})
+	s.fatalOnce = &sync.Once{}
+	maxLineSize := s.Conf.MaxLineSize
+	if maxLineSize <= 0 {
+		maxLineSize = 1024 * 1024
+	}
+	s.readOnce.Do(func() {
+		go s.read(maxLineSize)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wgroup.Add(1)
+	go s.parse(ctx)
+	return infos, nil
+}
+
+func (s *StdinService) read(maxLineSize int) {
+	defer close(s.lines)
+	reader := bufio.NewReaderSize(s.input, 65536)
+	for {
+		line, err := readLine(reader, maxLineSize)
+		if err == io.EOF {
+			s.logger.Info("stdin has been closed")
+			return
+		}
+		if err != nil {
+			s.logger.Warn("Error reading stdin", "error", err)
+			return
+		}
+		if len(line) == 0 {
+			continue
+		}
+		s.lines <- []byte(line)
+	}
+}
+
+func (s *StdinService) parse(ctx context.Context) {
+	defer s.wgroup.Done()
+	gen := utils.NewGenerator()
+	for {
+		var line []byte
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case line, ok = <-s.lines:
+		}
+		var err error
+		if ok {
+			err = s.parseOne(line, gen)
+		} else {
+			// send the incomplete auditd events
+			err = s.stash(s.parserEnv.Flush(), gen)
+		}
+		if err != nil {
+			base.CountParsingError(base.Stdin, s.hostname, s.Conf.Format)
+			s.logger.Warn(err.Error())
+			if eerrors.IsFatal(err) {
+				s.dofatal()
+				return
+			}
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+func (s *StdinService) parseOne(line []byte, gen *utils.Generator) error {
+	// the messages decoded before a parsing error are stashed too
+	syslogMsgs, parseErr := s.parserEnv.Parse(&s.Conf.DecoderBaseConfig, line)
+	err := s.stash(syslogMsgs, gen)
+	if err != nil {
+		return err
+	}
+	return parseErr
+}
+
+func (s *StdinService) stash(syslogMsgs []*model.SyslogMessage, gen *utils.Generator) error {
+	for _, syslogMsg := range syslogMsgs {
+		if syslogMsg == nil {
+			continue
+		}
+		full := model.FullFactoryFrom(syslogMsg)
+		full.SourceType = "stdin"
+		full.Uid = gen.Uid()
+		full.ConfId = s.Conf.ConfID
+		err := s.stasher.Stash(full)
+
+		model.FullFree(full)
+
+		if err != nil {
+			s.logger.Error("Error stashing stdin message", "error", err)
+			if eerrors.IsFatal(err) {
+				return eerrors.Wrap(err, "Fatal error pushing stdin message to the Store")
+			}
+			continue
+		}
+		base.CountIncomingMessage(base.Stdin, s.hostname, 0, "stdin")
+	}
+	return nil
+}
+
+func (s *StdinService) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wgroup.Wait()
+	s.cancel = nil
+	s.logger.Info("stdin source has been stopped")
+}
+
+func (s *StdinService) Shutdown() {
+	s.Stop()
+}
+
+func (s *StdinService) SetConf(c conf.BaseConfig) {
+	s.Lock()
+	defer s.Unlock()
+	s.Conf = c.Stdin
+	if s.parserEnv != nil {
+		// stop the external parsers of the previous configuration
+		s.parserEnv.Close()
+	}
+	s.parserEnv = decoders.NewParsersEnv(c.Parsers, s.logger)
+}
@@ -29,7 +29,7 @@ import (
 )
 
 type storeServiceImpl struct {
-	store            *store.MessageStore
+	store            store.Store
 	config           conf.BaseConfig
 	logger           log15.Logger
 	binder           binder.Client
@@ -126,14 +126,14 @@ func (s *storeServiceImpl) create() error {
 		return eerrors.Wrap(err, "Error storing configurations in store")
 	}
 
-	reserv := reservoir.NewReservoir(uint64(s.store.BatchSize))
+	reserv := reservoir.NewReservoir(uint64(s.config.Store.BatchSize))
 
 	// send messages to the store
 	s.ingestwg.Add(1)
 	go func() {
 		defer s.ingestwg.Done()
 
-		m := make(map[utils.MyULID]string, s.store.BatchSize)
+		m := make(map[utils.MyULID]string, s.config.Store.BatchSize)
 		w := waiter.Default()
 
 		for {
@@ -214,7 +214,7 @@ func (s *storeServiceImpl) stopAllForwarders() {
 func (s *storeServiceImpl) startForwarder(ctx context.Context, desttype conf.DestinationType) {
 	defer s.fwdersWg.Done()
 	s.logger.Info("Starting forwarder", "type", conf.DestinationNames[desttype])
-	forwarder := store.NewForwarder(desttype, s.store, s.config, s.logger, s.binder)
+	forwarder := store.NewForwarder(desttype, s.store, s.config, s.logger, s.binder, s.ring)
 	cb := circuit.NewConsecutiveBreaker(3)
 
 	for {
@@ -229,6 +229,7 @@ func (s *storeServiceImpl) startForwarder(ctx context.Context, desttype conf.Des
 			fcancel()
 			if err != circuit.ErrBreakerOpen {
 				s.logger.Error("Forwarder faced an error when creating destination", "dest", desttype, "error", err)
+				s.store.SetLastError(desttype, err)
 			}
 		} else {
 			// destination was successfully created
@@ -238,6 +239,7 @@ func (s *storeServiceImpl) startForwarder(ctx context.Context, desttype conf.Des
 				return
 			}
 			s.logger.Error("Forwarder error", "dest", desttype, "error", err)
+			s.store.SetLastError(desttype, err)
 		}
 		select {
 		case <-ctx.Done():
@@ -331,6 +333,17 @@ func (s *storeServiceImpl) Shutdown() {
 	_ = s.pipe.Close()
 }
 
+// Admin executes an admin request on the failed messages of the Store.
+func (s *storeServiceImpl) Admin(req store.AdminRequest) store.AdminResponse {
+	s.mu.Lock()
+	sto := s.store
+	s.mu.Unlock()
+	if sto == nil {
+		return store.AdminResponse{Error: "the Store is not started"}
+	}
+	return sto.Admin(req)
+}
+
 // Gather returns the metrics for the Store and the Kafka forwarder
 func (s *storeServiceImpl) Gather() ([]*dto.MetricFamily, error) {
 	var couple prometheus.Gatherers = []prometheus.Gatherer{store.Registry, dests.Registry}
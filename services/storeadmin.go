@@ -0,0 +1,121 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephane-martin/skewer/store"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// the Store has adminTimeout to answer an admin request
+const adminTimeout = 30 * time.Second
+
+// Admin sends an admin request to the Store process, and returns its
+// response.
+func (s *StoreController) Admin(req store.AdminRequest) (store.AdminResponse, error) {
+	s.adminMu.Lock()
+	defer s.adminMu.Unlock()
+
+	s.startedMu.Lock()
+	started := s.started
+	s.startedMu.Unlock()
+	if !started {
+		return store.AdminResponse{}, eerrors.New("the Store is not started")
+	}
+	reqb, err := json.Marshal(req)
+	if err != nil {
+		return store.AdminResponse{}, err
+	}
+	err = s.W(STOREADMIN, reqb)
+	if err != nil {
+		return store.AdminResponse{}, err
+	}
+	select {
+	case <-s.ShutdownChan:
+		return store.AdminResponse{}, eerrors.New("the Store has been shut down")
+	case <-time.After(adminTimeout):
+		return store.AdminResponse{}, eerrors.New("the Store did not answer the admin request")
+	case resp := <-s.adminChan:
+		return resp, nil
+	}
+}
+
+// AdminHandler serves the admin API for the failed messages:
+//
+//	GET  /admin/failed          lists the failed messages
+//	POST /admin/failed/requeue  sends the failed messages to their destination again
+//	POST /admin/failed/purge    deletes the failed messages
+//
+// The destination, limit and uid (can be repeated) query parameters select the
+// messages.
+func (s *StoreController) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := store.AdminRequest{
+			Destination: r.URL.Query().Get("destination"),
+		}
+		for _, uid := range r.URL.Query()["uid"] {
+			req.Uids = append(req.Uids, utils.MyULID(uid))
+		}
+		if limit := r.URL.Query().Get("limit"); len(limit) > 0 {
+			l, err := strconv.Atoi(limit)
+			if err != nil {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			req.Limit = l
+		}
+
+		switch strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/admin/failed") {
+		case "":
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			req.Action = store.AdminList
+		case "/" + store.AdminRequeue:
+			req.Action = store.AdminRequeue
+		case "/" + store.AdminPurge:
+			req.Action = store.AdminPurge
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if req.Action != store.AdminList && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp, err := s.Admin(req)
+		writeAdminResponse(w, resp, err)
+	})
+}
+
+// RotateKeyHandler serves POST /admin/rotate-key, that starts to encrypt the
+// stored messages again with the current store secret.
+func (s *StoreController) RotateKeyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resp, err := s.Admin(store.AdminRequest{Action: store.AdminRotateKey})
+		writeAdminResponse(w, resp, err)
+	})
+}
+
+func writeAdminResponse(w http.ResponseWriter, resp store.AdminResponse, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(resp.Error) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/db"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// the admin actions on the failed messages
+const (
+	AdminList    = "list"
+	AdminRequeue = "requeue"
+	AdminPurge   = "purge"
+)
+
+// AdminRotateKey encrypts the stored messages again with the current store
+// secret.
+const AdminRotateKey = "rotate-key"
+
+// the failed messages are listed up to defaultAdminLimit messages by default
+const defaultAdminLimit = 100
+
+// AdminRequest asks the store to list, requeue or purge the messages that
+// could not be delivered to a destination. An empty Destination means all
+// destinations. Empty Uids means all the failed messages.
+type AdminRequest struct {
+	Action      string         `json:"action"`
+	Destination string         `json:"destination,omitempty"`
+	Uids        []utils.MyULID `json:"uids,omitempty"`
+	Limit       int            `json:"limit,omitempty"`
+}
+
+// AdminResponse is the result of an AdminRequest. Count is the number of
+// listed, requeued or purged messages.
+type AdminResponse struct {
+	Count    int             `json:"count"`
+	Messages []FailedMessage `json:"messages,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// FailedMessage describes a message that a destination failed to deliver.
+// Queue is "failed" for the messages that will be tried again, and
+// "permerrors" for the messages that the destination rejected for good.
+type FailedMessage struct {
+	Uid         utils.MyULID              `json:"uid"`
+	Destination string                    `json:"destination"`
+	Queue       string                    `json:"queue"`
+	FailedAt    time.Time                 `json:"failed_at"`
+	Reason      string                    `json:"reason"`
+	LastError   string                    `json:"last_error,omitempty"`
+	Message     *model.RegularFullMessage `json:"message,omitempty"`
+}
+
+// lastErrors remembers the last error of each destination, so that the
+// failed messages can be listed with a hint of what went wrong.
+type lastErrors struct {
+	errs map[conf.DestinationType]string
+	sync.Mutex
+}
+
+func (l *lastErrors) set(dest conf.DestinationType, err error) {
+	l.Lock()
+	if l.errs == nil {
+		l.errs = make(map[conf.DestinationType]string)
+	}
+	l.errs[dest] = err.Error()
+	l.Unlock()
+}
+
+func (l *lastErrors) get(dest conf.DestinationType) string {
+	l.Lock()
+	defer l.Unlock()
+	return l.errs[dest]
+}
+
+// SetLastError records the last error faced by the forwarder of a
+// destination.
+func (s *MessageStore) SetLastError(dest conf.DestinationType, err error) {
+	if err != nil {
+		s.lastErrs.set(dest, err)
+	}
+}
+
+// Admin executes an admin request on the failed messages, or starts a key
+// rotation.
+func (s *MessageStore) Admin(req AdminRequest) (resp AdminResponse) {
+	queues, err := adminQueues(s.queues, req.Destination)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	switch req.Action {
+	case AdminList:
+		resp.Messages, err = s.listFailed(queues, req.Uids, adminLimit(req))
+		resp.Count = len(resp.Messages)
+	case AdminRequeue:
+		resp.Count, err = s.requeueFailed(queues, req.Uids)
+	case AdminPurge:
+		resp.Count, err = s.purgeFailed(queues, req.Uids)
+	case AdminRotateKey:
+		resp.Count, err = s.rotateKey()
+	default:
+		err = eerrors.Errorf("unknown action: '%s'", req.Action)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// adminQueues returns the queues of the destination of an admin request, or
+// all the queues when the destination is empty.
+func adminQueues(all map[conf.DestinationType]*destQueue, destination string) ([]*destQueue, error) {
+	var queues []*destQueue
+	if len(destination) == 0 {
+		for _, dest := range conf.Destinations {
+			queues = append(queues, all[dest])
+		}
+		return queues, nil
+	}
+	dest, ok := conf.Destinations[destination]
+	if !ok {
+		return nil, eerrors.New("unknown destination: " + destination)
+	}
+	return append(queues, all[dest]), nil
+}
+
+func adminLimit(req AdminRequest) int {
+	if req.Limit <= 0 {
+		return defaultAdminLimit
+	}
+	return req.Limit
+}
+
+func newFailedMessage(uid utils.MyULID, q *destQueue, qname string, failedAt time.Time, lastError string) FailedMessage {
+	m := FailedMessage{
+		Uid:         uid,
+		Destination: q.name,
+		Queue:       qname,
+		FailedAt:    failedAt,
+		LastError:   lastError,
+	}
+	if qname == "failed" {
+		m.Reason = "delivery failed, the message will be tried again"
+	} else {
+		m.Reason = "the destination rejected the message, or the message could not be encoded"
+	}
+	return m
+}
+
+// sortFailed sorts the failed messages by uid, and keeps the first limit
+// messages.
+func sortFailed(result []FailedMessage, limit int) []FailedMessage {
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Uid < result[j].Uid
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// failedEntries returns the messages of a failed or permerrors partition,
+// with the time of the failure.
+func failedEntries(partition db.Partition, uids []utils.MyULID, txn *db.NTransaction) (map[utils.MyULID]time.Time, error) {
+	entries := make(map[utils.MyULID]time.Time)
+	if len(uids) == 0 {
+		uids = partition.ListKeys(txn)
+	}
+	for _, uid := range uids {
+		value, err := partition.Get(uid, nil, txn)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// both queues store the failure time as a varint of nanoseconds
+		t, n := binary.Varint(value)
+		if n <= 0 {
+			entries[uid] = time.Time{}
+			continue
+		}
+		entries[uid] = time.Unix(0, t).UTC()
+	}
+	return entries, nil
+}
+
+func (s *MessageStore) listFailed(queues []*destQueue, uids []utils.MyULID, limit int) ([]FailedMessage, error) {
+	txn := db.NewNTransaction(s.badger, false)
+	defer txn.Discard()
+
+	var result []FailedMessage
+	for _, q := range queues {
+		lastError := s.lastErrs.get(q.dest)
+		partitions := map[string]db.Partition{"failed": q.failed, "permerrors": q.permerrors}
+		for qname, partition := range partitions {
+			entries, err := failedEntries(partition, uids, txn)
+			if err != nil {
+				return nil, err
+			}
+			for uid, failedAt := range entries {
+				result = append(result, newFailedMessage(uid, q, qname, failedAt, lastError))
+			}
+		}
+	}
+	result = sortFailed(result, limit)
+
+	for i := range result {
+		content, err := s.backend.Messages.Get(result[i].Uid, nil, txn)
+		if err != nil || content == nil {
+			continue
+		}
+		msg, err := decodeStored(content)
+		if err != nil {
+			s.logger.Debug("Invalid failed message", "uid", result[i].Uid, "error", err)
+			continue
+		}
+		result[i].Message = msg.Regular()
+		model.FullFree(msg)
+	}
+	return result, nil
+}
+
+// requeueFailed moves the failed messages back to the ready queue of their
+// destination.
+func (s *MessageStore) requeueFailed(queues []*destQueue, uids []utils.MyULID) (total int, err error) {
+	for _, q := range queues {
+		var failed, permerrors int
+		for {
+			failed, permerrors, err = requeueHelper(s.badger, q, uids)
+			if err != badger.ErrConflict {
+				break
+			}
+		}
+		if err != nil {
+			return total, eerrors.Wrapf(err, "Failed to requeue the messages of destination '%s'", q.name)
+		}
+		badgerGauge.WithLabelValues("failed", q.name).Sub(float64(failed))
+		badgerGauge.WithLabelValues("permerrors", q.name).Sub(float64(permerrors))
+		badgerGauge.WithLabelValues("ready", q.name).Add(float64(failed + permerrors))
+		if failed+permerrors > 0 {
+			q.zeroMsg.Store(false)
+		}
+		total += failed + permerrors
+	}
+	if total > 0 {
+		s.logger.Info("Requeued failed messages", "nb", total)
+	}
+	return total, nil
+}
+
+func requeueHelper(badg *badger.DB, q *destQueue, uids []utils.MyULID) (failed int, permerrors int, err error) {
+	txn := db.NewNTransaction(badg, true)
+	defer txn.Discard()
+
+	move := func(partition db.Partition) (int, error) {
+		entries, err := failedEntries(partition, uids, txn)
+		if err != nil {
+			return 0, err
+		}
+		for uid := range entries {
+			err = partition.Delete(uid, txn)
+			if err != nil {
+				return 0, err
+			}
+			err = q.ready.Set(uid, "true", txn)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return len(entries), nil
+	}
+
+	failed, err = move(q.failed)
+	if err != nil {
+		return 0, 0, err
+	}
+	permerrors, err = move(q.permerrors)
+	if err != nil {
+		return 0, 0, err
+	}
+	return failed, permerrors, txn.Commit(nil)
+}
+
+// purgeFailed deletes the failed messages. The content of a message is
+// deleted by the next purge, when no other destination references it.
+func (s *MessageStore) purgeFailed(queues []*destQueue, uids []utils.MyULID) (total int, err error) {
+	for _, q := range queues {
+		var failed, permerrors []utils.MyULID
+		for {
+			failed, permerrors, err = purgeHelper(s.badger, q, uids)
+			if err != badger.ErrConflict {
+				break
+			}
+		}
+		if err != nil {
+			return total, eerrors.Wrapf(err, "Failed to purge the messages of destination '%s'", q.name)
+		}
+		badgerGauge.WithLabelValues("failed", q.name).Sub(float64(len(failed)))
+		badgerGauge.WithLabelValues("permerrors", q.name).Sub(float64(len(permerrors)))
+		for _, uid := range failed {
+			s.count.Dec(uid)
+		}
+		for _, uid := range permerrors {
+			s.count.Dec(uid)
+		}
+		total += len(failed) + len(permerrors)
+	}
+	if total > 0 {
+		s.logger.Info("Purged failed messages", "nb", total)
+	}
+	return total, nil
+}
+
+func purgeHelper(badg *badger.DB, q *destQueue, uids []utils.MyULID) (failed []utils.MyULID, permerrors []utils.MyULID, err error) {
+	txn := db.NewNTransaction(badg, true)
+	defer txn.Discard()
+
+	remove := func(partition db.Partition) ([]utils.MyULID, error) {
+		entries, err := failedEntries(partition, uids, txn)
+		if err != nil {
+			return nil, err
+		}
+		removed := make([]utils.MyULID, 0, len(entries))
+		for uid := range entries {
+			removed = append(removed, uid)
+		}
+		return removed, partition.DeleteMany(removed, txn)
+	}
+
+	failed, err = remove(q.failed)
+	if err != nil {
+		return nil, nil, err
+	}
+	permerrors, err = remove(q.permerrors)
+	if err != nil {
+		return nil, nil, err
+	}
+	return failed, permerrors, txn.Commit(nil)
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/utils/db"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// BackupInfo is the integrity metadata of a store backup: the size and the
+// SHA256 of the badger backup stream, and the number of messages in each
+// queue when the backup was made.
+type BackupInfo struct {
+	Created  time.Time      `json:"created"`
+	Size     int64          `json:"size"`
+	SHA256   string         `json:"sha256"`
+	Messages int            `json:"messages"`
+	Queues   map[string]int `json:"queues"`
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Backup writes a snapshot of a store that is not in use by a running skewer
+// to w, and returns its integrity metadata.
+func Backup(cfg conf.StoreConfig, w io.Writer) (*BackupInfo, error) {
+	err := badgerOnly(cfg)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := badger.Open(badgerOptions(cfg, cfg.Dirname))
+	if err != nil {
+		return nil, eerrors.Wrap(err, "failed to open the badger database")
+	}
+	defer func() { _ = kv.Close() }()
+	bend, err := NewBackend(kv, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BackupInfo{
+		Created: time.Now().UTC(),
+		Queues:  make(map[string]int),
+	}
+	txn := db.NewNTransaction(kv, false)
+	info.Messages = bend.Messages.Count(txn)
+	for qtype, qname := range map[QueueType]string{Ready: "ready", Sent: "sent", Failed: "failed", PermErrors: "permerrors"} {
+		for _, dest := range conf.Destinations {
+			nb := bend.GetPartition(qtype, dest).Count(txn)
+			if nb > 0 {
+				info.Queues[qname+"/"+conf.DestinationNames[dest]] = nb
+			}
+		}
+	}
+	txn.Discard()
+
+	h := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(w, h)}
+	_, err = kv.Backup(counter, 0)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Failed to backup the badger database")
+	}
+	info.Size = counter.n
+	info.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return info, nil
+}
+
+// Restore loads a snapshot made by Backup into an empty store. The snapshot
+// should be checked against its metadata with Verify first.
+func Restore(cfg conf.StoreConfig, r io.Reader) error {
+	err := badgerOnly(cfg)
+	if err != nil {
+		return err
+	}
+	kv, err := badger.Open(badgerOptions(cfg, cfg.Dirname))
+	if err != nil {
+		return eerrors.Wrap(err, "failed to open the badger database")
+	}
+	bend, err := NewBackend(kv, nil)
+	if err != nil {
+		_ = kv.Close()
+		return err
+	}
+	txn := db.NewNTransaction(kv, false)
+	nb := bend.Whole.Count(txn)
+	txn.Discard()
+	if nb > 0 {
+		_ = kv.Close()
+		return eerrors.Errorf("The store in '%s' is not empty", cfg.Dirname)
+	}
+	err = kv.Load(r)
+	if err != nil {
+		_ = kv.Close()
+		return eerrors.Wrap(err, "Failed to load the backup")
+	}
+	return kv.Close()
+}
+
+// Verify checks that a snapshot matches its integrity metadata.
+func Verify(r io.Reader, info *BackupInfo) error {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	if n != info.Size {
+		return eerrors.Errorf("The backup size is %d bytes, %d expected", n, info.Size)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != info.SHA256 {
+		return eerrors.New("The backup checksum does not match")
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package store
+
+import (
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Admin executes an admin request on the failed messages, or starts a key
+// rotation.
+func (s *BoltStore) Admin(req AdminRequest) (resp AdminResponse) {
+	queues, err := adminQueues(s.queues, req.Destination)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	switch req.Action {
+	case AdminList:
+		resp.Messages, err = s.listFailed(queues, req.Uids, adminLimit(req))
+		resp.Count = len(resp.Messages)
+	case AdminRequeue:
+		resp.Count, err = s.requeueFailed(queues, req.Uids)
+	case AdminPurge:
+		resp.Count, err = s.purgeFailed(queues, req.Uids)
+	case AdminRotateKey:
+		resp.Count, err = s.rotateKey()
+	default:
+		err = eerrors.Errorf("unknown action: '%s'", req.Action)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// boltFailedEntries returns the messages of a failed or permerrors bucket,
+// with the time of the failure.
+func boltFailedEntries(b *bolt.Bucket, uids []utils.MyULID) map[utils.MyULID]time.Time {
+	entries := make(map[utils.MyULID]time.Time)
+	add := func(k, v []byte) error {
+		t, n := utils.Bytes2Time(v)
+		if n <= 0 {
+			t = time.Time{}
+		}
+		entries[utils.MyULID(k)] = t
+		return nil
+	}
+	if len(uids) == 0 {
+		_ = b.ForEach(add)
+		return entries
+	}
+	for _, uid := range uids {
+		if v := b.Get([]byte(uid)); v != nil {
+			_ = add([]byte(uid), v)
+		}
+	}
+	return entries
+}
+
+var boltFailedQueues = map[string]QueueType{"failed": Failed, "permerrors": PermErrors}
+
+func (s *BoltStore) listFailed(queues []*destQueue, uids []utils.MyULID, limit int) (result []FailedMessage, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		for _, q := range queues {
+			lastError := s.lastErrs.get(q.dest)
+			for qname, qtype := range boltFailedQueues {
+				entries := boltFailedEntries(tx.Bucket(boltBucket(qtype, q.dest)), uids)
+				for uid, failedAt := range entries {
+					result = append(result, newFailedMessage(uid, q, qname, failedAt, lastError))
+				}
+			}
+		}
+		result = sortFailed(result, limit)
+
+		msgs := tx.Bucket(boltMessages)
+		for i := range result {
+			msg, err := s.decode(msgs.Get([]byte(result[i].Uid)))
+			if err != nil {
+				s.logger.Debug("Invalid failed message", "uid", result[i].Uid, "error", err)
+				continue
+			}
+			result[i].Message = msg.Regular()
+			model.FullFree(msg)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// requeueFailed moves the failed messages back to the ready queue of their
+// destination.
+func (s *BoltStore) requeueFailed(queues []*destQueue, uids []utils.MyULID) (total int, err error) {
+	for _, q := range queues {
+		moved := make(map[string]int, len(boltFailedQueues))
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			ready := tx.Bucket(boltBucket(Ready, q.dest))
+			for qname, qtype := range boltFailedQueues {
+				b := tx.Bucket(boltBucket(qtype, q.dest))
+				entries := boltFailedEntries(b, uids)
+				for uid := range entries {
+					err := b.Delete([]byte(uid))
+					if err != nil {
+						return err
+					}
+					err = ready.Put([]byte(uid), boltTrue)
+					if err != nil {
+						return err
+					}
+				}
+				moved[qname] = len(entries)
+			}
+			return nil
+		})
+		if err != nil {
+			return total, eerrors.Wrapf(err, "Failed to requeue the messages of destination '%s'", q.name)
+		}
+		nb := 0
+		for qname, n := range moved {
+			badgerGauge.WithLabelValues(qname, q.name).Sub(float64(n))
+			nb += n
+		}
+		badgerGauge.WithLabelValues("ready", q.name).Add(float64(nb))
+		if nb > 0 {
+			q.zeroMsg.Store(false)
+		}
+		total += nb
+	}
+	if total > 0 {
+		s.logger.Info("Requeued failed messages", "nb", total)
+	}
+	return total, nil
+}
+
+// purgeFailed deletes the failed messages, and their content when no other
+// destination references it.
+func (s *BoltStore) purgeFailed(queues []*destQueue, uids []utils.MyULID) (total int, err error) {
+	for _, q := range queues {
+		purged := make(map[string]int, len(boltFailedQueues))
+		var nbDeleted int
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			for qname, qtype := range boltFailedQueues {
+				b := tx.Bucket(boltBucket(qtype, q.dest))
+				entries := boltFailedEntries(b, uids)
+				for uid := range entries {
+					err := b.Delete([]byte(uid))
+					if err != nil {
+						return err
+					}
+					deleted, err := deleteUnreferenced(tx, []byte(uid))
+					if err != nil {
+						return err
+					}
+					if deleted {
+						nbDeleted++
+					}
+				}
+				purged[qname] = len(entries)
+			}
+			return nil
+		})
+		if err != nil {
+			return total, eerrors.Wrapf(err, "Failed to purge the messages of destination '%s'", q.name)
+		}
+		for qname, n := range purged {
+			badgerGauge.WithLabelValues(qname, q.name).Sub(float64(n))
+			total += n
+		}
+		badgerGauge.WithLabelValues("messages", "").Sub(float64(nbDeleted))
+	}
+	if total > 0 {
+		s.logger.Info("Purged failed messages", "nb", total)
+	}
+	return total, nil
+}
+
+// rotateKey starts to encrypt the stored messages with the current store
+// secret, in the background. It returns the number of messages that will be
+// checked.
+func (s *BoltStore) rotateKey() (int, error) {
+	if s.keys == nil {
+		return 0, eerrors.New("The store is not encrypted")
+	}
+	if !s.rotating.CAS(false, true) {
+		return 0, eerrors.New("A key rotation is already running")
+	}
+	select {
+	case <-s.done:
+		s.rotating.Store(false)
+		return 0, eerrors.New("The store is shutting down")
+	default:
+	}
+
+	var uids [][]byte
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		uids = bucketKeys(tx.Bucket(boltMessages), 0)
+		return nil
+	})
+
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			s.rotating.Store(false)
+			s.wg.Done()
+		}()
+		var total int
+		for start := 0; start < len(uids); start += rotateBatchSize {
+			select {
+			case <-s.done:
+				s.logger.Info("Key rotation interrupted by shutdown", "reencrypted", total)
+				return
+			default:
+			}
+			end := start + rotateBatchSize
+			if end > len(uids) {
+				end = len(uids)
+			}
+			nb, err := s.reencrypt(uids[start:end])
+			if err != nil {
+				s.logger.Error("Key rotation failed", "error", err, "reencrypted", total)
+				return
+			}
+			total += nb
+		}
+		s.logger.Info("Key rotation done", "reencrypted", total, "checked", len(uids))
+	}()
+	return len(uids), nil
+}
+
+func (s *BoltStore) reencrypt(uids [][]byte) (nb int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		nb = 0
+		msgs := tx.Bucket(boltMessages)
+		for _, uid := range uids {
+			value := msgs.Get(uid)
+			if len(value) == 0 || s.keys.IsCurrent(value) {
+				// deleted meanwhile, or already encrypted with the current secret
+				continue
+			}
+			plain, err := s.keys.DecryptTo(value, nil)
+			if err != nil {
+				return eerrors.Wrapf(err, "Failed to decrypt message '%s'", utils.MyULID(uid))
+			}
+			value, err = s.keys.EncryptTo(plain, nil)
+			if err != nil {
+				return err
+			}
+			err = msgs.Put(uid, value)
+			if err != nil {
+				return err
+			}
+			nb++
+		}
+		return nil
+	})
+	return nb, err
+}
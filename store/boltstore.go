@@ -0,0 +1,707 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/sys/kring"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/db"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/queue"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/atomic"
+)
+
+// boltFilename is the name of the BoltDB file in the store directory
+const boltFilename = "skewer.bolt"
+
+var boltMessages = []byte("messages")
+var boltConfigs = []byte("configs")
+var boltTrue = []byte("true")
+
+// boltBucket returns the name of the bucket of a queue. The names are the
+// prefixes of the badger partitions.
+func boltBucket(qtype QueueType, dest conf.DestinationType) []byte {
+	return []byte(getPartitionPrefix(qtype, dest))
+}
+
+// BoltStore is a store backend on top of BoltDB. It needs much less memory
+// than badger, so it suits small hosts.
+//
+// The content of a message is deleted as soon as no queue references it
+// anymore, so BoltStore needs neither reference counting nor garbage
+// collection. The eviction of messages and the disk quota are not supported.
+type BoltStore struct {
+	db           *bolt.DB
+	keys         *db.Keyring
+	logger       log15.Logger
+	dests        *Destinations
+	queues       map[conf.DestinationType]*destQueue
+	batchSize    uint32
+	ackBatchSize uint32
+	ackLatency   time.Duration
+	confined     bool
+	lastErrs     lastErrors
+	rotating     *atomic.Bool
+	done         <-chan struct{}
+
+	wg             sync.WaitGroup
+	closedChan     chan struct{}
+	FatalErrorChan chan struct{}
+}
+
+func newBoltStore(ctx context.Context, cfg conf.StoreConfig, dirname string, r kring.Ring, dests conf.DestinationType, cfnd bool, l log15.Logger) (*BoltStore, error) {
+	logger := l.New("class", "BoltStore")
+	secret, oldSecrets, err := storeSecrets(cfg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &bolt.Options{Timeout: time.Second, NoSync: !cfg.FSync}
+	bdb, err := bolt.Open(filepath.Join(dirname, boltFilename), 0600, opts)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "failed to open the bolt database")
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		names := [][]byte{boltMessages, boltConfigs}
+		for qtype := range Queues {
+			for _, dest := range conf.Destinations {
+				names = append(names, boltBucket(qtype, dest))
+			}
+		}
+		for _, name := range names {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = bdb.Close()
+		return nil, eerrors.Wrap(err, "failed to create the bolt buckets")
+	}
+
+	store := &BoltStore{
+		db:             bdb,
+		logger:         logger,
+		dests:          &Destinations{},
+		queues:         make(map[conf.DestinationType]*destQueue, len(conf.Destinations)),
+		batchSize:      cfg.BatchSize,
+		ackBatchSize:   cfg.AckBatchSize,
+		ackLatency:     cfg.AckLatency,
+		confined:       cfnd,
+		rotating:       atomic.NewBool(false),
+		done:           ctx.Done(),
+		closedChan:     make(chan struct{}),
+		FatalErrorChan: make(chan struct{}),
+	}
+	if secret != nil {
+		store.keys = db.NewKeyring(secret, oldSecrets...)
+		logger.Info("The bolt store is encrypted")
+	}
+	store.dests.Store(dests)
+	for _, dest := range conf.Destinations {
+		store.queues[dest] = newQueue(dest)
+	}
+
+	store.wg.Add(1)
+	go func() {
+		defer store.wg.Done()
+		store.init(ctx)
+	}()
+	return store, nil
+}
+
+func (s *BoltStore) init(ctx context.Context) {
+	lctx, cancel := context.WithCancel(ctx)
+
+	err := s.resetStuckInSent()
+	if err != nil {
+		s.logger.Warn("Error resetting stuck sent messages", "error", err)
+	}
+	err = s.resetFailures()
+	if err != nil {
+		s.logger.Warn("Error resetting failed messages", "error", err)
+	}
+	err = s.pruneOrphaned()
+	if err != nil {
+		s.logger.Warn("Error pruning orphaned messages", "error", err)
+	}
+	s.initGauge()
+
+	errs := make(chan error, 2*len(s.queues)+1)
+
+	for _, q := range s.queues {
+		s.wg.Add(2)
+		go func(q *destQueue) {
+			defer s.wg.Done()
+			err := receiveAcks(s, q, s.ackBatchSize, s.ackLatency)
+			if err != nil {
+				errs <- err
+			}
+		}(q)
+		go func(q *destQueue) {
+			defer s.wg.Done()
+			err := retrieveAndForward(lctx, s, q, s.dests)
+			if err != nil {
+				errs <- err
+			}
+		}(q)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		watchOldest(lctx, s, s.queues)
+	}()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := s.resetFailures()
+				if err != nil {
+					errs <- err
+					return
+				}
+			case <-lctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		select {
+		case <-lctx.Done():
+		case err := <-errs:
+			s.logger.Error("Some error happened operating the Store. Shutting it down", "error", err)
+			cancel()
+			close(s.FatalErrorChan)
+		}
+		// dispose the queues. makes the goroutines receiveAcks stop.
+		for _, q := range s.queues {
+			q.dispose()
+		}
+		s.wg.Wait()
+		err := s.db.Close()
+		if err != nil {
+			s.logger.Warn("Error closing the bolt database", "error", err)
+		}
+		close(s.closedChan)
+	}()
+}
+
+func (s *BoltStore) Confined() bool {
+	return s.confined
+}
+
+func (s *BoltStore) Outputs(dest conf.DestinationType) chan []*model.FullMessage {
+	return s.queues[dest].outputs
+}
+
+func (s *BoltStore) Errors() chan struct{} {
+	return s.FatalErrorChan
+}
+
+func (s *BoltStore) Destinations() []conf.DestinationType {
+	return s.dests.Load()
+}
+
+func (s *BoltStore) SetDestinations(dests conf.DestinationType) {
+	s.dests.Store(dests)
+}
+
+func (s *BoltStore) WaitFinished() {
+	<-s.closedChan
+}
+
+// SetLastError records the last error faced by the forwarder of a
+// destination.
+func (s *BoltStore) SetLastError(dest conf.DestinationType, err error) {
+	if err != nil {
+		s.lastErrs.set(dest, err)
+	}
+}
+
+// ReadAllBadgers returns the text of the ready and sent messages, and the
+// time of failure of the failed messages.
+func (s *BoltStore) ReadAllBadgers() (map[string]string, map[string]string, map[string]string) {
+	readyMap := make(map[string]string)
+	failedMap := make(map[string]string)
+	sentMap := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		msgs := tx.Bucket(boltMessages)
+		text := func(uid []byte) string {
+			m, err := s.decode(msgs.Get(uid))
+			if err != nil {
+				return "invalid message: " + err.Error()
+			}
+			defer model.FullFree(m)
+			return m.Fields.Message
+		}
+		for _, q := range s.queues {
+			err := tx.Bucket(boltBucket(Ready, q.dest)).ForEach(func(k, v []byte) error {
+				readyMap[q.dumpKey(utils.MyULID(k))] = text(k)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(boltBucket(Sent, q.dest)).ForEach(func(k, v []byte) error {
+				sentMap[q.dumpKey(utils.MyULID(k))] = text(k)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for uid, failedAt := range boltFailedEntries(tx.Bucket(boltBucket(Failed, q.dest)), nil) {
+				failedMap[q.dumpKey(uid)] = failedAt.Format(time.RFC3339Nano)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Warn("Error reading the bolt queues", "error", err)
+	}
+	return readyMap, failedMap, sentMap
+}
+
+func (s *BoltStore) StoreAllSyslogConfigs(c conf.BaseConfig) error {
+	return storeAllSyslogConfigs(c, s.StoreSyslogConfig)
+}
+
+func (s *BoltStore) StoreSyslogConfig(confID utils.MyULID, config conf.FilterSubConfig) error {
+	var stored bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltConfigs)
+		if b.Get([]byte(confID)) != nil {
+			return nil
+		}
+		stored = true
+		return b.Put([]byte(confID), []byte(config.Export()))
+	})
+	if err != nil {
+		return eerrors.Wrap(err, "failed to store some configuration in the database")
+	}
+	if stored {
+		badgerGauge.WithLabelValues("syslogconf", "").Inc()
+	}
+	return nil
+}
+
+func (s *BoltStore) GetSyslogConfig(confID utils.MyULID) (*conf.FilterSubConfig, error) {
+	var data []byte
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		data = append(data, tx.Bucket(boltConfigs).Get([]byte(confID))...)
+		return nil
+	})
+	if data == nil {
+		return nil, eerrors.Errorf("unknown syslog configuration id: %s", confID.String())
+	}
+	c, err := conf.ImportSyslogConfig(data)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Failed to unmarshal configuration from the database")
+	}
+	return c, nil
+}
+
+// seal compresses and encrypts the content of a message.
+func (s *BoltStore) seal(w *snappy.Writer, content string) ([]byte, error) {
+	var buf bytes.Buffer
+	w.Reset(&buf)
+	_, _ = w.Write([]byte(content))
+	_ = w.Close()
+	if s.keys == nil {
+		return buf.Bytes(), nil
+	}
+	return s.keys.EncryptTo(buf.Bytes(), nil)
+}
+
+// decode decrypts and decodes the content of a message.
+func (s *BoltStore) decode(value []byte) (*model.FullMessage, error) {
+	if len(value) == 0 {
+		return nil, eerrors.New("message not found")
+	}
+	if s.keys != nil {
+		var err error
+		value, err = s.keys.DecryptTo(value, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decodeStored(value)
+}
+
+// deleteUnreferenced deletes the content of a message when no queue
+// references it anymore. It returns true if the content was deleted.
+func deleteUnreferenced(tx *bolt.Tx, uid []byte) (bool, error) {
+	for qtype := range Queues {
+		for _, dest := range conf.Destinations {
+			if tx.Bucket(boltBucket(qtype, dest)).Get(uid) != nil {
+				return false, nil
+			}
+		}
+	}
+	msgs := tx.Bucket(boltMessages)
+	if msgs.Get(uid) == nil {
+		return false, nil
+	}
+	return true, msgs.Delete(uid)
+}
+
+// bucketKeys returns a copy of the keys of a bucket, up to limit keys when limit is
+// positive.
+func bucketKeys(b *bolt.Bucket, limit int) (keys [][]byte) {
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && (limit <= 0 || len(keys) < limit); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	return keys
+}
+
+func (s *BoltStore) Ingest(m map[utils.MyULID]string) (int, error) {
+	if len(m) == 0 {
+		return 0, nil
+	}
+	w := snappy.NewBufferedWriter(nil)
+	values := make(map[utils.MyULID][]byte, len(m))
+	for uid, content := range m {
+		if len(content) == 0 {
+			continue
+		}
+		value, err := s.seal(w, content)
+		if err != nil {
+			return 0, eerrors.Wrap(err, "Failed to encrypt message")
+		}
+		values[uid] = value
+	}
+
+	destinations := s.Destinations()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		msgs := tx.Bucket(boltMessages)
+		for uid, value := range values {
+			err := msgs.Put([]byte(uid), value)
+			if err != nil {
+				return err
+			}
+			for _, dest := range destinations {
+				err = tx.Bucket(boltBucket(Ready, dest)).Put([]byte(uid), boltTrue)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, eerrors.Wrap(err, "Failed to ingest messages in the bolt database")
+	}
+	badgerGauge.WithLabelValues("messages", "").Add(float64(len(values)))
+	for _, dest := range destinations {
+		q := s.queues[dest]
+		q.zeroMsg.Store(false)
+		badgerGauge.WithLabelValues("ready", q.name).Add(float64(len(values)))
+	}
+	return len(values), nil
+}
+
+func (s *BoltStore) retrieve(q *destQueue) ([]*model.FullMessage, error) {
+	startt := time.Now()
+	defer func() {
+		retrieveTimeSummary.Observe(time.Since(startt).Seconds() * 1000)
+	}()
+
+	messages := msgsSlicePool.Get().([]*model.FullMessage)[:0]
+	var nbInvalids, nbDeleted int
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		ready := tx.Bucket(boltBucket(Ready, q.dest))
+		sent := tx.Bucket(boltBucket(Sent, q.dest))
+		msgs := tx.Bucket(boltMessages)
+
+		for _, uid := range bucketKeys(ready, int(s.batchSize)) {
+			err := ready.Delete(uid)
+			if err != nil {
+				return err
+			}
+			message, err := s.decode(msgs.Get(uid))
+			if err != nil {
+				s.logger.Debug("retrieved invalid entry", "uid", utils.MyULID(uid), "error", err)
+				nbInvalids++
+				deleted, err := deleteUnreferenced(tx, uid)
+				if err != nil {
+					return err
+				}
+				if deleted {
+					nbDeleted++
+				}
+				continue
+			}
+			err = sent.Put(uid, boltTrue)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	if err != nil {
+		for _, message := range messages {
+			model.FullFree(message)
+		}
+		msgsSlicePool.Put(messages)
+		return nil, err
+	}
+
+	if nbInvalids > 0 {
+		s.logger.Info("Found invalid entries", "number", nbInvalids)
+	}
+	badgerGauge.WithLabelValues("ready", q.name).Sub(float64(len(messages) + nbInvalids))
+	badgerGauge.WithLabelValues("sent", q.name).Add(float64(len(messages)))
+	badgerGauge.WithLabelValues("messages", "").Sub(float64(nbDeleted))
+	return messages, nil
+}
+
+func (s *BoltStore) ACK(uid utils.MyULID, dest conf.DestinationType) {
+	countACK(dest, "ack")
+	_ = s.queues[dest].acks.Put(uid, dest)
+}
+
+func (s *BoltStore) NACK(uid utils.MyULID, dest conf.DestinationType) {
+	countACK(dest, "nack")
+	_ = s.queues[dest].nacks.Put(uid, dest)
+}
+
+func (s *BoltStore) PermError(uid utils.MyULID, dest conf.DestinationType) {
+	countACK(dest, "permerror")
+	_ = s.queues[dest].permerrs.Put(uid, dest)
+}
+
+func (s *BoltStore) doACK(q *destQueue, acks []queue.UidDest) error {
+	if len(acks) == 0 {
+		return nil
+	}
+	var nbDeleted int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		sent := tx.Bucket(boltBucket(Sent, q.dest))
+		for _, ack := range acks {
+			err := sent.Delete([]byte(ack.Uid))
+			if err != nil {
+				return eerrors.Wrap(err, "Error removing messages from the Sent bucket")
+			}
+			deleted, err := deleteUnreferenced(tx, []byte(ack.Uid))
+			if err != nil {
+				return eerrors.Wrap(err, "Error deleting acknowledged messages")
+			}
+			if deleted {
+				nbDeleted++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	badgerGauge.WithLabelValues("sent", q.name).Sub(float64(len(acks)))
+	badgerGauge.WithLabelValues("messages", "").Sub(float64(nbDeleted))
+	return nil
+}
+
+// moveFromSent moves messages from the sent queue of the destination to
+// another queue, with the current time as value.
+func (s *BoltStore) moveFromSent(q *destQueue, to QueueType, uids []queue.UidDest) error {
+	now := utils.Time2Bytes(time.Now(), nil)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		sent := tx.Bucket(boltBucket(Sent, q.dest))
+		target := tx.Bucket(boltBucket(to, q.dest))
+		for _, u := range uids {
+			err := sent.Delete([]byte(u.Uid))
+			if err != nil {
+				return eerrors.Wrap(err, "Error removing messages from the Sent bucket")
+			}
+			err = target.Put([]byte(u.Uid), now)
+			if err != nil {
+				return eerrors.Wrap(err, "Error moving message out of the Sent bucket")
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) doNACK(q *destQueue, nacks []queue.UidDest) error {
+	if len(nacks) == 0 {
+		return nil
+	}
+	err := s.moveFromSent(q, Failed, nacks)
+	if err != nil {
+		return err
+	}
+	badgerGauge.WithLabelValues("failed", q.name).Add(float64(len(nacks)))
+	badgerGauge.WithLabelValues("sent", q.name).Sub(float64(len(nacks)))
+	return nil
+}
+
+func (s *BoltStore) doPermanentError(q *destQueue, pes []queue.UidDest) error {
+	if len(pes) == 0 {
+		return nil
+	}
+	err := s.moveFromSent(q, PermErrors, pes)
+	if err != nil {
+		return err
+	}
+	badgerGauge.WithLabelValues("permerrors", q.name).Add(float64(len(pes)))
+	badgerGauge.WithLabelValues("sent", q.name).Sub(float64(len(pes)))
+	return nil
+}
+
+// resetStuckInSent pushes back to the ready queues the messages that were
+// sent, but not acknowledged, when skewer stopped.
+func (s *BoltStore) resetStuckInSent() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, dest := range conf.Destinations {
+			sent := tx.Bucket(boltBucket(Sent, dest))
+			ready := tx.Bucket(boltBucket(Ready, dest))
+			uids := bucketKeys(sent, 0)
+			for _, uid := range uids {
+				err := sent.Delete(uid)
+				if err != nil {
+					return err
+				}
+				err = ready.Put(uid, boltTrue)
+				if err != nil {
+					return err
+				}
+			}
+			if len(uids) > 0 {
+				s.logger.Info("Messages stuck in sent have been pushed back to ready", "nb", len(uids), "dest", conf.DestinationNames[dest])
+			}
+		}
+		return nil
+	})
+}
+
+// resetFailures pushes back to the ready queues the messages that failed
+// more than a minute ago.
+func (s *BoltStore) resetFailures() error {
+	for _, q := range s.queues {
+		var nbExpired, nbInvalid int
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			nbExpired, nbInvalid = 0, 0
+			failed := tx.Bucket(boltBucket(Failed, q.dest))
+			ready := tx.Bucket(boltBucket(Ready, q.dest))
+			now := time.Now()
+			var expired, invalid [][]byte
+			err := failed.ForEach(func(k, v []byte) error {
+				t, n := utils.Bytes2Time(v)
+				if n <= 0 {
+					invalid = append(invalid, append([]byte(nil), k...))
+				} else if now.Sub(t) >= time.Minute {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, uid := range invalid {
+				err = failed.Delete(uid)
+				if err != nil {
+					return err
+				}
+			}
+			for _, uid := range expired {
+				err = failed.Delete(uid)
+				if err != nil {
+					return err
+				}
+				err = ready.Put(uid, boltTrue)
+				if err != nil {
+					return err
+				}
+			}
+			nbExpired, nbInvalid = len(expired), len(invalid)
+			return nil
+		})
+		if err != nil {
+			return eerrors.Wrap(err, "failed to reset expired failures")
+		}
+		if nbExpired > 0 {
+			q.zeroMsg.Store(false)
+			s.logger.Debug("Pushed back expired failures to the ready queue", "nb", nbExpired)
+		}
+		if nbInvalid > 0 {
+			s.logger.Info("Deleted some invalid entries", "nb", nbInvalid)
+		}
+		badgerGauge.WithLabelValues("ready", q.name).Add(float64(nbExpired))
+		badgerGauge.WithLabelValues("failed", q.name).Sub(float64(nbExpired + nbInvalid))
+	}
+	return nil
+}
+
+// pruneOrphaned deletes the messages that no queue references.
+func (s *BoltStore) pruneOrphaned() error {
+	var nb int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, uid := range bucketKeys(tx.Bucket(boltMessages), 0) {
+			deleted, err := deleteUnreferenced(tx, uid)
+			if err != nil {
+				return err
+			}
+			if deleted {
+				nb++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if nb > 0 {
+		s.logger.Info("Deleted messages that are not referenced anymore", "nb", nb)
+	}
+	return nil
+}
+
+// oldest returns the oldest message that waits to be delivered to the
+// destination, or an empty uid.
+func (s *BoltStore) oldest(q *destQueue) (oldest utils.MyULID) {
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		for _, qtype := range []QueueType{Ready, Sent, Failed} {
+			// the keys are sorted, and the ULIDs are sorted by time
+			k, _ := tx.Bucket(boltBucket(qtype, q.dest)).Cursor().First()
+			if k != nil && (len(oldest) == 0 || utils.MyULID(k) < oldest) {
+				oldest = utils.MyULID(k)
+			}
+		}
+		return nil
+	})
+	return oldest
+}
+
+func (s *BoltStore) initGauge() {
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		badgerGauge.WithLabelValues("messages", "").Set(float64(tx.Bucket(boltMessages).Stats().KeyN))
+		badgerGauge.WithLabelValues("syslogconf", "").Set(float64(tx.Bucket(boltConfigs).Stats().KeyN))
+		for _, dest := range conf.Destinations {
+			name := conf.DestinationNames[dest]
+			badgerGauge.WithLabelValues("ready", name).Set(float64(tx.Bucket(boltBucket(Ready, dest)).Stats().KeyN))
+			badgerGauge.WithLabelValues("sent", name).Set(float64(tx.Bucket(boltBucket(Sent, dest)).Stats().KeyN))
+			badgerGauge.WithLabelValues("failed", name).Set(float64(tx.Bucket(boltBucket(Failed, dest)).Stats().KeyN))
+			badgerGauge.WithLabelValues("permerrors", name).Set(float64(tx.Bucket(boltBucket(PermErrors, dest)).Stats().KeyN))
+		}
+		return nil
+	})
+}
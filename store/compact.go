@@ -0,0 +1,110 @@
+package store
+
+import (
+	"os"
+
+	"github.com/dgraph-io/badger"
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// runValueLogGC rewrites the value log files that have at least discardRatio
+// of garbage, until there is no such file anymore. It returns the number of
+// rewritten files.
+func runValueLogGC(badg *badger.DB, discardRatio float64) (nb int, err error) {
+	for {
+		err = badg.RunValueLogGC(discardRatio)
+		if err == badger.ErrNoRewrite {
+			return nb, nil
+		}
+		if err != nil {
+			return nb, err
+		}
+		nb++
+	}
+}
+
+// collectGarbage runs the value log GC of the store.
+func (s *MessageStore) collectGarbage() {
+	s.purgeLock.Lock()
+	defer s.purgeLock.Unlock()
+	nb, err := runValueLogGC(s.badger, s.gcDiscardRatio)
+	if err != nil {
+		s.logger.Warn("Error happened when garbage collecting the badger", "error", err)
+	}
+	if nb > 0 {
+		s.logger.Debug("Value log files rewritten", "nb", nb)
+	}
+}
+
+// Compact cleans up a store that is not in use by a running skewer: the
+// messages stuck in the sent queues are pushed back to the ready queues, the
+// messages that no destination references anymore are deleted, and the value
+// log is garbage collected until no file has discardRatio of garbage. It
+// returns the size of the store before and after.
+func Compact(cfg conf.StoreConfig, discardRatio float64, logger log15.Logger) (before int64, after int64, err error) {
+	err = badgerOnly(cfg)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = os.Stat(cfg.Dirname)
+	if err != nil {
+		return 0, 0, eerrors.Wrap(err, "The store directory is not available")
+	}
+	before, err = dirSize(cfg.Dirname)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	kv, err := badger.Open(badgerOptions(cfg, cfg.Dirname))
+	if err != nil {
+		return 0, 0, eerrors.Wrap(err, "failed to open the badger database")
+	}
+	// the content of the messages is left as is, so the secret is not needed
+	bend, err := NewBackend(kv, nil)
+	if err != nil {
+		_ = kv.Close()
+		return 0, 0, err
+	}
+
+	for _, dest := range conf.Destinations {
+		q := newDestQueue(dest, bend)
+		var nb int
+		for {
+			nb, err = resetHelper(kv, q)
+			if err != badger.ErrConflict {
+				break
+			}
+		}
+		q.dispose()
+		if err != nil {
+			_ = kv.Close()
+			return 0, 0, eerrors.Wrap(err, "failed to reset messages stuck in the sent queue")
+		}
+		if nb > 0 {
+			logger.Info("Messages stuck in sent have been pushed back to ready", "nb", nb, "dest", q.name)
+		}
+	}
+
+	nb, err := prune(kv, bend)
+	if err != nil {
+		_ = kv.Close()
+		return 0, 0, eerrors.Wrap(err, "Failed to prune orphaned messages")
+	}
+	logger.Info("Deleted messages that are not referenced anymore", "nb", nb)
+
+	nb, err = runValueLogGC(kv, discardRatio)
+	if err != nil {
+		_ = kv.Close()
+		return 0, 0, eerrors.Wrap(err, "Error happened when garbage collecting the badger")
+	}
+	logger.Info("Value log files rewritten", "nb", nb)
+
+	err = kv.Close()
+	if err != nil {
+		return 0, 0, eerrors.Wrap(err, "Error closing the badger")
+	}
+	after, err = dirSize(cfg.Dirname)
+	return before, after, err
+}
@@ -0,0 +1,272 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/db"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/queue"
+	"github.com/stephane-martin/skewer/utils/waiter"
+	"go.uber.org/atomic"
+)
+
+// destQueue holds the queues of a destination: the ready, sent, failed and
+// permerrors partitions, the pending ACKs, and the channel to the forwarder.
+//
+// Each destination retrieves its messages and applies its ACKs in its own
+// goroutines, so that a slow destination lags behind without delaying the
+// others.
+type destQueue struct {
+	dest       conf.DestinationType
+	name       string
+	ready      db.Partition
+	sent       db.Partition
+	failed     db.Partition
+	permerrors db.Partition
+
+	acks     *queue.AckQueue
+	nacks    *queue.AckQueue
+	permerrs *queue.AckQueue
+
+	// outputs is a non-buffered chan to the forwarder
+	outputs chan []*model.FullMessage
+	// zeroMsg is set when the ready queue is known to be empty
+	zeroMsg *atomic.Bool
+}
+
+func newDestQueue(dest conf.DestinationType, b *Backend) *destQueue {
+	q := newQueue(dest)
+	q.ready = b.GetPartition(Ready, dest)
+	q.sent = b.GetPartition(Sent, dest)
+	q.failed = b.GetPartition(Failed, dest)
+	q.permerrors = b.GetPartition(PermErrors, dest)
+	return q
+}
+
+// newQueue returns a destQueue without the badger partitions.
+func newQueue(dest conf.DestinationType) *destQueue {
+	return &destQueue{
+		dest:     dest,
+		name:     conf.DestinationNames[dest],
+		acks:     queue.NewAckQueue(),
+		nacks:    queue.NewAckQueue(),
+		permerrs: queue.NewAckQueue(),
+		outputs:  make(chan []*model.FullMessage),
+		zeroMsg:  atomic.NewBool(false),
+	}
+}
+
+// dumpKey is the key of a queued message in the maps of ReadAllBadgers.
+func (q *destQueue) dumpKey(uid utils.MyULID) string {
+	return q.name + "/" + uid.String()
+}
+
+func (q *destQueue) dispose() {
+	q.acks.Dispose()
+	q.nacks.Dispose()
+	q.permerrs.Dispose()
+}
+
+// queueOps are the operations of a store backend on the queues of a
+// destination. The goroutines of the destinations are the same for all
+// backends.
+type queueOps interface {
+	retrieve(q *destQueue) ([]*model.FullMessage, error)
+	doACK(q *destQueue, acks []queue.UidDest) error
+	doNACK(q *destQueue, nacks []queue.UidDest) error
+	doPermanentError(q *destQueue, pes []queue.UidDest) error
+	NACK(uid utils.MyULID, dest conf.DestinationType)
+	oldest(q *destQueue) utils.MyULID
+}
+
+// while a batch of ACKs fills up, the ACK queues are polled every
+// ackPollInterval
+const ackPollInterval = 5 * time.Millisecond
+
+// the age of the oldest pending message is updated every oldestInterval
+const oldestInterval = 10 * time.Second
+
+// watchOldest exports the age of the oldest message that waits to be
+// delivered (ready, sent or failed) to each destination, until ctx is
+// canceled.
+func watchOldest(ctx context.Context, s queueOps, queues map[conf.DestinationType]*destQueue) {
+	ticker := time.NewTicker(oldestInterval)
+	defer ticker.Stop()
+	for {
+		for _, q := range queues {
+			var age float64
+			if uid := s.oldest(q); len(uid) > 0 {
+				age = time.Since(uid.Time()).Seconds()
+			}
+			oldestGauge.WithLabelValues(q.name).Set(age)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// receiveAcks applies the ACKs of the destination by batches of at most
+// batchSize, until the queues are disposed. It waits up to latency for a
+// batch to fill up.
+func receiveAcks(s queueOps, q *destQueue, batchSize uint32, latency time.Duration) error {
+	if batchSize == 0 {
+		batchSize = 1
+	}
+	nackBatchSize := batchSize / 8
+	if nackBatchSize == 0 {
+		nackBatchSize = 1
+	}
+	acks := make([]queue.UidDest, 0, batchSize)
+	nacks := make([]queue.UidDest, 0, nackBatchSize)
+	permerrs := make([]queue.UidDest, 0, nackBatchSize)
+	full := func() bool {
+		return len(acks) == cap(acks) || len(nacks) == cap(nacks) || len(permerrs) == cap(permerrs)
+	}
+	for queue.WaitManyAckQueues(q.acks, q.nacks, q.permerrs) {
+		acks, nacks, permerrs = acks[:0], nacks[:0], permerrs[:0]
+		deadline := time.Now().Add(latency)
+		for {
+			q.acks.AppendMany(&acks)
+			q.nacks.AppendMany(&nacks)
+			q.permerrs.AppendMany(&permerrs)
+			if full() || q.acks.Disposed() {
+				break
+			}
+			// wait for the batch to fill up, up to latency
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				break
+			}
+			if wait > ackPollInterval {
+				wait = ackPollInterval
+			}
+			time.Sleep(wait)
+		}
+		err := s.doACK(q, acks)
+		if err != nil {
+			return eerrors.Wrapf(err, "Error applying ACKs for destination '%s'", q.name)
+		}
+		err = s.doNACK(q, nacks)
+		if err != nil {
+			return eerrors.Wrapf(err, "Error applying NACKs for destination '%s'", q.name)
+		}
+		err = s.doPermanentError(q, permerrs)
+		if err != nil {
+			return eerrors.Wrapf(err, "Error applying PermErrors for destination '%s'", q.name)
+		}
+	}
+	return nil
+}
+
+// retrieveAndForward retrieves the ready messages of the destination and
+// pushes them to its forwarder. The next batch is retrieved while the
+// forwarder processes the previous one.
+func retrieveAndForward(ctx context.Context, s queueOps, q *destQueue, dests *Destinations) error {
+	defer close(q.outputs)
+
+	ew := waiter.Default()
+	var previousMsgs []*model.FullMessage
+
+	wait := func(d time.Duration) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(d):
+			return true
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		if !dests.Has(q.dest) {
+			// that destination is not currently selected, do nothing
+			if !wait(time.Second) {
+				return nil
+			}
+			continue
+		}
+		if q.zeroMsg.Load() {
+			// we are sure that there was no new message
+			if !wait(ew.Next()) {
+				return nil
+			}
+			continue
+		}
+		messages, err := s.retrieve(q)
+		if err != nil {
+			return eerrors.Wrap(err, "Failed to retrieve messages from the store")
+		}
+		if len(messages) == 0 {
+			// no messages in store for that destination
+			msgsSlicePool.Put(messages)
+			q.zeroMsg.Store(true)
+			if !wait(ew.Next()) {
+				return nil
+			}
+			continue
+		}
+		ew.Reset()
+
+		select {
+		case q.outputs <- messages:
+			// q.outputs is a non-buffered chan. So when q.outputs <- messages
+			// returns, it means that the forwarder has finished to process
+			// the previously provided messages. Therefore we can now push
+			// back the previous messages slice to the slice pool.
+			if previousMsgs != nil {
+				msgsSlicePool.Put(previousMsgs)
+			}
+			previousMsgs = messages
+		case <-ctx.Done():
+			// NACK the messages that were not delivered
+			for _, message := range messages {
+				s.NACK(message.Uid, q.dest)
+				model.FullFree(message)
+			}
+			return nil
+		}
+	}
+}
+
+func (s *MessageStore) resetStuckInSentByDest(q *destQueue) (nb int, err error) {
+	for {
+		nb, err = resetHelper(s.badger, q)
+		if err != badger.ErrConflict {
+			return nb, err
+		}
+	}
+}
+
+func resetHelper(badg *badger.DB, q *destQueue) (nb int, err error) {
+	txn := db.NewNTransaction(badg, true)
+	defer txn.Discard()
+
+	uids := q.sent.ListKeys(txn)
+	err = q.sent.DeleteMany(uids, txn)
+	if err != nil {
+		return 0, err
+	}
+	for _, uid := range uids {
+		err = q.ready.Set(uid, "true", txn)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = txn.Commit(nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(uids), nil
+}
@@ -0,0 +1,299 @@
+package dests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/encoders"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/valyala/bytebufferpool"
+)
+
+// AlertDestination sends the severe messages as alerts, by email or to a
+// webhook. The other messages are ACKed and dropped.
+type AlertDestination struct {
+	*baseDestination
+	config    conf.AlertDestConfig
+	severity  model.Severity
+	match     *regexp.Regexp
+	appname   *regexp.Regexp
+	subject   *template.Template
+	tlsConfig *tls.Config
+	clt       *http.Client
+
+	rateMu      sync.Mutex
+	windowStart time.Time
+	sent        int
+	suppressed  int
+}
+
+func NewAlertDestination(ctx context.Context, e *Env) (Destination, error) {
+	config := e.config.AlertDest
+	d := &AlertDestination{
+		baseDestination: newBaseDestination(conf.Alert, "alert", e),
+		config:          config,
+	}
+	err := d.setFormat(config.Format)
+	if err != nil {
+		return nil, err
+	}
+	severity, ok := model.RSeverities[config.Severity]
+	if !ok {
+		return nil, eerrors.Errorf("Unknown alert severity threshold: '%s'", config.Severity)
+	}
+	d.severity = severity
+	if len(config.Match) > 0 {
+		d.match, err = regexp.Compile(config.Match)
+		if err != nil {
+			return nil, eerrors.Wrap(err, "Invalid match expression")
+		}
+	}
+	if len(config.AppName) > 0 {
+		d.appname, err = regexp.Compile(config.AppName)
+		if err != nil {
+			return nil, eerrors.Wrap(err, "Invalid appname expression")
+		}
+	}
+	if d.config.MaxAlerts > 0 && d.config.RatePeriod <= 0 {
+		d.config.RatePeriod = time.Minute
+	}
+
+	var host string
+	switch config.Mode {
+	case conf.AlertWebhook:
+		u, err := url.Parse(config.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, eerrors.Errorf("The alert destination needs a valid webhook_url: '%s'", config.WebhookURL)
+		}
+		host = u.Hostname()
+	default:
+		if len(config.To) == 0 {
+			return nil, eerrors.New("The alert destination needs at least one recipient")
+		}
+		host, _, err = net.SplitHostPort(config.SMTPServer)
+		if err != nil {
+			return nil, eerrors.Wrapf(err, "Invalid SMTP server: '%s'", config.SMTPServer)
+		}
+		d.subject, err = template.New("subject").Parse(config.Subject)
+		if err != nil {
+			return nil, eerrors.Wrap(err, "Invalid alert subject template")
+		}
+	}
+	d.tlsConfig, err = utils.NewTLSConfig(
+		host,
+		config.CAFile,
+		config.CAPath,
+		config.CertFile,
+		config.KeyFile,
+		config.Insecure,
+		e.confined,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if config.Mode == conf.AlertWebhook {
+		d.clt = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     d.tlsConfig,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			Timeout: config.RequestTimeout,
+		}
+	}
+	return d, nil
+}
+
+func (d *AlertDestination) matches(msg *model.FullMessage) bool {
+	if msg.Fields == nil || msg.Fields.Severity > d.severity {
+		return false
+	}
+	if d.appname != nil && !d.appname.MatchString(msg.Fields.AppName) {
+		return false
+	}
+	if d.match != nil && !d.match.MatchString(msg.Fields.Message) {
+		return false
+	}
+	return true
+}
+
+// allow applies the rate limit. It returns the number of alerts that were
+// suppressed during the previous period, when a new period begins.
+func (d *AlertDestination) allow() (ok bool, suppressed int) {
+	if d.config.MaxAlerts <= 0 {
+		return true, 0
+	}
+	d.rateMu.Lock()
+	defer d.rateMu.Unlock()
+	now := time.Now()
+	if now.Sub(d.windowStart) >= d.config.RatePeriod {
+		suppressed = d.suppressed
+		d.windowStart = now
+		d.sent = 0
+		d.suppressed = 0
+	}
+	if d.sent >= d.config.MaxAlerts {
+		d.suppressed++
+		return false, 0
+	}
+	d.sent++
+	return true, suppressed
+}
+
+func (d *AlertDestination) sendOne(ctx context.Context, msg *model.FullMessage) (err error) {
+	if !d.matches(msg) {
+		return nil
+	}
+	ok, suppressed := d.allow()
+	if !ok {
+		alertsCounter.WithLabelValues("suppressed").Inc()
+		return nil
+	}
+	if suppressed > 0 {
+		d.logger.Warn("Alerts were suppressed by the rate limit", "suppressed", suppressed)
+	}
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	err = d.encoder(msg, buf)
+	if err != nil {
+		return err
+	}
+	if d.config.Mode == conf.AlertWebhook {
+		err = d.postWebhook(ctx, buf.Bytes())
+	} else {
+		err = d.sendMail(msg, buf.Bytes(), suppressed)
+	}
+	if err != nil {
+		alertsCounter.WithLabelValues("failed").Inc()
+		return err
+	}
+	alertsCounter.WithLabelValues("sent").Inc()
+	return nil
+}
+
+func (d *AlertDestination) postWebhook(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest("POST", d.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	contentType := encoders.MimeTypes[d.format]
+	if len(contentType) == 0 {
+		contentType = "text/plain"
+	}
+	req.Header.Set("Content-Type", contentType)
+	if len(d.config.BearerToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+d.config.BearerToken)
+	}
+	resp, err := d.clt.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return eerrors.Errorf("The alert webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *AlertDestination) sendMail(msg *model.FullMessage, body []byte, suppressed int) error {
+	subject := bytebufferpool.Get()
+	defer bytebufferpool.Put(subject)
+	err := d.subject.Execute(subject, msg.Fields)
+	if err != nil {
+		return encoders.EncodingError(err)
+	}
+
+	var mail bytes.Buffer
+	fmt.Fprintf(&mail, "From: %s\r\n", d.config.From)
+	fmt.Fprintf(&mail, "To: %s\r\n", strings.Join(d.config.To, ", "))
+	fmt.Fprintf(&mail, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&mail, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	mail.WriteString("MIME-Version: 1.0\r\n")
+	mail.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	mail.Write(body)
+	if suppressed > 0 {
+		fmt.Fprintf(&mail, "\r\n\r\n%d alerts were suppressed by the rate limit.\r\n", suppressed)
+	}
+
+	// the whole SMTP session must complete within the request timeout
+	conn, err := net.DialTimeout("tcp", d.config.SMTPServer, d.config.RequestTimeout)
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(d.config.RequestTimeout))
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	host, _, _ := net.SplitHostPort(d.config.SMTPServer)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(d.tlsConfig)
+		if err != nil {
+			return err
+		}
+	} else if d.config.TLSEnabled {
+		return eerrors.Errorf("The SMTP server '%s' does not support STARTTLS", d.config.SMTPServer)
+	}
+	if len(d.config.SMTPUsername) > 0 {
+		err = c.Auth(smtp.PlainAuth("", d.config.SMTPUsername, d.config.SMTPPassword, d.tlsConfig.ServerName))
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(d.config.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range d.config.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(mail.Bytes())
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (d *AlertDestination) Close() error {
+	return nil
+}
+
+func (d *AlertDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
+	return d.ForEach(ctx, d.sendOne, true, true, msgs)
+}
@@ -0,0 +1,383 @@
+package dests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/encoders"
+	"github.com/stephane-martin/skewer/encoders/baseenc"
+	"github.com/stephane-martin/skewer/encoders/parquet"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/valyala/bytebufferpool"
+)
+
+const azureAPIVersion = "2019-12-12"
+const azureIMDSURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// an append block can not be larger than 4MiB
+const azureMaxAppendBlock = 4 * 1024 * 1024
+
+// azureBatch holds the encoded messages that will be written to the same blob.
+type azureBatch struct {
+	container string
+	blob      string
+	buf       bytes.Buffer
+	uids      []utils.MyULID
+	// parquet encodes the batch as a Parquet file
+	parquet *parquet.Writer
+}
+
+type azureToken struct {
+	value  string
+	expiry time.Time
+	sync.Mutex
+}
+
+type AzureBlobDestination struct {
+	*baseDestination
+	config        conf.AzureBlobDestConfig
+	endpoint      string
+	client        *http.Client
+	containerTmpl *template.Template
+	blobTmpl      *template.Template
+	batches       map[string]*azureBatch
+	batchesMu     sync.Mutex
+	// created remembers the append blobs that are known to exist
+	created map[string]bool
+	token   azureToken
+	flushMu sync.Mutex
+	columns []parquet.Column
+	codec   parquet.Codec
+}
+
+func NewAzureBlobDestination(ctx context.Context, e *Env) (Destination, error) {
+	config := e.config.AzureBlobDest
+	d := &AzureBlobDestination{
+		baseDestination: newBaseDestination(conf.AzureBlob, "azureblob", e),
+		config:          config,
+		client:          &http.Client{Timeout: config.Timeout},
+		batches:         make(map[string]*azureBatch),
+		created:         make(map[string]bool),
+	}
+	var err error
+	if baseenc.ParseFormat(config.Format) == baseenc.Parquet {
+		if config.BlobType != conf.AzureBlockBlob {
+			return nil, eerrors.New("The parquet format needs Azure block blobs")
+		}
+		d.format = baseenc.Parquet
+		d.columns, err = parquet.ParseColumns(config.ParquetColumns)
+		if err != nil {
+			return nil, err
+		}
+		d.codec, err = parquet.ParseCodec(config.ParquetCompression)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = d.setFormat(config.Format)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(config.SASToken) == 0 && !config.ManagedIdentity {
+		return nil, eerrors.New("Azure blob destination needs a SAS token or a managed identity")
+	}
+	d.endpoint = strings.TrimRight(strings.TrimSpace(config.Endpoint), "/")
+	if len(d.endpoint) == 0 {
+		if len(config.Account) == 0 {
+			return nil, eerrors.New("Azure blob destination needs an account or an endpoint")
+		}
+		d.endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", config.Account)
+	}
+	d.containerTmpl, err = template.New("container").Parse(config.ContainerTmpl)
+	if err != nil {
+		return nil, err
+	}
+	d.blobTmpl, err = template.New("blob").Parse(config.BlobTmpl)
+	if err != nil {
+		return nil, err
+	}
+	if d.config.FlushSize <= 0 || d.config.FlushSize > azureMaxAppendBlock {
+		d.config.FlushSize = azureMaxAppendBlock
+	}
+
+	go func() {
+		// flush the batches periodically
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d.config.FlushPeriod):
+			}
+			d.flushAll(ctx)
+		}
+	}()
+
+	return d, nil
+}
+
+func (d *AzureBlobDestination) render(tmpl *template.Template, message *model.FullMessage) (string, error) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	err := tmpl.Execute(buf, message.Fields)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(buf.String()), "/"), nil
+}
+
+func (d *AzureBlobDestination) sendOne(ctx context.Context, message *model.FullMessage) (err error) {
+	container, err := d.render(d.containerTmpl, message)
+	if err != nil {
+		d.logger.Warn("Error calculating container name", "error", err)
+		return encoders.EncodingError(err)
+	}
+	blob, err := d.render(d.blobTmpl, message)
+	if err != nil {
+		d.logger.Warn("Error calculating blob name", "error", err)
+		return encoders.EncodingError(err)
+	}
+	if len(container) == 0 || len(blob) == 0 {
+		return encoders.EncodingError(eerrors.New("Empty container or blob name"))
+	}
+	var encoded string
+	if d.format != baseenc.Parquet {
+		encoded, err = encoders.ChainEncode(d.encoder, message, "\n")
+		if err != nil {
+			d.logger.Warn("Error encoding message", "error", err)
+			return encoders.EncodingError(err)
+		}
+	}
+
+	key := container + "/" + blob
+	var full *azureBatch
+	d.batchesMu.Lock()
+	batch, ok := d.batches[key]
+	if ok && batch.size()+len(encoded) > d.config.FlushSize {
+		full = batch
+		ok = false
+	}
+	if !ok {
+		batch = &azureBatch{container: container, blob: blob}
+		if d.format == baseenc.Parquet {
+			batch.parquet = parquet.NewWriter(&batch.buf, d.columns, d.codec, d.config.ParquetRowGroupSize)
+		}
+		d.batches[key] = batch
+	}
+	if batch.parquet != nil {
+		err = batch.parquet.Write(message)
+	} else {
+		batch.buf.WriteString(encoded)
+	}
+	if err == nil {
+		batch.uids = append(batch.uids, message.Uid)
+	}
+	d.batchesMu.Unlock()
+	if err != nil {
+		d.logger.Warn("Error encoding message", "error", err)
+		return encoders.EncodingError(err)
+	}
+
+	if full != nil {
+		d.flush(ctx, full)
+	}
+	return nil
+}
+
+// size returns the encoded size of the batch.
+func (batch *azureBatch) size() int {
+	if batch.parquet != nil {
+		return batch.buf.Len() + batch.parquet.Buffered()
+	}
+	return batch.buf.Len()
+}
+
+func (d *AzureBlobDestination) flushAll(ctx context.Context) {
+	d.batchesMu.Lock()
+	batches := d.batches
+	d.batches = make(map[string]*azureBatch)
+	d.batchesMu.Unlock()
+	for _, batch := range batches {
+		d.flush(ctx, batch)
+	}
+}
+
+// flush uploads a batch, and then ACKs or NACKs its messages.
+func (d *AzureBlobDestination) flush(ctx context.Context, batch *azureBatch) {
+	if len(batch.uids) == 0 {
+		return
+	}
+	var err error
+	if batch.parquet != nil {
+		// write the Parquet footer
+		err = batch.parquet.Close()
+	}
+	if err == nil {
+		// the blocks appended to the same blob must keep their order
+		d.flushMu.Lock()
+		err = d.upload(ctx, batch)
+		d.flushMu.Unlock()
+	}
+	if err != nil {
+		connCounter.WithLabelValues("azureblob", "fail").Inc()
+		d.logger.Warn("Error uploading to Azure blob storage", "container", batch.container, "blob", batch.blob, "error", err)
+		for _, uid := range batch.uids {
+			d.NACK(uid)
+		}
+		return
+	}
+	connCounter.WithLabelValues("azureblob", "success").Inc()
+	for _, uid := range batch.uids {
+		d.ACK(uid)
+	}
+}
+
+func (d *AzureBlobDestination) upload(ctx context.Context, batch *azureBatch) error {
+	if d.config.BlobType == conf.AzureBlockBlob {
+		// block blobs are immutable: each batch gets its own blob
+		name := batch.blob + "." + batch.uids[0].String()
+		if batch.parquet != nil {
+			name += ".parquet"
+		}
+		return d.do(ctx, "PUT", batch.container, name, nil, map[string]string{"x-ms-blob-type": "BlockBlob"}, batch.buf.Bytes())
+	}
+	key := batch.container + "/" + batch.blob
+	if !d.created[key] {
+		err := d.do(
+			ctx, "PUT", batch.container, batch.blob, nil,
+			map[string]string{"x-ms-blob-type": "AppendBlob", "If-None-Match": "*"},
+			nil,
+		)
+		if err != nil && !eerrors.Is("BlobExists", err) {
+			return err
+		}
+		d.created[key] = true
+	}
+	err := d.do(ctx, "PUT", batch.container, batch.blob, url.Values{"comp": []string{"appendblock"}}, nil, batch.buf.Bytes())
+	if eerrors.Is("BlobNotFound", err) {
+		// the blob was deleted behind our back
+		delete(d.created, key)
+	}
+	return err
+}
+
+func (d *AzureBlobDestination) do(ctx context.Context, method, container, blob string, params url.Values, headers map[string]string, body []byte) error {
+	u := d.endpoint + "/" + url.PathEscape(container) + "/" + escapeBlobName(blob)
+	query := params.Encode()
+	if len(d.config.SASToken) > 0 {
+		if len(query) > 0 {
+			query += "&"
+		}
+		query += strings.TrimPrefix(d.config.SASToken, "?")
+	}
+	if len(query) > 0 {
+		u += "?" + query
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if len(d.config.SASToken) == 0 {
+		token, err := d.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = eerrors.Errorf("Azure blob storage returned '%s': %s", resp.Status, strings.TrimSpace(string(msg)))
+	types := make([]string, 0, 2)
+	if code := resp.Header.Get("x-ms-error-code"); len(code) > 0 {
+		types = append(types, code)
+	}
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusPreconditionFailed {
+		types = append(types, "BlobExists")
+	}
+	return eerrors.WithTypes(err, types...)
+}
+
+// accessToken returns a token for the managed identity of the host.
+func (d *AzureBlobDestination) accessToken(ctx context.Context) (string, error) {
+	d.token.Lock()
+	defer d.token.Unlock()
+	if len(d.token.value) > 0 && time.Now().Add(time.Minute).Before(d.token.expiry) {
+		return d.token.value, nil
+	}
+	params := url.Values{}
+	params.Set("api-version", "2018-02-01")
+	params.Set("resource", "https://storage.azure.com/")
+	if len(d.config.ClientID) > 0 {
+		params.Set("client_id", d.config.ClientID)
+	}
+	req, err := http.NewRequest("GET", azureIMDSURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", eerrors.Wrap(err, "Error requesting an Azure access token")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", eerrors.Errorf("Azure identity endpoint returned '%s': %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var token struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", eerrors.Wrap(err, "Error decoding the Azure access token")
+	}
+	expiresIn, _ := token.ExpiresIn.Int64()
+	d.token.value = token.AccessToken
+	d.token.expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	return d.token.value, nil
+}
+
+func escapeBlobName(name string) string {
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+func (d *AzureBlobDestination) Close() error {
+	d.flushAll(context.Background())
+	return nil
+}
+
+func (d *AzureBlobDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
+	// messages are ACKed when their batch has been uploaded
+	return d.ForEach(ctx, d.sendOne, false, true, msgs)
+}
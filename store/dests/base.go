@@ -12,6 +12,7 @@ import (
 	"github.com/stephane-martin/skewer/encoders/baseenc"
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/sys/binder"
+	"github.com/stephane-martin/skewer/sys/kring"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
 	"github.com/stephane-martin/skewer/utils/queue/message"
@@ -25,6 +26,9 @@ var fatalCounter *prometheus.CounterVec
 var httpStatusCounter *prometheus.CounterVec
 var kafkaInputsCounter prometheus.Counter
 var openedFilesGauge prometheus.Gauge
+var nullMessagesCounter prometheus.Counter
+var nullBytesCounter prometheus.Counter
+var alertsCounter *prometheus.CounterVec
 
 var once sync.Once
 
@@ -76,6 +80,28 @@ func InitRegistry() {
 			},
 		)
 
+		nullMessagesCounter = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "skw_dest_null_messages_total",
+				Help: "number of messages discarded by the null destination",
+			},
+		)
+
+		nullBytesCounter = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "skw_dest_null_bytes_total",
+				Help: "number of encoded bytes discarded by the null destination",
+			},
+		)
+
+		alertsCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_dest_alerts_total",
+				Help: "number of alerts handled by the alert destination",
+			},
+			[]string{"status"},
+		)
+
 		Registry = prometheus.NewRegistry()
 		Registry.MustRegister(
 			ackCounter,
@@ -84,6 +110,9 @@ func InitRegistry() {
 			kafkaInputsCounter,
 			httpStatusCounter,
 			openedFilesGauge,
+			nullMessagesCounter,
+			nullBytesCounter,
+			alertsCounter,
 		)
 	})
 }
@@ -96,6 +125,7 @@ type Env struct {
 	permerr  storeCallback
 	confined bool
 	config   conf.BaseConfig
+	ring     kring.Ring
 }
 
 func BuildEnv() *Env {
@@ -129,6 +159,11 @@ func (e *Env) Config(c conf.BaseConfig) *Env {
 	return e
 }
 
+func (e *Env) Ring(r kring.Ring) *Env {
+	e.ring = r
+	return e
+}
+
 type baseDestination struct {
 	logger   log15.Logger
 	binder   binder.Client
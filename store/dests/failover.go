@@ -0,0 +1,85 @@
+package dests
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// endpoint is the address of a TCP or RELP server.
+type endpoint struct {
+	host string
+	port int
+}
+
+func (ep endpoint) String() string {
+	return net.JoinHostPort(ep.host, strconv.FormatInt(int64(ep.port), 10))
+}
+
+// failoverEndpoints returns the configured server, followed by the failover
+// servers.
+func failoverEndpoints(host string, port int, c conf.FailoverBaseConfig) ([]endpoint, error) {
+	endpoints := []endpoint{{host: host, port: port}}
+	for _, addr := range c.Failover {
+		h, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, eerrors.Wrapf(err, "Invalid failover server: '%s'", addr)
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, eerrors.Errorf("Invalid failover server port: '%s'", addr)
+		}
+		endpoints = append(endpoints, endpoint{host: h, port: port})
+	}
+	return endpoints, nil
+}
+
+// connectFailover calls connect for each endpoint, in order, until one
+// succeeds. It returns the index of that endpoint.
+func (base *baseDestination) connectFailover(endpoints []endpoint, connect func(endpoint) error) (int, error) {
+	var err error
+	for i, ep := range endpoints {
+		err = connect(ep)
+		if err == nil {
+			if i > 0 {
+				base.logger.Warn("Connected to a failover server", "dest", base.codename, "server", ep.String())
+			}
+			return i, nil
+		}
+		if len(endpoints) > 1 {
+			base.logger.Info("Error connecting to server", "dest", base.codename, "server", ep.String(), "error", err)
+		}
+	}
+	return -1, err
+}
+
+// watchFailback checks periodically if a server that is preferred over the
+// current one accepts connections again. When it does, the destination is
+// stopped, so that it is created again with the preferred server.
+func (base *baseDestination) watchFailback(ctx context.Context, endpoints []endpoint, current int, period, timeout time.Duration) {
+	if current <= 0 || period <= 0 {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(period):
+			}
+			for _, ep := range endpoints[:current] {
+				conn, err := net.DialTimeout("tcp", ep.String(), timeout)
+				if err != nil {
+					continue
+				}
+				_ = conn.Close()
+				base.dofatal(eerrors.Errorf("Preferred server '%s' is available again", ep.String()))
+				return
+			}
+		}
+	}()
+}
@@ -1,10 +1,15 @@
 package dests
 
 import (
+	"compress/gzip"
 	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
@@ -13,6 +18,8 @@ import (
 	"github.com/inconshreveable/log15"
 	"github.com/stephane-martin/skewer/conf"
 	"github.com/stephane-martin/skewer/encoders"
+	"github.com/stephane-martin/skewer/encoders/baseenc"
+	"github.com/stephane-martin/skewer/encoders/parquet"
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/ctrie/filetrie"
@@ -60,6 +67,10 @@ func (m *filesMap) Remove(fname string) {
 	}
 }
 
+func (m *filesMap) Size() int {
+	return m.fm.Size()
+}
+
 func (m *filesMap) ForEach(f func(string, *utils.OFile)) {
 	g := func(e filetrie.Entry) {
 		f(e.Key, e.Value)
@@ -90,25 +101,49 @@ type openedFiles struct {
 	files       *filesMap
 	filesMu     sync.Mutex
 	timeout     time.Duration
+	maxFiles    int
 	logger      log15.Logger
 	bufferSize  int
 	flushPeriod time.Duration
 	syncPeriod  time.Duration
 	gzip        bool
 	gziplevel   int
+	rotateSize  int64
+	rotateEvery time.Duration
+	rotateFmt   string
+	rotateGzip  bool
+	keepFiles   int
+	keepAge     time.Duration
+	// parquet is not nil when the files are written in the Parquet format
+	parquet      []parquet.Column
+	parquetCodec parquet.Codec
+	rowGroupSize int
+	rollPeriod   time.Duration
+	ack          func(utils.MyULID)
+	nack         func(utils.MyULID)
 }
 
 func newOpenedFiles(ctx context.Context, c conf.FileDestConfig, l log15.Logger) *openedFiles {
 	o := openedFiles{
 		files:       newFilesMap(),
 		timeout:     c.OpenFileTimeout,
+		maxFiles:    c.OpenFilesCache,
 		bufferSize:  c.BufferSize,
 		flushPeriod: c.FlushPeriod,
 		syncPeriod:  c.SyncPeriod,
 		gzip:        c.Gzip,
 		gziplevel:   c.GzipLevel,
+		rotateSize:  c.RotateSize,
+		rotateEvery: c.RotateInterval,
+		rotateFmt:   c.RotatePattern,
+		rotateGzip:  c.RotateGzip,
+		keepFiles:   c.MaxFiles,
+		keepAge:     c.MaxAge,
 		logger:      l,
 	}
+	if len(o.rotateFmt) == 0 {
+		o.rotateFmt = "20060102-150405"
+	}
 	go func() {
 		// flush the buffers periodically
 		lastSync := time.Now()
@@ -151,7 +186,7 @@ func newOpenedFiles(ctx context.Context, c conf.FileDestConfig, l log15.Logger)
 			}
 			o.filesMu.Lock()
 			for f := range o.files.Filter(func(fname string, fi *utils.OFile) bool {
-				return fi.Expired()
+				return fi.Expired() || o.mustRoll(fi)
 			}) {
 				o.files.Remove(f.Name)
 			}
@@ -168,9 +203,14 @@ func (o *openedFiles) open(filename string) (fi *utils.OFile, err error) {
 	}
 	fi = o.files.Get(filename)
 	if fi != nil {
-		// fastpath
-		fi.Postpone(o.timeout)
-		return fi, nil
+		if !o.mustRotate(fi.Size(), fi.Start()) {
+			// fastpath
+			fi.Postpone(o.timeout)
+			return fi, nil
+		}
+		if fi.Release() {
+			openedFilesGauge.Dec()
+		}
 	}
 	dirname := filepath.Dir(filename)
 	err = os.MkdirAll(dirname, 0755)
@@ -178,24 +218,302 @@ func (o *openedFiles) open(filename string) (fi *utils.OFile, err error) {
 		return nil, err
 	}
 
-	o.logger.Debug("Opening file", "filename", filename)
 	o.filesMu.Lock()
 	defer o.filesMu.Unlock()
+	fi = o.files.Get(filename)
+	if fi != nil {
+		if !o.mustRotate(fi.Size(), fi.Start()) {
+			// another goroutine has already rotated the file
+			fi.Postpone(o.timeout)
+			return fi, nil
+		}
+		rotated, err := o.rotate(filename, fi.Start())
+		if err != nil {
+			o.logger.Warn("Error rotating file", "filename", filename, "error", err)
+			fi.Postpone(o.timeout)
+			return fi, nil
+		}
+		// the rotated file is archived when the last writer has released it
+		fi.OnClose(func() {
+			go o.archive(filename, rotated)
+		})
+		o.files.Remove(filename)
+		if fi.Release() {
+			openedFilesGauge.Dec()
+		}
+	} else if infos, err := os.Stat(filename); err == nil && (o.mustRotate(infos.Size(), infos.ModTime()) || (o.parquet != nil && infos.Size() > 0)) {
+		// the file was written before skewer was started, or before it was
+		// closed. A Parquet file can not be appended to.
+		rotated, err := o.rotate(filename, infos.ModTime())
+		if err != nil {
+			o.logger.Warn("Error rotating file", "filename", filename, "error", err)
+		} else {
+			go o.archive(filename, rotated)
+		}
+	}
+
+	o.logger.Debug("Opening file", "filename", filename)
+	if o.maxFiles > 0 && o.files.Size() >= o.maxFiles {
+		o.evict()
+	}
 	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return nil, err
 	}
 	openedFilesGauge.Inc()
 	fi = utils.NewOFile(f, filename, time.Now().Add(o.timeout), o.bufferSize, o.gzip, o.gziplevel, o.logger)
+	if o.parquet != nil {
+		fi.SetEncoder(&parquetFile{
+			Writer: parquet.NewWriter(fi, o.parquet, o.parquetCodec, o.rowGroupSize),
+			file:   fi,
+			ack:    o.ack,
+			nack:   o.nack,
+		})
+	}
 	o.files.Put(filename, fi)
 	fi.Acquire()
 	return fi, nil
 }
 
+// evict closes the least recently used file, to bound the number of opened
+// files. filesMu must be held.
+func (o *openedFiles) evict() {
+	var oldest *utils.OFile
+	o.files.ForEach(func(fname string, f *utils.OFile) {
+		if oldest == nil || f.ClosingTime().Before(oldest.ClosingTime()) {
+			oldest = f
+		}
+	})
+	if oldest != nil {
+		o.files.Remove(oldest.Name)
+	}
+}
+
+// mustRoll returns true when a Parquet file has been opened for too long, and
+// must be closed so that its messages are acknowledged. The closed file is
+// rotated when it is opened again.
+func (o *openedFiles) mustRoll(fi *utils.OFile) bool {
+	return o.parquet != nil && time.Since(fi.Start()) >= o.rollPeriod
+}
+
+// mustRotate returns true when a file with the given size, whose content
+// began to be written at start, must be rotated.
+func (o *openedFiles) mustRotate(size int64, start time.Time) bool {
+	if size == 0 {
+		return false
+	}
+	if o.rotateSize > 0 && size >= o.rotateSize {
+		return true
+	}
+	if o.rotateEvery > 0 {
+		return start.UTC().Truncate(o.rotateEvery).Before(time.Now().UTC().Truncate(o.rotateEvery))
+	}
+	return false
+}
+
+// rotate renames the file, and returns the new name. filesMu must be held.
+func (o *openedFiles) rotate(filename string, start time.Time) (string, error) {
+	base := filename + "." + start.Format(o.rotateFmt)
+	rotated := base
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%d", base, i)
+	}
+	err := os.Rename(filename, rotated)
+	if err != nil {
+		return "", err
+	}
+	o.logger.Info("Rotated file", "filename", filename, "rotated", rotated)
+	return rotated, nil
+}
+
+// archive compresses a rotated file if needed, and then applies the
+// retention policy to the rotated files.
+func (o *openedFiles) archive(filename, rotated string) {
+	// when the file was written with gzip, it is already compressed
+	if o.rotateGzip && !o.gzip {
+		err := o.compress(rotated)
+		if err != nil {
+			o.logger.Warn("Error compressing rotated file", "filename", rotated, "error", err)
+		}
+	}
+	o.purge(filename)
+}
+
+func (o *openedFiles) compress(name string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(name + ".gz")
+		}
+	}()
+	level := o.gziplevel
+	if level <= 0 || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	w, err := gzip.NewWriterLevel(dst, level)
+	if err != nil {
+		_ = dst.Close()
+		return err
+	}
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	cerr := dst.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+type rotatedFile struct {
+	name    string
+	modTime time.Time
+}
+
+// purge removes the rotated files of filename that are too old, or in excess.
+func (o *openedFiles) purge(filename string) {
+	if o.keepFiles == 0 && o.keepAge == 0 {
+		return
+	}
+	dirname := filepath.Dir(filename)
+	prefix := filepath.Base(filename) + "."
+	infos, err := ioutil.ReadDir(dirname)
+	if err != nil {
+		o.logger.Warn("Error listing rotated files", "directory", dirname, "error", err)
+		return
+	}
+	var rotated []rotatedFile
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		if !o.isRotated(strings.TrimPrefix(info.Name(), prefix)) {
+			continue
+		}
+		rotated = append(rotated, rotatedFile{name: filepath.Join(dirname, info.Name()), modTime: info.ModTime()})
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		return rotated[i].modTime.After(rotated[j].modTime)
+	})
+	limit := time.Now().Add(-o.keepAge)
+	for i, f := range rotated {
+		if (o.keepFiles > 0 && i >= o.keepFiles) || (o.keepAge > 0 && f.modTime.Before(limit)) {
+			err := os.Remove(f.name)
+			if err != nil && !os.IsNotExist(err) {
+				o.logger.Warn("Error removing rotated file", "filename", f.name, "error", err)
+				continue
+			}
+			o.logger.Info("Removed rotated file", "filename", f.name)
+		}
+	}
+}
+
+// isRotated checks that a file name suffix was generated by rotate.
+func (o *openedFiles) isRotated(suffix string) bool {
+	suffix = strings.TrimSuffix(suffix, ".gz")
+	if _, err := time.Parse(o.rotateFmt, suffix); err == nil {
+		return true
+	}
+	idx := strings.LastIndex(suffix, ".")
+	if idx == -1 {
+		return false
+	}
+	if _, err := strconv.Atoi(suffix[idx+1:]); err != nil {
+		return false
+	}
+	_, err := time.Parse(o.rotateFmt, suffix[:idx])
+	return err == nil
+}
+
+// parquetFile is the encoder of a Parquet file. A Parquet file can not be read
+// until its footer has been written, so the messages are only acknowledged
+// when the file is closed.
+type parquetFile struct {
+	*parquet.Writer
+	file *utils.OFile
+	uids []utils.MyULID
+	mu   sync.Mutex
+	ack  func(utils.MyULID)
+	nack func(utils.MyULID)
+}
+
+func (p *parquetFile) Write(m *model.FullMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.Writer.Write(m)
+	if err != nil {
+		return err
+	}
+	p.uids = append(p.uids, m.Uid)
+	return nil
+}
+
+// Close writes the footer, and then acknowledges the messages of the file.
+func (p *parquetFile) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.Writer.Close()
+	if err == nil {
+		err = p.file.Flush()
+	}
+	if err == nil {
+		err = p.file.Sync()
+	}
+	for _, uid := range p.uids {
+		if err == nil {
+			p.ack(uid)
+		} else {
+			p.nack(uid)
+		}
+	}
+	p.uids = nil
+	return err
+}
+
+func fileExists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
 func (o *openedFiles) closeall() {
 	o.files.Clear()
 }
 
+// filenameData is given to the filename template. The syslog fields are
+// available directly, like {{.AppName}}, or under Fields, like
+// {{.Fields.HostName}}.
+type filenameData struct {
+	*model.SyslogMessage
+	Fields     *model.SyslogMessage
+	ClientAddr string
+	SourceType string
+}
+
+// cleanPathElement makes sure that a message field can not change the
+// directory where the file is written.
+func cleanPathElement(s string) string {
+	s = strings.Replace(strings.Replace(s, "/", "_", -1), "\\", "_", -1)
+	if s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
 type FileDestination struct {
 	*baseDestination
 	filenameTmpl *template.Template
@@ -207,9 +525,26 @@ func NewFileDestination(ctx context.Context, e *Env) (Destination, error) {
 		baseDestination: newBaseDestination(conf.File, "file", e),
 		files:           newOpenedFiles(ctx, e.config.FileDest, e.logger),
 	}
-	err := dest.setFormat(e.config.FileDest.Format)
-	if err != nil {
-		return nil, err
+	var err error
+	if baseenc.ParseFormat(e.config.FileDest.Format) == baseenc.Parquet {
+		dest.format = baseenc.Parquet
+		dest.files.parquet, err = parquet.ParseColumns(e.config.FileDest.ParquetColumns)
+		if err != nil {
+			return nil, err
+		}
+		dest.files.parquetCodec, err = parquet.ParseCodec(e.config.FileDest.ParquetCompression)
+		if err != nil {
+			return nil, err
+		}
+		dest.files.rowGroupSize = e.config.FileDest.ParquetRowGroupSize
+		dest.files.rollPeriod = e.config.FileDest.ParquetRollPeriod
+		dest.files.ack = dest.ACK
+		dest.files.nack = dest.NACK
+	} else {
+		err = dest.setFormat(e.config.FileDest.Format)
+		if err != nil {
+			return nil, err
+		}
 	}
 	fname := e.config.FileDest.Filename
 	if e.confined {
@@ -226,8 +561,19 @@ func (d *FileDestination) sendOne(ctx context.Context, message *model.FullMessag
 	if len(message.Fields.AppName) == 0 {
 		message.Fields.AppName = "unknown"
 	}
+	fields := *message.Fields
+	fields.HostName = cleanPathElement(fields.HostName)
+	fields.AppName = cleanPathElement(fields.AppName)
+	fields.ProcId = cleanPathElement(fields.ProcId)
+	fields.MsgId = cleanPathElement(fields.MsgId)
+	data := filenameData{
+		SyslogMessage: &fields,
+		Fields:        &fields,
+		ClientAddr:    cleanPathElement(message.ClientAddr),
+		SourceType:    message.SourceType,
+	}
 	buf := bytebufferpool.Get()
-	err = d.filenameTmpl.Execute(buf, message.Fields)
+	err = d.filenameTmpl.Execute(buf, data)
 	if err != nil {
 		d.logger.Warn("Error calculating filename", "error", err)
 		return encoders.EncodingError(err)
@@ -235,10 +581,13 @@ func (d *FileDestination) sendOne(ctx context.Context, message *model.FullMessag
 	filename := strings.TrimSpace(buf.String())
 	bytebufferpool.Put(buf)
 
-	encoded, err := encoders.ChainEncode(d.encoder, message, "\n")
-	if err != nil {
-		d.logger.Warn("Error encoding message", "error", err)
-		return encoders.EncodingError(err)
+	var encoded string
+	if d.format != baseenc.Parquet {
+		encoded, err = encoders.ChainEncode(d.encoder, message, "\n")
+		if err != nil {
+			d.logger.Warn("Error encoding message", "error", err)
+			return encoders.EncodingError(err)
+		}
 	}
 
 	f, err := d.files.open(filename)
@@ -246,7 +595,11 @@ func (d *FileDestination) sendOne(ctx context.Context, message *model.FullMessag
 		d.logger.Warn("Error opening file", "filename", filename, "error", err)
 		return err
 	}
-	_, err = io.WriteString(f, encoded)
+	if pf, ok := f.Encoder().(*parquetFile); ok {
+		err = pf.Write(message)
+	} else {
+		_, err = io.WriteString(f, encoded)
+	}
 	if f.Release() {
 		openedFilesGauge.Dec()
 	}
@@ -259,5 +612,7 @@ func (d *FileDestination) Close() error {
 }
 
 func (d *FileDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
-	return d.ForEach(ctx, d.sendOne, true, true, msgs)
+	// the messages written to a Parquet file are acknowledged when the file
+	// is closed
+	return d.ForEach(ctx, d.sendOne, d.format != baseenc.Parquet, true, msgs)
 }
@@ -1,15 +1,21 @@
 package dests
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"net"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Graylog2/go-gelf/gelf"
 	"github.com/stephane-martin/skewer/conf"
 	"github.com/stephane-martin/skewer/encoders"
 	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
 )
 
@@ -21,7 +27,11 @@ type GraylogDestination struct {
 func NewGraylogDestination(ctx context.Context, e *Env) (Destination, error) {
 	hostport := net.JoinHostPort(e.config.GraylogDest.Host, strconv.FormatInt(int64(e.config.GraylogDest.Port), 10))
 	var w gelf.Writer
-	if strings.ToLower(strings.TrimSpace(e.config.GraylogDest.Mode)) == "udp" {
+	udp := strings.ToLower(strings.TrimSpace(e.config.GraylogDest.Mode)) == "udp"
+	if udp && e.config.GraylogDest.TLSEnabled {
+		return nil, eerrors.New("TLS is not available for the Graylog destination in udp mode")
+	}
+	if udp {
 		writer, err := gelf.NewUDPWriter(hostport)
 		if err != nil {
 			connCounter.WithLabelValues("graylog", "fail").Inc()
@@ -40,6 +50,26 @@ func NewGraylogDestination(ctx context.Context, e *Env) (Destination, error) {
 			writer.CompressionType = gelf.CompressGzip
 		}
 		w = writer
+	} else if e.config.GraylogDest.TLSEnabled {
+		config, err := utils.NewTLSConfig(
+			e.config.GraylogDest.Host,
+			e.config.GraylogDest.CAFile,
+			e.config.GraylogDest.CAPath,
+			e.config.GraylogDest.CertFile,
+			e.config.GraylogDest.KeyFile,
+			e.config.GraylogDest.Insecure,
+			e.confined,
+		)
+		if err != nil {
+			return nil, err
+		}
+		writer, err := newGraylogTLSWriter(hostport, config, e.config.GraylogDest.MaxReconnect, e.config.GraylogDest.ReconnectDelay)
+		if err != nil {
+			connCounter.WithLabelValues("graylog", "fail").Inc()
+			return nil, err
+		}
+		connCounter.WithLabelValues("graylog", "success").Inc()
+		w = writer
 	} else {
 		writer, err := gelf.NewTCPWriter(hostport)
 		if err != nil {
@@ -59,6 +89,93 @@ func NewGraylogDestination(ctx context.Context, e *Env) (Destination, error) {
 	return d, nil
 }
 
+// graylogTLSWriter sends GELF messages over TCP with TLS. As with plain TCP,
+// the messages are delimited by a null byte and can not be compressed.
+type graylogTLSWriter struct {
+	addr           string
+	config         *tls.Config
+	hostname       string
+	conn           net.Conn
+	maxReconnect   int
+	reconnectDelay time.Duration
+	mu             sync.Mutex
+}
+
+func newGraylogTLSWriter(addr string, config *tls.Config, maxReconnect int, reconnectDelay time.Duration) (*graylogTLSWriter, error) {
+	w := &graylogTLSWriter{
+		addr:           addr,
+		config:         config,
+		maxReconnect:   maxReconnect,
+		reconnectDelay: reconnectDelay,
+	}
+	w.hostname, _ = os.Hostname()
+	err := w.dial()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *graylogTLSWriter) dial() (err error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	w.conn, err = tls.DialWithDialer(dialer, "tcp", w.addr, w.config)
+	return err
+}
+
+func (w *graylogTLSWriter) WriteMessage(m *gelf.Message) error {
+	var buf bytes.Buffer
+	err := m.MarshalJSONBuf(&buf)
+	if err != nil {
+		return encoders.EncodingError(err)
+	}
+	buf.WriteByte(0)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := 0; ; i++ {
+		if w.conn != nil {
+			_, err = w.conn.Write(buf.Bytes())
+			if err == nil {
+				return nil
+			}
+			_ = w.conn.Close()
+			w.conn = nil
+		}
+		if i >= w.maxReconnect {
+			return eerrors.Wrap(err, "Error writing to Graylog, maximum reconnection attempts was reached")
+		}
+		time.Sleep(w.reconnectDelay)
+		err = w.dial()
+		if err != nil {
+			connCounter.WithLabelValues("graylog", "fail").Inc()
+		}
+	}
+}
+
+func (w *graylogTLSWriter) Write(p []byte) (int, error) {
+	m := &gelf.Message{
+		Version:  "1.1",
+		Host:     w.hostname,
+		Short:    string(bytes.TrimSpace(p)),
+		TimeUnix: float64(time.Now().UnixNano()) / 1e9,
+		Level:    gelf.LOG_INFO,
+	}
+	err := w.WriteMessage(m)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *graylogTLSWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
+
 func (d *GraylogDestination) Close() error {
 	return d.writer.Close()
 }
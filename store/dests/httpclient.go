@@ -3,17 +3,20 @@ package dests
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	"github.com/cenk/backoff"
 	circuit "github.com/rubyist/circuitbreaker"
 	"github.com/stephane-martin/skewer/conf"
 	"github.com/stephane-martin/skewer/encoders"
@@ -37,6 +40,13 @@ type HTTPDestination struct {
 	reqtimeout  time.Duration
 	queue       *defered.Ring
 	wg          sync.WaitGroup
+	maxRetries  int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	retryCodes  map[int]bool
+	ackRegexp   *regexp.Regexp
+	ackPath     []string
+	ackValue    string
 }
 
 func NewHTTPDestination(ctx context.Context, e *Env) (Destination, error) {
@@ -46,11 +56,38 @@ func NewHTTPDestination(ctx context.Context, e *Env) (Destination, error) {
 		useragent:       config.UserAgent,
 		method:          config.Method,
 		reqtimeout:      config.RequestTimeout,
+		maxRetries:      config.MaxRetries,
+		minBackoff:      config.RetryInitialBackoff,
+		maxBackoff:      config.RetryMaxBackoff,
+		retryCodes:      make(map[int]bool, len(config.RetryStatusCodes)),
 	}
 	err := d.setFormat(config.Format)
 	if err != nil {
 		return nil, err
 	}
+	for _, code := range config.RetryStatusCodes {
+		if code < 100 || code > 599 {
+			return nil, eerrors.Errorf("Invalid HTTP status code in retry_status_codes: %d", code)
+		}
+		d.retryCodes[code] = true
+	}
+	if len(config.ResponseAckRegexp) > 0 {
+		d.ackRegexp, err = regexp.Compile(config.ResponseAckRegexp)
+		if err != nil {
+			return nil, eerrors.Wrap(err, "Invalid response_ack_regexp")
+		}
+	}
+	d.ackPath, err = parseJSONPath(config.ResponseAckPath)
+	if err != nil {
+		return nil, err
+	}
+	d.ackValue = config.ResponseAckValue
+	if d.minBackoff <= 0 {
+		d.minBackoff = 500 * time.Millisecond
+	}
+	if d.maxBackoff < d.minBackoff {
+		d.maxBackoff = d.minBackoff
+	}
 
 	config.ContentType = strings.TrimSpace(strings.ToLower(config.ContentType))
 	d.contentType = config.ContentType
@@ -196,51 +233,164 @@ func (d *HTTPDestination) doHTTP(ctx context.Context, uid utils.MyULID, req *htt
 	}
 	req = req.WithContext(ctx)
 
-	// perform the HTTP request, retry every second, use a circuit breaker to limit the tries
-	var resp *http.Response
-	for {
-		err = d.breaker.CallContext(
-			ctx, func() (e error) {
-				resp, e = d.clt.Do(req.WithContext(ctx))
-				return e
-			},
-			d.reqtimeout,
-		)
-		if err == nil {
-			break
-		}
-		if err == context.Canceled {
-			return nil
+	// perform the HTTP request, use a circuit breaker to limit the tries, and
+	// retry with an exponential backoff
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = d.minBackoff
+	b.MaxInterval = d.maxBackoff
+	b.MaxElapsedTime = 0
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return context.Canceled
+			case <-time.After(b.NextBackOff()):
+			}
+			if req.GetBody != nil {
+				req.Body, err = req.GetBody()
+				if err != nil {
+					return err
+				}
+			}
 		}
-		if eerrors.HasConnRefused(err) {
-			// we stop if there is not even a HTTP server listening
+		err = d.do(req)
+		if err == nil || !eerrors.Is("Retry", err) || attempt >= d.maxRetries {
 			return err
 		}
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-time.After(time.Second):
-		}
 	}
+}
 
-	// not interested in response body
+// do performs the HTTP request. The returned error has the "Retry" type when
+// the request can be retried, and the "Permanent" type when the server has
+// rejected the message.
+func (d *HTTPDestination) do(req *http.Request) (err error) {
+	var resp *http.Response
+	err = d.breaker.CallContext(
+		req.Context(), func() (e error) {
+			resp, e = d.clt.Do(req)
+			return e
+		},
+		d.reqtimeout,
+	)
+	if err == context.Canceled || eerrors.HasConnRefused(err) {
+		// we stop if there is not even a HTTP server listening
+		return err
+	}
+	if err != nil {
+		// timeout, or the circuit breaker is open
+		return eerrors.WithTypes(err, "Retry")
+	}
+
+	var body []byte
+	if d.ackRegexp != nil || d.ackPath != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	}
+	// not interested in the rest of the response body
 	io.Copy(ioutil.Discard, resp.Body)
 	resp.Body.Close()
 
 	httpStatusCounter.WithLabelValues(req.Host, strconv.FormatInt(int64(resp.StatusCode), 10)).Inc()
 
 	if 200 <= resp.StatusCode && resp.StatusCode < 300 {
+		if err != nil {
+			return eerrors.Wrap(err, "Error reading the HTTP response")
+		}
+		return d.checkResponse(body)
+	}
+	err = eerrors.Errorf("HTTP error when sending message to server: code '%d', status '%s'", resp.StatusCode, resp.Status)
+	switch {
+	case d.retryCodes[resp.StatusCode]:
+		return eerrors.WithTypes(err, "Retry")
+	case 500 <= resp.StatusCode && resp.StatusCode < 600:
+		// server side error, the message will be sent again later
+		return err
+	default:
+		// client-side error, sending the message again will not help
+		return eerrors.WithTypes(err, "Permanent")
+	}
+}
+
+// checkResponse decides if a message was accepted by the server, when the
+// status code is not enough, like for bulk APIs that return 200 with errors.
+func (d *HTTPDestination) checkResponse(body []byte) error {
+	if d.ackRegexp != nil && !d.ackRegexp.Match(body) {
+		return eerrors.Errorf("HTTP response does not match response_ack_regexp: '%s'", truncate(body, 256))
+	}
+	if d.ackPath == nil {
 		return nil
 	}
-	if 400 <= resp.StatusCode && resp.StatusCode < 500 {
-		// client-side error ??!
-		return eerrors.Errorf("HTTP error when sending message to server: code '%d', status '%s'", resp.StatusCode, resp.Status)
+	var doc interface{}
+	err := json.Unmarshal(body, &doc)
+	if err != nil {
+		return eerrors.Wrap(err, "HTTP response is not valid JSON")
+	}
+	value, ok := lookupJSONPath(doc, d.ackPath)
+	if !ok {
+		return eerrors.Errorf("HTTP response has no value at response_ack_path: '%s'", truncate(body, 256))
+	}
+	if value != d.ackValue {
+		return eerrors.Errorf("HTTP response has value '%s' at response_ack_path: '%s'", value, truncate(body, 256))
 	}
-	if 500 <= resp.StatusCode && resp.StatusCode < 600 {
-		// server side error
-		return eerrors.Errorf("HTTP error when sending message to server: code '%d', status '%s'", resp.StatusCode, resp.Status)
+	return nil
+}
+
+func truncate(b []byte, n int) string {
+	if len(b) > n {
+		b = b[:n]
+	}
+	return string(b)
+}
+
+// parseJSONPath parses a simple JSONPath made of object keys and array
+// indexes, like "$.items.0.status".
+func parseJSONPath(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	path = strings.TrimPrefix(path, ".")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	parts := strings.Split(path, ".")
+	for _, part := range parts {
+		if len(part) == 0 {
+			return nil, eerrors.Errorf("Invalid response_ack_path: '%s'", path)
+		}
+	}
+	return parts, nil
+}
+
+// lookupJSONPath returns the value at path in the decoded JSON document. A
+// scalar value is returned as its JSON representation without the quotes.
+func lookupJSONPath(doc interface{}, path []string) (string, bool) {
+	for _, part := range path {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			doc, ok = v[part]
+			if !ok {
+				return "", false
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return "", false
+			}
+			doc = v[idx]
+		default:
+			return "", false
+		}
+	}
+	switch v := doc.(type) {
+	case string:
+		return v, true
+	case nil:
+		return "null", true
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
 	}
-	return eerrors.Errorf("HTTP error when sending message to server: code '%d', status '%s'", resp.StatusCode, resp.Status)
 }
 
 func (d *HTTPDestination) dequeue(ctx context.Context) error {
@@ -250,11 +400,22 @@ func (d *HTTPDestination) dequeue(ctx context.Context) error {
 			return nil
 		}
 		err = d.doHTTP(ctx, defered.UID, defered.Request)
-		if err != nil {
+		switch {
+		case err == nil:
+			d.ACK(defered.UID)
+		case err == context.Canceled:
+			d.NACK(defered.UID)
+			return nil
+		case eerrors.HasConnRefused(err):
 			d.NACK(defered.UID)
 			return err
+		case eerrors.Is("Permanent", err):
+			d.logger.Warn("HTTP server rejected message", "error", err)
+			d.PermError(defered.UID)
+		default:
+			d.logger.Info("HTTP request failed, message will be retried", "error", err)
+			d.NACK(defered.UID)
 		}
-		d.ACK(defered.UID)
 	}
 }
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/encoders"
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
@@ -35,11 +36,38 @@ var destinations = map[conf.DestinationType]constructor{
 	conf.NATS:            NewNATSDestination,
 	conf.HTTPServer:      NewHTTPServerDestination,
 	conf.WebsocketServer: NewWebsocketServerDestination,
+	conf.WebsocketClient: NewWebsocketClientDestination,
 	conf.Elasticsearch:   NewElasticDestination,
 	conf.Redis:           NewRedisDestination,
+	conf.AzureBlob:       NewAzureBlobDestination,
+	conf.MongoDB:         NewMongoDBDestination,
+	conf.NSQ:             NewNSQDestination,
+	conf.Pulsar:          NewPulsarDestination,
+	conf.SQS:             NewSQSDestination,
+	conf.SyslogTLS:       NewSyslogTLSDestination,
+	conf.Null:            NewNullDestination,
+	conf.PromRemoteWrite: NewPromRemoteWriteDestination,
+	conf.Alert:           NewAlertDestination,
 }
 
 func NewDestination(ctx context.Context, typ conf.DestinationType, e *Env) (Destination, error) {
+	err := encoders.ConfigureCEF(e.config.CEF)
+	if err != nil {
+		return nil, err
+	}
+	err = encoders.ConfigureLEEF(e.config.LEEF)
+	if err != nil {
+		return nil, err
+	}
+	encoders.ConfigureFlatJSON(e.config.FlatJSON)
+	err = encoders.ConfigureCSV(e.config.CSV)
+	if err != nil {
+		return nil, err
+	}
+	err = encoders.ConfigureTemplates(e.config.Templates)
+	if err != nil {
+		return nil, err
+	}
 	if c, ok := destinations[typ]; ok {
 		return c(ctx, e)
 	}
@@ -2,35 +2,79 @@ package dests
 
 import (
 	"context"
+	"net/http"
+	"net/url"
 	"sync"
 
 	sarama "github.com/Shopify/sarama"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/encoders"
+	"github.com/stephane-martin/skewer/encoders/baseenc"
 	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/model/avro"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/schemaregistry"
 	"github.com/valyala/bytebufferpool"
 )
 
 type KafkaDestination struct {
 	*baseDestination
 	producer   sarama.AsyncProducer
+	headers    []conf.KafkaHeader
+	admin      sarama.Client
+	topic      *sarama.TopicDetail
+	topics     map[string]bool
+	registry   *schemaregistry.Client
+	schema     string
+	schemaName string
+	strategy   string
 	collectors []prometheus.Collector
 	wg         sync.WaitGroup
+	// in exactly_once mode, the messages of a batch are acked when the
+	// transaction is committed
+	exactlyOnce bool
+	pending     []utils.MyULID
 }
 
 func NewKafkaDestination(ctx context.Context, e *Env) (Destination, error) {
 	d := &KafkaDestination{
 		baseDestination: newBaseDestination(conf.Kafka, "kafka", e),
+		exactlyOnce:     e.config.KafkaDest.ExactlyOnce,
 	}
 	err := d.setFormat(e.config.KafkaDest.Format)
 	if err != nil {
 		return nil, err
 	}
+	d.headers, err = e.config.KafkaDest.GetHeaders()
+	if err != nil {
+		return nil, err
+	}
+
+	d.topic, err = e.config.KafkaDest.GetTopicDetail()
+	if err != nil {
+		return nil, err
+	}
+	d.topics = make(map[string]bool)
+	err = d.initSchemaRegistry(e)
+	if err != nil {
+		return nil, err
+	}
 
-	producer, registry, err := e.config.KafkaDest.GetAsyncProducer(e.confined)
+	if d.topic != nil {
+		d.admin, err = e.config.KafkaDest.GetClient(e.confined, e.ring)
+		if err != nil {
+			connCounter.WithLabelValues("kafka", "fail").Inc()
+			return nil, err
+		}
+	}
+
+	producer, registry, err := e.config.KafkaDest.GetAsyncProducer(e.confined, e.ring)
 	if err != nil {
+		if d.admin != nil {
+			_ = d.admin.Close()
+		}
 		connCounter.WithLabelValues("kafka", "fail").Inc()
 		return nil, err
 	}
@@ -46,7 +90,9 @@ func NewKafkaDestination(ctx context.Context, e *Env) (Destination, error) {
 	d.wg.Add(1)
 	go func() {
 		for m := range d.producer.Successes() {
-			d.ACK(m.Metadata.(utils.MyULID))
+			if !d.exactlyOnce {
+				d.ACK(m.Metadata.(utils.MyULID))
+			}
 		}
 		d.wg.Done()
 	}()
@@ -55,7 +101,9 @@ func NewKafkaDestination(ctx context.Context, e *Env) (Destination, error) {
 	d.wg.Add(1)
 	go func() {
 		for m := range d.producer.Errors() {
-			d.NACK(m.Msg.Metadata.(utils.MyULID))
+			if !d.exactlyOnce {
+				d.NACK(m.Msg.Metadata.(utils.MyULID))
+			}
 			if model.IsFatalKafkaError(m.Err) {
 				d.dofatal(eerrors.Wrap(m.Err, "Kafka fatal error"))
 			}
@@ -75,8 +123,110 @@ func NewKafkaDestination(ctx context.Context, e *Env) (Destination, error) {
 	return d, nil
 }
 
+func (d *KafkaDestination) initSchemaRegistry(e *Env) error {
+	config := e.config.KafkaDest
+	if len(config.SchemaRegistryURL) == 0 {
+		return nil
+	}
+	switch d.format {
+	case baseenc.AVRO:
+		d.schema = avro.NewSyslogMessage().Schema()
+		d.schemaName = "skewer.SyslogMessage"
+	case baseenc.FullAVRO:
+		d.schema = avro.NewFullMessage().Schema()
+		d.schemaName = "skewer.FullMessage"
+	default:
+		return eerrors.New("The schema registry needs the avro or fullavro format")
+	}
+	u, err := url.Parse(config.SchemaRegistryURL)
+	if err != nil {
+		return eerrors.Wrap(err, "Invalid schema registry URL")
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if u.Scheme == "https" {
+		transport.TLSClientConfig, err = utils.NewTLSConfig(u.Hostname(), "", "", "", "", config.SchemaRegistryInsecure, e.confined)
+		if err != nil {
+			return err
+		}
+	}
+	clt := &http.Client{Transport: transport, Timeout: config.ProducerTimeout}
+	d.registry = schemaregistry.NewClient(config.SchemaRegistryURL, config.SchemaRegistryUsername, config.SchemaRegistryPassword, clt)
+	d.strategy = config.SubjectNameStrategy
+	return nil
+}
+
+// subject returns the schema registry subject for the topic.
+func (d *KafkaDestination) subject(topic string) string {
+	switch d.strategy {
+	case conf.RecordNameStrategy:
+		return d.schemaName
+	case conf.TopicRecordNameStrategy:
+		return topic + "-" + d.schemaName
+	default:
+		return topic + "-value"
+	}
+}
+
+// ensureTopic creates the topic if it does not exist yet.
+func (d *KafkaDestination) ensureTopic(topic string) error {
+	if d.topics[topic] {
+		return nil
+	}
+	topics, err := d.admin.Topics()
+	if err != nil {
+		return err
+	}
+	for _, t := range topics {
+		if t == topic {
+			d.topics[topic] = true
+			return nil
+		}
+	}
+	controller, err := d.admin.Controller()
+	if err != nil {
+		return err
+	}
+	resp, err := controller.CreateTopics(&sarama.CreateTopicsRequest{
+		TopicDetails: map[string]*sarama.TopicDetail{topic: d.topic},
+		Timeout:      d.admin.Config().Producer.Timeout,
+	})
+	if err != nil {
+		return eerrors.Wrapf(err, "Error creating Kafka topic '%s'", topic)
+	}
+	if terr, ok := resp.TopicErrors[topic]; ok && terr.Err != sarama.ErrNoError && terr.Err != sarama.ErrTopicAlreadyExists {
+		if terr.Err == sarama.ErrInvalidTopic || terr.Err == sarama.ErrPolicyViolation || terr.Err == sarama.ErrInvalidConfig {
+			// retrying would not help
+			return encoders.EncodingError(eerrors.Wrapf(terr.Err, "Kafka refused to create topic '%s'", topic))
+		}
+		return eerrors.Wrapf(terr.Err, "Error creating Kafka topic '%s'", topic)
+	}
+	d.logger.Info("Created Kafka topic", "topic", topic)
+	err = d.admin.RefreshMetadata(topic)
+	if err != nil {
+		return err
+	}
+	d.topics[topic] = true
+	return nil
+}
+
 func (d *KafkaDestination) sendOne(ctx context.Context, message *model.FullMessage, topic, pKey string, pNumber int32) (err error) {
+	if d.admin != nil {
+		err = d.ensureTopic(topic)
+		if err != nil {
+			return err
+		}
+	}
 	buf := bytebufferpool.Get()
+	if d.registry != nil {
+		// Confluent wire format: magic byte, schema ID, then the avro payload
+		var id int32
+		id, err = d.registry.Register(d.subject(topic), d.schema)
+		if err != nil {
+			bytebufferpool.Put(buf)
+			return err
+		}
+		_, _ = buf.Write(schemaregistry.Header(id))
+	}
 	err = d.encoder(message, buf)
 	if err != nil {
 		bytebufferpool.Put(buf)
@@ -92,17 +242,93 @@ func (d *KafkaDestination) sendOne(ctx context.Context, message *model.FullMessa
 		Metadata:  message.Uid,
 	}
 	bytebufferpool.Put(buf)
+	if len(d.headers) > 0 {
+		kafkaMsg.Headers = d.recordHeaders(message)
+	}
+	if d.exactlyOnce {
+		d.pending = append(d.pending, message.Uid)
+	}
 	d.producer.Input() <- kafkaMsg
 	kafkaInputsCounter.Inc()
 	return nil
 }
 
+// recordHeaders executes the header templates on the message. A header whose
+// template fails is not attached.
+func (d *KafkaDestination) recordHeaders(message *model.FullMessage) []sarama.RecordHeader {
+	headers := make([]sarama.RecordHeader, 0, len(d.headers))
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	for _, h := range d.headers {
+		buf.Reset()
+		err := h.Value.Execute(buf, message)
+		if err != nil {
+			d.logger.Debug("Error executing Kafka header template", "header", h.Name, "error", err)
+			continue
+		}
+		headers = append(headers, sarama.RecordHeader{
+			Key:   []byte(h.Name),
+			Value: append([]byte(nil), buf.Bytes()...),
+		})
+	}
+	return headers
+}
+
 func (d *KafkaDestination) Close() error {
 	d.producer.AsyncClose()
 	d.wg.Wait()
+	if d.admin != nil {
+		_ = d.admin.Close()
+	}
 	return nil
 }
 
 func (d *KafkaDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
-	return d.ForEachWithTopic(ctx, d.sendOne, false, true, msgs)
+	if !d.exactlyOnce {
+		return d.ForEachWithTopic(ctx, d.sendOne, false, true, msgs)
+	}
+	txnErr := d.producer.BeginTxn()
+	if txnErr != nil {
+		d.NACKRemaining(msgs)
+		d.dofatal(eerrors.Wrap(txnErr, "Failed to begin the Kafka transaction"))
+		return eerrors.ErrorSlice{txnErr}
+	}
+	d.pending = d.pending[:0]
+	err = d.ForEachWithTopic(ctx, d.sendOne, false, true, msgs)
+	if !sendFailed(err) {
+		// CommitTxn waits for the messages of the batch to be produced
+		txnErr = d.producer.CommitTxn()
+		if txnErr == nil {
+			for _, uid := range d.pending {
+				d.ACK(uid)
+			}
+			return err
+		}
+		err = append(err, txnErr)
+	}
+	// the store sends the messages again, in a new transaction
+	for _, uid := range d.pending {
+		d.NACK(uid)
+	}
+	if d.producer.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0 {
+		d.dofatal(eerrors.New("The Kafka transactional producer is in a fatal state"))
+		return err
+	}
+	txnErr = d.producer.AbortTxn()
+	if txnErr != nil {
+		d.dofatal(eerrors.Wrap(txnErr, "Failed to abort the Kafka transaction"))
+		err = append(err, txnErr)
+	}
+	return err
+}
+
+// sendFailed reports whether the batch was interrupted. The messages that
+// could not be encoded do not prevent the transaction from being committed.
+func sendFailed(errs eerrors.ErrorSlice) bool {
+	for _, err := range errs {
+		if !IsEncodingError(err) {
+			return true
+		}
+	}
+	return false
 }
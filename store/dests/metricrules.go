@@ -0,0 +1,180 @@
+package dests
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+// metricSource extracts a label or a value from a message. groups are the
+// submatches of the rule regular expression.
+type metricSource func(m *model.FullMessage, groups []string) string
+
+var metricFields = map[string]func(*model.FullMessage) string{
+	"appname":     func(m *model.FullMessage) string { return m.Fields.AppName },
+	"hostname":    func(m *model.FullMessage) string { return m.Fields.HostName },
+	"procid":      func(m *model.FullMessage) string { return m.Fields.ProcId },
+	"msgid":       func(m *model.FullMessage) string { return m.Fields.MsgId },
+	"facility":    func(m *model.FullMessage) string { return m.Fields.Facility.String() },
+	"severity":    func(m *model.FullMessage) string { return m.Fields.Severity.String() },
+	"client":      func(m *model.FullMessage) string { return m.ClientAddr },
+	"source_type": func(m *model.FullMessage) string { return m.SourceType },
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// newMetricSource parses a source specification. It returns the source and
+// the default label name for that source.
+func newMetricSource(spec string, match *regexp.Regexp) (metricSource, string, error) {
+	if f, ok := metricFields[strings.ToLower(spec)]; ok {
+		return func(m *model.FullMessage, _ []string) string { return f(m) }, strings.ToLower(spec), nil
+	}
+	if idx := strings.Index(spec, ":"); idx != -1 {
+		domain, key := spec[:idx], spec[idx+1:]
+		if len(domain) == 0 || len(key) == 0 {
+			return nil, "", eerrors.Errorf("Invalid metric property: '%s'", spec)
+		}
+		return func(m *model.FullMessage, _ []string) string {
+			return m.Fields.GetProperty(domain, key)
+		}, invalidLabelChars.ReplaceAllString(domain+"_"+key, "_"), nil
+	}
+	if match != nil {
+		if idx := match.SubexpIndex(spec); idx > 0 {
+			return func(_ *model.FullMessage, groups []string) string {
+				if idx < len(groups) {
+					return groups[idx]
+				}
+				return ""
+			}, invalidLabelChars.ReplaceAllString(spec, "_"), nil
+		}
+	}
+	return nil, "", eerrors.Errorf("Unknown metric source: '%s'", spec)
+}
+
+type metricLabel struct {
+	name   string
+	source metricSource
+}
+
+// metricRule is a compiled conf.MetricRuleConfig.
+type metricRule struct {
+	name    string
+	gauge   bool
+	match   *regexp.Regexp
+	appname *regexp.Regexp
+	value   metricSource
+	labels  []metricLabel
+}
+
+func newMetricRule(c conf.MetricRuleConfig) (r *metricRule, err error) {
+	r = &metricRule{name: c.Name, gauge: c.Type == "gauge"}
+	if len(c.Match) > 0 {
+		r.match, err = regexp.Compile(c.Match)
+		if err != nil {
+			return nil, eerrors.Wrapf(err, "Invalid match expression for metric '%s'", c.Name)
+		}
+	}
+	if len(c.AppName) > 0 {
+		r.appname, err = regexp.Compile(c.AppName)
+		if err != nil {
+			return nil, eerrors.Wrapf(err, "Invalid appname expression for metric '%s'", c.Name)
+		}
+	}
+	if len(c.Value) > 0 {
+		r.value, _, err = newMetricSource(strings.TrimSpace(c.Value), r.match)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range c.Labels {
+		name, source := "", strings.TrimSpace(spec)
+		if idx := strings.Index(source, "="); idx != -1 {
+			name, source = strings.TrimSpace(source[:idx]), strings.TrimSpace(source[idx+1:])
+		}
+		f, defaultName, err := newMetricSource(source, r.match)
+		if err != nil {
+			return nil, err
+		}
+		if len(name) == 0 {
+			name = defaultName
+		}
+		if invalidLabelChars.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, eerrors.Errorf("Invalid label name for metric '%s': '%s'", c.Name, name)
+		}
+		r.labels = append(r.labels, metricLabel{name: name, source: f})
+	}
+	return r, nil
+}
+
+// extract returns the labels and the value of the metric for the message.
+// ok is false when the message does not match the rule.
+func (r *metricRule) extract(m *model.FullMessage) (labels []promLabel, value float64, ok bool) {
+	if m.Fields == nil {
+		return nil, 0, false
+	}
+	if r.appname != nil && !r.appname.MatchString(m.Fields.AppName) {
+		return nil, 0, false
+	}
+	var groups []string
+	if r.match != nil {
+		groups = r.match.FindStringSubmatch(m.Fields.Message)
+		if groups == nil {
+			return nil, 0, false
+		}
+	}
+	value = 1
+	if r.value != nil {
+		var err error
+		value, err = strconv.ParseFloat(strings.TrimSpace(r.value(m, groups)), 64)
+		if err != nil {
+			return nil, 0, false
+		}
+	}
+	labels = make([]promLabel, 0, len(r.labels)+1)
+	labels = append(labels, promLabel{name: "__name__", value: r.name})
+	for _, l := range r.labels {
+		labels = append(labels, promLabel{name: l.name, value: l.source(m, groups)})
+	}
+	return labels, value, true
+}
+
+// mergeLabels adds the external labels that are not already set, and sorts
+// the labels by name, as remote write requires.
+func mergeLabels(labels, external []promLabel) []promLabel {
+	for _, ext := range external {
+		found := false
+		for _, l := range labels {
+			if l.name == ext.name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			labels = append(labels, ext)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+// seriesKey identifies a time series by its sorted labels.
+func seriesKey(labels []promLabel) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.name)
+		b.WriteByte(0xff)
+		b.WriteString(l.value)
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
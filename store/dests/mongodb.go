@@ -0,0 +1,235 @@
+package dests
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/encoders"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/mongo"
+)
+
+// a MongoDB command must stay below 16MiB
+const mongoMaxBatchBytes = 8 * 1024 * 1024
+
+type MongoDBDestination struct {
+	*baseDestination
+	config  conf.MongoDBDestConfig
+	client  *mongo.Client
+	docs    []interface{}
+	uids    []utils.MyULID
+	size    int
+	docsMu  sync.Mutex
+	flushMu sync.Mutex
+}
+
+func NewMongoDBDestination(ctx context.Context, e *Env) (Destination, error) {
+	config := e.config.MongoDBDest
+	d := &MongoDBDestination{
+		baseDestination: newBaseDestination(conf.MongoDB, "mongodb", e),
+		config:          config,
+	}
+	if len(config.Database) == 0 || len(config.Collection) == 0 {
+		return nil, eerrors.New("MongoDB destination needs a database and a collection")
+	}
+	if d.config.BatchSize <= 0 {
+		d.config.BatchSize = 1
+	}
+
+	var tlsConf *tls.Config
+	var err error
+	if config.TLSEnabled {
+		tlsConf, err = utils.NewTLSConfig(config.Host, config.CAFile, config.CAPath, config.CertFile, config.KeyFile, config.Insecure, e.confined)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.client, err = mongo.Dial(fmt.Sprintf("%s:%d", config.Host, config.Port), tlsConf, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Username) > 0 {
+		err = d.client.AuthSCRAMSHA256(config.AuthSource, config.Username, config.Password)
+		if err != nil {
+			_ = d.client.Close()
+			return nil, err
+		}
+	}
+	err = d.createCollection()
+	if err != nil {
+		_ = d.client.Close()
+		return nil, err
+	}
+
+	go func() {
+		// flush the pending documents periodically
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d.config.FlushPeriod):
+			}
+			d.flush()
+		}
+	}()
+
+	if config.Rebind > 0 {
+		go func() {
+			select {
+			case <-ctx.Done():
+				// the store service asked for stop
+			case <-time.After(config.Rebind):
+				d.dofatal(eerrors.Errorf("Rebind period has expired (%s)", config.Rebind.String()))
+			}
+		}()
+	}
+
+	return d, nil
+}
+
+// createCollection creates the capped collection if it does not exist yet.
+// Regular collections are created implicitly by the first insert.
+func (d *MongoDBDestination) createCollection() error {
+	if !d.config.Capped {
+		return nil
+	}
+	cmd := mongo.Doc{
+		{Key: "create", Value: d.config.Collection},
+		{Key: "capped", Value: true},
+		{Key: "size", Value: d.config.CappedSize},
+	}
+	if d.config.CappedMaxDocs > 0 {
+		cmd = append(cmd, mongo.Elem{Key: "max", Value: d.config.CappedMaxDocs})
+	}
+	_, err := d.client.Run(d.config.Database, cmd)
+	if eerrors.Is("NamespaceExists", err) {
+		d.logger.Info("MongoDB collection already exists", "collection", d.config.Collection)
+		return nil
+	}
+	return err
+}
+
+func mongoDocument(msg *model.FullMessage) mongo.Doc {
+	doc := mongo.Doc{
+		// the message UID is used as the document ID, so that a message
+		// delivered twice is stored only once
+		{Key: "_id", Value: msg.Uid.String()},
+		{Key: "client_addr", Value: msg.ClientAddr},
+		{Key: "source_type", Value: msg.SourceType},
+		{Key: "source_path", Value: msg.SourcePath},
+		{Key: "source_port", Value: msg.SourcePort},
+	}
+	if msg.Fields == nil {
+		return doc
+	}
+	fields := msg.Fields.Regular()
+	return append(
+		doc,
+		mongo.Elem{Key: "facility", Value: fields.Facility},
+		mongo.Elem{Key: "severity", Value: fields.Severity},
+		mongo.Elem{Key: "timereported", Value: fields.TimeReported},
+		mongo.Elem{Key: "timegenerated", Value: fields.TimeGenerated},
+		mongo.Elem{Key: "hostname", Value: fields.HostName},
+		mongo.Elem{Key: "appname", Value: fields.AppName},
+		mongo.Elem{Key: "procid", Value: fields.ProcID},
+		mongo.Elem{Key: "msgid", Value: fields.MsgID},
+		mongo.Elem{Key: "message", Value: fields.Message},
+		mongo.Elem{Key: "properties", Value: fields.Properties},
+	)
+}
+
+func (d *MongoDBDestination) sendOne(ctx context.Context, msg *model.FullMessage) error {
+	doc, err := mongoDocument(msg).Marshal()
+	if err != nil {
+		d.logger.Warn("Error encoding message to BSON", "error", err)
+		return encoders.EncodingError(err)
+	}
+	id := msg.Uid.String()
+	update := mongo.Doc{
+		{Key: "q", Value: mongo.Doc{{Key: "_id", Value: id}}},
+		{Key: "u", Value: mongo.Raw(doc)},
+		{Key: "upsert", Value: true},
+	}
+	d.docsMu.Lock()
+	d.docs = append(d.docs, update)
+	d.uids = append(d.uids, msg.Uid)
+	d.size += len(doc) + len(id)
+	full := len(d.docs) >= d.config.BatchSize || d.size >= mongoMaxBatchBytes
+	d.docsMu.Unlock()
+
+	if full {
+		d.flush()
+	}
+	return nil
+}
+
+// flush upserts the pending documents, and then ACKs or NACKs the messages.
+func (d *MongoDBDestination) flush() {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	d.docsMu.Lock()
+	docs, uids := d.docs, d.uids
+	d.docs, d.uids, d.size = nil, nil, 0
+	d.docsMu.Unlock()
+	if len(docs) == 0 {
+		return
+	}
+
+	reply, err := d.client.Run(d.config.Database, mongo.Doc{
+		{Key: "update", Value: d.config.Collection},
+		{Key: "updates", Value: docs},
+		{Key: "ordered", Value: false},
+	})
+	if err != nil {
+		connCounter.WithLabelValues("mongodb", "fail").Inc()
+		for _, uid := range uids {
+			d.NACK(uid)
+		}
+		d.logger.Warn("Error writing to MongoDB", "error", err)
+		if eerrors.Is("Connection", err) {
+			// the store will restart the destination
+			go d.dofatal(err)
+		}
+		return
+	}
+	connCounter.WithLabelValues("mongodb", "success").Inc()
+
+	// the documents that were rejected by the server would be rejected again
+	failed := make(map[int64]bool)
+	writeErrors, _ := reply.Get("writeErrors").([]interface{})
+	for _, we := range writeErrors {
+		weDoc, ok := we.(mongo.Doc)
+		if !ok {
+			continue
+		}
+		idx, ok := mongo.Int(weDoc.Get("index"))
+		if ok && idx >= 0 && idx < int64(len(uids)) {
+			failed[idx] = true
+			d.logger.Warn("MongoDB rejected a document", "error", weDoc.Get("errmsg"), "uid", uids[idx].String())
+		}
+	}
+	for i, uid := range uids {
+		if failed[int64(i)] {
+			d.PermError(uid)
+		} else {
+			d.ACK(uid)
+		}
+	}
+}
+
+func (d *MongoDBDestination) Close() error {
+	d.flush()
+	return d.client.Close()
+}
+
+func (d *MongoDBDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
+	// messages are ACKed when their batch has been written
+	return d.ForEach(ctx, d.sendOne, false, true, msgs)
+}
@@ -0,0 +1,124 @@
+package dests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/nsq"
+	"github.com/valyala/bytebufferpool"
+)
+
+// NSQ topic names are limited to 64 characters
+const nsqMaxTopicLength = 64
+
+type NSQDestination struct {
+	*baseDestination
+	producer *nsq.Producer
+	wg       sync.WaitGroup
+}
+
+func NewNSQDestination(ctx context.Context, e *Env) (Destination, error) {
+	config := e.config.NSQDest
+	d := &NSQDestination{
+		baseDestination: newBaseDestination(conf.NSQ, "nsq", e),
+	}
+	err := d.setFormat(config.Format)
+	if err != nil {
+		return nil, err
+	}
+	nsqConfig := nsq.Config{
+		AuthSecret:        config.AuthSecret,
+		DialTimeout:       config.DialTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		HeartbeatInterval: config.HeartbeatInterval,
+		MaxInFlight:       config.MaxInFlight,
+	}
+	if config.TLSEnabled {
+		nsqConfig.TLSConfig, err = utils.NewTLSConfig(config.Host, config.CAFile, config.CAPath, config.CertFile, config.KeyFile, config.Insecure, e.confined)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.producer, err = nsq.NewProducer(fmt.Sprintf("%s:%d", config.Host, config.Port), nsqConfig)
+	if err != nil {
+		connCounter.WithLabelValues("nsq", "fail").Inc()
+		return nil, err
+	}
+	connCounter.WithLabelValues("nsq", "success").Inc()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for res := range d.producer.Results() {
+			uid := res.Meta.(utils.MyULID)
+			switch {
+			case res.Err == nil:
+				d.ACK(uid)
+			case eerrors.Is("E_BAD_MESSAGE", res.Err) || eerrors.Is("E_BAD_TOPIC", res.Err):
+				d.logger.Warn("nsqd refused message", "error", res.Err, "uid", uid.String())
+				d.PermError(uid)
+			default:
+				d.NACK(uid)
+				d.dofatal(eerrors.Wrap(res.Err, "NSQ fatal error"))
+			}
+		}
+	}()
+
+	if config.Rebind > 0 {
+		go func() {
+			select {
+			case <-ctx.Done():
+				// the store service asked for stop
+			case <-time.After(config.Rebind):
+				d.dofatal(eerrors.Errorf("Rebind period has expired (%s)", config.Rebind.String()))
+			}
+		}()
+	}
+
+	return d, nil
+}
+
+// nsqTopic replaces the characters that are not allowed in NSQ topic names.
+func nsqTopic(topic string) string {
+	topic = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, topic)
+	if len(topic) > nsqMaxTopicLength {
+		topic = topic[:nsqMaxTopicLength]
+	}
+	return topic
+}
+
+func (d *NSQDestination) sendOne(ctx context.Context, msg *model.FullMessage, topic, partitionKey string, partitionNumber int32) (err error) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	err = d.encoder(msg, buf)
+	if err != nil {
+		return err
+	}
+	// we use buf.String() to get a copy of buf, so that we can release buf afterwards
+	return d.producer.Publish(nsqTopic(topic), []byte(buf.String()), msg.Uid)
+}
+
+func (d *NSQDestination) Close() error {
+	err := d.producer.Close()
+	d.wg.Wait()
+	return err
+}
+
+func (d *NSQDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
+	// messages are ACKed when nsqd has confirmed the publication
+	return d.ForEachWithTopic(ctx, d.sendOne, false, true, msgs)
+}
@@ -0,0 +1,53 @@
+package dests
+
+import (
+	"context"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/valyala/bytebufferpool"
+)
+
+// NullDestination acknowledges the messages without sending them anywhere.
+// It is meant to benchmark the sources, the parsers and the store.
+type NullDestination struct {
+	*baseDestination
+	encode bool
+}
+
+func NewNullDestination(ctx context.Context, e *Env) (Destination, error) {
+	d := &NullDestination{
+		baseDestination: newBaseDestination(conf.Null, "null", e),
+		encode:          e.config.NullDest.Encode,
+	}
+	err := d.setFormat(e.config.NullDest.Format)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *NullDestination) sendOne(ctx context.Context, message *model.FullMessage) (err error) {
+	if d.encode {
+		buf := bytebufferpool.Get()
+		err = d.encoder(message, buf)
+		if err == nil {
+			nullBytesCounter.Add(float64(buf.Len()))
+		}
+		bytebufferpool.Put(buf)
+		if err != nil {
+			return err
+		}
+	}
+	nullMessagesCounter.Inc()
+	return nil
+}
+
+func (d *NullDestination) Close() error {
+	return nil
+}
+
+func (d *NullDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
+	return d.ForEach(ctx, d.sendOne, true, true, msgs)
+}
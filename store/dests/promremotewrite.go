@@ -0,0 +1,251 @@
+package dests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+type promSeries struct {
+	labels []promLabel
+	value  float64
+}
+
+// PromRemoteWriteDestination extracts metrics from the messages, and pushes
+// them periodically to a Prometheus remote write endpoint. The messages are
+// ACKed as soon as their metrics have been extracted.
+type PromRemoteWriteDestination struct {
+	*baseDestination
+	clt      *http.Client
+	url      string
+	host     string
+	username string
+	password string
+	token    string
+	timeout  time.Duration
+	rules    []*metricRule
+	external []promLabel
+	seriesMu sync.Mutex
+	series   map[string]*promSeries
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewPromRemoteWriteDestination(ctx context.Context, e *Env) (Destination, error) {
+	config := e.config.PromRemoteWriteDest
+	d := &PromRemoteWriteDestination{
+		baseDestination: newBaseDestination(conf.PromRemoteWrite, "promremotewrite", e),
+		url:             strings.TrimSpace(config.URL),
+		token:           config.BearerToken,
+		timeout:         config.RequestTimeout,
+		series:          make(map[string]*promSeries),
+		done:            make(chan struct{}),
+	}
+	if config.BasicAuth {
+		d.username = config.Username
+		d.password = config.Password
+	}
+	if len(config.Metrics) == 0 {
+		return nil, eerrors.New("The promremotewrite destination needs at least one metric")
+	}
+	for _, ruleConf := range config.Metrics {
+		rule, err := newMetricRule(ruleConf)
+		if err != nil {
+			return nil, err
+		}
+		d.rules = append(d.rules, rule)
+	}
+	for _, label := range config.ExternalLabels {
+		idx := strings.Index(label, "=")
+		if idx == -1 {
+			return nil, eerrors.Errorf("External labels must be given as name=value: '%s'", label)
+		}
+		d.external = append(d.external, promLabel{
+			name:  strings.TrimSpace(label[:idx]),
+			value: strings.TrimSpace(label[idx+1:]),
+		})
+	}
+
+	u, err := url.Parse(d.url)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Invalid remote write URL")
+	}
+	d.host = u.Host
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if config.TLSEnabled || u.Scheme == "https" {
+		transport.TLSClientConfig, err = utils.NewTLSConfig(
+			u.Hostname(),
+			config.CAFile,
+			config.CAPath,
+			config.CertFile,
+			config.KeyFile,
+			config.Insecure,
+			e.confined,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.clt = &http.Client{Transport: transport}
+
+	period := config.PushPeriod
+	if period <= 0 {
+		period = 15 * time.Second
+	}
+	d.wg.Add(1)
+	go d.pushLoop(ctx, period)
+	return d, nil
+}
+
+func (d *PromRemoteWriteDestination) pushLoop(ctx context.Context, period time.Duration) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.done:
+			return
+		case <-ticker.C:
+			err := d.push()
+			if err != nil {
+				d.logger.Warn("Error pushing metrics to remote write endpoint", "url", d.url, "error", err)
+			}
+		}
+	}
+}
+
+// push sends the current value of every time series. The counters are
+// cumulative, so a failed push is caught up by the next one.
+func (d *PromRemoteWriteDestination) push() error {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	d.seriesMu.Lock()
+	if len(d.series) == 0 {
+		d.seriesMu.Unlock()
+		return nil
+	}
+	body := encodeWriteRequest(d.series, now)
+	d.seriesMu.Unlock()
+
+	req, err := http.NewRequest("POST", d.url, bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if len(d.username) > 0 {
+		req.SetBasicAuth(d.username, d.password)
+	} else if len(d.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+	if d.timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	resp, err := d.clt.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	httpStatusCounter.WithLabelValues(d.host, strconv.FormatInt(int64(resp.StatusCode), 10)).Inc()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return eerrors.Errorf("Remote write endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+func (d *PromRemoteWriteDestination) sendOne(ctx context.Context, msg *model.FullMessage) error {
+	for _, rule := range d.rules {
+		labels, value, ok := rule.extract(msg)
+		if !ok {
+			continue
+		}
+		labels = mergeLabels(labels, d.external)
+		key := seriesKey(labels)
+		d.seriesMu.Lock()
+		s, ok := d.series[key]
+		if !ok {
+			s = &promSeries{labels: labels}
+			d.series[key] = s
+		}
+		if rule.gauge {
+			s.value = value
+		} else {
+			s.value += value
+		}
+		d.seriesMu.Unlock()
+	}
+	return nil
+}
+
+func (d *PromRemoteWriteDestination) Close() error {
+	close(d.done)
+	d.wg.Wait()
+	return d.push()
+}
+
+func (d *PromRemoteWriteDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
+	return d.ForEach(ctx, d.sendOne, true, true, msgs)
+}
+
+// encodeWriteRequest encodes the time series as a remote write protobuf
+// WriteRequest, with one sample per time series.
+func encodeWriteRequest(series map[string]*promSeries, timestamp int64) []byte {
+	var req, ts, label, sample []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			label = label[:0]
+			label = appendProtoBytes(label, 1, l.name)
+			label = appendProtoBytes(label, 2, l.value)
+			ts = appendProtoBytes(ts, 1, string(label))
+		}
+		sample = sample[:0]
+		sample = appendProtoVarint(sample, 1<<3|1)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(s.value))
+		sample = append(sample, b[:]...)
+		sample = appendProtoVarint(sample, 2<<3|0)
+		sample = appendProtoVarint(sample, uint64(timestamp))
+		ts = appendProtoBytes(ts, 2, string(sample))
+		req = appendProtoBytes(req, 1, string(ts))
+	}
+	return req
+}
+
+func appendProtoVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendProtoBytes(b []byte, field uint64, v string) []byte {
+	b = appendProtoVarint(b, field<<3|2)
+	b = appendProtoVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,258 @@
+package dests
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/valyala/bytebufferpool"
+)
+
+// pulsarProducer is a connection to the websocket producer endpoint of a
+// Pulsar topic.
+type pulsarProducer struct {
+	topic   string
+	conn    *websocket.Conn
+	pending map[string]utils.MyULID
+	writeMu sync.Mutex
+	sync.Mutex
+}
+
+type pulsarSendRequest struct {
+	Payload []byte `json:"payload"`
+	Key     string `json:"key,omitempty"`
+	Context string `json:"context"`
+}
+
+type pulsarSendReceipt struct {
+	Result    string `json:"result"`
+	MessageID string `json:"messageId"`
+	ErrorMsg  string `json:"errorMsg"`
+	Context   string `json:"context"`
+}
+
+type PulsarDestination struct {
+	*baseDestination
+	config    conf.PulsarDestConfig
+	dialer    *websocket.Dialer
+	producers map[string]*pulsarProducer
+	closing   bool
+	wg        sync.WaitGroup
+	sync.Mutex
+}
+
+func NewPulsarDestination(ctx context.Context, e *Env) (Destination, error) {
+	config := e.config.PulsarDest
+	d := &PulsarDestination{
+		baseDestination: newBaseDestination(conf.Pulsar, "pulsar", e),
+		config:          config,
+		producers:       make(map[string]*pulsarProducer),
+	}
+	err := d.setFormat(config.Format)
+	if err != nil {
+		return nil, err
+	}
+	d.config.URL = strings.TrimRight(strings.TrimSpace(config.URL), "/")
+	u, err := url.Parse(d.config.URL)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Invalid Pulsar URL")
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, eerrors.Errorf("The Pulsar URL must be a ws:// or wss:// URL: '%s'", config.URL)
+	}
+	d.dialer = &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: config.ConnTimeout,
+	}
+	if config.TLSEnabled || u.Scheme == "wss" {
+		d.dialer.TLSClientConfig, err = utils.NewTLSConfig(u.Hostname(), config.CAFile, config.CAPath, config.CertFile, config.KeyFile, config.Insecure, e.confined)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Rebind > 0 {
+		go func() {
+			select {
+			case <-ctx.Done():
+				// the store service asked for stop
+			case <-time.After(config.Rebind):
+				d.dofatal(eerrors.Errorf("Rebind period has expired (%s)", config.Rebind.String()))
+			}
+		}()
+	}
+
+	return d, nil
+}
+
+// producerURL returns the websocket endpoint of the producer for the topic.
+func (d *PulsarDestination) producerURL(topic string) string {
+	persistence := "persistent"
+	if d.config.NonPersistent {
+		persistence = "non-persistent"
+	}
+	params := url.Values{}
+	params.Set("sendTimeoutMillis", strconv.FormatInt(int64(d.config.SendTimeout/time.Millisecond), 10))
+	params.Set("batchingEnabled", strconv.FormatBool(d.config.BatchingEnabled))
+	if d.config.BatchingEnabled {
+		params.Set("batchingMaxMessages", strconv.Itoa(d.config.BatchingMaxMessages))
+		params.Set("batchingMaxPublishDelay", strconv.FormatInt(int64(d.config.BatchingMaxPublishDelay/time.Millisecond), 10))
+	}
+	if d.config.MaxPendingMessages > 0 {
+		params.Set("maxPendingMessages", strconv.Itoa(d.config.MaxPendingMessages))
+	}
+	if len(d.config.CompressionType) > 0 {
+		params.Set("compressionType", d.config.CompressionType)
+	}
+	if len(d.config.ProducerName) > 0 {
+		params.Set("producerName", d.config.ProducerName)
+	}
+	return d.config.URL + "/ws/v2/producer/" + persistence + "/" +
+		url.PathEscape(d.config.Tenant) + "/" + url.PathEscape(d.config.Namespace) + "/" + url.PathEscape(topic) +
+		"?" + params.Encode()
+}
+
+func (d *PulsarDestination) getProducer(topic string) (*pulsarProducer, error) {
+	d.Lock()
+	defer d.Unlock()
+	if p, ok := d.producers[topic]; ok {
+		return p, nil
+	}
+	header := http.Header{}
+	if len(d.config.Token) > 0 {
+		header.Set("Authorization", "Bearer "+d.config.Token)
+	}
+	conn, resp, err := d.dialer.Dial(d.producerURL(topic), header)
+	if err != nil {
+		connCounter.WithLabelValues("pulsar", "fail").Inc()
+		if resp != nil {
+			return nil, eerrors.Wrapf(err, "Error creating the Pulsar producer for topic '%s' (%s)", topic, resp.Status)
+		}
+		return nil, eerrors.Wrapf(err, "Error creating the Pulsar producer for topic '%s'", topic)
+	}
+	connCounter.WithLabelValues("pulsar", "success").Inc()
+	p := &pulsarProducer{
+		topic:   topic,
+		conn:    conn,
+		pending: make(map[string]utils.MyULID),
+	}
+	d.producers[topic] = p
+	d.wg.Add(1)
+	go d.readReceipts(p)
+	return p, nil
+}
+
+// readReceipts ACKs or NACKs the messages when Pulsar sends back the receipts.
+func (d *PulsarDestination) readReceipts(p *pulsarProducer) {
+	defer d.wg.Done()
+	var err error
+	for {
+		var receipt pulsarSendReceipt
+		err = p.conn.ReadJSON(&receipt)
+		if err != nil {
+			break
+		}
+		p.Lock()
+		uid, ok := p.pending[receipt.Context]
+		delete(p.pending, receipt.Context)
+		p.Unlock()
+		if !ok {
+			continue
+		}
+		if receipt.Result == "ok" {
+			d.ACK(uid)
+			continue
+		}
+		d.logger.Warn("Pulsar did not accept message", "topic", p.topic, "result", receipt.Result, "error", receipt.ErrorMsg, "uid", uid.String())
+		d.NACK(uid)
+	}
+
+	d.Lock()
+	if d.producers[p.topic] == p {
+		delete(d.producers, p.topic)
+	}
+	closing := d.closing
+	d.Unlock()
+	_ = p.conn.Close()
+
+	// the messages without a receipt are lost
+	p.Lock()
+	pending := p.pending
+	p.pending = make(map[string]utils.MyULID)
+	p.Unlock()
+	for _, uid := range pending {
+		d.NACK(uid)
+	}
+	if !closing && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		d.dofatal(eerrors.Wrapf(err, "Pulsar producer for topic '%s' was disconnected", p.topic))
+	}
+}
+
+func (d *PulsarDestination) sendOne(ctx context.Context, msg *model.FullMessage, topic, partitionKey string, partitionNumber int32) (err error) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	err = d.encoder(msg, buf)
+	if err != nil {
+		return err
+	}
+	p, err := d.getProducer(topic)
+	if err != nil {
+		return err
+	}
+	req := pulsarSendRequest{
+		Payload: buf.Bytes(),
+		Key:     partitionKey,
+		Context: msg.Uid.String(),
+	}
+	p.Lock()
+	p.pending[req.Context] = msg.Uid
+	p.Unlock()
+
+	p.writeMu.Lock()
+	err = p.conn.WriteJSON(req)
+	p.writeMu.Unlock()
+	if err != nil {
+		// the message will be NACKed by ForEachWithTopic
+		p.Lock()
+		delete(p.pending, req.Context)
+		p.Unlock()
+	}
+	return err
+}
+
+func (d *PulsarDestination) Close() error {
+	d.Lock()
+	d.closing = true
+	producers := make([]*pulsarProducer, 0, len(d.producers))
+	for _, p := range d.producers {
+		producers = append(producers, p)
+	}
+	d.Unlock()
+	for _, p := range producers {
+		p.writeMu.Lock()
+		_ = p.conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second),
+		)
+		p.writeMu.Unlock()
+		// wait a bit for the last receipts
+		_ = p.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	}
+	d.wg.Wait()
+	return nil
+}
+
+func (d *PulsarDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
+	// messages are ACKed when Pulsar sends the receipt
+	return d.ForEachWithTopic(ctx, d.sendOne, false, true, msgs)
+}
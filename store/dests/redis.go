@@ -3,6 +3,8 @@ package dests
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -16,12 +18,17 @@ import (
 type RedisDestination struct {
 	*baseDestination
 	client *redis.Client
+	config conf.RedisDestConfig
 }
 
 func NewRedisDestination(ctx context.Context, e *Env) (Destination, error) {
 	config := e.config.RedisDest
 	d := &RedisDestination{
-		baseDestination: newBaseDestination(conf.Elasticsearch, "elasticsearch", e),
+		baseDestination: newBaseDestination(conf.Redis, "redis", e),
+		config:          config,
+	}
+	if d.config.PipelineSize <= 0 {
+		d.config.PipelineSize = 1
 	}
 	err := d.setFormat(config.Format)
 	if err != nil {
@@ -71,16 +78,93 @@ func (d *RedisDestination) Close() error {
 	return d.client.Close()
 }
 
-func (d *RedisDestination) sendOne(ctx context.Context, msg *model.FullMessage, topic, pKey string, pNumber int32) (err error) {
-	var buf string
-	buf, err = encoders.ChainEncode(d.encoder, msg)
-	if err != nil {
-		return err
+// queue adds the command that pushes the message to the pipeline. The topic is
+// used as the name of the list, channel or stream.
+func (d *RedisDestination) queue(pipe redis.Pipeliner, topic string, buf []byte) {
+	switch d.config.Mode {
+	case conf.RedisChannel:
+		pipe.Publish(topic, buf)
+	case conf.RedisStream:
+		args := []interface{}{"xadd", topic}
+		if d.config.StreamMaxLen > 0 {
+			args = append(args, "maxlen", "~", d.config.StreamMaxLen)
+		}
+		args = append(args, "*", d.config.StreamField, buf)
+		_ = pipe.Process(redis.NewStringCmd(args...))
+	default:
+		pipe.RPush(topic, buf)
 	}
-	_, err = d.client.RPush(topic, []byte(buf)).Result()
-	return err
+}
+
+// isRedisConnError returns true when the error does not come from a Redis
+// error reply, which means that the connection is broken.
+func isRedisConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
 }
 
 func (d *RedisDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
-	return d.ForEachWithTopic(ctx, d.sendOne, true, true, msgs)
+	c := eerrors.ChainErrors()
+	for len(msgs) > 0 {
+		n := d.config.PipelineSize
+		if n > len(msgs) {
+			n = len(msgs)
+		}
+		batch := msgs[:n]
+		msgs = msgs[n:]
+
+		pipe := d.client.Pipeline()
+		uids := make([]utils.MyULID, 0, n)
+		for _, m := range batch {
+			uid := m.Message.Uid
+			buf, err := encoders.ChainEncode(d.encoder, m.Message)
+			model.FullFree(m.Message)
+			if err != nil {
+				c.Append(encoders.EncodingError(err))
+				d.PermError(uid)
+				continue
+			}
+			d.queue(pipe, m.Topic, []byte(buf))
+			uids = append(uids, uid)
+		}
+		if len(uids) == 0 {
+			_ = pipe.Close()
+			continue
+		}
+		cmds, execErr := pipe.Exec()
+		_ = pipe.Close()
+
+		var connErr error
+		for i, uid := range uids {
+			var cmdErr error
+			if i < len(cmds) {
+				cmdErr = cmds[i].Err()
+			} else {
+				cmdErr = execErr
+			}
+			switch {
+			case cmdErr == nil:
+				d.ACK(uid)
+			case isRedisConnError(cmdErr):
+				d.NACK(uid)
+				connErr = cmdErr
+			default:
+				// the server refused the command (eg WRONGTYPE): retrying would not help
+				c.Append(cmdErr)
+				d.PermError(uid)
+			}
+		}
+		if connErr != nil {
+			c.Append(connErr)
+			d.NACKRemaining(msgs)
+			d.dofatal(connErr)
+			return c.Sum()
+		}
+	}
+	return c.Sum()
 }
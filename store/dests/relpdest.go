@@ -25,44 +25,57 @@ func NewRELPDestination(ctx context.Context, e *Env) (Destination, error) {
 	if err != nil {
 		return nil, err
 	}
-	clt := clients.NewRELPClient(e.logger).
-		Host(e.config.RELPDest.Host).
-		Port(e.config.RELPDest.Port).
-		Path(e.config.RELPDest.UnixSocketPath).
-		Format(d.format).
-		KeepAlive(e.config.RELPDest.KeepAlive).
-		KeepAlivePeriod(e.config.RELPDest.KeepAlivePeriod).
-		ConnTimeout(e.config.RELPDest.ConnTimeout).
-		RelpTimeout(e.config.RELPDest.RelpTimeout).
-		WindowSize(e.config.RELPDest.WindowSize).
-		FlushPeriod(e.config.RELPDest.FlushPeriod)
+	config := e.config.RELPDest
+	endpoints, err := failoverEndpoints(config.Host, config.Port, config.FailoverBaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	current, err := d.connectFailover(endpoints, func(ep endpoint) error {
+		clt := clients.NewRELPClient(e.logger).
+			Host(ep.host).
+			Port(ep.port).
+			Path(config.UnixSocketPath).
+			Format(d.format).
+			KeepAlive(config.KeepAlive).
+			KeepAlivePeriod(config.KeepAlivePeriod).
+			ConnTimeout(config.ConnTimeout).
+			RelpTimeout(config.RelpTimeout).
+			WindowSize(config.WindowSize).
+			FlushPeriod(config.FlushPeriod)
 
-	if e.config.RELPDest.TLSEnabled {
-		config, err := utils.NewTLSConfig(
-			e.config.RELPDest.Host,
-			e.config.RELPDest.CAFile,
-			e.config.RELPDest.CAPath,
-			e.config.RELPDest.CertFile,
-			e.config.RELPDest.KeyFile,
-			e.config.RELPDest.Insecure,
-			e.confined,
-		)
-		if err != nil {
-			return nil, err
+		if config.TLSEnabled {
+			tlsConfig, err := utils.NewTLSConfig(
+				ep.host,
+				config.CAFile,
+				config.CAPath,
+				config.CertFile,
+				config.KeyFile,
+				config.Insecure,
+				e.confined,
+			)
+			if err != nil {
+				return err
+			}
+			clt = clt.TLS(tlsConfig)
 		}
-		clt = clt.TLS(config)
-	}
 
-	err = clt.Connect()
+		err := clt.Connect()
+		if err != nil {
+			connCounter.WithLabelValues("relp", "fail").Inc()
+			return err
+		}
+		connCounter.WithLabelValues("relp", "success").Inc()
+		d.clt = clt
+		return nil
+	})
 	if err != nil {
-		connCounter.WithLabelValues("relp", "fail").Inc()
 		return nil, err
 	}
-	connCounter.WithLabelValues("relp", "success").Inc()
-
-	d.clt = clt
+	if len(config.UnixSocketPath) == 0 {
+		d.watchFailback(ctx, endpoints, current, config.FailbackPeriod, config.ConnTimeout)
+	}
 
-	rebind := e.config.RELPDest.Rebind
+	rebind := config.Rebind
 	if rebind > 0 {
 		go func() {
 			select {
@@ -0,0 +1,291 @@
+package dests
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/encoders"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/awssig"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/valyala/bytebufferpool"
+)
+
+// the total size of a SQS or SNS batch is limited to 256KiB
+const sqsMaxBatchBytes = 256 * 1024
+
+type sqsEntry struct {
+	uid   utils.MyULID
+	body  string
+	group string
+}
+
+type awsBatchError struct {
+	ID          string `xml:"Id"`
+	Code        string `xml:"Code"`
+	Message     string `xml:"Message"`
+	SenderFault bool   `xml:"SenderFault"`
+}
+
+// awsBatchResult decodes the responses of SQS SendMessageBatch and SNS
+// PublishBatch. Only the failed entries are needed.
+type awsBatchResult struct {
+	SQSFailed []awsBatchError `xml:"SendMessageBatchResult>BatchResultErrorEntry"`
+	SNSFailed []awsBatchError `xml:"PublishBatchResult>Failed>member"`
+}
+
+type awsErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+type SQSDestination struct {
+	*baseDestination
+	config    conf.SQSDestConfig
+	client    *http.Client
+	creds     *awssig.Provider
+	endpoint  string
+	region    string
+	service   string
+	fifo      bool
+	groupTmpl *template.Template
+	entries   []sqsEntry
+	size      int
+	entriesMu sync.Mutex
+	flushMu   sync.Mutex
+}
+
+func NewSQSDestination(ctx context.Context, e *Env) (Destination, error) {
+	config := e.config.SQSDest
+	d := &SQSDestination{
+		baseDestination: newBaseDestination(conf.SQS, "sqs", e),
+		config:          config,
+		client:          &http.Client{Timeout: config.Timeout},
+		region:          config.Region,
+		endpoint:        strings.TrimSpace(config.Endpoint),
+	}
+	err := d.setFormat(config.Format)
+	if err != nil {
+		return nil, err
+	}
+	d.creds = awssig.NewProvider(awssig.Credentials{
+		AccessKeyID:     config.AccessKeyID,
+		SecretAccessKey: config.SecretAccessKey,
+		SessionToken:    config.SessionToken,
+	})
+
+	switch {
+	case len(config.TopicARN) > 0:
+		// arn:aws:sns:region:account:name
+		d.service = "sns"
+		d.fifo = strings.HasSuffix(config.TopicARN, ".fifo")
+		parts := strings.Split(config.TopicARN, ":")
+		if len(parts) != 6 || parts[2] != "sns" {
+			return nil, eerrors.Errorf("Invalid SNS topic ARN: '%s'", config.TopicARN)
+		}
+		d.region = parts[3]
+		if len(d.endpoint) == 0 {
+			d.endpoint = fmt.Sprintf("https://sns.%s.amazonaws.com/", d.region)
+		}
+	case len(config.QueueURL) > 0:
+		// https://sqs.region.amazonaws.com/account/name
+		d.service = "sqs"
+		d.fifo = strings.HasSuffix(config.QueueURL, ".fifo")
+		u, err := url.Parse(config.QueueURL)
+		if err != nil {
+			return nil, eerrors.Wrap(err, "Invalid SQS queue URL")
+		}
+		hostParts := strings.Split(u.Hostname(), ".")
+		if len(hostParts) > 2 && hostParts[0] == "sqs" {
+			d.region = hostParts[1]
+		}
+		if len(d.endpoint) == 0 {
+			d.endpoint = config.QueueURL
+		}
+	default:
+		return nil, eerrors.New("The SQS destination needs a queue_url or a topic_arn")
+	}
+
+	d.groupTmpl, err = template.New("group").Parse(config.MessageGroupTmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		// flush the pending messages periodically
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d.config.FlushPeriod):
+			}
+			d.flush(ctx)
+		}
+	}()
+
+	return d, nil
+}
+
+func (d *SQSDestination) sendOne(ctx context.Context, message *model.FullMessage) error {
+	encoded, err := encoders.ChainEncode(d.encoder, message)
+	if err != nil {
+		d.logger.Warn("Error encoding message", "error", err)
+		return encoders.EncodingError(err)
+	}
+	if len(encoded) > sqsMaxBatchBytes {
+		return encoders.EncodingError(eerrors.Errorf("Message is too large for SQS/SNS (%d bytes)", len(encoded)))
+	}
+	entry := sqsEntry{uid: message.Uid, body: encoded}
+	if d.fifo {
+		buf := bytebufferpool.Get()
+		err = d.groupTmpl.Execute(buf, message.Fields)
+		entry.group = strings.TrimSpace(buf.String())
+		bytebufferpool.Put(buf)
+		if err != nil || len(entry.group) == 0 {
+			entry.group = "skewer"
+		}
+	}
+
+	d.entriesMu.Lock()
+	full := len(d.entries) > 0 && d.size+len(encoded) > sqsMaxBatchBytes
+	d.entriesMu.Unlock()
+	if full {
+		d.flush(ctx)
+	}
+
+	d.entriesMu.Lock()
+	d.entries = append(d.entries, entry)
+	d.size += len(encoded)
+	full = len(d.entries) >= d.config.BatchSize
+	d.entriesMu.Unlock()
+	if full {
+		d.flush(ctx)
+	}
+	return nil
+}
+
+// flush sends the pending messages, and then ACKs or NACKs them.
+func (d *SQSDestination) flush(ctx context.Context) {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	d.entriesMu.Lock()
+	entries := d.entries
+	d.entries, d.size = nil, 0
+	d.entriesMu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	failed, err := d.sendBatch(ctx, entries)
+	if err != nil {
+		connCounter.WithLabelValues("sqs", "fail").Inc()
+		d.logger.Warn("Error sending messages to AWS", "service", d.service, "error", err)
+		for _, entry := range entries {
+			d.NACK(entry.uid)
+		}
+		return
+	}
+	connCounter.WithLabelValues("sqs", "success").Inc()
+	for _, entry := range entries {
+		batchErr, ok := failed[entry.uid.String()]
+		switch {
+		case !ok:
+			d.ACK(entry.uid)
+		case batchErr.SenderFault:
+			d.logger.Warn("AWS rejected message", "service", d.service, "code", batchErr.Code, "error", batchErr.Message)
+			d.PermError(entry.uid)
+		default:
+			d.NACK(entry.uid)
+		}
+	}
+}
+
+func (d *SQSDestination) sendBatch(ctx context.Context, entries []sqsEntry) (map[string]awsBatchError, error) {
+	form := url.Values{}
+	var prefix string
+	if d.service == "sns" {
+		form.Set("Action", "PublishBatch")
+		form.Set("Version", "2010-03-31")
+		form.Set("TopicArn", d.config.TopicARN)
+		prefix = "PublishBatchRequestEntries.member."
+	} else {
+		form.Set("Action", "SendMessageBatch")
+		form.Set("Version", "2012-11-05")
+		form.Set("QueueUrl", d.config.QueueURL)
+		prefix = "SendMessageBatchRequestEntry."
+	}
+	for i, entry := range entries {
+		p := fmt.Sprintf("%s%d.", prefix, i+1)
+		form.Set(p+"Id", entry.uid.String())
+		if d.service == "sns" {
+			form.Set(p+"Message", entry.body)
+		} else {
+			form.Set(p+"MessageBody", entry.body)
+		}
+		if d.fifo {
+			// the message UID makes the retries of the same message idempotent
+			form.Set(p+"MessageDeduplicationId", entry.uid.String())
+			form.Set(p+"MessageGroupId", entry.group)
+		}
+	}
+	body := []byte(form.Encode())
+
+	creds, err := d.creds.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", d.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	awssig.Sign(req, body, d.region, d.service, creds, time.Now())
+
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var awsErr awsErrorResponse
+		_ = xml.Unmarshal(respBody, &awsErr)
+		return nil, eerrors.Errorf("AWS returned '%s': %s %s", resp.Status, awsErr.Code, awsErr.Message)
+	}
+	var result awsBatchResult
+	err = xml.Unmarshal(respBody, &result)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Error decoding the AWS response")
+	}
+	failed := make(map[string]awsBatchError)
+	for _, f := range append(result.SQSFailed, result.SNSFailed...) {
+		failed[f.ID] = f
+	}
+	return failed, nil
+}
+
+func (d *SQSDestination) Close() error {
+	d.flush(context.Background())
+	return nil
+}
+
+func (d *SQSDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
+	// messages are ACKed when their batch has been accepted
+	return d.ForEach(ctx, d.sendOne, false, true, msgs)
+}
@@ -0,0 +1,75 @@
+package dests
+
+import (
+	"context"
+	"time"
+
+	"github.com/stephane-martin/skewer/clients"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// NewSyslogTLSDestination creates a destination that sends the messages as
+// specified by RFC 5425: RFC 5424 messages, octet-counted, over a mutually
+// authenticated TLS connection. Except for the framing and the
+// authentication, it works like the TCP destination.
+func NewSyslogTLSDestination(ctx context.Context, e *Env) (Destination, error) {
+	config := e.config.SyslogTLSDest
+	if len(config.CertFile) == 0 || len(config.KeyFile) == 0 {
+		return nil, eerrors.New("The syslogtls destination needs a client certificate (cert_file and key_file)")
+	}
+	d := &TCPDestination{
+		baseDestination: newBaseDestination(conf.SyslogTLS, "syslogtls", e),
+	}
+	err := d.setFormat("rfc5424")
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := utils.NewTLSConfig(
+		config.Host,
+		config.CAFile,
+		config.CAPath,
+		config.CertFile,
+		config.KeyFile,
+		config.Insecure,
+		e.confined,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	clt := clients.NewSyslogTCPClient(e.logger).
+		Host(config.Host).
+		Port(config.Port).
+		Format(d.format).
+		KeepAlive(config.KeepAlive).
+		KeepAlivePeriod(config.KeepAlivePeriod).
+		LineFraming(false).
+		ConnTimeout(config.ConnTimeout).
+		FlushPeriod(config.FlushPeriod).
+		TLS(tlsConfig)
+
+	err = clt.Connect(ctx)
+	if err != nil {
+		connCounter.WithLabelValues("syslogtls", "fail").Inc()
+		return nil, err
+	}
+	connCounter.WithLabelValues("syslogtls", "success").Inc()
+	d.clt = clt
+
+	rebind := config.Rebind
+	if rebind > 0 {
+		go func() {
+			select {
+			case <-ctx.Done():
+				// the store service asked for stop
+				d.clt.Close()
+			case <-time.After(rebind):
+				d.dofatal(eerrors.Errorf("Rebind period has expired (%s)", rebind.String()))
+			}
+		}()
+	}
+
+	return d, nil
+}
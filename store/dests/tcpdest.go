@@ -27,44 +27,57 @@ func NewTCPDestination(ctx context.Context, e *Env) (Destination, error) {
 	if err != nil {
 		return nil, err
 	}
-	clt := clients.NewSyslogTCPClient(e.logger).
-		Host(e.config.TCPDest.Host).
-		Port(e.config.TCPDest.Port).
-		Path(e.config.TCPDest.UnixSocketPath).
-		Format(d.format).
-		KeepAlive(e.config.TCPDest.KeepAlive).
-		KeepAlivePeriod(e.config.TCPDest.KeepAlivePeriod).
-		LineFraming(e.config.TCPDest.LineFraming).
-		FrameDelimiter(e.config.TCPDest.FrameDelimiter).
-		ConnTimeout(e.config.TCPDest.ConnTimeout).
-		FlushPeriod(e.config.TCPDest.FlushPeriod)
+	config := e.config.TCPDest
+	endpoints, err := failoverEndpoints(config.Host, config.Port, config.FailoverBaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	current, err := d.connectFailover(endpoints, func(ep endpoint) error {
+		clt := clients.NewSyslogTCPClient(e.logger).
+			Host(ep.host).
+			Port(ep.port).
+			Path(config.UnixSocketPath).
+			Format(d.format).
+			KeepAlive(config.KeepAlive).
+			KeepAlivePeriod(config.KeepAlivePeriod).
+			LineFraming(config.LineFraming).
+			FrameDelimiter(config.FrameDelimiter).
+			ConnTimeout(config.ConnTimeout).
+			FlushPeriod(config.FlushPeriod)
 
-	if e.config.TCPDest.TLSEnabled {
-		config, err := utils.NewTLSConfig(
-			e.config.TCPDest.Host,
-			e.config.TCPDest.CAFile,
-			e.config.TCPDest.CAPath,
-			e.config.TCPDest.CertFile,
-			e.config.TCPDest.KeyFile,
-			e.config.TCPDest.Insecure,
-			e.confined,
-		)
-		if err != nil {
-			return nil, err
+		if config.TLSEnabled {
+			tlsConfig, err := utils.NewTLSConfig(
+				ep.host,
+				config.CAFile,
+				config.CAPath,
+				config.CertFile,
+				config.KeyFile,
+				config.Insecure,
+				e.confined,
+			)
+			if err != nil {
+				return err
+			}
+			clt = clt.TLS(tlsConfig)
 		}
-		clt = clt.TLS(config)
-	}
 
-	err = clt.Connect(ctx)
+		err := clt.Connect(ctx)
+		if err != nil {
+			connCounter.WithLabelValues("tcp", "fail").Inc()
+			return err
+		}
+		connCounter.WithLabelValues("tcp", "success").Inc()
+		d.clt = clt
+		return nil
+	})
 	if err != nil {
-		connCounter.WithLabelValues("tcp", "fail").Inc()
 		return nil, err
 	}
-	connCounter.WithLabelValues("tcp", "success").Inc()
-
-	d.clt = clt
+	if len(config.UnixSocketPath) == 0 {
+		d.watchFailback(ctx, endpoints, current, config.FailbackPeriod, config.ConnTimeout)
+	}
 
-	rebind := e.config.TCPDest.Rebind
+	rebind := config.Rebind
 	if rebind > 0 {
 		go func() {
 			select {
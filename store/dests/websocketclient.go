@@ -1,3 +1,162 @@
 package dests
 
-// TODO: implement
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/encoders/baseenc"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/valyala/bytebufferpool"
+)
+
+// WebsocketClientDestination connects to a remote websocket server and
+// streams the encoded messages to it.
+type WebsocketClientDestination struct {
+	*baseDestination
+	config      conf.WebsocketClientDestConfig
+	conn        *websocket.Conn
+	messageType int
+	writeMu     sync.Mutex
+	wg          sync.WaitGroup
+	done        chan struct{}
+}
+
+func NewWebsocketClientDestination(ctx context.Context, e *Env) (Destination, error) {
+	config := e.config.WebsocketClientDest
+	d := &WebsocketClientDestination{
+		baseDestination: newBaseDestination(conf.WebsocketClient, "websocketclient", e),
+		config:          config,
+		done:            make(chan struct{}),
+	}
+	err := d.setFormat(config.Format)
+	if err != nil {
+		return nil, err
+	}
+	switch d.format {
+	case baseenc.Protobuf:
+		d.messageType = websocket.BinaryMessage
+	default:
+		d.messageType = websocket.TextMessage
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Invalid websocket URL")
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, eerrors.Errorf("The websocket URL must be a ws:// or wss:// URL: '%s'", config.URL)
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: config.ConnTimeout,
+	}
+	if config.TLSEnabled || u.Scheme == "wss" {
+		dialer.TLSClientConfig, err = utils.NewTLSConfig(u.Hostname(), config.CAFile, config.CAPath, config.CertFile, config.KeyFile, config.Insecure, e.confined)
+		if err != nil {
+			return nil, err
+		}
+	}
+	header := http.Header{}
+	if len(config.BearerToken) > 0 {
+		header.Set("Authorization", "Bearer "+config.BearerToken)
+	}
+	d.conn, _, err = dialer.Dial(config.URL, header)
+	if err != nil {
+		connCounter.WithLabelValues("websocketclient", "fail").Inc()
+		return nil, eerrors.Wrapf(err, "Error connecting to websocket server '%s'", config.URL)
+	}
+	connCounter.WithLabelValues("websocketclient", "success").Inc()
+
+	d.wg.Add(2)
+	go d.read()
+	go d.ping(ctx)
+
+	if config.Rebind > 0 {
+		go func() {
+			select {
+			case <-ctx.Done():
+				// the store service asked for stop
+			case <-time.After(config.Rebind):
+				d.dofatal(eerrors.Errorf("Rebind period has expired (%s)", config.Rebind.String()))
+			}
+		}()
+	}
+
+	return d, nil
+}
+
+// read discards what the server sends, and detects when the connection is closed.
+func (d *WebsocketClientDestination) read() {
+	defer d.wg.Done()
+	_ = d.conn.SetReadDeadline(time.Now().Add(pongWait))
+	d.conn.SetPongHandler(func(string) error {
+		return d.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, _, err := d.conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-d.done:
+				// Close() was called
+			default:
+				d.dofatal(eerrors.Wrap(err, "Websocket connection was closed"))
+			}
+			return
+		}
+	}
+}
+
+func (d *WebsocketClientDestination) ping(ctx context.Context) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.done:
+			return
+		case <-time.After(pingPeriod):
+		}
+		err := d.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(writeWait))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (d *WebsocketClientDestination) sendOne(ctx context.Context, msg *model.FullMessage) (err error) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	err = d.encoder(msg, buf)
+	if err != nil {
+		return err
+	}
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	_ = d.conn.SetWriteDeadline(time.Now().Add(d.config.WriteTimeout))
+	return d.conn.WriteMessage(d.messageType, buf.Bytes())
+}
+
+func (d *WebsocketClientDestination) Close() error {
+	close(d.done)
+	d.writeMu.Lock()
+	_ = d.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye!"),
+		time.Now().Add(time.Second),
+	)
+	d.writeMu.Unlock()
+	err := d.conn.Close()
+	d.wg.Wait()
+	return err
+}
+
+func (d *WebsocketClientDestination) Send(ctx context.Context, msgs []model.OutputMsg) (err eerrors.ErrorSlice) {
+	return d.ForEach(ctx, d.sendOne, true, true, msgs)
+}
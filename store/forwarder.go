@@ -11,6 +11,7 @@ import (
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/store/dests"
 	"github.com/stephane-martin/skewer/sys/binder"
+	"github.com/stephane-martin/skewer/sys/kring"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
 	"go.uber.org/atomic"
@@ -19,18 +20,21 @@ import (
 type Forwarder struct {
 	logger     log15.Logger
 	binder     binder.Client
+	ring       kring.Ring
 	once       sync.Once
-	store      *MessageStore
+	store      Store
 	conf       conf.BaseConfig
 	desttype   conf.DestinationType
 	outputMsgs []model.OutputMsg
 	dest       dests.Destination
+	router     router
 }
 
-func NewForwarder(desttype conf.DestinationType, st *MessageStore, bc conf.BaseConfig, logger log15.Logger, bindr binder.Client) *Forwarder {
+func NewForwarder(desttype conf.DestinationType, st Store, bc conf.BaseConfig, logger log15.Logger, bindr binder.Client, ring kring.Ring) *Forwarder {
 	f := Forwarder{
 		logger:   logger.New("class", "forwarder"),
 		binder:   bindr,
+		ring:     ring,
 		store:    st,
 		conf:     bc,
 		desttype: desttype,
@@ -41,12 +45,17 @@ func NewForwarder(desttype conf.DestinationType, st *MessageStore, bc conf.BaseC
 
 func (fwder *Forwarder) CreateDestination(ctx context.Context) (err error) {
 	fwder.logger.Debug("Creating destination", "dest", fwder.desttype)
+	fwder.router, err = newRouter(fwder.conf.Routes)
+	if err != nil {
+		return eerrors.Wrap(err, "Error setting up the routes")
+	}
 	e := dests.BuildEnv().
 		Callbacks(fwder.store.ACK, fwder.store.NACK, fwder.store.PermError).
 		Config(fwder.conf).
 		Confined(fwder.store.Confined()).
 		Logger(fwder.logger).
-		Binder(fwder.binder)
+		Binder(fwder.binder).
+		Ring(fwder.ring)
 
 	dest, err := dests.NewDestination(ctx, fwder.desttype, e)
 	if err != nil {
@@ -130,6 +139,13 @@ Loop:
 		if m == nil || m.Fields == nil {
 			continue Loop
 		}
+		if !fwder.router.Accepts(m, fwder.desttype) {
+			// the message was routed to other destinations
+			fwder.store.ACK(m.Uid, fwder.desttype)
+			countFiltered(fwder.desttype, "routed", m.Fields.GetProperty("skewer", "client"))
+			continue Loop
+		}
+
 		env, ok := envs[m.ConfId]
 		if !ok {
 			// create the environment for the javascript virtual machine
@@ -153,6 +169,10 @@ Loop:
 				fwder.logger,
 			)
 			env = envs[m.ConfId]
+			e = env.SetExpressions(config)
+			if e != nil {
+				fwder.logger.Warn("Error setting the filter expressions", "error", e)
+			}
 		}
 
 		topic := ""
@@ -163,8 +183,10 @@ Loop:
 		_, ok1 := dest.(*dests.KafkaDestination)
 		_, ok2 := dest.(*dests.NATSDestination)
 		_, ok3 := dest.(*dests.RedisDestination)
+		_, ok4 := dest.(*dests.NSQDestination)
+		_, ok5 := dest.(*dests.PulsarDestination)
 
-		if ok1 || ok2 || ok3 {
+		if ok1 || ok2 || ok3 || ok4 || ok5 {
 			// only calculate proper Topic, PartitionKey and PartitionNumber if we are sending to Kafka or NATS
 			topic, joinedErr = env.Topic(m.Fields)
 			if joinedErr != nil {
@@ -183,7 +205,7 @@ Loop:
 			}
 		}
 
-		filterResult, e := env.FilterMessage(m.Fields)
+		filterResult, e := env.FilterMessageTo(m.Fields, fwder.desttype)
 		if e != nil {
 			fwder.logger.Warn("Error happened filtering message", "error", e)
 			continue Loop
@@ -198,6 +220,10 @@ Loop:
 			fwder.store.NACK(m.Uid, fwder.desttype)
 			countFiltered(fwder.desttype, "rejected", m.Fields.GetProperty("skewer", "client"))
 			continue Loop
+		case javascript.ROUTED:
+			fwder.store.ACK(m.Uid, fwder.desttype)
+			countFiltered(fwder.desttype, "routed", m.Fields.GetProperty("skewer", "client"))
+			continue Loop
 		case javascript.PASS:
 			countFiltered(fwder.desttype, "passing", m.Fields.GetProperty("skewer", "client"))
 		default:
@@ -1,8 +1,15 @@
 package store
 
-/*
+import (
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+)
+
+// Store is implemented by the store backends: MessageStore on top of badger,
+// BoltStore on top of BoltDB, and the volatile MemoryStore.
 type Store interface {
-	Stash(uid utils.MyULID, b []byte) error
+	Ingest(m map[utils.MyULID]string) (int, error)
 	Outputs(dest conf.DestinationType) chan []*model.FullMessage
 	ACK(uid utils.MyULID, dest conf.DestinationType)
 	NACK(uid utils.MyULID, dest conf.DestinationType)
@@ -11,8 +18,12 @@ type Store interface {
 	WaitFinished()
 	GetSyslogConfig(configID utils.MyULID) (*conf.FilterSubConfig, error)
 	StoreAllSyslogConfigs(c conf.BaseConfig) error
+	// ReadAllBadgers returns the ready, failed and sent messages of every
+	// destination, keyed by "destination/uid", for debugging.
 	ReadAllBadgers() (map[string]string, map[string]string, map[string]string)
 	Destinations() []conf.DestinationType
+	SetDestinations(dests conf.DestinationType)
 	Confined() bool
+	SetLastError(dest conf.DestinationType, err error)
+	Admin(req AdminRequest) AdminResponse
 }
-*/
@@ -0,0 +1,689 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stephane-martin/skewer/utils/queue"
+)
+
+type memMessage struct {
+	content  []byte
+	ingested time.Time
+	// refs is the number of queues that reference the message
+	refs int
+}
+
+// memQueues are the queues of a destination in a MemoryStore. ready is in
+// ingestion order. It may contain messages that have been evicted since, they
+// are skipped.
+type memQueues struct {
+	ready      []utils.MyULID
+	inReady    map[utils.MyULID]bool
+	sent       map[utils.MyULID]bool
+	failed     map[utils.MyULID]time.Time
+	permerrors map[utils.MyULID]time.Time
+}
+
+// MemoryStore is a volatile store backend: the messages are kept in memory
+// only, and they are lost when skewer stops or crashes. There is no disk I/O
+// at all, so it suits the relays that favor latency over durability.
+//
+// The store is bounded: when it holds maxMessages messages, the oldest
+// messages are evicted to make room for the new ones.
+type MemoryStore struct {
+	sync.Mutex
+	messages map[utils.MyULID]*memMessage
+	// order is the ingestion order of the messages, for eviction
+	order   []utils.MyULID
+	configs map[utils.MyULID]string
+	mqueues map[conf.DestinationType]*memQueues
+
+	logger       log15.Logger
+	dests        *Destinations
+	queues       map[conf.DestinationType]*destQueue
+	batchSize    uint32
+	ackBatchSize uint32
+	ackLatency   time.Duration
+	confined     bool
+	maxMessages  int
+	maxAge       time.Duration
+	lastErrs     lastErrors
+
+	wg             sync.WaitGroup
+	closedChan     chan struct{}
+	FatalErrorChan chan struct{}
+}
+
+func newMemoryStore(ctx context.Context, cfg conf.StoreConfig, dests conf.DestinationType, cfnd bool, l log15.Logger) *MemoryStore {
+	logger := l.New("class", "MemoryStore")
+	store := &MemoryStore{
+		messages:       make(map[utils.MyULID]*memMessage),
+		configs:        make(map[utils.MyULID]string),
+		mqueues:        make(map[conf.DestinationType]*memQueues, len(conf.Destinations)),
+		logger:         logger,
+		dests:          &Destinations{},
+		queues:         make(map[conf.DestinationType]*destQueue, len(conf.Destinations)),
+		batchSize:      cfg.BatchSize,
+		ackBatchSize:   cfg.AckBatchSize,
+		ackLatency:     cfg.AckLatency,
+		confined:       cfnd,
+		maxMessages:    cfg.MaxMessages,
+		maxAge:         cfg.MaxAge,
+		closedChan:     make(chan struct{}),
+		FatalErrorChan: make(chan struct{}),
+	}
+	store.dests.Store(dests)
+	for _, dest := range conf.Destinations {
+		store.queues[dest] = newQueue(dest)
+		store.mqueues[dest] = &memQueues{
+			inReady:    make(map[utils.MyULID]bool),
+			sent:       make(map[utils.MyULID]bool),
+			failed:     make(map[utils.MyULID]time.Time),
+			permerrors: make(map[utils.MyULID]time.Time),
+		}
+	}
+	badgerGauge.WithLabelValues("messages", "").Set(0)
+	store.init(ctx)
+	return store
+}
+
+func (s *MemoryStore) init(ctx context.Context) {
+	lctx, cancel := context.WithCancel(ctx)
+	errs := make(chan error, 2*len(s.queues))
+
+	for _, q := range s.queues {
+		s.wg.Add(2)
+		go func(q *destQueue) {
+			defer s.wg.Done()
+			err := receiveAcks(s, q, s.ackBatchSize, s.ackLatency)
+			if err != nil {
+				errs <- err
+			}
+		}(q)
+		go func(q *destQueue) {
+			defer s.wg.Done()
+			err := retrieveAndForward(lctx, s, q, s.dests)
+			if err != nil {
+				errs <- err
+			}
+		}(q)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		watchOldest(lctx, s, s.queues)
+	}()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.resetFailures()
+				s.evictExpired()
+			case <-lctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		select {
+		case <-lctx.Done():
+		case err := <-errs:
+			s.logger.Error("Some error happened operating the Store. Shutting it down", "error", err)
+			cancel()
+			close(s.FatalErrorChan)
+		}
+		for _, q := range s.queues {
+			q.dispose()
+		}
+		s.wg.Wait()
+		s.Lock()
+		if len(s.messages) > 0 {
+			s.logger.Info("Messages lost on shutdown of the memory store", "nb", len(s.messages))
+		}
+		s.Unlock()
+		close(s.closedChan)
+	}()
+}
+
+func (s *MemoryStore) Confined() bool {
+	return s.confined
+}
+
+func (s *MemoryStore) Outputs(dest conf.DestinationType) chan []*model.FullMessage {
+	return s.queues[dest].outputs
+}
+
+func (s *MemoryStore) Errors() chan struct{} {
+	return s.FatalErrorChan
+}
+
+func (s *MemoryStore) Destinations() []conf.DestinationType {
+	return s.dests.Load()
+}
+
+func (s *MemoryStore) SetDestinations(dests conf.DestinationType) {
+	s.dests.Store(dests)
+}
+
+func (s *MemoryStore) WaitFinished() {
+	<-s.closedChan
+}
+
+// SetLastError records the last error faced by the forwarder of a
+// destination.
+func (s *MemoryStore) SetLastError(dest conf.DestinationType, err error) {
+	if err != nil {
+		s.lastErrs.set(dest, err)
+	}
+}
+
+// ReadAllBadgers returns the text of the ready and sent messages, and the
+// time of failure of the failed messages.
+func (s *MemoryStore) ReadAllBadgers() (map[string]string, map[string]string, map[string]string) {
+	readyMap := make(map[string]string)
+	failedMap := make(map[string]string)
+	sentMap := make(map[string]string)
+	protobuff := proto.NewBuffer(nil)
+	text := func(uid utils.MyULID) string {
+		msg, ok := s.messages[uid]
+		if !ok {
+			return "evicted message"
+		}
+		protobuff.SetBuf(msg.content)
+		m, err := model.FromBuf(protobuff)
+		if err != nil {
+			return "invalid message: " + err.Error()
+		}
+		defer model.FullFree(m)
+		return m.Fields.Message
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for dest, q := range s.queues {
+		mq := s.mqueues[dest]
+		for uid := range mq.inReady {
+			if _, ok := s.messages[uid]; ok {
+				readyMap[q.dumpKey(uid)] = text(uid)
+			}
+		}
+		for uid := range mq.sent {
+			sentMap[q.dumpKey(uid)] = text(uid)
+		}
+		for uid, failedAt := range mq.failed {
+			failedMap[q.dumpKey(uid)] = failedAt.Format(time.RFC3339Nano)
+		}
+	}
+	return readyMap, failedMap, sentMap
+}
+
+func (s *MemoryStore) StoreAllSyslogConfigs(c conf.BaseConfig) error {
+	return storeAllSyslogConfigs(c, s.StoreSyslogConfig)
+}
+
+func (s *MemoryStore) StoreSyslogConfig(confID utils.MyULID, config conf.FilterSubConfig) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.configs[confID]; !ok {
+		s.configs[confID] = config.Export()
+		badgerGauge.WithLabelValues("syslogconf", "").Inc()
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetSyslogConfig(confID utils.MyULID) (*conf.FilterSubConfig, error) {
+	s.Lock()
+	data, ok := s.configs[confID]
+	s.Unlock()
+	if !ok {
+		return nil, eerrors.Errorf("unknown syslog configuration id: %s", confID.String())
+	}
+	c, err := conf.ImportSyslogConfig([]byte(data))
+	if err != nil {
+		return nil, eerrors.Wrap(err, "Failed to unmarshal configuration")
+	}
+	return c, nil
+}
+
+func (s *MemoryStore) Ingest(m map[utils.MyULID]string) (int, error) {
+	if len(m) == 0 {
+		return 0, nil
+	}
+	destinations := s.Destinations()
+	now := time.Now()
+
+	s.Lock()
+	var nb int
+	for uid, content := range m {
+		if len(content) == 0 {
+			continue
+		}
+		if _, ok := s.messages[uid]; ok {
+			continue
+		}
+		s.messages[uid] = &memMessage{content: []byte(content), ingested: now, refs: len(destinations)}
+		s.order = append(s.order, uid)
+		for _, dest := range destinations {
+			mq := s.mqueues[dest]
+			mq.ready = append(mq.ready, uid)
+			mq.inReady[uid] = true
+		}
+		nb++
+	}
+	var evicted int
+	if s.maxMessages > 0 && len(s.messages) > s.maxMessages {
+		evicted = s.evictOldest(len(s.messages) - s.maxMessages)
+	}
+	if len(s.order) > 2*len(s.messages)+1024 {
+		s.compactOrder()
+	}
+	s.Unlock()
+
+	badgerGauge.WithLabelValues("messages", "").Add(float64(nb - evicted))
+	for _, dest := range destinations {
+		q := s.queues[dest]
+		q.zeroMsg.Store(false)
+		badgerGauge.WithLabelValues("ready", q.name).Add(float64(nb))
+	}
+	if evicted > 0 {
+		evictedCounter.WithLabelValues("max_messages").Add(float64(evicted))
+	}
+	return nb, nil
+}
+
+// evictOldest deletes the nb oldest messages from all the queues. The lock
+// must be held.
+func (s *MemoryStore) evictOldest(nb int) (evicted int) {
+	for evicted < nb && len(s.order) > 0 {
+		uid := s.order[0]
+		s.order = s.order[1:]
+		if s.remove(uid) {
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// compactOrder drops from the ingestion order the messages that are not in
+// the store anymore. The lock must be held.
+func (s *MemoryStore) compactOrder() {
+	order := make([]utils.MyULID, 0, len(s.messages))
+	for _, uid := range s.order {
+		if _, ok := s.messages[uid]; ok {
+			order = append(order, uid)
+		}
+	}
+	s.order = order
+}
+
+// evictExpired deletes the messages older than maxAge.
+func (s *MemoryStore) evictExpired() {
+	if s.maxAge <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-s.maxAge)
+	var evicted int
+	s.Lock()
+	for len(s.order) > 0 {
+		uid := s.order[0]
+		msg, ok := s.messages[uid]
+		if ok && msg.ingested.After(deadline) {
+			break
+		}
+		s.order = s.order[1:]
+		if s.remove(uid) {
+			evicted++
+		}
+	}
+	s.Unlock()
+	if evicted > 0 {
+		badgerGauge.WithLabelValues("messages", "").Sub(float64(evicted))
+		evictedCounter.WithLabelValues("max_age").Add(float64(evicted))
+		s.logger.Info("Evicted messages from the memory store", "nb", evicted, "reason", "max_age")
+	}
+}
+
+// remove deletes a message from all the queues. The lock must be held. The
+// ready queues are cleaned lazily by retrieve.
+func (s *MemoryStore) remove(uid utils.MyULID) bool {
+	if _, ok := s.messages[uid]; !ok {
+		return false
+	}
+	delete(s.messages, uid)
+	for dest, mq := range s.mqueues {
+		name := conf.DestinationNames[dest]
+		if mq.inReady[uid] {
+			delete(mq.inReady, uid)
+			badgerGauge.WithLabelValues("ready", name).Dec()
+		}
+		if mq.sent[uid] {
+			delete(mq.sent, uid)
+			badgerGauge.WithLabelValues("sent", name).Dec()
+		}
+		if _, ok := mq.failed[uid]; ok {
+			delete(mq.failed, uid)
+			badgerGauge.WithLabelValues("failed", name).Dec()
+		}
+		if _, ok := mq.permerrors[uid]; ok {
+			delete(mq.permerrors, uid)
+			badgerGauge.WithLabelValues("permerrors", name).Dec()
+		}
+	}
+	return true
+}
+
+// unref drops a reference to a message, and deletes the message when no
+// queue references it anymore. The lock must be held.
+func (s *MemoryStore) unref(uid utils.MyULID) {
+	msg, ok := s.messages[uid]
+	if !ok {
+		return
+	}
+	msg.refs--
+	if msg.refs <= 0 {
+		delete(s.messages, uid)
+		badgerGauge.WithLabelValues("messages", "").Dec()
+	}
+}
+
+func (s *MemoryStore) retrieve(q *destQueue) ([]*model.FullMessage, error) {
+	startt := time.Now()
+	defer func() {
+		retrieveTimeSummary.Observe(time.Since(startt).Seconds() * 1000)
+	}()
+
+	messages := msgsSlicePool.Get().([]*model.FullMessage)[:0]
+	protobuff := proto.NewBuffer(nil)
+
+	s.Lock()
+	defer s.Unlock()
+	mq := s.mqueues[q.dest]
+	var nbInvalids int
+	for len(mq.ready) > 0 && uint32(len(messages)) < s.batchSize {
+		uid := mq.ready[0]
+		mq.ready = mq.ready[1:]
+		if !mq.inReady[uid] {
+			// evicted or purged meanwhile
+			continue
+		}
+		delete(mq.inReady, uid)
+		msg, ok := s.messages[uid]
+		if !ok {
+			nbInvalids++
+			continue
+		}
+		protobuff.SetBuf(msg.content)
+		message, err := model.FromBuf(protobuff)
+		if err != nil {
+			s.logger.Debug("retrieved invalid protobuf encoded entry", "uid", uid, "error", err)
+			nbInvalids++
+			s.unref(uid)
+			continue
+		}
+		mq.sent[uid] = true
+		messages = append(messages, message)
+	}
+	badgerGauge.WithLabelValues("ready", q.name).Sub(float64(len(messages) + nbInvalids))
+	badgerGauge.WithLabelValues("sent", q.name).Add(float64(len(messages)))
+	return messages, nil
+}
+
+func (s *MemoryStore) ACK(uid utils.MyULID, dest conf.DestinationType) {
+	countACK(dest, "ack")
+	_ = s.queues[dest].acks.Put(uid, dest)
+}
+
+func (s *MemoryStore) NACK(uid utils.MyULID, dest conf.DestinationType) {
+	countACK(dest, "nack")
+	_ = s.queues[dest].nacks.Put(uid, dest)
+}
+
+func (s *MemoryStore) PermError(uid utils.MyULID, dest conf.DestinationType) {
+	countACK(dest, "permerror")
+	_ = s.queues[dest].permerrs.Put(uid, dest)
+}
+
+func (s *MemoryStore) doACK(q *destQueue, acks []queue.UidDest) error {
+	s.Lock()
+	defer s.Unlock()
+	mq := s.mqueues[q.dest]
+	for _, ack := range acks {
+		if !mq.sent[ack.Uid] {
+			continue
+		}
+		delete(mq.sent, ack.Uid)
+		badgerGauge.WithLabelValues("sent", q.name).Dec()
+		s.unref(ack.Uid)
+	}
+	return nil
+}
+
+func (s *MemoryStore) moveFromSent(q *destQueue, to map[utils.MyULID]time.Time, uids []queue.UidDest) (nb int) {
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+	mq := s.mqueues[q.dest]
+	for _, u := range uids {
+		if !mq.sent[u.Uid] {
+			continue
+		}
+		delete(mq.sent, u.Uid)
+		to[u.Uid] = now
+		nb++
+	}
+	return nb
+}
+
+func (s *MemoryStore) doNACK(q *destQueue, nacks []queue.UidDest) error {
+	if len(nacks) == 0 {
+		return nil
+	}
+	nb := s.moveFromSent(q, s.mqueues[q.dest].failed, nacks)
+	badgerGauge.WithLabelValues("failed", q.name).Add(float64(nb))
+	badgerGauge.WithLabelValues("sent", q.name).Sub(float64(nb))
+	return nil
+}
+
+func (s *MemoryStore) doPermanentError(q *destQueue, pes []queue.UidDest) error {
+	if len(pes) == 0 {
+		return nil
+	}
+	nb := s.moveFromSent(q, s.mqueues[q.dest].permerrors, pes)
+	badgerGauge.WithLabelValues("permerrors", q.name).Add(float64(nb))
+	badgerGauge.WithLabelValues("sent", q.name).Sub(float64(nb))
+	return nil
+}
+
+// oldest returns the oldest message that waits to be delivered to the
+// destination, or an empty uid.
+func (s *MemoryStore) oldest(q *destQueue) (oldest utils.MyULID) {
+	s.Lock()
+	defer s.Unlock()
+	mq := s.mqueues[q.dest]
+	older := func(uid utils.MyULID) {
+		if len(oldest) == 0 || uid < oldest {
+			oldest = uid
+		}
+	}
+	for uid := range mq.inReady {
+		older(uid)
+	}
+	for uid := range mq.sent {
+		older(uid)
+	}
+	for uid := range mq.failed {
+		older(uid)
+	}
+	return oldest
+}
+
+// resetFailures pushes back to the ready queues the messages that failed
+// more than a minute ago.
+func (s *MemoryStore) resetFailures() {
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+	for _, q := range s.queues {
+		mq := s.mqueues[q.dest]
+		var nb int
+		for uid, t := range mq.failed {
+			if now.Sub(t) >= time.Minute {
+				delete(mq.failed, uid)
+				mq.ready = append(mq.ready, uid)
+				mq.inReady[uid] = true
+				nb++
+			}
+		}
+		if nb > 0 {
+			q.zeroMsg.Store(false)
+			badgerGauge.WithLabelValues("ready", q.name).Add(float64(nb))
+			badgerGauge.WithLabelValues("failed", q.name).Sub(float64(nb))
+		}
+	}
+}
+
+// Admin executes an admin request on the failed messages. The memory store
+// is not encrypted, so there is no key to rotate.
+func (s *MemoryStore) Admin(req AdminRequest) (resp AdminResponse) {
+	queues, err := adminQueues(s.queues, req.Destination)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	switch req.Action {
+	case AdminList:
+		resp.Messages = s.listFailed(queues, req.Uids, adminLimit(req))
+		resp.Count = len(resp.Messages)
+	case AdminRequeue:
+		resp.Count = s.requeueFailed(queues, req.Uids)
+	case AdminPurge:
+		resp.Count = s.purgeFailed(queues, req.Uids)
+	case AdminRotateKey:
+		err = eerrors.New("The memory store is not encrypted")
+	default:
+		err = eerrors.Errorf("unknown action: '%s'", req.Action)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// memFailedEntries returns the failed or permerrors messages of a
+// destination, restricted to uids when it is not empty.
+func memFailedEntries(m map[utils.MyULID]time.Time, uids []utils.MyULID) map[utils.MyULID]time.Time {
+	if len(uids) == 0 {
+		return m
+	}
+	entries := make(map[utils.MyULID]time.Time, len(uids))
+	for _, uid := range uids {
+		if t, ok := m[uid]; ok {
+			entries[uid] = t
+		}
+	}
+	return entries
+}
+
+func (mq *memQueues) failedQueues() map[string]map[utils.MyULID]time.Time {
+	return map[string]map[utils.MyULID]time.Time{"failed": mq.failed, "permerrors": mq.permerrors}
+}
+
+func (s *MemoryStore) listFailed(queues []*destQueue, uids []utils.MyULID, limit int) (result []FailedMessage) {
+	s.Lock()
+	defer s.Unlock()
+	for _, q := range queues {
+		lastError := s.lastErrs.get(q.dest)
+		for qname, m := range s.mqueues[q.dest].failedQueues() {
+			for uid, failedAt := range memFailedEntries(m, uids) {
+				result = append(result, newFailedMessage(uid, q, qname, failedAt, lastError))
+			}
+		}
+	}
+	result = sortFailed(result, limit)
+
+	protobuff := proto.NewBuffer(nil)
+	for i := range result {
+		msg, ok := s.messages[result[i].Uid]
+		if !ok {
+			continue
+		}
+		protobuff.SetBuf(msg.content)
+		m, err := model.FromBuf(protobuff)
+		if err != nil {
+			s.logger.Debug("Invalid failed message", "uid", result[i].Uid, "error", err)
+			continue
+		}
+		result[i].Message = m.Regular()
+		model.FullFree(m)
+	}
+	return result
+}
+
+// requeueFailed moves the failed messages back to the ready queue of their
+// destination.
+func (s *MemoryStore) requeueFailed(queues []*destQueue, uids []utils.MyULID) (total int) {
+	s.Lock()
+	defer s.Unlock()
+	for _, q := range queues {
+		mq := s.mqueues[q.dest]
+		nb := 0
+		for qname, m := range mq.failedQueues() {
+			entries := memFailedEntries(m, uids)
+			n := len(entries)
+			for uid := range entries {
+				delete(m, uid)
+				mq.ready = append(mq.ready, uid)
+				mq.inReady[uid] = true
+			}
+			badgerGauge.WithLabelValues(qname, q.name).Sub(float64(n))
+			nb += n
+		}
+		badgerGauge.WithLabelValues("ready", q.name).Add(float64(nb))
+		if nb > 0 {
+			q.zeroMsg.Store(false)
+		}
+		total += nb
+	}
+	if total > 0 {
+		s.logger.Info("Requeued failed messages", "nb", total)
+	}
+	return total
+}
+
+// purgeFailed deletes the failed messages, and their content when no other
+// destination references it.
+func (s *MemoryStore) purgeFailed(queues []*destQueue, uids []utils.MyULID) (total int) {
+	s.Lock()
+	defer s.Unlock()
+	for _, q := range queues {
+		for qname, m := range s.mqueues[q.dest].failedQueues() {
+			entries := memFailedEntries(m, uids)
+			n := len(entries)
+			for uid := range entries {
+				delete(m, uid)
+				s.unref(uid)
+			}
+			badgerGauge.WithLabelValues(qname, q.name).Sub(float64(n))
+			total += n
+		}
+	}
+	if total > 0 {
+		s.logger.Info("Purged failed messages", "nb", total)
+	}
+	return total
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/utils/db"
+)
+
+// the disk usage of the store is measured every diskUsageInterval
+const diskUsageInterval = 5 * time.Second
+
+// dirSize returns the size of the badger files in dirname.
+func dirSize(dirname string) (size int64, err error) {
+	err = filepath.Walk(dirname, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// watchDiskUsage periodically measures the disk usage of the store, and
+// applies the disk usage policy when the quota is exceeded.
+func (s *MessageStore) watchDiskUsage(ctx context.Context) {
+	ticker := time.NewTicker(diskUsageInterval)
+	defer ticker.Stop()
+	for {
+		s.checkDiskUsage()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *MessageStore) checkDiskUsage() {
+	usage, err := dirSize(s.dirname)
+	if err != nil {
+		s.logger.Warn("Failed to measure the disk usage of the store", "error", err)
+		return
+	}
+	diskUsageGauge.Set(float64(usage))
+	over := usage > s.maxDiskUsage
+	if s.overQuota.Swap(over) != over {
+		if over {
+			s.logger.Warn("The store exceeds its disk quota", "usage", usage, "quota", s.maxDiskUsage, "policy", s.diskPolicy)
+		} else {
+			s.logger.Info("The store is back under its disk quota", "usage", usage, "quota", s.maxDiskUsage)
+		}
+	}
+	if over && s.diskPolicy == conf.DiskDropOldest {
+		s.dropOldest(usage)
+	}
+}
+
+// dropOldest evicts the oldest messages, in proportion of the excess of disk
+// usage. The disk space is reclaimed when the value log is garbage
+// collected, so it may take a few rounds to get under the quota.
+func (s *MessageStore) dropOldest(usage int64) {
+	txn := db.NewNTransaction(s.badger, false)
+	uids := s.backend.Messages.ListKeys(txn)
+	txn.Discard()
+	if len(uids) == 0 {
+		return
+	}
+	nb := int(float64(len(uids))*float64(usage-s.maxDiskUsage)/float64(usage)) + 1
+	if nb > len(uids) {
+		nb = len(uids)
+	}
+	evicted, err := s.evictMany(uids[:nb], "max_disk_usage")
+	if err != nil {
+		s.logger.Warn("Error evicting messages over the disk quota", "error", err)
+	}
+	diskQuotaCounter.WithLabelValues(conf.DiskDropOldest).Add(float64(evicted))
+	err = s.PurgeBadger()
+	if err != nil {
+		s.logger.Warn("Error purging badger over the disk quota", "error", err)
+	}
+	s.collectGarbage()
+}
+
+// applyDiskQuota is called before new messages are ingested. It returns
+// false when the messages must be discarded. With the block policy, it waits
+// until the store is under its quota again: the ingestion pipe fills up, and
+// the sources are blocked in turn.
+func (s *MessageStore) applyDiskQuota(nb int) bool {
+	if !s.overQuota.Load() {
+		return true
+	}
+	switch s.diskPolicy {
+	case conf.DiskDropNewest:
+		diskQuotaCounter.WithLabelValues(conf.DiskDropNewest).Add(float64(nb))
+		return false
+	case conf.DiskBlock:
+		diskQuotaCounter.WithLabelValues(conf.DiskBlock).Add(float64(nb))
+		for s.overQuota.Load() {
+			select {
+			case <-s.done:
+				// the store is shutting down: save the messages anyway
+				return true
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+	return true
+}
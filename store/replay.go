@@ -0,0 +1,102 @@
+package store
+
+import (
+	"bytes"
+
+	"github.com/awnumar/memguard"
+	"github.com/dgraph-io/badger"
+	"github.com/golang/snappy"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/db"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// Replayer writes messages back into a store that is not in use by a
+// running skewer, in the ready queues of some destinations. The messages are
+// delivered when skewer starts.
+type Replayer struct {
+	badger  *badger.DB
+	backend *Backend
+	queues  []*destQueue
+}
+
+// NewReplayer opens the store for replay. secret is the plain store secret,
+// or nil when the store is not encrypted.
+func NewReplayer(cfg conf.StoreConfig, secret *memguard.LockedBuffer, dests conf.DestinationType) (*Replayer, error) {
+	err := badgerOnly(cfg)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := badger.Open(badgerOptions(cfg, cfg.Dirname))
+	if err != nil {
+		return nil, eerrors.Wrap(err, "failed to open the badger database")
+	}
+	bend, err := NewBackend(kv, secret)
+	if err != nil {
+		_ = kv.Close()
+		return nil, eerrors.Wrap(err, "error creating the backend from the badger database")
+	}
+	r := &Replayer{badger: kv, backend: bend}
+	for _, dest := range dests.Iterate() {
+		r.queues = append(r.queues, newDestQueue(dest, bend))
+	}
+	return r, nil
+}
+
+// Replay writes a batch of messages. The messages that are still in the
+// store are skipped. It returns the number of written messages.
+func (r *Replayer) Replay(msgs []*model.FullMessage) (nb int, err error) {
+	for {
+		nb, err = r.replayHelper(msgs)
+		if err != badger.ErrConflict {
+			return nb, err
+		}
+	}
+}
+
+func (r *Replayer) replayHelper(msgs []*model.FullMessage) (nb int, err error) {
+	txn := db.NewNTransaction(r.badger, true)
+	defer txn.Discard()
+
+	var buf bytes.Buffer
+	w := snappy.NewBufferedWriter(&buf)
+
+	for _, msg := range msgs {
+		have, err := r.backend.Messages.Exists(msg.Uid, txn)
+		if err != nil {
+			return 0, err
+		}
+		if have {
+			continue
+		}
+		b, err := msg.Marshal()
+		if err != nil {
+			return 0, eerrors.Wrap(err, "Failed to protobuf-marshal message")
+		}
+		buf.Reset()
+		w.Reset(&buf)
+		_, _ = w.Write(b)
+		_ = w.Close()
+		err = r.backend.Messages.Set(msg.Uid, buf.String(), txn)
+		if err != nil {
+			return 0, err
+		}
+		for _, q := range r.queues {
+			err = q.ready.Set(msg.Uid, "true", txn)
+			if err != nil {
+				return 0, err
+			}
+		}
+		nb++
+	}
+	return nb, txn.Commit(nil)
+}
+
+// Close closes the store.
+func (r *Replayer) Close() error {
+	for _, q := range r.queues {
+		q.dispose()
+	}
+	return r.badger.Close()
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/encoders"
+	"github.com/stephane-martin/skewer/encoders/baseenc"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/db"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// the messages are evicted by batches of evictBatchSize
+const evictBatchSize = 1000
+
+// evict deletes the messages that have been waiting for longer than the
+// maximum age, and the oldest messages beyond the maximum number of
+// messages. The messages are removed from the ready, failed and permerrors
+// queues of all destinations, and their content is deleted by the next
+// purge. The messages that are being sent are left alone.
+func (s *MessageStore) evict() error {
+	if s.maxAge <= 0 && s.maxMessages <= 0 {
+		return nil
+	}
+	txn := db.NewNTransaction(s.badger, false)
+	uids := s.backend.Messages.ListKeys(txn)
+	txn.Discard()
+
+	// the keys are ULIDs, so the messages are sorted by reception time
+	var expired, overflow int
+	if s.maxAge > 0 {
+		deadline := time.Now().Add(-s.maxAge)
+		for expired < len(uids) && uids[expired].Time().Before(deadline) {
+			expired++
+		}
+	}
+	if s.maxMessages > 0 && len(uids) > s.maxMessages {
+		overflow = len(uids) - s.maxMessages
+	}
+
+	_, err := s.evictMany(uids[:expired], "max_age")
+	if err != nil {
+		return err
+	}
+	if overflow > expired {
+		_, err = s.evictMany(uids[expired:overflow], "max_messages")
+	}
+	return err
+}
+
+// evictMany evicts some messages by batches, and returns the number of
+// evicted messages.
+func (s *MessageStore) evictMany(uids []utils.MyULID, reason string) (int, error) {
+	var total int
+	for start := 0; start < len(uids); start += evictBatchSize {
+		end := start + evictBatchSize
+		if end > len(uids) {
+			end = len(uids)
+		}
+		nb, err := s.evictBatch(uids[start:end], reason)
+		if err != nil {
+			return total, err
+		}
+		total += nb
+	}
+	if total > 0 {
+		s.logger.Info("Evicted messages from the store", "nb", total, "reason", reason)
+	}
+	return total, nil
+}
+
+// evictBatch evicts some messages, and returns the number of evicted
+// messages.
+func (s *MessageStore) evictBatch(uids []utils.MyULID, reason string) (int, error) {
+	var res *evictResult
+	var err error
+	for {
+		res, err = evictHelper(s.badger, s.backend, s.queues, uids, len(s.deadLetterFile) > 0)
+		if err != badger.ErrConflict {
+			break
+		}
+	}
+	if err != nil {
+		return 0, eerrors.Wrap(err, "Failed to evict messages")
+	}
+	for key, nb := range res.gauges {
+		badgerGauge.WithLabelValues(key[0], key[1]).Sub(float64(nb))
+	}
+	for uid, refs := range res.refs {
+		for i := 0; i < refs; i++ {
+			s.count.Dec(uid)
+		}
+	}
+	evictedCounter.WithLabelValues(reason).Add(float64(len(res.refs)))
+	if len(res.contents) > 0 {
+		err = s.writeDeadLetters(res.contents)
+		if err != nil {
+			s.logger.Warn("Failed to write the evicted messages to the dead letter file", "error", err)
+		}
+	}
+	return len(res.refs), nil
+}
+
+type evictResult struct {
+	// number of references removed for each message
+	refs map[utils.MyULID]int
+	// number of messages removed from each queue and destination
+	gauges map[[2]string]int
+	// the content of the evicted messages
+	contents [][]byte
+}
+
+func evictHelper(badg *badger.DB, bend *Backend, queues map[conf.DestinationType]*destQueue, uids []utils.MyULID, withContent bool) (*evictResult, error) {
+	txn := db.NewNTransaction(badg, true)
+	defer txn.Discard()
+
+	res := &evictResult{
+		refs:   make(map[utils.MyULID]int),
+		gauges: make(map[[2]string]int),
+	}
+	for _, q := range queues {
+		partitions := map[string]db.Partition{
+			"ready":      q.ready,
+			"failed":     q.failed,
+			"permerrors": q.permerrors,
+		}
+		for qname, partition := range partitions {
+			for _, uid := range uids {
+				have, err := partition.Exists(uid, txn)
+				if err != nil {
+					return nil, err
+				}
+				if !have {
+					continue
+				}
+				err = partition.Delete(uid, txn)
+				if err != nil {
+					return nil, err
+				}
+				res.refs[uid]++
+				res.gauges[[2]string{qname, q.name}]++
+			}
+		}
+	}
+	if withContent {
+		for _, uid := range uids {
+			if res.refs[uid] == 0 {
+				continue
+			}
+			content, err := bend.Messages.Get(uid, nil, txn)
+			if err == nil && len(content) > 0 {
+				res.contents = append(res.contents, content)
+			}
+		}
+	}
+	return res, txn.Commit(nil)
+}
+
+// writeDeadLetters appends the evicted messages to the dead letter file, as
+// JSON lines.
+func (s *MessageStore) writeDeadLetters(contents [][]byte) (err error) {
+	encoder, err := encoders.GetEncoder(baseenc.FullJSON)
+	if err != nil {
+		return err
+	}
+	fname := s.deadLetterFile
+	if s.confined {
+		// the directory of the dead letter file is bind-mounted in the namespace
+		fname = filepath.Join("/tmp", "deadletter", fname)
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return eerrors.Wrapf(err, "Failed to open the dead letter file '%s'", s.deadLetterFile)
+	}
+	defer func() {
+		e := f.Close()
+		if err == nil {
+			err = e
+		}
+	}()
+	for _, content := range contents {
+		m, err := decodeStored(content)
+		if err != nil {
+			s.logger.Debug("Invalid evicted message", "error", err)
+			continue
+		}
+		line, err := encoders.ChainEncode(encoder, m)
+		model.FullFree(m)
+		if err != nil {
+			s.logger.Debug("Failed to encode evicted message", "error", err)
+			continue
+		}
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			line += "\n"
+		}
+		_, err = f.WriteString(line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeStored decodes the content of a message from the messages partition.
+func decodeStored(content []byte) (*model.FullMessage, error) {
+	dec, err := ioutil.ReadAll(snappy.NewReader(bytes.NewReader(content)))
+	if err != nil {
+		return nil, err
+	}
+	return model.FromBuf(proto.NewBuffer(dec))
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"github.com/dgraph-io/badger"
+	"github.com/stephane-martin/skewer/utils"
+	"github.com/stephane-martin/skewer/utils/db"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// the messages are encrypted again by batches of rotateBatchSize
+const rotateBatchSize = 1000
+
+type reencrypter interface {
+	Reencrypt(key utils.MyULID, txn *db.NTransaction) (bool, error)
+}
+
+// rotateKey starts to encrypt the stored messages with the current store
+// secret, in the background. It returns the number of messages that will be
+// checked.
+func (s *MessageStore) rotateKey() (int, error) {
+	partition, ok := s.backend.Messages.(reencrypter)
+	if !ok {
+		return 0, eerrors.New("The store is not encrypted")
+	}
+	if !s.rotating.CAS(false, true) {
+		return 0, eerrors.New("A key rotation is already running")
+	}
+	select {
+	case <-s.done:
+		s.rotating.Store(false)
+		return 0, eerrors.New("The store is shutting down")
+	default:
+	}
+
+	txn := db.NewNTransaction(s.badger, false)
+	uids := s.backend.Messages.ListKeys(txn)
+	txn.Discard()
+
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			s.rotating.Store(false)
+			s.wg.Done()
+		}()
+		var total int
+		for start := 0; start < len(uids); start += rotateBatchSize {
+			select {
+			case <-s.done:
+				s.logger.Info("Key rotation interrupted by shutdown", "reencrypted", total)
+				return
+			default:
+			}
+			end := start + rotateBatchSize
+			if end > len(uids) {
+				end = len(uids)
+			}
+			var nb int
+			var err error
+			for {
+				nb, err = reencryptHelper(s.badger, partition, uids[start:end])
+				if err != badger.ErrConflict {
+					break
+				}
+			}
+			if err != nil {
+				s.logger.Error("Key rotation failed", "error", err, "reencrypted", total)
+				return
+			}
+			total += nb
+		}
+		s.logger.Info("Key rotation done", "reencrypted", total, "checked", len(uids))
+	}()
+	return len(uids), nil
+}
+
+func reencryptHelper(badg *badger.DB, partition reencrypter, uids []utils.MyULID) (nb int, err error) {
+	txn := db.NewNTransaction(badg, true)
+	defer txn.Discard()
+	for _, uid := range uids {
+		done, err := partition.Reencrypt(uid, txn)
+		if err == badger.ErrKeyNotFound {
+			// the message has been deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return 0, eerrors.Wrapf(err, "Failed to encrypt message '%s' again", uid)
+		}
+		if done {
+			nb++
+		}
+	}
+	return nb, txn.Commit(nil)
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+type propertyCond struct {
+	domain string
+	key    string
+	re     *regexp.Regexp
+}
+
+type route struct {
+	client     *regexp.Regexp
+	appname    *regexp.Regexp
+	facilities map[model.Facility]bool
+	severities map[model.Severity]bool
+	// the most severe levels have the lowest values
+	minSeverity model.Severity
+	maxSeverity model.Severity
+	properties  []propertyCond
+	dests       conf.DestinationType
+}
+
+// router selects the destinations of the messages, according to the
+// configured routes.
+type router []route
+
+func newRouter(routes []conf.RouteConfig) (r router, err error) {
+	r = make([]route, 0, len(routes))
+	for _, rconf := range routes {
+		rt := route{minSeverity: model.Sdebug, maxSeverity: model.Semerg}
+		rt.dests, err = rconf.GetDestinations()
+		if err != nil {
+			return nil, err
+		}
+		if len(rconf.Client) > 0 {
+			rt.client, err = regexp.Compile(rconf.Client)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(rconf.AppName) > 0 {
+			rt.appname, err = regexp.Compile(rconf.AppName)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(rconf.Facility) > 0 {
+			rt.facilities = make(map[model.Facility]bool, len(rconf.Facility))
+			for _, name := range rconf.Facility {
+				f, ok := model.RFacilities[name]
+				if !ok {
+					return nil, eerrors.Errorf("Unknown facility in route: '%s'", name)
+				}
+				rt.facilities[f] = true
+			}
+		}
+		if len(rconf.Severity) > 0 {
+			rt.severities = make(map[model.Severity]bool, len(rconf.Severity))
+			for _, name := range rconf.Severity {
+				s, ok := model.RSeverities[name]
+				if !ok {
+					return nil, eerrors.Errorf("Unknown severity in route: '%s'", name)
+				}
+				rt.severities[s] = true
+			}
+		}
+		if len(rconf.MinSeverity) > 0 {
+			s, ok := model.RSeverities[rconf.MinSeverity]
+			if !ok {
+				return nil, eerrors.Errorf("Unknown min_severity in route: '%s'", rconf.MinSeverity)
+			}
+			rt.minSeverity = s
+		}
+		if len(rconf.MaxSeverity) > 0 {
+			s, ok := model.RSeverities[rconf.MaxSeverity]
+			if !ok {
+				return nil, eerrors.Errorf("Unknown max_severity in route: '%s'", rconf.MaxSeverity)
+			}
+			rt.maxSeverity = s
+		}
+		for _, cond := range rconf.Properties {
+			domain, key, expr, err := conf.ParseProperty(cond)
+			if err != nil {
+				return nil, err
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, err
+			}
+			rt.properties = append(rt.properties, propertyCond{domain: domain, key: key, re: re})
+		}
+		r = append(r, rt)
+	}
+	return r, nil
+}
+
+func (rt *route) match(m *model.FullMessage) bool {
+	if rt.client != nil {
+		client := m.ClientAddr
+		if len(client) == 0 {
+			client = m.Fields.GetProperty("skewer", "client")
+		}
+		if !rt.client.MatchString(client) {
+			return false
+		}
+	}
+	if rt.appname != nil && !rt.appname.MatchString(m.Fields.AppName) {
+		return false
+	}
+	if rt.facilities != nil && !rt.facilities[m.Fields.Facility] {
+		return false
+	}
+	if rt.severities != nil && !rt.severities[m.Fields.Severity] {
+		return false
+	}
+	if m.Fields.Severity > rt.minSeverity || m.Fields.Severity < rt.maxSeverity {
+		return false
+	}
+	for _, cond := range rt.properties {
+		if !cond.re.MatchString(m.Fields.GetProperty(cond.domain, cond.key)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Accepts returns true if the message should be sent to the given destination.
+// The destinations in the skewer:destinations property, set by the filter
+// stages, take precedence over the routes.
+func (r router) Accepts(m *model.FullMessage, dest conf.DestinationType) bool {
+	if names := m.Fields.GetProperty("skewer", "destinations"); len(names) > 0 {
+		for _, name := range strings.Split(names, ",") {
+			if conf.Destinations[name] == dest {
+				return true
+			}
+		}
+		return false
+	}
+	for i := range r {
+		if r[i].match(m) {
+			return r[i].dests.Has(dest)
+		}
+	}
+	// the messages that match no route go everywhere
+	return true
+}
@@ -26,7 +26,6 @@ import (
 	"github.com/stephane-martin/skewer/utils/db"
 	"github.com/stephane-martin/skewer/utils/eerrors"
 	"github.com/stephane-martin/skewer/utils/queue"
-	"github.com/stephane-martin/skewer/utils/waiter"
 	"github.com/valyala/bytebufferpool"
 	"go.uber.org/atomic"
 )
@@ -35,6 +34,10 @@ var Registry *prometheus.Registry
 var badgerGauge *prometheus.GaugeVec
 var ackCounter *prometheus.CounterVec
 var messageFilterCounter *prometheus.CounterVec
+var evictedCounter *prometheus.CounterVec
+var diskQuotaCounter *prometheus.CounterVec
+var diskUsageGauge prometheus.Gauge
+var oldestGauge *prometheus.GaugeVec
 var retrieveTimeSummary prometheus.Summary
 var lsmSize prometheus.GaugeFunc
 var vlogSize prometheus.GaugeFunc
@@ -82,6 +85,37 @@ func InitRegistry() {
 			[]string{"status", "client", "destination"},
 		)
 
+		evictedCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_store_evicted_total",
+				Help: "number of messages deleted from the store by the retention policy",
+			},
+			[]string{"reason"},
+		)
+
+		diskQuotaCounter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "skw_store_disk_quota_total",
+				Help: "number of messages blocked or dropped because the store exceeds its disk quota",
+			},
+			[]string{"action"},
+		)
+
+		diskUsageGauge = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "skw_store_disk_usage",
+				Help: "size of the store files on disk, when a disk quota is set",
+			},
+		)
+
+		oldestGauge = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "skw_store_oldest_pending_seconds",
+				Help: "age of the oldest message waiting to be delivered to the destination",
+			},
+			[]string{"destination"},
+		)
+
 		retrieveTimeSummary = prometheus.NewSummary(
 			prometheus.SummaryOpts{
 				Help:       "histogram for the response time to retrieve messages from the Store",
@@ -110,7 +144,10 @@ func InitRegistry() {
 		)
 
 		Registry = prometheus.NewRegistry()
-		Registry.MustRegister(badgerGauge, ackCounter, messageFilterCounter, retrieveTimeSummary, lsmSize, vlogSize)
+		Registry.MustRegister(
+			badgerGauge, ackCounter, messageFilterCounter, evictedCounter,
+			diskQuotaCounter, diskUsageGauge, oldestGauge, retrieveTimeSummary, lsmSize, vlogSize,
+		)
 	})
 }
 
@@ -170,7 +207,7 @@ func (b *Backend) GetPartition(qtype QueueType, dtype conf.DestinationType) db.P
 	return b.Partitions[qtype][dtype]
 }
 
-func NewBackend(parent *badger.DB, storeSecret *memguard.LockedBuffer) (b *Backend, err error) {
+func NewBackend(parent *badger.DB, storeSecret *memguard.LockedBuffer, oldSecrets ...*memguard.LockedBuffer) (b *Backend, err error) {
 	b = new(Backend)
 	b.Partitions = make(map[QueueType]map[conf.DestinationType]db.Partition, len(Queues))
 	for qtype := range Queues {
@@ -182,7 +219,7 @@ func NewBackend(parent *badger.DB, storeSecret *memguard.LockedBuffer) (b *Backe
 	b.Configs = db.NewPartition(parent, "co")
 	b.Messages = db.NewPartition(parent, "ma")
 	if storeSecret != nil {
-		b.Messages, err = db.NewEncryptedPartition(b.Messages, storeSecret)
+		b.Messages, err = db.NewEncryptedPartition(b.Messages, storeSecret, oldSecrets...)
 		if err != nil {
 			return nil, eerrors.Wrap(err, "Failed to initialize encrypted partition")
 		}
@@ -200,21 +237,31 @@ type MessageStore struct {
 	purgeLock sync.Mutex
 	dests     *Destinations
 
-	ticker *time.Ticker
-	logger log15.Logger
+	ticker   *time.Ticker
+	gcTicker *time.Ticker
+	logger   log15.Logger
 
 	closedChan     chan struct{}
 	FatalErrorChan chan struct{}
-	OutputsChans   map[conf.DestinationType]chan []*model.FullMessage
-	zeroMsgFlags   map[conf.DestinationType]*atomic.Bool
-
-	ackQueue        *queue.AckQueue
-	nackQueue       *queue.AckQueue
-	permerrorsQueue *queue.AckQueue
+	queues         map[conf.DestinationType]*destQueue
 
 	confined        bool
 	BatchSize       uint32
+	ackBatchSize    uint32
+	ackLatency      time.Duration
 	addMissingMsgID bool
+	maxAge          time.Duration
+	maxMessages     int
+	deadLetterFile  string
+	dirname         string
+	maxDiskUsage    int64
+	diskPolicy      string
+	overQuota       *atomic.Bool
+	rotating        *atomic.Bool
+	done            <-chan struct{}
+	lastErrs        lastErrors
+	gcInterval      time.Duration
+	gcDiscardRatio  float64
 	generator       *utils.Generator
 	uidsTmpBuf      []utils.MyULID
 }
@@ -224,7 +271,7 @@ func (s *MessageStore) Confined() bool {
 }
 
 func (s *MessageStore) Outputs(dest conf.DestinationType) chan []*model.FullMessage {
-	return s.OutputsChans[dest]
+	return s.queues[dest].outputs
 }
 
 func (s *MessageStore) Errors() chan struct{} {
@@ -239,32 +286,6 @@ func (s *MessageStore) SetDestinations(dests conf.DestinationType) {
 	s.dests.Store(dests)
 }
 
-func (s *MessageStore) receiveAcks() error {
-	var ackBatchSize = uint32(s.BatchSize * 4 / 5)
-	var nackBatchSize = uint32(s.BatchSize / 10)
-	acks := make([]queue.UidDest, 0, ackBatchSize)
-	nacks := make([]queue.UidDest, 0, nackBatchSize)
-	permerrs := make([]queue.UidDest, 0, nackBatchSize)
-	for queue.WaitManyAckQueues(s.ackQueue, s.nackQueue, s.permerrorsQueue) {
-		s.ackQueue.GetManyInto(&acks)
-		s.nackQueue.GetManyInto(&nacks)
-		s.permerrorsQueue.GetManyInto(&permerrs)
-		err := s.doACK(acks)
-		if err != nil {
-			return eerrors.Wrap(err, "Error applying ACKs")
-		}
-		err = s.doNACK(nacks)
-		if err != nil {
-			return eerrors.Wrap(err, "Error applying NACKs")
-		}
-		err = s.doPermanentError(permerrs)
-		if err != nil {
-			return eerrors.Wrap(err, "Error applying PermErrors")
-		}
-	}
-	return nil
-}
-
 /*
 	if err == badger.ErrNoRoom {
 		TODO: check that in another place
@@ -283,181 +304,30 @@ func (s *MessageStore) tickResetFailures(ctx context.Context) error {
 			if err != nil {
 				return err
 			}
+			err = s.evict()
+			if err != nil {
+				s.logger.Warn("Error evicting messages from the store", "error", err)
+			}
 			err = s.PurgeBadger()
 			if err != nil {
 				s.logger.Warn("Error in the periodic badger purge", "error", err)
 			}
+		case <-s.gcTicker.C:
+			s.collectGarbage()
 		case <-ctx.Done():
 			s.ticker.Stop()
+			s.gcTicker.Stop()
 			return nil
 		}
 	}
 }
 
-func (s *MessageStore) retrieveAndForward(ctx context.Context) (err error) {
-	var wg sync.WaitGroup
-	bucket := make(map[conf.DestinationType]*atomic.Value, len(conf.Destinations))
-
-	var nilmsg []*model.FullMessage
-	for _, d := range conf.Destinations {
-		bucket[d] = new(atomic.Value)
-		bucket[d].Store(nilmsg)
-	}
-	lctx, cancel := context.WithCancel(ctx)
-
-	for _, d := range conf.Destinations {
-		wg.Add(1)
-
-		go func(dest conf.DestinationType) {
-			defer wg.Done()
-
-			ew := waiter.Default()
-			var previousMsgs []*model.FullMessage
-
-		ForwardLoop:
-			for {
-				if !s.dests.Has(dest) {
-					// that destination is not currently selected, do nothing
-					select {
-					case <-lctx.Done():
-						return
-					case <-time.After(time.Second):
-						continue ForwardLoop
-					}
-				}
-				// look into the bucket for available messages
-				msgs := bucket[dest].Load().([]*model.FullMessage)
-				if len(msgs) == 0 {
-					// no available message for that destination, let's wait a little
-					select {
-					case <-lctx.Done():
-						return
-					case <-time.After(ew.Next()):
-						continue ForwardLoop
-					}
-				}
-
-				// there are some messages to forward
-				ew.Reset()
-				select {
-				case s.Outputs(dest) <- msgs:
-					// s.Outputs() is a non-buffered chan. So when
-					// s.Outputs() <- msgs returns, it means that the forwarder
-					// has finished to process the previously provided messages.
-					// Therefore we can now push back the previous messages slice
-					// to the slice pool.
-					if previousMsgs != nil {
-						msgsSlicePool.Put(previousMsgs)
-					}
-					previousMsgs = msgs
-					msgs = nil
-					bucket[dest].Store(msgs)
-				case <-lctx.Done():
-					return
-				}
-			}
-		}(d)
-	}
-
-	defer func() {
-		cancel()
-		wg.Wait()
-		for _, d := range conf.Destinations {
-			close(s.Outputs(d))
-			// NACK the messages that were not delivered
-			msgs := bucket[d].Load().([]*model.FullMessage)
-			if len(msgs) > 0 {
-				for _, message := range msgs {
-					s.NACK(message.Uid, d)
-					model.FullFree(message)
-				}
-				msgs = nil
-				bucket[d].Store(msgs)
-			}
-		}
-	}()
-
-	next := make(map[conf.DestinationType]time.Time, len(conf.Destinations))
-	waits := make(map[conf.DestinationType]*waiter.W, len(conf.Destinations))
-	now := time.Now()
-	for _, d := range conf.Destinations {
-		waits[d] = waiter.Default()
-		if s.dests.Has(d) {
-			next[d] = now
-		} else {
-			next[d] = now.Add(time.Second)
-		}
-	}
-
-	var currentDest conf.DestinationType
-	var currentDestIdx uint
-	var first time.Time
-
-RetrieveLoop:
-	for {
-		select {
-		case <-lctx.Done():
-			return nil
-		default:
-		}
-		// wait until we have something to do
-		now = time.Now()
-		first = now.Add(time.Hour)
-		for _, d := range conf.Destinations {
-			if next[d].Before(first) {
-				first = next[d]
-			}
-		}
-		if now.Before(first) {
-			select {
-			case <-lctx.Done():
-				return nil
-			case <-time.After(first.Sub(now)):
-			}
-		}
-
-		currentDestIdx = (currentDestIdx + 1) % uint(len(conf.Destinations))
-		currentDest = 1 << currentDestIdx
-
-		if time.Now().Before(next[currentDest]) {
-			// wait more for next check
-			continue RetrieveLoop
-		}
-		if !s.dests.Has(currentDest) {
-			// current destination is not selected
-			next[currentDest] = now.Add(time.Second)
-			continue RetrieveLoop
-		}
-		if len(bucket[currentDest].Load().([]*model.FullMessage)) > 0 {
-			// previous messages are still there
-			next[currentDest] = now.Add(waits[currentDest].Next())
-			continue RetrieveLoop
-		}
-		if s.zeroMsgFlags[currentDest].Load() {
-			// we are sure that there was no new message
-			next[currentDest] = time.Now().Add(waits[currentDest].Next())
-			continue RetrieveLoop
-		}
-		messages, err := s.retrieve(currentDest)
-		if err != nil {
-			return eerrors.Wrap(err, "Failed to retrieve messages from badger")
-		}
-		if len(messages) == 0 {
-			// no messages in store for that destination
-			s.zeroMsgFlags[currentDest].Store(true)
-			next[currentDest] = time.Now().Add(waits[currentDest].Next())
-			continue RetrieveLoop
-		}
-		waits[currentDest].Reset()
-		bucket[currentDest].Store(messages)
-	}
-}
-
 func (s *MessageStore) init(ctx context.Context) {
 	lctx, cancel := context.WithCancel(ctx)
 
 	s.FatalErrorChan = make(chan struct{})
 	s.ticker = time.NewTicker(time.Minute)
+	s.gcTicker = time.NewTicker(s.gcInterval)
 
 	// only once, push back messages from previous run that may have been stuck in the sent queue
 	s.logger.Debug("reset messages stuck in sent")
@@ -482,39 +352,65 @@ func (s *MessageStore) init(ctx context.Context) {
 
 	s.initGauge()
 
-	errs := make(chan error, 4)
+	s.logger.Debug("evict expired messages")
+	err = s.evict()
+	if err != nil {
+		s.logger.Warn("Error evicting messages from the store", "error", err)
+	}
 
-	s.wg.Add(1)
-	go func() {
-		defer func() {
-			s.logger.Debug("receiveAcks done")
-			s.wg.Done()
+	errs := make(chan error, 2*len(s.queues)+1)
+
+	for _, q := range s.queues {
+		s.wg.Add(2)
+		go func(q *destQueue) {
+			defer func() {
+				s.logger.Debug("receiveAcks done", "dest", q.name)
+				s.wg.Done()
+			}()
+			err := receiveAcks(s, q, s.ackBatchSize, s.ackLatency)
+			if err != nil {
+				errs <- err
+			}
+		}(q)
+		go func(q *destQueue) {
+			defer func() {
+				s.logger.Debug("retrieveAndForward done", "dest", q.name)
+				s.wg.Done()
+			}()
+			err := retrieveAndForward(lctx, s, q, s.dests)
+			if err != nil {
+				errs <- err
+			}
+		}(q)
+	}
+
+	if s.maxDiskUsage > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer func() {
+				s.logger.Debug("watchDiskUsage done")
+				s.wg.Done()
+			}()
+			s.watchDiskUsage(lctx)
 		}()
-		err := s.receiveAcks()
-		if err != nil {
-			errs <- err
-		}
-	}()
+	}
 
 	s.wg.Add(1)
 	go func() {
 		defer func() {
-			s.logger.Debug("tickResetFailures done")
+			s.logger.Debug("watchOldest done")
 			s.wg.Done()
 		}()
-		err := s.tickResetFailures(lctx)
-		if err != nil {
-			errs <- err
-		}
+		watchOldest(lctx, s, s.queues)
 	}()
 
 	s.wg.Add(1)
 	go func() {
 		defer func() {
-			s.logger.Debug("retrieveAndForward done")
+			s.logger.Debug("tickResetFailures done")
 			s.wg.Done()
 		}()
-		err := s.retrieveAndForward(lctx)
+		err := s.tickResetFailures(lctx)
 		if err != nil {
 			errs <- err
 		}
@@ -533,16 +429,17 @@ func (s *MessageStore) init(ctx context.Context) {
 			cancel()
 			close(s.FatalErrorChan)
 		}
-		// dispose the queues. makes the goroutine receiveAcks stop.
-		s.ackQueue.Dispose()
-		s.nackQueue.Dispose()
-		s.permerrorsQueue.Dispose()
+		// dispose the queues. makes the goroutines receiveAcks stop.
+		for _, q := range s.queues {
+			q.dispose()
+		}
 
 		// wait that all goroutines have stopped
 		s.logger.Debug("Waiting for the end of store goroutines")
 		s.wg.Wait()
 		s.logger.Debug("Final purge of badger")
 		s.PurgeBadger()
+		s.collectGarbage()
 		s.logger.Debug("Final close of badger")
 		s.closeBadgers()
 		// notify our caller
@@ -570,11 +467,16 @@ func getValueLogSize() float64 {
 	return float64(size)
 }
 
-func NewStore(ctx context.Context, cfg conf.StoreConfig, r kring.Ring, dests conf.DestinationType, cfnd bool, l log15.Logger) (*MessageStore, error) {
-	dirname := cfg.Dirname
-	if cfnd {
-		dirname = filepath.Join("/tmp", "store", dirname)
+// badgerOnly returns an error when the store does not use the badger backend,
+// for the operations that only badger supports.
+func badgerOnly(cfg conf.StoreConfig) error {
+	if cfg.Backend != conf.BadgerBackend && len(cfg.Backend) > 0 {
+		return eerrors.Errorf("Not supported by the '%s' store backend", cfg.Backend)
 	}
+	return nil
+}
+
+func badgerOptions(cfg conf.StoreConfig, dirname string) badger.Options {
 	badgerOpts := badger.DefaultOptions
 	badgerOpts.Dir = dirname
 	badgerOpts.ValueDir = dirname
@@ -585,24 +487,77 @@ func NewStore(ctx context.Context, cfg conf.StoreConfig, r kring.Ring, dests con
 	badgerOpts.ValueLogLoadingMode = options.MemoryMap
 	badgerOpts.ValueLogFileSize = cfg.ValueLogFileSize
 	badgerOpts.NumVersionsToKeep = 1
+	return badgerOpts
+}
 
+// NewStore opens the store with the backend selected by the configuration.
+func NewStore(ctx context.Context, cfg conf.StoreConfig, r kring.Ring, dests conf.DestinationType, cfnd bool, l log15.Logger) (Store, error) {
+	if cfg.Backend == conf.MemoryBackend {
+		return newMemoryStore(ctx, cfg, dests, cfnd, l), nil
+	}
+	dirname := cfg.Dirname
+	if cfnd {
+		dirname = filepath.Join("/tmp", "store", dirname)
+	}
 	err := os.MkdirAll(dirname, 0700)
 	if err != nil {
 		return nil, eerrors.Wrap(err, "failed to create the directory for the Store")
 	}
+	if cfg.Backend == conf.BoltBackend {
+		return newBoltStore(ctx, cfg, dirname, r, dests, cfnd, l)
+	}
+	return newBadgerStore(ctx, cfg, dirname, r, dests, cfnd, l)
+}
+
+// storeSecrets returns the current and the previous store secrets, or nil
+// when the store is not encrypted.
+func storeSecrets(cfg conf.StoreConfig, r kring.Ring) (secret *memguard.LockedBuffer, old []*memguard.LockedBuffer, err error) {
+	if r == nil {
+		return nil, nil, nil
+	}
+	sessionSecret, err := r.GetBoxSecret()
+	if err != nil {
+		return nil, nil, eerrors.Wrap(err, "fail to retrieve the box secret")
+	}
+	defer sessionSecret.Destroy()
+	secret, err = cfg.GetSecretB(sessionSecret)
+	if err != nil {
+		return nil, nil, eerrors.Wrap(err, "failed to retrieve the session secret")
+	}
+	if secret == nil {
+		return nil, nil, nil
+	}
+	old, err = cfg.GetOldSecretsB(sessionSecret)
+	if err != nil {
+		return nil, nil, eerrors.Wrap(err, "failed to retrieve the old store secrets")
+	}
+	return secret, old, nil
+}
+
+func newBadgerStore(ctx context.Context, cfg conf.StoreConfig, dirname string, r kring.Ring, dests conf.DestinationType, cfnd bool, l log15.Logger) (*MessageStore, error) {
+	badgerOpts := badgerOptions(cfg, dirname)
 
 	store := &MessageStore{
 		confined:        cfnd,
 		logger:          l.New("class", "MessageStore"),
 		dests:           &Destinations{},
 		BatchSize:       cfg.BatchSize,
-		ackQueue:        queue.NewAckQueue(),
-		nackQueue:       queue.NewAckQueue(),
-		permerrorsQueue: queue.NewAckQueue(),
+		ackBatchSize:    cfg.AckBatchSize,
+		ackLatency:      cfg.AckLatency,
 		closedChan:      make(chan struct{}),
-		OutputsChans:    make(map[conf.DestinationType]chan []*model.FullMessage, len(conf.Destinations)),
-		zeroMsgFlags:    make(map[conf.DestinationType]*atomic.Bool, len(conf.Destinations)),
+		queues:          make(map[conf.DestinationType]*destQueue, len(conf.Destinations)),
 		addMissingMsgID: cfg.AddMissingMsgID,
+		maxAge:          cfg.MaxAge,
+		maxMessages:     cfg.MaxMessages,
+		deadLetterFile:  cfg.DeadLetterFile,
+		dirname:         dirname,
+		maxDiskUsage:    cfg.MaxDiskUsage,
+		diskPolicy:      cfg.DiskUsagePolicy,
+		overQuota:       atomic.NewBool(false),
+		rotating:        atomic.NewBool(false),
+		done:            ctx.Done(),
+		gcInterval:      cfg.GCInterval,
+		gcDiscardRatio:  cfg.GCDiscardRatio,
 		generator:       utils.NewGenerator(),
 		count:           utils.NewRefCount(),
 	}
@@ -614,29 +569,20 @@ func NewStore(ctx context.Context, cfg conf.StoreConfig, r kring.Ring, dests con
 	}
 	store.badger = kv
 
-	var storeSecret *memguard.LockedBuffer
-	if r != nil {
-		sessionSecret, err := r.GetBoxSecret()
-		if err != nil {
-			return nil, eerrors.Wrap(err, "fail to retrieve the box secret")
-		}
-		defer sessionSecret.Destroy()
-		storeSecret, err = cfg.GetSecretB(sessionSecret)
-		if err != nil {
-			return nil, eerrors.Wrap(err, "failed to retrieve the session secret")
-		}
-		if storeSecret != nil {
-			store.logger.Info("The badger store is encrypted")
-		}
+	storeSecret, oldSecrets, err := storeSecrets(cfg, r)
+	if err != nil {
+		return nil, err
 	}
-	store.backend, err = NewBackend(kv, storeSecret)
+	if storeSecret != nil {
+		store.logger.Info("The badger store is encrypted")
+	}
+	store.backend, err = NewBackend(kv, storeSecret, oldSecrets...)
 	if err != nil {
 		return nil, eerrors.Wrap(err, "error creating the backend from the badger database")
 	}
 
 	for _, dest := range conf.Destinations {
-		store.OutputsChans[dest] = make(chan []*model.FullMessage)
-		store.zeroMsgFlags[dest] = atomic.NewBool(false)
+		store.queues[dest] = newDestQueue(dest, store.backend)
 	}
 
 	store.wg.Add(1)
@@ -653,53 +599,88 @@ func (s *MessageStore) WaitFinished() {
 }
 
 func (s *MessageStore) StoreAllSyslogConfigs(c conf.BaseConfig) (err error) {
+	return storeAllSyslogConfigs(c, s.StoreSyslogConfig)
+}
+
+// storeAllSyslogConfigs saves the filter configuration of every source with
+// storeConf.
+func storeAllSyslogConfigs(c conf.BaseConfig, storeConf func(utils.MyULID, conf.FilterSubConfig) error) (err error) {
 	funcs := make([]utils.Func, 0, 20)
 
 	for _, c := range c.TCPSource {
 		tcpConf := c
 		funcs = append(funcs, func() error {
-			return s.StoreSyslogConfig(tcpConf.ConfID, tcpConf.FilterSubConfig)
+			return storeConf(tcpConf.ConfID, tcpConf.FilterSubConfig)
 		})
 	}
 
 	for _, c := range c.UDPSource {
 		udpConf := c
 		funcs = append(funcs, func() error {
-			return s.StoreSyslogConfig(udpConf.ConfID, udpConf.FilterSubConfig)
+			return storeConf(udpConf.ConfID, udpConf.FilterSubConfig)
 		})
 	}
 
 	for _, c := range c.RELPSource {
 		relpConf := c
 		funcs = append(funcs, func() error {
-			return s.StoreSyslogConfig(relpConf.ConfID, relpConf.FilterSubConfig)
+			return storeConf(relpConf.ConfID, relpConf.FilterSubConfig)
 		})
 	}
 
 	for _, c := range c.KafkaSource {
 		kafkaConf := c
 		funcs = append(funcs, func() error {
-			return s.StoreSyslogConfig(kafkaConf.ConfID, kafkaConf.FilterSubConfig)
+			return storeConf(kafkaConf.ConfID, kafkaConf.FilterSubConfig)
 		})
 	}
 
 	for _, c := range c.GraylogSource {
 		graylogConf := c
 		funcs = append(funcs, func() error {
-			return s.StoreSyslogConfig(graylogConf.ConfID, graylogConf.FilterSubConfig)
+			return storeConf(graylogConf.ConfID, graylogConf.FilterSubConfig)
+		})
+	}
+
+	for _, c := range c.ExecSource {
+		execConf := c
+		funcs = append(funcs, func() error {
+			return storeConf(execConf.ConfID, execConf.FilterSubConfig)
+		})
+	}
+
+	for _, c := range c.PubSubSource {
+		pubsubConf := c
+		funcs = append(funcs, func() error {
+			return storeConf(pubsubConf.ConfID, pubsubConf.FilterSubConfig)
+		})
+	}
+
+	for _, c := range c.JournalGatewaySource {
+		gatewayConf := c
+		funcs = append(funcs, func() error {
+			return storeConf(gatewayConf.ConfID, gatewayConf.FilterSubConfig)
 		})
 	}
 
 	funcs = append(funcs, func() error {
-		return s.StoreSyslogConfig(c.Journald.ConfID, c.Journald.FilterSubConfig)
+		return storeConf(c.Journald.ConfID, c.Journald.FilterSubConfig)
 	})
 
 	funcs = append(funcs, func() error {
-		return s.StoreSyslogConfig(c.Accounting.ConfID, c.Accounting.FilterSubConfig)
+		return storeConf(c.Accounting.ConfID, c.Accounting.FilterSubConfig)
 	})
 
 	funcs = append(funcs, func() error {
-		return s.StoreSyslogConfig(c.MacOS.ConfID, c.MacOS.FilterSubConfig)
+		return storeConf(c.MacOS.ConfID, c.MacOS.FilterSubConfig)
+	})
+
+	funcs = append(funcs, func() error {
+		return storeConf(c.Kubernetes.ConfID, c.Kubernetes.FilterSubConfig)
+	})
+
+	funcs = append(funcs, func() error {
+		return storeConf(c.Stdin.ConfID, c.Stdin.FilterSubConfig)
 	})
 
 	return utils.Chain(funcs...)
@@ -745,6 +726,26 @@ func (s *MessageStore) GetSyslogConfig(confID utils.MyULID) (*conf.FilterSubConf
 	return c, nil
 }
 
+// oldest returns the oldest message that waits to be delivered to the
+// destination, or an empty uid.
+func (s *MessageStore) oldest(q *destQueue) (oldest utils.MyULID) {
+	txn := db.NewNTransaction(s.badger, false)
+	defer txn.Discard()
+	for _, p := range []db.Partition{q.ready, q.sent, q.failed} {
+		// the keys are sorted, and the ULIDs are sorted by time
+		iter := p.KeyIterator(txn)
+		iter.Rewind()
+		if iter.Valid() {
+			uid := iter.Key()
+			if len(oldest) == 0 || uid < oldest {
+				oldest = uid
+			}
+		}
+		iter.Close()
+	}
+	return oldest
+}
+
 func (s *MessageStore) initGauge() {
 	s.logger.Debug("Calculating the store initial content size")
 	defer s.logger.Debug("Done calculating the store initial content size")
@@ -888,66 +889,26 @@ func (s *MessageStore) resetStuckInSent() (err error) {
 	// push back to "Ready" the messages that were sent out of the Store in the
 	// last execution of skewer, but never were ACKed or NACKed
 	var nb int
-	for _, dest := range conf.Destinations {
-	RetryLoop:
-		for {
-			nb, err = reset(s.badger, s.backend, dest)
-			if err == badger.ErrConflict {
-				continue RetryLoop
-			}
-			if err != nil {
-				return eerrors.Wrap(err, "failed to reset messages stuck in the sent queue")
-			}
-			break RetryLoop
+	for _, q := range s.queues {
+		nb, err = s.resetStuckInSentByDest(q)
+		if err != nil {
+			return eerrors.Wrap(err, "failed to reset messages stuck in the sent queue")
 		}
 		if nb > 0 {
-			s.logger.Info("Reset messages from the sent queue", "dest", dest, "nb", nb)
+			s.logger.Info("Reset messages from the sent queue", "dest", q.name, "nb", nb)
 		}
 	}
 	return nil
 }
 
-func reset(badg *badger.DB, bend *Backend, dest conf.DestinationType) (nb int, err error) {
-	txn := db.NewNTransaction(badg, true)
-	defer txn.Discard()
-
-	sentDB := bend.GetPartition(Sent, dest)
-	readyDB := bend.GetPartition(Ready, dest)
-
-	nb, err = resetStuckInSentByDest(sentDB, readyDB, dest, txn)
-	if err != nil {
-		return 0, err
-	}
-	err = txn.Commit(nil)
-	if err != nil {
-		return 0, err
-	}
-	return nb, nil
-}
-
-func resetStuckInSentByDest(sentDB, readyDB db.Partition, dest conf.DestinationType, txn *db.NTransaction) (nb int, err error) {
-	uids := sentDB.ListKeys(txn)
-	err = sentDB.DeleteMany(uids, txn)
-	if err != nil {
-		return 0, err
-	}
-	for _, uid := range uids {
-		err = readyDB.Set(uid, "true", txn)
-		if err != nil {
-			return 0, err
-		}
-	}
-	return len(uids), nil
-}
-
 func (s *MessageStore) ReadAllBadgers() (map[string]string, map[string]string, map[string]string) {
 	return nil, nil, nil // TODO
 }
 
 func (s *MessageStore) resetFailures() error {
 	// push back messages from "failed" to "ready"
-	for _, dest := range conf.Destinations {
-		err := s.resetFailuresByDest(dest)
+	for _, q := range s.queues {
+		err := s.resetFailuresByDest(q)
 		if err != nil {
 			return err
 		}
@@ -955,9 +916,9 @@ func (s *MessageStore) resetFailures() error {
 	return nil
 }
 
-func (s *MessageStore) resetFailuresByDest(dest conf.DestinationType) (err error) {
-	failedDB := s.backend.GetPartition(Failed, dest)
-	readyDB := s.backend.GetPartition(Ready, dest)
+func (s *MessageStore) resetFailuresByDest(q *destQueue) (err error) {
+	failedDB := q.failed
+	readyDB := q.ready
 
 	iterate := func(txn *db.NTransaction) (expiredUIDs []utils.MyULID, invalidUIDs []utils.MyULID) {
 		expiredUIDs = make([]utils.MyULID, 0)
@@ -1045,8 +1006,11 @@ func (s *MessageStore) resetFailuresByDest(dest conf.DestinationType) (err error
 	if err != nil {
 		return eerrors.Wrap(err, "failed to reset expired failures")
 	}
-	badgerGauge.WithLabelValues("ready", conf.DestinationNames[dest]).Add(float64(nbExpired))
-	badgerGauge.WithLabelValues("failed", conf.DestinationNames[dest]).Sub(float64(nbExpired + nbInvalid))
+	if nbExpired > 0 {
+		q.zeroMsg.Store(false)
+	}
+	badgerGauge.WithLabelValues("ready", q.name).Add(float64(nbExpired))
+	badgerGauge.WithLabelValues("failed", q.name).Sub(float64(nbExpired + nbInvalid))
 	if nbInvalid > 0 {
 		s.logger.Info("Deleted some invalid entries", "nb", nbInvalid)
 	}
@@ -1057,19 +1021,12 @@ func (s *MessageStore) resetFailuresByDest(dest conf.DestinationType) (err error
 
 }
 
+// PurgeBadger deletes the messages that are not referenced anymore. The disk
+// space is reclaimed by the next value log GC.
 func (s *MessageStore) PurgeBadger() (err error) {
 	s.purgeLock.Lock()
+	defer s.purgeLock.Unlock()
 	s.logger.Debug("Purge badger")
-	defer func() {
-		// run the purge
-		e := s.badger.RunValueLogGC(0.25)
-		if e != nil && e != badger.ErrNoRewrite {
-			err = eerrors.Wrap(e, "Error happened when garbage collecting the badger")
-		} else {
-			s.logger.Debug("Purge done")
-		}
-		s.purgeLock.Unlock()
-	}()
 
 	// delete the messages that are not referenced anymore
 	uids := s.count.GC()
@@ -1114,10 +1071,9 @@ func ingestMsgsHelper(badg *badger.DB, msgsDB db.Partition, queue map[utils.MyUL
 	return txn.Commit(nil)
 }
 
-func (s *MessageStore) ingestReadyByDest(queue map[utils.MyULID]string, dest conf.DestinationType) error {
-	readyDB := s.backend.GetPartition(Ready, dest)
+func (s *MessageStore) ingestReadyByDest(queue map[utils.MyULID]string, q *destQueue) error {
 	for {
-		err := ingestReadyHelper(s.badger, readyDB, queue)
+		err := ingestReadyHelper(s.badger, q.ready, queue)
 		if err != badger.ErrConflict {
 			return err
 		}
@@ -1139,6 +1095,9 @@ func (s *MessageStore) Ingest(m map[utils.MyULID]string) (int, error) {
 	if length == 0 {
 		return 0, nil
 	}
+	if !s.applyDiskQuota(length) {
+		return 0, nil
+	}
 	w := snappy.NewBufferedWriter(ioutil.Discard)
 	for k, v := range m {
 		if len(v) == 0 {
@@ -1159,17 +1118,21 @@ func (s *MessageStore) Ingest(m map[utils.MyULID]string) (int, error) {
 	}
 	badgerGauge.WithLabelValues("messages", "").Add(float64(length))
 
-	// reference the new messages in the ready queue
+	// reference the new messages in the ready queue of each destination. a
+	// failure for a destination does not prevent the others from getting the
+	// messages.
 	destinations := s.Destinations()
 	var nbDone int32
 	for _, dest := range destinations {
-		err = s.ingestReadyByDest(m, dest)
-		if err != nil {
-			break
+		q := s.queues[dest]
+		e := s.ingestReadyByDest(m, q)
+		if e != nil {
+			err = eerrors.Wrapf(e, "Failed to push messages to the ready queue of destination '%s'", q.name)
+			continue
 		}
 		nbDone++
-		s.zeroMsgFlags[dest].Store(false)
-		badgerGauge.WithLabelValues("ready", conf.DestinationNames[dest]).Add(float64(length))
+		q.zeroMsg.Store(false)
+		badgerGauge.WithLabelValues("ready", q.name).Add(float64(length))
 	}
 	for msg := range m {
 		s.count.New(msg, nbDone)
@@ -1275,7 +1238,7 @@ func tryRetrieveHelper(msgsDB, readyDB, sentDB db.Partition, badg *badger.DB, ba
 	return uids, messages, len(invalidEntries), keysNotFound, txn.Commit(nil)
 }
 
-func (s *MessageStore) retrieve(dest conf.DestinationType) ([]*model.FullMessage, error) {
+func (s *MessageStore) retrieve(q *destQueue) ([]*model.FullMessage, error) {
 	startt := time.Now()
 	defer func() {
 		retrieveTimeSummary.Observe(time.Since(startt).Seconds() * 1000)
@@ -1284,8 +1247,8 @@ func (s *MessageStore) retrieve(dest conf.DestinationType) ([]*model.FullMessage
 	// messages object is allocated from a pool, so it's the responsability of
 	// the caller to put back messages to the pool when finished
 	messagesDB := s.backend.Messages
-	readyDB := s.backend.GetPartition(Ready, dest)
-	sentDB := s.backend.GetPartition(Sent, dest)
+	readyDB := q.ready
+	sentDB := q.sent
 
 	var messages []*model.FullMessage
 	var uids []utils.MyULID
@@ -1312,10 +1275,10 @@ func (s *MessageStore) retrieve(dest conf.DestinationType) ([]*model.FullMessage
 		}
 	}
 
-	badgerGauge.WithLabelValues("ready", conf.DestinationNames[dest]).Sub(float64(nbInvalids))
-	badgerGauge.WithLabelValues("messages", conf.DestinationNames[dest]).Sub(float64(nbInvalids - nbNotFound))
-	badgerGauge.WithLabelValues("sent", conf.DestinationNames[dest]).Add(float64(len(uids)))
-	badgerGauge.WithLabelValues("ready", conf.DestinationNames[dest]).Sub(float64(len(uids)))
+	badgerGauge.WithLabelValues("ready", q.name).Sub(float64(nbInvalids))
+	badgerGauge.WithLabelValues("messages", q.name).Sub(float64(nbInvalids - nbNotFound))
+	badgerGauge.WithLabelValues("sent", q.name).Add(float64(len(uids)))
+	badgerGauge.WithLabelValues("ready", q.name).Sub(float64(len(uids)))
 
 	if uids != nil {
 		uidsPool.Put(uids)
@@ -1325,34 +1288,29 @@ func (s *MessageStore) retrieve(dest conf.DestinationType) ([]*model.FullMessage
 
 func (s *MessageStore) ACK(uid utils.MyULID, dest conf.DestinationType) {
 	countACK(dest, "ack")
-	_ = s.ackQueue.Put(uid, dest)
+	_ = s.queues[dest].acks.Put(uid, dest)
 }
 
-func doACKHelper(badg *badger.DB, bend *Backend, acks []queue.UidDest) (count map[conf.DestinationType]int, err error) {
+func doACKHelper(badg *badger.DB, q *destQueue, acks []queue.UidDest) (err error) {
 	txn := db.NewNTransaction(badg, true)
 	defer txn.Discard()
 
-	count = make(map[conf.DestinationType]int)
-
 	for _, ack := range acks {
-		err = bend.GetPartition(Sent, ack.Dest).Delete(ack.Uid, txn)
+		err = q.sent.Delete(ack.Uid, txn)
 		if err != nil {
-			return nil, eerrors.Wrap(err, "Error removing messages from the Sent DB")
+			return eerrors.Wrap(err, "Error removing messages from the Sent DB")
 		}
-		count[ack.Dest]++
 	}
-	return count, txn.Commit(nil)
-
+	return txn.Commit(nil)
 }
 
-func (s *MessageStore) doACK(acks []queue.UidDest) (err error) {
+func (s *MessageStore) doACK(q *destQueue, acks []queue.UidDest) (err error) {
 	if len(acks) == 0 {
 		return
 	}
-	var count map[conf.DestinationType]int
 
 	for {
-		count, err = doACKHelper(s.badger, s.backend, acks)
+		err = doACKHelper(s.badger, q, acks)
 		if err != badger.ErrConflict {
 			break
 		}
@@ -1361,9 +1319,7 @@ func (s *MessageStore) doACK(acks []queue.UidDest) (err error) {
 		return err
 	}
 
-	for dtype, nb := range count {
-		badgerGauge.WithLabelValues("sent", conf.DestinationNames[dtype]).Sub(float64(nb))
-	}
+	badgerGauge.WithLabelValues("sent", q.name).Sub(float64(len(acks)))
 	for _, ack := range acks {
 		s.count.Dec(ack.Uid)
 	}
@@ -1372,38 +1328,35 @@ func (s *MessageStore) doACK(acks []queue.UidDest) (err error) {
 
 func (s *MessageStore) NACK(uid utils.MyULID, dest conf.DestinationType) {
 	countACK(dest, "nack")
-	_ = s.nackQueue.Put(uid, dest)
+	_ = s.queues[dest].nacks.Put(uid, dest)
 }
 
-func doNACKHelper(badg *badger.DB, bend *Backend, nacks []queue.UidDest) (count map[conf.DestinationType]int, err error) {
+func doNACKHelper(badg *badger.DB, q *destQueue, nacks []queue.UidDest) (err error) {
 	txn := db.NewNTransaction(badg, true)
 	defer txn.Discard()
 
-	count = make(map[conf.DestinationType]int)
 	buf := string(utils.Time2Bytes(time.Now(), nil))
 
 	for _, nack := range nacks {
-		err = bend.GetPartition(Sent, nack.Dest).Delete(nack.Uid, txn)
+		err = q.sent.Delete(nack.Uid, txn)
 		if err != nil {
-			return nil, eerrors.Wrap(err, "Error removing messages from the Sent DB")
+			return eerrors.Wrap(err, "Error removing messages from the Sent DB")
 		}
-		err = bend.GetPartition(Failed, nack.Dest).Set(nack.Uid, buf, txn)
+		err = q.failed.Set(nack.Uid, buf, txn)
 		if err != nil {
-			return nil, eerrors.Wrap(err, "Error moving message to the Failed DB")
+			return eerrors.Wrap(err, "Error moving message to the Failed DB")
 		}
-		count[nack.Dest]++
 	}
-	return count, txn.Commit(nil)
+	return txn.Commit(nil)
 }
 
-func (s *MessageStore) doNACK(nacks []queue.UidDest) (err error) {
+func (s *MessageStore) doNACK(q *destQueue, nacks []queue.UidDest) (err error) {
 	if len(nacks) == 0 {
 		return
 	}
-	var count map[conf.DestinationType]int
 
 	for {
-		count, err = doNACKHelper(s.badger, s.backend, nacks)
+		err = doNACKHelper(s.badger, q, nacks)
 		if err != badger.ErrConflict {
 			break
 		}
@@ -1412,49 +1365,44 @@ func (s *MessageStore) doNACK(nacks []queue.UidDest) (err error) {
 		return err
 	}
 
-	for dtype, nb := range count {
-		badgerGauge.WithLabelValues("failed", conf.DestinationNames[dtype]).Add(float64(nb))
-		badgerGauge.WithLabelValues("sent", conf.DestinationNames[dtype]).Sub(float64(nb))
-	}
+	badgerGauge.WithLabelValues("failed", q.name).Add(float64(len(nacks)))
+	badgerGauge.WithLabelValues("sent", q.name).Sub(float64(len(nacks)))
 	return nil
 }
 
 func (s *MessageStore) PermError(uid utils.MyULID, dest conf.DestinationType) {
 	countACK(dest, "permerror")
-	_ = s.permerrorsQueue.Put(uid, dest)
+	_ = s.queues[dest].permerrs.Put(uid, dest)
 }
 
-func doPermErrorHelper(badg *badger.DB, bend *Backend, nacks []queue.UidDest) (count map[conf.DestinationType]int, err error) {
+func doPermErrorHelper(badg *badger.DB, q *destQueue, nacks []queue.UidDest) (err error) {
 	txn := db.NewNTransaction(badg, true)
 	defer txn.Discard()
 
-	count = make(map[conf.DestinationType]int)
 	timeb := make([]byte, binary.MaxVarintLen64)
 	n := binary.PutVarint(timeb, time.Now().UnixNano())
 	buf := string(timeb[:n])
 
 	for _, nack := range nacks {
-		err = bend.GetPartition(Sent, nack.Dest).Delete(nack.Uid, txn)
+		err = q.sent.Delete(nack.Uid, txn)
 		if err != nil {
-			return nil, eerrors.Wrap(err, "Error removing messages from the Sent DB")
+			return eerrors.Wrap(err, "Error removing messages from the Sent DB")
 		}
-		err = bend.GetPartition(PermErrors, nack.Dest).Set(nack.Uid, buf, txn)
+		err = q.permerrors.Set(nack.Uid, buf, txn)
 		if err != nil {
-			return nil, eerrors.Wrap(err, "Error moving message to the PermErrors DB")
+			return eerrors.Wrap(err, "Error moving message to the PermErrors DB")
 		}
-		count[nack.Dest]++
 	}
-	return count, txn.Commit(nil)
+	return txn.Commit(nil)
 }
 
-func (s *MessageStore) doPermanentError(pes []queue.UidDest) (err error) {
+func (s *MessageStore) doPermanentError(q *destQueue, pes []queue.UidDest) (err error) {
 	if len(pes) == 0 {
 		return
 	}
-	var count map[conf.DestinationType]int
 
 	for {
-		count, err = doPermErrorHelper(s.badger, s.backend, pes)
+		err = doPermErrorHelper(s.badger, q, pes)
 		if err != badger.ErrConflict {
 			break
 		}
@@ -1463,9 +1411,7 @@ func (s *MessageStore) doPermanentError(pes []queue.UidDest) (err error) {
 		return err
 	}
 
-	for dtype, nb := range count {
-		badgerGauge.WithLabelValues("permerrors", conf.DestinationNames[dtype]).Add(float64(nb))
-		badgerGauge.WithLabelValues("sent", conf.DestinationNames[dtype]).Sub(float64(nb))
-	}
+	badgerGauge.WithLabelValues("permerrors", q.name).Add(float64(len(pes)))
+	badgerGauge.WithLabelValues("sent", q.name).Sub(float64(len(pes)))
 	return nil
 }
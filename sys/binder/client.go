@@ -18,6 +18,10 @@ import (
 	"github.com/stephane-martin/skewer/utils/eerrors/erroradapters"
 )
 
+// ReusePortSuffix is appended to the network name to ask the binder for a
+// socket with SO_REUSEPORT set.
+const ReusePortSuffix = "+reuseport"
+
 func IsStream(lnet string) bool {
 	switch lnet {
 	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
@@ -484,6 +488,12 @@ func (c *clientImpl) ListenPacket(lnet string, laddr string, bytes int) (pconn n
 	return pconn, nil
 }
 
+// ListenPacketReusePort asks for a packet connection with SO_REUSEPORT set, so
+// that several sockets can be bound to the same address.
+func (c *clientImpl) ListenPacketReusePort(lnet string, laddr string, bytes int) (net.PacketConn, error) {
+	return c.ListenPacket(lnet+ReusePortSuffix, laddr, bytes)
+}
+
 func (c *clientImpl) StopListen(addr string) error {
 	if c.newConns.delete(addr) {
 		_, _ = c.writer.Write([]byte(fmt.Sprintf("stoplisten %s", addr)))
@@ -14,6 +14,7 @@ import (
 	"github.com/inconshreveable/log15"
 	"github.com/stephane-martin/skewer/utils"
 	"github.com/stephane-martin/skewer/utils/eerrors"
+	"golang.org/x/sys/unix"
 )
 
 type ExternalConn struct {
@@ -77,12 +78,75 @@ func listen(ctx context.Context, wg *sync.WaitGroup, logger log15.Logger, schan
 	return l, nil
 }
 
+// removeStaleSocket removes a unix socket file left behind by a previous
+// listener, so that we can bind again on the same path (eg. /dev/log). The
+// socket is only removed when nobody listens on it anymore.
+func removeStaleSocket(laddr string) error {
+	infos, err := os.Lstat(laddr)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if infos.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("'%s' exists and is not a unix socket", laddr)
+	}
+	conn, err := net.Dial("unixgram", laddr)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("'%s' is already used by another process", laddr)
+	}
+	if !isConnRefused(err) {
+		return err
+	}
+	return os.Remove(laddr)
+}
+
+func isConnRefused(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
+
+// reusePortConfig sets SO_REUSEPORT on the sockets before they are bound.
+var reusePortConfig = net.ListenConfig{
+	Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	},
+}
+
 func listenPacket(addr string) (conn net.PacketConn, err error) {
 	parts := strings.SplitN(addr, ":", 2)
 	lnet := parts[0]
 	laddr := parts[1]
+	reusePort := strings.HasSuffix(lnet, ReusePortSuffix)
+	lnet = strings.TrimSuffix(lnet, ReusePortSuffix)
 
-	conn, err = net.ListenPacket(lnet, laddr)
+	if lnet == "unixgram" {
+		// unlike stream listeners, datagram sockets are not unlinked on close
+		err = removeStaleSocket(laddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if reusePort {
+		conn, err = reusePortConfig.ListenPacket(context.Background(), lnet, laddr)
+	} else {
+		conn, err = net.ListenPacket(lnet, laddr)
+	}
 
 	if err != nil {
 		return nil, err
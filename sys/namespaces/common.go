@@ -21,6 +21,7 @@ type NamespacedCmd struct {
 	confPath     string
 	acctPath     string
 	fileDestTmpl string
+	deadLetter   string
 	certFiles    []string
 	certPaths    []string
 	polldirs     []string
@@ -55,6 +56,11 @@ func (c *NamespacedCmd) FileDestTemplate(tmpl string) *NamespacedCmd {
 	return c
 }
 
+func (c *NamespacedCmd) DeadLetterFile(path string) *NamespacedCmd {
+	c.deadLetter = strings.TrimSpace(path)
+	return c
+}
+
 func (c *NamespacedCmd) CertFiles(list []string) *NamespacedCmd {
 	c.certFiles = list
 	return c
@@ -106,6 +112,7 @@ type CmdOpts struct {
 	loggerHdl   uintptr
 	binderHdl   uintptr
 	messagePipe *os.File
+	input       *os.File
 	profile     bool
 }
 
@@ -127,6 +134,13 @@ func Pipe(pipe *os.File) func(*CmdOpts) {
 	}
 }
 
+// Input gives a file to the plugin, to be read as its input.
+func Input(input *os.File) func(*CmdOpts) {
+	return func(opts *CmdOpts) {
+		opts.input = input
+	}
+}
+
 func Profile(profile bool) func(*CmdOpts) {
 	return func(opts *CmdOpts) {
 		opts.profile = profile
@@ -160,6 +174,10 @@ func SetupCmd(name string, ring kring.Ring, funcopts ...func(*CmdOpts)) (cmd *Pl
 		files = append(files, opts.messagePipe)
 		envs = append(envs, "SKEWER_HAS_PIPE=TRUE")
 	}
+	if opts.input != nil {
+		files = append(files, opts.input)
+		envs = append(envs, "SKEWER_HAS_INPUT=TRUE")
+	}
 	if opts.profile {
 		envs = append(envs, "SKEWER_PROFILE=TRUE")
 	}
@@ -195,6 +213,7 @@ func SetupCmd(name string, ring kring.Ring, funcopts ...func(*CmdOpts)) (cmd *Pl
 type envPaths struct {
 	acctParentDir     string
 	fileDestParentDir string
+	deadLetterDir     string
 	storePath         string
 	confPath          string
 	certFiles         []string
@@ -224,6 +243,10 @@ func setupEnv(paths envPaths, ttyName string) (env []string) {
 		env = append(env, fmt.Sprintf("SKEWER_FILEDEST_DIR=%s", paths.fileDestParentDir))
 	}
 
+	if len(paths.deadLetterDir) > 0 {
+		env = append(env, fmt.Sprintf("SKEWER_DEADLETTER_DIR=%s", paths.deadLetterDir))
+	}
+
 	if len(paths.certFiles) > 0 {
 		env = append(env, fmt.Sprintf("SKEWER_CERT_FILES=%s", strings.Join(paths.certFiles, string(filepath.ListSeparator))))
 	}
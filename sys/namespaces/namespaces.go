@@ -79,6 +79,17 @@ func (c *NamespacedCmd) Start() (err error) {
 
 	}
 
+	if len(c.deadLetter) > 0 {
+		deadLetter, err := filepath.Abs(c.deadLetter)
+		if err != nil {
+			return err
+		}
+		paths.deadLetterDir = filepath.Dir(deadLetter)
+		if !utils.IsDir(paths.deadLetterDir) {
+			return fmt.Errorf("Dead letter directory '%s' does not exist, or is not a directory", paths.deadLetterDir)
+		}
+	}
+
 	if len(c.storePath) > 0 {
 		paths.storePath, err = filepath.Abs(c.storePath)
 		if err != nil {
@@ -555,6 +566,20 @@ func MakeChroot(targetExec string) (string, error) {
 		})
 	}
 
+	// RW bind-mount the directory of the store dead letter file if needed
+	deadLetterDir := strings.TrimSpace(os.Getenv("SKEWER_DEADLETTER_DIR"))
+	if len(deadLetterDir) > 0 {
+		bindMounts = append(bindMounts, bindMountPoint{
+			baseMountPoint: baseMountPoint{
+				Source: deadLetterDir,
+				Target: filepath.Join(root, "newroot", "tmp", "deadletter", deadLetterDir),
+			},
+			ReadOnly: false,
+			IsDir:    true,
+			Flags:    syscall.MS_NOEXEC | syscall.MS_NODEV | syscall.MS_NOSUID,
+		})
+	}
+
 	// mount SKEWER_CERT_FILES
 	certFiles := filepath.SplitList(os.Getenv("SKEWER_CERT_FILES"))
 	if len(certFiles) > 0 {
@@ -22,7 +22,11 @@ func SetupPledge(t base.Types) (err error) {
 		base.Accounting,
 		base.KafkaSource,
 		base.Filesystem,
-		base.HTTPServer:
+		base.HTTPServer,
+		base.Kubernetes,
+		base.PubSub,
+		base.JournalGateway,
+		base.Stdin:
 
 		err = unix.Pledge("stdio rpath flock dns sendfd recvfd ps inet unix getpw", nil)
 
@@ -499,12 +499,13 @@ func applyFilter(filter *seccomp.ScmpFilter) (*seccomp.ScmpFilter, error) {
 
 func SetupSeccomp(t base.Types) (err error) {
 	// MacOS source does not run under Linux
+	// Exec source needs the default filter, as it has to fork and exec
 	switch t {
 
-	case base.TCP, base.UDP, base.RELP, base.Graylog, base.Journal, base.Filesystem, base.HTTPServer, base.Accounting:
+	case base.TCP, base.UDP, base.RELP, base.Graylog, base.Journal, base.Filesystem, base.HTTPServer, base.Accounting, base.Stdin:
 		_, err = deriveComposeA(buildSimpleFilter, applyFilter)(baseAllowed, nil)
 
-	case base.DirectRELP, base.Store, base.KafkaSource, base.Configuration:
+	case base.DirectRELP, base.Store, base.KafkaSource, base.Configuration, base.Kubernetes, base.PubSub, base.JournalGateway:
 		_, err = deriveComposeB(buildSimpleFilter, socketFilter, applyFilter)(baseAllowed, nil)
 
 	default:
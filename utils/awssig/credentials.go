@@ -0,0 +1,122 @@
+package awssig
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+const imdsURL = "http://169.254.169.254/latest"
+
+// Provider returns the credentials from the configuration, from the
+// environment, or from the EC2 instance metadata service, in that order.
+type Provider struct {
+	static Credentials
+	client *http.Client
+	cached Credentials
+	sync.Mutex
+}
+
+// NewProvider builds a credentials provider. The static credentials are used
+// when the access key is not empty.
+func NewProvider(static Credentials) *Provider {
+	if len(static.AccessKeyID) == 0 {
+		static = Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	return &Provider{
+		static: static,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Get returns valid credentials.
+func (p *Provider) Get(ctx context.Context) (Credentials, error) {
+	if len(p.static.AccessKeyID) > 0 {
+		return p.static, nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	if len(p.cached.AccessKeyID) > 0 && !p.cached.Expired() {
+		return p.cached, nil
+	}
+	creds, err := p.fromInstanceMetadata(ctx)
+	if err != nil {
+		return Credentials{}, eerrors.Wrap(err, "No AWS credentials were found")
+	}
+	p.cached = creds
+	return creds, nil
+}
+
+func (p *Provider) imds(ctx context.Context, method, path, token string, headers map[string]string) (string, error) {
+	req, err := http.NewRequest(method, imdsURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(token) > 0 {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", eerrors.Errorf("Instance metadata service returned '%s'", resp.Status)
+	}
+	return string(body), nil
+}
+
+func (p *Provider) fromInstanceMetadata(ctx context.Context) (Credentials, error) {
+	// IMDSv2 session token
+	token, err := p.imds(ctx, "PUT", "/api/token", "", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "21600"})
+	if err != nil {
+		return Credentials{}, err
+	}
+	roles, err := p.imds(ctx, "GET", "/meta-data/iam/security-credentials/", token, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if len(role) == 0 {
+		return Credentials{}, eerrors.New("The instance has no IAM role")
+	}
+	doc, err := p.imds(ctx, "GET", "/meta-data/iam/security-credentials/"+role, token, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var c struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	err = json.Unmarshal([]byte(doc), &c)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.Token,
+		Expiration:      c.Expiration,
+	}, nil
+}
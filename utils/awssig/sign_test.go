@@ -0,0 +1,86 @@
+package awssig
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the test vectors come from the AWS Signature Version 4 test suite, and from
+// the IAM example of the AWS General Reference.
+var testCreds = Credentials{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+var testTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		service     string
+		want        string
+	}{
+		{
+			"get-vanilla", "GET", "https://example.amazonaws.com/", "", "", "service",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			"post-vanilla", "POST", "https://example.amazonaws.com/", "", "", "service",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			"get-vanilla-query-order-key-case", "GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "", "", "service",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			"post-x-www-form-urlencoded", "POST", "https://example.amazonaws.com/", "application/x-www-form-urlencoded", "Param1=value1", "service",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			"iam-list-users", "GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", "application/x-www-form-urlencoded; charset=utf-8", "", "iam",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.contentType) > 0 {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			Sign(req, []byte(tt.body), "us-east-1", tt.service, testCreds, testTime)
+			assert.Equal(t, tt.want, req.Header.Get("Authorization"))
+			assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+		})
+	}
+}
+
+func TestSignSessionToken(t *testing.T) {
+	req, err := http.NewRequest("POST", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := testCreds
+	creds.SessionToken = "token"
+	Sign(req, nil, "us-east-1", "service", creds, testTime)
+	assert.Equal(t, "token", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/?b=2&a=x%20y&a=1&c=%2A~", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "a=1&a=x%20y&b=2&c=%2A~", canonicalQuery(req.URL.Query()))
+}
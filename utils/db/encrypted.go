@@ -7,12 +7,11 @@ import (
 	"github.com/awnumar/memguard"
 	"github.com/dgraph-io/badger"
 	"github.com/stephane-martin/skewer/utils"
-	"github.com/stephane-martin/skewer/utils/sbox"
 )
 
 type EncryptedDB struct {
-	p      *partitionImpl
-	secret *memguard.LockedBuffer
+	p    *partitionImpl
+	keys *Keyring
 }
 
 var bufpool = &sync.Pool{
@@ -39,15 +38,18 @@ func getTmpBuf() []byte {
 	return bufpool.Get().([]byte)[:0]
 }
 
-func NewEncryptedPartition(p Partition, secret *memguard.LockedBuffer) (Partition, error) {
+// NewEncryptedPartition wraps p so that its values are encrypted with secret.
+// The previous secrets are used to read the values that were encrypted before
+// the secret was rotated.
+func NewEncryptedPartition(p Partition, secret *memguard.LockedBuffer, previous ...*memguard.LockedBuffer) (Partition, error) {
 	var impl *partitionImpl
 	var ok bool
 	if impl, ok = p.(*partitionImpl); !ok {
 		return nil, fmt.Errorf("Argument partition is not a partitionImpl")
 	}
 	return &EncryptedDB{
-		p:      impl,
-		secret: secret,
+		p:    impl,
+		keys: NewKeyring(secret, previous...),
 	}, nil
 }
 
@@ -60,7 +62,7 @@ func (encDB *EncryptedDB) KeyIterator(txn *NTransaction) *ULIDIterator {
 		PrefetchValues: false,
 		PrefetchSize:   100,
 	}
-	return &ULIDIterator{iter: txn.NewIterator(opt), secret: encDB.secret, prefix: []byte(encDB.p.prefix)}
+	return &ULIDIterator{iter: txn.NewIterator(opt), keys: encDB.keys, prefix: []byte(encDB.p.prefix)}
 }
 
 func (encDB *EncryptedDB) KeyValueIterator(txn *NTransaction) *ULIDIterator {
@@ -68,7 +70,7 @@ func (encDB *EncryptedDB) KeyValueIterator(txn *NTransaction) *ULIDIterator {
 		PrefetchValues: true,
 		PrefetchSize:   100,
 	}
-	return &ULIDIterator{iter: txn.NewIterator(opt), secret: encDB.secret, prefix: []byte(encDB.p.prefix)}
+	return &ULIDIterator{iter: txn.NewIterator(opt), keys: encDB.keys, prefix: []byte(encDB.p.prefix)}
 }
 
 func (encDB *EncryptedDB) Exists(key utils.MyULID, txn *NTransaction) (bool, error) {
@@ -96,7 +98,7 @@ func (encDB *EncryptedDB) DeleteMany(keys []utils.MyULID, txn *NTransaction) err
 }
 
 func (encDB *EncryptedDB) Set(key utils.MyULID, value string, txn *NTransaction) error {
-	encBuf, err := sbox.EncryptTo([]byte(value), encDB.secret, getTmpBuf())
+	encBuf, err := encDB.keys.EncryptTo([]byte(value), getTmpBuf())
 	if err != nil {
 		return err
 	}
@@ -107,7 +109,7 @@ func (encDB *EncryptedDB) Set(key utils.MyULID, value string, txn *NTransaction)
 }
 
 func (encDB *EncryptedDB) AddManyTrueMap(m map[utils.MyULID]string, txn *NTransaction) (err error) {
-	encValue, err := sbox.EncryptTo(trueBytes, encDB.secret, getTmpBuf())
+	encValue, err := encDB.keys.EncryptTo(trueBytes, getTmpBuf())
 	if err != nil {
 		return err
 	}
@@ -124,7 +126,7 @@ func (encDB *EncryptedDB) AddManyTrueMap(m map[utils.MyULID]string, txn *NTransa
 }
 
 func (encDB *EncryptedDB) AddManySame(uids []utils.MyULID, v string, txn *NTransaction) (err error) {
-	encValue, err := sbox.Encrypt([]byte(v), encDB.secret)
+	encValue, err := encDB.keys.EncryptTo([]byte(v), nil)
 	if err != nil {
 		return err
 	}
@@ -137,7 +139,7 @@ func (encDB *EncryptedDB) AddMany(m map[utils.MyULID]string, txn *NTransaction)
 
 	var err error
 	for uid, val := range m {
-		buf, err = sbox.EncryptTo([]byte(val), encDB.secret, buf[:0])
+		buf, err = encDB.keys.EncryptTo([]byte(val), buf)
 		if err != nil {
 			return err
 		}
@@ -154,7 +156,27 @@ func (encDB *EncryptedDB) Get(key utils.MyULID, dst []byte, txn *NTransaction) (
 	if err != nil {
 		return dst, err
 	}
-	ret, err := sbox.DecryptTo(encVal, encDB.secret, dst[:0])
+	ret, err := encDB.keys.DecryptTo(encVal, dst)
 	bufpool.Put(encVal)
 	return ret, err
 }
+
+// Reencrypt encrypts the value of key again with the current secret, when it
+// was encrypted with a previous one. It returns true if the value was
+// rewritten.
+func (encDB *EncryptedDB) Reencrypt(key utils.MyULID, txn *NTransaction) (bool, error) {
+	encVal, err := encDB.p.Get(key, getTmpBuf(), txn)
+	if err != nil {
+		return false, err
+	}
+	defer bufpool.Put(encVal)
+	if len(encVal) == 0 || encDB.keys.IsCurrent(encVal) {
+		return false, nil
+	}
+	decVal, err := encDB.keys.DecryptTo(encVal, getTmpBuf())
+	if err != nil {
+		return false, err
+	}
+	defer bufpool.Put(decVal)
+	return true, encDB.Set(key, string(decVal), txn)
+}
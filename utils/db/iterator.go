@@ -1,16 +1,14 @@
 package db
 
 import (
-	"github.com/awnumar/memguard"
 	"github.com/dgraph-io/badger"
 	"github.com/stephane-martin/skewer/utils"
-	"github.com/stephane-martin/skewer/utils/sbox"
 )
 
 type ULIDIterator struct {
 	iter   *badger.Iterator
 	prefix []byte
-	secret *memguard.LockedBuffer
+	keys   *Keyring
 }
 
 func (i *ULIDIterator) Close() {
@@ -50,7 +48,7 @@ func (i *ULIDIterator) KeyInto(uid *utils.MyULID) {
 }
 
 func (i *ULIDIterator) Value(dst []byte) ([]byte, error) {
-	if i.secret == nil {
+	if i.keys == nil {
 		return i.iter.Item().ValueCopy(dst)
 	}
 	encVal, err := i.iter.Item().ValueCopy(getTmpBuf())
@@ -61,7 +59,7 @@ func (i *ULIDIterator) Value(dst []byte) ([]byte, error) {
 		bufpool.Put(encVal)
 		return nil, nil
 	}
-	decVal, err := sbox.DecryptTo(encVal, i.secret, getTmpBuf())
+	decVal, err := i.keys.DecryptTo(encVal, getTmpBuf())
 	if err != nil {
 		bufpool.Put(encVal)
 		return nil, err
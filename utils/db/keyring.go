@@ -0,0 +1,81 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/awnumar/memguard"
+	"github.com/stephane-martin/skewer/utils/sbox"
+)
+
+// the encrypted values are prefixed with the id of their key
+const keyIDLen = 4
+
+// Keyring holds the secrets of an encrypted partition. The values are
+// encrypted with the current secret. The previous secrets are only used to
+// decrypt the values that have not been encrypted again since the secret was
+// rotated.
+type Keyring struct {
+	secrets []*memguard.LockedBuffer
+	ids     [][]byte
+}
+
+// KeyID returns the id of a secret.
+func KeyID(secret *memguard.LockedBuffer) []byte {
+	h := sha256.Sum256(secret.Buffer())
+	return h[:keyIDLen]
+}
+
+// NewKeyring returns a keyring with the current secret, and the previous
+// secrets that were used before the secret was rotated.
+func NewKeyring(current *memguard.LockedBuffer, previous ...*memguard.LockedBuffer) *Keyring {
+	k := &Keyring{}
+	for _, secret := range append([]*memguard.LockedBuffer{current}, previous...) {
+		k.secrets = append(k.secrets, secret)
+		k.ids = append(k.ids, KeyID(secret))
+	}
+	return k
+}
+
+// EncryptTo encrypts value with the current secret, and writes the result in
+// out.
+func (k *Keyring) EncryptTo(value []byte, out []byte) ([]byte, error) {
+	return sbox.EncryptTo(value, k.secrets[0], append(out[:0], k.ids[0]...))
+}
+
+// DecryptTo decrypts an encrypted value with the secret that was used to
+// encrypt it, and writes the result in out.
+func (k *Keyring) DecryptTo(encrypted []byte, out []byte) ([]byte, error) {
+	if len(encrypted) > keyIDLen {
+		for i, id := range k.ids {
+			if bytes.Equal(encrypted[:keyIDLen], id) {
+				decrypted, err := sbox.DecryptTo(encrypted[keyIDLen:], k.secrets[i], out[:0])
+				if err == nil {
+					return decrypted, nil
+				}
+			}
+		}
+	}
+	// the values written before the key ids were introduced have no prefix
+	for _, secret := range k.secrets {
+		decrypted, err := sbox.DecryptTo(encrypted, secret, out[:0])
+		if err == nil {
+			return decrypted, nil
+		}
+	}
+	return nil, fmt.Errorf("Error decrypting value: no store secret matches")
+}
+
+// IsCurrent returns true if encrypted was encrypted with the current secret.
+func (k *Keyring) IsCurrent(encrypted []byte) bool {
+	if len(encrypted) <= keyIDLen || !bytes.Equal(encrypted[:keyIDLen], k.ids[0]) {
+		return false
+	}
+	decrypted, err := sbox.DecryptTo(encrypted[keyIDLen:], k.secrets[0], getTmpBuf())
+	if err != nil {
+		return false
+	}
+	bufpool.Put(decrypted)
+	return true
+}
@@ -145,7 +145,7 @@ func (p *partitionImpl) KeyIterator(txn *NTransaction) *ULIDIterator {
 		Reverse:        false,
 		AllVersions:    false,
 	}
-	return &ULIDIterator{iter: txn.NewIterator(opt), secret: nil, prefix: []byte(p.prefix)}
+	return &ULIDIterator{iter: txn.NewIterator(opt), keys: nil, prefix: []byte(p.prefix)}
 }
 
 func (p *partitionImpl) KeyValueIterator(txn *NTransaction) *ULIDIterator {
@@ -155,7 +155,7 @@ func (p *partitionImpl) KeyValueIterator(txn *NTransaction) *ULIDIterator {
 		Reverse:        false,
 		AllVersions:    false,
 	}
-	return &ULIDIterator{iter: txn.NewIterator(opt), secret: nil, prefix: []byte(p.prefix)}
+	return &ULIDIterator{iter: txn.NewIterator(opt), keys: nil, prefix: []byte(p.prefix)}
 }
 
 func NewPartition(parent *badger.DB, prefix string) Partition {
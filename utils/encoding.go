@@ -1,9 +1,11 @@
 package utils
 
 import "strings"
+import "unicode/utf8"
 import "golang.org/x/text/encoding/unicode"
 import "golang.org/x/text/encoding/charmap"
 import "golang.org/x/text/encoding"
+import "golang.org/x/text/transform"
 
 // SelectDecoder returns a decoder from the provided coding string/
 func SelectDecoder(coding string) *encoding.Decoder {
@@ -18,8 +20,83 @@ func SelectDecoder(coding string) *encoding.Decoder {
 		enc = charmap.Windows1252
 	case "iso885915", "latin15":
 		enc = charmap.ISO8859_15
+	case "auto":
+		return &encoding.Decoder{Transformer: &autoDecoder{}}
 	default:
 		enc = unicode.UTF8
 	}
 	return enc.NewDecoder()
 }
+
+// sniffSize is the number of bytes that are used to detect the charset.
+const sniffSize = 512
+
+// autoDecoder detects the charset of each message: UTF-8 or UTF-16 when
+// there is a BOM, UTF-16 when every other byte is zero, UTF-8 when the bytes
+// are valid UTF-8, and latin-1 (as Windows-1252) otherwise.
+type autoDecoder struct {
+	decoder transform.Transformer
+}
+
+func (d *autoDecoder) Reset() {
+	d.decoder = nil
+}
+
+func (d *autoDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if d.decoder == nil {
+		if len(src) < sniffSize && !atEOF {
+			return 0, 0, transform.ErrShortSrc
+		}
+		// a BOM overrides the detected charset
+		d.decoder = unicode.BOMOverride(detectCharset(src, atEOF).NewDecoder())
+	}
+	return d.decoder.Transform(dst, src, atEOF)
+}
+
+func detectCharset(src []byte, atEOF bool) encoding.Encoding {
+	sample := src
+	if len(sample) > sniffSize {
+		sample = sample[:sniffSize]
+	}
+	// ASCII text in UTF-16 has a zero byte in every code unit
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b == 0 {
+			if i%2 == 0 {
+				evenZeros++
+			} else {
+				oddZeros++
+			}
+		}
+	}
+	units := len(sample) / 2
+	if units > 0 {
+		if oddZeros*10 >= units*3 && evenZeros*10 < units {
+			return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+		}
+		if evenZeros*10 >= units*3 && oddZeros*10 < units {
+			return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+		}
+	}
+	if !atEOF || len(src) > len(sample) {
+		// the sample may end in the middle of a rune
+		sample = trimPartialRune(sample)
+	}
+	if utf8.Valid(sample) {
+		return unicode.UTF8
+	}
+	return charmap.Windows1252
+}
+
+// trimPartialRune removes the incomplete UTF-8 sequence at the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			return b
+		}
+	}
+	return b
+}
@@ -0,0 +1,215 @@
+// Package expr implements a small expression language to select messages,
+// like `severity <= 3 && appname =~ "nginx.*"`.
+//
+// The expressions combine comparisons (==, !=, <, <=, >, >=), regular
+// expression matches (=~, !~), boolean operators (&&, ||, !) and
+// parentheses. The operands are numbers, double-quoted strings, true, false,
+// and identifiers, that are resolved when the expression is evaluated.
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Lookup returns the value of an identifier: a string, a number, a bool, or
+// nil when the identifier has no value.
+type Lookup func(name string) interface{}
+
+// Expr is a compiled expression.
+type Expr struct {
+	src    string
+	root   node
+	idents []string
+}
+
+// Compile parses an expression.
+func Compile(src string) (*Expr, error) {
+	p := &parser{lex: lexer{src: src}}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected '%s'", p.tok.text)
+	}
+	if p.lex.err != nil {
+		return nil, p.lex.err
+	}
+	return &Expr{src: src, root: root, idents: p.idents}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Idents returns the identifiers used by the expression.
+func (e *Expr) Idents() []string {
+	return e.idents
+}
+
+// Eval returns the value of the expression.
+func (e *Expr) Eval(lookup Lookup) interface{} {
+	return e.root.eval(lookup)
+}
+
+// Match returns true if the value of the expression is true, a non-empty
+// string or a non-zero number.
+func (e *Expr) Match(lookup Lookup) bool {
+	return truth(e.root.eval(lookup))
+}
+
+type node interface {
+	eval(lookup Lookup) interface{}
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n literal) eval(Lookup) interface{} {
+	return n.value
+}
+
+type ident struct {
+	name string
+}
+
+func (n ident) eval(lookup Lookup) interface{} {
+	return normalize(lookup(n.name))
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) eval(lookup Lookup) interface{} {
+	return !truth(n.operand.eval(lookup))
+}
+
+type and struct {
+	left, right node
+}
+
+func (n and) eval(lookup Lookup) interface{} {
+	return truth(n.left.eval(lookup)) && truth(n.right.eval(lookup))
+}
+
+type or struct {
+	left, right node
+}
+
+func (n or) eval(lookup Lookup) interface{} {
+	return truth(n.left.eval(lookup)) || truth(n.right.eval(lookup))
+}
+
+type matchRegexp struct {
+	operand node
+	re      *regexp.Regexp
+	negate  bool
+}
+
+func (n matchRegexp) eval(lookup Lookup) interface{} {
+	return n.re.MatchString(toString(n.operand.eval(lookup))) != n.negate
+}
+
+type compare struct {
+	op          string
+	left, right node
+}
+
+func (n compare) eval(lookup Lookup) interface{} {
+	l := n.left.eval(lookup)
+	r := n.right.eval(lookup)
+	var c int
+	lf, lok := toNumber(l)
+	rf, rok := toNumber(r)
+	switch {
+	case lok && rok:
+		switch {
+		case lf < rf:
+			c = -1
+		case lf > rf:
+			c = 1
+		}
+	default:
+		c = strings.Compare(toString(l), toString(r))
+	}
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case fmt.Stringer:
+		return n.String()
+	default:
+		return v
+	}
+}
+
+func truth(v interface{}) bool {
+	switch val := v.(type) {
+	case bool:
+		return val
+	case string:
+		return len(val) > 0
+	case float64:
+		return val != 0
+	default:
+		return false
+	}
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func toString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,238 @@
+package expr
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!"}
+
+type lexer struct {
+	src string
+	pos int
+	err error
+}
+
+func isIdentChar(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		return true
+	case c >= '0' && c <= '9', c == '.', c == ':', c == '-':
+		return !first
+	default:
+		return false
+	}
+}
+
+func (l *lexer) next() token {
+	for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}
+	case c == ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}
+	case c == '"':
+		l.pos++
+		for l.pos < len(l.src) && l.src[l.pos] != '"' {
+			if l.src[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.src) {
+			l.err = eerrors.Errorf("Syntax error at position %d: unterminated string", start+1)
+			return token{kind: tokEOF, pos: start}
+		}
+		l.pos++
+		s, err := strconv.Unquote(l.src[start:l.pos])
+		if err != nil {
+			l.err = eerrors.Errorf("Syntax error at position %d: invalid string", start+1)
+			return token{kind: tokEOF, pos: start}
+		}
+		return token{kind: tokString, text: s, pos: start}
+	case c >= '0' && c <= '9', c == '-' && l.pos+1 < len(l.src) && l.src[l.pos+1] >= '0' && l.src[l.pos+1] <= '9':
+		l.pos++
+		for l.pos < len(l.src) && (l.src[l.pos] >= '0' && l.src[l.pos] <= '9' || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}
+	case isIdentChar(c, true):
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos], false) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}
+		}
+	}
+	l.err = eerrors.Errorf("Syntax error at position %d: unexpected '%c'", start+1, c)
+	return token{kind: tokEOF, pos: start}
+}
+
+type parser struct {
+	lex    lexer
+	tok    token
+	idents []string
+}
+
+func (p *parser) next() {
+	p.tok = p.lex.next()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	if p.lex.err != nil {
+		return p.lex.err
+	}
+	return eerrors.Errorf("Syntax error at position %d: "+format, append([]interface{}{p.tok.pos + 1}, args...)...)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && p.tok.text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = or{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && p.tok.text == "&&" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = and{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.tok.kind == tokOp && p.tok.text == "!" {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokOp {
+		return left, nil
+	}
+	op := p.tok.text
+	switch op {
+	case "=~", "!~":
+		p.next()
+		if p.tok.kind != tokString {
+			return nil, p.errorf("expected a string after '%s'", op)
+		}
+		re, err := regexp.Compile(p.tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid regular expression: %s", err.Error())
+		}
+		p.next()
+		return matchRegexp{operand: left, re: re, negate: op == "!~"}, nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return compare{op: op, left: left, right: right}, nil
+	default:
+		return left, nil
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number '%s'", tok.text)
+		}
+		p.next()
+		return literal{value: f}, nil
+	case tokString:
+		p.next()
+		return literal{value: tok.text}, nil
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "true":
+			return literal{value: true}, nil
+		case "false":
+			return literal{value: false}, nil
+		}
+		p.idents = append(p.idents, tok.text)
+		return ident{name: tok.text}, nil
+	case tokLParen:
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected ')'")
+		}
+		p.next()
+		return n, nil
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	default:
+		return nil, p.errorf("unexpected '%s'", tok.text)
+	}
+}
@@ -21,6 +21,10 @@ type OFile struct {
 	syncmu     sync.Mutex
 	refs       atomic.Int32
 	logger     log15.Logger
+	size       atomic.Int64
+	start      time.Time
+	onClose    func()
+	encoder    io.Closer
 }
 
 func NewOFile(f *os.File, name string, closeAt time.Time, bufferSize int, doGzip bool, gzipLevel int, logger log15.Logger) *OFile {
@@ -28,8 +32,14 @@ func NewOFile(f *os.File, name string, closeAt time.Time, bufferSize int, doGzip
 		f:      f,
 		Name:   name,
 		logger: logger,
+		start:  time.Now(),
 	}
 	o.closeAt.Store(closeAt.UnixNano())
+	if infos, err := f.Stat(); err == nil && infos.Size() > 0 {
+		// the file already had some content
+		o.size.Store(infos.Size())
+		o.start = infos.ModTime()
+	}
 	if gzipLevel == 0 || !doGzip {
 		o.writer = concurrent.NewWriterAutoFlush(f, bufferSize, 0.75)
 		// the native go file finalizer will close the file when we do not reference it anymore
@@ -57,13 +67,45 @@ func (o *OFile) Postpone(d time.Duration) {
 	o.closeAt.Store(time.Now().Add(d).UnixNano())
 }
 
+// ClosingTime returns when the file will be closed if it is not used anymore.
+func (o *OFile) ClosingTime() time.Time {
+	return time.Unix(0, o.closeAt.Load())
+}
+
+// Size returns the number of bytes written to the file, before compression.
+func (o *OFile) Size() int64 {
+	return o.size.Load()
+}
+
+// Start returns when the content of the file began to be written.
+func (o *OFile) Start() time.Time {
+	return o.start
+}
+
+// SetEncoder attaches a stateful encoder, like a Parquet writer, to the file.
+// The encoder is closed before the file.
+func (o *OFile) SetEncoder(e io.Closer) {
+	o.encoder = e
+}
+
+func (o *OFile) Encoder() io.Closer {
+	return o.encoder
+}
+
+// OnClose registers a function that is called after the file is closed.
+func (o *OFile) OnClose(f func()) {
+	o.onClose = f
+}
+
 func (o *OFile) Expired() bool {
 	return time.Now().After(time.Unix(0, o.closeAt.Load()))
 }
 
 func (o *OFile) Write(p []byte) (int, error) {
 	// may be called concurrently
-	return o.writer.Write(p)
+	n, err := o.writer.Write(p)
+	o.size.Add(int64(n))
+	return n, err
 }
 
 func (o *OFile) Flush() (err error) {
@@ -86,11 +128,20 @@ func (o *OFile) Sync() (err error) {
 }
 
 func (o *OFile) close() {
+	if o.encoder != nil {
+		err := o.encoder.Close()
+		if err != nil {
+			o.logger.Error("Error closing file encoder. Message loss may have occurred.", "filename", o.Name, "error", err)
+		}
+	}
 	o.Flush()
 	if o.gzipwriter != nil {
 		_ = o.gzipwriter.Close()
 	}
 	_ = o.f.Close()
+	if o.onClose != nil {
+		o.onClose()
+	}
 }
 
 // ensure thread safety for the gzip writer
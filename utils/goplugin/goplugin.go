@@ -0,0 +1,93 @@
+// Package goplugin loads the decoders and the filters compiled as Go plugins.
+//
+// A decoder plugin exports a Parse function:
+//
+//	func Parse(m []byte) ([]*model.SyslogMessage, error)
+//
+// A filter plugin exports a Filter function, that returns the same codes as
+// the JS filters (0: pass, 1: dropped, 2: rejected, 3: error), and may modify
+// the message:
+//
+//	func Filter(m *model.SyslogMessage) (int, error)
+//
+// The plugins must be built with the same Go version and the same packages
+// as skewer. A loaded plugin can't be unloaded: to deploy a new version,
+// build it with another plugin path (-ldflags=-pluginpath=...), install it
+// under another file name, and reload the configuration.
+package goplugin
+
+import (
+	"path/filepath"
+	"plugin"
+	"sync"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// ParseFunc is the type of the decoders exported by the plugins.
+type ParseFunc func([]byte) ([]*model.SyslogMessage, error)
+
+// FilterFunc is the type of the filters exported by the plugins.
+type FilterFunc func(*model.SyslogMessage) (int, error)
+
+var plugins = make(map[string]*plugin.Plugin)
+var pluginsLock sync.Mutex
+
+func open(path string) (*plugin.Plugin, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	if p, ok := plugins[path]; ok {
+		return p, nil
+	}
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Failed to load Go plugin '%s'", path)
+	}
+	plugins[path] = p
+	return p, nil
+}
+
+// Parser returns the Parse function exported by the plugin.
+func Parser(path string) (ParseFunc, error) {
+	p, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Parse")
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Go plugin '%s' does not export Parse", path)
+	}
+	switch f := sym.(type) {
+	case func([]byte) ([]*model.SyslogMessage, error):
+		return f, nil
+	case *func([]byte) ([]*model.SyslogMessage, error):
+		return *f, nil
+	default:
+		return nil, eerrors.Errorf("Parse in Go plugin '%s' has the wrong type: %T", path, sym)
+	}
+}
+
+// Filter returns the Filter function exported by the plugin.
+func Filter(path string) (FilterFunc, error) {
+	p, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Filter")
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Go plugin '%s' does not export Filter", path)
+	}
+	switch f := sym.(type) {
+	case func(*model.SyslogMessage) (int, error):
+		return f, nil
+	case *func(*model.SyslogMessage) (int, error):
+		return *f, nil
+	default:
+		return nil, eerrors.Errorf("Filter in Go plugin '%s' has the wrong type: %T", path, sym)
+	}
+}
@@ -0,0 +1,310 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// Elem is a key/value pair of a BSON document.
+type Elem struct {
+	Key   string
+	Value interface{}
+}
+
+// Doc is an ordered BSON document. The supported values are nil, bool,
+// int, int32, int64, float64, string, []byte (generic binary), time.Time, Doc,
+// Raw, map[string]string, map[string]map[string]string and []interface{}.
+type Doc []Elem
+
+// Raw is a BSON document that has already been marshaled.
+type Raw []byte
+
+// Get returns the value of the first element with the given key.
+func (d Doc) Get(key string) interface{} {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+// Marshal encodes the document in BSON.
+func (d Doc) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	err := writeDoc(&buf, d)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeDoc(buf *bytes.Buffer, d Doc) error {
+	start := buf.Len()
+	buf.Write([]byte{0, 0, 0, 0})
+	for _, e := range d {
+		err := writeElem(buf, e.Key, e.Value)
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(0)
+	binary.LittleEndian.PutUint32(buf.Bytes()[start:], uint32(buf.Len()-start))
+	return nil
+}
+
+func writeCString(buf *bytes.Buffer, s string) error {
+	if bytes.IndexByte([]byte(s), 0) >= 0 {
+		return eerrors.Errorf("BSON key contains a NUL byte: '%s'", s)
+	}
+	buf.WriteString(s)
+	buf.WriteByte(0)
+	return nil
+}
+
+func writeInt32(buf *bytes.Buffer, i int32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(i))
+	buf.Write(b[:])
+}
+
+func writeInt64(buf *bytes.Buffer, i int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(i))
+	buf.Write(b[:])
+}
+
+func sortedMap(m map[string]string) Doc {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	d := make(Doc, 0, len(keys))
+	for _, k := range keys {
+		d = append(d, Elem{k, m[k]})
+	}
+	return d
+}
+
+func writeElem(buf *bytes.Buffer, key string, value interface{}) (err error) {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0x0A)
+		return writeCString(buf, key)
+	case bool:
+		buf.WriteByte(0x08)
+		err = writeCString(buf, key)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case int32:
+		buf.WriteByte(0x10)
+		err = writeCString(buf, key)
+		writeInt32(buf, v)
+	case int:
+		buf.WriteByte(0x12)
+		err = writeCString(buf, key)
+		writeInt64(buf, int64(v))
+	case int64:
+		buf.WriteByte(0x12)
+		err = writeCString(buf, key)
+		writeInt64(buf, v)
+	case float64:
+		buf.WriteByte(0x01)
+		err = writeCString(buf, key)
+		writeInt64(buf, int64(math.Float64bits(v)))
+	case string:
+		buf.WriteByte(0x02)
+		err = writeCString(buf, key)
+		writeInt32(buf, int32(len(v)+1))
+		buf.WriteString(v)
+		buf.WriteByte(0)
+	case []byte:
+		buf.WriteByte(0x05)
+		err = writeCString(buf, key)
+		writeInt32(buf, int32(len(v)))
+		buf.WriteByte(0)
+		buf.Write(v)
+	case time.Time:
+		buf.WriteByte(0x09)
+		err = writeCString(buf, key)
+		writeInt64(buf, v.UnixNano()/int64(time.Millisecond))
+	case Doc:
+		buf.WriteByte(0x03)
+		err = writeCString(buf, key)
+		if err == nil {
+			err = writeDoc(buf, v)
+		}
+	case Raw:
+		buf.WriteByte(0x03)
+		err = writeCString(buf, key)
+		buf.Write(v)
+	case map[string]string:
+		return writeElem(buf, key, sortedMap(v))
+	case map[string]map[string]string:
+		d := make(Doc, 0, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			d = append(d, Elem{k, sortedMap(v[k])})
+		}
+		return writeElem(buf, key, d)
+	case []interface{}:
+		buf.WriteByte(0x04)
+		err = writeCString(buf, key)
+		if err == nil {
+			arr := make(Doc, 0, len(v))
+			for i, item := range v {
+				arr = append(arr, Elem{fmt.Sprintf("%d", i), item})
+			}
+			err = writeDoc(buf, arr)
+		}
+	default:
+		return eerrors.Errorf("Unsupported BSON value type: %T", value)
+	}
+	return err
+}
+
+// Unmarshal decodes a BSON document. Arrays are decoded as []interface{},
+// and the values of the unsupported types are skipped.
+func Unmarshal(b []byte) (Doc, error) {
+	d, _, err := readDoc(b)
+	return d, err
+}
+
+var errShortBSON = eerrors.New("Truncated BSON document")
+
+func readDoc(b []byte) (Doc, int, error) {
+	if len(b) < 5 {
+		return nil, 0, errShortBSON
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	if size < 5 || size > len(b) {
+		return nil, 0, errShortBSON
+	}
+	body := b[4 : size-1]
+	d := Doc{}
+	for len(body) > 0 {
+		typ := body[0]
+		end := bytes.IndexByte(body[1:], 0)
+		if end < 0 {
+			return nil, 0, errShortBSON
+		}
+		key := string(body[1 : 1+end])
+		body = body[2+end:]
+		value, n, err := readValue(typ, body)
+		if err != nil {
+			return nil, 0, err
+		}
+		body = body[n:]
+		d = append(d, Elem{key, value})
+	}
+	return d, size, nil
+}
+
+func readValue(typ byte, b []byte) (interface{}, int, error) {
+	need := func(n int) error {
+		if len(b) < n {
+			return errShortBSON
+		}
+		return nil
+	}
+	switch typ {
+	case 0x01:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), 8, nil
+	case 0x02, 0x0D, 0x0E:
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		l := int(binary.LittleEndian.Uint32(b))
+		if l < 1 || len(b) < 4+l {
+			return nil, 0, errShortBSON
+		}
+		return string(b[4 : 4+l-1]), 4 + l, nil
+	case 0x03:
+		return readDoc(b)
+	case 0x04:
+		d, n, err := readDoc(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		arr := make([]interface{}, 0, len(d))
+		for _, e := range d {
+			arr = append(arr, e.Value)
+		}
+		return arr, n, nil
+	case 0x05:
+		if err := need(5); err != nil {
+			return nil, 0, err
+		}
+		l := int(binary.LittleEndian.Uint32(b))
+		if l < 0 || len(b) < 5+l {
+			return nil, 0, errShortBSON
+		}
+		return b[5 : 5+l], 5 + l, nil
+	case 0x06, 0x0A, 0xFF, 0x7F:
+		return nil, 0, nil
+	case 0x07:
+		if err := need(12); err != nil {
+			return nil, 0, err
+		}
+		return b[:12], 12, nil
+	case 0x08:
+		if err := need(1); err != nil {
+			return nil, 0, err
+		}
+		return b[0] != 0, 1, nil
+	case 0x09:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		ms := int64(binary.LittleEndian.Uint64(b))
+		return time.Unix(0, ms*int64(time.Millisecond)), 8, nil
+	case 0x10:
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		return int32(binary.LittleEndian.Uint32(b)), 4, nil
+	case 0x11, 0x12:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		return int64(binary.LittleEndian.Uint64(b)), 8, nil
+	case 0x13:
+		if err := need(16); err != nil {
+			return nil, 0, err
+		}
+		return b[:16], 16, nil
+	default:
+		return nil, 0, eerrors.Errorf("Unsupported BSON type: 0x%02x", typ)
+	}
+}
+
+// Int returns a numeric BSON value as an int64.
+func Int(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,127 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshal(t *testing.T) {
+	// the examples of the BSON specification
+	tests := []struct {
+		name string
+		doc  Doc
+		want string
+	}{
+		{
+			"hello", Doc{{"hello", "world"}},
+			"\x16\x00\x00\x00\x02hello\x00\x06\x00\x00\x00world\x00\x00",
+		},
+		{
+			"array", Doc{{"BSON", []interface{}{"awesome", 5.05, int32(1986)}}},
+			"\x31\x00\x00\x00\x04BSON\x00\x26\x00\x00\x00\x020\x00\x08\x00\x00\x00awesome\x00" +
+				"\x011\x00\x33\x33\x33\x33\x33\x33\x14\x40\x102\x00\xc2\x07\x00\x00\x00\x00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.doc.Marshal()
+			assert.NoError(t, err)
+			assert.Equal(t, []byte(tt.want), b)
+			d, err := Unmarshal(b)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.doc, d)
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Unix(1500000000, 123000000)
+	doc := Doc{
+		{"null", nil},
+		{"true", true},
+		{"false", false},
+		{"int32", int32(-3)},
+		{"int", 42},
+		{"int64", int64(1) << 40},
+		{"float", 1.5},
+		{"string", "héllo"},
+		{"binary", []byte{1, 2, 3}},
+		{"time", now},
+		{"doc", Doc{{"a", "b"}}},
+		{"map", map[string]string{"z": "1", "a": "2"}},
+		{"maps", map[string]map[string]string{"d": {"k": "v"}}},
+		{"array", []interface{}{"x", int32(1)}},
+	}
+	b, err := doc.Marshal()
+	if !assert.NoError(t, err) {
+		return
+	}
+	d, err := Unmarshal(b)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, d, len(doc))
+	assert.Nil(t, d.Get("null"))
+	assert.Equal(t, true, d.Get("true"))
+	assert.Equal(t, false, d.Get("false"))
+	assert.Equal(t, int32(-3), d.Get("int32"))
+	assert.Equal(t, int64(42), d.Get("int"))
+	assert.Equal(t, int64(1)<<40, d.Get("int64"))
+	assert.Equal(t, 1.5, d.Get("float"))
+	assert.Equal(t, "héllo", d.Get("string"))
+	assert.Equal(t, []byte{1, 2, 3}, d.Get("binary"))
+	assert.True(t, now.Equal(d.Get("time").(time.Time)))
+	assert.Equal(t, Doc{{"a", "b"}}, d.Get("doc"))
+	// the maps are sorted by key
+	assert.Equal(t, Doc{{"a", "2"}, {"z", "1"}}, d.Get("map"))
+	assert.Equal(t, Doc{{"d", Doc{{"k", "v"}}}}, d.Get("maps"))
+	assert.Equal(t, []interface{}{"x", int32(1)}, d.Get("array"))
+
+	raw, err := Doc{{"a", "b"}}.Marshal()
+	assert.NoError(t, err)
+	b, err = Doc{{"raw", Raw(raw)}}.Marshal()
+	assert.NoError(t, err)
+	d, err = Unmarshal(b)
+	assert.NoError(t, err)
+	assert.Equal(t, Doc{{"a", "b"}}, d.Get("raw"))
+}
+
+func TestMarshalErrors(t *testing.T) {
+	_, err := Doc{{"a\x00b", "c"}}.Marshal()
+	assert.Error(t, err)
+	_, err = Doc{{"a", struct{}{}}}.Marshal()
+	assert.Error(t, err)
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	b, err := Doc{{"hello", "world"}}.Marshal()
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < len(b); i++ {
+		_, err = Unmarshal(b[:i])
+		assert.Error(t, err, "truncated to %d bytes", i)
+	}
+	// the string length goes beyond the document
+	bad := append([]byte(nil), b...)
+	bad[11] = 0x7f
+	_, err = Unmarshal(bad)
+	assert.Error(t, err)
+	// unknown type
+	bad = append([]byte(nil), b...)
+	bad[4] = 0x20
+	_, err = Unmarshal(bad)
+	assert.Error(t, err)
+}
+
+func TestInt(t *testing.T) {
+	for _, v := range []interface{}{int32(1), int64(1), 1.0} {
+		n, ok := Int(v)
+		assert.True(t, ok)
+		assert.Equal(t, int64(1), n)
+	}
+	_, ok := Int("1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,245 @@
+package mongo
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+const opMsg = 2013
+
+// maximum size of a reply that we accept from the server
+const maxReplySize = 48 * 1024 * 1024
+
+// Client is a minimal MongoDB client that runs database commands over a single
+// connection, using the OP_MSG wire protocol (MongoDB >= 3.6).
+type Client struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	timeout   time.Duration
+	requestID int32
+	sync.Mutex
+}
+
+// Dial connects to a MongoDB server. When tlsConf is not nil, the connection
+// is encrypted.
+func Dial(addr string, tlsConf *tls.Config, timeout time.Duration) (*Client, error) {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if tlsConf != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConf)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, eerrors.WithTypes(eerrors.Wrapf(err, "Error connecting to MongoDB at '%s'", addr), "Connection")
+	}
+	return &Client{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Run executes a command on the given database and returns the reply. An
+// error is returned when the reply is not ok.
+func (c *Client) Run(db string, cmd Doc) (Doc, error) {
+	cmd = append(cmd, Elem{"$db", db})
+	body, err := cmd.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	reply, err := c.roundTrip(body)
+	c.Unlock()
+	if err != nil {
+		return nil, eerrors.WithTypes(err, "Connection")
+	}
+	if ok, _ := Int(reply.Get("ok")); ok != 1 {
+		// the error can be checked with eerrors.Is(codeName, err)
+		codeName, _ := reply.Get("codeName").(string)
+		errmsg, _ := reply.Get("errmsg").(string)
+		err = eerrors.Errorf("MongoDB command '%s' failed: %s", cmd[0].Key, errmsg)
+		if len(codeName) > 0 {
+			err = eerrors.WithTypes(err, codeName)
+		}
+		return reply, err
+	}
+	return reply, nil
+}
+
+func (c *Client) roundTrip(body []byte) (Doc, error) {
+	c.requestID++
+	header := make([]byte, 21)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(header)+len(body)))
+	binary.LittleEndian.PutUint32(header[4:], uint32(c.requestID))
+	binary.LittleEndian.PutUint32(header[8:], 0)
+	binary.LittleEndian.PutUint32(header[12:], opMsg)
+	// flagBits are zero, and the command is given as a kind 0 section
+	binary.LittleEndian.PutUint32(header[16:], 0)
+	header[20] = 0
+
+	if c.timeout > 0 {
+		_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer func() { _ = c.conn.SetDeadline(time.Time{}) }()
+	}
+	_, err := c.conn.Write(append(header, body...))
+	if err != nil {
+		return nil, err
+	}
+
+	var rheader [16]byte
+	_, err = io.ReadFull(c.reader, rheader[:])
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint32(rheader[0:]))
+	if size < 21 || size > maxReplySize {
+		return nil, eerrors.Errorf("Invalid MongoDB reply size: %d", size)
+	}
+	if opcode := binary.LittleEndian.Uint32(rheader[12:]); opcode != opMsg {
+		return nil, eerrors.Errorf("Unexpected MongoDB reply opcode: %d", opcode)
+	}
+	rbody := make([]byte, size-16)
+	_, err = io.ReadFull(c.reader, rbody)
+	if err != nil {
+		return nil, err
+	}
+	// skip flagBits, and expect a single kind 0 section
+	if rbody[4] != 0 {
+		return nil, eerrors.New("Unexpected MongoDB reply section kind")
+	}
+	return Unmarshal(rbody[5:])
+}
+
+// AuthSCRAMSHA256 authenticates the connection with the SCRAM-SHA-256
+// mechanism.
+func (c *Client) AuthSCRAMSHA256(source, username, password string) error {
+	nonce := make([]byte, 24)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	clientNonce := base64.StdEncoding.EncodeToString(nonce)
+	user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(username)
+	clientFirstBare := "n=" + user + ",r=" + clientNonce
+
+	reply, err := c.Run(source, Doc{
+		{"saslStart", int32(1)},
+		{"mechanism", "SCRAM-SHA-256"},
+		{"payload", []byte("n,," + clientFirstBare)},
+		{"options", Doc{{"skipEmptyExchange", true}}},
+	})
+	if err != nil {
+		return eerrors.Wrap(err, "MongoDB authentication failed")
+	}
+	serverFirst, _ := reply.Get("payload").([]byte)
+	attrs := scramAttributes(string(serverFirst))
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return eerrors.Wrap(err, "Invalid SCRAM salt")
+	}
+	iterations := 0
+	for _, ch := range attrs["i"] {
+		if ch < '0' || ch > '9' {
+			return eerrors.New("Invalid SCRAM iteration count")
+		}
+		iterations = iterations*10 + int(ch-'0')
+	}
+	if iterations < 4096 || !strings.HasPrefix(attrs["r"], clientNonce) {
+		return eerrors.New("Invalid SCRAM server challenge")
+	}
+
+	clientFinalBare := "c=biws,r=" + attrs["r"]
+	authMessage := clientFirstBare + "," + string(serverFirst) + "," + clientFinalBare
+	proof, serverSignature := scramProof(password, salt, iterations, authMessage)
+
+	conversationID := reply.Get("conversationId")
+	reply, err = c.Run(source, Doc{
+		{"saslContinue", int32(1)},
+		{"conversationId", conversationID},
+		{"payload", []byte(clientFinalBare + ",p=" + proof)},
+	})
+	if err != nil {
+		return eerrors.Wrap(err, "MongoDB authentication failed")
+	}
+	serverFinal, _ := reply.Get("payload").([]byte)
+	if scramAttributes(string(serverFinal))["v"] != serverSignature {
+		return eerrors.New("MongoDB server signature does not match")
+	}
+	for done, _ := reply.Get("done").(bool); !done; done, _ = reply.Get("done").(bool) {
+		reply, err = c.Run(source, Doc{
+			{"saslContinue", int32(1)},
+			{"conversationId", conversationID},
+			{"payload", []byte{}},
+		})
+		if err != nil {
+			return eerrors.Wrap(err, "MongoDB authentication failed")
+		}
+	}
+	return nil
+}
+
+// scramProof returns the client proof and the expected server signature, in
+// base64.
+func scramProof(password string, salt []byte, iterations int, authMessage string) (string, string) {
+	salted := pbkdf2SHA256([]byte(password), salt, iterations)
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	signature := hmacSHA256(storedKey[:], []byte(authMessage))
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	serverKey := hmacSHA256(salted, []byte("Server Key"))
+	serverSignature := hmacSHA256(serverKey, []byte(authMessage))
+	return base64.StdEncoding.EncodeToString(proof), base64.StdEncoding.EncodeToString(serverSignature)
+}
+
+func scramAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		if len(part) > 2 && part[1] == '=' {
+			attrs[part[:1]] = part[2:]
+		}
+	}
+	return attrs
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// pbkdf2SHA256 derives a single block key, which is all SCRAM-SHA-256 needs.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	result := make([]byte, len(u))
+	copy(result, u)
+	for n := 1; n < iterations; n++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for i := range result {
+			result[i] ^= u[i]
+		}
+	}
+	return result
+}
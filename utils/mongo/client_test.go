@@ -0,0 +1,152 @@
+package mongo
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer answers the OP_MSG commands of a client with handle.
+func fakeServer(t *testing.T, handle func(cmd Doc) Doc) *Client {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		for {
+			var header [21]byte
+			_, err := io.ReadFull(server, header[:])
+			if err != nil {
+				return
+			}
+			size := int(binary.LittleEndian.Uint32(header[0:]))
+			if binary.LittleEndian.Uint32(header[12:]) != opMsg || header[20] != 0 {
+				t.Error("unexpected OP_MSG header")
+				return
+			}
+			body := make([]byte, size-21)
+			_, err = io.ReadFull(server, body)
+			if err != nil {
+				return
+			}
+			cmd, err := Unmarshal(body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			reply, err := handle(cmd).Marshal()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rheader := make([]byte, 21)
+			binary.LittleEndian.PutUint32(rheader[0:], uint32(len(rheader)+len(reply)))
+			binary.LittleEndian.PutUint32(rheader[8:], binary.LittleEndian.Uint32(header[4:]))
+			binary.LittleEndian.PutUint32(rheader[12:], opMsg)
+			_, err = server.Write(append(rheader, reply...))
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return &Client{conn: client, reader: bufio.NewReader(client)}
+}
+
+func TestRun(t *testing.T) {
+	c := fakeServer(t, func(cmd Doc) Doc {
+		if cmd.Get("$db") != "logs" {
+			return Doc{{"ok", 0.0}, {"errmsg", "wrong database"}}
+		}
+		switch cmd[0].Key {
+		case "insert":
+			docs, _ := cmd.Get("documents").([]interface{})
+			return Doc{{"n", int32(len(docs))}, {"ok", 1.0}}
+		default:
+			return Doc{{"ok", 0.0}, {"errmsg", "no such command"}, {"codeName", "CommandNotFound"}}
+		}
+	})
+	defer c.Close()
+
+	reply, err := c.Run("logs", Doc{
+		{"insert", "messages"},
+		{"documents", []interface{}{Doc{{"a", "b"}}, Doc{{"c", "d"}}}},
+	})
+	if assert.NoError(t, err) {
+		n, _ := Int(reply.Get("n"))
+		assert.Equal(t, int64(2), n)
+	}
+
+	_, err = c.Run("logs", Doc{{"unknown", int32(1)}})
+	assert.Error(t, err)
+	assert.True(t, eerrors.Is("CommandNotFound", err))
+
+	_, err = c.Run("other", Doc{{"insert", "messages"}})
+	assert.Error(t, err)
+}
+
+func TestSCRAMProof(t *testing.T) {
+	// the example of RFC 7677
+	salt, _ := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	authMessage := "n=user,r=rOprNGfwEbeRWgbNEkqO," +
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096," +
+		"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+	proof, signature := scramProof("pencil", salt, 4096, authMessage)
+	assert.Equal(t, "dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", proof)
+	assert.Equal(t, "6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", signature)
+}
+
+// scramServer plays the server side of a SCRAM-SHA-256 conversation.
+func scramServer(t *testing.T, password string, tamper bool) func(cmd Doc) Doc {
+	salt := []byte("0123456789abcdef")
+	var clientFirstBare, serverFirst string
+	return func(cmd Doc) Doc {
+		payload, _ := cmd.Get("payload").([]byte)
+		switch cmd[0].Key {
+		case "saslStart":
+			assert.Equal(t, "admin", cmd.Get("$db"))
+			assert.Equal(t, "SCRAM-SHA-256", cmd.Get("mechanism"))
+			clientFirstBare = string(payload[3:])
+			attrs := scramAttributes(clientFirstBare)
+			assert.Equal(t, "us=3Der", attrs["n"])
+			serverFirst = "r=" + attrs["r"] + "server,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+			return Doc{{"conversationId", int32(1)}, {"done", false}, {"payload", []byte(serverFirst)}, {"ok", 1.0}}
+		case "saslContinue":
+			if len(payload) == 0 {
+				return Doc{{"conversationId", int32(1)}, {"done", true}, {"payload", []byte{}}, {"ok", 1.0}}
+			}
+			attrs := scramAttributes(string(payload))
+			clientFinalBare := "c=biws,r=" + attrs["r"]
+			proof, signature := scramProof(password, salt, 4096, clientFirstBare+","+serverFirst+","+clientFinalBare)
+			if attrs["p"] != proof {
+				return Doc{{"ok", 0.0}, {"errmsg", "Authentication failed."}, {"codeName", "AuthenticationFailed"}}
+			}
+			if tamper {
+				signature = base64.StdEncoding.EncodeToString([]byte("tampered"))
+			}
+			return Doc{{"conversationId", int32(1)}, {"done", false}, {"payload", []byte("v=" + signature)}, {"ok", 1.0}}
+		default:
+			return Doc{{"ok", 0.0}}
+		}
+	}
+}
+
+func TestAuthSCRAMSHA256(t *testing.T) {
+	c := fakeServer(t, scramServer(t, "secret", false))
+	assert.NoError(t, c.AuthSCRAMSHA256("admin", "us=er", "secret"))
+	c.Close()
+
+	c = fakeServer(t, scramServer(t, "secret", false))
+	err := c.AuthSCRAMSHA256("admin", "us=er", "wrong")
+	assert.Error(t, err)
+	assert.True(t, eerrors.Is("AuthenticationFailed", err))
+	c.Close()
+
+	// the client checks the signature of the server
+	c = fakeServer(t, scramServer(t, "secret", true))
+	assert.Error(t, c.AuthSCRAMSHA256("admin", "us=er", "secret"))
+	c.Close()
+}
@@ -0,0 +1,312 @@
+// Package nsq implements a minimal nsqd producer, speaking the NSQ TCP protocol.
+package nsq
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+const (
+	frameTypeResponse int32 = 0
+	frameTypeError    int32 = 1
+	frameTypeMessage  int32 = 2
+)
+
+var magicV2 = []byte("  V2")
+var heartbeat = []byte("_heartbeat_")
+
+// Result reports the outcome of an asynchronous publication.
+type Result struct {
+	Meta interface{}
+	Err  error
+}
+
+// Config holds the parameters of the connection to nsqd.
+type Config struct {
+	TLSConfig         *tls.Config
+	AuthSecret        string
+	DialTimeout       time.Duration
+	WriteTimeout      time.Duration
+	HeartbeatInterval time.Duration
+	// MaxInFlight is the maximum number of publications waiting for a response
+	MaxInFlight int
+}
+
+// Producer publishes messages to a single nsqd. Publications are asynchronous:
+// their results are delivered on the Results channel, in the same order.
+type Producer struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	config   Config
+	results  chan Result
+	pending  chan interface{}
+	inflight chan struct{}
+	done     bool
+	writeMu  sync.Mutex
+	closed   chan struct{}
+	closeErr error
+	once     sync.Once
+}
+
+// NewProducer connects to nsqd and negotiates the connection features.
+func NewProducer(addr string, config Config) (*Producer, error) {
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = 1
+	}
+	conn, err := net.DialTimeout("tcp", addr, config.DialTimeout)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Error connecting to nsqd at '%s'", addr)
+	}
+	p := &Producer{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		config:   config,
+		results:  make(chan Result, config.MaxInFlight),
+		pending:  make(chan interface{}, config.MaxInFlight),
+		inflight: make(chan struct{}, config.MaxInFlight),
+		closed:   make(chan struct{}),
+	}
+	err = p.handshake()
+	if err != nil {
+		_ = p.conn.Close()
+		return nil, err
+	}
+	go p.readLoop()
+	return p, nil
+}
+
+func (p *Producer) handshake() error {
+	if p.config.DialTimeout > 0 {
+		_ = p.conn.SetDeadline(time.Now().Add(p.config.DialTimeout))
+		defer func() { _ = p.conn.SetDeadline(time.Time{}) }()
+	}
+	_, err := p.conn.Write(magicV2)
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	identify := map[string]interface{}{
+		"client_id":           strings.Split(hostname, ".")[0],
+		"hostname":            hostname,
+		"user_agent":          "skewer",
+		"feature_negotiation": true,
+		"tls_v1":              p.config.TLSConfig != nil,
+		"heartbeat_interval":  int64(p.config.HeartbeatInterval / time.Millisecond),
+	}
+	body, err := json.Marshal(identify)
+	if err != nil {
+		return err
+	}
+	err = p.writeCommand("IDENTIFY", body)
+	if err != nil {
+		return err
+	}
+	typ, data, err := p.readFrame()
+	if err != nil {
+		return err
+	}
+	if typ == frameTypeError {
+		return eerrors.Errorf("nsqd refused IDENTIFY: %s", string(data))
+	}
+	var features struct {
+		TLSv1        bool `json:"tls_v1"`
+		AuthRequired bool `json:"auth_required"`
+	}
+	_ = json.Unmarshal(data, &features)
+
+	if p.config.TLSConfig != nil {
+		if !features.TLSv1 {
+			return eerrors.New("nsqd does not support TLS")
+		}
+		tlsConn := tls.Client(p.conn, p.config.TLSConfig)
+		err = tlsConn.Handshake()
+		if err != nil {
+			return eerrors.Wrap(err, "TLS handshake with nsqd failed")
+		}
+		p.conn = tlsConn
+		p.reader = bufio.NewReader(tlsConn)
+		typ, data, err = p.readFrame()
+		if err != nil {
+			return err
+		}
+		if typ != frameTypeResponse || !bytes.Equal(data, []byte("OK")) {
+			return eerrors.Errorf("Unexpected nsqd response after TLS upgrade: %s", string(data))
+		}
+	}
+
+	if features.AuthRequired {
+		if len(p.config.AuthSecret) == 0 {
+			return eerrors.New("nsqd requires authentication, but no secret was configured")
+		}
+		err = p.writeCommand("AUTH", []byte(p.config.AuthSecret))
+		if err != nil {
+			return err
+		}
+		typ, data, err = p.readFrame()
+		if err != nil {
+			return err
+		}
+		if typ == frameTypeError {
+			return eerrors.Errorf("nsqd authentication failed: %s", string(data))
+		}
+	}
+	return nil
+}
+
+func (p *Producer) writeCommand(name string, body []byte, params ...string) error {
+	var buf bytes.Buffer
+	buf.WriteString(name)
+	for _, param := range params {
+		buf.WriteByte(' ')
+		buf.WriteString(param)
+	}
+	buf.WriteByte('\n')
+	if body != nil {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(body)))
+		buf.Write(size[:])
+		buf.Write(body)
+	}
+	if p.config.WriteTimeout > 0 {
+		_ = p.conn.SetWriteDeadline(time.Now().Add(p.config.WriteTimeout))
+	}
+	_, err := p.conn.Write(buf.Bytes())
+	return err
+}
+
+func (p *Producer) readFrame() (int32, []byte, error) {
+	var header [8]byte
+	_, err := io.ReadFull(p.reader, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header[:4]))
+	if size < 4 {
+		return 0, nil, eerrors.Errorf("Invalid nsqd frame size: %d", size)
+	}
+	typ := int32(binary.BigEndian.Uint32(header[4:]))
+	data := make([]byte, size-4)
+	_, err = io.ReadFull(p.reader, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, data, nil
+}
+
+// Publish sends a message to the given topic. It blocks when MaxInFlight
+// publications are already waiting for a response. An error is returned only
+// when the connection was already closed: a failed write is reported on the
+// Results channel.
+func (p *Producer) Publish(topic string, body []byte, meta interface{}) error {
+	select {
+	case <-p.closed:
+		return p.closeErr
+	case p.inflight <- struct{}{}:
+	}
+	// the commands must be written in the same order as the pending queue
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if p.done {
+		return p.closeErr
+	}
+	p.pending <- meta
+	err := p.writeCommand("PUB", body, topic)
+	if err != nil {
+		p.shutdown(err)
+	}
+	return nil
+}
+
+func (p *Producer) readLoop() {
+	var err error
+	var typ int32
+	var data []byte
+	for {
+		typ, data, err = p.readFrame()
+		if err != nil {
+			break
+		}
+		if typ == frameTypeResponse && bytes.Equal(data, heartbeat) {
+			p.writeMu.Lock()
+			err = p.writeCommand("NOP", nil)
+			p.writeMu.Unlock()
+			if err != nil {
+				break
+			}
+			continue
+		}
+		var meta interface{}
+		select {
+		case meta = <-p.pending:
+			<-p.inflight
+		default:
+			err = eerrors.New("Unexpected response from nsqd")
+		}
+		if err != nil {
+			break
+		}
+		switch typ {
+		case frameTypeResponse:
+			p.results <- Result{Meta: meta}
+		case frameTypeError:
+			p.results <- Result{Meta: meta, Err: eerrors.WithTypes(eerrors.New(string(data)), errorType(data))}
+		default:
+			p.results <- Result{Meta: meta, Err: eerrors.Errorf("Unexpected nsqd frame type: %d", typ)}
+		}
+	}
+	p.shutdown(err)
+	// the publications that are still pending have failed
+	p.writeMu.Lock()
+	p.done = true
+	for {
+		select {
+		case meta := <-p.pending:
+			p.results <- Result{Meta: meta, Err: eerrors.WithTypes(p.closeErr, "Connection")}
+			continue
+		default:
+		}
+		break
+	}
+	p.writeMu.Unlock()
+	close(p.results)
+}
+
+// errorType extracts the error code, such as E_BAD_MESSAGE, from an error frame.
+func errorType(data []byte) string {
+	return strings.SplitN(string(data), " ", 2)[0]
+}
+
+func (p *Producer) shutdown(err error) {
+	p.once.Do(func() {
+		if err == nil {
+			err = io.EOF
+		}
+		p.closeErr = eerrors.Wrap(err, "Connection to nsqd was closed")
+		close(p.closed)
+		_ = p.conn.Close()
+	})
+}
+
+// Results returns the channel where the results of the publications are
+// delivered. The channel is closed when the connection is closed.
+func (p *Producer) Results() <-chan Result {
+	return p.results
+}
+
+// Close closes the connection. The pending publications are reported as
+// failed on the Results channel.
+func (p *Producer) Close() error {
+	p.shutdown(nil)
+	return nil
+}
@@ -173,21 +173,26 @@ func (q *AckQueue) GetMany(max uint32) (res []UidDest) {
 }
 
 func (q *AckQueue) GetManyInto(uids *[]UidDest) {
+	if q == nil {
+		return
+	}
+	*uids = (*uids)[:0]
+	q.AppendMany(uids)
+}
+
+// AppendMany appends the queued elements to uids, up to the capacity of uids.
+func (q *AckQueue) AppendMany(uids *[]UidDest) {
 	if q == nil {
 		return
 	}
 	var uid utils.MyULID
 	var dest conf.DestinationType
 	var err error
-	max := cap(*uids)
-	*uids = (*uids)[:0]
-	var i int
-	for i < max {
+	for len(*uids) < cap(*uids) {
 		uid, dest, err = q.Get()
 		if uid == utils.ZeroULID || err != nil {
 			break
 		}
 		*uids = append(*uids, UidDest{Uid: uid, Dest: dest})
-		i++
 	}
 }
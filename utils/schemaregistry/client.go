@@ -0,0 +1,112 @@
+// Package schemaregistry is a minimal client for the Confluent Schema Registry.
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// MagicByte starts the messages in the Confluent wire format.
+const MagicByte byte = 0
+
+// Client registers schemas in the registry and caches their IDs.
+type Client struct {
+	url      string
+	username string
+	password string
+	http     *http.Client
+	ids      map[string]int32
+	mu       sync.Mutex
+}
+
+// NewClient builds a client for the schema registry at baseURL.
+func NewClient(baseURL, username, password string, clt *http.Client) *Client {
+	return &Client{
+		url:      strings.TrimRight(baseURL, "/"),
+		username: username,
+		password: password,
+		http:     clt,
+		ids:      make(map[string]int32),
+	}
+}
+
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// Register registers the schema under the subject and returns the schema ID.
+// The registry returns the existing ID when the schema is already registered,
+// and refuses a new version that is not compatible with the previous ones:
+// such errors have the "Incompatible" type.
+func (c *Client) Register(subject, schema string) (int32, error) {
+	key := subject + "\x00" + schema
+	c.mu.Lock()
+	id, ok := c.ids[key]
+	c.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST", c.url+"/subjects/"+url.PathEscape(subject)+"/versions", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	if len(c.username) > 0 {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, eerrors.Wrap(err, "Error contacting the schema registry")
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var regErr registryError
+		_ = json.Unmarshal(respBody, &regErr)
+		err = eerrors.Errorf("Schema registry returned '%s' for subject '%s': %s", resp.Status, subject, regErr.Message)
+		if resp.StatusCode == http.StatusConflict {
+			return 0, eerrors.WithTypes(err, "Incompatible")
+		}
+		return 0, err
+	}
+	var result struct {
+		ID int32 `json:"id"`
+	}
+	err = json.Unmarshal(respBody, &result)
+	if err != nil {
+		return 0, eerrors.Wrap(err, "Error decoding the schema registry response")
+	}
+	c.mu.Lock()
+	c.ids[key] = result.ID
+	c.mu.Unlock()
+	return result.ID, nil
+}
+
+// Header returns the Confluent wire format prefix for the schema ID.
+func Header(id int32) []byte {
+	h := make([]byte, 5)
+	h[0] = MagicByte
+	binary.BigEndian.PutUint32(h[1:], uint32(id))
+	return h
+}
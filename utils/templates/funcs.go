@@ -0,0 +1,167 @@
+// Package templates provides the functions available in the templates of
+// the configuration: topics, partition keys, filter keys and formats.
+package templates
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/stephane-martin/skewer/utils/eerrors"
+)
+
+// Funcs are the helpers available in the user templates.
+var Funcs = template.FuncMap{
+	"time":    formatTime,
+	"rfc3339": func(t interface{}) (string, error) { return formatTime(t, time.RFC3339Nano) },
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"default": func(def string, v interface{}) string {
+		if v == nil {
+			return def
+		}
+		s := fmt.Sprint(v)
+		if len(s) == 0 {
+			return def
+		}
+		return s
+	},
+	"truncate": func(n int, s string) string {
+		if len(s) > n {
+			return s[:n]
+		}
+		return s
+	},
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"replace":  strings.Replace,
+	"regex":    regex,
+	"match":    match,
+	"jsonpath": jsonPath,
+	"sha1": func(s string) string {
+		h := sha1.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	},
+	"md5": func(s string) string {
+		h := md5.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	},
+	"fnv": func(s string) uint32 {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(s))
+		return h.Sum32()
+	},
+}
+
+// New returns a template that knows the Funcs.
+func New(name string) *template.Template {
+	return template.New(name).Funcs(Funcs)
+}
+
+// formatTime formats a time, or a timestamp in nanoseconds.
+func formatTime(t interface{}, layout string) (string, error) {
+	switch v := t.(type) {
+	case time.Time:
+		return v.UTC().Format(layout), nil
+	case int64:
+		return time.Unix(0, v).UTC().Format(layout), nil
+	case int:
+		return time.Unix(0, int64(v)).UTC().Format(layout), nil
+	default:
+		return "", eerrors.Errorf("Can't format time from %T", t)
+	}
+}
+
+var regexps sync.Map
+
+func compile(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(expr, re)
+	return re, nil
+}
+
+// regex returns the first group matched by the expression in s, or the whole
+// match if the expression has no group.
+func regex(expr, s string) (string, error) {
+	re, err := compile(expr)
+	if err != nil {
+		return "", err
+	}
+	groups := re.FindStringSubmatch(s)
+	switch len(groups) {
+	case 0:
+		return "", nil
+	case 1:
+		return groups[0], nil
+	default:
+		return groups[1], nil
+	}
+}
+
+func match(expr, s string) (bool, error) {
+	re, err := compile(expr)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+// jsonPath returns the value at path in the JSON document s, like
+// "user.roles.0" or "$.user.roles[0]". The value is empty when the path does
+// not exist, and objects and arrays are returned as JSON.
+func jsonPath(path, s string) (string, error) {
+	var v interface{}
+	err := json.Unmarshal([]byte(s), &v)
+	if err != nil {
+		return "", nil
+	}
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.Replace(strings.Replace(path, "[", ".", -1), "]", "", -1)
+	if len(path) > 0 {
+		for _, elt := range strings.Split(path, ".") {
+			switch cur := v.(type) {
+			case map[string]interface{}:
+				v = cur[elt]
+			case []interface{}:
+				idx, err := strconv.Atoi(elt)
+				if err != nil || idx < 0 || idx >= len(cur) {
+					return "", nil
+				}
+				v = cur[idx]
+			default:
+				return "", nil
+			}
+		}
+	}
+	switch cur := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return cur, nil
+	case float64:
+		return strconv.FormatFloat(cur, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(cur), nil
+	default:
+		b, err := json.Marshal(cur)
+		return string(b), err
+	}
+}
@@ -67,6 +67,17 @@ func (uid MyULID) String() string {
 	return tmp.String()
 }
 
+// Time returns the time when the ULID was generated.
+func (uid MyULID) Time() time.Time {
+	if len(uid) < 16 {
+		return time.Time{}
+	}
+	var tmp ulid.ULID
+	copy(tmp[:], uid[:16])
+	ms := tmp.Time()
+	return time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond))
+}
+
 // MarshalJSON marshals the ULID to JSON.
 func (uid MyULID) MarshalJSON() ([]byte, error) {
 	return json.Marshal(uid.String())
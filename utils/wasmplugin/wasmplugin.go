@@ -0,0 +1,215 @@
+// Package wasmplugin runs the decoders and the filters compiled as WASM
+// modules.
+//
+// The modules are sandboxed: they have no access to the filesystem or to the
+// network. They may import WASI, so that the usual toolchains (TinyGo, Rust
+// wasm32-wasi...) can be used. A module exports:
+//
+//	memory
+//	alloc(size i32) i32          allocates the input buffers
+//	dealloc(ptr i32, size i32)   optional, frees the input and output buffers
+//	decode(ptr i32, len i32) i64 for the decoders
+//	filter(ptr i32, len i32) i64 for the filters
+//
+// decode and filter receive their input in a buffer allocated with alloc, and
+// return the position of their output in memory, as (ptr << 32) | len. Both
+// the input and the output are JSON (see the decoders and the filters for the
+// contents).
+package wasmplugin
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/utils/eerrors"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// memoryLimitPages bounds the memory of the modules (64KiB pages).
+const memoryLimitPages = 1024
+
+// callTimeout is the delay given to a module function to return.
+const callTimeout = 10 * time.Second
+
+var cache = wazero.NewCompilationCache()
+
+var modules = make(map[string]*Module)
+var modulesLock sync.Mutex
+
+// Module is an instantiated WASM module. The calls are serialized.
+type Module struct {
+	mu       sync.Mutex
+	path     string
+	modTime  time.Time
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	instance api.Module
+}
+
+// Open loads the WASM module at path. The modules are shared: they are loaded
+// again only when the file has been modified.
+func Open(path string) (*Module, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := os.Stat(path)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Failed to load WASM module '%s'", path)
+	}
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+	if m, ok := modules[path]; ok && m.modTime.Equal(infos.ModTime()) {
+		return m, nil
+	}
+	binary, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Failed to load WASM module '%s'", path)
+	}
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(memoryLimitPages).
+		WithCompilationCache(cache)
+	r := wazero.NewRuntimeWithConfig(ctx, config)
+	_, err = wasi_snapshot_preview1.Instantiate(ctx, r)
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, eerrors.Wrap(err, "Failed to instantiate WASI")
+	}
+	compiled, err := r.CompileModule(ctx, binary)
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, eerrors.Wrapf(err, "Failed to compile WASM module '%s'", path)
+	}
+	m := &Module{path: path, modTime: infos.ModTime(), runtime: r, compiled: compiled}
+	err = m.instantiate()
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, err
+	}
+	if m.instance.ExportedFunction("alloc") == nil {
+		_ = r.Close(ctx)
+		return nil, eerrors.Errorf("WASM module '%s' does not export alloc", path)
+	}
+	// the previous version of the module is not closed, as it may still be
+	// used by the previous configuration
+	modules[path] = m
+	return m, nil
+}
+
+func (m *Module) instantiate() error {
+	// the reactor modules are initialized by _initialize, the command
+	// modules would exit after _start
+	config := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	instance, err := m.runtime.InstantiateModule(context.Background(), m.compiled, config)
+	if err != nil {
+		return eerrors.Wrapf(err, "Failed to instantiate WASM module '%s'", m.path)
+	}
+	m.instance = instance
+	return nil
+}
+
+// Exports returns true if the module exports the function.
+func (m *Module) Exports(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.instance.ExportedFunction(name) != nil
+}
+
+// Call gives the input to the exported function, and returns its output.
+func (m *Module) Call(name string, input []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.instance.IsClosed() {
+		// a previous call timed out or exited
+		err := m.instantiate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	f := m.instance.ExportedFunction(name)
+	if f == nil {
+		return nil, eerrors.Errorf("WASM module '%s' does not export %s", m.path, name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	res, err := m.instance.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Error calling alloc in WASM module '%s'", m.path)
+	}
+	inPtr := uint32(res[0])
+	if !m.instance.Memory().Write(inPtr, input) {
+		return nil, eerrors.Errorf("WASM module '%s' allocated an invalid buffer", m.path)
+	}
+	res, err = f.Call(ctx, uint64(inPtr), uint64(len(input)))
+	if err != nil {
+		return nil, eerrors.Wrapf(err, "Error calling %s in WASM module '%s'", name, m.path)
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	out, ok := m.instance.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, eerrors.Errorf("%s in WASM module '%s' returned an invalid buffer", name, m.path)
+	}
+	// the memory belongs to the module, so the output is copied
+	output := make([]byte, len(out))
+	copy(output, out)
+	if dealloc := m.instance.ExportedFunction("dealloc"); dealloc != nil {
+		_, _ = dealloc.Call(ctx, uint64(inPtr), uint64(len(input)))
+		_, _ = dealloc.Call(ctx, uint64(outPtr), uint64(outLen))
+	}
+	return output, nil
+}
+
+type filterResponse struct {
+	Result  int                  `json:"result"`
+	Message *model.RegularSyslog `json:"message,omitempty"`
+}
+
+// Filter returns a filter function backed by the filter function of the
+// module. The filter function receives the message as JSON, and returns
+// {"result": code, "message": {...}}, where code is the same as for the JS
+// filters, and the message, if present, replaces the original message.
+func Filter(path string) (func(*model.SyslogMessage) (int, error), error) {
+	m, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Exports("filter") {
+		return nil, eerrors.Errorf("WASM module '%s' does not export filter", path)
+	}
+	return func(msg *model.SyslogMessage) (int, error) {
+		input, err := json.Marshal(msg.Regular())
+		if err != nil {
+			return 0, err
+		}
+		output, err := m.Call("filter", input)
+		if err != nil {
+			return 0, err
+		}
+		var resp filterResponse
+		err = json.Unmarshal(output, &resp)
+		if err != nil {
+			return 0, eerrors.Wrap(err, "Invalid response from the WASM filter")
+		}
+		if resp.Message != nil {
+			filtered := resp.Message.Internal()
+			filtered.TimeGeneratedNum = msg.TimeGeneratedNum
+			if resp.Message.TimeReported.IsZero() {
+				filtered.TimeReportedNum = msg.TimeReportedNum
+			}
+			*msg = *filtered
+			model.Free(filtered)
+		}
+		return resp.Result, nil
+	}, nil
+}